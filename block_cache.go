@@ -0,0 +1,218 @@
+package tsdb
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BlockCacheKey identifies a decoded block in a BlockCache.
+type BlockCacheKey struct {
+	// File identifies the open file the block was read from.
+	File uint64
+
+	// Generation changes each time the file is tombstoned, so blocks decoded
+	// before are not returned anymore.
+	Generation uint64
+
+	// Offset is the offset of the block in the file.
+	Offset int64
+}
+
+// BlockCache is a size-bounded LRU cache of decoded TSM blocks shared by the
+// engines of all shards in a store.  The cached blocks must not be modified.
+// The methods of a nil BlockCache are no-ops.
+type BlockCache struct {
+	mu      sync.Mutex
+	maxSize uint64
+	size    uint64
+	lru     *list.List // of *blockCacheEntry, most recently used first
+	blocks  map[BlockCacheKey]*list.Element
+	files   map[uint64]*list.List // of the lru elements of the cached blocks, by file
+
+	// Accessed atomically.
+	hits, misses, evictions uint64
+}
+
+type blockCacheEntry struct {
+	key   BlockCacheKey
+	block interface{}
+	size  uint64
+
+	// fileElem is the element of the entry in the list of blocks of its file.
+	fileElem *list.Element
+}
+
+// NewBlockCache returns a cache holding at most maxSize bytes of decoded blocks.
+func NewBlockCache(maxSize uint64) *BlockCache {
+	return &BlockCache{
+		maxSize: maxSize,
+		lru:     list.New(),
+		blocks:  make(map[BlockCacheKey]*list.Element),
+		files:   make(map[uint64]*list.List),
+	}
+}
+
+// Get returns the block cached for key.
+func (c *BlockCache) Get(key BlockCacheKey) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	e, ok := c.blocks[key]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		globalBlockCacheMetrics.misses.Inc()
+		return nil, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	globalBlockCacheMetrics.hits.Inc()
+	return e.Value.(*blockCacheEntry).block, true
+}
+
+// Add caches block for key.  size is the number of bytes used by the block.
+// Least recently used blocks are evicted to make room for it.
+func (c *BlockCache) Add(key BlockCacheKey, block interface{}, size uint64) {
+	if c == nil || size > c.maxSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.blocks[key]; ok {
+		return
+	}
+	for c.size+size > c.maxSize {
+		c.remove(c.lru.Back())
+		atomic.AddUint64(&c.evictions, 1)
+		globalBlockCacheMetrics.evictions.Inc()
+	}
+
+	entry := &blockCacheEntry{key: key, block: block, size: size}
+	e := c.lru.PushFront(entry)
+	c.blocks[key] = e
+
+	blocks := c.files[key.File]
+	if blocks == nil {
+		blocks = list.New()
+		c.files[key.File] = blocks
+	}
+	entry.fileElem = blocks.PushBack(e)
+	c.size += size
+	globalBlockCacheMetrics.size.Add(float64(size))
+}
+
+// RemoveFile removes the blocks of file from the cache.
+func (c *BlockCache) RemoveFile(file uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	blocks := c.files[file]
+	if blocks == nil {
+		return
+	}
+	for blocks.Len() > 0 {
+		c.remove(blocks.Front().Value.(*list.Element))
+	}
+}
+
+// remove removes the block of e.  c.mu must be held.
+func (c *BlockCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*blockCacheEntry)
+	delete(c.blocks, entry.key)
+	blocks := c.files[entry.key.File]
+	if blocks.Remove(entry.fileElem); blocks.Len() == 0 {
+		delete(c.files, entry.key.File)
+	}
+	c.size -= entry.size
+	globalBlockCacheMetrics.size.Sub(float64(entry.size))
+}
+
+// BlockCacheStatistics are the statistics of a BlockCache.
+type BlockCacheStatistics struct {
+	Hits, Misses, Evictions uint64
+	Blocks                  int
+	Size                    uint64
+}
+
+// Statistics returns the statistics of the cache.
+func (c *BlockCache) Statistics() BlockCacheStatistics {
+	if c == nil {
+		return BlockCacheStatistics{}
+	}
+
+	c.mu.Lock()
+	blocks, size := len(c.blocks), c.size
+	c.mu.Unlock()
+
+	return BlockCacheStatistics{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+		Blocks:    blocks,
+		Size:      size,
+	}
+}
+
+var globalBlockCacheMetrics = newBlockCacheMetrics()
+
+const blockCacheSubsystem = "block_cache"
+
+type blockCacheMetrics struct {
+	hits      prometheus.Counter
+	misses    prometheus.Counter
+	evictions prometheus.Counter
+	size      prometheus.Gauge
+}
+
+func newBlockCacheMetrics() *blockCacheMetrics {
+	return &blockCacheMetrics{
+		hits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: storageNamespace,
+			Subsystem: blockCacheSubsystem,
+			Name:      "hits_total",
+			Help:      "Counter of decoded TSM blocks found in the block cache",
+		}),
+		misses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: storageNamespace,
+			Subsystem: blockCacheSubsystem,
+			Name:      "misses_total",
+			Help:      "Counter of decoded TSM blocks not found in the block cache",
+		}),
+		evictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: storageNamespace,
+			Subsystem: blockCacheSubsystem,
+			Name:      "evictions_total",
+			Help:      "Counter of decoded TSM blocks evicted from the block cache",
+		}),
+		size: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: storageNamespace,
+			Subsystem: blockCacheSubsystem,
+			Name:      "inuse_bytes",
+			Help:      "Gauge of the memory used by the block cache",
+		}),
+	}
+}
+
+// BlockCacheCollectors returns the prometheus metrics of the block cache.
+func BlockCacheCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		globalBlockCacheMetrics.hits,
+		globalBlockCacheMetrics.misses,
+		globalBlockCacheMetrics.evictions,
+		globalBlockCacheMetrics.size,
+	}
+}
@@ -0,0 +1,57 @@
+package tsdb_test
+
+import (
+	"testing"
+
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockCache_Evict(t *testing.T) {
+	c := tsdb.NewBlockCache(100)
+	a := tsdb.BlockCacheKey{File: 1, Offset: 5}
+	b := tsdb.BlockCacheKey{File: 1, Offset: 50}
+	d := tsdb.BlockCacheKey{File: 2, Offset: 5}
+
+	c.Add(a, "a", 40)
+	c.Add(b, "b", 40)
+
+	// Reading a makes b the least recently used block, which is evicted.
+	v, ok := c.Get(a)
+	require.True(t, ok)
+	require.Equal(t, "a", v)
+	c.Add(d, "d", 40)
+
+	_, ok = c.Get(b)
+	require.False(t, ok)
+	_, ok = c.Get(d)
+	require.True(t, ok)
+
+	// Blocks larger than the cache are not cached.
+	c.Add(tsdb.BlockCacheKey{File: 3}, "e", 101)
+
+	require.Equal(t, tsdb.BlockCacheStatistics{Hits: 2, Misses: 1, Evictions: 1, Blocks: 2, Size: 80}, c.Statistics())
+}
+
+func TestBlockCache_RemoveFile(t *testing.T) {
+	c := tsdb.NewBlockCache(100)
+	c.Add(tsdb.BlockCacheKey{File: 1, Offset: 5}, "a", 10)
+	c.Add(tsdb.BlockCacheKey{File: 1, Generation: 1, Offset: 5}, "b", 10)
+	c.Add(tsdb.BlockCacheKey{File: 2, Offset: 5}, "c", 10)
+
+	c.RemoveFile(1)
+
+	_, ok := c.Get(tsdb.BlockCacheKey{File: 1, Generation: 1, Offset: 5})
+	require.False(t, ok)
+	_, ok = c.Get(tsdb.BlockCacheKey{File: 2, Offset: 5})
+	require.True(t, ok)
+	require.Equal(t, 1, c.Statistics().Blocks)
+	require.Equal(t, uint64(10), c.Statistics().Size)
+
+	// Blocks of the file cached again are tracked and removed again.
+	c.Add(tsdb.BlockCacheKey{File: 1, Generation: 2, Offset: 5}, "d", 10)
+	c.RemoveFile(2)
+	c.RemoveFile(1)
+	c.RemoveFile(1)
+	require.Equal(t, tsdb.BlockCacheStatistics{Hits: 1, Misses: 1}, c.Statistics())
+}
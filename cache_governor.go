@@ -0,0 +1,166 @@
+package tsdb
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// cacheGovernorReliefInterval is the minimum time between two rounds of
+	// forced snapshots.
+	cacheGovernorReliefInterval = time.Second
+
+	// cacheGovernorColdAge is the time since the last write after which a cache
+	// is snapshotted before larger caches that are still written to.
+	cacheGovernorColdAge = time.Minute
+)
+
+// GovernedCache is a shard cache whose memory use is limited by a
+// CacheMemoryGovernor.
+type GovernedCache interface {
+	// Size returns the number of bytes used by the cache.
+	Size() uint64
+
+	// LastWriteTime returns the time of the last write to the cache.
+	LastWriteTime() time.Time
+
+	// RequestSnapshot asks for the cache to be written to disk.  It must not
+	// block.
+	RequestSnapshot()
+}
+
+// CacheMemoryGovernor limits the memory used by the caches of all shards in a
+// store.  Caches charge their memory use against the governor.  Once the memory
+// used crosses a high water mark, snapshots of the coldest and largest caches
+// are requested until enough memory is being released to fall back to a low
+// water mark.  Writes are only rejected once the limit itself is reached.
+type CacheMemoryGovernor struct {
+	used        uint64 // accessed atomically
+	lastRelief  int64  // accessed atomically
+	limit       uint64
+	highWater   uint64
+	lowWater    uint64
+	now         func() time.Time
+	mu          sync.Mutex
+	caches      map[GovernedCache]struct{}
+	reliefCount uint64 // accessed atomically
+}
+
+// NewCacheMemoryGovernor returns a governor limiting the memory used by all
+// caches to limit bytes.  Snapshots are forced once 80% of the limit is used.
+func NewCacheMemoryGovernor(limit uint64) *CacheMemoryGovernor {
+	return &CacheMemoryGovernor{
+		limit:     limit,
+		highWater: limit - limit/5,
+		lowWater:  limit / 2,
+		now:       time.Now,
+		caches:    make(map[GovernedCache]struct{}),
+	}
+}
+
+// Limit returns the number of bytes all caches may use.
+func (g *CacheMemoryGovernor) Limit() uint64 {
+	return g.limit
+}
+
+// Used returns the number of bytes charged by all caches.
+func (g *CacheMemoryGovernor) Used() uint64 {
+	return atomic.LoadUint64(&g.used)
+}
+
+// Register adds c to the caches that may be asked to snapshot.
+func (g *CacheMemoryGovernor) Register(c GovernedCache) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.caches[c] = struct{}{}
+}
+
+// Deregister removes c from the caches that may be asked to snapshot.
+func (g *CacheMemoryGovernor) Deregister(c GovernedCache) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.caches, c)
+}
+
+// Charge records n more bytes in use.
+func (g *CacheMemoryGovernor) Charge(n uint64) {
+	atomic.AddUint64(&g.used, n)
+}
+
+// Release records n fewer bytes in use.
+func (g *CacheMemoryGovernor) Release(n uint64) {
+	// Per sync/atomic docs, bit-flip n minus one to perform subtraction within AddUint64.
+	atomic.AddUint64(&g.used, ^(n - 1))
+}
+
+// Admit returns true if n more bytes may be written to a cache.  It requests
+// snapshots if the write would take the memory used over the high water mark.
+func (g *CacheMemoryGovernor) Admit(n uint64) bool {
+	used := g.Used() + n
+	if used > g.highWater {
+		g.relieve(used)
+	}
+	return used <= g.limit
+}
+
+// ReliefCount returns the number of rounds of forced snapshots.
+func (g *CacheMemoryGovernor) ReliefCount() uint64 {
+	return atomic.LoadUint64(&g.reliefCount)
+}
+
+// relieve requests snapshots of enough caches to bring the memory used from
+// used back down to the low water mark.  Caches that have not been written to
+// recently go first, then the largest caches.
+func (g *CacheMemoryGovernor) relieve(used uint64) {
+	now := g.now()
+	last := atomic.LoadInt64(&g.lastRelief)
+	if now.UnixNano()-last < int64(cacheGovernorReliefInterval) ||
+		!atomic.CompareAndSwapInt64(&g.lastRelief, last, now.UnixNano()) {
+		return
+	}
+	atomic.AddUint64(&g.reliefCount, 1)
+
+	type candidate struct {
+		cache GovernedCache
+		size  uint64
+		cold  bool
+	}
+
+	// Caches are queried without holding the lock, since they hold their own
+	// lock while registering.
+	g.mu.Lock()
+	caches := make([]GovernedCache, 0, len(g.caches))
+	for c := range g.caches {
+		caches = append(caches, c)
+	}
+	g.mu.Unlock()
+
+	candidates := make([]candidate, 0, len(caches))
+	for _, c := range caches {
+		if size := c.Size(); size > 0 {
+			candidates = append(candidates, candidate{
+				cache: c,
+				size:  size,
+				cold:  now.Sub(c.LastWriteTime()) > cacheGovernorColdAge,
+			})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].cold != candidates[j].cold {
+			return candidates[i].cold
+		}
+		return candidates[i].size > candidates[j].size
+	})
+
+	excess := used - g.lowWater
+	for _, c := range candidates {
+		c.cache.RequestSnapshot()
+		if c.size >= excess {
+			return
+		}
+		excess -= c.size
+	}
+}
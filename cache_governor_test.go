@@ -0,0 +1,62 @@
+package tsdb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/stretchr/testify/require"
+)
+
+// governedCache is a GovernedCache with a fixed size.
+type governedCache struct {
+	size          uint64
+	lastWriteTime time.Time
+	requested     bool
+}
+
+func (c *governedCache) Size() uint64             { return c.size }
+func (c *governedCache) LastWriteTime() time.Time { return c.lastWriteTime }
+func (c *governedCache) RequestSnapshot()         { c.requested = true }
+
+func TestCacheMemoryGovernor_Admit(t *testing.T) {
+	g := tsdb.NewCacheMemoryGovernor(1000)
+
+	now := time.Now()
+	cold := &governedCache{size: 100, lastWriteTime: now.Add(-time.Hour)}
+	large := &governedCache{size: 400, lastWriteTime: now}
+	medium := &governedCache{size: 250, lastWriteTime: now}
+	small := &governedCache{size: 50, lastWriteTime: now}
+	for _, c := range []*governedCache{cold, large, medium, small} {
+		g.Register(c)
+		g.Charge(c.size)
+	}
+	require.Equal(t, uint64(800), g.Used())
+
+	// Below the high water mark no snapshots are requested.
+	require.True(t, g.Admit(0))
+	require.Equal(t, uint64(0), g.ReliefCount())
+
+	// Above it, the cold cache and then the largest caches are asked to
+	// snapshot until the low water mark can be reached.
+	require.True(t, g.Admit(100))
+	require.Equal(t, uint64(1), g.ReliefCount())
+	require.True(t, cold.requested)
+	require.True(t, large.requested)
+	require.False(t, medium.requested)
+	require.False(t, small.requested)
+
+	// Writes are rejected past the limit and snapshots are not requested again
+	// until the relief interval has passed.
+	require.False(t, g.Admit(300))
+	require.Equal(t, uint64(1), g.ReliefCount())
+
+	// Released memory can be used again.
+	g.Release(cold.size + large.size)
+	require.Equal(t, uint64(300), g.Used())
+	require.True(t, g.Admit(300))
+
+	g.Deregister(medium)
+	g.Release(medium.size)
+	require.Equal(t, uint64(50), g.Used())
+}
@@ -0,0 +1,73 @@
+package tsdb
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultBlockCodec is the default codec used to compress engine blocks.
+const DefaultBlockCodec = "snappy"
+
+// BlockCodec compresses and decompresses opaque blocks of bytes written to disk
+// by an engine, such as string blocks in TSM files and WAL entries.
+type BlockCodec interface {
+	// ID returns the identifier recorded alongside data compressed by the codec.
+	// It must be unique amongst registered codecs and in the range [1, 15].
+	ID() byte
+
+	// Name returns the name used to select the codec in configuration.
+	Name() string
+
+	// Encode returns the compressed form of src. The capacity of dst is used
+	// to hold the result if it is large enough.
+	Encode(dst, src []byte) ([]byte, error)
+
+	// Decode returns the decompressed form of src. The capacity of dst is used
+	// to hold the result if it is large enough. The result never shares memory
+	// with src.
+	Decode(dst, src []byte) ([]byte, error)
+}
+
+// blockCodecs is a lookup of block codecs by name.
+var blockCodecs = make(map[string]BlockCodec)
+
+// blockCodecsByID is a lookup of block codecs by ID.
+var blockCodecsByID [16]BlockCodec
+
+// RegisterBlockCodec registers a block codec by name and ID.
+func RegisterBlockCodec(c BlockCodec) {
+	if _, ok := blockCodecs[c.Name()]; ok {
+		panic("block codec already registered: " + c.Name())
+	} else if id := c.ID(); id == 0 || int(id) >= len(blockCodecsByID) {
+		panic(fmt.Sprintf("block codec %s has invalid id: %d", c.Name(), id))
+	} else if blockCodecsByID[id] != nil {
+		panic(fmt.Sprintf("block codec id %d already registered: %s", id, blockCodecsByID[id].Name()))
+	}
+	blockCodecs[c.Name()] = c
+	blockCodecsByID[c.ID()] = c
+}
+
+// RegisteredBlockCodecs returns the slice of currently registered block codecs.
+func RegisteredBlockCodecs() []string {
+	a := make([]string, 0, len(blockCodecs))
+	for k := range blockCodecs {
+		a = append(a, k)
+	}
+	sort.Strings(a)
+	return a
+}
+
+// BlockCodecByName returns the block codec registered as name, or nil if no
+// such codec has been registered.
+func BlockCodecByName(name string) BlockCodec {
+	return blockCodecs[name]
+}
+
+// BlockCodecByID returns the block codec registered with id, or nil if no
+// such codec has been registered.
+func BlockCodecByID(id byte) BlockCodec {
+	if int(id) >= len(blockCodecsByID) {
+		return nil
+	}
+	return blockCodecsByID[id]
+}
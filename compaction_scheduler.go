@@ -0,0 +1,282 @@
+package tsdb
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/v2/pkg/limiter"
+)
+
+// Compaction scheduler policies.
+const (
+	// CompactionPolicyFIFO starts compactions in the order shards ask for them.
+	CompactionPolicyFIFO = "fifo"
+
+	// CompactionPolicyHotShards starts the compactions of the most recently
+	// written shards first.
+	CompactionPolicyHotShards = "hot-shards"
+
+	// CompactionPolicyLevel1Files starts the compactions of the shards with the
+	// most level 1 compactions queued first.
+	CompactionPolicyLevel1Files = "level1-files"
+)
+
+// compactionSchedulerWaitTTL is how long a compaction that could not start is
+// considered to be waiting.  Engines ask to start their compactions every second.
+const compactionSchedulerWaitTTL = 3 * time.Second
+
+// CompactionStatus describes a running or queued compaction of an engine.
+type CompactionStatus struct {
+	// ID identifies a running compaction.  It is zero for queued compactions.
+	ID uint64
+
+	// Level is the level of the compaction: 1, 2, 3, full, opt or downsample.
+	// It is empty for compactions not scheduled by the engine.
+	Level string
+
+	// Files are the input TSM files of the compaction.
+	Files []string
+
+	// Running is true if the compaction has started.
+	Running bool
+
+	// StartTime is the time a running compaction started.
+	StartTime time.Time
+
+	// BytesTotal is the size of the input files, BytesRead the size of the
+	// blocks read from them so far, and BytesWritten the size of the blocks
+	// written to new files so far.
+	BytesTotal, BytesRead, BytesWritten int64
+
+	// EstimatedCompletion is when the compaction is expected to finish based on
+	// its progress so far.  It is zero if it cannot be estimated yet.
+	EstimatedCompletion time.Time
+
+	// Throttled is true if the writes of the compaction are limited by the
+	// compaction throughput limiter, and ThrottleWait is the time spent waiting
+	// on it.
+	Throttled    bool
+	ThrottleWait time.Duration
+}
+
+// CompactionRequest describes a compaction a shard is ready to start.
+type CompactionRequest struct {
+	ShardID uint64
+
+	// Level is the level of the compaction: 1 to 3 for level compactions and 4
+	// for full, optimize and downsample compactions.
+	Level int
+
+	// LastWrite is the time the shard was last written to.
+	LastWrite time.Time
+
+	// Level1Queued is the number of level 1 compactions queued in the shard.
+	Level1Queued int
+
+	// Size is the size of the shard on disk in bytes.
+	Size int64
+}
+
+// Full returns true if the request is for a full, optimize or downsample compaction.
+func (r CompactionRequest) Full() bool {
+	return r.Level >= 4
+}
+
+// CompactionScheduler decides when the compactions of the shards in a store may
+// start.  It is shared by all shards.
+type CompactionScheduler interface {
+	// TryAcquire returns true if the compaction described by req may start now.
+	// Release must be called with the same request once it completes.
+	TryAcquire(req CompactionRequest) bool
+
+	// Release returns the capacity used by a compaction.
+	Release(req CompactionRequest)
+
+	// Capacity returns the maximum number of concurrent compactions.
+	Capacity() int
+}
+
+// NewCompactionScheduler returns a scheduler running as many compactions as l
+// allows with the named policy, reserving reservedFull of them for full
+// compactions.  It returns a first come, first served scheduler taking from l
+// if policy is empty or fifo and no capacity is reserved.
+func NewCompactionScheduler(l limiter.Fixed, policy string, reservedFull int) (CompactionScheduler, error) {
+	less, err := CompactionPolicyByName(policy)
+	if err != nil {
+		return nil, err
+	} else if reservedFull < 0 || (reservedFull > 0 && reservedFull >= l.Capacity()) {
+		return nil, fmt.Errorf("compact-full-reserved must be less than %d", l.Capacity())
+	}
+
+	if less == nil && reservedFull == 0 {
+		return NewLimiterCompactionScheduler(l), nil
+	}
+	return NewPriorityCompactionScheduler(l.Capacity(), reservedFull, less), nil
+}
+
+// CompactionPolicy returns true if the compaction a should start before b.
+type CompactionPolicy func(a, b CompactionRequest) bool
+
+// CompactionPolicyByName returns the named compaction policy.  The first come,
+// first served policy is nil.
+func CompactionPolicyByName(name string) (CompactionPolicy, error) {
+	switch name {
+	case "", CompactionPolicyFIFO:
+		return nil, nil
+	case CompactionPolicyHotShards:
+		return func(a, b CompactionRequest) bool { return a.LastWrite.After(b.LastWrite) }, nil
+	case CompactionPolicyLevel1Files:
+		return func(a, b CompactionRequest) bool { return a.Level1Queued > b.Level1Queued }, nil
+	}
+	return nil, fmt.Errorf("unrecognized compaction policy %s", name)
+}
+
+// limiterCompactionScheduler starts compactions in the order they are asked for,
+// up to the capacity of a fixed limiter.
+type limiterCompactionScheduler struct {
+	limiter limiter.Fixed
+}
+
+// NewLimiterCompactionScheduler returns a first come, first served scheduler
+// sharing the capacity of l.
+func NewLimiterCompactionScheduler(l limiter.Fixed) CompactionScheduler {
+	return &limiterCompactionScheduler{limiter: l}
+}
+
+func (s *limiterCompactionScheduler) TryAcquire(CompactionRequest) bool { return s.limiter.TryTake() }
+func (s *limiterCompactionScheduler) Release(CompactionRequest)         { s.limiter.Release() }
+func (s *limiterCompactionScheduler) Capacity() int                     { return s.limiter.Capacity() }
+
+// PriorityCompactionScheduler starts the compactions of all shards by priority.
+// Level compactions go before full compactions, and compactions of the same kind
+// are ordered by a policy.  A number of slots may be reserved for full
+// compactions, which then run in those slots only: level compactions are not
+// starved by full compactions, nor full compactions by level compactions.  If
+// no slots are reserved, full compactions share the slots and only start when
+// no level compaction is waiting.
+type PriorityCompactionScheduler struct {
+	mu           sync.Mutex
+	capacity     int
+	reservedFull int
+	less         CompactionPolicy
+	now          func() time.Time
+
+	levelRunning, fullRunning int
+
+	// waiting are the compactions that could not start, by shard and level.
+	waiting map[compactionKey]waitingCompaction
+}
+
+type compactionKey struct {
+	shardID uint64
+	level   int
+}
+
+type waitingCompaction struct {
+	req  CompactionRequest
+	seen time.Time
+}
+
+// NewPriorityCompactionScheduler returns a scheduler running at most capacity
+// compactions ordered by less, reserving reservedFull slots for full compactions.
+func NewPriorityCompactionScheduler(capacity, reservedFull int, less CompactionPolicy) *PriorityCompactionScheduler {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if reservedFull >= capacity {
+		reservedFull = capacity - 1
+	}
+	return &PriorityCompactionScheduler{
+		capacity:     capacity,
+		reservedFull: reservedFull,
+		less:         less,
+		now:          time.Now,
+		waiting:      make(map[compactionKey]waitingCompaction),
+	}
+}
+
+// Capacity returns the maximum number of concurrent compactions.
+func (s *PriorityCompactionScheduler) Capacity() int {
+	return s.capacity
+}
+
+// TryAcquire returns true if the compaction described by req may start now.  If
+// it may not, it is recorded as waiting until it is asked for again.
+func (s *PriorityCompactionScheduler) TryAcquire(req CompactionRequest) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	key := compactionKey{shardID: req.ShardID, level: req.Level}
+	for k, w := range s.waiting {
+		if now.Sub(w.seen) > compactionSchedulerWaitTTL {
+			delete(s.waiting, k)
+		}
+	}
+
+	if !s.admit(key, req) {
+		s.waiting[key] = waitingCompaction{req: req, seen: now}
+		return false
+	}
+
+	delete(s.waiting, key)
+	if req.Full() {
+		s.fullRunning++
+	} else {
+		s.levelRunning++
+	}
+	return true
+}
+
+// admit returns true if req may start.
+func (s *PriorityCompactionScheduler) admit(key compactionKey, req CompactionRequest) bool {
+	if req.Full() && s.reservedFull > 0 {
+		// Full compactions only use the reserved slots.
+		if s.fullRunning >= s.reservedFull {
+			return false
+		}
+	} else {
+		used := s.levelRunning
+		if s.reservedFull == 0 {
+			used += s.fullRunning
+		}
+		if used >= s.capacity-s.reservedFull {
+			return false
+		}
+	}
+
+	// Give the slot to a waiting compaction that goes first.
+	for k, w := range s.waiting {
+		if k != key && (s.reservedFull == 0 || w.req.Full() == req.Full()) && s.before(w.req, req) {
+			return false
+		}
+	}
+	return true
+}
+
+// before returns true if a should start before b.
+func (s *PriorityCompactionScheduler) before(a, b CompactionRequest) bool {
+	if a.Full() != b.Full() {
+		return !a.Full()
+	}
+	return s.less != nil && s.less(a, b)
+}
+
+// Release returns the slot used by a compaction.
+func (s *PriorityCompactionScheduler) Release(req CompactionRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Full() {
+		s.fullRunning--
+	} else {
+		s.levelRunning--
+	}
+}
+
+// Waiting returns the number of compactions waiting to start.
+func (s *PriorityCompactionScheduler) Waiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.waiting)
+}
@@ -0,0 +1,102 @@
+package tsdb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/v2/pkg/limiter"
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriorityCompactionScheduler_ReservedFull(t *testing.T) {
+	s := tsdb.NewPriorityCompactionScheduler(3, 1, nil)
+
+	fullA := tsdb.CompactionRequest{ShardID: 1, Level: 4}
+	fullB := tsdb.CompactionRequest{ShardID: 2, Level: 4}
+	levelC := tsdb.CompactionRequest{ShardID: 3, Level: 1}
+	levelD := tsdb.CompactionRequest{ShardID: 4, Level: 2}
+	levelE := tsdb.CompactionRequest{ShardID: 5, Level: 1}
+
+	// Full compactions only run in the reserved slot.
+	require.True(t, s.TryAcquire(fullA))
+	require.False(t, s.TryAcquire(fullB))
+
+	// Level compactions have the other slots, even with a full compaction waiting.
+	require.True(t, s.TryAcquire(levelC))
+	require.True(t, s.TryAcquire(levelD))
+	require.False(t, s.TryAcquire(levelE))
+	require.Equal(t, 2, s.Waiting())
+
+	// Level compactions may not use the reserved slot.
+	s.Release(fullA)
+	require.False(t, s.TryAcquire(levelE))
+	require.True(t, s.TryAcquire(fullB))
+
+	// A freed shared slot does not go to a full compaction.
+	s.Release(levelC)
+	require.False(t, s.TryAcquire(fullA))
+	require.True(t, s.TryAcquire(levelE))
+}
+
+func TestPriorityCompactionScheduler_SharedFull(t *testing.T) {
+	s := tsdb.NewPriorityCompactionScheduler(2, 0, nil)
+
+	fullA := tsdb.CompactionRequest{ShardID: 1, Level: 4}
+	fullB := tsdb.CompactionRequest{ShardID: 2, Level: 4}
+	levelC := tsdb.CompactionRequest{ShardID: 3, Level: 1}
+	levelD := tsdb.CompactionRequest{ShardID: 4, Level: 1}
+
+	// Without reserved slots, full and level compactions share the slots.
+	require.True(t, s.TryAcquire(levelC))
+	require.True(t, s.TryAcquire(fullA))
+	require.False(t, s.TryAcquire(levelD))
+
+	// The waiting level compaction goes before another full compaction.
+	s.Release(levelC)
+	require.False(t, s.TryAcquire(fullB))
+	require.True(t, s.TryAcquire(levelD))
+}
+
+func TestPriorityCompactionScheduler_HotShards(t *testing.T) {
+	policy, err := tsdb.CompactionPolicyByName(tsdb.CompactionPolicyHotShards)
+	require.NoError(t, err)
+	s := tsdb.NewPriorityCompactionScheduler(1, 0, policy)
+
+	now := time.Now()
+	cold := tsdb.CompactionRequest{ShardID: 1, Level: 1, LastWrite: now.Add(-time.Hour)}
+	hot := tsdb.CompactionRequest{ShardID: 2, Level: 1, LastWrite: now}
+	running := tsdb.CompactionRequest{ShardID: 3, Level: 3}
+
+	require.True(t, s.TryAcquire(running))
+	require.False(t, s.TryAcquire(cold))
+	require.False(t, s.TryAcquire(hot))
+
+	// The cold shard asks first once the slot is free, but the hot shard is waiting.
+	s.Release(running)
+	require.False(t, s.TryAcquire(cold))
+	require.True(t, s.TryAcquire(hot))
+
+	s.Release(hot)
+	require.True(t, s.TryAcquire(cold))
+}
+
+func TestNewCompactionScheduler(t *testing.T) {
+	l := limiter.NewFixed(2)
+
+	s, err := tsdb.NewCompactionScheduler(l, tsdb.CompactionPolicyFIFO, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, s.Capacity())
+	require.True(t, s.TryAcquire(tsdb.CompactionRequest{Level: 4}))
+	require.Equal(t, 1, l.Available())
+
+	s, err = tsdb.NewCompactionScheduler(l, tsdb.CompactionPolicyLevel1Files, 1)
+	require.NoError(t, err)
+	require.IsType(t, &tsdb.PriorityCompactionScheduler{}, s)
+
+	_, err = tsdb.NewCompactionScheduler(l, tsdb.CompactionPolicyFIFO, 2)
+	require.EqualError(t, err, "compact-full-reserved must be less than 2")
+
+	_, err = tsdb.NewCompactionScheduler(l, "newest", 0)
+	require.EqualError(t, err, "unrecognized compaction policy newest")
+}
@@ -3,6 +3,7 @@ package tsdb
 import (
 	"errors"
 	"fmt"
+	"path/filepath"
 	"time"
 
 	"github.com/influxdata/influxdb/v2/toml"
@@ -17,6 +18,10 @@ const (
 
 	// tsdb/engine/wal configuration options
 
+	// DefaultWALGroupCommitLatency is the maximum time a write waits for other
+	// writes to be fsynced with it when WAL group commit is enabled.
+	DefaultWALGroupCommitLatency = time.Duration(10 * time.Millisecond)
+
 	// Default settings for TSM
 
 	// DefaultCacheMaxMemorySize is the maximum size a shard's cache can
@@ -47,17 +52,30 @@ const (
 	// will be set to equal the normal throughput
 	DefaultCompactThroughputBurst = 48 * 1024 * 1024
 
+	// DefaultTSMColdAge is how old the data in a fully compacted generation of
+	// TSM files must be before it is moved to the cold tier directory.
+	DefaultTSMColdAge = time.Duration(30 * 24 * time.Hour)
+
 	// DefaultMaxPointsPerBlock is the maximum number of points in an encoded
 	// block in a TSM file
 	DefaultMaxPointsPerBlock = 1000
 
+	// DefaultMaxSeriesPerDatabase is the maximum number of series a node can hold per database.
+	// It is not applied by NewConfig, so max-series-per-database is only enforced once set.
+	DefaultMaxSeriesPerDatabase = 1000000
+
 	// DefaultMaxValuesPerTag is the maximum number of values a tag can have within a measurement.
+	// It is not applied by NewConfig, so max-values-per-tag is only enforced once set.
 	DefaultMaxValuesPerTag = 100000
 
 	// DefaultMaxConcurrentCompactions is the maximum number of concurrent full and level compactions
 	// that can run at one time.  A value of 0 results in 50% of runtime.GOMAXPROCS(0) used at runtime.
 	DefaultMaxConcurrentCompactions = 0
 
+	// DefaultCompactSchedulerPolicy is the default policy deciding which shard's
+	// compactions start first.
+	DefaultCompactSchedulerPolicy = CompactionPolicyFIFO
+
 	// DefaultMaxIndexLogFileSize is the default threshold, in bytes, when an index
 	// write-ahead log file will compact into an index file.
 	DefaultMaxIndexLogFileSize = 1 * 1024 * 1024 // 1MB
@@ -95,12 +113,33 @@ type Config struct {
 	// disks or when WAL write contention is seen.  A value of 0 fsyncs every write to the WAL.
 	WALFsyncDelay toml.Duration `toml:"wal-fsync-delay"`
 
+	// WALCodec is the name of the codec used to compress new WAL entries. Existing
+	// entries are always decompressed with the codec recorded in their header.
+	WALCodec string `toml:"wal-codec"`
+
+	// WALGroupCommitBytes enables group commit of WAL writes if greater than 0.  Pending
+	// writes are fsynced together once this many bytes are pending, once the oldest of
+	// them has waited WALGroupCommitLatency or a write's deadline is near, or as soon as
+	// no other write is about to join them, whichever comes first.  WALFsyncDelay is
+	// ignored when group commit is enabled.
+	WALGroupCommitBytes toml.Size `toml:"wal-group-commit-bytes"`
+
+	// WALGroupCommitLatency is the maximum time a write waits for other writes to be
+	// fsynced with it when group commit is enabled.
+	WALGroupCommitLatency toml.Duration `toml:"wal-group-commit-latency"`
+
 	// Enables unicode validation on series keys on write.
 	ValidateKeys bool `toml:"validate-keys"`
 
 	// When true, skips size validation on fields
 	SkipFieldSizeValidation bool `toml:"skip-field-size-validation"`
 
+	// SketchFields lists the field keys of the quantile sketch fields created by writes.
+	// Line protocol has no sketch type, so sketches are written to these fields as strings
+	// holding their text encoding, and strings written to other new fields are never parsed
+	// as sketches. Fields keep the type they were created with.
+	SketchFields []string `toml:"sketch-fields"`
+
 	// Query logging
 	QueryLogEnabled bool `toml:"query-log-enabled"`
 
@@ -112,14 +151,65 @@ type Config struct {
 	CompactThroughput              toml.Size     `toml:"compact-throughput"`
 	CompactThroughputBurst         toml.Size     `toml:"compact-throughput-burst"`
 
+	// CacheMaxMemorySizeTotal is the maximum size the caches of all shards can
+	// reach together.  Snapshots of the coldest and largest caches are forced as
+	// the total approaches the limit, and writes are rejected once it is reached.
+	// The limit is disabled if it is zero.
+	CacheMaxMemorySizeTotal toml.Size `toml:"cache-max-memory-size-total"`
+
+	// BlockCacheMaxMemorySize is the maximum size of the cache of decoded TSM
+	// blocks shared by all shards.  Queries reading recently read blocks again
+	// are served from the cache rather than decoding them.  The cache is
+	// disabled if it is zero.
+	BlockCacheMaxMemorySize toml.Size `toml:"block-cache-max-memory-size"`
+
+	// TSMStringCodec is the name of the codec used to compress string blocks in new
+	// TSM files. Existing blocks are always decompressed with the codec recorded in
+	// their header.
+	TSMStringCodec string `toml:"tsm-string-codec"`
+
+	// TSMColdDir is the directory of the cold storage tier.  Fully compacted
+	// generations of TSM files are moved from a shard's directory to the same
+	// path under TSMColdDir once all their data is older than TSMColdAge.  The
+	// cold tier is disabled if TSMColdDir is empty.
+	TSMColdDir string `toml:"tsm-cold-dir"`
+
+	// TSMColdAge is how old all the data in a fully compacted generation of
+	// TSM files must be before it is moved to TSMColdDir.
+	TSMColdAge toml.Duration `toml:"tsm-cold-age"`
+
 	// Limits
 
+	// MaxSeriesPerDatabase is the maximum number of series a node can hold per database.
+	// When this limit is exceeded, writes return a 'max series per database exceeded' error.
+	// A value of 0 disables the limit, which is the default.
+	MaxSeriesPerDatabase int `toml:"max-series-per-database"`
+
+	// MaxValuesPerTag is the maximum number of tag values a single tag key can have within
+	// a measurement.  When the limit is exceeded, writes return an error.
+	// A value of 0 disables the limit, which is the default.
+	MaxValuesPerTag int `toml:"max-values-per-tag"`
+
 	// MaxConcurrentCompactions is the maximum number of concurrent level and full compactions
 	// that can be running at one time across all shards.  Compactions scheduled to run when the
 	// limit is reached are blocked until a running compaction completes.  Snapshot compactions are
 	// not affected by this limit.  A value of 0 limits compactions to runtime.GOMAXPROCS(0).
 	MaxConcurrentCompactions int `toml:"max-concurrent-compactions"`
 
+	// CompactSchedulerPolicy decides which shard's compactions start first when they
+	// compete for the max-concurrent-compactions limit: fifo starts them in order,
+	// hot-shards starts those of the most recently written shards first, and
+	// level1-files those of the shards with the most level 1 compactions queued.
+	// If no slots are reserved by compact-full-reserved, level compactions start
+	// before full compactions unless fifo is used.
+	CompactSchedulerPolicy string `toml:"compact-scheduler-policy"`
+
+	// CompactFullReserved is the number of max-concurrent-compactions slots set aside
+	// for full compactions.  Full compactions only run in those slots, so at most that
+	// many run at once and level compactions always have the other slots.  If it is 0,
+	// full compactions share all slots with level compactions, which start first.
+	CompactFullReserved int `toml:"compact-full-reserved"`
+
 	// MaxIndexLogFileSize is the threshold, in bytes, when an index write-ahead log file will
 	// compact into an index file. Lower sizes will cause log files to be compacted more quickly
 	// and result in lower heap usage at the expense of write throughput. Higher sizes will
@@ -131,6 +221,23 @@ type Config struct {
 	// Setting series-id-set-cache-size to 0 disables the cache.
 	SeriesIDSetCacheSize int `toml:"series-id-set-cache-size"`
 
+	// SeriesIDSetCacheMaxMemorySize is the maximum size of the series ID sets cached by the TSI indexes
+	// of all shards together. The least recently used sets are evicted to cache a new set, unless they
+	// are used more often than the new set. The limit is disabled if it is zero.
+	SeriesIDSetCacheMaxMemorySize toml.Size `toml:"series-id-set-cache-max-memory-size"`
+
+	// TagValueNGramIndex enables writing an n-gram index of tag values when TSI index files are
+	// compacted. Regex and substring tag value predicates use it to only match candidate values,
+	// at the expense of larger index files and slower compactions.
+	TagValueNGramIndex bool `toml:"tag-value-ngram-index"`
+
+	// NumericTagKeys lists the tag keys whose values the TSI index compares as numbers, so that
+	// comparison predicates such as `version > 12` are evaluated by the index. Values are compared
+	// by the number they parse to, so `version = 12` also matches the values `12.0` and `012`, and
+	// `version != 12` excludes them. Tag values which are not numbers never match these predicates.
+	// Keys stay numeric once recorded in the index.
+	NumericTagKeys []string `toml:"numeric-tag-keys"`
+
 	// SeriesFileMaxConcurrentSnapshotCompactions is the maximum number of concurrent snapshot compactions
 	// that can be running at one time across all series partitions in a database. Snapshots scheduled
 	// to run when the limit is reached are blocked until a running snapshot completes.  Only snapshot
@@ -163,8 +270,15 @@ func NewConfig() Config {
 		CompactThroughputBurst:         toml.Size(DefaultCompactThroughputBurst),
 
 		MaxConcurrentCompactions: DefaultMaxConcurrentCompactions,
+		CompactSchedulerPolicy:   DefaultCompactSchedulerPolicy,
 
 		WALMaxWriteDelay: 10 * time.Minute,
+		WALCodec:         DefaultBlockCodec,
+
+		WALGroupCommitLatency: toml.Duration(DefaultWALGroupCommitLatency),
+
+		TSMStringCodec: DefaultBlockCodec,
+		TSMColdAge:     toml.Duration(DefaultTSMColdAge),
 
 		MaxIndexLogFileSize:  toml.Size(DefaultMaxIndexLogFileSize),
 		SeriesIDSetCacheSize: DefaultSeriesIDSetCacheSize,
@@ -184,10 +298,26 @@ func (c *Config) Validate() error {
 		return errors.New("Data.WALDir must be specified")
 	}
 
+	if c.MaxSeriesPerDatabase < 0 {
+		return errors.New("max-series-per-database must be non-negative")
+	}
+
+	if c.MaxValuesPerTag < 0 {
+		return errors.New("max-values-per-tag must be non-negative")
+	}
+
 	if c.MaxConcurrentCompactions < 0 {
 		return errors.New("max-concurrent-compactions must be non-negative")
 	}
 
+	if _, err := CompactionPolicyByName(c.CompactSchedulerPolicy); err != nil {
+		return err
+	}
+
+	if c.CompactFullReserved < 0 {
+		return errors.New("compact-full-reserved must be non-negative")
+	}
+
 	if c.SeriesIDSetCacheSize < 0 {
 		return errors.New("series-id-set-cache-size must be non-negative")
 	}
@@ -218,5 +348,25 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("unrecognized index %s", c.Index)
 	}
 
+	if c.WALCodec != "" && BlockCodecByName(c.WALCodec) == nil {
+		return fmt.Errorf("unrecognized wal-codec %s", c.WALCodec)
+	}
+
+	if c.WALGroupCommitBytes > 0 && c.WALGroupCommitLatency <= 0 {
+		return errors.New("wal-group-commit-latency must be positive")
+	}
+
+	if c.TSMStringCodec != "" && BlockCodecByName(c.TSMStringCodec) == nil {
+		return fmt.Errorf("unrecognized tsm-string-codec %s", c.TSMStringCodec)
+	}
+
+	if c.TSMColdDir != "" {
+		if c.TSMColdAge <= 0 {
+			return errors.New("tsm-cold-age must be positive")
+		} else if filepath.Clean(c.TSMColdDir) == filepath.Clean(c.Dir) {
+			return errors.New("tsm-cold-dir must not be the same as dir")
+		}
+	}
+
 	return nil
 }
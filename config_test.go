@@ -16,6 +16,15 @@ dir = "/var/lib/influxdb/data"
 wal-dir = "/var/lib/influxdb/wal"
 wal-fsync-delay = "10s"
 tsm-use-madv-willneed = true
+max-series-per-database = 2000
+max-values-per-tag = 0
+tsm-cold-dir = "/mnt/cold/data"
+tsm-cold-age = "720h"
+cache-max-memory-size-total = "8gib"
+block-cache-max-memory-size = "256mib"
+series-id-set-cache-max-memory-size = "64mib"
+compact-scheduler-policy = "hot-shards"
+compact-full-reserved = 1
 `, &c); err != nil {
 		t.Fatal(err)
 	}
@@ -36,6 +45,33 @@ tsm-use-madv-willneed = true
 	if got, exp := c.TSMWillNeed, true; got != exp {
 		t.Errorf("unexpected tsm-madv-willneed:\n\nexp=%v\n\ngot=%v\n\n", exp, got)
 	}
+	if got, exp := c.MaxSeriesPerDatabase, 2000; got != exp {
+		t.Errorf("unexpected max-series-per-database:\n\nexp=%v\n\ngot=%v\n\n", exp, got)
+	}
+	if got, exp := c.MaxValuesPerTag, 0; got != exp {
+		t.Errorf("unexpected max-values-per-tag:\n\nexp=%v\n\ngot=%v\n\n", exp, got)
+	}
+	if got, exp := c.TSMColdDir, "/mnt/cold/data"; got != exp {
+		t.Errorf("unexpected tsm-cold-dir:\n\nexp=%v\n\ngot=%v\n\n", exp, got)
+	}
+	if got, exp := c.TSMColdAge, time.Duration(720*time.Hour); time.Duration(got) != exp {
+		t.Errorf("unexpected tsm-cold-age:\n\nexp=%v\n\ngot=%v\n\n", exp, got)
+	}
+	if got, exp := c.CacheMaxMemorySizeTotal, uint64(8<<30); uint64(got) != exp {
+		t.Errorf("unexpected cache-max-memory-size-total:\n\nexp=%v\n\ngot=%v\n\n", exp, got)
+	}
+	if got, exp := c.BlockCacheMaxMemorySize, uint64(256<<20); uint64(got) != exp {
+		t.Errorf("unexpected block-cache-max-memory-size:\n\nexp=%v\n\ngot=%v\n\n", exp, got)
+	}
+	if got, exp := c.SeriesIDSetCacheMaxMemorySize, uint64(64<<20); uint64(got) != exp {
+		t.Errorf("unexpected series-id-set-cache-max-memory-size:\n\nexp=%v\n\ngot=%v\n\n", exp, got)
+	}
+	if got, exp := c.CompactSchedulerPolicy, tsdb.CompactionPolicyHotShards; got != exp {
+		t.Errorf("unexpected compact-scheduler-policy:\n\nexp=%v\n\ngot=%v\n\n", exp, got)
+	}
+	if got, exp := c.CompactFullReserved, 1; got != exp {
+		t.Errorf("unexpected compact-full-reserved:\n\nexp=%v\n\ngot=%v\n\n", exp, got)
+	}
 }
 
 func TestConfig_Validate_Error(t *testing.T) {
@@ -70,6 +106,70 @@ func TestConfig_Validate_Error(t *testing.T) {
 	if err := c.Validate(); err == nil || err.Error() != "series-id-set-cache-size must be non-negative" {
 		t.Errorf("unexpected error: %s", err)
 	}
+
+	c.SeriesIDSetCacheSize = tsdb.DefaultSeriesIDSetCacheSize
+	c.MaxSeriesPerDatabase = -1
+	if err := c.Validate(); err == nil || err.Error() != "max-series-per-database must be non-negative" {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	c.MaxSeriesPerDatabase = 0
+	c.MaxValuesPerTag = -1
+	if err := c.Validate(); err == nil || err.Error() != "max-values-per-tag must be non-negative" {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	c.MaxValuesPerTag = 0
+	c.CompactSchedulerPolicy = "newest"
+	if err := c.Validate(); err == nil || err.Error() != "unrecognized compaction policy newest" {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	c.CompactSchedulerPolicy = tsdb.CompactionPolicyLevel1Files
+	c.CompactFullReserved = -1
+	if err := c.Validate(); err == nil || err.Error() != "compact-full-reserved must be non-negative" {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	c.CompactFullReserved = 0
+	c.WALCodec = "foo"
+	if err := c.Validate(); err == nil || err.Error() != "unrecognized wal-codec foo" {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	c.WALCodec = "zstd"
+	c.WALGroupCommitBytes = 1 << 20
+	c.WALGroupCommitLatency = 0
+	if err := c.Validate(); err == nil || err.Error() != "wal-group-commit-latency must be positive" {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	c.WALGroupCommitBytes = 0
+	c.TSMStringCodec = "bar"
+	if err := c.Validate(); err == nil || err.Error() != "unrecognized tsm-string-codec bar" {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	c.TSMStringCodec = "lz4"
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	c.TSMColdDir = c.Dir
+	if err := c.Validate(); err == nil || err.Error() != "tsm-cold-dir must not be the same as dir" {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	c.TSMColdDir = "/mnt/cold/data"
+	c.TSMColdAge = 0
+	if err := c.Validate(); err == nil || err.Error() != "tsm-cold-age must be positive" {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	c.TSMColdAge = tsdb.NewConfig().TSMColdAge
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
 }
 
 func TestConfig_ByteSizes(t *testing.T) {
@@ -13,12 +13,14 @@ type (
 	UnsignedArray = cursors.UnsignedArray
 	StringArray   = cursors.StringArray
 	BooleanArray  = cursors.BooleanArray
+	SketchArray   = cursors.SketchArray
 
 	IntegerArrayCursor  = cursors.IntegerArrayCursor
 	FloatArrayCursor    = cursors.FloatArrayCursor
 	UnsignedArrayCursor = cursors.UnsignedArrayCursor
 	StringArrayCursor   = cursors.StringArrayCursor
 	BooleanArrayCursor  = cursors.BooleanArrayCursor
+	SketchArrayCursor   = cursors.SketchArrayCursor
 
 	Cursor          = cursors.Cursor
 	CursorStats     = cursors.CursorStats
@@ -32,6 +34,7 @@ func NewFloatArrayLen(sz int) *FloatArray       { return cursors.NewFloatArrayLe
 func NewUnsignedArrayLen(sz int) *UnsignedArray { return cursors.NewUnsignedArrayLen(sz) }
 func NewStringArrayLen(sz int) *StringArray     { return cursors.NewStringArrayLen(sz) }
 func NewBooleanArrayLen(sz int) *BooleanArray   { return cursors.NewBooleanArrayLen(sz) }
+func NewSketchArrayLen(sz int) *SketchArray     { return cursors.NewSketchArrayLen(sz) }
 
 // EOF represents a "not found" key returned by a Cursor.
 const EOF = query.ZeroTime
@@ -27,3 +27,11 @@ func (a *BooleanArray) Size() int {
 	// size of timestamps + values
 	return len(a.Timestamps)*8 + len(a.Values)
 }
+
+func (a *SketchArray) Size() int {
+	sz := len(a.Timestamps) * 8
+	for _, s := range a.Values {
+		sz += s.Size()
+	}
+	return sz
+}
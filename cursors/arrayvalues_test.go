@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/influxdata/influxdb/v2/tsdb/cursors"
+	"github.com/influxdata/influxdb/v2/tsdb/sketch"
 )
 
 func makeBooleanArray(v ...interface{}) *cursors.BooleanArray {
@@ -457,3 +458,28 @@ func TestStringArray_Merge(t *testing.T) {
 		})
 	}
 }
+
+// Ensures a sketch of b replaces the sketch of a with the same timestamp.
+func TestSketchArray_Merge(t *testing.T) {
+	newSketch := func(values ...float64) *sketch.Sketch {
+		s := sketch.NewDefault()
+		for _, v := range values {
+			s.Add(v)
+		}
+		return s
+	}
+
+	a := &cursors.SketchArray{Timestamps: []int64{1, 2}, Values: []*sketch.Sketch{newSketch(1), newSketch(2)}}
+	b := &cursors.SketchArray{Timestamps: []int64{2, 3}, Values: []*sketch.Sketch{newSketch(3, 4), newSketch(5)}}
+	exp := []*sketch.Sketch{a.Values[0], b.Values[0], b.Values[1]}
+	a.Merge(b)
+
+	if got, exp := a.Timestamps, []int64{1, 2, 3}; !cmp.Equal(got, exp) {
+		t.Fatalf("unexpected timestamps -got/+exp\n%s", cmp.Diff(got, exp))
+	}
+	for i := range exp {
+		if a.Values[i] != exp[i] {
+			t.Fatalf("unexpected sketch at %d: got %v, exp %v", i, a.Values[i], exp[i])
+		}
+	}
+}
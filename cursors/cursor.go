@@ -39,6 +39,11 @@ type BooleanArrayCursor interface {
 	Next() *BooleanArray
 }
 
+type SketchArrayCursor interface {
+	Cursor
+	Next() *SketchArray
+}
+
 // CursorRequest is a request to the storage engine for a cursor to be
 // created with the given name, tags, and field for a given direction
 // and time range.
@@ -65,6 +70,54 @@ type CursorRequest struct {
 	// absolute time regardless of the Ascending flag. This value
 	// is an inclusive bound.
 	EndTime int64
+
+	// Aggregate is the aggregate the cursor should return for each
+	// window of values rather than the values themselves. Aggregate
+	// cursors are always ascending.
+	Aggregate Aggregate
+
+	// Every is the duration of the aggregate windows in nanoseconds.
+	// Windows are aligned to the Unix epoch. If Every is zero, a
+	// single window spans StartTime to EndTime.
+	Every int64
+}
+
+// Aggregate is an aggregate computed by the storage engine for the
+// windows of a cursor.
+type Aggregate int
+
+const (
+	AggregateNone Aggregate = iota
+	AggregateSum
+	AggregateCount
+	AggregateMin
+	AggregateMax
+	AggregateMean
+	AggregateFirst
+	AggregateLast
+)
+
+// String returns the name of the aggregate.
+func (a Aggregate) String() string {
+	switch a {
+	case AggregateNone:
+		return "none"
+	case AggregateSum:
+		return "sum"
+	case AggregateCount:
+		return "count"
+	case AggregateMin:
+		return "min"
+	case AggregateMax:
+		return "max"
+	case AggregateMean:
+		return "mean"
+	case AggregateFirst:
+		return "first"
+	case AggregateLast:
+		return "last"
+	}
+	return "unknown"
 }
 
 type CursorIterator interface {
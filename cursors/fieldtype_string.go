@@ -13,12 +13,13 @@ func _() {
 	_ = x[Unsigned-2]
 	_ = x[String-3]
 	_ = x[Boolean-4]
-	_ = x[Undefined-5]
+	_ = x[Sketch-5]
+	_ = x[Undefined-6]
 }
 
-const _FieldType_name = "FloatIntegerUnsignedStringBooleanUndefined"
+const _FieldType_name = "FloatIntegerUnsignedStringBooleanSketchUndefined"
 
-var _FieldType_index = [...]uint8{0, 5, 12, 20, 26, 33, 42}
+var _FieldType_index = [...]uint8{0, 5, 12, 20, 26, 33, 39, 48}
 
 func (i FieldType) String() string {
 	if i < 0 || i >= FieldType(len(_FieldType_index)-1) {
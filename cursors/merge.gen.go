@@ -0,0 +1,1025 @@
+// Generated by tmpl
+// https://github.com/benbjohnson/tmpl
+//
+// DO NOT EDIT!
+// Source: merge.gen.go.tmpl
+
+package cursors
+
+import (
+	"context"
+)
+
+// floatMergeInput is a float cursor of a merge cursor, with the arrays it
+// read ahead.
+type floatMergeInput struct {
+	cur   FloatArrayCursor
+	ready chan *FloatArray
+	free  chan *FloatArray
+	err   error
+
+	// a is the array being merged and i the position of its next value.
+	a    *FloatArray
+	i    int
+	done bool
+}
+
+// floatMergeCursor merges float cursors into a single stream ordered by time.
+type floatMergeCursor struct {
+	mergeCursor
+	inputs []*floatMergeInput
+	res    *FloatArray
+	err    error
+}
+
+func newFloatMergeCursor(ctx context.Context, cursors []Cursor, ascending bool, readAhead int) (*floatMergeCursor, error) {
+	c := &floatMergeCursor{
+		inputs: make([]*floatMergeInput, len(cursors)),
+		res:    NewFloatArrayLen(DefaultMaxPointsPerBlock),
+	}
+	c.init(ctx, len(cursors), ascending)
+
+	for i, cur := range cursors {
+		fcur, ok := cur.(FloatArrayCursor)
+		if !ok {
+			return nil, ErrMergeTypeConflict
+		}
+		in := &floatMergeInput{
+			cur:   fcur,
+			ready: make(chan *FloatArray, readAhead),
+			free:  make(chan *FloatArray, readAhead+1),
+		}
+		for j := 0; j < readAhead+1; j++ {
+			in.free <- &FloatArray{}
+		}
+		c.inputs[i] = in
+	}
+
+	c.wg.Add(len(c.inputs))
+	for i, in := range c.inputs {
+		go c.read(i, in)
+	}
+	return c, nil
+}
+
+// read copies the arrays of the cursor of in to its ready channel until the
+// cursor is exhausted or the merge cursor is closed.
+func (c *floatMergeCursor) read(i int, in *floatMergeInput) {
+	defer c.wg.Done()
+	defer close(in.ready)
+
+	for {
+		var buf *FloatArray
+		select {
+		case buf = <-in.free:
+		case <-c.closing:
+			return
+		case <-c.ctx.Done():
+			in.err = c.ctx.Err()
+			return
+		}
+
+		a := in.cur.Next()
+		c.setStats(i, in.cur.Stats())
+		if a.Len() == 0 {
+			in.err = in.cur.Err()
+			return
+		}
+		buf.Timestamps = append(buf.Timestamps[:0], a.Timestamps...)
+		buf.Values = append(buf.Values[:0], a.Values...)
+
+		select {
+		case in.ready <- buf:
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+// load returns true if in has a value at in.i, waiting for the next array read
+// ahead if needed.
+func (c *floatMergeCursor) load(in *floatMergeInput) bool {
+	for !in.done && in.i >= in.a.Len() {
+		if in.a != nil {
+			in.free <- in.a
+			in.a = nil
+		}
+
+		a, ok := <-in.ready
+		if !ok {
+			in.done = true
+			if in.err != nil && c.err == nil {
+				c.err = in.err
+			}
+			break
+		}
+		in.a, in.i = a, 0
+	}
+	return !in.done
+}
+
+func (c *floatMergeCursor) Next() *FloatArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for c.err == nil && len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		// Find the input with the next value, the last one if several have a
+		// value at the same time, and the time of the next value of the others.
+		next := -1
+		var limit int64
+		var limited bool
+		for i, in := range c.inputs {
+			if !c.load(in) {
+				continue
+			}
+			t := in.a.Timestamps[in.i]
+			if next < 0 || !c.before(c.inputs[next].a.Timestamps[c.inputs[next].i], t) {
+				if next >= 0 {
+					limit, limited = c.inputs[next].a.Timestamps[c.inputs[next].i], true
+				}
+				next = i
+			} else if !limited || c.before(t, limit) {
+				limit, limited = t, true
+			}
+		}
+		if next < 0 || c.err != nil {
+			break
+		}
+
+		// Copy the values of the input up to the next value of the others.
+		in := c.inputs[next]
+		ts := in.a.Timestamps
+		t := ts[in.i]
+		n, max := 1, cap(c.res.Timestamps)-len(c.res.Timestamps)
+		for in.i+n < len(ts) && n < max && (!limited || c.before(ts[in.i+n], limit)) {
+			n++
+		}
+		c.res.Timestamps = append(c.res.Timestamps, ts[in.i:in.i+n]...)
+		c.res.Values = append(c.res.Values, in.a.Values[in.i:in.i+n]...)
+		in.i += n
+
+		// Drop the values of the other inputs at the same time as the first one.
+		if limited && limit == t {
+			for _, other := range c.inputs {
+				if other != in && !other.done && other.i < other.a.Len() && other.a.Timestamps[other.i] == t {
+					other.i++
+				}
+			}
+		}
+	}
+	return c.res
+}
+
+// Err returns the first error of the merged cursors.
+func (c *floatMergeCursor) Err() error { return c.err }
+
+// Close stops reading ahead and closes the merged cursors.
+func (c *floatMergeCursor) Close() {
+	c.stop()
+	for _, in := range c.inputs {
+		in.cur.Close()
+	}
+}
+
+// integerMergeInput is a integer cursor of a merge cursor, with the arrays it
+// read ahead.
+type integerMergeInput struct {
+	cur   IntegerArrayCursor
+	ready chan *IntegerArray
+	free  chan *IntegerArray
+	err   error
+
+	// a is the array being merged and i the position of its next value.
+	a    *IntegerArray
+	i    int
+	done bool
+}
+
+// integerMergeCursor merges integer cursors into a single stream ordered by time.
+type integerMergeCursor struct {
+	mergeCursor
+	inputs []*integerMergeInput
+	res    *IntegerArray
+	err    error
+}
+
+func newIntegerMergeCursor(ctx context.Context, cursors []Cursor, ascending bool, readAhead int) (*integerMergeCursor, error) {
+	c := &integerMergeCursor{
+		inputs: make([]*integerMergeInput, len(cursors)),
+		res:    NewIntegerArrayLen(DefaultMaxPointsPerBlock),
+	}
+	c.init(ctx, len(cursors), ascending)
+
+	for i, cur := range cursors {
+		fcur, ok := cur.(IntegerArrayCursor)
+		if !ok {
+			return nil, ErrMergeTypeConflict
+		}
+		in := &integerMergeInput{
+			cur:   fcur,
+			ready: make(chan *IntegerArray, readAhead),
+			free:  make(chan *IntegerArray, readAhead+1),
+		}
+		for j := 0; j < readAhead+1; j++ {
+			in.free <- &IntegerArray{}
+		}
+		c.inputs[i] = in
+	}
+
+	c.wg.Add(len(c.inputs))
+	for i, in := range c.inputs {
+		go c.read(i, in)
+	}
+	return c, nil
+}
+
+// read copies the arrays of the cursor of in to its ready channel until the
+// cursor is exhausted or the merge cursor is closed.
+func (c *integerMergeCursor) read(i int, in *integerMergeInput) {
+	defer c.wg.Done()
+	defer close(in.ready)
+
+	for {
+		var buf *IntegerArray
+		select {
+		case buf = <-in.free:
+		case <-c.closing:
+			return
+		case <-c.ctx.Done():
+			in.err = c.ctx.Err()
+			return
+		}
+
+		a := in.cur.Next()
+		c.setStats(i, in.cur.Stats())
+		if a.Len() == 0 {
+			in.err = in.cur.Err()
+			return
+		}
+		buf.Timestamps = append(buf.Timestamps[:0], a.Timestamps...)
+		buf.Values = append(buf.Values[:0], a.Values...)
+
+		select {
+		case in.ready <- buf:
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+// load returns true if in has a value at in.i, waiting for the next array read
+// ahead if needed.
+func (c *integerMergeCursor) load(in *integerMergeInput) bool {
+	for !in.done && in.i >= in.a.Len() {
+		if in.a != nil {
+			in.free <- in.a
+			in.a = nil
+		}
+
+		a, ok := <-in.ready
+		if !ok {
+			in.done = true
+			if in.err != nil && c.err == nil {
+				c.err = in.err
+			}
+			break
+		}
+		in.a, in.i = a, 0
+	}
+	return !in.done
+}
+
+func (c *integerMergeCursor) Next() *IntegerArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for c.err == nil && len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		// Find the input with the next value, the last one if several have a
+		// value at the same time, and the time of the next value of the others.
+		next := -1
+		var limit int64
+		var limited bool
+		for i, in := range c.inputs {
+			if !c.load(in) {
+				continue
+			}
+			t := in.a.Timestamps[in.i]
+			if next < 0 || !c.before(c.inputs[next].a.Timestamps[c.inputs[next].i], t) {
+				if next >= 0 {
+					limit, limited = c.inputs[next].a.Timestamps[c.inputs[next].i], true
+				}
+				next = i
+			} else if !limited || c.before(t, limit) {
+				limit, limited = t, true
+			}
+		}
+		if next < 0 || c.err != nil {
+			break
+		}
+
+		// Copy the values of the input up to the next value of the others.
+		in := c.inputs[next]
+		ts := in.a.Timestamps
+		t := ts[in.i]
+		n, max := 1, cap(c.res.Timestamps)-len(c.res.Timestamps)
+		for in.i+n < len(ts) && n < max && (!limited || c.before(ts[in.i+n], limit)) {
+			n++
+		}
+		c.res.Timestamps = append(c.res.Timestamps, ts[in.i:in.i+n]...)
+		c.res.Values = append(c.res.Values, in.a.Values[in.i:in.i+n]...)
+		in.i += n
+
+		// Drop the values of the other inputs at the same time as the first one.
+		if limited && limit == t {
+			for _, other := range c.inputs {
+				if other != in && !other.done && other.i < other.a.Len() && other.a.Timestamps[other.i] == t {
+					other.i++
+				}
+			}
+		}
+	}
+	return c.res
+}
+
+// Err returns the first error of the merged cursors.
+func (c *integerMergeCursor) Err() error { return c.err }
+
+// Close stops reading ahead and closes the merged cursors.
+func (c *integerMergeCursor) Close() {
+	c.stop()
+	for _, in := range c.inputs {
+		in.cur.Close()
+	}
+}
+
+// unsignedMergeInput is a unsigned cursor of a merge cursor, with the arrays it
+// read ahead.
+type unsignedMergeInput struct {
+	cur   UnsignedArrayCursor
+	ready chan *UnsignedArray
+	free  chan *UnsignedArray
+	err   error
+
+	// a is the array being merged and i the position of its next value.
+	a    *UnsignedArray
+	i    int
+	done bool
+}
+
+// unsignedMergeCursor merges unsigned cursors into a single stream ordered by time.
+type unsignedMergeCursor struct {
+	mergeCursor
+	inputs []*unsignedMergeInput
+	res    *UnsignedArray
+	err    error
+}
+
+func newUnsignedMergeCursor(ctx context.Context, cursors []Cursor, ascending bool, readAhead int) (*unsignedMergeCursor, error) {
+	c := &unsignedMergeCursor{
+		inputs: make([]*unsignedMergeInput, len(cursors)),
+		res:    NewUnsignedArrayLen(DefaultMaxPointsPerBlock),
+	}
+	c.init(ctx, len(cursors), ascending)
+
+	for i, cur := range cursors {
+		fcur, ok := cur.(UnsignedArrayCursor)
+		if !ok {
+			return nil, ErrMergeTypeConflict
+		}
+		in := &unsignedMergeInput{
+			cur:   fcur,
+			ready: make(chan *UnsignedArray, readAhead),
+			free:  make(chan *UnsignedArray, readAhead+1),
+		}
+		for j := 0; j < readAhead+1; j++ {
+			in.free <- &UnsignedArray{}
+		}
+		c.inputs[i] = in
+	}
+
+	c.wg.Add(len(c.inputs))
+	for i, in := range c.inputs {
+		go c.read(i, in)
+	}
+	return c, nil
+}
+
+// read copies the arrays of the cursor of in to its ready channel until the
+// cursor is exhausted or the merge cursor is closed.
+func (c *unsignedMergeCursor) read(i int, in *unsignedMergeInput) {
+	defer c.wg.Done()
+	defer close(in.ready)
+
+	for {
+		var buf *UnsignedArray
+		select {
+		case buf = <-in.free:
+		case <-c.closing:
+			return
+		case <-c.ctx.Done():
+			in.err = c.ctx.Err()
+			return
+		}
+
+		a := in.cur.Next()
+		c.setStats(i, in.cur.Stats())
+		if a.Len() == 0 {
+			in.err = in.cur.Err()
+			return
+		}
+		buf.Timestamps = append(buf.Timestamps[:0], a.Timestamps...)
+		buf.Values = append(buf.Values[:0], a.Values...)
+
+		select {
+		case in.ready <- buf:
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+// load returns true if in has a value at in.i, waiting for the next array read
+// ahead if needed.
+func (c *unsignedMergeCursor) load(in *unsignedMergeInput) bool {
+	for !in.done && in.i >= in.a.Len() {
+		if in.a != nil {
+			in.free <- in.a
+			in.a = nil
+		}
+
+		a, ok := <-in.ready
+		if !ok {
+			in.done = true
+			if in.err != nil && c.err == nil {
+				c.err = in.err
+			}
+			break
+		}
+		in.a, in.i = a, 0
+	}
+	return !in.done
+}
+
+func (c *unsignedMergeCursor) Next() *UnsignedArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for c.err == nil && len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		// Find the input with the next value, the last one if several have a
+		// value at the same time, and the time of the next value of the others.
+		next := -1
+		var limit int64
+		var limited bool
+		for i, in := range c.inputs {
+			if !c.load(in) {
+				continue
+			}
+			t := in.a.Timestamps[in.i]
+			if next < 0 || !c.before(c.inputs[next].a.Timestamps[c.inputs[next].i], t) {
+				if next >= 0 {
+					limit, limited = c.inputs[next].a.Timestamps[c.inputs[next].i], true
+				}
+				next = i
+			} else if !limited || c.before(t, limit) {
+				limit, limited = t, true
+			}
+		}
+		if next < 0 || c.err != nil {
+			break
+		}
+
+		// Copy the values of the input up to the next value of the others.
+		in := c.inputs[next]
+		ts := in.a.Timestamps
+		t := ts[in.i]
+		n, max := 1, cap(c.res.Timestamps)-len(c.res.Timestamps)
+		for in.i+n < len(ts) && n < max && (!limited || c.before(ts[in.i+n], limit)) {
+			n++
+		}
+		c.res.Timestamps = append(c.res.Timestamps, ts[in.i:in.i+n]...)
+		c.res.Values = append(c.res.Values, in.a.Values[in.i:in.i+n]...)
+		in.i += n
+
+		// Drop the values of the other inputs at the same time as the first one.
+		if limited && limit == t {
+			for _, other := range c.inputs {
+				if other != in && !other.done && other.i < other.a.Len() && other.a.Timestamps[other.i] == t {
+					other.i++
+				}
+			}
+		}
+	}
+	return c.res
+}
+
+// Err returns the first error of the merged cursors.
+func (c *unsignedMergeCursor) Err() error { return c.err }
+
+// Close stops reading ahead and closes the merged cursors.
+func (c *unsignedMergeCursor) Close() {
+	c.stop()
+	for _, in := range c.inputs {
+		in.cur.Close()
+	}
+}
+
+// stringMergeInput is a string cursor of a merge cursor, with the arrays it
+// read ahead.
+type stringMergeInput struct {
+	cur   StringArrayCursor
+	ready chan *StringArray
+	free  chan *StringArray
+	err   error
+
+	// a is the array being merged and i the position of its next value.
+	a    *StringArray
+	i    int
+	done bool
+}
+
+// stringMergeCursor merges string cursors into a single stream ordered by time.
+type stringMergeCursor struct {
+	mergeCursor
+	inputs []*stringMergeInput
+	res    *StringArray
+	err    error
+}
+
+func newStringMergeCursor(ctx context.Context, cursors []Cursor, ascending bool, readAhead int) (*stringMergeCursor, error) {
+	c := &stringMergeCursor{
+		inputs: make([]*stringMergeInput, len(cursors)),
+		res:    NewStringArrayLen(DefaultMaxPointsPerBlock),
+	}
+	c.init(ctx, len(cursors), ascending)
+
+	for i, cur := range cursors {
+		fcur, ok := cur.(StringArrayCursor)
+		if !ok {
+			return nil, ErrMergeTypeConflict
+		}
+		in := &stringMergeInput{
+			cur:   fcur,
+			ready: make(chan *StringArray, readAhead),
+			free:  make(chan *StringArray, readAhead+1),
+		}
+		for j := 0; j < readAhead+1; j++ {
+			in.free <- &StringArray{}
+		}
+		c.inputs[i] = in
+	}
+
+	c.wg.Add(len(c.inputs))
+	for i, in := range c.inputs {
+		go c.read(i, in)
+	}
+	return c, nil
+}
+
+// read copies the arrays of the cursor of in to its ready channel until the
+// cursor is exhausted or the merge cursor is closed.
+func (c *stringMergeCursor) read(i int, in *stringMergeInput) {
+	defer c.wg.Done()
+	defer close(in.ready)
+
+	for {
+		var buf *StringArray
+		select {
+		case buf = <-in.free:
+		case <-c.closing:
+			return
+		case <-c.ctx.Done():
+			in.err = c.ctx.Err()
+			return
+		}
+
+		a := in.cur.Next()
+		c.setStats(i, in.cur.Stats())
+		if a.Len() == 0 {
+			in.err = in.cur.Err()
+			return
+		}
+		buf.Timestamps = append(buf.Timestamps[:0], a.Timestamps...)
+		buf.Values = append(buf.Values[:0], a.Values...)
+
+		select {
+		case in.ready <- buf:
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+// load returns true if in has a value at in.i, waiting for the next array read
+// ahead if needed.
+func (c *stringMergeCursor) load(in *stringMergeInput) bool {
+	for !in.done && in.i >= in.a.Len() {
+		if in.a != nil {
+			in.free <- in.a
+			in.a = nil
+		}
+
+		a, ok := <-in.ready
+		if !ok {
+			in.done = true
+			if in.err != nil && c.err == nil {
+				c.err = in.err
+			}
+			break
+		}
+		in.a, in.i = a, 0
+	}
+	return !in.done
+}
+
+func (c *stringMergeCursor) Next() *StringArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for c.err == nil && len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		// Find the input with the next value, the last one if several have a
+		// value at the same time, and the time of the next value of the others.
+		next := -1
+		var limit int64
+		var limited bool
+		for i, in := range c.inputs {
+			if !c.load(in) {
+				continue
+			}
+			t := in.a.Timestamps[in.i]
+			if next < 0 || !c.before(c.inputs[next].a.Timestamps[c.inputs[next].i], t) {
+				if next >= 0 {
+					limit, limited = c.inputs[next].a.Timestamps[c.inputs[next].i], true
+				}
+				next = i
+			} else if !limited || c.before(t, limit) {
+				limit, limited = t, true
+			}
+		}
+		if next < 0 || c.err != nil {
+			break
+		}
+
+		// Copy the values of the input up to the next value of the others.
+		in := c.inputs[next]
+		ts := in.a.Timestamps
+		t := ts[in.i]
+		n, max := 1, cap(c.res.Timestamps)-len(c.res.Timestamps)
+		for in.i+n < len(ts) && n < max && (!limited || c.before(ts[in.i+n], limit)) {
+			n++
+		}
+		c.res.Timestamps = append(c.res.Timestamps, ts[in.i:in.i+n]...)
+		c.res.Values = append(c.res.Values, in.a.Values[in.i:in.i+n]...)
+		in.i += n
+
+		// Drop the values of the other inputs at the same time as the first one.
+		if limited && limit == t {
+			for _, other := range c.inputs {
+				if other != in && !other.done && other.i < other.a.Len() && other.a.Timestamps[other.i] == t {
+					other.i++
+				}
+			}
+		}
+	}
+	return c.res
+}
+
+// Err returns the first error of the merged cursors.
+func (c *stringMergeCursor) Err() error { return c.err }
+
+// Close stops reading ahead and closes the merged cursors.
+func (c *stringMergeCursor) Close() {
+	c.stop()
+	for _, in := range c.inputs {
+		in.cur.Close()
+	}
+}
+
+// booleanMergeInput is a boolean cursor of a merge cursor, with the arrays it
+// read ahead.
+type booleanMergeInput struct {
+	cur   BooleanArrayCursor
+	ready chan *BooleanArray
+	free  chan *BooleanArray
+	err   error
+
+	// a is the array being merged and i the position of its next value.
+	a    *BooleanArray
+	i    int
+	done bool
+}
+
+// booleanMergeCursor merges boolean cursors into a single stream ordered by time.
+type booleanMergeCursor struct {
+	mergeCursor
+	inputs []*booleanMergeInput
+	res    *BooleanArray
+	err    error
+}
+
+func newBooleanMergeCursor(ctx context.Context, cursors []Cursor, ascending bool, readAhead int) (*booleanMergeCursor, error) {
+	c := &booleanMergeCursor{
+		inputs: make([]*booleanMergeInput, len(cursors)),
+		res:    NewBooleanArrayLen(DefaultMaxPointsPerBlock),
+	}
+	c.init(ctx, len(cursors), ascending)
+
+	for i, cur := range cursors {
+		fcur, ok := cur.(BooleanArrayCursor)
+		if !ok {
+			return nil, ErrMergeTypeConflict
+		}
+		in := &booleanMergeInput{
+			cur:   fcur,
+			ready: make(chan *BooleanArray, readAhead),
+			free:  make(chan *BooleanArray, readAhead+1),
+		}
+		for j := 0; j < readAhead+1; j++ {
+			in.free <- &BooleanArray{}
+		}
+		c.inputs[i] = in
+	}
+
+	c.wg.Add(len(c.inputs))
+	for i, in := range c.inputs {
+		go c.read(i, in)
+	}
+	return c, nil
+}
+
+// read copies the arrays of the cursor of in to its ready channel until the
+// cursor is exhausted or the merge cursor is closed.
+func (c *booleanMergeCursor) read(i int, in *booleanMergeInput) {
+	defer c.wg.Done()
+	defer close(in.ready)
+
+	for {
+		var buf *BooleanArray
+		select {
+		case buf = <-in.free:
+		case <-c.closing:
+			return
+		case <-c.ctx.Done():
+			in.err = c.ctx.Err()
+			return
+		}
+
+		a := in.cur.Next()
+		c.setStats(i, in.cur.Stats())
+		if a.Len() == 0 {
+			in.err = in.cur.Err()
+			return
+		}
+		buf.Timestamps = append(buf.Timestamps[:0], a.Timestamps...)
+		buf.Values = append(buf.Values[:0], a.Values...)
+
+		select {
+		case in.ready <- buf:
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+// load returns true if in has a value at in.i, waiting for the next array read
+// ahead if needed.
+func (c *booleanMergeCursor) load(in *booleanMergeInput) bool {
+	for !in.done && in.i >= in.a.Len() {
+		if in.a != nil {
+			in.free <- in.a
+			in.a = nil
+		}
+
+		a, ok := <-in.ready
+		if !ok {
+			in.done = true
+			if in.err != nil && c.err == nil {
+				c.err = in.err
+			}
+			break
+		}
+		in.a, in.i = a, 0
+	}
+	return !in.done
+}
+
+func (c *booleanMergeCursor) Next() *BooleanArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for c.err == nil && len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		// Find the input with the next value, the last one if several have a
+		// value at the same time, and the time of the next value of the others.
+		next := -1
+		var limit int64
+		var limited bool
+		for i, in := range c.inputs {
+			if !c.load(in) {
+				continue
+			}
+			t := in.a.Timestamps[in.i]
+			if next < 0 || !c.before(c.inputs[next].a.Timestamps[c.inputs[next].i], t) {
+				if next >= 0 {
+					limit, limited = c.inputs[next].a.Timestamps[c.inputs[next].i], true
+				}
+				next = i
+			} else if !limited || c.before(t, limit) {
+				limit, limited = t, true
+			}
+		}
+		if next < 0 || c.err != nil {
+			break
+		}
+
+		// Copy the values of the input up to the next value of the others.
+		in := c.inputs[next]
+		ts := in.a.Timestamps
+		t := ts[in.i]
+		n, max := 1, cap(c.res.Timestamps)-len(c.res.Timestamps)
+		for in.i+n < len(ts) && n < max && (!limited || c.before(ts[in.i+n], limit)) {
+			n++
+		}
+		c.res.Timestamps = append(c.res.Timestamps, ts[in.i:in.i+n]...)
+		c.res.Values = append(c.res.Values, in.a.Values[in.i:in.i+n]...)
+		in.i += n
+
+		// Drop the values of the other inputs at the same time as the first one.
+		if limited && limit == t {
+			for _, other := range c.inputs {
+				if other != in && !other.done && other.i < other.a.Len() && other.a.Timestamps[other.i] == t {
+					other.i++
+				}
+			}
+		}
+	}
+	return c.res
+}
+
+// Err returns the first error of the merged cursors.
+func (c *booleanMergeCursor) Err() error { return c.err }
+
+// Close stops reading ahead and closes the merged cursors.
+func (c *booleanMergeCursor) Close() {
+	c.stop()
+	for _, in := range c.inputs {
+		in.cur.Close()
+	}
+}
+
+// sketchMergeInput is a sketch cursor of a merge cursor, with the arrays it
+// read ahead.
+type sketchMergeInput struct {
+	cur   SketchArrayCursor
+	ready chan *SketchArray
+	free  chan *SketchArray
+	err   error
+
+	// a is the array being merged and i the position of its next value.
+	a    *SketchArray
+	i    int
+	done bool
+}
+
+// sketchMergeCursor merges sketch cursors into a single stream ordered by time.
+type sketchMergeCursor struct {
+	mergeCursor
+	inputs []*sketchMergeInput
+	res    *SketchArray
+	err    error
+}
+
+func newSketchMergeCursor(ctx context.Context, cursors []Cursor, ascending bool, readAhead int) (*sketchMergeCursor, error) {
+	c := &sketchMergeCursor{
+		inputs: make([]*sketchMergeInput, len(cursors)),
+		res:    NewSketchArrayLen(DefaultMaxPointsPerBlock),
+	}
+	c.init(ctx, len(cursors), ascending)
+
+	for i, cur := range cursors {
+		fcur, ok := cur.(SketchArrayCursor)
+		if !ok {
+			return nil, ErrMergeTypeConflict
+		}
+		in := &sketchMergeInput{
+			cur:   fcur,
+			ready: make(chan *SketchArray, readAhead),
+			free:  make(chan *SketchArray, readAhead+1),
+		}
+		for j := 0; j < readAhead+1; j++ {
+			in.free <- &SketchArray{}
+		}
+		c.inputs[i] = in
+	}
+
+	c.wg.Add(len(c.inputs))
+	for i, in := range c.inputs {
+		go c.read(i, in)
+	}
+	return c, nil
+}
+
+// read copies the arrays of the cursor of in to its ready channel until the
+// cursor is exhausted or the merge cursor is closed.
+func (c *sketchMergeCursor) read(i int, in *sketchMergeInput) {
+	defer c.wg.Done()
+	defer close(in.ready)
+
+	for {
+		var buf *SketchArray
+		select {
+		case buf = <-in.free:
+		case <-c.closing:
+			return
+		case <-c.ctx.Done():
+			in.err = c.ctx.Err()
+			return
+		}
+
+		a := in.cur.Next()
+		c.setStats(i, in.cur.Stats())
+		if a.Len() == 0 {
+			in.err = in.cur.Err()
+			return
+		}
+		buf.Timestamps = append(buf.Timestamps[:0], a.Timestamps...)
+		buf.Values = append(buf.Values[:0], a.Values...)
+
+		select {
+		case in.ready <- buf:
+		case <-c.closing:
+			return
+		}
+	}
+}
+
+// load returns true if in has a value at in.i, waiting for the next array read
+// ahead if needed.
+func (c *sketchMergeCursor) load(in *sketchMergeInput) bool {
+	for !in.done && in.i >= in.a.Len() {
+		if in.a != nil {
+			in.free <- in.a
+			in.a = nil
+		}
+
+		a, ok := <-in.ready
+		if !ok {
+			in.done = true
+			if in.err != nil && c.err == nil {
+				c.err = in.err
+			}
+			break
+		}
+		in.a, in.i = a, 0
+	}
+	return !in.done
+}
+
+func (c *sketchMergeCursor) Next() *SketchArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for c.err == nil && len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		// Find the input with the next value, the last one if several have a
+		// value at the same time, and the time of the next value of the others.
+		next := -1
+		var limit int64
+		var limited bool
+		for i, in := range c.inputs {
+			if !c.load(in) {
+				continue
+			}
+			t := in.a.Timestamps[in.i]
+			if next < 0 || !c.before(c.inputs[next].a.Timestamps[c.inputs[next].i], t) {
+				if next >= 0 {
+					limit, limited = c.inputs[next].a.Timestamps[c.inputs[next].i], true
+				}
+				next = i
+			} else if !limited || c.before(t, limit) {
+				limit, limited = t, true
+			}
+		}
+		if next < 0 || c.err != nil {
+			break
+		}
+
+		// Copy the values of the input up to the next value of the others.
+		in := c.inputs[next]
+		ts := in.a.Timestamps
+		t := ts[in.i]
+		n, max := 1, cap(c.res.Timestamps)-len(c.res.Timestamps)
+		for in.i+n < len(ts) && n < max && (!limited || c.before(ts[in.i+n], limit)) {
+			n++
+		}
+		c.res.Timestamps = append(c.res.Timestamps, ts[in.i:in.i+n]...)
+		c.res.Values = append(c.res.Values, in.a.Values[in.i:in.i+n]...)
+		in.i += n
+
+		// Drop the values of the other inputs at the same time as the first one.
+		if limited && limit == t {
+			for _, other := range c.inputs {
+				if other != in && !other.done && other.i < other.a.Len() && other.a.Timestamps[other.i] == t {
+					other.i++
+				}
+			}
+		}
+	}
+	return c.res
+}
+
+// Err returns the first error of the merged cursors.
+func (c *sketchMergeCursor) Err() error { return c.err }
+
+// Close stops reading ahead and closes the merged cursors.
+func (c *sketchMergeCursor) Close() {
+	c.stop()
+	for _, in := range c.inputs {
+		in.cur.Close()
+	}
+}
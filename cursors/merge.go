@@ -0,0 +1,140 @@
+package cursors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultMergeReadAhead is the default number of arrays each cursor of a
+// merge cursor reads ahead of the merge.
+const DefaultMergeReadAhead = 2
+
+// NewMergeCursor returns a cursor merging the cursors created by each iterator
+// for r into a single stream ordered by time.  Each cursor is read in its own
+// goroutine and may read up to readAhead arrays ahead of the merge.  If several
+// cursors have a value at the same time, the value of the last one is kept.
+//
+// The iterators must not be used to create other cursors until the merge cursor
+// is closed.  NewMergeCursor returns nil if no iterator has a cursor for r.
+func (a CursorIterators) NewMergeCursor(ctx context.Context, r *CursorRequest, readAhead int) (Cursor, error) {
+	if r.Aggregate != AggregateNone {
+		return nil, fmt.Errorf("cannot merge %s aggregate cursors", r.Aggregate)
+	}
+	if readAhead <= 0 {
+		readAhead = DefaultMergeReadAhead
+	}
+
+	var cursors []Cursor
+	closeAll := func() {
+		for _, cur := range cursors {
+			cur.Close()
+		}
+	}
+	for _, itr := range a {
+		cur, err := itr.Next(ctx, r)
+		if err != nil {
+			closeAll()
+			return nil, err
+		} else if cur != nil {
+			cursors = append(cursors, cur)
+		}
+	}
+
+	switch len(cursors) {
+	case 0:
+		return nil, nil
+	case 1:
+		return cursors[0], nil
+	}
+
+	cur, err := newMergeCursor(ctx, cursors, r.Ascending, readAhead)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	return cur, nil
+}
+
+// ErrMergeTypeConflict is returned when the cursors to be merged are not all
+// of the same type.
+var ErrMergeTypeConflict = errors.New("cannot merge cursors of different types")
+
+// mergeCursor holds the state shared by the merge cursors of all types.
+type mergeCursor struct {
+	ctx       context.Context
+	ascending bool
+
+	// closing is closed to stop the goroutines reading the cursors.
+	closing chan struct{}
+	wg      sync.WaitGroup
+
+	mu    sync.Mutex
+	stats []CursorStats
+}
+
+func (c *mergeCursor) init(ctx context.Context, n int, ascending bool) {
+	c.ctx = ctx
+	c.ascending = ascending
+	c.closing = make(chan struct{})
+	c.stats = make([]CursorStats, n)
+}
+
+// before returns true if time a goes before time b in the merged stream.
+func (c *mergeCursor) before(a, b int64) bool {
+	if c.ascending {
+		return a < b
+	}
+	return a > b
+}
+
+// setStats records the stats of the i-th cursor.
+func (c *mergeCursor) setStats(i int, stats CursorStats) {
+	c.mu.Lock()
+	c.stats[i] = stats
+	c.mu.Unlock()
+}
+
+// Stats returns the aggregate stats of the merged cursors.
+func (c *mergeCursor) Stats() CursorStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var stats CursorStats
+	for _, s := range c.stats {
+		stats.Add(s)
+	}
+	return stats
+}
+
+// stop stops the goroutines reading the cursors and waits for them to return.
+func (c *mergeCursor) stop() {
+	select {
+	case <-c.closing:
+	default:
+		close(c.closing)
+	}
+	c.wg.Wait()
+}
+
+// newMergeCursor returns a cursor merging cursors, which must all be of the
+// same type.
+func newMergeCursor(ctx context.Context, cursors []Cursor, ascending bool, readAhead int) (Cursor, error) {
+	switch cursors[0].(type) {
+	case FloatArrayCursor:
+		return newFloatMergeCursor(ctx, cursors, ascending, readAhead)
+	case IntegerArrayCursor:
+		return newIntegerMergeCursor(ctx, cursors, ascending, readAhead)
+	case UnsignedArrayCursor:
+		return newUnsignedMergeCursor(ctx, cursors, ascending, readAhead)
+	case StringArrayCursor:
+		return newStringMergeCursor(ctx, cursors, ascending, readAhead)
+	case BooleanArrayCursor:
+		return newBooleanMergeCursor(ctx, cursors, ascending, readAhead)
+	case SketchArrayCursor:
+		return newSketchMergeCursor(ctx, cursors, ascending, readAhead)
+	default:
+		return nil, fmt.Errorf("cannot merge cursors of type %T", cursors[0])
+	}
+}
@@ -0,0 +1,122 @@
+package cursors_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/influxdata/influxdb/v2/tsdb/cursors"
+)
+
+type floatArrayCursor struct {
+	arrays []*cursors.FloatArray
+	closed bool
+}
+
+func (c *floatArrayCursor) Next() *cursors.FloatArray {
+	if len(c.arrays) == 0 {
+		return &cursors.FloatArray{}
+	}
+	a := c.arrays[0]
+	c.arrays = c.arrays[1:]
+	return a
+}
+
+func (c *floatArrayCursor) Close()                     { c.closed = true }
+func (c *floatArrayCursor) Err() error                 { return nil }
+func (c *floatArrayCursor) Stats() cursors.CursorStats { return cursors.CursorStats{} }
+
+type integerArrayCursor struct{ floatArrayCursor }
+
+func (c *integerArrayCursor) Next() *cursors.IntegerArray { return &cursors.IntegerArray{} }
+
+type cursorIterator struct{ cur cursors.Cursor }
+
+func (itr *cursorIterator) Next(context.Context, *cursors.CursorRequest) (cursors.Cursor, error) {
+	return itr.cur, nil
+}
+
+func (itr *cursorIterator) Stats() cursors.CursorStats { return cursors.CursorStats{} }
+
+func TestCursorIterators_NewMergeCursor(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		ascending     bool
+		inputs        [][]*cursors.FloatArray
+		expTimestamps []int64
+		expValues     []float64
+	}{
+		{
+			name:      "ascending",
+			ascending: true,
+			inputs: [][]*cursors.FloatArray{
+				{makeFloatArray(1, 1.0, 3, 3.0, 5, 5.0), makeFloatArray(7, 7.0, 9, 9.0)},
+				{makeFloatArray(2, 2.1, 3, 3.1, 4, 4.1)},
+				{},
+			},
+			expTimestamps: []int64{1, 2, 3, 4, 5, 7, 9},
+			expValues:     []float64{1.0, 2.1, 3.1, 4.1, 5.0, 7.0, 9.0},
+		},
+		{
+			name:      "descending",
+			ascending: false,
+			inputs: [][]*cursors.FloatArray{
+				{makeFloatArray(9, 9.0, 7, 7.0), makeFloatArray(5, 5.0, 3, 3.0, 1, 1.0)},
+				{makeFloatArray(4, 4.1, 3, 3.1, 2, 2.1)},
+			},
+			expTimestamps: []int64{9, 7, 5, 4, 3, 2, 1},
+			expValues:     []float64{9.0, 7.0, 5.0, 4.1, 3.1, 2.1, 1.0},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var itrs cursors.CursorIterators
+			var inputs []*floatArrayCursor
+			for _, arrays := range tt.inputs {
+				cur := &floatArrayCursor{arrays: arrays}
+				inputs = append(inputs, cur)
+				itrs = append(itrs, &cursorIterator{cur: cur})
+			}
+
+			cur, err := itrs.NewMergeCursor(context.Background(), &cursors.CursorRequest{Ascending: tt.ascending}, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var timestamps []int64
+			var values []float64
+			fcur := cur.(cursors.FloatArrayCursor)
+			for a := fcur.Next(); a.Len() > 0; a = fcur.Next() {
+				timestamps = append(timestamps, a.Timestamps...)
+				values = append(values, a.Values...)
+			}
+			if err := cur.Err(); err != nil {
+				t.Fatal(err)
+			}
+			cur.Close()
+
+			if !cmp.Equal(tt.expTimestamps, timestamps) {
+				t.Errorf("unexpected timestamps: %v", timestamps)
+			}
+			if !cmp.Equal(tt.expValues, values) {
+				t.Errorf("unexpected values: %v", values)
+			}
+			for i, in := range inputs {
+				if !in.closed {
+					t.Errorf("cursor %d not closed", i)
+				}
+			}
+		})
+	}
+}
+
+func TestCursorIterators_NewMergeCursor_TypeConflict(t *testing.T) {
+	fcur, icur := &floatArrayCursor{}, &integerArrayCursor{}
+	itrs := cursors.CursorIterators{&cursorIterator{cur: fcur}, &cursorIterator{cur: icur}}
+
+	if _, err := itrs.NewMergeCursor(context.Background(), &cursors.CursorRequest{}, 0); err != cursors.ErrMergeTypeConflict {
+		t.Fatalf("unexpected error: got %v, exp %v", err, cursors.ErrMergeTypeConflict)
+	}
+	if !fcur.closed || !icur.closed {
+		t.Fatal("expected cursors to be closed")
+	}
+}
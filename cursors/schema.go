@@ -14,9 +14,15 @@ const (
 	Unsigned                   // means the data type is an unsigned integer
 	String                     // means the data type is a string of text
 	Boolean                    // means the data type is a boolean
+	Sketch                     // means the data type is a quantile sketch
 	Undefined                  // means the data type in unknown or undefined
 )
 
+// SketchDataType is the influxql DataType sketch fields are recorded with.
+// InfluxQL has no sketch type, so it is outside the range of the types it defines
+// and sketch fields can only be read with array cursors.
+const SketchDataType = influxql.DataType(64)
+
 var (
 	fieldTypeToDataTypeMapping = [8]influxql.DataType{
 		Float:     influxql.Float,
@@ -24,8 +30,8 @@ var (
 		Unsigned:  influxql.Unsigned,
 		String:    influxql.String,
 		Boolean:   influxql.Boolean,
+		Sketch:    SketchDataType,
 		Undefined: influxql.Unknown,
-		6:         influxql.Unknown,
 		7:         influxql.Unknown,
 	}
 )
@@ -17,6 +17,7 @@ func TestFieldTypeDataTypePrecedenceEquivalence(t *testing.T) {
 		cursors.Unsigned,
 		cursors.Boolean,
 		cursors.String,
+		cursors.Sketch,
 		cursors.Undefined,
 	}
 
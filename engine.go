@@ -76,11 +76,26 @@ type Engine interface {
 	IsIdle() (bool, string)
 	Free() error
 
+	Compactions() []CompactionStatus
+	CancelCompaction(id uint64) bool
+
 	Reindex() error
+	VerifyIndex(ctx context.Context) (*IndexVerifyReport, error)
+	RebuildIndex(ctx context.Context) error
+
+	ArchiveWAL(w io.Writer) (io.Closer, error)
+	ReplayWALArchive(ctx context.Context, shard WALReplayer, r io.Reader, asOf time.Time) (int, error)
 
 	io.WriterTo
 }
 
+// WALReplayer is the part of a shard WAL entries are replayed against.  It is
+// implemented by *Shard.
+type WALReplayer interface {
+	WritePoints(ctx context.Context, points []models.Point) error
+	DeleteSeriesRange(ctx context.Context, itr SeriesIterator, min, max int64) error
+}
+
 // SeriesIDSets provides access to the total set of series IDs
 type SeriesIDSets interface {
 	ForEach(f func(ids *SeriesIDSet)) error
@@ -159,13 +174,21 @@ type EngineOptions struct {
 
 	// CompactionDisabled specifies shards should not schedule compactions.
 	// This option is intended for offline tooling.
-	CompactionDisabled          bool
-	CompactionPlannerCreator    CompactionPlannerCreator
-	CompactionLimiter           limiter.Fixed
+	CompactionDisabled       bool
+	CompactionPlannerCreator CompactionPlannerCreator
+	CompactionLimiter        limiter.Fixed
+	// CompactionScheduler decides when the compactions of all shards may start.
+	// If it is nil, compactions start in order up to the capacity of CompactionLimiter.
+	CompactionScheduler         CompactionScheduler
 	CompactionThroughputLimiter limiter.Rate
 	WALEnabled                  bool
 	MonitorDisabled             bool
 
+	// ReadOnly opens stores, shards and engines without creating, renaming or
+	// deleting any file.  Writes, deletes and compactions are rejected with a
+	// ReadOnlyError.  This option is intended for offline tooling.
+	ReadOnly bool
+
 	// DatabaseFilter is a predicate controlling which databases may be opened.
 	// If no function is set, all databases will be opened.
 	DatabaseFilter func(database string) bool
@@ -181,6 +204,23 @@ type EngineOptions struct {
 	Config       Config
 	SeriesIDSets SeriesIDSets
 
+	// CacheMemoryGovernor limits the memory used by the caches of all shards.
+	// It is nil if the caches are only limited individually.
+	CacheMemoryGovernor *CacheMemoryGovernor
+
+	// BlockCache caches the blocks decoded from the TSM files of all shards.
+	// Blocks are not cached if it is nil.
+	BlockCache *BlockCache
+
+	// SeriesIDSetCacheBudget limits the memory used by the series ID set caches
+	// of all indexes.  It is nil if the caches are only limited individually.
+	SeriesIDSetCacheBudget *SeriesIDSetCacheBudget
+
+	// SeriesRetention holds the series retention rules of the retention policy
+	// of the shard.  It is shared by all shards of the retention policy, and is
+	// nil if the shard has no rules.
+	SeriesRetention *SeriesRetention
+
 	OnNewEngine func(Engine)
 
 	FileStoreObserver FileStoreObserver
@@ -148,3 +148,30 @@ func (q *arrayCursorIterator) buildBooleanArrayCursor(ctx context.Context, name
 		return q.desc.Boolean, nil
 	}
 }
+
+// buildSketchArrayCursor creates an array cursor for a sketch field.
+func (q *arrayCursorIterator) buildSketchArrayCursor(ctx context.Context, name []byte, tags models.Tags, field string, opt query.IteratorOptions) (tsdb.SketchArrayCursor, error) {
+	var err error
+	key := q.seriesFieldKeyBytes(name, tags, field)
+	cacheValues := q.e.Cache.Values(key)
+	keyCursor := q.e.KeyCursor(ctx, key, opt.SeekTime(), opt.Ascending)
+	if opt.Ascending {
+		if q.asc.Sketch == nil {
+			q.asc.Sketch = newSketchArrayAscendingCursor()
+		}
+		err = q.asc.Sketch.reset(opt.SeekTime(), opt.StopTime(), cacheValues, keyCursor)
+		if err != nil {
+			return nil, err
+		}
+		return q.asc.Sketch, nil
+	} else {
+		if q.desc.Sketch == nil {
+			q.desc.Sketch = newSketchArrayDescendingCursor()
+		}
+		err = q.desc.Sketch.reset(opt.SeekTime(), opt.StopTime(), cacheValues, keyCursor)
+		if err != nil {
+			return nil, err
+		}
+		return q.desc.Sketch, nil
+	}
+}
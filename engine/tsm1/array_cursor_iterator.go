@@ -8,6 +8,7 @@ import (
 	"github.com/influxdata/influxdb/v2/models"
 	"github.com/influxdata/influxdb/v2/pkg/metrics"
 	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxdb/v2/tsdb/cursors"
 	"github.com/influxdata/influxql"
 )
 
@@ -21,6 +22,7 @@ type arrayCursorIterator struct {
 		Unsigned *unsignedArrayAscendingCursor
 		Boolean  *booleanArrayAscendingCursor
 		String   *stringArrayAscendingCursor
+		Sketch   *sketchArrayAscendingCursor
 	}
 
 	desc struct {
@@ -29,6 +31,7 @@ type arrayCursorIterator struct {
 		Unsigned *unsignedArrayDescendingCursor
 		Boolean  *booleanArrayDescendingCursor
 		String   *stringArrayDescendingCursor
+		Sketch   *sketchArrayDescendingCursor
 	}
 }
 
@@ -54,6 +57,10 @@ func (q *arrayCursorIterator) Next(ctx context.Context, r *tsdb.CursorRequest) (
 		grp.GetCounter(numberOfRefCursorsCounter).Add(1)
 	}
 
+	if r.Aggregate != cursors.AggregateNone {
+		return q.buildWindowArrayCursor(ctx, r, f.Type)
+	}
+
 	var opt query.IteratorOptions
 	opt.Ascending = r.Ascending
 	opt.StartTime = r.StartTime
@@ -71,6 +78,8 @@ func (q *arrayCursorIterator) Next(ctx context.Context, r *tsdb.CursorRequest) (
 		return q.buildStringArrayCursor(ctx, r.Name, r.Tags, r.Field, opt)
 	case influxql.Boolean:
 		return q.buildBooleanArrayCursor(ctx, r.Name, r.Tags, r.Field, opt)
+	case cursors.SketchDataType:
+		return q.buildSketchArrayCursor(ctx, r.Name, r.Tags, r.Field, opt)
 	default:
 		panic(fmt.Sprintf("unreachable: %T", f.Type))
 	}
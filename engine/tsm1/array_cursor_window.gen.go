@@ -0,0 +1,923 @@
+// Generated by tmpl
+// https://github.com/benbjohnson/tmpl
+//
+// DO NOT EDIT!
+// Source: array_cursor_window.gen.go.tmpl
+
+package tsm1
+
+import (
+	"fmt"
+
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxdb/v2/tsdb/cursors"
+	"github.com/influxdata/influxdb/v2/tsdb/sketch"
+)
+
+// newFloatWindowArrayCursor returns a cursor computing agg for the windows
+// of the values of the ascending cursor cur.
+func newFloatWindowArrayCursor(cur tsdb.FloatArrayCursor, agg cursors.Aggregate, w arrayWindow) (tsdb.Cursor, error) {
+	r := floatWindowReader{cur: cur, w: w}
+	switch agg {
+	case cursors.AggregateCount:
+		return &floatWindowCountArrayCursor{floatWindowReader: r, res: tsdb.NewIntegerArrayLen(tsdb.DefaultMaxPointsPerBlock)}, nil
+	case cursors.AggregateMean:
+		return &floatWindowMeanArrayCursor{floatWindowReader: r, res: tsdb.NewFloatArrayLen(tsdb.DefaultMaxPointsPerBlock)}, nil
+	case cursors.AggregateSum, cursors.AggregateMin, cursors.AggregateMax, cursors.AggregateFirst, cursors.AggregateLast:
+		return &floatWindowArrayCursor{floatWindowReader: r, agg: agg, res: tsdb.NewFloatArrayLen(tsdb.DefaultMaxPointsPerBlock)}, nil
+	}
+	cur.Close()
+	return nil, fmt.Errorf("unsupported aggregate %s for float field", agg)
+}
+
+// floatWindowReader reads the values of an ascending float array cursor
+// window by window.
+type floatWindowReader struct {
+	cur  tsdb.FloatArrayCursor
+	w    arrayWindow
+	a    *tsdb.FloatArray
+	i    int
+	done bool
+}
+
+// load returns true if there is a value at r.i, reading the next array from the
+// cursor if needed.
+func (r *floatWindowReader) load() bool {
+	for !r.done && r.i >= r.a.Len() {
+		r.a, r.i = r.cur.Next(), 0
+		r.done = r.a.Len() == 0
+	}
+	return !r.done
+}
+
+// window returns the bounds of the window of the next value, or false if there
+// are no values left.
+func (r *floatWindowReader) window() (start, stop int64, ok bool) {
+	if !r.load() {
+		return 0, 0, false
+	}
+	start, stop = r.w.bounds(r.a.Timestamps[r.i])
+	return start, stop, true
+}
+
+// run returns the range [i, j) of the values of r.a up to stop, or an empty
+// range if the window ending at stop has no values left.  The values must be
+// used before run is called again.
+func (r *floatWindowReader) run(stop int64) (i, j int) {
+	if !r.load() {
+		return 0, 0
+	}
+	ts := r.a.Timestamps
+	i, j = r.i, r.i
+	for j < len(ts) && ts[j] <= stop {
+		j++
+	}
+	r.i = j
+	return i, j
+}
+
+func (r *floatWindowReader) Close()                  { r.cur.Close() }
+func (r *floatWindowReader) Err() error              { return r.cur.Err() }
+func (r *floatWindowReader) Stats() tsdb.CursorStats { return r.cur.Stats() }
+
+// floatWindowArrayCursor returns the sum, min, max, first or last float value of
+// each window.
+type floatWindowArrayCursor struct {
+	floatWindowReader
+	agg cursors.Aggregate
+	res *tsdb.FloatArray
+}
+
+func (c *floatWindowArrayCursor) Next() *tsdb.FloatArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		start, stop, ok := c.window()
+		if !ok {
+			break
+		}
+
+		n := len(c.res.Timestamps)
+		for i, j := c.run(stop); i < j; i, j = c.run(stop) {
+			ts, vs := c.a.Timestamps[i:j], c.a.Values[i:j]
+			if len(c.res.Timestamps) == n {
+				c.res.Timestamps = append(c.res.Timestamps, ts[0])
+				c.res.Values = append(c.res.Values, vs[0])
+				ts, vs = ts[1:], vs[1:]
+			}
+			if len(vs) == 0 {
+				continue
+			}
+
+			switch c.agg {
+			case cursors.AggregateSum:
+				for _, v := range vs {
+					c.res.Values[n] += v
+				}
+			case cursors.AggregateMin:
+				for k, v := range vs {
+					if v < c.res.Values[n] {
+						c.res.Timestamps[n], c.res.Values[n] = ts[k], v
+					}
+				}
+			case cursors.AggregateMax:
+				for k, v := range vs {
+					if v > c.res.Values[n] {
+						c.res.Timestamps[n], c.res.Values[n] = ts[k], v
+					}
+				}
+			case cursors.AggregateLast:
+				c.res.Timestamps[n], c.res.Values[n] = ts[len(ts)-1], vs[len(vs)-1]
+			}
+		}
+
+		if c.agg == cursors.AggregateSum {
+			c.res.Timestamps[n] = c.w.timestamp(start)
+		}
+	}
+	return c.res
+}
+
+// floatWindowCountArrayCursor returns the number of float values in each window.
+type floatWindowCountArrayCursor struct {
+	floatWindowReader
+	res *tsdb.IntegerArray
+}
+
+func (c *floatWindowCountArrayCursor) Next() *tsdb.IntegerArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		start, stop, ok := c.window()
+		if !ok {
+			break
+		}
+
+		var count int64
+		for i, j := c.run(stop); i < j; i, j = c.run(stop) {
+			count += int64(j - i)
+		}
+		c.res.Timestamps = append(c.res.Timestamps, c.w.timestamp(start))
+		c.res.Values = append(c.res.Values, count)
+	}
+	return c.res
+}
+
+// floatWindowMeanArrayCursor returns the mean of the float values in each window.
+type floatWindowMeanArrayCursor struct {
+	floatWindowReader
+	res *tsdb.FloatArray
+}
+
+func (c *floatWindowMeanArrayCursor) Next() *tsdb.FloatArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		start, stop, ok := c.window()
+		if !ok {
+			break
+		}
+
+		var sum float64
+		var count int
+		for i, j := c.run(stop); i < j; i, j = c.run(stop) {
+			for _, v := range c.a.Values[i:j] {
+				sum += float64(v)
+			}
+			count += j - i
+		}
+		c.res.Timestamps = append(c.res.Timestamps, c.w.timestamp(start))
+		c.res.Values = append(c.res.Values, sum/float64(count))
+	}
+	return c.res
+}
+
+// newIntegerWindowArrayCursor returns a cursor computing agg for the windows
+// of the values of the ascending cursor cur.
+func newIntegerWindowArrayCursor(cur tsdb.IntegerArrayCursor, agg cursors.Aggregate, w arrayWindow) (tsdb.Cursor, error) {
+	r := integerWindowReader{cur: cur, w: w}
+	switch agg {
+	case cursors.AggregateCount:
+		return &integerWindowCountArrayCursor{integerWindowReader: r, res: tsdb.NewIntegerArrayLen(tsdb.DefaultMaxPointsPerBlock)}, nil
+	case cursors.AggregateMean:
+		return &integerWindowMeanArrayCursor{integerWindowReader: r, res: tsdb.NewFloatArrayLen(tsdb.DefaultMaxPointsPerBlock)}, nil
+	case cursors.AggregateSum, cursors.AggregateMin, cursors.AggregateMax, cursors.AggregateFirst, cursors.AggregateLast:
+		return &integerWindowArrayCursor{integerWindowReader: r, agg: agg, res: tsdb.NewIntegerArrayLen(tsdb.DefaultMaxPointsPerBlock)}, nil
+	}
+	cur.Close()
+	return nil, fmt.Errorf("unsupported aggregate %s for integer field", agg)
+}
+
+// integerWindowReader reads the values of an ascending integer array cursor
+// window by window.
+type integerWindowReader struct {
+	cur  tsdb.IntegerArrayCursor
+	w    arrayWindow
+	a    *tsdb.IntegerArray
+	i    int
+	done bool
+}
+
+// load returns true if there is a value at r.i, reading the next array from the
+// cursor if needed.
+func (r *integerWindowReader) load() bool {
+	for !r.done && r.i >= r.a.Len() {
+		r.a, r.i = r.cur.Next(), 0
+		r.done = r.a.Len() == 0
+	}
+	return !r.done
+}
+
+// window returns the bounds of the window of the next value, or false if there
+// are no values left.
+func (r *integerWindowReader) window() (start, stop int64, ok bool) {
+	if !r.load() {
+		return 0, 0, false
+	}
+	start, stop = r.w.bounds(r.a.Timestamps[r.i])
+	return start, stop, true
+}
+
+// run returns the range [i, j) of the values of r.a up to stop, or an empty
+// range if the window ending at stop has no values left.  The values must be
+// used before run is called again.
+func (r *integerWindowReader) run(stop int64) (i, j int) {
+	if !r.load() {
+		return 0, 0
+	}
+	ts := r.a.Timestamps
+	i, j = r.i, r.i
+	for j < len(ts) && ts[j] <= stop {
+		j++
+	}
+	r.i = j
+	return i, j
+}
+
+func (r *integerWindowReader) Close()                  { r.cur.Close() }
+func (r *integerWindowReader) Err() error              { return r.cur.Err() }
+func (r *integerWindowReader) Stats() tsdb.CursorStats { return r.cur.Stats() }
+
+// integerWindowArrayCursor returns the sum, min, max, first or last integer value of
+// each window.
+type integerWindowArrayCursor struct {
+	integerWindowReader
+	agg cursors.Aggregate
+	res *tsdb.IntegerArray
+}
+
+func (c *integerWindowArrayCursor) Next() *tsdb.IntegerArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		start, stop, ok := c.window()
+		if !ok {
+			break
+		}
+
+		n := len(c.res.Timestamps)
+		for i, j := c.run(stop); i < j; i, j = c.run(stop) {
+			ts, vs := c.a.Timestamps[i:j], c.a.Values[i:j]
+			if len(c.res.Timestamps) == n {
+				c.res.Timestamps = append(c.res.Timestamps, ts[0])
+				c.res.Values = append(c.res.Values, vs[0])
+				ts, vs = ts[1:], vs[1:]
+			}
+			if len(vs) == 0 {
+				continue
+			}
+
+			switch c.agg {
+			case cursors.AggregateSum:
+				for _, v := range vs {
+					c.res.Values[n] += v
+				}
+			case cursors.AggregateMin:
+				for k, v := range vs {
+					if v < c.res.Values[n] {
+						c.res.Timestamps[n], c.res.Values[n] = ts[k], v
+					}
+				}
+			case cursors.AggregateMax:
+				for k, v := range vs {
+					if v > c.res.Values[n] {
+						c.res.Timestamps[n], c.res.Values[n] = ts[k], v
+					}
+				}
+			case cursors.AggregateLast:
+				c.res.Timestamps[n], c.res.Values[n] = ts[len(ts)-1], vs[len(vs)-1]
+			}
+		}
+
+		if c.agg == cursors.AggregateSum {
+			c.res.Timestamps[n] = c.w.timestamp(start)
+		}
+	}
+	return c.res
+}
+
+// integerWindowCountArrayCursor returns the number of integer values in each window.
+type integerWindowCountArrayCursor struct {
+	integerWindowReader
+	res *tsdb.IntegerArray
+}
+
+func (c *integerWindowCountArrayCursor) Next() *tsdb.IntegerArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		start, stop, ok := c.window()
+		if !ok {
+			break
+		}
+
+		var count int64
+		for i, j := c.run(stop); i < j; i, j = c.run(stop) {
+			count += int64(j - i)
+		}
+		c.res.Timestamps = append(c.res.Timestamps, c.w.timestamp(start))
+		c.res.Values = append(c.res.Values, count)
+	}
+	return c.res
+}
+
+// integerWindowMeanArrayCursor returns the mean of the integer values in each window.
+type integerWindowMeanArrayCursor struct {
+	integerWindowReader
+	res *tsdb.FloatArray
+}
+
+func (c *integerWindowMeanArrayCursor) Next() *tsdb.FloatArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		start, stop, ok := c.window()
+		if !ok {
+			break
+		}
+
+		var sum float64
+		var count int
+		for i, j := c.run(stop); i < j; i, j = c.run(stop) {
+			for _, v := range c.a.Values[i:j] {
+				sum += float64(v)
+			}
+			count += j - i
+		}
+		c.res.Timestamps = append(c.res.Timestamps, c.w.timestamp(start))
+		c.res.Values = append(c.res.Values, sum/float64(count))
+	}
+	return c.res
+}
+
+// newUnsignedWindowArrayCursor returns a cursor computing agg for the windows
+// of the values of the ascending cursor cur.
+func newUnsignedWindowArrayCursor(cur tsdb.UnsignedArrayCursor, agg cursors.Aggregate, w arrayWindow) (tsdb.Cursor, error) {
+	r := unsignedWindowReader{cur: cur, w: w}
+	switch agg {
+	case cursors.AggregateCount:
+		return &unsignedWindowCountArrayCursor{unsignedWindowReader: r, res: tsdb.NewIntegerArrayLen(tsdb.DefaultMaxPointsPerBlock)}, nil
+	case cursors.AggregateMean:
+		return &unsignedWindowMeanArrayCursor{unsignedWindowReader: r, res: tsdb.NewFloatArrayLen(tsdb.DefaultMaxPointsPerBlock)}, nil
+	case cursors.AggregateSum, cursors.AggregateMin, cursors.AggregateMax, cursors.AggregateFirst, cursors.AggregateLast:
+		return &unsignedWindowArrayCursor{unsignedWindowReader: r, agg: agg, res: tsdb.NewUnsignedArrayLen(tsdb.DefaultMaxPointsPerBlock)}, nil
+	}
+	cur.Close()
+	return nil, fmt.Errorf("unsupported aggregate %s for unsigned field", agg)
+}
+
+// unsignedWindowReader reads the values of an ascending unsigned array cursor
+// window by window.
+type unsignedWindowReader struct {
+	cur  tsdb.UnsignedArrayCursor
+	w    arrayWindow
+	a    *tsdb.UnsignedArray
+	i    int
+	done bool
+}
+
+// load returns true if there is a value at r.i, reading the next array from the
+// cursor if needed.
+func (r *unsignedWindowReader) load() bool {
+	for !r.done && r.i >= r.a.Len() {
+		r.a, r.i = r.cur.Next(), 0
+		r.done = r.a.Len() == 0
+	}
+	return !r.done
+}
+
+// window returns the bounds of the window of the next value, or false if there
+// are no values left.
+func (r *unsignedWindowReader) window() (start, stop int64, ok bool) {
+	if !r.load() {
+		return 0, 0, false
+	}
+	start, stop = r.w.bounds(r.a.Timestamps[r.i])
+	return start, stop, true
+}
+
+// run returns the range [i, j) of the values of r.a up to stop, or an empty
+// range if the window ending at stop has no values left.  The values must be
+// used before run is called again.
+func (r *unsignedWindowReader) run(stop int64) (i, j int) {
+	if !r.load() {
+		return 0, 0
+	}
+	ts := r.a.Timestamps
+	i, j = r.i, r.i
+	for j < len(ts) && ts[j] <= stop {
+		j++
+	}
+	r.i = j
+	return i, j
+}
+
+func (r *unsignedWindowReader) Close()                  { r.cur.Close() }
+func (r *unsignedWindowReader) Err() error              { return r.cur.Err() }
+func (r *unsignedWindowReader) Stats() tsdb.CursorStats { return r.cur.Stats() }
+
+// unsignedWindowArrayCursor returns the sum, min, max, first or last unsigned value of
+// each window.
+type unsignedWindowArrayCursor struct {
+	unsignedWindowReader
+	agg cursors.Aggregate
+	res *tsdb.UnsignedArray
+}
+
+func (c *unsignedWindowArrayCursor) Next() *tsdb.UnsignedArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		start, stop, ok := c.window()
+		if !ok {
+			break
+		}
+
+		n := len(c.res.Timestamps)
+		for i, j := c.run(stop); i < j; i, j = c.run(stop) {
+			ts, vs := c.a.Timestamps[i:j], c.a.Values[i:j]
+			if len(c.res.Timestamps) == n {
+				c.res.Timestamps = append(c.res.Timestamps, ts[0])
+				c.res.Values = append(c.res.Values, vs[0])
+				ts, vs = ts[1:], vs[1:]
+			}
+			if len(vs) == 0 {
+				continue
+			}
+
+			switch c.agg {
+			case cursors.AggregateSum:
+				for _, v := range vs {
+					c.res.Values[n] += v
+				}
+			case cursors.AggregateMin:
+				for k, v := range vs {
+					if v < c.res.Values[n] {
+						c.res.Timestamps[n], c.res.Values[n] = ts[k], v
+					}
+				}
+			case cursors.AggregateMax:
+				for k, v := range vs {
+					if v > c.res.Values[n] {
+						c.res.Timestamps[n], c.res.Values[n] = ts[k], v
+					}
+				}
+			case cursors.AggregateLast:
+				c.res.Timestamps[n], c.res.Values[n] = ts[len(ts)-1], vs[len(vs)-1]
+			}
+		}
+
+		if c.agg == cursors.AggregateSum {
+			c.res.Timestamps[n] = c.w.timestamp(start)
+		}
+	}
+	return c.res
+}
+
+// unsignedWindowCountArrayCursor returns the number of unsigned values in each window.
+type unsignedWindowCountArrayCursor struct {
+	unsignedWindowReader
+	res *tsdb.IntegerArray
+}
+
+func (c *unsignedWindowCountArrayCursor) Next() *tsdb.IntegerArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		start, stop, ok := c.window()
+		if !ok {
+			break
+		}
+
+		var count int64
+		for i, j := c.run(stop); i < j; i, j = c.run(stop) {
+			count += int64(j - i)
+		}
+		c.res.Timestamps = append(c.res.Timestamps, c.w.timestamp(start))
+		c.res.Values = append(c.res.Values, count)
+	}
+	return c.res
+}
+
+// unsignedWindowMeanArrayCursor returns the mean of the unsigned values in each window.
+type unsignedWindowMeanArrayCursor struct {
+	unsignedWindowReader
+	res *tsdb.FloatArray
+}
+
+func (c *unsignedWindowMeanArrayCursor) Next() *tsdb.FloatArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		start, stop, ok := c.window()
+		if !ok {
+			break
+		}
+
+		var sum float64
+		var count int
+		for i, j := c.run(stop); i < j; i, j = c.run(stop) {
+			for _, v := range c.a.Values[i:j] {
+				sum += float64(v)
+			}
+			count += j - i
+		}
+		c.res.Timestamps = append(c.res.Timestamps, c.w.timestamp(start))
+		c.res.Values = append(c.res.Values, sum/float64(count))
+	}
+	return c.res
+}
+
+// newStringWindowArrayCursor returns a cursor computing agg for the windows
+// of the values of the ascending cursor cur.
+func newStringWindowArrayCursor(cur tsdb.StringArrayCursor, agg cursors.Aggregate, w arrayWindow) (tsdb.Cursor, error) {
+	r := stringWindowReader{cur: cur, w: w}
+	switch agg {
+	case cursors.AggregateCount:
+		return &stringWindowCountArrayCursor{stringWindowReader: r, res: tsdb.NewIntegerArrayLen(tsdb.DefaultMaxPointsPerBlock)}, nil
+	case cursors.AggregateFirst, cursors.AggregateLast:
+		return &stringWindowArrayCursor{stringWindowReader: r, agg: agg, res: tsdb.NewStringArrayLen(tsdb.DefaultMaxPointsPerBlock)}, nil
+	}
+	cur.Close()
+	return nil, fmt.Errorf("unsupported aggregate %s for string field", agg)
+}
+
+// stringWindowReader reads the values of an ascending string array cursor
+// window by window.
+type stringWindowReader struct {
+	cur  tsdb.StringArrayCursor
+	w    arrayWindow
+	a    *tsdb.StringArray
+	i    int
+	done bool
+}
+
+// load returns true if there is a value at r.i, reading the next array from the
+// cursor if needed.
+func (r *stringWindowReader) load() bool {
+	for !r.done && r.i >= r.a.Len() {
+		r.a, r.i = r.cur.Next(), 0
+		r.done = r.a.Len() == 0
+	}
+	return !r.done
+}
+
+// window returns the bounds of the window of the next value, or false if there
+// are no values left.
+func (r *stringWindowReader) window() (start, stop int64, ok bool) {
+	if !r.load() {
+		return 0, 0, false
+	}
+	start, stop = r.w.bounds(r.a.Timestamps[r.i])
+	return start, stop, true
+}
+
+// run returns the range [i, j) of the values of r.a up to stop, or an empty
+// range if the window ending at stop has no values left.  The values must be
+// used before run is called again.
+func (r *stringWindowReader) run(stop int64) (i, j int) {
+	if !r.load() {
+		return 0, 0
+	}
+	ts := r.a.Timestamps
+	i, j = r.i, r.i
+	for j < len(ts) && ts[j] <= stop {
+		j++
+	}
+	r.i = j
+	return i, j
+}
+
+func (r *stringWindowReader) Close()                  { r.cur.Close() }
+func (r *stringWindowReader) Err() error              { return r.cur.Err() }
+func (r *stringWindowReader) Stats() tsdb.CursorStats { return r.cur.Stats() }
+
+// stringWindowArrayCursor returns the first or last string value of
+// each window.
+type stringWindowArrayCursor struct {
+	stringWindowReader
+	agg cursors.Aggregate
+	res *tsdb.StringArray
+}
+
+func (c *stringWindowArrayCursor) Next() *tsdb.StringArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		_, stop, ok := c.window()
+		if !ok {
+			break
+		}
+
+		n := len(c.res.Timestamps)
+		for i, j := c.run(stop); i < j; i, j = c.run(stop) {
+			ts, vs := c.a.Timestamps[i:j], c.a.Values[i:j]
+			if len(c.res.Timestamps) == n {
+				c.res.Timestamps = append(c.res.Timestamps, ts[0])
+				c.res.Values = append(c.res.Values, vs[0])
+				ts, vs = ts[1:], vs[1:]
+			}
+			if len(vs) == 0 {
+				continue
+			}
+
+			switch c.agg {
+			case cursors.AggregateLast:
+				c.res.Timestamps[n], c.res.Values[n] = ts[len(ts)-1], vs[len(vs)-1]
+			}
+		}
+	}
+	return c.res
+}
+
+// stringWindowCountArrayCursor returns the number of string values in each window.
+type stringWindowCountArrayCursor struct {
+	stringWindowReader
+	res *tsdb.IntegerArray
+}
+
+func (c *stringWindowCountArrayCursor) Next() *tsdb.IntegerArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		start, stop, ok := c.window()
+		if !ok {
+			break
+		}
+
+		var count int64
+		for i, j := c.run(stop); i < j; i, j = c.run(stop) {
+			count += int64(j - i)
+		}
+		c.res.Timestamps = append(c.res.Timestamps, c.w.timestamp(start))
+		c.res.Values = append(c.res.Values, count)
+	}
+	return c.res
+}
+
+// newBooleanWindowArrayCursor returns a cursor computing agg for the windows
+// of the values of the ascending cursor cur.
+func newBooleanWindowArrayCursor(cur tsdb.BooleanArrayCursor, agg cursors.Aggregate, w arrayWindow) (tsdb.Cursor, error) {
+	r := booleanWindowReader{cur: cur, w: w}
+	switch agg {
+	case cursors.AggregateCount:
+		return &booleanWindowCountArrayCursor{booleanWindowReader: r, res: tsdb.NewIntegerArrayLen(tsdb.DefaultMaxPointsPerBlock)}, nil
+	case cursors.AggregateFirst, cursors.AggregateLast:
+		return &booleanWindowArrayCursor{booleanWindowReader: r, agg: agg, res: tsdb.NewBooleanArrayLen(tsdb.DefaultMaxPointsPerBlock)}, nil
+	}
+	cur.Close()
+	return nil, fmt.Errorf("unsupported aggregate %s for boolean field", agg)
+}
+
+// booleanWindowReader reads the values of an ascending boolean array cursor
+// window by window.
+type booleanWindowReader struct {
+	cur  tsdb.BooleanArrayCursor
+	w    arrayWindow
+	a    *tsdb.BooleanArray
+	i    int
+	done bool
+}
+
+// load returns true if there is a value at r.i, reading the next array from the
+// cursor if needed.
+func (r *booleanWindowReader) load() bool {
+	for !r.done && r.i >= r.a.Len() {
+		r.a, r.i = r.cur.Next(), 0
+		r.done = r.a.Len() == 0
+	}
+	return !r.done
+}
+
+// window returns the bounds of the window of the next value, or false if there
+// are no values left.
+func (r *booleanWindowReader) window() (start, stop int64, ok bool) {
+	if !r.load() {
+		return 0, 0, false
+	}
+	start, stop = r.w.bounds(r.a.Timestamps[r.i])
+	return start, stop, true
+}
+
+// run returns the range [i, j) of the values of r.a up to stop, or an empty
+// range if the window ending at stop has no values left.  The values must be
+// used before run is called again.
+func (r *booleanWindowReader) run(stop int64) (i, j int) {
+	if !r.load() {
+		return 0, 0
+	}
+	ts := r.a.Timestamps
+	i, j = r.i, r.i
+	for j < len(ts) && ts[j] <= stop {
+		j++
+	}
+	r.i = j
+	return i, j
+}
+
+func (r *booleanWindowReader) Close()                  { r.cur.Close() }
+func (r *booleanWindowReader) Err() error              { return r.cur.Err() }
+func (r *booleanWindowReader) Stats() tsdb.CursorStats { return r.cur.Stats() }
+
+// booleanWindowArrayCursor returns the first or last boolean value of
+// each window.
+type booleanWindowArrayCursor struct {
+	booleanWindowReader
+	agg cursors.Aggregate
+	res *tsdb.BooleanArray
+}
+
+func (c *booleanWindowArrayCursor) Next() *tsdb.BooleanArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		_, stop, ok := c.window()
+		if !ok {
+			break
+		}
+
+		n := len(c.res.Timestamps)
+		for i, j := c.run(stop); i < j; i, j = c.run(stop) {
+			ts, vs := c.a.Timestamps[i:j], c.a.Values[i:j]
+			if len(c.res.Timestamps) == n {
+				c.res.Timestamps = append(c.res.Timestamps, ts[0])
+				c.res.Values = append(c.res.Values, vs[0])
+				ts, vs = ts[1:], vs[1:]
+			}
+			if len(vs) == 0 {
+				continue
+			}
+
+			switch c.agg {
+			case cursors.AggregateLast:
+				c.res.Timestamps[n], c.res.Values[n] = ts[len(ts)-1], vs[len(vs)-1]
+			}
+		}
+	}
+	return c.res
+}
+
+// booleanWindowCountArrayCursor returns the number of boolean values in each window.
+type booleanWindowCountArrayCursor struct {
+	booleanWindowReader
+	res *tsdb.IntegerArray
+}
+
+func (c *booleanWindowCountArrayCursor) Next() *tsdb.IntegerArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		start, stop, ok := c.window()
+		if !ok {
+			break
+		}
+
+		var count int64
+		for i, j := c.run(stop); i < j; i, j = c.run(stop) {
+			count += int64(j - i)
+		}
+		c.res.Timestamps = append(c.res.Timestamps, c.w.timestamp(start))
+		c.res.Values = append(c.res.Values, count)
+	}
+	return c.res
+}
+
+// newSketchWindowArrayCursor returns a cursor computing agg for the windows
+// of the values of the ascending cursor cur.
+func newSketchWindowArrayCursor(cur tsdb.SketchArrayCursor, agg cursors.Aggregate, w arrayWindow) (tsdb.Cursor, error) {
+	r := sketchWindowReader{cur: cur, w: w}
+	switch agg {
+	case cursors.AggregateCount:
+		return &sketchWindowCountArrayCursor{sketchWindowReader: r, res: tsdb.NewIntegerArrayLen(tsdb.DefaultMaxPointsPerBlock)}, nil
+	case cursors.AggregateSum, cursors.AggregateFirst, cursors.AggregateLast:
+		return &sketchWindowArrayCursor{sketchWindowReader: r, agg: agg, res: tsdb.NewSketchArrayLen(tsdb.DefaultMaxPointsPerBlock)}, nil
+	}
+	cur.Close()
+	return nil, fmt.Errorf("unsupported aggregate %s for sketch field", agg)
+}
+
+// sketchWindowReader reads the values of an ascending sketch array cursor
+// window by window.
+type sketchWindowReader struct {
+	cur  tsdb.SketchArrayCursor
+	w    arrayWindow
+	a    *tsdb.SketchArray
+	i    int
+	done bool
+}
+
+// load returns true if there is a value at r.i, reading the next array from the
+// cursor if needed.
+func (r *sketchWindowReader) load() bool {
+	for !r.done && r.i >= r.a.Len() {
+		r.a, r.i = r.cur.Next(), 0
+		r.done = r.a.Len() == 0
+	}
+	return !r.done
+}
+
+// window returns the bounds of the window of the next value, or false if there
+// are no values left.
+func (r *sketchWindowReader) window() (start, stop int64, ok bool) {
+	if !r.load() {
+		return 0, 0, false
+	}
+	start, stop = r.w.bounds(r.a.Timestamps[r.i])
+	return start, stop, true
+}
+
+// run returns the range [i, j) of the values of r.a up to stop, or an empty
+// range if the window ending at stop has no values left.  The values must be
+// used before run is called again.
+func (r *sketchWindowReader) run(stop int64) (i, j int) {
+	if !r.load() {
+		return 0, 0
+	}
+	ts := r.a.Timestamps
+	i, j = r.i, r.i
+	for j < len(ts) && ts[j] <= stop {
+		j++
+	}
+	r.i = j
+	return i, j
+}
+
+func (r *sketchWindowReader) Close()                  { r.cur.Close() }
+func (r *sketchWindowReader) Err() error              { return r.cur.Err() }
+func (r *sketchWindowReader) Stats() tsdb.CursorStats { return r.cur.Stats() }
+
+// sketchWindowArrayCursor returns the first or last sketch value of
+// each window.  The sum of a window is the merge of its sketches.
+type sketchWindowArrayCursor struct {
+	sketchWindowReader
+	agg cursors.Aggregate
+	res *tsdb.SketchArray
+}
+
+func (c *sketchWindowArrayCursor) Next() *tsdb.SketchArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		start, stop, ok := c.window()
+		if !ok {
+			break
+		}
+
+		n := len(c.res.Timestamps)
+		for i, j := c.run(stop); i < j; i, j = c.run(stop) {
+			ts, vs := c.a.Timestamps[i:j], c.a.Values[i:j]
+			if len(c.res.Timestamps) == n {
+				c.res.Timestamps = append(c.res.Timestamps, ts[0])
+				c.res.Values = append(c.res.Values, vs[0])
+				ts, vs = ts[1:], vs[1:]
+			}
+			if len(vs) == 0 {
+				continue
+			}
+
+			switch c.agg {
+			case cursors.AggregateSum:
+				for _, v := range vs {
+					c.res.Values[n] = sketch.Merge(c.res.Values[n], v)
+				}
+			case cursors.AggregateLast:
+				c.res.Timestamps[n], c.res.Values[n] = ts[len(ts)-1], vs[len(vs)-1]
+			}
+		}
+
+		if c.agg == cursors.AggregateSum {
+			c.res.Timestamps[n] = c.w.timestamp(start)
+		}
+	}
+	return c.res
+}
+
+// sketchWindowCountArrayCursor returns the number of sketch values in each window.
+type sketchWindowCountArrayCursor struct {
+	sketchWindowReader
+	res *tsdb.IntegerArray
+}
+
+func (c *sketchWindowCountArrayCursor) Next() *tsdb.IntegerArray {
+	c.res.Timestamps, c.res.Values = c.res.Timestamps[:0], c.res.Values[:0]
+	for len(c.res.Timestamps) < cap(c.res.Timestamps) {
+		start, stop, ok := c.window()
+		if !ok {
+			break
+		}
+
+		var count int64
+		for i, j := c.run(stop); i < j; i, j = c.run(stop) {
+			count += int64(j - i)
+		}
+		c.res.Timestamps = append(c.res.Timestamps, c.w.timestamp(start))
+		c.res.Values = append(c.res.Values, count)
+	}
+	return c.res
+}
@@ -0,0 +1,99 @@
+package tsm1
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/influxdata/influxdb/v2/influxql/query"
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxdb/v2/tsdb/cursors"
+	"github.com/influxdata/influxql"
+)
+
+// arrayWindow divides the time range of a window aggregate cursor into windows.
+type arrayWindow struct {
+	start, end int64
+	every      int64
+}
+
+// bounds returns the first and last time of the window containing t.
+func (w arrayWindow) bounds(t int64) (start, stop int64) {
+	if w.every <= 0 {
+		return w.start, w.end
+	}
+
+	start = t - t%w.every
+	if t%w.every < 0 {
+		start -= w.every
+	}
+	stop = start + w.every - 1
+	if stop < start {
+		stop = math.MaxInt64
+	}
+	return start, stop
+}
+
+// timestamp returns the timestamp of the aggregate of the window starting at
+// start, which is the start of the window bounded by the start of the range.
+func (w arrayWindow) timestamp(start int64) int64 {
+	if start < w.start {
+		return w.start
+	}
+	return start
+}
+
+// buildWindowArrayCursor creates a cursor returning the aggregate requested by r
+// for the windows of a field of type typ.
+func (q *arrayCursorIterator) buildWindowArrayCursor(ctx context.Context, r *tsdb.CursorRequest, typ influxql.DataType) (tsdb.Cursor, error) {
+	if r.Every < 0 {
+		return nil, fmt.Errorf("invalid aggregate window duration %d", r.Every)
+	}
+
+	var opt query.IteratorOptions
+	opt.Ascending = true
+	opt.StartTime = r.StartTime
+	opt.EndTime = r.EndTime // inclusive
+
+	w := arrayWindow{start: r.StartTime, end: r.EndTime, every: r.Every}
+	switch typ {
+	case influxql.Float:
+		cur, err := q.buildFloatArrayCursor(ctx, r.Name, r.Tags, r.Field, opt)
+		if err != nil {
+			return nil, err
+		}
+		return newFloatWindowArrayCursor(cur, r.Aggregate, w)
+	case influxql.Integer:
+		cur, err := q.buildIntegerArrayCursor(ctx, r.Name, r.Tags, r.Field, opt)
+		if err != nil {
+			return nil, err
+		}
+		return newIntegerWindowArrayCursor(cur, r.Aggregate, w)
+	case influxql.Unsigned:
+		cur, err := q.buildUnsignedArrayCursor(ctx, r.Name, r.Tags, r.Field, opt)
+		if err != nil {
+			return nil, err
+		}
+		return newUnsignedWindowArrayCursor(cur, r.Aggregate, w)
+	case influxql.String:
+		cur, err := q.buildStringArrayCursor(ctx, r.Name, r.Tags, r.Field, opt)
+		if err != nil {
+			return nil, err
+		}
+		return newStringWindowArrayCursor(cur, r.Aggregate, w)
+	case influxql.Boolean:
+		cur, err := q.buildBooleanArrayCursor(ctx, r.Name, r.Tags, r.Field, opt)
+		if err != nil {
+			return nil, err
+		}
+		return newBooleanWindowArrayCursor(cur, r.Aggregate, w)
+	case cursors.SketchDataType:
+		cur, err := q.buildSketchArrayCursor(ctx, r.Name, r.Tags, r.Field, opt)
+		if err != nil {
+			return nil, err
+		}
+		return newSketchWindowArrayCursor(cur, r.Aggregate, w)
+	default:
+		panic(fmt.Sprintf("unreachable: %T", typ))
+	}
+}
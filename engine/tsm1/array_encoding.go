@@ -110,3 +110,24 @@ func DecodeStringArrayBlock(block []byte, a *tsdb.StringArray) error {
 	a.Values, err = StringArrayDecodeAll(vb, a.Values)
 	return err
 }
+
+// DecodeSketchArrayBlock decodes the sketch block from the byte slice
+// and writes the values to a.
+func DecodeSketchArrayBlock(block []byte, a *tsdb.SketchArray) error {
+	blockType := block[0]
+	if blockType != BlockSketch {
+		return fmt.Errorf("invalid block type: exp %d, got %d", BlockSketch, blockType)
+	}
+
+	tb, vb, err := unpackBlock(block[1:])
+	if err != nil {
+		return err
+	}
+
+	a.Timestamps, err = TimeArrayDecodeAll(tb, a.Timestamps)
+	if err != nil {
+		return err
+	}
+	a.Values, err = SketchArrayDecodeAll(vb, a.Values)
+	return err
+}
@@ -0,0 +1,43 @@
+package tsm1
+
+import (
+	"github.com/influxdata/influxdb/v2/tsdb/sketch"
+)
+
+// SketchArrayEncodeAll encodes src into b, returning b and any error encountered.
+// The returned slice may be of a different length and capacity to b.
+func SketchArrayEncodeAll(src []*sketch.Sketch, b []byte) ([]byte, error) {
+	enc := getSketchEncoder(len(src))
+	for _, s := range src {
+		enc.Write(s)
+	}
+	vb, err := enc.Bytes()
+	putSketchEncoder(enc)
+	if err != nil {
+		return b[:0], err
+	}
+	return append(b[:0], vb...), nil
+}
+
+// SketchArrayDecodeAll decodes the sketches in b into dst, returning dst and any
+// error encountered.  The returned slice may be of a different length and capacity
+// to dst.
+func SketchArrayDecodeAll(b []byte, dst []*sketch.Sketch) ([]*sketch.Sketch, error) {
+	dst = dst[:0]
+	if len(b) == 0 {
+		return dst, nil
+	}
+
+	var dec SketchDecoder
+	if err := dec.SetBytes(b); err != nil {
+		return dst, err
+	}
+	for dec.Next() {
+		s := dec.Read()
+		if err := dec.Error(); err != nil {
+			return dst, err
+		}
+		dst = append(dst, s)
+	}
+	return dst, dec.Error()
+}
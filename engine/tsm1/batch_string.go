@@ -8,6 +8,7 @@ import (
 	"unsafe"
 
 	"github.com/golang/snappy"
+	"github.com/influxdata/influxdb/v2/tsdb"
 )
 
 var (
@@ -77,15 +78,47 @@ func StringArrayEncodeAll(src []string, b []byte) ([]byte, error) {
 	return dst[:len(res)+1], nil
 }
 
+// StringArrayEncodeAllUsing encodes src into b using codec to compress the
+// strings, returning b and any error encountered. A nil codec uses snappy.
+func StringArrayEncodeAllUsing(src []string, b []byte, codec tsdb.BlockCodec) ([]byte, error) {
+	if codec == nil || codec.ID() == stringCompressedSnappy {
+		return StringArrayEncodeAll(src, b)
+	}
+
+	srcSz64 := int64(len(src) * binary.MaxVarintLen32)
+	for i := range src {
+		srcSz64 += int64(len(src[i]))
+	}
+
+	// 32-bit systems
+	if srcSz64 > math.MaxUint32 {
+		return b[:0], ErrStringArrayEncodeTooLarge
+	}
+
+	dta := make([]byte, srcSz64)
+	n := 0
+	for i := range src {
+		n += binary.PutUvarint(dta[n:], uint64(len(src[i])))
+		n += copy(dta[n:], src[i])
+	}
+
+	res, err := codec.Encode(nil, dta[:n])
+	if err != nil {
+		return b[:0], err
+	}
+
+	b = append(b[:0], codec.ID()<<4)
+	return append(b, res...), nil
+}
+
 func StringArrayDecodeAll(b []byte, dst []string) ([]string, error) {
-	// First byte stores the encoding type, only have snappy format
-	// currently so ignore for now.
+	// First byte stores the encoding type.
 	if len(b) > 0 {
 		var err error
-		// it is important that to note that `snappy.Decode` always returns
+		// it is important that to note that decoding always returns
 		// a newly allocated slice as the final strings reference this slice
 		// directly.
-		b, err = snappy.Decode(nil, b[1:])
+		b, err = decodeStringBlockBytes(b)
 		if err != nil {
 			return []string{}, fmt.Errorf("failed to decode string block: %v", err.Error())
 		}
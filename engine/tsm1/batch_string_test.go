@@ -10,6 +10,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/influxdata/influxdb/v2/internal/testutil"
+	"github.com/influxdata/influxdb/v2/tsdb"
 	"github.com/influxdata/influxdb/v2/uuid"
 )
 
@@ -107,6 +108,37 @@ func TestStringArrayEncode_Compare(t *testing.T) {
 	}
 }
 
+func TestStringArrayEncodeAllUsing_Codecs(t *testing.T) {
+	src := make([]string, 100)
+	for i := range src {
+		src[i] = fmt.Sprintf("log line %d: request completed", i)
+	}
+
+	for _, name := range tsdb.RegisteredBlockCodecs() {
+		t.Run(name, func(t *testing.T) {
+			codec := tsdb.BlockCodecByName(name)
+
+			b, err := StringArrayEncodeAllUsing(src, nil, codec)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got, exp := b[0]>>4, codec.ID(); got != exp {
+				t.Fatalf("unexpected encoding: got %v, exp %v", got, exp)
+			}
+
+			got, err := StringArrayDecodeAll(b, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !cmp.Equal(got, src) {
+				t.Fatalf("unexpected values: -got/+exp\n%s", cmp.Diff(got, src))
+			}
+		})
+	}
+}
+
 func TestStringArrayEncodeAll_Multi_Compressed(t *testing.T) {
 	src := make([]string, 10)
 	for i := range src {
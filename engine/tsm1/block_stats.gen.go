@@ -0,0 +1,586 @@
+// Generated by tmpl
+// https://github.com/benbjohnson/tmpl
+//
+// DO NOT EDIT!
+// Source: block_stats.gen.go.tmpl
+
+package tsm1
+
+import (
+	"context"
+	"math"
+
+	"github.com/influxdata/influxdb/v2/influxql/query"
+	"github.com/influxdata/influxdb/v2/tsdb"
+)
+
+// newValuesBlockStats returns the statistics of values, which are all of the
+// same type.  It returns false if they have no statistics.
+func newValuesBlockStats(values Values, bufs *blockStatsBuffers) (BlockStats, bool) {
+	if len(values) == 0 {
+		return BlockStats{}, false
+	}
+
+	switch values[0].(type) {
+	case FloatValue:
+		a := &bufs.float
+		a.Timestamps, a.Values = a.Timestamps[:0], a.Values[:0]
+		for _, v := range values {
+			a.Timestamps = append(a.Timestamps, v.UnixNano())
+			a.Values = append(a.Values, v.(FloatValue).RawValue())
+		}
+		return newFloatBlockStats(a)
+	case IntegerValue:
+		a := &bufs.integer
+		a.Timestamps, a.Values = a.Timestamps[:0], a.Values[:0]
+		for _, v := range values {
+			a.Timestamps = append(a.Timestamps, v.UnixNano())
+			a.Values = append(a.Values, v.(IntegerValue).RawValue())
+		}
+		return newIntegerBlockStats(a)
+	case UnsignedValue:
+		a := &bufs.unsigned
+		a.Timestamps, a.Values = a.Timestamps[:0], a.Values[:0]
+		for _, v := range values {
+			a.Timestamps = append(a.Timestamps, v.UnixNano())
+			a.Values = append(a.Values, v.(UnsignedValue).RawValue())
+		}
+		return newUnsignedBlockStats(a)
+	}
+	return BlockStats{}, false
+}
+
+// newFloatBlockStats returns the statistics of the values in a.
+func newFloatBlockStats(a *tsdb.FloatArray) (BlockStats, bool) {
+	if a.Len() == 0 {
+		return BlockStats{}, false
+	}
+
+	s := BlockStats{
+		MinTime: a.MinTime(),
+		MaxTime: a.MaxTime(),
+		Count:   uint32(a.Len()),
+		MinAt:   a.Timestamps[0],
+		MaxAt:   a.Timestamps[0],
+	}
+
+	var sum float64
+	min, max := a.Values[0], a.Values[0]
+	for i, v := range a.Values {
+		// Selecting among NaNs depends on the order values are compared in.
+		if math.IsNaN(v) {
+			return BlockStats{}, false
+		}
+		sum += v
+		if v < min {
+			min, s.MinAt = v, a.Timestamps[i]
+		}
+		if v > max {
+			max, s.MaxAt = v, a.Timestamps[i]
+		}
+	}
+
+	s.Sum, s.Min, s.Max = math.Float64bits(sum), math.Float64bits(min), math.Float64bits(max)
+	return s, true
+}
+
+// floatBlockStatsIterator merges the points of a float series with a point
+// standing for each block answered from its statistics.
+type floatBlockStatsIterator struct {
+	input     query.FloatIterator
+	buf       *query.FloatPoint
+	done      bool
+	stats     []BlockStats
+	call      string
+	ascending bool
+	point     query.FloatPoint
+	pointN    int
+}
+
+func newFloatBlockStatsIterator(input query.FloatIterator, name string, tags query.Tags, stats []BlockStats, call string, opt query.IteratorOptions) *floatBlockStatsIterator {
+	return &floatBlockStatsIterator{
+		input:     input,
+		stats:     stats,
+		call:      call,
+		ascending: opt.Ascending,
+		point: query.FloatPoint{
+			Name: name,
+			Tags: tags,
+		},
+	}
+}
+
+// Next returns the next point from the iterator.
+func (itr *floatBlockStatsIterator) Next() (*query.FloatPoint, error) {
+	if itr.buf == nil && !itr.done {
+		p, err := itr.input.Next()
+		if err != nil {
+			return nil, err
+		}
+		itr.buf, itr.done = p, p == nil
+	}
+
+	if len(itr.stats) == 0 || (itr.buf != nil && !blockStatsBefore(&itr.stats[0], itr.buf.Time, itr.ascending)) {
+		p := itr.buf
+		itr.buf = nil
+		return p, nil
+	}
+
+	s := &itr.stats[0]
+	itr.stats = itr.stats[1:]
+	itr.pointN += int(s.Count)
+
+	switch itr.call {
+	case "sum":
+		itr.point.Time, itr.point.Value = s.MinTime, math.Float64frombits(s.Sum)
+	case "min":
+		itr.point.Time, itr.point.Value = s.MinAt, math.Float64frombits(s.Min)
+	case "max":
+		itr.point.Time, itr.point.Value = s.MaxAt, math.Float64frombits(s.Max)
+	}
+	return &itr.point, nil
+}
+
+// Stats returns stats on the points processed.
+func (itr *floatBlockStatsIterator) Stats() query.IteratorStats {
+	stats := itr.input.Stats()
+	stats.PointN += itr.pointN
+	return stats
+}
+
+// Close closes the iterator.
+func (itr *floatBlockStatsIterator) Close() error { return itr.input.Close() }
+
+// floatBlockCountIterator returns a count of one for each point of a float
+// series and the count of points of each block answered from its statistics.
+type floatBlockCountIterator struct {
+	input     query.FloatIterator
+	buf       *query.FloatPoint
+	done      bool
+	stats     []BlockStats
+	ascending bool
+	point     query.IntegerPoint
+	pointN    int
+}
+
+func newFloatBlockCountIterator(input query.FloatIterator, name string, tags query.Tags, stats []BlockStats, opt query.IteratorOptions) *floatBlockCountIterator {
+	return &floatBlockCountIterator{
+		input:     input,
+		stats:     stats,
+		ascending: opt.Ascending,
+		point: query.IntegerPoint{
+			Name: name,
+			Tags: tags,
+		},
+	}
+}
+
+// Next returns the next point from the iterator.
+func (itr *floatBlockCountIterator) Next() (*query.IntegerPoint, error) {
+	if itr.buf == nil && !itr.done {
+		p, err := itr.input.Next()
+		if err != nil {
+			return nil, err
+		}
+		itr.buf, itr.done = p, p == nil
+	}
+
+	if len(itr.stats) == 0 || (itr.buf != nil && !blockStatsBefore(&itr.stats[0], itr.buf.Time, itr.ascending)) {
+		if itr.buf == nil {
+			return nil, nil
+		}
+		itr.point.Time, itr.point.Value = itr.buf.Time, 1
+		itr.buf = nil
+		return &itr.point, nil
+	}
+
+	s := &itr.stats[0]
+	itr.stats = itr.stats[1:]
+	itr.pointN += int(s.Count)
+
+	itr.point.Time, itr.point.Value = s.MinTime, int64(s.Count)
+	return &itr.point, nil
+}
+
+// Stats returns stats on the points processed.
+func (itr *floatBlockCountIterator) Stats() query.IteratorStats {
+	stats := itr.input.Stats()
+	stats.PointN += itr.pointN
+	return stats
+}
+
+// Close closes the iterator.
+func (itr *floatBlockCountIterator) Close() error { return itr.input.Close() }
+
+func (itr *floatBlockCountIterator) partialCounts() {}
+
+// buildFloatBlockStatsIterator creates an iterator for a count, sum, min or max
+// call over a float field that answers the blocks covered by the call from their
+// statistics instead of decoding them.
+func (e *Engine) buildFloatBlockStatsIterator(ctx context.Context, name string, tags query.Tags, seriesKey, field, call string, opt query.IteratorOptions) query.Iterator {
+	key := SeriesFieldKeyBytes(seriesKey, field)
+	cacheValues := e.Cache.Values(key)
+	keyCursor := e.KeyCursor(ctx, key, opt.SeekTime(), opt.Ascending)
+	stats := keyCursor.TakeBlockStats(opt.SeekTime(), blockStatsCovered(opt, cacheValues))
+
+	cur := newFloatCursor(opt.SeekTime(), opt.Ascending, cacheValues, keyCursor)
+	input := newFloatIterator(name, tags, opt, cur, nil, nil, nil)
+	if call == "count" {
+		return newFloatBlockCountIterator(input, name, tags, stats, opt)
+	}
+	return newFloatBlockStatsIterator(input, name, tags, stats, call, opt)
+}
+
+// newIntegerBlockStats returns the statistics of the values in a.
+func newIntegerBlockStats(a *tsdb.IntegerArray) (BlockStats, bool) {
+	if a.Len() == 0 {
+		return BlockStats{}, false
+	}
+
+	s := BlockStats{
+		MinTime: a.MinTime(),
+		MaxTime: a.MaxTime(),
+		Count:   uint32(a.Len()),
+		MinAt:   a.Timestamps[0],
+		MaxAt:   a.Timestamps[0],
+	}
+
+	var sum int64
+	min, max := a.Values[0], a.Values[0]
+	for i, v := range a.Values {
+		sum += v
+		if v < min {
+			min, s.MinAt = v, a.Timestamps[i]
+		}
+		if v > max {
+			max, s.MaxAt = v, a.Timestamps[i]
+		}
+	}
+
+	s.Sum, s.Min, s.Max = uint64(sum), uint64(min), uint64(max)
+	return s, true
+}
+
+// integerBlockStatsIterator merges the points of a integer series with a point
+// standing for each block answered from its statistics.
+type integerBlockStatsIterator struct {
+	input     query.IntegerIterator
+	buf       *query.IntegerPoint
+	done      bool
+	stats     []BlockStats
+	call      string
+	ascending bool
+	point     query.IntegerPoint
+	pointN    int
+}
+
+func newIntegerBlockStatsIterator(input query.IntegerIterator, name string, tags query.Tags, stats []BlockStats, call string, opt query.IteratorOptions) *integerBlockStatsIterator {
+	return &integerBlockStatsIterator{
+		input:     input,
+		stats:     stats,
+		call:      call,
+		ascending: opt.Ascending,
+		point: query.IntegerPoint{
+			Name: name,
+			Tags: tags,
+		},
+	}
+}
+
+// Next returns the next point from the iterator.
+func (itr *integerBlockStatsIterator) Next() (*query.IntegerPoint, error) {
+	if itr.buf == nil && !itr.done {
+		p, err := itr.input.Next()
+		if err != nil {
+			return nil, err
+		}
+		itr.buf, itr.done = p, p == nil
+	}
+
+	if len(itr.stats) == 0 || (itr.buf != nil && !blockStatsBefore(&itr.stats[0], itr.buf.Time, itr.ascending)) {
+		p := itr.buf
+		itr.buf = nil
+		return p, nil
+	}
+
+	s := &itr.stats[0]
+	itr.stats = itr.stats[1:]
+	itr.pointN += int(s.Count)
+
+	switch itr.call {
+	case "sum":
+		itr.point.Time, itr.point.Value = s.MinTime, int64(s.Sum)
+	case "min":
+		itr.point.Time, itr.point.Value = s.MinAt, int64(s.Min)
+	case "max":
+		itr.point.Time, itr.point.Value = s.MaxAt, int64(s.Max)
+	}
+	return &itr.point, nil
+}
+
+// Stats returns stats on the points processed.
+func (itr *integerBlockStatsIterator) Stats() query.IteratorStats {
+	stats := itr.input.Stats()
+	stats.PointN += itr.pointN
+	return stats
+}
+
+// Close closes the iterator.
+func (itr *integerBlockStatsIterator) Close() error { return itr.input.Close() }
+
+// integerBlockCountIterator returns a count of one for each point of a integer
+// series and the count of points of each block answered from its statistics.
+type integerBlockCountIterator struct {
+	input     query.IntegerIterator
+	buf       *query.IntegerPoint
+	done      bool
+	stats     []BlockStats
+	ascending bool
+	point     query.IntegerPoint
+	pointN    int
+}
+
+func newIntegerBlockCountIterator(input query.IntegerIterator, name string, tags query.Tags, stats []BlockStats, opt query.IteratorOptions) *integerBlockCountIterator {
+	return &integerBlockCountIterator{
+		input:     input,
+		stats:     stats,
+		ascending: opt.Ascending,
+		point: query.IntegerPoint{
+			Name: name,
+			Tags: tags,
+		},
+	}
+}
+
+// Next returns the next point from the iterator.
+func (itr *integerBlockCountIterator) Next() (*query.IntegerPoint, error) {
+	if itr.buf == nil && !itr.done {
+		p, err := itr.input.Next()
+		if err != nil {
+			return nil, err
+		}
+		itr.buf, itr.done = p, p == nil
+	}
+
+	if len(itr.stats) == 0 || (itr.buf != nil && !blockStatsBefore(&itr.stats[0], itr.buf.Time, itr.ascending)) {
+		if itr.buf == nil {
+			return nil, nil
+		}
+		itr.point.Time, itr.point.Value = itr.buf.Time, 1
+		itr.buf = nil
+		return &itr.point, nil
+	}
+
+	s := &itr.stats[0]
+	itr.stats = itr.stats[1:]
+	itr.pointN += int(s.Count)
+
+	itr.point.Time, itr.point.Value = s.MinTime, int64(s.Count)
+	return &itr.point, nil
+}
+
+// Stats returns stats on the points processed.
+func (itr *integerBlockCountIterator) Stats() query.IteratorStats {
+	stats := itr.input.Stats()
+	stats.PointN += itr.pointN
+	return stats
+}
+
+// Close closes the iterator.
+func (itr *integerBlockCountIterator) Close() error { return itr.input.Close() }
+
+func (itr *integerBlockCountIterator) partialCounts() {}
+
+// buildIntegerBlockStatsIterator creates an iterator for a count, sum, min or max
+// call over a integer field that answers the blocks covered by the call from their
+// statistics instead of decoding them.
+func (e *Engine) buildIntegerBlockStatsIterator(ctx context.Context, name string, tags query.Tags, seriesKey, field, call string, opt query.IteratorOptions) query.Iterator {
+	key := SeriesFieldKeyBytes(seriesKey, field)
+	cacheValues := e.Cache.Values(key)
+	keyCursor := e.KeyCursor(ctx, key, opt.SeekTime(), opt.Ascending)
+	stats := keyCursor.TakeBlockStats(opt.SeekTime(), blockStatsCovered(opt, cacheValues))
+
+	cur := newIntegerCursor(opt.SeekTime(), opt.Ascending, cacheValues, keyCursor)
+	input := newIntegerIterator(name, tags, opt, cur, nil, nil, nil)
+	if call == "count" {
+		return newIntegerBlockCountIterator(input, name, tags, stats, opt)
+	}
+	return newIntegerBlockStatsIterator(input, name, tags, stats, call, opt)
+}
+
+// newUnsignedBlockStats returns the statistics of the values in a.
+func newUnsignedBlockStats(a *tsdb.UnsignedArray) (BlockStats, bool) {
+	if a.Len() == 0 {
+		return BlockStats{}, false
+	}
+
+	s := BlockStats{
+		MinTime: a.MinTime(),
+		MaxTime: a.MaxTime(),
+		Count:   uint32(a.Len()),
+		MinAt:   a.Timestamps[0],
+		MaxAt:   a.Timestamps[0],
+	}
+
+	var sum uint64
+	min, max := a.Values[0], a.Values[0]
+	for i, v := range a.Values {
+		sum += v
+		if v < min {
+			min, s.MinAt = v, a.Timestamps[i]
+		}
+		if v > max {
+			max, s.MaxAt = v, a.Timestamps[i]
+		}
+	}
+
+	s.Sum, s.Min, s.Max = uint64(sum), uint64(min), uint64(max)
+	return s, true
+}
+
+// unsignedBlockStatsIterator merges the points of a unsigned series with a point
+// standing for each block answered from its statistics.
+type unsignedBlockStatsIterator struct {
+	input     query.UnsignedIterator
+	buf       *query.UnsignedPoint
+	done      bool
+	stats     []BlockStats
+	call      string
+	ascending bool
+	point     query.UnsignedPoint
+	pointN    int
+}
+
+func newUnsignedBlockStatsIterator(input query.UnsignedIterator, name string, tags query.Tags, stats []BlockStats, call string, opt query.IteratorOptions) *unsignedBlockStatsIterator {
+	return &unsignedBlockStatsIterator{
+		input:     input,
+		stats:     stats,
+		call:      call,
+		ascending: opt.Ascending,
+		point: query.UnsignedPoint{
+			Name: name,
+			Tags: tags,
+		},
+	}
+}
+
+// Next returns the next point from the iterator.
+func (itr *unsignedBlockStatsIterator) Next() (*query.UnsignedPoint, error) {
+	if itr.buf == nil && !itr.done {
+		p, err := itr.input.Next()
+		if err != nil {
+			return nil, err
+		}
+		itr.buf, itr.done = p, p == nil
+	}
+
+	if len(itr.stats) == 0 || (itr.buf != nil && !blockStatsBefore(&itr.stats[0], itr.buf.Time, itr.ascending)) {
+		p := itr.buf
+		itr.buf = nil
+		return p, nil
+	}
+
+	s := &itr.stats[0]
+	itr.stats = itr.stats[1:]
+	itr.pointN += int(s.Count)
+
+	switch itr.call {
+	case "sum":
+		itr.point.Time, itr.point.Value = s.MinTime, uint64(s.Sum)
+	case "min":
+		itr.point.Time, itr.point.Value = s.MinAt, uint64(s.Min)
+	case "max":
+		itr.point.Time, itr.point.Value = s.MaxAt, uint64(s.Max)
+	}
+	return &itr.point, nil
+}
+
+// Stats returns stats on the points processed.
+func (itr *unsignedBlockStatsIterator) Stats() query.IteratorStats {
+	stats := itr.input.Stats()
+	stats.PointN += itr.pointN
+	return stats
+}
+
+// Close closes the iterator.
+func (itr *unsignedBlockStatsIterator) Close() error { return itr.input.Close() }
+
+// unsignedBlockCountIterator returns a count of one for each point of a unsigned
+// series and the count of points of each block answered from its statistics.
+type unsignedBlockCountIterator struct {
+	input     query.UnsignedIterator
+	buf       *query.UnsignedPoint
+	done      bool
+	stats     []BlockStats
+	ascending bool
+	point     query.IntegerPoint
+	pointN    int
+}
+
+func newUnsignedBlockCountIterator(input query.UnsignedIterator, name string, tags query.Tags, stats []BlockStats, opt query.IteratorOptions) *unsignedBlockCountIterator {
+	return &unsignedBlockCountIterator{
+		input:     input,
+		stats:     stats,
+		ascending: opt.Ascending,
+		point: query.IntegerPoint{
+			Name: name,
+			Tags: tags,
+		},
+	}
+}
+
+// Next returns the next point from the iterator.
+func (itr *unsignedBlockCountIterator) Next() (*query.IntegerPoint, error) {
+	if itr.buf == nil && !itr.done {
+		p, err := itr.input.Next()
+		if err != nil {
+			return nil, err
+		}
+		itr.buf, itr.done = p, p == nil
+	}
+
+	if len(itr.stats) == 0 || (itr.buf != nil && !blockStatsBefore(&itr.stats[0], itr.buf.Time, itr.ascending)) {
+		if itr.buf == nil {
+			return nil, nil
+		}
+		itr.point.Time, itr.point.Value = itr.buf.Time, 1
+		itr.buf = nil
+		return &itr.point, nil
+	}
+
+	s := &itr.stats[0]
+	itr.stats = itr.stats[1:]
+	itr.pointN += int(s.Count)
+
+	itr.point.Time, itr.point.Value = s.MinTime, int64(s.Count)
+	return &itr.point, nil
+}
+
+// Stats returns stats on the points processed.
+func (itr *unsignedBlockCountIterator) Stats() query.IteratorStats {
+	stats := itr.input.Stats()
+	stats.PointN += itr.pointN
+	return stats
+}
+
+// Close closes the iterator.
+func (itr *unsignedBlockCountIterator) Close() error { return itr.input.Close() }
+
+func (itr *unsignedBlockCountIterator) partialCounts() {}
+
+// buildUnsignedBlockStatsIterator creates an iterator for a count, sum, min or max
+// call over a unsigned field that answers the blocks covered by the call from their
+// statistics instead of decoding them.
+func (e *Engine) buildUnsignedBlockStatsIterator(ctx context.Context, name string, tags query.Tags, seriesKey, field, call string, opt query.IteratorOptions) query.Iterator {
+	key := SeriesFieldKeyBytes(seriesKey, field)
+	cacheValues := e.Cache.Values(key)
+	keyCursor := e.KeyCursor(ctx, key, opt.SeekTime(), opt.Ascending)
+	stats := keyCursor.TakeBlockStats(opt.SeekTime(), blockStatsCovered(opt, cacheValues))
+
+	cur := newUnsignedCursor(opt.SeekTime(), opt.Ascending, cacheValues, keyCursor)
+	input := newUnsignedIterator(name, tags, opt, cur, nil, nil, nil)
+	if call == "count" {
+		return newUnsignedBlockCountIterator(input, name, tags, stats, opt)
+	}
+	return newUnsignedBlockStatsIterator(input, name, tags, stats, call, opt)
+}
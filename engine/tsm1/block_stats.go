@@ -0,0 +1,164 @@
+package tsm1
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"sort"
+
+	"github.com/influxdata/influxdb/v2/influxql/query"
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxql"
+)
+
+const (
+	// blockStatsSize is the size in bytes of the statistics of a block in a TSM file.
+	blockStatsSize = 56
+
+	// blockStatsTrailerSize is the size in bytes of the trailer of the statistics section.
+	blockStatsTrailerSize = 4
+)
+
+// BlockStats holds the statistics of a block of float, integer or unsigned values.
+// Sum, Min and Max hold the bits of values of the block's type: math.Float64bits
+// for floats and the two's complement for integers.
+type BlockStats struct {
+	// The min and max time of all points stored in the block.
+	MinTime, MaxTime int64
+
+	// The number of points stored in the block.
+	Count uint32
+
+	// The sum, min and max of the values of the block.
+	Sum, Min, Max uint64
+
+	// The earliest times of the min and max values.
+	MinAt, MaxAt int64
+}
+
+// appendTo appends the binary encoding of the statistics of the block at offset to b.
+//
+// ┌──────────────────────────────────────────────────────────────────┐
+// │                            BlockStats                            │
+// ├────────┬───────┬───────┬───────┬───────┬───────┬───────┬─────────┤
+// │ Offset │ Count │  Sum  │  Min  │ MinAt │  Max  │ MaxAt │  CRC32  │
+// │8 bytes │4 bytes│8 bytes│8 bytes│8 bytes│8 bytes│8 bytes│ 4 bytes │
+// └────────┴───────┴───────┴───────┴───────┴───────┴───────┴─────────┘
+func (s *BlockStats) appendTo(b []byte, offset int64) []byte {
+	var buf [blockStatsSize]byte
+	binary.BigEndian.PutUint64(buf[0:8], uint64(offset))
+	binary.BigEndian.PutUint32(buf[8:12], s.Count)
+	binary.BigEndian.PutUint64(buf[12:20], s.Sum)
+	binary.BigEndian.PutUint64(buf[20:28], s.Min)
+	binary.BigEndian.PutUint64(buf[28:36], uint64(s.MinAt))
+	binary.BigEndian.PutUint64(buf[36:44], s.Max)
+	binary.BigEndian.PutUint64(buf[44:52], uint64(s.MaxAt))
+	binary.BigEndian.PutUint32(buf[52:56], crc32.ChecksumIEEE(buf[:52]))
+	return append(b, buf[:]...)
+}
+
+// unmarshalBinary decodes the statistics encoded in b, returning false if
+// they are corrupt.
+func (s *BlockStats) unmarshalBinary(b []byte) bool {
+	if len(b) < blockStatsSize || crc32.ChecksumIEEE(b[:52]) != binary.BigEndian.Uint32(b[52:56]) {
+		return false
+	}
+	s.Count = binary.BigEndian.Uint32(b[8:12])
+	s.Sum = binary.BigEndian.Uint64(b[12:20])
+	s.Min = binary.BigEndian.Uint64(b[20:28])
+	s.MinAt = int64(binary.BigEndian.Uint64(b[28:36]))
+	s.Max = binary.BigEndian.Uint64(b[36:44])
+	s.MaxAt = int64(binary.BigEndian.Uint64(b[44:52]))
+	return true
+}
+
+// blockStatsSection returns the statistics section of the TSM file b whose index
+// starts at indexStart, or nil if the file has none.
+func blockStatsSection(b []byte, indexStart uint64) []byte {
+	if len(b) < 5 || b[4] < VersionBlockStats {
+		return nil
+	} else if indexStart < 5+blockStatsTrailerSize || indexStart > uint64(len(b)) {
+		return nil
+	}
+
+	size := uint64(binary.BigEndian.Uint32(b[indexStart-blockStatsTrailerSize:indexStart])) * blockStatsSize
+	end := indexStart - blockStatsTrailerSize
+	if size > end-5 {
+		return nil
+	}
+	return b[end-size : end]
+}
+
+// findBlockStats returns the statistics of the block at entry in section.
+func findBlockStats(section []byte, entry *IndexEntry) (BlockStats, bool) {
+	n := len(section) / blockStatsSize
+	i := sort.Search(n, func(i int) bool {
+		return int64(binary.BigEndian.Uint64(section[i*blockStatsSize:])) >= entry.Offset
+	})
+	if i == n || int64(binary.BigEndian.Uint64(section[i*blockStatsSize:])) != entry.Offset {
+		return BlockStats{}, false
+	}
+
+	s := BlockStats{MinTime: entry.MinTime, MaxTime: entry.MaxTime}
+	if !s.unmarshalBinary(section[i*blockStatsSize:]) {
+		return BlockStats{}, false
+	}
+	return s, true
+}
+
+// blockStatsBuffers holds the arrays values are copied into to compute their statistics.
+type blockStatsBuffers struct {
+	float    tsdb.FloatArray
+	integer  tsdb.IntegerArray
+	unsigned tsdb.UnsignedArray
+}
+
+// blockStatsCall returns the name of the call in opt if it can be answered from
+// block statistics.
+func blockStatsCall(opt query.IteratorOptions) (string, bool) {
+	call, ok := opt.Expr.(*influxql.Call)
+	if !ok || len(opt.Aux) > 0 {
+		return "", false
+	}
+
+	switch call.Name {
+	case "count", "sum", "min", "max":
+		return call.Name, true
+	}
+	return "", false
+}
+
+// blockStatsCovered returns a function reporting whether a block can be answered from
+// its statistics: the block must lie within a single window of the query and must
+// not overlap any values in the cache.
+func blockStatsCovered(opt query.IteratorOptions, cacheValues Values) func(entry *IndexEntry) bool {
+	return func(entry *IndexEntry) bool {
+		if entry.MinTime < opt.StartTime || entry.MaxTime > opt.EndTime {
+			return false
+		}
+		minStart, _ := opt.Window(entry.MinTime)
+		maxStart, _ := opt.Window(entry.MaxTime)
+		if minStart != maxStart {
+			return false
+		}
+
+		i := sort.Search(len(cacheValues), func(i int) bool {
+			return cacheValues[i].UnixNano() >= entry.MinTime
+		})
+		return i == len(cacheValues) || cacheValues[i].UnixNano() > entry.MaxTime
+	}
+}
+
+// blockStatsBefore returns true if the block of s comes before a point at time t.
+func blockStatsBefore(s *BlockStats, t int64, ascending bool) bool {
+	if ascending {
+		return s.MinTime < t
+	}
+	return s.MaxTime > t
+}
+
+// blockCountIterator is implemented by iterators returning partial counts of
+// points, which must be summed rather than counted.
+type blockCountIterator interface {
+	query.IntegerIterator
+	partialCounts()
+}
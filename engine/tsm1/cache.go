@@ -1,7 +1,9 @@
 package tsm1
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"sync"
@@ -9,6 +11,7 @@ import (
 	"time"
 
 	"github.com/influxdata/influxdb/v2/models"
+	"github.com/influxdata/influxdb/v2/pkg/bytesutil"
 	"github.com/influxdata/influxdb/v2/tsdb"
 	"github.com/influxdata/influxql"
 	"github.com/prometheus/client_golang/prometheus"
@@ -38,48 +41,78 @@ type entry struct {
 	mu     sync.RWMutex
 	values Values // All stored values.
 
+	// sorted is the length of the run at the start of values that is sorted and
+	// deduplicated.  Values written out of order are appended after it, and are
+	// merged into it when the entry is read.
+	sorted int
+
+	// maxTime is the time of the latest value written.
+	maxTime int64
+
 	// The type of values stored. Read only so doesn't need to be protected by
 	// mu.
 	vtype byte
 }
 
-// newEntryValues returns a new instance of entry with the given values.  If the
-// values are not valid, an error is returned.
-func newEntryValues(values []Value) (*entry, error) {
+// newEntryValues returns a new instance of entry with the given values, and the
+// number of values written out of order.  If the values are not valid, an error
+// is returned.
+func newEntryValues(values []Value) (*entry, int, error) {
 	e := &entry{}
 	e.values = make(Values, 0, len(values))
 	e.values = append(e.values, values...)
 
 	// No values, don't check types and ordering
 	if len(values) == 0 {
-		return e, nil
+		return e, 0, nil
 	}
 
 	et := valueType(values[0])
 	for _, v := range values {
 		// Make sure all the values are the same type
 		if et != valueType(v) {
-			return nil, tsdb.ErrFieldTypeConflict
+			return nil, 0, tsdb.ErrFieldTypeConflict
 		}
 	}
 
 	// Set the type of values stored.
 	e.vtype = et
 
-	return e, nil
+	var n int
+	n, e.maxTime = outOfOrder(values[1:], values[0].UnixNano())
+	if n == 0 {
+		e.sorted = len(e.values)
+	}
+	return e, n, nil
+}
+
+// outOfOrder returns the number of values that are not after all the values
+// before them, starting at maxTime, and the new maximum time.
+func outOfOrder(values []Value, maxTime int64) (int, int64) {
+	var n int
+	for _, v := range values {
+		if t := v.UnixNano(); t <= maxTime {
+			n++
+		} else {
+			maxTime = t
+		}
+	}
+	return n, maxTime
 }
 
-// add adds the given values to the entry.
-func (e *entry) add(values []Value) error {
+// add adds the given values to the entry, and returns the number of values
+// written out of order.  Values written in order extend the sorted run of the
+// entry, the others are merged into it when the entry is next read.
+func (e *entry) add(values []Value) (int, error) {
 	if len(values) == 0 {
-		return nil // Nothing to do.
+		return 0, nil // Nothing to do.
 	}
 
 	// Are any of the new values the wrong type?
 	if e.vtype != 0 {
 		for _, v := range values {
 			if e.vtype != valueType(v) {
-				return tsdb.ErrFieldTypeConflict
+				return 0, tsdb.ErrFieldTypeConflict
 			}
 		}
 	}
@@ -87,28 +120,71 @@ func (e *entry) add(values []Value) error {
 	// entry currently has no values, so add the new ones and we're done.
 	e.mu.Lock()
 	if len(e.values) == 0 {
+		n, maxTime := outOfOrder(values[1:], values[0].UnixNano())
 		e.values = values
+		e.maxTime = maxTime
+		e.sorted = 0
+		if n == 0 {
+			e.sorted = len(e.values)
+		}
 		e.vtype = valueType(values[0])
 		e.mu.Unlock()
-		return nil
+		return n, nil
 	}
 
 	// Append the new values to the existing ones...
+	n, maxTime := outOfOrder(values, e.maxTime)
+	inOrder := n == 0 && e.sorted == len(e.values)
+	e.maxTime = maxTime
 	e.values = append(e.values, values...)
+	if inOrder {
+		e.sorted = len(e.values)
+	}
 	e.mu.Unlock()
-	return nil
+	return n, nil
 }
 
 // deduplicate sorts and orders the entry's values. If values are already deduped and sorted,
 // the function does no work and simply returns.
 func (e *entry) deduplicate() {
+	e.mu.RLock()
+	merged := e.sorted == len(e.values)
+	e.mu.RUnlock()
+	if merged {
+		return
+	}
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
+	e.merge()
+}
 
-	if len(e.values) <= 1 {
+// merge sorts the values written out of order and merges them into the sorted
+// run of the entry.  Callers must hold a write lock on the entry.
+func (e *entry) merge() {
+	if e.sorted == len(e.values) {
 		return
 	}
-	e.values = e.values.Deduplicate()
+
+	unsorted := e.values[e.sorted:].Deduplicate()
+	e.values = e.values[:e.sorted].Merge(unsorted)
+	e.sorted = len(e.values)
+}
+
+// sortedValues returns a sorted and deduplicated copy of the entry's values.
+// The values are copied under the entry lock, including those written out of
+// order, and the copy is sorted once the lock is released.
+func (e *entry) sortedValues() Values {
+	e.mu.RLock()
+	values := make(Values, len(e.values))
+	copy(values, e.values)
+	sorted := e.sorted
+	e.mu.RUnlock()
+
+	if sorted < len(values) {
+		values = values[:sorted].Merge(values[sorted:].Deduplicate())
+	}
+	return values
 }
 
 // count returns the number of values in this entry.
@@ -122,13 +198,33 @@ func (e *entry) count() int {
 // filter removes all values with timestamps between min and max inclusive.
 func (e *entry) filter(min, max int64) {
 	e.mu.Lock()
-	if len(e.values) > 1 {
-		e.values = e.values.Deduplicate()
-	}
+	e.merge()
 	e.values = e.values.Exclude(min, max)
+	e.sorted = len(e.values)
+	if len(e.values) > 0 {
+		e.maxTime = e.values[len(e.values)-1].UnixNano()
+	}
 	e.mu.Unlock()
 }
 
+// minTime returns the time of the earliest value in this entry, or math.MaxInt64
+// if it is empty.
+func (e *entry) minTime() int64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	min := int64(math.MaxInt64)
+	if e.sorted > 0 {
+		min = e.values[0].UnixNano()
+	}
+	for _, v := range e.values[e.sorted:] {
+		if v.UnixNano() < min {
+			min = v.UnixNano()
+		}
+	}
+	return min
+}
+
 // size returns the size of this entry in bytes.
 func (e *entry) size() int {
 	e.mu.RLock()
@@ -146,15 +242,15 @@ func (e *entry) InfluxQLType() (influxql.DataType, error) {
 
 // storer is the interface that descibes a cache's store.
 type storer interface {
-	entry(key []byte) *entry                        // Get an entry by its key.
-	write(key []byte, values Values) (bool, error)  // Write an entry to the store.
-	remove(key []byte)                              // Remove an entry from the store.
-	keys(sorted bool) [][]byte                      // Return an optionally sorted slice of entry keys.
-	apply(f func([]byte, *entry) error) error       // Apply f to all entries in the store in parallel.
-	applySerial(f func([]byte, *entry) error) error // Apply f to all entries in serial.
-	reset()                                         // Reset the store to an initial unused state.
-	split(n int) []storer                           // Split splits the store into n stores
-	count() int                                     // Count returns the number of keys in the store
+	entry(key []byte) *entry                            // Get an entry by its key.
+	write(key []byte, values Values) (bool, int, error) // Write an entry to the store.
+	remove(key []byte)                                  // Remove an entry from the store.
+	keys(sorted bool) [][]byte                          // Return an optionally sorted slice of entry keys.
+	apply(f func([]byte, *entry) error) error           // Apply f to all entries in the store in parallel.
+	applySerial(f func([]byte, *entry) error) error     // Apply f to all entries in serial.
+	reset()                                             // Reset the store to an initial unused state.
+	split(n int) []storer                               // Split splits the store into n stores
+	count() int                                         // Count returns the number of keys in the store
 }
 
 // Cache maintains an in-memory store of Values for a set of keys.
@@ -165,6 +261,10 @@ type Cache struct {
 	size         uint64
 	snapshotSize uint64
 
+	// The number of values written, and of values written out of order.
+	valuesWritten    uint64
+	valuesOutOfOrder uint64
+
 	mu      sync.RWMutex
 	store   storer
 	maxSize uint64
@@ -181,6 +281,13 @@ type Cache struct {
 	stats         *cacheMetrics
 	lastWriteTime time.Time
 
+	// governor holds the *tsdb.CacheMemoryGovernor the size of the cache is
+	// charged against, if any.
+	governor atomic.Value
+
+	// snapshotRequested is set to 1 when a snapshot was requested to free memory.
+	snapshotRequested uint32
+
 	// A one time synchronization used to initial the cache with a store.  Since the store can allocate a
 	// large amount memory across shards, we lazily create it.
 	initialize       atomic.Value
@@ -198,6 +305,7 @@ func NewCache(maxSize uint64, tags tsdb.EngineTags) *Cache {
 	}
 	c.stats.LastSnapshot.SetToCurrentTime()
 	c.initialize.Store(&sync.Once{})
+	c.governor.Store((*tsdb.CacheMemoryGovernor)(nil))
 	return c
 }
 
@@ -206,21 +314,23 @@ var globalCacheMetrics = newAllCacheMetrics()
 const cacheSubsystem = "cache"
 
 type allCacheMetrics struct {
-	MemBytes     *prometheus.GaugeVec
-	DiskBytes    *prometheus.GaugeVec
-	LastSnapshot *prometheus.GaugeVec
-	Writes       *prometheus.CounterVec
-	WriteErr     *prometheus.CounterVec
-	WriteDropped *prometheus.CounterVec
+	MemBytes        *prometheus.GaugeVec
+	DiskBytes       *prometheus.GaugeVec
+	LastSnapshot    *prometheus.GaugeVec
+	Writes          *prometheus.CounterVec
+	WriteErr        *prometheus.CounterVec
+	WriteDropped    *prometheus.CounterVec
+	OutOfOrderRatio *prometheus.GaugeVec
 }
 
 type cacheMetrics struct {
-	MemBytes     prometheus.Gauge
-	DiskBytes    prometheus.Gauge
-	LastSnapshot prometheus.Gauge
-	Writes       prometheus.Counter
-	WriteErr     prometheus.Counter
-	WriteDropped prometheus.Counter
+	MemBytes        prometheus.Gauge
+	DiskBytes       prometheus.Gauge
+	LastSnapshot    prometheus.Gauge
+	Writes          prometheus.Counter
+	WriteErr        prometheus.Counter
+	WriteDropped    prometheus.Counter
+	OutOfOrderRatio prometheus.Gauge
 }
 
 func newAllCacheMetrics() *allCacheMetrics {
@@ -262,6 +372,12 @@ func newAllCacheMetrics() *allCacheMetrics {
 			Name:      "writes_dropped",
 			Help:      "Counter of writes to cache with some dropped points",
 		}, labels),
+		OutOfOrderRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: storageNamespace,
+			Subsystem: cacheSubsystem,
+			Name:      "out_of_order_ratio",
+			Help:      "Gauge of the ratio of values written to cache before the latest value of their series",
+		}, labels),
 	}
 }
 
@@ -273,18 +389,20 @@ func CacheCollectors() []prometheus.Collector {
 		globalCacheMetrics.Writes,
 		globalCacheMetrics.WriteErr,
 		globalCacheMetrics.WriteDropped,
+		globalCacheMetrics.OutOfOrderRatio,
 	}
 }
 
 func newCacheMetrics(tags tsdb.EngineTags) *cacheMetrics {
 	labels := tags.GetLabels()
 	return &cacheMetrics{
-		MemBytes:     globalCacheMetrics.MemBytes.With(labels),
-		DiskBytes:    globalCacheMetrics.DiskBytes.With(labels),
-		LastSnapshot: globalCacheMetrics.LastSnapshot.With(labels),
-		Writes:       globalCacheMetrics.Writes.With(labels),
-		WriteErr:     globalCacheMetrics.WriteErr.With(labels),
-		WriteDropped: globalCacheMetrics.WriteDropped.With(labels),
+		MemBytes:        globalCacheMetrics.MemBytes.With(labels),
+		DiskBytes:       globalCacheMetrics.DiskBytes.With(labels),
+		LastSnapshot:    globalCacheMetrics.LastSnapshot.With(labels),
+		Writes:          globalCacheMetrics.Writes.With(labels),
+		WriteErr:        globalCacheMetrics.WriteErr.With(labels),
+		WriteDropped:    globalCacheMetrics.WriteDropped.With(labels),
+		OutOfOrderRatio: globalCacheMetrics.OutOfOrderRatio.With(labels),
 	}
 }
 
@@ -332,6 +450,13 @@ func (c *Cache) WriteMulti(values map[string][]Value) error {
 		return ErrCacheMemorySizeLimitExceeded(n, limit)
 	}
 
+	// Enough room across all caches?  Snapshots of other caches are requested
+	// first, so the write is only rejected if memory cannot be freed in time.
+	if g := c.memoryGovernor(); g != nil && !g.Admit(addedSize) {
+		c.stats.WriteErr.Inc()
+		return ErrCacheMemorySizeLimitExceeded(g.Used()+addedSize, g.Limit())
+	}
+
 	var werr error
 	c.mu.RLock()
 	store := c.store
@@ -339,19 +464,24 @@ func (c *Cache) WriteMulti(values map[string][]Value) error {
 
 	// We'll optimistically set size here, and then decrement it for write errors.
 	c.increaseSize(addedSize)
+	var written, outOfOrder int
 	for k, v := range values {
-		newKey, err := store.write([]byte(k), v)
+		newKey, n, err := store.write([]byte(k), v)
 		if err != nil {
 			// The write failed, hold onto the error and adjust the size delta.
 			werr = err
 			addedSize -= uint64(Values(v).Size())
 			c.decreaseSize(uint64(Values(v).Size()))
+		} else {
+			written += len(v)
+			outOfOrder += n
 		}
 		if newKey {
 			addedSize += uint64(len(k))
 			c.increaseSize(uint64(len(k)))
 		}
 	}
+	c.addOutOfOrder(written, outOfOrder)
 
 	// Some points in the batch were dropped.  An error is returned so
 	// error stat is incremented as well.
@@ -370,6 +500,28 @@ func (c *Cache) WriteMulti(values map[string][]Value) error {
 	return werr
 }
 
+// addOutOfOrder accounts for n values written, of which outOfOrder were written
+// before the latest value of their series.
+func (c *Cache) addOutOfOrder(n, outOfOrder int) {
+	if n == 0 {
+		return
+	}
+	total := atomic.AddUint64(&c.valuesWritten, uint64(n))
+	ooo := atomic.AddUint64(&c.valuesOutOfOrder, uint64(outOfOrder))
+	c.stats.OutOfOrderRatio.Set(float64(ooo) / float64(total))
+}
+
+// OutOfOrderRatio returns the ratio of values written to the cache before the
+// latest value of their series.  Reading these values requires merging them
+// with the values written in order.
+func (c *Cache) OutOfOrderRatio() float64 {
+	total := atomic.LoadUint64(&c.valuesWritten)
+	if total == 0 {
+		return 0
+	}
+	return float64(atomic.LoadUint64(&c.valuesOutOfOrder)) / float64(total)
+}
+
 // Snapshot takes a snapshot of the current cache, adds it to the slice of caches that
 // are being flushed, and resets the current cache with new values.
 func (c *Cache) Snapshot() (*Cache, error) {
@@ -384,6 +536,7 @@ func (c *Cache) Snapshot() (*Cache, error) {
 
 	c.snapshotting = true
 	c.snapshotAttempts++ // increment the number of times we tried to do this
+	atomic.StoreUint32(&c.snapshotRequested, 0)
 
 	// If no snapshot exists, create a new one, otherwise update the existing snapshot
 	if c.snapshot == nil {
@@ -457,8 +610,11 @@ func (c *Cache) ClearSnapshot(success bool) {
 		c.snapshot = &Cache{
 			store: c.snapshot.store,
 		}
-		c.stats.DiskBytes.Set(float64(atomic.LoadUint64(&c.snapshotSize)))
-		atomic.StoreUint64(&c.snapshotSize, 0)
+		snapshotSize := atomic.SwapUint64(&c.snapshotSize, 0)
+		c.stats.DiskBytes.Set(float64(snapshotSize))
+		if g := c.memoryGovernor(); g != nil {
+			g.Release(snapshotSize)
+		}
 	}
 	c.stats.MemBytes.Set(float64(c.Size()))
 }
@@ -471,12 +627,53 @@ func (c *Cache) Size() uint64 {
 // increaseSize increases size by delta.
 func (c *Cache) increaseSize(delta uint64) {
 	atomic.AddUint64(&c.size, delta)
+	if g := c.memoryGovernor(); g != nil {
+		g.Charge(delta)
+	}
 }
 
 // decreaseSize decreases size by delta.
 func (c *Cache) decreaseSize(delta uint64) {
 	// Per sync/atomic docs, bit-flip delta minus one to perform subtraction within AddUint64.
 	atomic.AddUint64(&c.size, ^(delta - 1))
+	if g := c.memoryGovernor(); g != nil {
+		g.Release(delta)
+	}
+}
+
+// memoryGovernor returns the governor the size of the cache is charged
+// against, or nil.
+func (c *Cache) memoryGovernor() *tsdb.CacheMemoryGovernor {
+	g, _ := c.governor.Load().(*tsdb.CacheMemoryGovernor)
+	return g
+}
+
+// SetMemoryGovernor charges the size of the cache against g instead of the
+// previous governor.  A nil governor removes the cache from the store-wide limit.
+func (c *Cache) SetMemoryGovernor(g *tsdb.CacheMemoryGovernor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old := c.memoryGovernor(); old != nil {
+		old.Deregister(c)
+		old.Release(c.Size())
+	}
+	c.governor.Store(g)
+	if g != nil {
+		g.Charge(c.Size())
+		g.Register(c)
+	}
+}
+
+// RequestSnapshot asks for the cache to be snapshotted by the engine to free
+// memory.  The request is cleared by the next snapshot.
+func (c *Cache) RequestSnapshot() {
+	atomic.StoreUint32(&c.snapshotRequested, 1)
+}
+
+// SnapshotRequested returns true if a snapshot was requested to free memory.
+func (c *Cache) SnapshotRequested() bool {
+	return atomic.LoadUint32(&c.snapshotRequested) == 1
 }
 
 // MaxSize returns the maximum number of bytes the cache may consume.
@@ -499,6 +696,25 @@ func (c *Cache) Keys() [][]byte {
 	return store.keys(true)
 }
 
+// keysWithSnapshot returns a sorted slice of all keys under management by the
+// cache, including the keys of the snapshot being written.  Keys snapshotted
+// after they are read are in the file store once the snapshot is written.
+func (c *Cache) keysWithSnapshot() [][]byte {
+	c.mu.RLock()
+	store := c.store
+	var snapshot storer
+	if c.snapshot != nil {
+		snapshot = c.snapshot.store
+	}
+	c.mu.RUnlock()
+
+	keys := store.keys(false)
+	if snapshot != nil {
+		keys = append(keys, snapshot.keys(false)...)
+	}
+	return bytesutil.SortDedup(keys)
+}
+
 func (c *Cache) Split(n int) []*Cache {
 	if n == 1 {
 		return []*Cache{c}
@@ -557,49 +773,21 @@ func (c *Cache) Values(key []byte) Values {
 	}
 	c.mu.RUnlock()
 
-	if e == nil {
-		if snapshotEntries == nil {
-			// No values in hot cache or snapshots.
-			return nil
+	// Copy the values of the snapshot and the hot values, in that order, so
+	// that hot values replace snapshot ones when they are merged.  The entries
+	// are merged first so later reads don't sort the same values again, but
+	// values can be written out of order again before they are copied.
+	var values Values
+	for _, e := range []*entry{snapshotEntries, e} {
+		if e == nil {
+			continue
 		}
-	} else {
 		e.deduplicate()
+		values = values.Merge(e.sortedValues())
 	}
-
-	// Build the sequence of entries that will be returned, in the correct order.
-	// Calculate the required size of the destination buffer.
-	var entries []*entry
-	sz := 0
-
-	if snapshotEntries != nil {
-		snapshotEntries.deduplicate() // guarantee we are deduplicated
-		entries = append(entries, snapshotEntries)
-		sz += snapshotEntries.count()
-	}
-
-	if e != nil {
-		entries = append(entries, e)
-		sz += e.count()
-	}
-
-	// Any entries? If not, return.
-	if sz == 0 {
+	if len(values) == 0 {
 		return nil
 	}
-
-	// Create the buffer, and copy all hot values and snapshots. Individual
-	// entries are sorted at this point, so now the code has to check if the
-	// resultant buffer will be sorted from start to finish.
-	values := make(Values, sz)
-	n := 0
-	for _, e := range entries {
-		e.mu.RLock()
-		n += copy(values[n:], e.values)
-		e.mu.RUnlock()
-	}
-	values = values[:n]
-	values = values.Deduplicate()
-
 	return values
 }
 
@@ -681,6 +869,10 @@ func (c *Cache) ApplyEntryFn(f func(key []byte, entry *entry) error) error {
 type CacheLoader struct {
 	files []string
 
+	// ReadOnly loads the segments without creating or truncating them.  The
+	// entries after damage in a segment are skipped.
+	ReadOnly bool
+
 	Logger *zap.Logger
 }
 
@@ -692,16 +884,61 @@ func NewCacheLoader(files []string) *CacheLoader {
 	}
 }
 
+// WALLoadReport describes the WAL entries that could not be loaded into a cache.
+type WALLoadReport struct {
+	// Segments holds the damage found in each segment that was truncated.
+	Segments []WALSegmentDamage
+}
+
+// Empty returns true if no entries were lost.
+func (r *WALLoadReport) Empty() bool {
+	return len(r.Segments) == 0
+}
+
+// WALSegmentDamage describes an entry of a WAL segment that could not be read,
+// and the data lost by truncating the segment before it.
+type WALSegmentDamage struct {
+	// Path is the path of the segment.
+	Path string
+
+	// Version is the version of the segment.
+	Version byte
+
+	// Entries is the number of entries loaded from the segment.
+	Entries int
+
+	// Offset is the offset of the entry that could not be read.  The segment was
+	// truncated to this size.
+	Offset int64
+
+	// LostBytes is the number of bytes removed by truncating the segment.
+	LostBytes int64
+
+	// Torn is true if the segment ended in the middle of the entry, as left by
+	// a write interrupted by a crash.  Otherwise the entry was corrupt.
+	Torn bool
+
+	// Err is the error reading the entry.
+	Err error
+}
+
 // Load returns a cache loaded with the data contained within the segment files.
 // If, during reading of a segment file, corruption is encountered, that segment
 // file is truncated up to and including the last valid byte, and processing
-// continues with the next segment file.
-func (cl *CacheLoader) Load(cache *Cache) error {
+// continues with the next segment file.  The returned report describes the
+// entries lost by truncating segments.  Segments are not truncated if the loader
+// is read-only.
+func (cl *CacheLoader) Load(cache *Cache) (*WALLoadReport, error) {
+	report := &WALLoadReport{}
 
 	var r *WALSegmentReader
 	for _, fn := range cl.files {
 		if err := func() error {
-			f, err := os.OpenFile(fn, os.O_CREATE|os.O_RDWR, 0666)
+			flag := os.O_CREATE | os.O_RDWR
+			if cl.ReadOnly {
+				flag = os.O_RDONLY
+			}
+			f, err := os.OpenFile(fn, flag, 0666)
 			if err != nil {
 				return err
 			}
@@ -726,16 +963,34 @@ func (cl *CacheLoader) Load(cache *Cache) error {
 				r.Reset(f)
 			}
 
+			var entries int
 			for r.Next() {
 				entry, err := r.Read()
-				if err != nil {
+				if errors.Is(err, ErrWALSegmentVersion) {
+					return fmt.Errorf("reading WAL segment %s: %w", f.Name(), err)
+				} else if err != nil {
 					n := r.Count()
-					cl.Logger.Info("File corrupt", zap.Error(err), zap.String("path", f.Name()), zap.Int64("pos", n))
-					if err := f.Truncate(n); err != nil {
-						return err
+					damage := WALSegmentDamage{
+						Path:      f.Name(),
+						Version:   r.Version(),
+						Entries:   entries,
+						Offset:    n,
+						LostBytes: stat.Size() - n,
+						Torn:      errors.Is(err, io.ErrUnexpectedEOF),
+						Err:       err,
+					}
+					report.Segments = append(report.Segments, damage)
+
+					cl.Logger.Info("File corrupt", zap.Error(err), zap.String("path", f.Name()), zap.Int64("pos", n),
+						zap.Int64("lost_bytes", damage.LostBytes), zap.Bool("torn", damage.Torn))
+					if !cl.ReadOnly {
+						if err := f.Truncate(n); err != nil {
+							return err
+						}
 					}
 					break
 				}
+				entries++
 
 				switch t := entry.(type) {
 				case *WriteWALEntry:
@@ -751,10 +1006,10 @@ func (cl *CacheLoader) Load(cache *Cache) error {
 
 			return r.Close()
 		}(); err != nil {
-			return err
+			return report, err
 		}
 	}
-	return nil
+	return report, nil
 }
 
 // WithLogger sets the logger on the CacheLoader.
@@ -775,6 +1030,7 @@ const (
 	valueTypeString    = 3
 	valueTypeBoolean   = 4
 	valueTypeUnsigned  = 5
+	valueTypeSketch    = 6
 )
 
 func valueType(v Value) byte {
@@ -789,6 +1045,8 @@ func valueType(v Value) byte {
 		return valueTypeBoolean
 	case UnsignedValue:
 		return valueTypeUnsigned
+	case SketchValue:
+		return valueTypeSketch
 	default:
 		return valueTypeUndefined
 	}
@@ -796,12 +1054,12 @@ func valueType(v Value) byte {
 
 type emptyStore struct{}
 
-func (e emptyStore) entry(key []byte) *entry                        { return nil }
-func (e emptyStore) write(key []byte, values Values) (bool, error)  { return false, nil }
-func (e emptyStore) remove(key []byte)                              {}
-func (e emptyStore) keys(sorted bool) [][]byte                      { return nil }
-func (e emptyStore) apply(f func([]byte, *entry) error) error       { return nil }
-func (e emptyStore) applySerial(f func([]byte, *entry) error) error { return nil }
-func (e emptyStore) reset()                                         {}
-func (e emptyStore) split(n int) []storer                           { return nil }
-func (e emptyStore) count() int                                     { return 0 }
+func (e emptyStore) entry(key []byte) *entry                            { return nil }
+func (e emptyStore) write(key []byte, values Values) (bool, int, error) { return false, 0, nil }
+func (e emptyStore) remove(key []byte)                                  {}
+func (e emptyStore) keys(sorted bool) [][]byte                          { return nil }
+func (e emptyStore) apply(f func([]byte, *entry) error) error           { return nil }
+func (e emptyStore) applySerial(f func([]byte, *entry) error) error     { return nil }
+func (e emptyStore) reset()                                             {}
+func (e emptyStore) split(n int) []storer                               { return nil }
+func (e emptyStore) count() int                                         { return 0 }
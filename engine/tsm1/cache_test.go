@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
 	"os"
@@ -98,6 +99,60 @@ func TestCache_WriteMulti_Stats(t *testing.T) {
 	}
 }
 
+// Tests that caches sharing a memory governor request snapshots and reject
+// writes once the store-wide limit is reached.
+func TestCache_WriteMulti_MemoryGovernor(t *testing.T) {
+	v := NewValue(1, 1.0)
+	g := tsdb.NewCacheMemoryGovernor(40)
+
+	c1 := NewCache(0, tsdb.EngineTags{})
+	c1.SetMemoryGovernor(g)
+	c2 := NewCache(0, tsdb.EngineTags{})
+	c2.SetMemoryGovernor(g)
+
+	if err := c1.WriteMulti(map[string][]Value{"foo": {v}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, exp := g.Used(), uint64(v.Size())+3; got != exp {
+		t.Fatalf("got %v, expected %v", got, exp)
+	}
+
+	// Crossing the high water mark asks the other cache to snapshot.
+	if err := c2.WriteMulti(map[string][]Value{"bar": {v}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !c1.SnapshotRequested() {
+		t.Fatalf("expected snapshot to be requested")
+	}
+
+	// Not enough room across both caches.
+	used := g.Used()
+	values := map[string][]Value{"baz": {v}}
+	if got, exp := c2.WriteMulti(values), ErrCacheMemorySizeLimitExceeded(used+uint64(v.Size()), 40); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("got %q, expected %q", got, exp)
+	}
+
+	// A snapshot written to disk frees room for the write.
+	if _, err := c1.Snapshot(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c1.ClearSnapshot(true)
+	if c1.SnapshotRequested() {
+		t.Fatalf("expected snapshot request to be cleared")
+	}
+	if got, exp := g.Used(), c2.Size(); got != exp {
+		t.Fatalf("got %v, expected %v", got, exp)
+	}
+	if err := c2.WriteMulti(values); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c2.SetMemoryGovernor(nil)
+	if got, exp := g.Used(), uint64(0); got != exp {
+		t.Fatalf("got %v, expected %v", got, exp)
+	}
+}
+
 func TestCache_CacheWriteMulti_TypeConflict(t *testing.T) {
 	v0 := NewValue(1, 1.0)
 	v1 := NewValue(2, 2.0)
@@ -338,6 +393,93 @@ func TestCache_CacheValues(t *testing.T) {
 	}
 }
 
+func TestCache_Values_OutOfOrder(t *testing.T) {
+	c := NewCache(0, tsdb.EngineTags{})
+
+	// Values written in order extend the sorted run of the entry.
+	if err := c.WriteMulti(map[string][]Value{"foo": {NewValue(10, 1.0), NewValue(20, 2.0)}}); err != nil {
+		t.Fatalf("failed to write key foo to cache: %s", err.Error())
+	}
+	if err := c.WriteMulti(map[string][]Value{"foo": {NewValue(30, 3.0)}}); err != nil {
+		t.Fatalf("failed to write key foo to cache: %s", err.Error())
+	}
+	e := c.store.entry([]byte("foo"))
+	if e.sorted != 3 {
+		t.Fatalf("unexpected sorted run: got %d, exp %d", e.sorted, 3)
+	}
+
+	// Backfilled values are merged into it when read, the latest write winning.
+	if err := c.WriteMulti(map[string][]Value{"foo": {NewValue(25, 2.5), NewValue(5, 0.5)}}); err != nil {
+		t.Fatalf("failed to write key foo to cache: %s", err.Error())
+	}
+	if err := c.WriteMulti(map[string][]Value{"foo": {NewValue(20, 4.0), NewValue(40, 5.0)}}); err != nil {
+		t.Fatalf("failed to write key foo to cache: %s", err.Error())
+	}
+	if e.sorted != 3 {
+		t.Fatalf("unexpected sorted run: got %d, exp %d", e.sorted, 3)
+	}
+
+	exp := Values{NewValue(5, 0.5), NewValue(10, 1.0), NewValue(20, 4.0), NewValue(25, 2.5), NewValue(30, 3.0), NewValue(40, 5.0)}
+	if got := c.Values([]byte("foo")); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected values:\n\tgot: %v\n\texp: %v", got, exp)
+	}
+	if e.sorted != len(exp) {
+		t.Fatalf("unexpected sorted run: got %d, exp %d", e.sorted, len(exp))
+	}
+
+	// 25, 5 and 20 were written before the latest value of the series.
+	if got, exp := c.OutOfOrderRatio(), 3.0/7.0; got != exp {
+		t.Fatalf("unexpected out of order ratio: got %v, exp %v", got, exp)
+	}
+}
+
+// Ensure values written out of order after an entry was merged are copied, and
+// that copying them leaves the entry as is.
+func TestCache_entry_sortedValues(t *testing.T) {
+	e, _, err := newEntryValues([]Value{NewValue(10, 1.0), NewValue(20, 2.0)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := e.add([]Value{NewValue(15, 1.5), NewValue(20, 3.0), NewValue(5, 0.5)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exp := Values{NewValue(5, 0.5), NewValue(10, 1.0), NewValue(15, 1.5), NewValue(20, 3.0)}
+	if got := e.sortedValues(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected values:\n\tgot: %v\n\texp: %v", got, exp)
+	}
+	if e.sorted != 2 || len(e.values) != 5 {
+		t.Fatalf("unexpected entry: sorted %d of %d values", e.sorted, len(e.values))
+	}
+}
+
+func TestCache_keysWithSnapshot(t *testing.T) {
+	c := NewCache(0, tsdb.EngineTags{})
+	if err := c.WriteMulti(map[string][]Value{
+		"foo": {NewValue(1, 1.0)},
+		"bar": {NewValue(1, 1.0)},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.Snapshot(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.WriteMulti(map[string][]Value{
+		"foo": {NewValue(2, 2.0)},
+		"baz": {NewValue(2, 2.0)},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, exp := c.Keys(), [][]byte{[]byte("baz"), []byte("foo")}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected keys:\n\tgot: %q\n\texp: %q", got, exp)
+	}
+	exp := [][]byte{[]byte("bar"), []byte("baz"), []byte("foo")}
+	if got := c.keysWithSnapshot(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("unexpected keys with snapshot:\n\tgot: %q\n\texp: %q", got, exp)
+	}
+}
+
 func TestCache_CacheSnapshot(t *testing.T) {
 	v0 := NewValue(2, 0.0)
 	v1 := NewValue(3, 2.0)
@@ -570,7 +712,7 @@ func TestCacheLoader_LoadSingle(t *testing.T) {
 	// Load the cache using the segment.
 	cache := NewCache(1024, tsdb.EngineTags{})
 	loader := NewCacheLoader([]string{f.Name()})
-	if err := loader.Load(cache); err != nil {
+	if _, err := loader.Load(cache); err != nil {
 		t.Fatalf("failed to load cache: %s", err.Error())
 	}
 
@@ -593,7 +735,7 @@ func TestCacheLoader_LoadSingle(t *testing.T) {
 	// Reload the cache using the segment.
 	cache = NewCache(1024, tsdb.EngineTags{})
 	loader = NewCacheLoader([]string{f.Name()})
-	if err := loader.Load(cache); err != nil {
+	if _, err := loader.Load(cache); err != nil {
 		t.Fatalf("failed to load cache: %s", err.Error())
 	}
 
@@ -660,7 +802,7 @@ func TestCacheLoader_LoadDouble(t *testing.T) {
 	// Load the cache using the segments.
 	cache := NewCache(1024, tsdb.EngineTags{})
 	loader := NewCacheLoader([]string{f1.Name(), f2.Name()})
-	if err := loader.Load(cache); err != nil {
+	if _, err := loader.Load(cache); err != nil {
 		t.Fatalf("failed to load cache: %s", err.Error())
 	}
 
@@ -727,7 +869,7 @@ func TestCacheLoader_LoadDeleted(t *testing.T) {
 	// Load the cache using the segment.
 	cache := NewCache(1024, tsdb.EngineTags{})
 	loader := NewCacheLoader([]string{f.Name()})
-	if err := loader.Load(cache); err != nil {
+	if _, err := loader.Load(cache); err != nil {
 		t.Fatalf("failed to load cache: %s", err.Error())
 	}
 
@@ -739,7 +881,7 @@ func TestCacheLoader_LoadDeleted(t *testing.T) {
 	// Reload the cache using the segment.
 	cache = NewCache(1024, tsdb.EngineTags{})
 	loader = NewCacheLoader([]string{f.Name()})
-	if err := loader.Load(cache); err != nil {
+	if _, err := loader.Load(cache); err != nil {
 		t.Fatalf("failed to load cache: %s", err.Error())
 	}
 
@@ -749,6 +891,93 @@ func TestCacheLoader_LoadDeleted(t *testing.T) {
 	}
 }
 
+// Ensure the CacheLoader reports the entries lost in corrupt and torn segments.
+func TestCacheLoader_LoadReport(t *testing.T) {
+	dir := t.TempDir()
+	f1, f2 := mustTempFile(dir), mustTempFile(dir)
+	w1, w2 := NewWALSegmentWriter(f1), NewWALSegmentWriter(f2)
+	t.Cleanup(func() {
+		f1.Close()
+		f2.Close()
+	})
+
+	// The second segment is written in the format without checksums.
+	w2.version = walSegmentVersionUnchecked
+
+	p1 := NewValue(1, 1.1)
+	p2 := NewValue(1, int64(1))
+	p3 := NewValue(1, true)
+
+	for _, values := range []map[string][]Value{{"foo": {p1}}, {"bar": {p2}}} {
+		if err := w1.Write(mustMarshalEntry(&WriteWALEntry{Values: values})); err != nil {
+			t.Fatal("write points", err)
+		}
+	}
+	if err := w2.Write(mustMarshalEntry(&WriteWALEntry{Values: map[string][]Value{"baz": {p3}}})); err != nil {
+		t.Fatal("write points", err)
+	}
+	if err := w1.Flush(); err != nil {
+		t.Fatalf("flush error: %v", err)
+	} else if err := w2.Flush(); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	// Corrupt the last entry of the first segment and tear the second segment.
+	size1 := int64(w1.size)
+	last := make([]byte, 1)
+	if _, err := f1.ReadAt(last, size1-1); err != nil {
+		t.Fatalf("read WAL segment: %s", err.Error())
+	}
+	if _, err := f1.WriteAt([]byte{^last[0]}, size1-1); err != nil {
+		t.Fatalf("corrupt WAL segment: %s", err.Error())
+	}
+	size2 := int64(w2.size)
+	if _, err := f2.Write([]byte{1, 4, 0, 0, 0}); err != nil {
+		t.Fatalf("corrupt WAL segment: %s", err.Error())
+	}
+
+	cache := NewCache(1024, tsdb.EngineTags{})
+	loader := NewCacheLoader([]string{f1.Name(), f2.Name()})
+	report, err := loader.Load(cache)
+	if err != nil {
+		t.Fatalf("failed to load cache: %s", err.Error())
+	}
+
+	exp := []WALSegmentDamage{
+		{Path: f1.Name(), Version: WALSegmentVersion, Entries: 1, Offset: int64(w1.offset), LostBytes: size1 - int64(w1.offset), Err: ErrWALChecksum},
+		{Path: f2.Name(), Version: walSegmentVersionUnchecked, Entries: 1, Offset: size2, LostBytes: 5, Torn: true, Err: io.ErrUnexpectedEOF},
+	}
+	if !reflect.DeepEqual(report.Segments, exp) {
+		t.Fatalf("unexpected report:\n\tgot: %+v\n\texp: %+v", report.Segments, exp)
+	}
+
+	// Check the cache and the truncated segments.
+	if values := cache.Values([]byte("foo")); !reflect.DeepEqual(values, Values{p1}) {
+		t.Fatalf("cache key foo not as expected, got %v, exp %v", values, Values{p1})
+	}
+	if values := cache.Values([]byte("bar")); values != nil {
+		t.Fatalf("cache key bar not as expected, got %v, exp %v", values, nil)
+	}
+	if values := cache.Values([]byte("baz")); !reflect.DeepEqual(values, Values{p3}) {
+		t.Fatalf("cache key baz not as expected, got %v, exp %v", values, Values{p3})
+	}
+	for _, e := range exp {
+		if fi, err := os.Stat(e.Path); err != nil {
+			t.Fatal(err)
+		} else if fi.Size() != e.Offset {
+			t.Fatalf("unexpected segment size: got %d, exp %d", fi.Size(), e.Offset)
+		}
+	}
+
+	// Reloading the truncated segments loses nothing.
+	report, err = NewCacheLoader([]string{f1.Name(), f2.Name()}).Load(NewCache(1024, tsdb.EngineTags{}))
+	if err != nil {
+		t.Fatalf("failed to load cache: %s", err.Error())
+	} else if !report.Empty() {
+		t.Fatalf("unexpected report: %+v", report.Segments)
+	}
+}
+
 func TestCache_Split(t *testing.T) {
 	v0 := NewValue(1, 1.0)
 	v1 := NewValue(2, 2.0)
@@ -821,9 +1050,12 @@ type TestStore struct {
 	countf       func() int
 }
 
-func NewTestStore() *TestStore                                      { return &TestStore{} }
-func (s *TestStore) entry(key []byte) *entry                        { return s.entryf(key) }
-func (s *TestStore) write(key []byte, values Values) (bool, error)  { return s.writef(key, values) }
+func NewTestStore() *TestStore               { return &TestStore{} }
+func (s *TestStore) entry(key []byte) *entry { return s.entryf(key) }
+func (s *TestStore) write(key []byte, values Values) (bool, int, error) {
+	ok, err := s.writef(key, values)
+	return ok, 0, err
+}
 func (s *TestStore) remove(key []byte)                              { s.removef(key) }
 func (s *TestStore) keys(sorted bool) [][]byte                      { return s.keysf(sorted) }
 func (s *TestStore) apply(f func([]byte, *entry) error) error       { return s.applyf(f) }
@@ -893,13 +1125,13 @@ func BenchmarkEntry_add(b *testing.B) {
 				otherValues[i] = NewValue(1, float64(i))
 			}
 
-			entry, err := newEntryValues(values)
+			entry, _, err := newEntryValues(values)
 			if err != nil {
 				b.Fatal(err)
 			}
 
 			b.StartTimer()
-			if err := entry.add(otherValues); err != nil {
+			if _, err := entry.add(otherValues); err != nil {
 				b.Fatal(err)
 			}
 		}
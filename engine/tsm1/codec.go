@@ -0,0 +1,141 @@
+package tsm1
+
+// Block codecs compress string blocks in TSM files and entries in WAL segments.
+// The ID of the codec used is recorded in the upper 4 bits of the string block
+// header and of the WAL entry type, so files written with one codec remain
+// readable after the configured codec changes.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/golang/snappy"
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+const (
+	// codecSnappy is the ID of the snappy codec. It matches stringCompressedSnappy
+	// so string blocks written before codecs were configurable stay readable.
+	codecSnappy = stringCompressedSnappy
+
+	// codecZstd is the ID of the zstd codec.
+	codecZstd = 2
+
+	// codecLZ4 is the ID of the lz4 codec.
+	codecLZ4 = 3
+)
+
+var errLZ4CorruptBlock = errors.New("lz4: corrupt block")
+
+func init() {
+	tsdb.RegisterBlockCodec(snappyCodec{})
+	tsdb.RegisterBlockCodec(newZstdCodec())
+	tsdb.RegisterBlockCodec(lz4Codec{})
+}
+
+// snappyCodec compresses blocks using snappy.
+type snappyCodec struct{}
+
+func (snappyCodec) ID() byte     { return codecSnappy }
+func (snappyCodec) Name() string { return "snappy" }
+
+func (snappyCodec) Encode(dst, src []byte) ([]byte, error) {
+	return snappy.Encode(dst[:cap(dst)], src), nil
+}
+
+func (snappyCodec) Decode(dst, src []byte) ([]byte, error) {
+	return snappy.Decode(dst[:cap(dst)], src)
+}
+
+// zstdCodec compresses blocks using zstd. The encoder and decoder are safe
+// for concurrent use when only EncodeAll and DecodeAll are called.
+type zstdCodec struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+func newZstdCodec() *zstdCodec {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		panic(fmt.Sprintf("zstd: unable to create encoder: %v", err))
+	}
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	if err != nil {
+		panic(fmt.Sprintf("zstd: unable to create decoder: %v", err))
+	}
+	return &zstdCodec{enc: enc, dec: dec}
+}
+
+func (c *zstdCodec) ID() byte     { return codecZstd }
+func (c *zstdCodec) Name() string { return "zstd" }
+
+func (c *zstdCodec) Encode(dst, src []byte) ([]byte, error) {
+	return c.enc.EncodeAll(src, dst[:0]), nil
+}
+
+func (c *zstdCodec) Decode(dst, src []byte) ([]byte, error) {
+	return c.dec.DecodeAll(src, dst[:0])
+}
+
+// lz4Codec compresses blocks using the lz4 block format. The lz4 block format
+// does not record the uncompressed length, so it is prefixed to each block as
+// a variable byte encoded integer.
+type lz4Codec struct{}
+
+func (lz4Codec) ID() byte     { return codecLZ4 }
+func (lz4Codec) Name() string { return "lz4" }
+
+func (lz4Codec) Encode(dst, src []byte) ([]byte, error) {
+	sz := binary.MaxVarintLen64 + lz4.CompressBlockBound(len(src))
+	if cap(dst) < sz {
+		dst = make([]byte, sz)
+	}
+	dst = dst[:sz]
+
+	n := binary.PutUvarint(dst, uint64(len(src)))
+	if len(src) == 0 {
+		return dst[:n], nil
+	}
+
+	m, err := lz4.CompressBlock(src, dst[n:], nil)
+	if err != nil {
+		return nil, err
+	}
+	return dst[:n+m], nil
+}
+
+func (lz4Codec) Decode(dst, src []byte) ([]byte, error) {
+	sz, n := binary.Uvarint(src)
+	if n <= 0 || sz > math.MaxUint32 {
+		return nil, errLZ4CorruptBlock
+	}
+
+	if uint64(cap(dst)) < sz {
+		dst = make([]byte, sz)
+	}
+	dst = dst[:sz]
+	if sz == 0 {
+		return dst, nil
+	}
+
+	m, err := lz4.UncompressBlock(src[n:], dst)
+	if err != nil {
+		return nil, err
+	} else if uint64(m) != sz {
+		return nil, errLZ4CorruptBlock
+	}
+	return dst, nil
+}
+
+// blockCodec returns the codec registered with id.
+func blockCodec(id byte) (tsdb.BlockCodec, error) {
+	c := tsdb.BlockCodecByID(id)
+	if c == nil {
+		return nil, fmt.Errorf("unknown block codec: %d", id)
+	}
+	return c, nil
+}
@@ -204,12 +204,14 @@ func (k *tsmBatchKeyIterator) chunkFloat(dst blocks) blocks {
 			return nil
 		}
 
-		dst = append(dst, &block{
+		blk := &block{
 			minTime: minTime,
 			maxTime: maxTime,
 			key:     k.key,
 			b:       cb,
-		})
+		}
+		blk.stats, blk.hasStats = newFloatBlockStats(&values)
+		dst = append(dst, blk)
 		k.mergedFloatValues.Timestamps = k.mergedFloatValues.Timestamps[k.size:]
 		k.mergedFloatValues.Values = k.mergedFloatValues.Values[k.size:]
 		return dst
@@ -224,12 +226,14 @@ func (k *tsmBatchKeyIterator) chunkFloat(dst blocks) blocks {
 			return nil
 		}
 
-		dst = append(dst, &block{
+		blk := &block{
 			minTime: minTime,
 			maxTime: maxTime,
 			key:     k.key,
 			b:       cb,
-		})
+		}
+		blk.stats, blk.hasStats = newFloatBlockStats(k.mergedFloatValues)
+		dst = append(dst, blk)
 		k.mergedFloatValues.Timestamps = k.mergedFloatValues.Timestamps[:0]
 		k.mergedFloatValues.Values = k.mergedFloatValues.Values[:0]
 	}
@@ -428,12 +432,14 @@ func (k *tsmBatchKeyIterator) chunkInteger(dst blocks) blocks {
 			return nil
 		}
 
-		dst = append(dst, &block{
+		blk := &block{
 			minTime: minTime,
 			maxTime: maxTime,
 			key:     k.key,
 			b:       cb,
-		})
+		}
+		blk.stats, blk.hasStats = newIntegerBlockStats(&values)
+		dst = append(dst, blk)
 		k.mergedIntegerValues.Timestamps = k.mergedIntegerValues.Timestamps[k.size:]
 		k.mergedIntegerValues.Values = k.mergedIntegerValues.Values[k.size:]
 		return dst
@@ -448,12 +454,14 @@ func (k *tsmBatchKeyIterator) chunkInteger(dst blocks) blocks {
 			return nil
 		}
 
-		dst = append(dst, &block{
+		blk := &block{
 			minTime: minTime,
 			maxTime: maxTime,
 			key:     k.key,
 			b:       cb,
-		})
+		}
+		blk.stats, blk.hasStats = newIntegerBlockStats(k.mergedIntegerValues)
+		dst = append(dst, blk)
 		k.mergedIntegerValues.Timestamps = k.mergedIntegerValues.Timestamps[:0]
 		k.mergedIntegerValues.Values = k.mergedIntegerValues.Values[:0]
 	}
@@ -652,12 +660,14 @@ func (k *tsmBatchKeyIterator) chunkUnsigned(dst blocks) blocks {
 			return nil
 		}
 
-		dst = append(dst, &block{
+		blk := &block{
 			minTime: minTime,
 			maxTime: maxTime,
 			key:     k.key,
 			b:       cb,
-		})
+		}
+		blk.stats, blk.hasStats = newUnsignedBlockStats(&values)
+		dst = append(dst, blk)
 		k.mergedUnsignedValues.Timestamps = k.mergedUnsignedValues.Timestamps[k.size:]
 		k.mergedUnsignedValues.Values = k.mergedUnsignedValues.Values[k.size:]
 		return dst
@@ -672,12 +682,14 @@ func (k *tsmBatchKeyIterator) chunkUnsigned(dst blocks) blocks {
 			return nil
 		}
 
-		dst = append(dst, &block{
+		blk := &block{
 			minTime: minTime,
 			maxTime: maxTime,
 			key:     k.key,
 			b:       cb,
-		})
+		}
+		blk.stats, blk.hasStats = newUnsignedBlockStats(k.mergedUnsignedValues)
+		dst = append(dst, blk)
 		k.mergedUnsignedValues.Timestamps = k.mergedUnsignedValues.Timestamps[:0]
 		k.mergedUnsignedValues.Values = k.mergedUnsignedValues.Values[:0]
 	}
@@ -870,18 +882,19 @@ func (k *tsmBatchKeyIterator) chunkString(dst blocks) blocks {
 		minTime, maxTime := values.Timestamps[0], values.Timestamps[len(values.Timestamps)-1]
 		values.Values = k.mergedStringValues.Values[:k.size]
 
-		cb, err := EncodeStringArrayBlock(&values, nil) // TODO(edd): pool this buffer
+		cb, err := EncodeStringArrayBlockUsing(&values, nil, k.stringCodec)
 		if err != nil {
 			k.handleEncodeError(err, "string")
 			return nil
 		}
 
-		dst = append(dst, &block{
+		blk := &block{
 			minTime: minTime,
 			maxTime: maxTime,
 			key:     k.key,
 			b:       cb,
-		})
+		}
+		dst = append(dst, blk)
 		k.mergedStringValues.Timestamps = k.mergedStringValues.Timestamps[k.size:]
 		k.mergedStringValues.Values = k.mergedStringValues.Values[k.size:]
 		return dst
@@ -890,18 +903,19 @@ func (k *tsmBatchKeyIterator) chunkString(dst blocks) blocks {
 	// Re-encode the remaining values into the last block
 	if k.mergedStringValues.Len() > 0 {
 		minTime, maxTime := k.mergedStringValues.Timestamps[0], k.mergedStringValues.Timestamps[len(k.mergedStringValues.Timestamps)-1]
-		cb, err := EncodeStringArrayBlock(k.mergedStringValues, nil) // TODO(edd): pool this buffer
+		cb, err := EncodeStringArrayBlockUsing(k.mergedStringValues, nil, k.stringCodec)
 		if err != nil {
 			k.handleEncodeError(err, "string")
 			return nil
 		}
 
-		dst = append(dst, &block{
+		blk := &block{
 			minTime: minTime,
 			maxTime: maxTime,
 			key:     k.key,
 			b:       cb,
-		})
+		}
+		dst = append(dst, blk)
 		k.mergedStringValues.Timestamps = k.mergedStringValues.Timestamps[:0]
 		k.mergedStringValues.Values = k.mergedStringValues.Values[:0]
 	}
@@ -1100,12 +1114,13 @@ func (k *tsmBatchKeyIterator) chunkBoolean(dst blocks) blocks {
 			return nil
 		}
 
-		dst = append(dst, &block{
+		blk := &block{
 			minTime: minTime,
 			maxTime: maxTime,
 			key:     k.key,
 			b:       cb,
-		})
+		}
+		dst = append(dst, blk)
 		k.mergedBooleanValues.Timestamps = k.mergedBooleanValues.Timestamps[k.size:]
 		k.mergedBooleanValues.Values = k.mergedBooleanValues.Values[k.size:]
 		return dst
@@ -1120,14 +1135,241 @@ func (k *tsmBatchKeyIterator) chunkBoolean(dst blocks) blocks {
 			return nil
 		}
 
-		dst = append(dst, &block{
+		blk := &block{
 			minTime: minTime,
 			maxTime: maxTime,
 			key:     k.key,
 			b:       cb,
-		})
+		}
+		dst = append(dst, blk)
 		k.mergedBooleanValues.Timestamps = k.mergedBooleanValues.Timestamps[:0]
 		k.mergedBooleanValues.Values = k.mergedBooleanValues.Values[:0]
 	}
 	return dst
 }
+
+// merge combines the next set of blocks into merged blocks.
+func (k *tsmBatchKeyIterator) mergeSketch() {
+	// No blocks left, or pending merged values, we're done
+	if len(k.blocks) == 0 && len(k.merged) == 0 && k.mergedSketchValues.Len() == 0 {
+		return
+	}
+
+	sort.Stable(k.blocks)
+
+	dedup := k.mergedSketchValues.Len() != 0
+	if len(k.blocks) > 0 && !dedup {
+		// If we have more than one block or any partially tombstoned blocks, we many need to dedup
+		dedup = len(k.blocks[0].tombstones) > 0 || k.blocks[0].partiallyRead()
+
+		// Quickly scan each block to see if any overlap with the prior block, if they overlap then
+		// we need to dedup as there may be duplicate points now
+		for i := 1; !dedup && i < len(k.blocks); i++ {
+			dedup = k.blocks[i].partiallyRead() ||
+				k.blocks[i].overlapsTimeRange(k.blocks[i-1].minTime, k.blocks[i-1].maxTime) ||
+				len(k.blocks[i].tombstones) > 0
+		}
+
+	}
+
+	k.merged = k.combineSketch(dedup)
+}
+
+// combine returns a new set of blocks using the current blocks in the buffers.  If dedup
+// is true, all the blocks will be decoded, dedup and sorted in in order.  If dedup is false,
+// only blocks that are smaller than the chunk size will be decoded and combined.
+func (k *tsmBatchKeyIterator) combineSketch(dedup bool) blocks {
+	if dedup {
+		for k.mergedSketchValues.Len() < k.size && len(k.blocks) > 0 {
+			for len(k.blocks) > 0 && k.blocks[0].read() {
+				k.blocks = k.blocks[1:]
+			}
+
+			if len(k.blocks) == 0 {
+				break
+			}
+			first := k.blocks[0]
+			minTime := first.minTime
+			maxTime := first.maxTime
+
+			// Adjust the min time to the start of any overlapping blocks.
+			for i := 0; i < len(k.blocks); i++ {
+				if k.blocks[i].overlapsTimeRange(minTime, maxTime) && !k.blocks[i].read() {
+					if k.blocks[i].minTime < minTime {
+						minTime = k.blocks[i].minTime
+					}
+					if k.blocks[i].maxTime > minTime && k.blocks[i].maxTime < maxTime {
+						maxTime = k.blocks[i].maxTime
+					}
+				}
+			}
+
+			// We have some overlapping blocks so decode all, append in order and then dedup
+			for i := 0; i < len(k.blocks); i++ {
+				if !k.blocks[i].overlapsTimeRange(minTime, maxTime) || k.blocks[i].read() {
+					continue
+				}
+
+				var v tsdb.SketchArray
+				var err error
+				if err = DecodeSketchArrayBlock(k.blocks[i].b, &v); err != nil {
+					k.handleDecodeError(err, "sketch")
+					return nil
+				}
+
+				// Invariant: v.MaxTime() == k.blocks[i].maxTime
+				if k.blocks[i].maxTime != v.MaxTime() {
+					if maxTime == k.blocks[i].maxTime {
+						maxTime = v.MaxTime()
+					}
+					k.blocks[i].maxTime = v.MaxTime()
+				}
+
+				// Remove values we already read
+				v.Exclude(k.blocks[i].readMin, k.blocks[i].readMax)
+
+				// Filter out only the values for overlapping block
+				v.Include(minTime, maxTime)
+				if v.Len() > 0 {
+					// Record that we read a subset of the block
+					k.blocks[i].markRead(v.MinTime(), v.MaxTime())
+				}
+
+				// Apply each tombstone to the block
+				for _, ts := range k.blocks[i].tombstones {
+					v.Exclude(ts.Min, ts.Max)
+				}
+
+				k.mergedSketchValues.Merge(&v)
+			}
+		}
+
+		// Since we combined multiple blocks, we could have more values than we should put into
+		// a single block.  We need to chunk them up into groups and re-encode them.
+		return k.chunkSketch(nil)
+	}
+	var i int
+
+	for ; i < len(k.blocks); i++ {
+
+		// skip this block if it's values were already read
+		if k.blocks[i].read() {
+			continue
+		}
+
+		// if this block is already full, just add it as is
+		count, err := BlockCount(k.blocks[i].b)
+		if err != nil {
+			k.AppendError(err)
+			continue
+		}
+
+		if count < k.size {
+			break
+		}
+
+		k.merged = append(k.merged, k.blocks[i])
+	}
+
+	if k.fast {
+		for i < len(k.blocks) {
+			// skip this block if it's values were already read
+			if k.blocks[i].read() {
+				i++
+				continue
+			}
+
+			k.merged = append(k.merged, k.blocks[i])
+			i++
+		}
+	}
+
+	// if we only have 1 blocks left, just append it as is and avoid decoding/recoding
+	if i == len(k.blocks)-1 {
+		if !k.blocks[i].read() {
+			k.merged = append(k.merged, k.blocks[i])
+		}
+		i++
+	}
+
+	// The remaining blocks can be combined and we know that they do not overlap and
+	// so we can just append each, sort and re-encode.
+	for i < len(k.blocks) && k.mergedSketchValues.Len() < k.size {
+		if k.blocks[i].read() {
+			i++
+			continue
+		}
+
+		var v tsdb.SketchArray
+		if err := DecodeSketchArrayBlock(k.blocks[i].b, &v); err != nil {
+			k.handleDecodeError(err, "sketch")
+			return nil
+		}
+
+		// Invariant: v.MaxTime() == k.blocks[i].maxTime
+		if k.blocks[i].maxTime != v.MaxTime() {
+			k.blocks[i].maxTime = v.MaxTime()
+		}
+
+		// Apply each tombstone to the block
+		for _, ts := range k.blocks[i].tombstones {
+			v.Exclude(ts.Min, ts.Max)
+		}
+
+		k.blocks[i].markRead(k.blocks[i].minTime, k.blocks[i].maxTime)
+
+		k.mergedSketchValues.Merge(&v)
+		i++
+	}
+
+	k.blocks = k.blocks[i:]
+
+	return k.chunkSketch(k.merged)
+}
+
+func (k *tsmBatchKeyIterator) chunkSketch(dst blocks) blocks {
+	if k.mergedSketchValues.Len() > k.size {
+		var values tsdb.SketchArray
+		values.Timestamps = k.mergedSketchValues.Timestamps[:k.size]
+		minTime, maxTime := values.Timestamps[0], values.Timestamps[len(values.Timestamps)-1]
+		values.Values = k.mergedSketchValues.Values[:k.size]
+
+		cb, err := EncodeSketchArrayBlock(&values, nil)
+		if err != nil {
+			k.handleEncodeError(err, "sketch")
+			return nil
+		}
+
+		blk := &block{
+			minTime: minTime,
+			maxTime: maxTime,
+			key:     k.key,
+			b:       cb,
+		}
+		dst = append(dst, blk)
+		k.mergedSketchValues.Timestamps = k.mergedSketchValues.Timestamps[k.size:]
+		k.mergedSketchValues.Values = k.mergedSketchValues.Values[k.size:]
+		return dst
+	}
+
+	// Re-encode the remaining values into the last block
+	if k.mergedSketchValues.Len() > 0 {
+		minTime, maxTime := k.mergedSketchValues.Timestamps[0], k.mergedSketchValues.Timestamps[len(k.mergedSketchValues.Timestamps)-1]
+		cb, err := EncodeSketchArrayBlock(k.mergedSketchValues, nil)
+		if err != nil {
+			k.handleEncodeError(err, "sketch")
+			return nil
+		}
+
+		blk := &block{
+			minTime: minTime,
+			maxTime: maxTime,
+			key:     k.key,
+			b:       cb,
+		}
+		dst = append(dst, blk)
+		k.mergedSketchValues.Timestamps = k.mergedSketchValues.Timestamps[:0]
+		k.mergedSketchValues.Values = k.mergedSketchValues.Values[:0]
+	}
+	return dst
+}
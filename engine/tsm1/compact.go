@@ -710,6 +710,10 @@ type Compactor struct {
 	Dir  string
 	Size int
 
+	// ColdDir is the directory of the cold storage tier, if any.  Files
+	// compacted only from files in ColdDir are written to ColdDir.
+	ColdDir string
+
 	FileStore interface {
 		NextGeneration() int
 		TSMReader(path string) *TSMReader
@@ -718,6 +722,14 @@ type Compactor struct {
 	// RateLimit is the limit for disk writes for all concurrent compactions.
 	RateLimit limiter.Rate
 
+	// StringCodec is the codec used to compress string blocks in new TSM files.
+	// Snappy is used if nil.
+	StringCodec tsdb.BlockCodec
+
+	// Retention holds the series retention rules of the shard.  Values they
+	// expire are dropped from the new TSM files.
+	Retention *tsdb.SeriesRetention
+
 	formatFileName FormatFileNameFunc
 	parseFileName  ParseFileNameFunc
 
@@ -736,6 +748,10 @@ type Compactor struct {
 	compactionsInterrupt chan struct{}
 
 	files map[string]struct{}
+
+	// compactions are the running compactions by ID.
+	compactions      map[uint64]*compactionProgress
+	lastCompactionID uint64
 }
 
 // NewCompactor returns a new instance of Compactor.
@@ -869,8 +885,8 @@ func (c *Compactor) WriteSnapshot(cache *Cache, logger *zap.Logger) ([]string, e
 	resC := make(chan res, concurrency)
 	for i := 0; i < concurrency; i++ {
 		go func(sp *Cache) {
-			iter := NewCacheKeyIterator(sp, tsdb.DefaultMaxPointsPerBlock, intC)
-			files, err := c.writeNewFiles(c.FileStore.NextGeneration(), 0, nil, iter, throttle, logger)
+			iter := c.expire(newCacheKeyIterator(sp, tsdb.DefaultMaxPointsPerBlock, c.StringCodec, intC))
+			files, err := c.writeNewFiles(c.FileStore.NextGeneration(), 0, nil, iter, throttle, nil, logger)
 			resC <- res{files: files, err: err}
 
 		}(splits[i])
@@ -903,15 +919,20 @@ func (c *Compactor) WriteSnapshot(cache *Cache, logger *zap.Logger) ([]string, e
 	return files, err
 }
 
-// compact writes multiple smaller TSM files into 1 or more larger files.
-func (c *Compactor) compact(fast bool, tsmFiles []string, logger *zap.Logger) ([]string, error) {
+// compact writes multiple smaller TSM files into 1 or more larger files.  If wrap
+// is not nil, the keys written are read from the iterator it returns.  The
+// progress of the compaction is recorded in p, which is created if nil.
+func (c *Compactor) compact(fast bool, tsmFiles []string, wrap func(KeyIterator) KeyIterator, p *compactionProgress, logger *zap.Logger) ([]string, error) {
 	size := c.Size
 	if size <= 0 {
 		size = tsdb.DefaultMaxPointsPerBlock
 	}
 
+	p = c.startCompaction(p, tsmFiles)
+	defer c.finishCompaction(p)
+
 	c.mu.RLock()
-	intC := c.compactionsInterrupt
+	intC := p.link(c.compactionsInterrupt)
 	c.mu.RUnlock()
 
 	// The new compacted files need to added to the max generation in the
@@ -952,6 +973,7 @@ func (c *Compactor) compact(fast bool, tsmFiles []string, logger *zap.Logger) ([
 		}
 		defer tr.Unref() // inform that we're done with this reader when this method returns.
 		trs = append(trs, tr)
+		p.addTotal(int64(tr.Size()))
 	}
 
 	if len(trs) == 0 {
@@ -959,48 +981,42 @@ func (c *Compactor) compact(fast bool, tsmFiles []string, logger *zap.Logger) ([
 		return nil, nil
 	}
 
-	tsm, err := NewTSMBatchKeyIterator(size, fast, DefaultMaxSavedErrors, intC, tsmFiles, trs...)
+	batch, err := newTSMBatchKeyIterator(size, fast, DefaultMaxSavedErrors, c.StringCodec, intC, tsmFiles, trs...)
 	if err != nil {
 		return nil, err
 	}
+	batch.progress = p
 
-	return c.writeNewFiles(maxGeneration, maxSequence, tsmFiles, tsm, true, logger)
-}
-
-// CompactFull writes multiple smaller TSM files into 1 or more larger files.
-func (c *Compactor) CompactFull(tsmFiles []string, logger *zap.Logger) ([]string, error) {
-	c.mu.RLock()
-	enabled := c.compactionsEnabled
-	c.mu.RUnlock()
-
-	if !enabled {
-		return nil, errCompactionsDisabled
-	}
-
-	if !c.add(tsmFiles) {
-		return nil, errCompactionInProgress{}
+	tsm := c.expire(batch)
+	if wrap != nil {
+		tsm = wrap(tsm)
 	}
-	defer c.remove(tsmFiles)
 
-	files, err := c.compact(false, tsmFiles, logger)
-
-	// See if we were disabled while writing a snapshot
-	c.mu.RLock()
-	enabled = c.compactionsEnabled
-	c.mu.RUnlock()
+	return c.writeNewFiles(maxGeneration, maxSequence, tsmFiles, tsm, true, p, logger)
+}
 
-	if !enabled {
-		if err := c.removeTmpFiles(files); err != nil {
-			return nil, err
-		}
-		return nil, errCompactionsDisabled
+// expire wraps iter to drop the values expired by the series retention rules,
+// if there are any.
+func (c *Compactor) expire(iter KeyIterator) KeyIterator {
+	if c.Retention.Len() == 0 {
+		return iter
 	}
+	return newRetentionKeyIterator(iter, c.Retention, time.Now().UnixNano(), c.StringCodec)
+}
 
-	return files, err
+// CompactFull writes multiple smaller TSM files into 1 or more larger files.
+func (c *Compactor) CompactFull(tsmFiles []string, logger *zap.Logger) ([]string, error) {
+	return c.compactFiles(false, tsmFiles, nil, logger)
 }
 
 // CompactFast writes multiple smaller TSM files into 1 or more larger files.
 func (c *Compactor) CompactFast(tsmFiles []string, logger *zap.Logger) ([]string, error) {
+	return c.compactFiles(true, tsmFiles, nil, logger)
+}
+
+// compactFiles writes multiple smaller TSM files into 1 or more larger files,
+// recording the progress of the compaction in p.
+func (c *Compactor) compactFiles(fast bool, tsmFiles []string, p *compactionProgress, logger *zap.Logger) ([]string, error) {
 	c.mu.RLock()
 	enabled := c.compactionsEnabled
 	c.mu.RUnlock()
@@ -1014,7 +1030,7 @@ func (c *Compactor) CompactFast(tsmFiles []string, logger *zap.Logger) ([]string
 	}
 	defer c.remove(tsmFiles)
 
-	files, err := c.compact(true, tsmFiles, logger)
+	files, err := c.compact(fast, tsmFiles, nil, p, logger)
 
 	// See if we were disabled while writing a snapshot
 	c.mu.RLock()
@@ -1029,7 +1045,6 @@ func (c *Compactor) CompactFast(tsmFiles []string, logger *zap.Logger) ([]string
 	}
 
 	return files, err
-
 }
 
 // removeTmpFiles is responsible for cleaning up a compaction that
@@ -1043,21 +1058,36 @@ func (c *Compactor) removeTmpFiles(files []string) error {
 	return nil
 }
 
+// outputDir returns the directory to write the files compacted from src to.
+// Files compacted from the cold tier stay in the cold tier.
+func (c *Compactor) outputDir(src []string) string {
+	if c.ColdDir == "" || len(src) == 0 {
+		return c.Dir
+	}
+	for _, f := range src {
+		if filepath.Dir(f) != filepath.Clean(c.ColdDir) {
+			return c.Dir
+		}
+	}
+	return c.ColdDir
+}
+
 // writeNewFiles writes from the iterator into new TSM files, rotating
 // to a new file once it has reached the max TSM file size.
-func (c *Compactor) writeNewFiles(generation, sequence int, src []string, iter KeyIterator, throttle bool, logger *zap.Logger) ([]string, error) {
+func (c *Compactor) writeNewFiles(generation, sequence int, src []string, iter KeyIterator, throttle bool, p *compactionProgress, logger *zap.Logger) ([]string, error) {
 	// These are the new TSM files written
 	var files []string
 
+	dir := c.outputDir(src)
 	for {
 		sequence++
 
 		// New TSM files are written to a temp file and renamed when fully completed.
-		fileName := filepath.Join(c.Dir, c.formatFileName(generation, sequence)+"."+TSMFileExtension+"."+TmpTSMFileExtension)
+		fileName := filepath.Join(dir, c.formatFileName(generation, sequence)+"."+TSMFileExtension+"."+TmpTSMFileExtension)
 		logger.Debug("Compacting files", zap.Int("file_count", len(src)), zap.String("output_file", fileName))
 
 		// Write as much as possible to this file
-		err := c.write(fileName, iter, throttle, logger)
+		err := c.write(fileName, iter, throttle, p, logger)
 
 		// We've hit the max file limit and there is more to write.  Create a new file
 		// and continue.
@@ -1097,7 +1127,7 @@ func (c *Compactor) writeNewFiles(generation, sequence int, src []string, iter K
 	return files, nil
 }
 
-func (c *Compactor) write(path string, iter KeyIterator, throttle bool, logger *zap.Logger) (err error) {
+func (c *Compactor) write(path string, iter KeyIterator, throttle bool, p *compactionProgress, logger *zap.Logger) (err error) {
 	fd, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_EXCL, 0666)
 	if err != nil {
 		return errCompactionInProgress{err: err}
@@ -1118,7 +1148,7 @@ func (c *Compactor) write(path string, iter KeyIterator, throttle bool, logger *
 	)
 
 	if c.RateLimit != nil && throttle {
-		limitWriter = limiter.NewWriterWithRate(fd, c.RateLimit)
+		limitWriter = limiter.NewWriterWithRate(fd, p.rate(c.RateLimit))
 	}
 
 	// Use a disk based TSM buffer if it looks like we might create a big index
@@ -1175,8 +1205,13 @@ func (c *Compactor) write(path string, iter KeyIterator, throttle bool, logger *
 			return fmt.Errorf("invalid index entry for block. min=%d, max=%d", minTime, maxTime)
 		}
 
-		// Write the key and value
-		if err := w.WriteBlock(key, minTime, maxTime, block); err == ErrMaxBlocksExceeded {
+		// Write the key and value, with the statistics of the block if the
+		// iterator has them.
+		var stats *BlockStats
+		if si, ok := iter.(blockStatsIterator); ok {
+			stats = si.blockStats()
+		}
+		if err := writeBlock(w, key, minTime, maxTime, block, stats); err == ErrMaxBlocksExceeded {
 			if err := w.WriteIndex(); err != nil {
 				return err
 			}
@@ -1184,6 +1219,7 @@ func (c *Compactor) write(path string, iter KeyIterator, throttle bool, logger *
 		} else if err != nil {
 			return err
 		}
+		p.addWritten(len(block))
 
 		// If we have a max file size configured and we're over it, close out the file
 		// and return the error.
@@ -1238,6 +1274,15 @@ func (c *Compactor) remove(files []string) {
 	}
 }
 
+// writeBlock writes block to w, recording s as its statistics if s is not nil
+// and w supports them.
+func writeBlock(w TSMWriter, key []byte, minTime, maxTime int64, block []byte, s *BlockStats) error {
+	if sw, ok := w.(blockStatsWriter); ok {
+		return sw.writeBlockWithStats(key, minTime, maxTime, block, s)
+	}
+	return w.WriteBlock(key, minTime, maxTime, block)
+}
+
 // KeyIterator allows iteration over set of keys and values in sorted order.
 type KeyIterator interface {
 	// Next returns true if there are any values remaining in the iterator.
@@ -1257,6 +1302,14 @@ type KeyIterator interface {
 	// be required to store all the series and entries in the KeyIterator.
 	EstimatedIndexSize() int
 }
+
+// blockStatsIterator is implemented by key iterators that know the statistics
+// of the blocks they return without decoding them.
+type blockStatsIterator interface {
+	// blockStats returns the statistics of the block returned by the last call
+	// to Read, or nil if they are not known.
+	blockStats() *BlockStats
+}
 type TSMErrors []error
 
 func (t TSMErrors) Error() string {
@@ -1274,6 +1327,10 @@ type block struct {
 	b                []byte
 	tombstones       []TimeRange
 
+	// stats holds the statistics of the block, if hasStats is set.
+	stats    BlockStats
+	hasStats bool
+
 	// readMin, readMax are the timestamps range of values have been
 	// read and encoded from this block.
 	readMin, readMax int64
@@ -1347,6 +1404,9 @@ type tsmBatchKeyIterator struct {
 	// size is the maximum number of values to encode in a single block
 	size int
 
+	// stringCodec is the codec used to compress string blocks.
+	stringCodec tsdb.BlockCodec
+
 	// key is the current key lowest key across all readers that has not be fully exhausted
 	// of values.
 	key []byte
@@ -1369,12 +1429,16 @@ type tsmBatchKeyIterator struct {
 	mergedUnsignedValues *tsdb.UnsignedArray
 	mergedBooleanValues  *tsdb.BooleanArray
 	mergedStringValues   *tsdb.StringArray
+	mergedSketchValues   *tsdb.SketchArray
 
 	// merged are encoded blocks that have been combined or used as is
 	// without decode
 	merged    blocks
 	interrupt chan struct{}
 
+	// progress records the bytes read by the compaction, if any.
+	progress *compactionProgress
+
 	// maxErrors is the maximum number of errors to store before discarding.
 	maxErrors int
 	// overflowErrors is the number of errors we have ignored.
@@ -1396,6 +1460,10 @@ func (t *tsmBatchKeyIterator) AppendError(err error) bool {
 // NewTSMBatchKeyIterator returns a new TSM key iterator from readers.
 // size indicates the maximum number of values to encode in a single block.
 func NewTSMBatchKeyIterator(size int, fast bool, maxErrors int, interrupt chan struct{}, tsmFiles []string, readers ...*TSMReader) (KeyIterator, error) {
+	return newTSMBatchKeyIterator(size, fast, maxErrors, nil, interrupt, tsmFiles, readers...)
+}
+
+func newTSMBatchKeyIterator(size int, fast bool, maxErrors int, stringCodec tsdb.BlockCodec, interrupt chan struct{}, tsmFiles []string, readers ...*TSMReader) (*tsmBatchKeyIterator, error) {
 	var iter []*BlockIterator
 	for _, r := range readers {
 		iter = append(iter, r.BlockIterator())
@@ -1406,6 +1474,7 @@ func NewTSMBatchKeyIterator(size int, fast bool, maxErrors int, interrupt chan s
 		values:               map[string][]Value{},
 		pos:                  make([]int, len(readers)),
 		size:                 size,
+		stringCodec:          stringCodec,
 		iterators:            iter,
 		fast:                 fast,
 		tsmFiles:             tsmFiles,
@@ -1415,6 +1484,7 @@ func NewTSMBatchKeyIterator(size int, fast bool, maxErrors int, interrupt chan s
 		mergedUnsignedValues: &tsdb.UnsignedArray{},
 		mergedBooleanValues:  &tsdb.BooleanArray{},
 		mergedStringValues:   &tsdb.StringArray{},
+		mergedSketchValues:   &tsdb.SketchArray{},
 		interrupt:            interrupt,
 		maxErrors:            maxErrors,
 	}, nil
@@ -1425,7 +1495,8 @@ func (k *tsmBatchKeyIterator) hasMergedValues() bool {
 		k.mergedIntegerValues.Len() > 0 ||
 		k.mergedUnsignedValues.Len() > 0 ||
 		k.mergedStringValues.Len() > 0 ||
-		k.mergedBooleanValues.Len() > 0
+		k.mergedBooleanValues.Len() > 0 ||
+		k.mergedSketchValues.Len() > 0
 }
 
 func (k *tsmBatchKeyIterator) EstimatedIndexSize() int {
@@ -1476,6 +1547,7 @@ RETRY:
 			if err != nil {
 				k.AppendError(errBlockRead{k.currentTsm, err})
 			}
+			k.progress.addRead(len(b))
 
 			// This block may have ranges of time removed from it that would
 			// reduce the block min and max time.
@@ -1499,6 +1571,7 @@ RETRY:
 			blk.typ = typ
 			blk.b = b
 			blk.tombstones = tombstones
+			blk.stats, blk.hasStats = iter.blockStats()
 			blk.readMin = math.MaxInt64
 			blk.readMax = math.MinInt64
 
@@ -1509,6 +1582,7 @@ RETRY:
 				if err != nil {
 					k.AppendError(errBlockRead{k.currentTsm, err})
 				}
+				k.progress.addRead(len(b))
 
 				tombstones := iter.r.TombstoneRange(key)
 
@@ -1531,6 +1605,7 @@ RETRY:
 				blk.typ = typ
 				blk.b = b
 				blk.tombstones = tombstones
+				blk.stats, blk.hasStats = iter.blockStats()
 				blk.readMin = math.MaxInt64
 				blk.readMax = math.MinInt64
 			}
@@ -1598,6 +1673,8 @@ func (k *tsmBatchKeyIterator) merge() {
 		k.mergeBoolean()
 	case BlockString:
 		k.mergeString()
+	case BlockSketch:
+		k.mergeSketch()
 	default:
 		k.AppendError(errBlockRead{k.currentTsm, fmt.Errorf("unknown block type: %v", k.typ)})
 	}
@@ -1627,6 +1704,13 @@ func (k *tsmBatchKeyIterator) Read() ([]byte, int64, int64, []byte, error) {
 	return block.key, block.minTime, block.maxTime, block.b, k.Err()
 }
 
+func (k *tsmBatchKeyIterator) blockStats() *BlockStats {
+	if len(k.merged) == 0 || !k.merged[0].hasStats {
+		return nil
+	}
+	return &k.merged[0].stats
+}
+
 func (k *tsmBatchKeyIterator) Close() error {
 	k.values = nil
 	k.pos = nil
@@ -1652,9 +1736,10 @@ func (k *tsmBatchKeyIterator) Err() error {
 }
 
 type cacheKeyIterator struct {
-	cache *Cache
-	size  int
-	order [][]byte
+	cache       *Cache
+	size        int
+	stringCodec tsdb.BlockCodec
+	order       [][]byte
 
 	i         int
 	blocks    [][]cacheBlock
@@ -1667,11 +1752,17 @@ type cacheBlock struct {
 	k                []byte
 	minTime, maxTime int64
 	b                []byte
+	stats            BlockStats
+	hasStats         bool
 	err              error
 }
 
 // NewCacheKeyIterator returns a new KeyIterator from a Cache.
 func NewCacheKeyIterator(cache *Cache, size int, interrupt chan struct{}) KeyIterator {
+	return newCacheKeyIterator(cache, size, nil, interrupt)
+}
+
+func newCacheKeyIterator(cache *Cache, size int, stringCodec tsdb.BlockCodec, interrupt chan struct{}) KeyIterator {
 	keys := cache.Keys()
 
 	chans := make([]chan struct{}, len(keys))
@@ -1680,13 +1771,14 @@ func NewCacheKeyIterator(cache *Cache, size int, interrupt chan struct{}) KeyIte
 	}
 
 	cki := &cacheKeyIterator{
-		i:         -1,
-		size:      size,
-		cache:     cache,
-		order:     keys,
-		ready:     chans,
-		blocks:    make([][]cacheBlock, len(keys)),
-		interrupt: interrupt,
+		i:           -1,
+		size:        size,
+		stringCodec: stringCodec,
+		cache:       cache,
+		order:       keys,
+		ready:       chans,
+		blocks:      make([][]cacheBlock, len(keys)),
+		interrupt:   interrupt,
 	}
 	go cki.encode()
 	return cki
@@ -1717,6 +1809,8 @@ func (c *cacheKeyIterator) encode() {
 			uenc := getUnsignedEncoder(tsdb.DefaultMaxPointsPerBlock)
 			senc := getStringEncoder(tsdb.DefaultMaxPointsPerBlock)
 			ienc := getIntegerEncoder(tsdb.DefaultMaxPointsPerBlock)
+			senc.SetCodec(c.stringCodec)
+			var statsBufs blockStatsBuffers
 
 			defer putTimeEncoder(tenc)
 			defer putFloatEncoder(fenc)
@@ -1760,15 +1854,18 @@ func (c *cacheKeyIterator) encode() {
 					default:
 						b, err = Values(values[:end]).Encode(nil)
 					}
+					stats, hasStats := newValuesBlockStats(values[:end], &statsBufs)
 
 					values = values[end:]
 
 					c.blocks[i] = append(c.blocks[i], cacheBlock{
-						k:       key,
-						minTime: minTime,
-						maxTime: maxTime,
-						b:       b,
-						err:     err,
+						k:        key,
+						minTime:  minTime,
+						maxTime:  maxTime,
+						b:        b,
+						stats:    stats,
+						hasStats: hasStats,
+						err:      err,
 					})
 
 					if err != nil {
@@ -1812,6 +1909,13 @@ func (c *cacheKeyIterator) Read() ([]byte, int64, int64, []byte, error) {
 	return blk.k, blk.minTime, blk.maxTime, blk.b, blk.err
 }
 
+func (c *cacheKeyIterator) blockStats() *BlockStats {
+	if blk := &c.blocks[c.i][0]; blk.hasStats {
+		return &blk.stats
+	}
+	return nil
+}
+
 func (c *cacheKeyIterator) Close() error {
 	return nil
 }
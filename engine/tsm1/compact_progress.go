@@ -0,0 +1,191 @@
+package tsm1
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdata/influxdb/v2/pkg/limiter"
+	"github.com/influxdata/influxdb/v2/tsdb"
+)
+
+// compactionProgress tracks a running compaction and allows it to be canceled.
+// The methods of a nil compactionProgress are no-ops.
+type compactionProgress struct {
+	id    uint64
+	level string
+	files []string
+	start time.Time
+
+	// Accessed atomically.
+	bytesTotal, bytesRead, bytesWritten int64
+	throttled                           int32
+	throttleWait                        int64
+
+	// interrupt is closed when the compaction is canceled or compactions are
+	// disabled, and done when the compaction returns.
+	interrupt chan struct{}
+	done      chan struct{}
+	cancel    sync.Once
+	canceled  int32
+}
+
+func newCompactionProgress(level string, files []string) *compactionProgress {
+	return &compactionProgress{
+		level:     level,
+		files:     files,
+		start:     time.Now(),
+		interrupt: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// link cancels the compaction if intC is closed before it finishes, and returns
+// the interrupt channel of the compaction.
+func (p *compactionProgress) link(intC chan struct{}) chan struct{} {
+	go func() {
+		select {
+		case <-intC:
+			p.stop()
+		case <-p.done:
+		}
+	}()
+	return p.interrupt
+}
+
+// stop closes the interrupt channel of the compaction.
+func (p *compactionProgress) stop() {
+	p.cancel.Do(func() { close(p.interrupt) })
+}
+
+// Cancel aborts the compaction.
+func (p *compactionProgress) Cancel() {
+	atomic.StoreInt32(&p.canceled, 1)
+	p.stop()
+}
+
+// Canceled returns true if the compaction was canceled by Cancel.
+func (p *compactionProgress) Canceled() bool {
+	return p != nil && atomic.LoadInt32(&p.canceled) == 1
+}
+
+func (p *compactionProgress) addTotal(n int64) {
+	if p != nil {
+		atomic.AddInt64(&p.bytesTotal, n)
+	}
+}
+
+func (p *compactionProgress) addRead(n int) {
+	if p != nil {
+		atomic.AddInt64(&p.bytesRead, int64(n))
+	}
+}
+
+func (p *compactionProgress) addWritten(n int) {
+	if p != nil {
+		atomic.AddInt64(&p.bytesWritten, int64(n))
+	}
+}
+
+// rate returns r, recording the time spent waiting on it in the progress.
+func (p *compactionProgress) rate(r limiter.Rate) limiter.Rate {
+	if p == nil {
+		return r
+	}
+	atomic.StoreInt32(&p.throttled, 1)
+	return &compactionRate{Rate: r, p: p}
+}
+
+// Status returns the status of the compaction at now.
+func (p *compactionProgress) Status(now time.Time) tsdb.CompactionStatus {
+	s := tsdb.CompactionStatus{
+		ID:           p.id,
+		Level:        p.level,
+		Files:        append([]string(nil), p.files...),
+		Running:      true,
+		StartTime:    p.start,
+		BytesTotal:   atomic.LoadInt64(&p.bytesTotal),
+		BytesRead:    atomic.LoadInt64(&p.bytesRead),
+		BytesWritten: atomic.LoadInt64(&p.bytesWritten),
+		Throttled:    atomic.LoadInt32(&p.throttled) == 1,
+		ThrottleWait: time.Duration(atomic.LoadInt64(&p.throttleWait)),
+	}
+
+	if s.BytesRead > 0 && s.BytesTotal >= s.BytesRead {
+		elapsed := now.Sub(p.start)
+		remaining := time.Duration(float64(elapsed) * float64(s.BytesTotal-s.BytesRead) / float64(s.BytesRead))
+		s.EstimatedCompletion = now.Add(remaining)
+	}
+	return s
+}
+
+// compactionRate is a limiter.Rate recording the time a compaction waits on it.
+type compactionRate struct {
+	limiter.Rate
+	p *compactionProgress
+}
+
+// WaitN blocks until n bytes may be written.
+func (r *compactionRate) WaitN(ctx context.Context, n int) error {
+	start := time.Now()
+	err := r.Rate.WaitN(ctx, n)
+	atomic.AddInt64(&r.p.throttleWait, int64(time.Since(start)))
+	return err
+}
+
+// startCompaction registers p as running, assigning it an ID if it has none.
+// A progress is created if p is nil.
+func (c *Compactor) startCompaction(p *compactionProgress, files []string) *compactionProgress {
+	if p == nil {
+		p = newCompactionProgress("", files)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.compactions == nil {
+		c.compactions = make(map[uint64]*compactionProgress)
+	}
+	c.lastCompactionID++
+	p.id = c.lastCompactionID
+	c.compactions[p.id] = p
+	return p
+}
+
+// finishCompaction unregisters p.
+func (c *Compactor) finishCompaction(p *compactionProgress) {
+	c.mu.Lock()
+	delete(c.compactions, p.id)
+	c.mu.Unlock()
+	close(p.done)
+}
+
+// Compactions returns the status of the running compactions, ordered by ID.
+func (c *Compactor) Compactions() []tsdb.CompactionStatus {
+	now := time.Now()
+
+	c.mu.RLock()
+	statuses := make([]tsdb.CompactionStatus, 0, len(c.compactions))
+	for _, p := range c.compactions {
+		statuses = append(statuses, p.Status(now))
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// CancelCompaction aborts the running compaction with the given ID.  It returns
+// false if there is no such compaction.
+func (c *Compactor) CancelCompaction(id uint64) bool {
+	c.mu.RLock()
+	p := c.compactions[id]
+	c.mu.RUnlock()
+
+	if p == nil {
+		return false
+	}
+	p.Cancel()
+	return true
+}
@@ -5,13 +5,16 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/influxdata/influxdb/v2/tsdb"
 	"github.com/influxdata/influxdb/v2/tsdb/engine/tsm1"
+	"github.com/influxdata/influxdb/v2/tsdb/sketch"
 	"go.uber.org/zap"
 )
 
@@ -147,6 +150,45 @@ func TestCompactor_CompactFullLastTimestamp(t *testing.T) {
 	}
 }
 
+// Ensures that files compacted only from files in the cold tier are written to
+// the cold tier, and files compacted from both tiers to the shard directory.
+func TestCompactor_CompactFull_ColdTier(t *testing.T) {
+	dir, cold := t.TempDir(), t.TempDir()
+
+	c1 := MustWriteTSM(t, cold, 1, map[string][]tsm1.Value{"cpu,host=A#!~#value": {tsm1.NewValue(1, 1.1)}})
+	c2 := MustWriteTSM(t, cold, 2, map[string][]tsm1.Value{"cpu,host=A#!~#value": {tsm1.NewValue(2, 1.2)}})
+	h3 := MustWriteTSM(t, dir, 3, map[string][]tsm1.Value{"cpu,host=A#!~#value": {tsm1.NewValue(3, 1.3)}})
+
+	fs := &fakeFileStore{}
+	t.Cleanup(func() { fs.Close() })
+
+	compactor := tsm1.NewCompactor()
+	compactor.Dir = dir
+	compactor.ColdDir = cold
+	compactor.FileStore = fs
+	compactor.Open()
+
+	for _, tt := range []struct {
+		files []string
+		dir   string
+	}{
+		{files: []string{c1, c2}, dir: cold},
+		{files: []string{c2, h3}, dir: dir},
+	} {
+		files, err := compactor.CompactFull(tt.files, zap.NewNop())
+		if err != nil {
+			t.Fatalf("unexpected error compacting: %v", err)
+		} else if got, exp := len(files), 1; got != exp {
+			t.Fatalf("files length mismatch: got %v, exp %v", got, exp)
+		} else if got, exp := filepath.Dir(files[0]), tt.dir; got != exp {
+			t.Fatalf("directory mismatch: got %v, exp %v", got, exp)
+		}
+		if err := os.Remove(files[0]); err != nil {
+			t.Fatalf("unexpected error removing file: %v", err)
+		}
+	}
+}
+
 // Ensures that a compaction will properly merge multiple TSM files
 func TestCompactor_CompactFull(t *testing.T) {
 	dir := t.TempDir()
@@ -251,6 +293,124 @@ func TestCompactor_CompactFull(t *testing.T) {
 	}
 }
 
+// Ensures that a compaction drops the values expired by the series retention rules.
+func TestCompactor_CompactFull_SeriesRetention(t *testing.T) {
+	dir := t.TempDir()
+
+	now := time.Now().UnixNano()
+	old, recent := now-int64(96*time.Hour), now-int64(time.Hour)
+	writes := map[string][]tsm1.Value{
+		"cpu,host=A#!~#value":    {tsm1.NewValue(old, 1.0), tsm1.NewValue(recent, 1.1)},
+		"debug,host=A#!~#value":  {tsm1.NewValue(old, 2.0), tsm1.NewValue(recent, 2.1)},
+		"debug,host=B#!~#value":  {tsm1.NewValue(old, 3.0)},
+		"debug,host=A#!~#status": {tsm1.NewValue(old, "down"), tsm1.NewValue(recent, "up")},
+	}
+	f1 := MustWriteTSM(t, dir, 1, writes)
+	f2 := MustWriteTSM(t, dir, 2, map[string][]tsm1.Value{
+		"cpu,host=B#!~#value": {tsm1.NewValue(old, 4.0)},
+	})
+
+	retention, err := tsdb.NewSeriesRetention(filepath.Join(dir, tsdb.SeriesRetentionFileName))
+	if err != nil {
+		t.Fatalf("unexpected error opening series retention: %v", err)
+	}
+	if err := retention.SetRules([]tsdb.SeriesRetentionRule{{Measurement: "debug", Duration: 72 * time.Hour}}); err != nil {
+		t.Fatalf("unexpected error setting series retention rules: %v", err)
+	}
+
+	fs := &fakeFileStore{}
+	t.Cleanup(func() { fs.Close() })
+	compactor := tsm1.NewCompactor()
+	compactor.Dir = dir
+	compactor.FileStore = fs
+	compactor.Retention = retention
+	compactor.Open()
+
+	files, err := compactor.CompactFull([]string{f1, f2}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error compacting: %v", err)
+	}
+	if got, exp := len(files), 1; got != exp {
+		t.Fatalf("files length mismatch: got %v, exp %v", got, exp)
+	}
+
+	r := MustOpenTSMReader(files[0])
+	t.Cleanup(func() { r.Close() })
+
+	if got, exp := r.KeyCount(), 4; got != exp {
+		t.Fatalf("keys length mismatch: got %v, exp %v", got, exp)
+	}
+
+	for key, exp := range map[string][]tsm1.Value{
+		"cpu,host=A#!~#value":    writes["cpu,host=A#!~#value"],
+		"cpu,host=B#!~#value":    {tsm1.NewValue(old, 4.0)},
+		"debug,host=A#!~#value":  {tsm1.NewValue(recent, 2.1)},
+		"debug,host=A#!~#status": {tsm1.NewValue(recent, "up")},
+	} {
+		values, err := r.ReadAll([]byte(key))
+		if err != nil {
+			t.Fatalf("unexpected error reading: %v", err)
+		}
+		if got, exp := len(values), len(exp); got != exp {
+			t.Fatalf("values length mismatch %s: got %v, exp %v", key, got, exp)
+		}
+		for i, v := range exp {
+			assertValueEqual(t, values[i], v)
+		}
+	}
+}
+
+// Ensures that a running compaction is reported and can be canceled.
+func TestCompactor_CancelCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	f1 := MustWriteTSM(t, dir, 1, map[string][]tsm1.Value{
+		"cpu,host=A#!~#value": {tsm1.NewValue(1, 1.1)},
+	})
+	f2 := MustWriteTSM(t, dir, 2, map[string][]tsm1.Value{
+		"cpu,host=A#!~#value": {tsm1.NewValue(2, 1.2)},
+	})
+
+	fs := &blockingFileStore{fakeFileStore: &fakeFileStore{}, opened: make(chan struct{}), release: make(chan struct{})}
+	t.Cleanup(func() { fs.Close() })
+	compactor := tsm1.NewCompactor()
+	compactor.Dir = dir
+	compactor.FileStore = fs
+	compactor.Open()
+
+	errC := make(chan error, 1)
+	go func() {
+		_, err := compactor.CompactFull([]string{f1, f2}, zap.NewNop())
+		errC <- err
+	}()
+
+	// Wait for the compaction to open its first input file.
+	<-fs.opened
+
+	statuses := compactor.Compactions()
+	if got, exp := len(statuses), 1; got != exp {
+		t.Fatalf("running compactions mismatch: got %v, exp %v", got, exp)
+	}
+	if got, exp := statuses[0].Files, []string{f1, f2}; !reflect.DeepEqual(got, exp) || !statuses[0].Running {
+		t.Fatalf("unexpected compaction status: %+v", statuses[0])
+	}
+
+	if compactor.CancelCompaction(statuses[0].ID + 1) {
+		t.Fatalf("expected no compaction to cancel for an unknown ID")
+	}
+	if !compactor.CancelCompaction(statuses[0].ID) {
+		t.Fatalf("expected compaction %d to be canceled", statuses[0].ID)
+	}
+	close(fs.release)
+
+	if err := <-errC; err == nil || !strings.Contains(err.Error(), "compaction aborted") {
+		t.Fatalf("expected compaction aborted error, got %v", err)
+	}
+	if got := compactor.Compactions(); len(got) != 0 {
+		t.Fatalf("expected no running compactions, got %+v", got)
+	}
+}
+
 // Ensures that a compaction will properly merge multiple TSM files
 func TestCompactor_DecodeError(t *testing.T) {
 	dir := t.TempDir()
@@ -692,9 +852,23 @@ func TestCompactor_CompactFull_SkipFullBlocks(t *testing.T) {
 		}
 	}
 
-	if got, exp := len(r.Entries([]byte("cpu,host=A#!~#value"))), 2; got != exp {
+	entries := r.Entries([]byte("cpu,host=A#!~#value"))
+	if got, exp := len(entries), 2; got != exp {
 		t.Fatalf("block count mismatch: got %v, exp %v", got, exp)
 	}
+
+	// The copied block keeps the statistics of its source file and the merged
+	// block has statistics computed from its values.
+	for i, exp := range []tsm1.BlockStats{
+		{MinTime: 1, MaxTime: 2, Count: 2, Sum: math.Float64bits(1.1 + 1.2), Min: math.Float64bits(1.1), Max: math.Float64bits(1.2), MinAt: 1, MaxAt: 2},
+		{MinTime: 3, MaxTime: 4, Count: 2, Sum: math.Float64bits(1.3 + 1.4), Min: math.Float64bits(1.3), Max: math.Float64bits(1.4), MinAt: 3, MaxAt: 4},
+	} {
+		if got, ok := r.BlockStats(&entries[i]); !ok {
+			t.Fatalf("block %d has no statistics", i)
+		} else if !reflect.DeepEqual(got, exp) {
+			t.Fatalf("block %d statistics mismatch: got %v, exp %v", i, got, exp)
+		}
+	}
 }
 
 // Ensures that a full compaction will skip over blocks that have the full
@@ -1210,6 +1384,78 @@ func TestTSMKeyIterator_Duplicate(t *testing.T) {
 	}
 }
 
+// Tests that sketch blocks from multiple files are merged, with the newest
+// sketch winning for duplicate timestamps.
+func TestTSMKeyIterator_Sketch(t *testing.T) {
+	dir := t.TempDir()
+
+	newSketch := func(values ...float64) *sketch.Sketch {
+		s := sketch.NewDefault()
+		for _, v := range values {
+			s.Add(v)
+		}
+		return s
+	}
+
+	v1 := tsm1.NewValue(1, newSketch(1, 2, 3))
+	v2 := tsm1.NewValue(3, newSketch(10))
+	v3 := tsm1.NewValue(2, newSketch(5, 50))
+	v4 := tsm1.NewValue(3, newSketch(20, 30))
+
+	writes1 := map[string][]tsm1.Value{
+		"cpu,host=A#!~#latency": {v1, v2},
+	}
+
+	r1 := MustTSMReader(t, dir, 1, writes1)
+	t.Cleanup(func() { r1.Close() })
+
+	writes2 := map[string][]tsm1.Value{
+		"cpu,host=A#!~#latency": {v3, v4},
+	}
+
+	r2 := MustTSMReader(t, dir, 2, writes2)
+	t.Cleanup(func() { r2.Close() })
+
+	iter, err := newTSMKeyIterator(1000, false, nil, r1, r2)
+	if err != nil {
+		t.Fatalf("unexpected error creating WALKeyIterator: %v", err)
+	}
+	t.Cleanup(func() { iter.Close() })
+
+	var readValues bool
+	for iter.Next() {
+		key, _, _, block, err := iter.Read()
+		if err != nil {
+			t.Fatalf("unexpected error read: %v", err)
+		}
+
+		if got, exp := string(key), "cpu,host=A#!~#latency"; got != exp {
+			t.Fatalf("key mismatch: got %v, exp %v", got, exp)
+		}
+
+		typ, err := tsm1.BlockType(block)
+		if err != nil {
+			t.Fatalf("unexpected error block type: %v", err)
+		} else if typ != tsm1.BlockSketch {
+			t.Fatalf("block type mismatch: got %v, exp %v", typ, tsm1.BlockSketch)
+		}
+
+		values, err := tsm1.DecodeBlock(block, nil)
+		if err != nil {
+			t.Fatalf("unexpected error decode: %v", err)
+		}
+
+		if exp := []tsm1.Value{v1, v3, v4}; !reflect.DeepEqual(values, exp) {
+			t.Fatalf("values mismatch:\n\tgot: %v\n\texp: %v", values, exp)
+		}
+		readValues = true
+	}
+
+	if !readValues {
+		t.Fatalf("failed to read any values")
+	}
+}
+
 // Tests that deleted keys are not seen during iteration with
 // TSM files.
 func TestTSMKeyIterator_MultipleKeysDeleted(t *testing.T) {
@@ -3091,3 +3337,19 @@ func (w *fakeFileStore) Close() {
 func (w *fakeFileStore) ParseFileName(path string) (int, int, error) {
 	return tsm1.DefaultParseFileName(path)
 }
+
+// blockingFileStore blocks the first TSMReader call until release is closed.
+type blockingFileStore struct {
+	*fakeFileStore
+	once    sync.Once
+	opened  chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingFileStore) TSMReader(path string) *tsm1.TSMReader {
+	w.once.Do(func() {
+		close(w.opened)
+		<-w.release
+	})
+	return w.fakeFileStore.TSMReader(path)
+}
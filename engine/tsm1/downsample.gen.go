@@ -0,0 +1,584 @@
+// Generated by tmpl
+// https://github.com/benbjohnson/tmpl
+//
+// DO NOT EDIT!
+// Source: downsample.gen.go.tmpl
+
+package tsm1
+
+import (
+	"github.com/influxdata/influxdb/v2/tsdb"
+)
+
+// downsampleFloat adds the aggregates of the float values in blocks to keys.
+func (k *downsampleKeyIterator) downsampleFloat(keys map[string]*downsampleKey, series, field []byte, blocks []downsampleBlock) error {
+	a, err := decodeFloatBlocks(blocks)
+	if err != nil {
+		return err
+	}
+
+	var (
+		means                           tsdb.FloatArray
+		counts                          tsdb.IntegerArray
+		sums, mins, maxs, firsts, lasts tsdb.FloatArray
+	)
+
+	iv := int64(k.config.Interval)
+	for i := 0; i < a.Len(); {
+		start := k.windowStart(a.Timestamps[i])
+
+		var (
+			n        int64
+			sum      float64
+			mean     float64
+			min, max = a.Values[i], a.Values[i]
+			first    = a.Values[i]
+			last     float64
+		)
+		for ; i < a.Len() && a.Timestamps[i]-start < iv; i++ {
+			v := a.Values[i]
+			n++
+			sum += v
+			mean += (float64(v) - mean) / float64(n)
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+			last = v
+		}
+
+		means.Timestamps = append(means.Timestamps, start)
+		means.Values = append(means.Values, mean)
+		counts.Timestamps = append(counts.Timestamps, start)
+		counts.Values = append(counts.Values, n)
+		sums.Timestamps = append(sums.Timestamps, start)
+		sums.Values = append(sums.Values, sum)
+		mins.Timestamps = append(mins.Timestamps, start)
+		mins.Values = append(mins.Values, min)
+		maxs.Timestamps = append(maxs.Timestamps, start)
+		maxs.Values = append(maxs.Values, max)
+		firsts.Timestamps = append(firsts.Timestamps, start)
+		firsts.Values = append(firsts.Values, first)
+		lasts.Timestamps = append(lasts.Timestamps, start)
+		lasts.Values = append(lasts.Values, last)
+	}
+
+	for _, agg := range k.config.Aggregates {
+		var (
+			typ byte = BlockFloat64
+			out []downsampleBlock
+		)
+		switch agg {
+		case DownsampleMean:
+			typ = BlockFloat64
+			out, err = k.encodeFloatBlocks(&means)
+		case DownsampleCount:
+			typ = BlockInteger
+			out, err = k.encodeIntegerBlocks(&counts)
+		case DownsampleSum:
+			out, err = k.encodeFloatBlocks(&sums)
+		case DownsampleMin:
+			out, err = k.encodeFloatBlocks(&mins)
+		case DownsampleMax:
+			out, err = k.encodeFloatBlocks(&maxs)
+		case DownsampleFirst:
+			out, err = k.encodeFloatBlocks(&firsts)
+		case DownsampleLast:
+			out, err = k.encodeFloatBlocks(&lasts)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := k.addAggregate(keys, series, field, agg, typ, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeFloatBlocks merges the values of two sets of float blocks for
+// the same key.  Values in b replace values in a with the same timestamp.
+func (k *downsampleKeyIterator) mergeFloatBlocks(a, b []downsampleBlock) ([]downsampleBlock, error) {
+	av, err := decodeFloatBlocks(a)
+	if err != nil {
+		return nil, err
+	}
+	bv, err := decodeFloatBlocks(b)
+	if err != nil {
+		return nil, err
+	}
+	av.Merge(bv)
+	return k.encodeFloatBlocks(av)
+}
+
+// mergeFloatAggregate merges the values of the aggregate agg in two sets of
+// float blocks for the same key.  Values of windows in both a and b are
+// combined, except for the first and last aggregates which keep the value in a.
+func (k *downsampleKeyIterator) mergeFloatAggregate(agg string, a, b []downsampleBlock) ([]downsampleBlock, error) {
+	av, err := decodeFloatBlocks(a)
+	if err != nil {
+		return nil, err
+	}
+	bv, err := decodeFloatBlocks(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var out tsdb.FloatArray
+	for i, j := 0, 0; i < av.Len() || j < bv.Len(); {
+		switch {
+		case j == bv.Len() || (i < av.Len() && av.Timestamps[i] < bv.Timestamps[j]):
+			out.Timestamps = append(out.Timestamps, av.Timestamps[i])
+			out.Values = append(out.Values, av.Values[i])
+			i++
+		case i == av.Len() || bv.Timestamps[j] < av.Timestamps[i]:
+			out.Timestamps = append(out.Timestamps, bv.Timestamps[j])
+			out.Values = append(out.Values, bv.Values[j])
+			j++
+		default:
+			v := av.Values[i]
+			switch agg {
+			case DownsampleCount, DownsampleSum:
+				v += bv.Values[j]
+			case DownsampleMin:
+				if bv.Values[j] < v {
+					v = bv.Values[j]
+				}
+			case DownsampleMax:
+				if bv.Values[j] > v {
+					v = bv.Values[j]
+				}
+			}
+			out.Timestamps = append(out.Timestamps, av.Timestamps[i])
+			out.Values = append(out.Values, v)
+			i++
+			j++
+		}
+	}
+	return k.encodeFloatBlocks(&out)
+}
+
+// encodeFloatBlocks encodes a into blocks of at most k.size values.
+func (k *downsampleKeyIterator) encodeFloatBlocks(a *tsdb.FloatArray) ([]downsampleBlock, error) {
+	var blocks []downsampleBlock
+	for i := 0; i < a.Len(); i += k.size {
+		j := i + k.size
+		if j > a.Len() {
+			j = a.Len()
+		}
+
+		chunk := tsdb.FloatArray{Timestamps: a.Timestamps[i:j], Values: a.Values[i:j]}
+		b, err := EncodeFloatArrayBlock(&chunk, nil)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, downsampleBlock{
+			minTime: chunk.MinTime(),
+			maxTime: chunk.MaxTime(),
+			block:   b,
+		})
+	}
+	return blocks, nil
+}
+
+// decodeFloatBlocks decodes the values of blocks, which must be sorted and
+// not overlap, into a single array.
+func decodeFloatBlocks(blocks []downsampleBlock) (*tsdb.FloatArray, error) {
+	var (
+		a   tsdb.FloatArray
+		tmp tsdb.FloatArray
+	)
+	for _, b := range blocks {
+		if err := DecodeFloatArrayBlock(b.block, &tmp); err != nil {
+			return nil, err
+		}
+		a.Timestamps = append(a.Timestamps, tmp.Timestamps...)
+		a.Values = append(a.Values, tmp.Values...)
+	}
+	return &a, nil
+}
+
+// downsampleInteger adds the aggregates of the integer values in blocks to keys.
+func (k *downsampleKeyIterator) downsampleInteger(keys map[string]*downsampleKey, series, field []byte, blocks []downsampleBlock) error {
+	a, err := decodeIntegerBlocks(blocks)
+	if err != nil {
+		return err
+	}
+
+	var (
+		means                           tsdb.FloatArray
+		counts                          tsdb.IntegerArray
+		sums, mins, maxs, firsts, lasts tsdb.IntegerArray
+	)
+
+	iv := int64(k.config.Interval)
+	for i := 0; i < a.Len(); {
+		start := k.windowStart(a.Timestamps[i])
+
+		var (
+			n        int64
+			sum      int64
+			mean     float64
+			min, max = a.Values[i], a.Values[i]
+			first    = a.Values[i]
+			last     int64
+		)
+		for ; i < a.Len() && a.Timestamps[i]-start < iv; i++ {
+			v := a.Values[i]
+			n++
+			sum += v
+			mean += (float64(v) - mean) / float64(n)
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+			last = v
+		}
+
+		means.Timestamps = append(means.Timestamps, start)
+		means.Values = append(means.Values, mean)
+		counts.Timestamps = append(counts.Timestamps, start)
+		counts.Values = append(counts.Values, n)
+		sums.Timestamps = append(sums.Timestamps, start)
+		sums.Values = append(sums.Values, sum)
+		mins.Timestamps = append(mins.Timestamps, start)
+		mins.Values = append(mins.Values, min)
+		maxs.Timestamps = append(maxs.Timestamps, start)
+		maxs.Values = append(maxs.Values, max)
+		firsts.Timestamps = append(firsts.Timestamps, start)
+		firsts.Values = append(firsts.Values, first)
+		lasts.Timestamps = append(lasts.Timestamps, start)
+		lasts.Values = append(lasts.Values, last)
+	}
+
+	for _, agg := range k.config.Aggregates {
+		var (
+			typ byte = BlockInteger
+			out []downsampleBlock
+		)
+		switch agg {
+		case DownsampleMean:
+			typ = BlockFloat64
+			out, err = k.encodeFloatBlocks(&means)
+		case DownsampleCount:
+			typ = BlockInteger
+			out, err = k.encodeIntegerBlocks(&counts)
+		case DownsampleSum:
+			out, err = k.encodeIntegerBlocks(&sums)
+		case DownsampleMin:
+			out, err = k.encodeIntegerBlocks(&mins)
+		case DownsampleMax:
+			out, err = k.encodeIntegerBlocks(&maxs)
+		case DownsampleFirst:
+			out, err = k.encodeIntegerBlocks(&firsts)
+		case DownsampleLast:
+			out, err = k.encodeIntegerBlocks(&lasts)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := k.addAggregate(keys, series, field, agg, typ, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeIntegerBlocks merges the values of two sets of integer blocks for
+// the same key.  Values in b replace values in a with the same timestamp.
+func (k *downsampleKeyIterator) mergeIntegerBlocks(a, b []downsampleBlock) ([]downsampleBlock, error) {
+	av, err := decodeIntegerBlocks(a)
+	if err != nil {
+		return nil, err
+	}
+	bv, err := decodeIntegerBlocks(b)
+	if err != nil {
+		return nil, err
+	}
+	av.Merge(bv)
+	return k.encodeIntegerBlocks(av)
+}
+
+// mergeIntegerAggregate merges the values of the aggregate agg in two sets of
+// integer blocks for the same key.  Values of windows in both a and b are
+// combined, except for the first and last aggregates which keep the value in a.
+func (k *downsampleKeyIterator) mergeIntegerAggregate(agg string, a, b []downsampleBlock) ([]downsampleBlock, error) {
+	av, err := decodeIntegerBlocks(a)
+	if err != nil {
+		return nil, err
+	}
+	bv, err := decodeIntegerBlocks(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var out tsdb.IntegerArray
+	for i, j := 0, 0; i < av.Len() || j < bv.Len(); {
+		switch {
+		case j == bv.Len() || (i < av.Len() && av.Timestamps[i] < bv.Timestamps[j]):
+			out.Timestamps = append(out.Timestamps, av.Timestamps[i])
+			out.Values = append(out.Values, av.Values[i])
+			i++
+		case i == av.Len() || bv.Timestamps[j] < av.Timestamps[i]:
+			out.Timestamps = append(out.Timestamps, bv.Timestamps[j])
+			out.Values = append(out.Values, bv.Values[j])
+			j++
+		default:
+			v := av.Values[i]
+			switch agg {
+			case DownsampleCount, DownsampleSum:
+				v += bv.Values[j]
+			case DownsampleMin:
+				if bv.Values[j] < v {
+					v = bv.Values[j]
+				}
+			case DownsampleMax:
+				if bv.Values[j] > v {
+					v = bv.Values[j]
+				}
+			}
+			out.Timestamps = append(out.Timestamps, av.Timestamps[i])
+			out.Values = append(out.Values, v)
+			i++
+			j++
+		}
+	}
+	return k.encodeIntegerBlocks(&out)
+}
+
+// encodeIntegerBlocks encodes a into blocks of at most k.size values.
+func (k *downsampleKeyIterator) encodeIntegerBlocks(a *tsdb.IntegerArray) ([]downsampleBlock, error) {
+	var blocks []downsampleBlock
+	for i := 0; i < a.Len(); i += k.size {
+		j := i + k.size
+		if j > a.Len() {
+			j = a.Len()
+		}
+
+		chunk := tsdb.IntegerArray{Timestamps: a.Timestamps[i:j], Values: a.Values[i:j]}
+		b, err := EncodeIntegerArrayBlock(&chunk, nil)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, downsampleBlock{
+			minTime: chunk.MinTime(),
+			maxTime: chunk.MaxTime(),
+			block:   b,
+		})
+	}
+	return blocks, nil
+}
+
+// decodeIntegerBlocks decodes the values of blocks, which must be sorted and
+// not overlap, into a single array.
+func decodeIntegerBlocks(blocks []downsampleBlock) (*tsdb.IntegerArray, error) {
+	var (
+		a   tsdb.IntegerArray
+		tmp tsdb.IntegerArray
+	)
+	for _, b := range blocks {
+		if err := DecodeIntegerArrayBlock(b.block, &tmp); err != nil {
+			return nil, err
+		}
+		a.Timestamps = append(a.Timestamps, tmp.Timestamps...)
+		a.Values = append(a.Values, tmp.Values...)
+	}
+	return &a, nil
+}
+
+// downsampleUnsigned adds the aggregates of the unsigned values in blocks to keys.
+func (k *downsampleKeyIterator) downsampleUnsigned(keys map[string]*downsampleKey, series, field []byte, blocks []downsampleBlock) error {
+	a, err := decodeUnsignedBlocks(blocks)
+	if err != nil {
+		return err
+	}
+
+	var (
+		means                           tsdb.FloatArray
+		counts                          tsdb.IntegerArray
+		sums, mins, maxs, firsts, lasts tsdb.UnsignedArray
+	)
+
+	iv := int64(k.config.Interval)
+	for i := 0; i < a.Len(); {
+		start := k.windowStart(a.Timestamps[i])
+
+		var (
+			n        int64
+			sum      uint64
+			mean     float64
+			min, max = a.Values[i], a.Values[i]
+			first    = a.Values[i]
+			last     uint64
+		)
+		for ; i < a.Len() && a.Timestamps[i]-start < iv; i++ {
+			v := a.Values[i]
+			n++
+			sum += v
+			mean += (float64(v) - mean) / float64(n)
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+			last = v
+		}
+
+		means.Timestamps = append(means.Timestamps, start)
+		means.Values = append(means.Values, mean)
+		counts.Timestamps = append(counts.Timestamps, start)
+		counts.Values = append(counts.Values, n)
+		sums.Timestamps = append(sums.Timestamps, start)
+		sums.Values = append(sums.Values, sum)
+		mins.Timestamps = append(mins.Timestamps, start)
+		mins.Values = append(mins.Values, min)
+		maxs.Timestamps = append(maxs.Timestamps, start)
+		maxs.Values = append(maxs.Values, max)
+		firsts.Timestamps = append(firsts.Timestamps, start)
+		firsts.Values = append(firsts.Values, first)
+		lasts.Timestamps = append(lasts.Timestamps, start)
+		lasts.Values = append(lasts.Values, last)
+	}
+
+	for _, agg := range k.config.Aggregates {
+		var (
+			typ byte = BlockUnsigned
+			out []downsampleBlock
+		)
+		switch agg {
+		case DownsampleMean:
+			typ = BlockFloat64
+			out, err = k.encodeFloatBlocks(&means)
+		case DownsampleCount:
+			typ = BlockInteger
+			out, err = k.encodeIntegerBlocks(&counts)
+		case DownsampleSum:
+			out, err = k.encodeUnsignedBlocks(&sums)
+		case DownsampleMin:
+			out, err = k.encodeUnsignedBlocks(&mins)
+		case DownsampleMax:
+			out, err = k.encodeUnsignedBlocks(&maxs)
+		case DownsampleFirst:
+			out, err = k.encodeUnsignedBlocks(&firsts)
+		case DownsampleLast:
+			out, err = k.encodeUnsignedBlocks(&lasts)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := k.addAggregate(keys, series, field, agg, typ, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeUnsignedBlocks merges the values of two sets of unsigned blocks for
+// the same key.  Values in b replace values in a with the same timestamp.
+func (k *downsampleKeyIterator) mergeUnsignedBlocks(a, b []downsampleBlock) ([]downsampleBlock, error) {
+	av, err := decodeUnsignedBlocks(a)
+	if err != nil {
+		return nil, err
+	}
+	bv, err := decodeUnsignedBlocks(b)
+	if err != nil {
+		return nil, err
+	}
+	av.Merge(bv)
+	return k.encodeUnsignedBlocks(av)
+}
+
+// mergeUnsignedAggregate merges the values of the aggregate agg in two sets of
+// unsigned blocks for the same key.  Values of windows in both a and b are
+// combined, except for the first and last aggregates which keep the value in a.
+func (k *downsampleKeyIterator) mergeUnsignedAggregate(agg string, a, b []downsampleBlock) ([]downsampleBlock, error) {
+	av, err := decodeUnsignedBlocks(a)
+	if err != nil {
+		return nil, err
+	}
+	bv, err := decodeUnsignedBlocks(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var out tsdb.UnsignedArray
+	for i, j := 0, 0; i < av.Len() || j < bv.Len(); {
+		switch {
+		case j == bv.Len() || (i < av.Len() && av.Timestamps[i] < bv.Timestamps[j]):
+			out.Timestamps = append(out.Timestamps, av.Timestamps[i])
+			out.Values = append(out.Values, av.Values[i])
+			i++
+		case i == av.Len() || bv.Timestamps[j] < av.Timestamps[i]:
+			out.Timestamps = append(out.Timestamps, bv.Timestamps[j])
+			out.Values = append(out.Values, bv.Values[j])
+			j++
+		default:
+			v := av.Values[i]
+			switch agg {
+			case DownsampleCount, DownsampleSum:
+				v += bv.Values[j]
+			case DownsampleMin:
+				if bv.Values[j] < v {
+					v = bv.Values[j]
+				}
+			case DownsampleMax:
+				if bv.Values[j] > v {
+					v = bv.Values[j]
+				}
+			}
+			out.Timestamps = append(out.Timestamps, av.Timestamps[i])
+			out.Values = append(out.Values, v)
+			i++
+			j++
+		}
+	}
+	return k.encodeUnsignedBlocks(&out)
+}
+
+// encodeUnsignedBlocks encodes a into blocks of at most k.size values.
+func (k *downsampleKeyIterator) encodeUnsignedBlocks(a *tsdb.UnsignedArray) ([]downsampleBlock, error) {
+	var blocks []downsampleBlock
+	for i := 0; i < a.Len(); i += k.size {
+		j := i + k.size
+		if j > a.Len() {
+			j = a.Len()
+		}
+
+		chunk := tsdb.UnsignedArray{Timestamps: a.Timestamps[i:j], Values: a.Values[i:j]}
+		b, err := EncodeUnsignedArrayBlock(&chunk, nil)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, downsampleBlock{
+			minTime: chunk.MinTime(),
+			maxTime: chunk.MaxTime(),
+			block:   b,
+		})
+	}
+	return blocks, nil
+}
+
+// decodeUnsignedBlocks decodes the values of blocks, which must be sorted and
+// not overlap, into a single array.
+func decodeUnsignedBlocks(blocks []downsampleBlock) (*tsdb.UnsignedArray, error) {
+	var (
+		a   tsdb.UnsignedArray
+		tmp tsdb.UnsignedArray
+	)
+	for _, b := range blocks {
+		if err := DecodeUnsignedArrayBlock(b.block, &tmp); err != nil {
+			return nil, err
+		}
+		a.Timestamps = append(a.Timestamps, tmp.Timestamps...)
+		a.Values = append(a.Values, tmp.Values...)
+	}
+	return &a, nil
+}
@@ -0,0 +1,863 @@
+package tsm1
+
+// Downsampling compactions rewrite every TSM file in a shard once all of its
+// data is older than a configured age.  Each float, integer and unsigned field
+// is replaced by one field per configured aggregate, named <field>_<aggregate>,
+// holding a single value per interval.  Boolean and string fields are copied
+// unchanged.
+//
+// The fields created by downsampling are recorded in the downsample file of
+// the shard, so that they are told apart from written fields whatever their
+// names.  A written field is left unchanged if one of its aggregates would be
+// named like another written field of the series.
+//
+// A shard is downsampled again only if new TSM generations have been written
+// since it was last downsampled.  Values written into a window that has already
+// been downsampled are aggregated on their own and merged with the existing
+// aggregates of the window: counts and sums are added, minimums and maximums
+// are combined, and means are weighted by the counts of the window.  The first
+// and last values of such a window cannot be merged and are kept unchanged.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/v2/models"
+	"github.com/influxdata/influxdb/v2/pkg/file"
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"go.uber.org/zap"
+)
+
+const (
+	// DownsampleFileName is the name of the file in a shard directory that records
+	// the highest TSM generation that has been downsampled and the fields created
+	// by downsampling.
+	DownsampleFileName = "downsample"
+
+	// DefaultDownsampleAge is the default age after which data is downsampled.
+	DefaultDownsampleAge = 30 * 24 * time.Hour
+
+	// DefaultDownsampleInterval is the default width of a downsampling window.
+	DefaultDownsampleInterval = time.Minute
+
+	// downsampleCheckInterval is how often the planner checks whether a shard
+	// is ready to be downsampled.
+	downsampleCheckInterval = time.Minute
+)
+
+// Aggregates supported by downsampling compactions.
+const (
+	DownsampleMean  = "mean"
+	DownsampleMin   = "min"
+	DownsampleMax   = "max"
+	DownsampleCount = "count"
+	DownsampleSum   = "sum"
+	DownsampleFirst = "first"
+	DownsampleLast  = "last"
+)
+
+var (
+	errDownsampleNoAggregates = errors.New("downsample: no aggregates configured")
+	errDownsampleMeanNoCount  = errors.New("downsample: mean aggregate requires the count aggregate")
+)
+
+// DownsampleConfig configures downsampling compactions.
+type DownsampleConfig struct {
+	// Age is how old all data in a shard must be before it is downsampled.
+	Age time.Duration
+
+	// Interval is the width of each aggregation window.
+	Interval time.Duration
+
+	// Aggregates are the aggregates computed for each window.
+	Aggregates []string
+}
+
+// NewDownsampleConfig returns a DownsampleConfig computing the mean, min, max and
+// count of each field per minute for data older than 30 days.
+func NewDownsampleConfig() DownsampleConfig {
+	return DownsampleConfig{
+		Age:        DefaultDownsampleAge,
+		Interval:   DefaultDownsampleInterval,
+		Aggregates: []string{DownsampleMean, DownsampleMin, DownsampleMax, DownsampleCount},
+	}
+}
+
+// Validate returns an error if the config is invalid.
+func (c DownsampleConfig) Validate() error {
+	if c.Age <= 0 {
+		return errors.New("downsample: age must be positive")
+	} else if c.Interval <= 0 {
+		return errors.New("downsample: interval must be positive")
+	} else if len(c.Aggregates) == 0 {
+		return errDownsampleNoAggregates
+	}
+
+	seen := make(map[string]struct{}, len(c.Aggregates))
+	for _, agg := range c.Aggregates {
+		switch agg {
+		case DownsampleMean, DownsampleMin, DownsampleMax, DownsampleCount,
+			DownsampleSum, DownsampleFirst, DownsampleLast:
+		default:
+			return fmt.Errorf("downsample: unknown aggregate %q", agg)
+		}
+		if _, ok := seen[agg]; ok {
+			return fmt.Errorf("downsample: duplicate aggregate %q", agg)
+		}
+		seen[agg] = struct{}{}
+	}
+
+	// Means of late values are merged using the counts of their window.
+	if _, ok := seen[DownsampleMean]; ok {
+		if _, ok := seen[DownsampleCount]; !ok {
+			return errDownsampleMeanNoCount
+		}
+	}
+	return nil
+}
+
+// DownsampleFields are the fields created by downsampling, by measurement.
+// They hold aggregates and are never downsampled again.
+type DownsampleFields map[string]map[string]struct{}
+
+// Contains returns true if field of measurement was created by downsampling.
+func (f DownsampleFields) Contains(measurement, field []byte) bool {
+	_, ok := f[string(measurement)][string(field)]
+	return ok
+}
+
+// add records field of measurement as created by downsampling.
+func (f DownsampleFields) add(measurement, field string) {
+	m := f[measurement]
+	if m == nil {
+		m = make(map[string]struct{})
+		f[measurement] = m
+	}
+	m[field] = struct{}{}
+}
+
+// DownsampleCompactionPlanner is a CompactionPlanner that also plans
+// downsampling compactions.
+type DownsampleCompactionPlanner interface {
+	CompactionPlanner
+
+	// PlanDownsample returns the group of TSM files to downsample, if any.
+	PlanDownsample() ([]CompactionGroup, int64)
+
+	// Downsampled records that a group returned by PlanDownsample has been
+	// downsampled and replaced.
+	Downsampled(group CompactionGroup) error
+
+	// AggregateFields returns the fields created by downsampling.
+	AggregateFields() (DownsampleFields, error)
+
+	// AddAggregateFields records the fields created by a downsampling
+	// compaction.  It must be called before the downsampled files replace
+	// the compacted files.
+	AddAggregateFields(fields tsdb.FieldChanges) error
+
+	// DownsampleConfig returns the configuration of downsampling compactions.
+	DownsampleConfig() DownsampleConfig
+}
+
+// DownsamplePlanner is a DefaultPlanner that also plans downsampling
+// compactions of shards whose data is all older than the configured age.
+type DownsamplePlanner struct {
+	*DefaultPlanner
+
+	config DownsampleConfig
+
+	mu sync.Mutex
+	// dir is the shard directory holding the downsample marker file.
+	dir string
+	// lastCheck is the last time PlanDownsample looked for files to downsample.
+	lastCheck time.Time
+
+	// now returns the current time. Used for testing.
+	now func() time.Time
+}
+
+// NewDownsamplePlanner returns a new instance of DownsamplePlanner.
+func NewDownsamplePlanner(fs fileStore, writeColdDuration time.Duration, config DownsampleConfig) *DownsamplePlanner {
+	return &DownsamplePlanner{
+		DefaultPlanner: NewDefaultPlanner(fs, writeColdDuration),
+		config:         config,
+		now:            time.Now,
+	}
+}
+
+// NewDownsamplePlannerCreator returns a CompactionPlannerCreator for use in
+// EngineOptions that creates DownsamplePlanners using config.
+func NewDownsamplePlannerCreator(config DownsampleConfig) tsdb.CompactionPlannerCreator {
+	return func(cfg tsdb.Config) interface{} {
+		return NewDownsamplePlanner(nil, time.Duration(cfg.CompactFullWriteColdDuration), config)
+	}
+}
+
+func (c *DownsamplePlanner) SetFileStore(fs *FileStore) {
+	c.DefaultPlanner.SetFileStore(fs)
+	c.mu.Lock()
+	c.dir = fs.dir
+	c.mu.Unlock()
+}
+
+// DownsampleConfig returns the configuration of downsampling compactions.
+func (c *DownsamplePlanner) DownsampleConfig() DownsampleConfig {
+	return c.config
+}
+
+// PlanDownsample returns a single group of all TSM files if every file only
+// holds data older than the configured age and the files have not already
+// been downsampled.
+func (c *DownsamplePlanner) PlanDownsample() ([]CompactionGroup, int64) {
+	c.mu.Lock()
+	now := c.now()
+	if now.Sub(c.lastCheck) < downsampleCheckInterval {
+		c.mu.Unlock()
+		return nil, 0
+	}
+	c.lastCheck = now
+	dir := c.dir
+	c.mu.Unlock()
+
+	if c.config.Validate() != nil {
+		return nil, 0
+	}
+
+	c.DefaultPlanner.mu.RLock()
+	forceFull := c.forceFull
+	c.DefaultPlanner.mu.RUnlock()
+	if forceFull {
+		return nil, 0
+	}
+
+	stats := c.FileStore.Stats()
+	if len(stats) == 0 {
+		return nil, 0
+	}
+
+	cutoff := now.Add(-c.config.Age).Truncate(c.config.Interval).UnixNano()
+	var (
+		group  CompactionGroup
+		maxGen int
+	)
+	for _, f := range stats {
+		if f.MaxTime >= cutoff {
+			return nil, 0
+		}
+
+		gen, _, err := c.ParseFileName(f.Path)
+		if err != nil {
+			return nil, 0
+		}
+		if gen > maxGen {
+			maxGen = gen
+		}
+		group = append(group, f.Path)
+	}
+
+	if dir != "" {
+		if state, err := readDownsampleState(dir); err != nil || state.Generation >= maxGen {
+			return nil, 0
+		}
+	}
+
+	groups := []CompactionGroup{group}
+	if !c.acquire(groups) {
+		return nil, 1
+	}
+	return groups, 1
+}
+
+// Downsampled records the highest generation in group as downsampled so the
+// files are not planned again.
+func (c *DownsamplePlanner) Downsampled(group CompactionGroup) error {
+	var maxGen int
+	for _, f := range group {
+		gen, _, err := c.ParseFileName(f)
+		if err != nil {
+			return err
+		}
+		if gen > maxGen {
+			maxGen = gen
+		}
+	}
+
+	return c.updateState(func(state *downsampleState) {
+		state.Generation = maxGen
+	})
+}
+
+// AggregateFields returns the fields created by downsampling the shard.
+func (c *DownsamplePlanner) AggregateFields() (DownsampleFields, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dir == "" {
+		return nil, errors.New("downsample: no shard directory")
+	}
+	state, err := readDownsampleState(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	return state.aggregateFields(), nil
+}
+
+// AddAggregateFields records fields as created by downsampling the shard.
+func (c *DownsamplePlanner) AddAggregateFields(fields tsdb.FieldChanges) error {
+	return c.updateState(func(state *downsampleState) {
+		aggregates := state.aggregateFields()
+		for _, fc := range fields {
+			aggregates.add(string(fc.Measurement), fc.Field.Name)
+		}
+		state.setAggregateFields(aggregates)
+	})
+}
+
+// updateState applies fn to the downsample state of the shard and persists it.
+func (c *DownsamplePlanner) updateState(fn func(state *downsampleState)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dir == "" {
+		return errors.New("downsample: no shard directory")
+	}
+	state, err := readDownsampleState(c.dir)
+	if err != nil {
+		return err
+	}
+	fn(state)
+	return writeDownsampleState(c.dir, state)
+}
+
+// downsampleState is the content of the downsample file of a shard.
+type downsampleState struct {
+	// Generation is the highest TSM generation that has been downsampled.
+	Generation int `json:"generation"`
+
+	// Fields are the sorted names of the fields created by downsampling, by
+	// measurement.
+	Fields map[string][]string `json:"fields,omitempty"`
+}
+
+func (s *downsampleState) aggregateFields() DownsampleFields {
+	fields := make(DownsampleFields, len(s.Fields))
+	for measurement, names := range s.Fields {
+		for _, name := range names {
+			fields.add(measurement, name)
+		}
+	}
+	return fields
+}
+
+func (s *downsampleState) setAggregateFields(fields DownsampleFields) {
+	s.Fields = make(map[string][]string, len(fields))
+	for measurement, m := range fields {
+		names := make([]string, 0, len(m))
+		for name := range m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		s.Fields[measurement] = names
+	}
+}
+
+// readDownsampleState returns the state recorded in the downsample file in
+// dir, or an empty state if the shard has never been downsampled.
+func readDownsampleState(dir string) (*downsampleState, error) {
+	b, err := os.ReadFile(filepath.Join(dir, DownsampleFileName))
+	if os.IsNotExist(err) {
+		return &downsampleState{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var state downsampleState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("downsample: invalid %s file: %w", DownsampleFileName, err)
+	}
+	return &state, nil
+}
+
+// writeDownsampleState atomically records state in the downsample file in dir.
+func writeDownsampleState(dir string, state *downsampleState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, DownsampleFileName)
+	tmp := path + "." + CompactionTempExtension
+	if err := os.WriteFile(tmp, b, 0666); err != nil {
+		return err
+	}
+	if err := file.RenameFile(tmp, path); err != nil {
+		return err
+	}
+	return file.SyncDir(dir)
+}
+
+// CompactDownsample writes the downsampled contents of tsmFiles into 1 or more
+// new files.  aggregates are the fields created by previous downsampling
+// compactions, which are merged rather than downsampled.  It returns the new
+// files and the fields created by downsampling.
+func (c *Compactor) CompactDownsample(tsmFiles []string, config DownsampleConfig, aggregates DownsampleFields, logger *zap.Logger) ([]string, tsdb.FieldChanges, error) {
+	return c.compactDownsample(tsmFiles, config, aggregates, nil, logger)
+}
+
+// compactDownsample is CompactDownsample recording the progress of the
+// compaction in p.
+func (c *Compactor) compactDownsample(tsmFiles []string, config DownsampleConfig, aggregates DownsampleFields, p *compactionProgress, logger *zap.Logger) ([]string, tsdb.FieldChanges, error) {
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.RLock()
+	enabled := c.compactionsEnabled
+	c.mu.RUnlock()
+
+	if !enabled {
+		return nil, nil, errCompactionsDisabled
+	}
+
+	if !c.add(tsmFiles) {
+		return nil, nil, errCompactionInProgress{}
+	}
+	defer c.remove(tsmFiles)
+
+	var iter *downsampleKeyIterator
+	files, err := c.compact(false, tsmFiles, func(ki KeyIterator) KeyIterator {
+		iter = newDownsampleKeyIterator(ki, config, aggregates, c.Size, c.StringCodec)
+		return iter
+	}, p, logger)
+
+	// See if we were disabled while writing a snapshot
+	c.mu.RLock()
+	enabled = c.compactionsEnabled
+	c.mu.RUnlock()
+
+	if !enabled {
+		if err := c.removeTmpFiles(files); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, errCompactionsDisabled
+	} else if err != nil || iter == nil {
+		return files, nil, err
+	}
+	return files, iter.fields, nil
+}
+
+// downsampleBlock is an encoded block produced by a downsampleKeyIterator.
+type downsampleBlock struct {
+	key              []byte
+	minTime, maxTime int64
+	block            []byte
+}
+
+// downsampleKey holds the blocks of a single key in the series being downsampled.
+type downsampleKey struct {
+	key    []byte
+	typ    byte
+	blocks []downsampleBlock
+
+	// aggregate is the aggregate generating the blocks, or empty if the blocks
+	// were read.
+	aggregate string
+}
+
+// downsampleKeyIterator is a KeyIterator that replaces the numeric fields read
+// from another KeyIterator with their aggregates over fixed windows of time.
+// All the keys of a series are read and aggregated before any are returned.
+type downsampleKeyIterator struct {
+	iter        KeyIterator
+	config      DownsampleConfig
+	aggregates  DownsampleFields
+	size        int
+	stringCodec tsdb.BlockCodec
+
+	// peek is the first block of the next series, read while looking for the
+	// end of the current series.
+	peek *downsampleBlock
+
+	// out are the blocks of the current series to return.
+	out []downsampleBlock
+	pos int
+
+	// fields are the fields created by downsampling.
+	fields tsdb.FieldChanges
+	seen   map[string]struct{}
+
+	err error
+}
+
+func newDownsampleKeyIterator(iter KeyIterator, config DownsampleConfig, aggregates DownsampleFields, size int, stringCodec tsdb.BlockCodec) *downsampleKeyIterator {
+	if size <= 0 {
+		size = tsdb.DefaultMaxPointsPerBlock
+	}
+	return &downsampleKeyIterator{
+		iter:        iter,
+		config:      config,
+		aggregates:  aggregates,
+		size:        size,
+		stringCodec: stringCodec,
+		pos:         -1,
+		seen:        make(map[string]struct{}),
+	}
+}
+
+// Next returns true if there are any values remaining in the iterator.
+func (k *downsampleKeyIterator) Next() bool {
+	if k.pos+1 < len(k.out) {
+		k.pos++
+		return true
+	}
+
+	k.out, k.pos = k.out[:0], 0
+	for len(k.out) == 0 {
+		if k.err != nil || !k.readSeries() {
+			return false
+		}
+	}
+	return true
+}
+
+// readSeries reads and downsamples the blocks of the next series.  It returns
+// false once the underlying iterator is exhausted or fails.
+func (k *downsampleKeyIterator) readSeries() bool {
+	var (
+		series []byte
+		blocks []downsampleBlock
+	)
+	if k.peek != nil {
+		blocks = append(blocks, *k.peek)
+		series, _ = SeriesAndFieldFromCompositeKey(k.peek.key)
+		k.peek = nil
+	}
+
+	for k.iter.Next() {
+		key, minTime, maxTime, block, err := k.iter.Read()
+		if err != nil {
+			k.err = err
+			return false
+		}
+
+		// The underlying iterator may reuse its buffers.
+		b := downsampleBlock{
+			key:     append([]byte(nil), key...),
+			minTime: minTime,
+			maxTime: maxTime,
+			block:   append([]byte(nil), block...),
+		}
+
+		s, _ := SeriesAndFieldFromCompositeKey(b.key)
+		if series != nil && !bytes.Equal(s, series) {
+			k.peek = &b
+			break
+		}
+		series = s
+		blocks = append(blocks, b)
+	}
+
+	if len(blocks) == 0 {
+		return false
+	}
+
+	if err := k.downsample(series, blocks); err != nil {
+		k.err = err
+		return false
+	}
+	return true
+}
+
+// downsample aggregates the blocks of a single series into k.out.
+func (k *downsampleKeyIterator) downsample(series []byte, blocks []downsampleBlock) error {
+	// Written fields are not downsampled if their aggregates would overwrite
+	// other written fields.
+	measurement := models.ParseName(series)
+	written := make(map[string]struct{})
+	for _, b := range blocks {
+		if _, field := SeriesAndFieldFromCompositeKey(b.key); !k.aggregates.Contains(measurement, field) {
+			written[string(field)] = struct{}{}
+		}
+	}
+	conflicts := func(field []byte) bool {
+		for _, agg := range k.config.Aggregates {
+			if _, ok := written[string(field)+"_"+agg]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	keys := make(map[string]*downsampleKey)
+	generated := make(map[string]*downsampleKey)
+	for i := 0; i < len(blocks); {
+		j := i + 1
+		for j < len(blocks) && bytes.Equal(blocks[j].key, blocks[i].key) {
+			j++
+		}
+
+		key := blocks[i].key
+		typ, err := BlockType(blocks[i].block)
+		if err != nil {
+			return err
+		}
+
+		_, field := SeriesAndFieldFromCompositeKey(key)
+		switch {
+		case k.aggregates.Contains(measurement, field) || conflicts(field):
+			err = k.add(keys, &downsampleKey{key: key, typ: typ, blocks: blocks[i:j]}, false)
+		case typ == BlockFloat64:
+			err = k.downsampleFloat(generated, series, field, blocks[i:j])
+		case typ == BlockInteger:
+			err = k.downsampleInteger(generated, series, field, blocks[i:j])
+		case typ == BlockUnsigned:
+			err = k.downsampleUnsigned(generated, series, field, blocks[i:j])
+		default:
+			err = k.add(keys, &downsampleKey{key: key, typ: typ, blocks: blocks[i:j]}, false)
+		}
+		if err != nil {
+			return err
+		}
+		i = j
+	}
+
+	if err := k.mergeAggregates(keys, generated); err != nil {
+		return err
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	for _, key := range sorted {
+		dk := keys[key]
+		for _, b := range dk.blocks {
+			b.key = dk.key
+			k.out = append(k.out, b)
+		}
+	}
+	return nil
+}
+
+// add adds dk to keys, merging it with any blocks already added for the same
+// key.  The values of generated keys replace existing values with the same
+// timestamp.
+func (k *downsampleKeyIterator) add(keys map[string]*downsampleKey, dk *downsampleKey, generated bool) error {
+	prev := keys[string(dk.key)]
+	if prev == nil {
+		keys[string(dk.key)] = dk
+		return nil
+	} else if prev.typ != dk.typ {
+		return fmt.Errorf("downsample: conflicting block types for key %q", dk.key)
+	}
+
+	a, b := prev, dk
+	if !generated {
+		a, b = dk, prev
+	}
+
+	var (
+		merged []downsampleBlock
+		err    error
+	)
+	switch dk.typ {
+	case BlockFloat64:
+		merged, err = k.mergeFloatBlocks(a.blocks, b.blocks)
+	case BlockInteger:
+		merged, err = k.mergeIntegerBlocks(a.blocks, b.blocks)
+	case BlockUnsigned:
+		merged, err = k.mergeUnsignedBlocks(a.blocks, b.blocks)
+	default:
+		return fmt.Errorf("downsample: conflicting block types for key %q", dk.key)
+	}
+	if err != nil {
+		return err
+	}
+	prev.blocks = merged
+	return nil
+}
+
+// mergeAggregates adds the generated aggregates to keys.  Aggregates of windows
+// which have already been downsampled, because values were written late into
+// them, are merged with the existing aggregates of these windows.
+func (k *downsampleKeyIterator) mergeAggregates(keys, generated map[string]*downsampleKey) error {
+	// Merge all aggregates before updating keys, as means are merged using the
+	// existing counts.
+	merged := make(map[string][]downsampleBlock)
+	for key, g := range generated {
+		prev := keys[key]
+		if prev == nil {
+			continue
+		} else if prev.typ != g.typ {
+			return fmt.Errorf("downsample: conflicting block types for key %q", g.key)
+		}
+
+		var (
+			blocks []downsampleBlock
+			err    error
+		)
+		switch {
+		case g.aggregate == DownsampleMean:
+			blocks, err = k.mergeMeans(keys, generated, prev, g)
+		case g.typ == BlockFloat64:
+			blocks, err = k.mergeFloatAggregate(g.aggregate, prev.blocks, g.blocks)
+		case g.typ == BlockInteger:
+			blocks, err = k.mergeIntegerAggregate(g.aggregate, prev.blocks, g.blocks)
+		case g.typ == BlockUnsigned:
+			blocks, err = k.mergeUnsignedAggregate(g.aggregate, prev.blocks, g.blocks)
+		default:
+			err = fmt.Errorf("downsample: conflicting block types for key %q", g.key)
+		}
+		if err != nil {
+			return err
+		}
+		merged[key] = blocks
+	}
+
+	for key, g := range generated {
+		if blocks, ok := merged[key]; ok {
+			keys[key].blocks = blocks
+		} else {
+			keys[key] = g
+		}
+	}
+	return nil
+}
+
+// mergeMeans merges the existing means in prev with the means in g, weighting
+// them by the counts of their window.  Means of windows without an existing
+// count are replaced.
+func (k *downsampleKeyIterator) mergeMeans(keys, generated map[string]*downsampleKey, prev, g *downsampleKey) ([]downsampleBlock, error) {
+	countKey := string(g.key[:len(g.key)-len(DownsampleMean)]) + DownsampleCount
+	counts := func(dk *downsampleKey) (map[int64]int64, error) {
+		m := make(map[int64]int64)
+		if dk == nil {
+			return m, nil
+		}
+		a, err := decodeIntegerBlocks(dk.blocks)
+		if err != nil {
+			return nil, err
+		}
+		for i, t := range a.Timestamps {
+			m[t] = a.Values[i]
+		}
+		return m, nil
+	}
+	an, err := counts(keys[countKey])
+	if err != nil {
+		return nil, err
+	}
+	bn, err := counts(generated[countKey])
+	if err != nil {
+		return nil, err
+	}
+
+	av, err := decodeFloatBlocks(prev.blocks)
+	if err != nil {
+		return nil, err
+	}
+	bv, err := decodeFloatBlocks(g.blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	var out tsdb.FloatArray
+	for i, j := 0, 0; i < av.Len() || j < bv.Len(); {
+		switch {
+		case j == bv.Len() || (i < av.Len() && av.Timestamps[i] < bv.Timestamps[j]):
+			out.Timestamps = append(out.Timestamps, av.Timestamps[i])
+			out.Values = append(out.Values, av.Values[i])
+			i++
+		case i == av.Len() || bv.Timestamps[j] < av.Timestamps[i]:
+			out.Timestamps = append(out.Timestamps, bv.Timestamps[j])
+			out.Values = append(out.Values, bv.Values[j])
+			j++
+		default:
+			t := av.Timestamps[i]
+			v := bv.Values[j]
+			if na, nb := float64(an[t]), float64(bn[t]); na > 0 {
+				v = (av.Values[i]*na + bv.Values[j]*nb) / (na + nb)
+			}
+			out.Timestamps = append(out.Timestamps, t)
+			out.Values = append(out.Values, v)
+			i++
+			j++
+		}
+	}
+	return k.encodeFloatBlocks(&out)
+}
+
+// addAggregate adds the blocks generated by aggregate for field to keys and
+// records the new field.
+func (k *downsampleKeyIterator) addAggregate(keys map[string]*downsampleKey, series, field []byte, aggregate string, typ byte, blocks []downsampleBlock) error {
+	name := string(field) + "_" + aggregate
+	key := SeriesFieldKeyBytes(string(series), name)
+	if err := k.add(keys, &downsampleKey{key: key, typ: typ, blocks: blocks, aggregate: aggregate}, true); err != nil {
+		return err
+	}
+
+	measurement := models.ParseName(series)
+	id := string(measurement) + keyFieldSeparator + name
+	if _, ok := k.seen[id]; ok {
+		return nil
+	}
+	k.seen[id] = struct{}{}
+	k.fields = append(k.fields, &tsdb.FieldChange{
+		FieldCreate: tsdb.FieldCreate{
+			Measurement: measurement,
+			Field:       &tsdb.Field{Name: name, Type: BlockTypeToInfluxQLDataType(typ)},
+		},
+		ChangeType: tsdb.AddMeasurementField,
+	})
+	return nil
+}
+
+// windowStart returns the start of the window containing t.
+func (k *downsampleKeyIterator) windowStart(t int64) int64 {
+	iv := int64(k.config.Interval)
+	m := t % iv
+	if m < 0 {
+		m += iv
+	}
+	return t - m
+}
+
+// Read returns the key, time range, and raw data for the next block.
+func (k *downsampleKeyIterator) Read() ([]byte, int64, int64, []byte, error) {
+	if k.pos >= len(k.out) {
+		return nil, 0, 0, nil, k.err
+	}
+	b := k.out[k.pos]
+	return b.key, b.minTime, b.maxTime, b.block, nil
+}
+
+// Close closes the iterator.
+func (k *downsampleKeyIterator) Close() error {
+	k.out = nil
+	k.peek = nil
+	return k.iter.Close()
+}
+
+// Err returns any errors encountered during iteration.
+func (k *downsampleKeyIterator) Err() error {
+	if k.err != nil {
+		return k.err
+	}
+	return k.iter.Err()
+}
+
+// EstimatedIndexSize returns the estimated size of the index of the
+// downsampled keys.
+func (k *downsampleKeyIterator) EstimatedIndexSize() int {
+	return k.iter.EstimatedIndexSize() * len(k.config.Aggregates)
+}
@@ -0,0 +1,354 @@
+package tsm1_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxdb/v2/tsdb/engine/tsm1"
+	"github.com/influxdata/influxql"
+	"go.uber.org/zap"
+)
+
+func TestDownsampleConfig_Validate(t *testing.T) {
+	if err := tsm1.NewDownsampleConfig().Validate(); err != nil {
+		t.Fatalf("unexpected error validating default config: %v", err)
+	}
+
+	for _, c := range []tsm1.DownsampleConfig{
+		{Age: 0, Interval: time.Minute, Aggregates: []string{"mean"}},
+		{Age: time.Hour, Interval: 0, Aggregates: []string{"mean"}},
+		{Age: time.Hour, Interval: time.Minute},
+		{Age: time.Hour, Interval: time.Minute, Aggregates: []string{"median"}},
+		{Age: time.Hour, Interval: time.Minute, Aggregates: []string{"mean", "mean"}},
+		{Age: time.Hour, Interval: time.Minute, Aggregates: []string{"mean", "max"}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("expected error validating %+v", c)
+		}
+	}
+}
+
+// Ensures numeric fields are replaced by their aggregates and other fields are
+// copied unchanged.
+func TestCompactor_CompactDownsample(t *testing.T) {
+	dir := t.TempDir()
+
+	const m = int64(time.Minute)
+	f1 := MustWriteTSM(t, dir, 1, map[string][]tsm1.Value{
+		"cpu,host=A#!~#value": {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 3.0), tsm1.NewValue(m+5, 4.0)},
+		"cpu,host=A#!~#n":     {tsm1.NewValue(0, int64(2)), tsm1.NewValue(m, int64(7))},
+		"cpu,host=A#!~#up":    {tsm1.NewValue(0, true)},
+	})
+	f2 := MustWriteTSM(t, dir, 2, map[string][]tsm1.Value{
+		"cpu,host=A#!~#value": {tsm1.NewValue(20, 2.0)},
+		"cpu,host=B#!~#value": {tsm1.NewValue(-5, 8.0)},
+	})
+
+	fs := &fakeFileStore{}
+	t.Cleanup(func() { fs.Close() })
+	compactor := tsm1.NewCompactor()
+	compactor.Dir = dir
+	compactor.FileStore = fs
+	compactor.Open()
+
+	config := tsm1.DownsampleConfig{
+		Age:        time.Hour,
+		Interval:   time.Minute,
+		Aggregates: []string{tsm1.DownsampleMean, tsm1.DownsampleMax, tsm1.DownsampleCount},
+	}
+	files, fields, err := compactor.CompactDownsample([]string{f1, f2}, config, nil, zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error downsampling: %v", err)
+	} else if got, exp := len(files), 1; got != exp {
+		t.Fatalf("files length mismatch: got %v, exp %v", got, exp)
+	}
+
+	r := MustOpenTSMReader(files[0])
+	t.Cleanup(func() { r.Close() })
+
+	var data = []struct {
+		key    string
+		points []tsm1.Value
+	}{
+		{"cpu,host=A#!~#n_count", []tsm1.Value{tsm1.NewValue(0, int64(1)), tsm1.NewValue(m, int64(1))}},
+		{"cpu,host=A#!~#n_max", []tsm1.Value{tsm1.NewValue(0, int64(2)), tsm1.NewValue(m, int64(7))}},
+		{"cpu,host=A#!~#n_mean", []tsm1.Value{tsm1.NewValue(0, 2.0), tsm1.NewValue(m, 7.0)}},
+		{"cpu,host=A#!~#up", []tsm1.Value{tsm1.NewValue(0, true)}},
+		{"cpu,host=A#!~#value_count", []tsm1.Value{tsm1.NewValue(0, int64(3)), tsm1.NewValue(m, int64(1))}},
+		{"cpu,host=A#!~#value_max", []tsm1.Value{tsm1.NewValue(0, 3.0), tsm1.NewValue(m, 4.0)}},
+		{"cpu,host=A#!~#value_mean", []tsm1.Value{tsm1.NewValue(0, 2.0), tsm1.NewValue(m, 4.0)}},
+		{"cpu,host=B#!~#value_count", []tsm1.Value{tsm1.NewValue(-m, int64(1))}},
+		{"cpu,host=B#!~#value_max", []tsm1.Value{tsm1.NewValue(-m, 8.0)}},
+		{"cpu,host=B#!~#value_mean", []tsm1.Value{tsm1.NewValue(-m, 8.0)}},
+	}
+
+	if got, exp := r.KeyCount(), len(data); got != exp {
+		t.Fatalf("keys length mismatch: got %v, exp %v", got, exp)
+	}
+
+	for i, p := range data {
+		if got, _ := r.KeyAt(i); string(got) != p.key {
+			t.Fatalf("key mismatch at %d: got %s, exp %s", i, got, p.key)
+		}
+
+		values, err := r.ReadAll([]byte(p.key))
+		if err != nil {
+			t.Fatalf("unexpected error reading: %v", err)
+		}
+
+		if got, exp := len(values), len(p.points); got != exp {
+			t.Fatalf("values length mismatch %s: got %v, exp %v", p.key, got, exp)
+		}
+
+		for i, point := range p.points {
+			assertValueEqual(t, values[i], point)
+		}
+	}
+
+	exp := map[string]influxql.DataType{
+		"n_count":     influxql.Integer,
+		"n_max":       influxql.Integer,
+		"n_mean":      influxql.Float,
+		"value_count": influxql.Integer,
+		"value_max":   influxql.Float,
+		"value_mean":  influxql.Float,
+	}
+	if got := len(fields); got != len(exp) {
+		t.Fatalf("fields length mismatch: got %v, exp %v", got, len(exp))
+	}
+	for _, f := range fields {
+		if string(f.Measurement) != "cpu" || f.ChangeType != tsdb.AddMeasurementField {
+			t.Fatalf("unexpected field change: %+v", f)
+		} else if typ, ok := exp[f.Field.Name]; !ok || typ != f.Field.Type {
+			t.Fatalf("unexpected field %s of type %s", f.Field.Name, f.Field.Type)
+		}
+	}
+}
+
+// Ensures aggregates of late values are merged with existing aggregates for the
+// same window and previously downsampled fields are not aggregated again.
+func TestCompactor_CompactDownsample_Merge(t *testing.T) {
+	dir := t.TempDir()
+
+	const m = int64(time.Minute)
+	f1 := MustWriteTSM(t, dir, 1, map[string][]tsm1.Value{
+		"cpu#!~#value_max": {tsm1.NewValue(0, 5.0), tsm1.NewValue(m, 6.0)},
+	})
+	f2 := MustWriteTSM(t, dir, 2, map[string][]tsm1.Value{
+		"cpu#!~#value": {tsm1.NewValue(m+1, 9.0), tsm1.NewValue(2*m, 1.0)},
+	})
+
+	fs := &fakeFileStore{}
+	t.Cleanup(func() { fs.Close() })
+	compactor := tsm1.NewCompactor()
+	compactor.Dir = dir
+	compactor.FileStore = fs
+	compactor.Open()
+
+	config := tsm1.DownsampleConfig{Age: time.Hour, Interval: time.Minute, Aggregates: []string{tsm1.DownsampleMax}}
+	aggregates := tsm1.DownsampleFields{"cpu": {"value_max": {}}}
+	files, _, err := compactor.CompactDownsample([]string{f1, f2}, config, aggregates, zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error downsampling: %v", err)
+	}
+
+	r := MustOpenTSMReader(files[0])
+	t.Cleanup(func() { r.Close() })
+
+	if got, exp := r.KeyCount(), 1; got != exp {
+		t.Fatalf("keys length mismatch: got %v, exp %v", got, exp)
+	}
+
+	values, err := r.ReadAll([]byte("cpu#!~#value_max"))
+	if err != nil {
+		t.Fatalf("unexpected error reading: %v", err)
+	}
+
+	points := []tsm1.Value{tsm1.NewValue(0, 5.0), tsm1.NewValue(m, 9.0), tsm1.NewValue(2*m, 1.0)}
+	if got, exp := len(values), len(points); got != exp {
+		t.Fatalf("values length mismatch: got %v, exp %v", got, exp)
+	}
+	for i, point := range points {
+		assertValueEqual(t, values[i], point)
+	}
+}
+
+// Ensures each mergeable aggregate of late values is combined with the existing
+// aggregate of the window, and first values are kept.
+func TestCompactor_CompactDownsample_MergeAggregates(t *testing.T) {
+	dir := t.TempDir()
+
+	f1 := MustWriteTSM(t, dir, 1, map[string][]tsm1.Value{
+		"cpu#!~#value_count": {tsm1.NewValue(0, int64(2))},
+		"cpu#!~#value_first": {tsm1.NewValue(0, 2.0)},
+		"cpu#!~#value_mean":  {tsm1.NewValue(0, 3.0)},
+		"cpu#!~#value_min":   {tsm1.NewValue(0, 2.0)},
+		"cpu#!~#value_sum":   {tsm1.NewValue(0, 6.0)},
+	})
+	f2 := MustWriteTSM(t, dir, 2, map[string][]tsm1.Value{
+		"cpu#!~#value": {tsm1.NewValue(10, 1.0), tsm1.NewValue(20, 11.0)},
+	})
+
+	fs := &fakeFileStore{}
+	t.Cleanup(func() { fs.Close() })
+	compactor := tsm1.NewCompactor()
+	compactor.Dir = dir
+	compactor.FileStore = fs
+	compactor.Open()
+
+	config := tsm1.DownsampleConfig{
+		Age:        time.Hour,
+		Interval:   time.Minute,
+		Aggregates: []string{tsm1.DownsampleMean, tsm1.DownsampleCount, tsm1.DownsampleSum, tsm1.DownsampleMin, tsm1.DownsampleFirst},
+	}
+	aggregates := tsm1.DownsampleFields{"cpu": {"value_count": {}, "value_first": {}, "value_mean": {}, "value_min": {}, "value_sum": {}}}
+	files, _, err := compactor.CompactDownsample([]string{f1, f2}, config, aggregates, zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error downsampling: %v", err)
+	}
+
+	r := MustOpenTSMReader(files[0])
+	t.Cleanup(func() { r.Close() })
+
+	for key, exp := range map[string]tsm1.Value{
+		"cpu#!~#value_count": tsm1.NewValue(0, int64(4)),
+		"cpu#!~#value_first": tsm1.NewValue(0, 2.0),
+		"cpu#!~#value_mean":  tsm1.NewValue(0, 4.5),
+		"cpu#!~#value_min":   tsm1.NewValue(0, 1.0),
+		"cpu#!~#value_sum":   tsm1.NewValue(0, 18.0),
+	} {
+		values, err := r.ReadAll([]byte(key))
+		if err != nil {
+			t.Fatalf("unexpected error reading: %v", err)
+		} else if got := len(values); got != 1 {
+			t.Fatalf("values length mismatch %s: got %v, exp 1", key, got)
+		}
+		assertValueEqual(t, values[0], exp)
+	}
+}
+
+// Ensures written fields are downsampled whatever their names, unless their
+// aggregates would overwrite other written fields.
+func TestCompactor_CompactDownsample_WrittenFields(t *testing.T) {
+	dir := t.TempDir()
+
+	f1 := MustWriteTSM(t, dir, 1, map[string][]tsm1.Value{
+		"cpu#!~#disk_max":  {tsm1.NewValue(0, 1.0), tsm1.NewValue(10, 3.0)},
+		"cpu#!~#value":     {tsm1.NewValue(0, 5.0), tsm1.NewValue(10, 6.0)},
+		"cpu#!~#value_max": {tsm1.NewValue(0, 2.0)},
+	})
+
+	fs := &fakeFileStore{}
+	t.Cleanup(func() { fs.Close() })
+	compactor := tsm1.NewCompactor()
+	compactor.Dir = dir
+	compactor.FileStore = fs
+	compactor.Open()
+
+	config := tsm1.DownsampleConfig{Age: time.Hour, Interval: time.Minute, Aggregates: []string{tsm1.DownsampleMax}}
+	files, _, err := compactor.CompactDownsample([]string{f1}, config, nil, zap.NewNop())
+	if err != nil {
+		t.Fatalf("unexpected error downsampling: %v", err)
+	}
+
+	r := MustOpenTSMReader(files[0])
+	t.Cleanup(func() { r.Close() })
+
+	var data = []struct {
+		key    string
+		points []tsm1.Value
+	}{
+		{"cpu#!~#disk_max_max", []tsm1.Value{tsm1.NewValue(0, 3.0)}},
+		{"cpu#!~#value", []tsm1.Value{tsm1.NewValue(0, 5.0), tsm1.NewValue(10, 6.0)}},
+		{"cpu#!~#value_max_max", []tsm1.Value{tsm1.NewValue(0, 2.0)}},
+	}
+	if got, exp := r.KeyCount(), len(data); got != exp {
+		t.Fatalf("keys length mismatch: got %v, exp %v", got, exp)
+	}
+	for _, p := range data {
+		values, err := r.ReadAll([]byte(p.key))
+		if err != nil {
+			t.Fatalf("unexpected error reading: %v", err)
+		} else if got, exp := len(values), len(p.points); got != exp {
+			t.Fatalf("values length mismatch %s: got %v, exp %v", p.key, got, exp)
+		}
+		for i, point := range p.points {
+			assertValueEqual(t, values[i], point)
+		}
+	}
+}
+
+// Ensures files are only planned for downsampling once all data is older than
+// the configured age and they have not been downsampled already.
+func TestDownsamplePlanner_PlanDownsample(t *testing.T) {
+	dir := t.TempDir()
+
+	old := time.Now().Add(-2 * time.Hour).UnixNano()
+	MustWriteTSM(t, dir, 1, map[string][]tsm1.Value{"cpu#!~#value": {tsm1.NewValue(old, 1.0)}})
+	MustWriteTSM(t, dir, 2, map[string][]tsm1.Value{"cpu#!~#value": {tsm1.NewValue(old+1, 2.0)}})
+
+	fs := tsm1.NewFileStore(dir, tsdb.EngineTags{})
+	if err := fs.Open(context.Background()); err != nil {
+		t.Fatalf("unexpected error opening file store: %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+
+	config := tsm1.DownsampleConfig{Age: time.Hour, Interval: time.Minute, Aggregates: []string{tsm1.DownsampleMean, tsm1.DownsampleCount}}
+	cp := tsm1.NewDownsamplePlanner(nil, time.Hour, config)
+	cp.SetFileStore(fs)
+
+	groups, pLen := cp.PlanDownsample()
+	if got, exp := len(groups), 1; got != exp {
+		t.Fatalf("group length mismatch: got %v, exp %v", got, exp)
+	} else if got, exp := pLen, int64(1); got != exp {
+		t.Fatalf("plan length mismatch: got %v, exp %v", got, exp)
+	} else if got, exp := len(groups[0]), 2; got != exp {
+		t.Fatalf("files length mismatch: got %v, exp %v", got, exp)
+	}
+
+	// Subsequent checks are throttled.
+	if groups, _ := cp.PlanDownsample(); len(groups) != 0 {
+		t.Fatalf("expected no plan, got %v", groups)
+	}
+
+	cp.Release(groups)
+	if err := cp.Downsampled(groups[0]); err != nil {
+		t.Fatalf("unexpected error recording downsample: %v", err)
+	}
+
+	cp = tsm1.NewDownsamplePlanner(nil, time.Hour, config)
+	cp.SetFileStore(fs)
+	if groups, _ := cp.PlanDownsample(); len(groups) != 0 {
+		t.Fatalf("expected no plan after downsampling, got %v", groups)
+	}
+
+	// Files holding recent data are never downsampled.
+	config.Age = 3 * time.Hour
+	cp = tsm1.NewDownsamplePlanner(nil, time.Hour, config)
+	cp.SetFileStore(fs)
+	if err := cp.Downsampled(tsm1.CompactionGroup{}); err != nil {
+		t.Fatalf("unexpected error resetting downsample: %v", err)
+	}
+	if groups, _ := cp.PlanDownsample(); len(groups) != 0 {
+		t.Fatalf("expected no plan for recent data, got %v", groups)
+	}
+
+	// Fields created by downsampling are kept with the downsampled generation.
+	if err := cp.AddAggregateFields(tsdb.FieldChanges{{
+		FieldCreate: tsdb.FieldCreate{Measurement: []byte("cpu"), Field: &tsdb.Field{Name: "value_mean", Type: influxql.Float}},
+		ChangeType:  tsdb.AddMeasurementField,
+	}}); err != nil {
+		t.Fatalf("unexpected error recording aggregate fields: %v", err)
+	} else if err := cp.Downsampled(groups[0]); err != nil {
+		t.Fatalf("unexpected error recording downsample: %v", err)
+	}
+	cp = tsm1.NewDownsamplePlanner(nil, time.Hour, config)
+	cp.SetFileStore(fs)
+	if aggregates, err := cp.AggregateFields(); err != nil {
+		t.Fatalf("unexpected error reading aggregate fields: %v", err)
+	} else if !aggregates.Contains([]byte("cpu"), []byte("value_mean")) || aggregates.Contains([]byte("cpu"), []byte("value")) {
+		t.Fatalf("unexpected aggregate fields: %v", aggregates)
+	}
+}
@@ -8,6 +8,8 @@ import (
 
 	"github.com/influxdata/influxdb/v2/pkg/pool"
 	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxdb/v2/tsdb/cursors"
+	"github.com/influxdata/influxdb/v2/tsdb/sketch"
 	"github.com/influxdata/influxql"
 )
 
@@ -27,6 +29,9 @@ const (
 	// BlockUnsigned designates a block encodes uint64 values.
 	BlockUnsigned = byte(4)
 
+	// BlockSketch designates a block encodes quantile sketch values.
+	BlockSketch = byte(5)
+
 	// encodedBlockHeaderSize is the size of the header for an encoded block.  There is one
 	// byte encoding the type of the block.
 	encodedBlockHeaderSize = 1
@@ -73,6 +78,9 @@ var (
 	booleanEncoderPool = pool.NewGeneric(runtime.NumCPU(), func(sz int) interface{} {
 		return NewBooleanEncoder(sz)
 	})
+	sketchEncoderPool = pool.NewGeneric(runtime.NumCPU(), func(sz int) interface{} {
+		return NewSketchEncoder(sz)
+	})
 
 	// decoder pools
 
@@ -91,6 +99,9 @@ var (
 	booleanDecoderPool = pool.NewGeneric(runtime.NumCPU(), func(sz int) interface{} {
 		return &BooleanDecoder{}
 	})
+	sketchDecoderPool = pool.NewGeneric(runtime.NumCPU(), func(sz int) interface{} {
+		return &SketchDecoder{}
+	})
 )
 
 // Value represents a TSM-encoded value.
@@ -125,6 +136,8 @@ func NewValue(t int64, value interface{}) Value {
 		return BooleanValue{unixnano: t, value: v}
 	case string:
 		return StringValue{unixnano: t, value: v}
+	case *sketch.Sketch:
+		return SketchValue{unixnano: t, value: v}
 	}
 	return EmptyValue{}
 }
@@ -154,6 +167,12 @@ func NewStringValue(t int64, v string) Value {
 	return StringValue{unixnano: t, value: v}
 }
 
+// NewSketchValue returns a new sketch value.  The sketch must not be modified
+// once the value is written.
+func NewSketchValue(t int64, v *sketch.Sketch) Value {
+	return SketchValue{unixnano: t, value: v}
+}
+
 // EmptyValue is used when there is no appropriate other value.
 type EmptyValue struct{}
 
@@ -175,6 +194,7 @@ func (IntegerValue) internalOnly()  {}
 func (UnsignedValue) internalOnly() {}
 func (BooleanValue) internalOnly()  {}
 func (FloatValue) internalOnly()    {}
+func (SketchValue) internalOnly()   {}
 
 // Encode converts the values to a byte slice.  If there are no values,
 // this function panics.
@@ -194,6 +214,8 @@ func (a Values) Encode(buf []byte) ([]byte, error) {
 		return encodeBooleanBlock(buf, a)
 	case StringValue:
 		return encodeStringBlock(buf, a)
+	case SketchValue:
+		return encodeSketchBlock(buf, a)
 	}
 
 	return nil, fmt.Errorf("unsupported value type %T", a[0])
@@ -216,6 +238,8 @@ func (a Values) InfluxQLType() (influxql.DataType, error) {
 		return influxql.Boolean, nil
 	case StringValue:
 		return influxql.String, nil
+	case SketchValue:
+		return cursors.SketchDataType, nil
 	}
 
 	return influxql.Unknown, fmt.Errorf("unsupported value type %T", a[0])
@@ -226,7 +250,7 @@ func (a Values) InfluxQLType() (influxql.DataType, error) {
 func BlockType(block []byte) (byte, error) {
 	blockType := block[0]
 	switch blockType {
-	case BlockFloat64, BlockInteger, BlockUnsigned, BlockBoolean, BlockString:
+	case BlockFloat64, BlockInteger, BlockUnsigned, BlockBoolean, BlockString, BlockSketch:
 		return blockType, nil
 	default:
 		return 0, fmt.Errorf("unknown block type: %d", blockType)
@@ -313,6 +337,17 @@ func DecodeBlock(block []byte, vals []Value) ([]Value, error) {
 		}
 		return vals[:len(decoded)], err
 
+	case BlockSketch:
+		var buf []SketchValue
+		decoded, err := DecodeSketchBlock(block, &buf)
+		if len(vals) < len(decoded) {
+			vals = make([]Value, len(decoded))
+		}
+		for i := range decoded {
+			vals[i] = decoded[i]
+		}
+		return vals[:len(decoded)], err
+
 	default:
 		return nil, fmt.Errorf("unknown block type: %d", blockType)
 	}
@@ -891,6 +926,28 @@ func encodeStringBlockUsing(buf []byte, values []Value, tenc TimeEncoder, venc S
 	return packBlock(buf, BlockString, tb, vb), nil
 }
 
+// EncodeStringArrayBlockUsing encodes a into a string block, compressing the
+// values with codec. A nil codec uses snappy.
+func EncodeStringArrayBlockUsing(a *tsdb.StringArray, b []byte, codec tsdb.BlockCodec) ([]byte, error) {
+	if a.Len() == 0 {
+		return nil, nil
+	}
+
+	vb, err := StringArrayEncodeAllUsing(a.Values, nil, codec)
+	if err != nil {
+		return nil, err
+	}
+
+	tb, err := TimeArrayEncodeAll(a.Timestamps, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prepend the first timestamp of the block in the first 8 bytes and the block
+	// in the next byte, followed by the block
+	return packBlock(b, BlockString, tb, vb), nil
+}
+
 // DecodeStringBlock decodes the string block from the byte slice
 // and appends the string values to a.
 func DecodeStringBlock(block []byte, a *[]StringValue) ([]StringValue, error) {
@@ -950,6 +1007,130 @@ func DecodeStringBlock(block []byte, a *[]StringValue) ([]StringValue, error) {
 	return (*a)[:i], err
 }
 
+// SketchValue represents a quantile sketch value.
+type SketchValue struct {
+	unixnano int64
+	value    *sketch.Sketch
+}
+
+// Value returns the underlying sketch.
+func (v SketchValue) Value() interface{} {
+	return v.value
+}
+
+// UnixNano returns the timestamp of the value.
+func (v SketchValue) UnixNano() int64 {
+	return v.unixnano
+}
+
+// Size returns the number of bytes necessary to represent the value and its timestamp.
+func (v SketchValue) Size() int {
+	return 8 + v.value.Size()
+}
+
+// String returns the string representation of the value and its timestamp.
+func (v SketchValue) String() string {
+	return fmt.Sprintf("%v %v", time.Unix(0, v.unixnano), v.value)
+}
+
+func (v SketchValue) RawValue() *sketch.Sketch { return v.value }
+
+func encodeSketchBlock(buf []byte, values []Value) ([]byte, error) {
+	tenc := getTimeEncoder(len(values))
+	venc := getSketchEncoder(len(values))
+
+	b, err := encodeSketchBlockUsing(buf, values, tenc, venc)
+
+	putTimeEncoder(tenc)
+	putSketchEncoder(venc)
+
+	return b, err
+}
+
+func encodeSketchBlockUsing(buf []byte, values []Value, tenc TimeEncoder, venc SketchEncoder) ([]byte, error) {
+	tenc.Reset()
+	venc.Reset()
+
+	for _, v := range values {
+		vv := v.(SketchValue)
+		tenc.Write(vv.unixnano)
+		venc.Write(vv.value)
+	}
+
+	// Encoded timestamp values
+	tb, err := tenc.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	// Encoded sketch values
+	vb, err := venc.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	// Prepend the first timestamp of the block in the first 8 bytes
+	return packBlock(buf, BlockSketch, tb, vb), nil
+}
+
+// DecodeSketchBlock decodes the sketch block from the byte slice
+// and appends the sketch values to a.
+func DecodeSketchBlock(block []byte, a *[]SketchValue) ([]SketchValue, error) {
+	blockType := block[0]
+	if blockType != BlockSketch {
+		return nil, fmt.Errorf("invalid block type: exp %d, got %d", BlockSketch, blockType)
+	}
+
+	block = block[1:]
+
+	// The first 8 bytes is the minimum timestamp of the block
+	tb, vb, err := unpackBlock(block)
+	if err != nil {
+		return nil, err
+	}
+
+	sz := CountTimestamps(tb)
+
+	if cap(*a) < sz {
+		*a = make([]SketchValue, sz)
+	} else {
+		*a = (*a)[:sz]
+	}
+
+	tdec := timeDecoderPool.Get(0).(*TimeDecoder)
+	vdec := sketchDecoderPool.Get(0).(*SketchDecoder)
+
+	var i int
+	err = func(a []SketchValue) error {
+		// Setup our timestamp and value decoders
+		tdec.Init(tb)
+		err = vdec.SetBytes(vb)
+		if err != nil {
+			return err
+		}
+
+		// Decode both a timestamp and value
+		j := 0
+		for j < len(a) && tdec.Next() && vdec.Next() {
+			a[j] = SketchValue{unixnano: tdec.Read(), value: vdec.Read()}
+			j++
+		}
+		i = j
+
+		// Did timestamp decoding have an error?
+		err = tdec.Error()
+		if err != nil {
+			return err
+		}
+		// Did sketch decoding have an error?
+		return vdec.Error()
+	}(*a)
+
+	timeDecoderPool.Put(tdec)
+	sketchDecoderPool.Put(vdec)
+
+	return (*a)[:i], err
+}
+
 func packBlock(buf []byte, typ byte, ts []byte, values []byte) []byte {
 	// We encode the length of the timestamp block using a variable byte encoding.
 	// This allows small byte slices to take up 1 byte while larger ones use 2 or more.
@@ -1032,6 +1213,7 @@ func putFloatEncoder(enc *FloatEncoder) { floatEncoderPool.Put(enc) }
 func getStringEncoder(sz int) StringEncoder {
 	x := stringEncoderPool.Get(sz).(StringEncoder)
 	x.Reset()
+	x.SetCodec(nil)
 	return x
 }
 func putStringEncoder(enc StringEncoder) { stringEncoderPool.Put(enc) }
@@ -1042,3 +1224,10 @@ func getBooleanEncoder(sz int) BooleanEncoder {
 	return x
 }
 func putBooleanEncoder(enc BooleanEncoder) { booleanEncoderPool.Put(enc) }
+
+func getSketchEncoder(sz int) SketchEncoder {
+	x := sketchEncoderPool.Get(sz).(SketchEncoder)
+	x.Reset()
+	return x
+}
+func putSketchEncoder(enc SketchEncoder) { sketchEncoderPool.Put(enc) }
@@ -9,6 +9,7 @@ import (
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/influxdata/influxdb/v2/tsdb/engine/tsm1"
+	"github.com/influxdata/influxdb/v2/tsdb/sketch"
 )
 
 func TestEncoding_FloatBlock(t *testing.T) {
@@ -255,6 +256,34 @@ func TestEncoding_StringBlock_Basic(t *testing.T) {
 	}
 }
 
+func TestEncoding_SketchBlock(t *testing.T) {
+	valueCount := 100
+	times := getTimes(valueCount, 60, time.Second)
+	values := make([]tsm1.Value, len(times))
+	for i, t := range times {
+		s := sketch.NewDefault()
+		for j := 0; j <= i; j++ {
+			s.Add(float64(j * 10))
+		}
+		values[i] = tsm1.NewValue(t, s)
+	}
+
+	b, err := tsm1.Values(values).Encode(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var decodedValues []tsm1.Value
+	decodedValues, err = tsm1.DecodeBlock(b, decodedValues)
+	if err != nil {
+		t.Fatalf("unexpected error decoding block: %v", err)
+	}
+
+	if !reflect.DeepEqual(decodedValues, values) {
+		t.Fatalf("unexpected results:\n\tgot: %v\n\texp: %v\n", decodedValues, values)
+	}
+}
+
 func TestEncoding_BlockType(t *testing.T) {
 	tests := []struct {
 		value     interface{}
@@ -265,6 +294,7 @@ func TestEncoding_BlockType(t *testing.T) {
 		{value: uint64(1), blockType: tsm1.BlockUnsigned},
 		{value: true, blockType: tsm1.BlockBoolean},
 		{value: "string", blockType: tsm1.BlockString},
+		{value: sketch.NewDefault(), blockType: tsm1.BlockSketch},
 	}
 
 	for _, test := range tests {
@@ -25,21 +25,24 @@ import (
 	"github.com/influxdata/influxdb/v2/pkg/bytesutil"
 	"github.com/influxdata/influxdb/v2/pkg/estimator"
 	"github.com/influxdata/influxdb/v2/pkg/file"
-	"github.com/influxdata/influxdb/v2/pkg/limiter"
 	"github.com/influxdata/influxdb/v2/pkg/metrics"
 	"github.com/influxdata/influxdb/v2/pkg/radix"
 	intar "github.com/influxdata/influxdb/v2/pkg/tar"
 	"github.com/influxdata/influxdb/v2/pkg/tracing"
 	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxdb/v2/tsdb/cursors"
 	_ "github.com/influxdata/influxdb/v2/tsdb/index"
 	"github.com/influxdata/influxdb/v2/tsdb/index/tsi1"
+	"github.com/influxdata/influxdb/v2/tsdb/sketch"
 	"github.com/influxdata/influxql"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
 //go:generate -command tmpl go run github.com/benbjohnson/tmpl
-//go:generate tmpl -data=@iterator.gen.go.tmpldata iterator.gen.go.tmpl engine.gen.go.tmpl array_cursor.gen.go.tmpl array_cursor_iterator.gen.go.tmpl
+//go:generate tmpl -data=@iterator.gen.go.tmpldata iterator.gen.go.tmpl engine.gen.go.tmpl
+//go:generate tmpl -data=@array_cursor.gen.go.tmpldata array_cursor.gen.go.tmpl array_cursor_iterator.gen.go.tmpl
+//go:generate tmpl -data=@array_cursor_window.gen.go.tmpldata array_cursor_window.gen.go.tmpl
 // The file store generate uses a custom modified tmpl
 // to support adding templated data from the command line.
 // This can probably be worked into the upstream tmpl
@@ -49,6 +52,8 @@ import (
 //go:generate tmpl -data=@encoding.gen.go.tmpldata encoding.gen.go.tmpl
 //go:generate tmpl -data=@compact.gen.go.tmpldata compact.gen.go.tmpl
 //go:generate tmpl -data=@reader.gen.go.tmpldata reader.gen.go.tmpl
+//go:generate tmpl -data=@downsample.gen.go.tmpldata downsample.gen.go.tmpl
+//go:generate tmpl -data=@block_stats.gen.go.tmpldata block_stats.gen.go.tmpl
 
 func init() {
 	tsdb.RegisterEngine("tsm1", NewEngine)
@@ -80,6 +85,18 @@ const (
 	deleteFlushThreshold = 50 * 1024 * 1024
 )
 
+// seriesRetentionCheckInterval is how often values expired by the series retention
+// rules are deleted from the cache.
+const seriesRetentionCheckInterval = time.Minute
+
+// seriesRetentionFileCheckInterval is how often values expired by the series
+// retention rules are tombstoned in TSM files.
+const seriesRetentionFileCheckInterval = time.Hour
+
+// compactionCancelBackoff is how long the files of a canceled compaction are
+// kept out of compaction plans.
+const compactionCancelBackoff = 10 * time.Minute
+
 // Engine represents a storage engine with compressed blocks.
 type Engine struct {
 	mu sync.RWMutex
@@ -101,6 +118,12 @@ type Engine struct {
 	snapDone chan struct{}   // channel to signal snapshot compactions to stop
 	snapWG   *sync.WaitGroup // waitgroup for running snapshot compactions
 
+	// rewriteMu is held for writing while TSM files are replaced outside of
+	// compactions, by repairs and moves to the cold tier, and for reading by
+	// deletes.  Tombstones written to a file while it is copied would be lost
+	// when the copy replaces it.
+	rewriteMu sync.RWMutex
+
 	id           uint64
 	path         string
 	sfile        *tsdb.SeriesFile
@@ -108,7 +131,21 @@ type Engine struct {
 	traceLogger  *zap.Logger // Logger to be used when trace-logging is on.
 	traceLogging bool
 
-	fieldset *tsdb.MeasurementFieldSet
+	fieldset  *tsdb.MeasurementFieldSet
+	retention *tsdb.SeriesRetention
+
+	// walLoadReport describes the WAL entries lost when the cache was loaded.
+	walLoadReport *WALLoadReport
+
+	// queuedCompactions are the compactions planned but not started when
+	// compactions were last scheduled.
+	queuedMu          sync.RWMutex
+	queuedCompactions []tsdb.CompactionStatus
+
+	// canceledFiles are the input files of recently canceled compactions, with
+	// the time until which they are not compacted again.
+	canceledMu    sync.Mutex
+	canceledFiles map[string]time.Time
 
 	WAL            *WAL
 	Cache          *Cache
@@ -127,6 +164,14 @@ type Engine struct {
 	// a snapshot of the cache to a TSM file
 	CacheFlushWriteColdDuration time.Duration
 
+	// TSMColdAge specifies the age of the newest data in a fully compacted
+	// generation after which its files are moved to the cold tier.
+	TSMColdAge time.Duration
+
+	// lastColdCheck is the last time the cold tier was planned.  It is only
+	// accessed by the compaction goroutine.
+	lastColdCheck time.Time
+
 	// WALEnabled determines whether writes to the WAL are enabled.  If this is false,
 	// writes will only exist in the cache and can be lost if a snapshot has not occurred.
 	WALEnabled bool
@@ -137,18 +182,29 @@ type Engine struct {
 	// Controls whether to enabled compactions when the engine is open
 	enableCompactionsOnOpen bool
 
+	// readOnly is set if the engine may not create, rename or delete files.
+	readOnly bool
+
 	stats *compactionMetrics
 
 	activeCompactions *compactionCounter
 
-	// Limiter for concurrent compactions.
-	compactionLimiter limiter.Fixed
+	// Scheduler deciding when compactions may start, shared by all engines.
+	compactionScheduler tsdb.CompactionScheduler
+
+	// level1Queued is the number of level 1 compactions planned on the last
+	// scheduling of compactions.  Accessed atomically.
+	level1Queued int64
 
 	scheduler *scheduler
 
 	// provides access to the total set of series IDs
 	seriesIDSets tsdb.SeriesIDSets
 
+	// cacheMemoryGovernor limits the memory used by the caches of all engines
+	// in the store.  It is nil if there is no store-wide limit.
+	cacheMemoryGovernor *tsdb.CacheMemoryGovernor
+
 	// seriesTypeMap maps a series key to field type
 	seriesTypeMap *radix.Tree
 
@@ -170,6 +226,8 @@ func NewEngine(id uint64, idx tsdb.Index, path string, walPath string, sfile *ts
 	if opt.WALEnabled {
 		wal = NewWAL(walPath, opt.Config.WALMaxConcurrentWrites, opt.Config.WALMaxWriteDelay, etags)
 		wal.syncDelay = time.Duration(opt.Config.WALFsyncDelay)
+		wal.SetGroupCommit(int(opt.Config.WALGroupCommitBytes), time.Duration(opt.Config.WALGroupCommitLatency))
+		wal.SetCodec(tsdb.BlockCodecByName(opt.Config.WALCodec))
 	}
 
 	fs := NewFileStore(path, etags)
@@ -178,13 +236,21 @@ func NewEngine(id uint64, idx tsdb.Index, path string, walPath string, sfile *ts
 		fs.WithObserver(opt.FileStoreObserver)
 	}
 	fs.tsmMMAPWillNeed = opt.Config.TSMWillNeed
+	fs.WithBlockCache(opt.BlockCache)
+	fs.WithReadOnly(opt.ReadOnly)
+	if opt.Config.TSMColdDir != "" {
+		fs.WithColdDir(coldShardPath(opt.Config.TSMColdDir, path))
+	}
 
 	cache := NewCache(uint64(opt.Config.CacheMaxMemorySize), etags)
 
 	c := NewCompactor()
 	c.Dir = path
+	c.ColdDir = fs.ColdDir()
 	c.FileStore = fs
 	c.RateLimit = opt.CompactionThroughputLimiter
+	c.StringCodec = tsdb.BlockCodecByName(opt.Config.TSMStringCodec)
+	c.Retention = opt.SeriesRetention
 
 	var planner CompactionPlanner = NewDefaultPlanner(fs, time.Duration(opt.Config.CompactFullWriteColdDuration))
 	if opt.CompactionPlannerCreator != nil {
@@ -194,6 +260,10 @@ func NewEngine(id uint64, idx tsdb.Index, path string, walPath string, sfile *ts
 
 	stats := newEngineMetrics(etags)
 	activeCompactions := &compactionCounter{}
+	compactionScheduler := opt.CompactionScheduler
+	if compactionScheduler == nil {
+		compactionScheduler = tsdb.NewLimiterCompactionScheduler(opt.CompactionLimiter)
+	}
 	e := &Engine{
 		id:           id,
 		path:         path,
@@ -211,16 +281,20 @@ func NewEngine(id uint64, idx tsdb.Index, path string, walPath string, sfile *ts
 		CompactionPlan: planner,
 
 		activeCompactions: activeCompactions,
-		scheduler:         newScheduler(activeCompactions, opt.CompactionLimiter.Capacity()),
+		scheduler:         newScheduler(activeCompactions, compactionScheduler.Capacity()),
 
 		CacheFlushMemorySizeThreshold: uint64(opt.Config.CacheSnapshotMemorySize),
 		CacheFlushWriteColdDuration:   time.Duration(opt.Config.CacheSnapshotWriteColdDuration),
+		TSMColdAge:                    time.Duration(opt.Config.TSMColdAge),
 		enableCompactionsOnOpen:       true,
+		readOnly:                      opt.ReadOnly,
 		WALEnabled:                    opt.WALEnabled,
 		formatFileName:                DefaultFormatFileName,
 		stats:                         stats,
-		compactionLimiter:             opt.CompactionLimiter,
+		compactionScheduler:           compactionScheduler,
 		seriesIDSets:                  opt.SeriesIDSets,
+		cacheMemoryGovernor:           opt.CacheMemoryGovernor,
+		retention:                     opt.SeriesRetention,
 	}
 
 	// Feature flag to enable per-series type checking, by default this is off and
@@ -251,6 +325,10 @@ func (e *Engine) WithParseFileNameFunc(parseFileNameFunc ParseFileNameFunc) {
 
 // Digest returns a reader for the shard's digest.
 func (e *Engine) Digest() (io.ReadCloser, int64, error) {
+	if err := e.checkWritable("digest"); err != nil {
+		return nil, 0, err
+	}
+
 	e.muDigest.Lock()
 	defer e.muDigest.Unlock()
 
@@ -346,6 +424,10 @@ func (e *Engine) SetEnabled(enabled bool) {
 // SetCompactionsEnabled enables compactions on the engine.  When disabled
 // all running compactions are aborted and new compactions stop running.
 func (e *Engine) SetCompactionsEnabled(enabled bool) {
+	if enabled && e.readOnly {
+		return
+	}
+
 	if enabled {
 		e.enableSnapshotCompactions()
 		e.enableLevelCompactions(false)
@@ -511,6 +593,10 @@ func (e *Engine) disableSnapshotCompactions() {
 // This will cancel and running compactions and snapshot any data in the cache to
 // TSM files.  This is an expensive operation.
 func (e *Engine) ScheduleFullCompaction() error {
+	if err := e.checkWritable("schedule full compaction"); err != nil {
+		return err
+	}
+
 	// Snapshot any data in the cache
 	if err := e.WriteSnapshot(); err != nil {
 		return err
@@ -530,6 +616,14 @@ func (e *Engine) ScheduleFullCompaction() error {
 // Path returns the path the engine was opened with.
 func (e *Engine) Path() string { return e.path }
 
+// checkWritable returns a tsdb.ReadOnlyError for op if the engine is read-only.
+func (e *Engine) checkWritable(op string) error {
+	if e.readOnly {
+		return tsdb.ReadOnlyError{Op: op, Path: e.path}
+	}
+	return nil
+}
+
 func (e *Engine) MeasurementExists(name []byte) (bool, error) {
 	return e.index.MeasurementExists(name)
 }
@@ -603,6 +697,8 @@ func PrometheusCollectors() []prometheus.Collector {
 	collectors = append(collectors, FileStoreCollectors()...)
 	collectors = append(collectors, CacheCollectors()...)
 	collectors = append(collectors, WALCollectors()...)
+	collectors = append(collectors, tsdb.BlockCacheCollectors()...)
+	collectors = append(collectors, tsdb.SeriesIDSetCacheCollectors()...)
 	return collectors
 }
 
@@ -614,6 +710,7 @@ const (
 	level3           = "3"
 	levelOpt         = "opt"
 	levelFull        = "full"
+	levelDownsample  = "downsample"
 	levelKey         = "level"
 	levelCache       = "cache"
 )
@@ -663,11 +760,12 @@ func newAllCompactionMetrics(labelNames []string) *compactionMetrics {
 }
 
 type compactionCounter struct {
-	l1       int64
-	l2       int64
-	l3       int64
-	full     int64
-	optimize int64
+	l1         int64
+	l2         int64
+	l3         int64
+	full       int64
+	optimize   int64
+	downsample int64
 }
 
 func (c *compactionCounter) countForLevel(l int) *int64 {
@@ -711,15 +809,26 @@ func (e *Engine) DiskSize() int64 {
 
 // Open opens and initializes the engine.
 func (e *Engine) Open(ctx context.Context) error {
-	if err := os.MkdirAll(e.path, 0777); err != nil {
-		return err
-	}
+	if e.readOnly {
+		// Temporary files are left as they are, so the engine must exist.
+		if _, err := os.Stat(e.path); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(e.path, 0777); err != nil {
+			return err
+		}
 
-	if err := e.cleanup(); err != nil {
-		return err
+		if err := e.cleanup(); err != nil {
+			return err
+		}
 	}
 
-	fields, err := tsdb.NewMeasurementFieldSet(filepath.Join(e.path, "fields.idx"), e.logger)
+	newFieldSet := tsdb.NewMeasurementFieldSet
+	if e.readOnly {
+		newFieldSet = tsdb.NewReadOnlyMeasurementFieldSet
+	}
+	fields, err := newFieldSet(filepath.Join(e.path, "fields.idx"), e.logger)
 	if err != nil {
 		e.logger.Warn(fmt.Sprintf("error opening fields.idx: %v.  Rebuilding.", err))
 	}
@@ -730,7 +839,9 @@ func (e *Engine) Open(ctx context.Context) error {
 
 	e.index.SetFieldSet(fields)
 
-	if e.WALEnabled {
+	// A read-only engine loads the cache from the WAL segments without
+	// opening the WAL for writes.
+	if e.WALEnabled && !e.readOnly {
 		if err := e.WAL.Open(); err != nil {
 			return err
 		}
@@ -746,6 +857,12 @@ func (e *Engine) Open(ctx context.Context) error {
 		}
 	}
 
+	// The cache is only charged against the store-wide limit once the WAL has
+	// been loaded, since loading ignores the limits.
+	if e.cacheMemoryGovernor != nil {
+		e.Cache.SetMemoryGovernor(e.cacheMemoryGovernor)
+	}
+
 	e.Compactor.Open()
 
 	if e.enableCompactionsOnOpen {
@@ -764,6 +881,8 @@ func (e *Engine) Close() error {
 	defer e.mu.Unlock()
 	e.done = nil // Ensures that the channel will not be closed again.
 
+	e.Cache.SetMemoryGovernor(nil)
+
 	var err error = nil
 	err = e.fieldset.Close()
 	if err2 := e.FileStore.Close(); err2 != nil && err == nil {
@@ -871,8 +990,10 @@ func (e *Engine) LoadMetadataIndex(shardID uint64, index tsdb.Index) error {
 	}
 
 	// Save the field set index so we don't have to rebuild it next time
-	if err := e.fieldset.WriteToFile(); err != nil {
-		return err
+	if !e.readOnly {
+		if err := e.fieldset.WriteToFile(); err != nil {
+			return err
+		}
 	}
 
 	e.traceLogger.Info("Meta data index for shard loaded", zap.Uint64("id", shardID), zap.Duration("duration", time.Since(now)))
@@ -1026,7 +1147,11 @@ func (e *Engine) filterFileToBackup(r *TSMReader, fi os.FileInfo, shardRelativeP
 		if minTime >= start && minTime <= end ||
 			maxTime >= start && maxTime <= end ||
 			minTime <= start && maxTime >= end {
-			err := w.WriteBlock(key, minTime, maxTime, buf)
+			var stats *BlockStats
+			if s, ok := bi.blockStats(); ok {
+				stats = &s
+			}
+			err := writeBlock(w, key, minTime, maxTime, buf, stats)
 			if err != nil {
 				return err
 			}
@@ -1059,6 +1184,10 @@ func (e *Engine) filterFileToBackup(r *TSMReader, fi os.FileInfo, shardRelativeP
 // Only files that match basePath will be copied into the directory. This obtains
 // a write lock so no operations can be performed while restoring.
 func (e *Engine) Restore(r io.Reader, basePath string) error {
+	if err := e.checkWritable("restore"); err != nil {
+		return err
+	}
+
 	return e.overlay(r, basePath, false)
 }
 
@@ -1067,6 +1196,10 @@ func (e *Engine) Restore(r io.Reader, basePath string) error {
 // a write lock so no operations can be performed while Importing.
 // If the import is successful, a full compaction is scheduled.
 func (e *Engine) Import(r io.Reader, basePath string) error {
+	if err := e.checkWritable("import"); err != nil {
+		return err
+	}
+
 	if err := e.overlay(r, basePath, true); err != nil {
 		return err
 	}
@@ -1248,12 +1381,20 @@ func (e *Engine) addToIndexFromKey(keys [][]byte, fieldTypes []influxql.DataType
 		tags = append(tags, models.ParseTags(keys[i]))
 	}
 
+	// A read-only index already holds the series, and may not add them.
+	if e.readOnly {
+		return nil
+	}
 	return e.index.CreateSeriesListIfNotExists(keys, names, tags)
 }
 
 // WritePoints writes metadata and point data into the engine.
 // It returns an error if new points are added to an existing key.
 func (e *Engine) WritePoints(ctx context.Context, points []models.Point) error {
+	if err := e.checkWritable("write points"); err != nil {
+		return err
+	}
+
 	values := make(map[string][]Value, len(points))
 	var (
 		keyBuf    []byte
@@ -1326,7 +1467,17 @@ func (e *Engine) WritePoints(ctx context.Context, points []models.Point) error {
 				}
 				v = NewUnsignedValue(t, iv)
 			case models.String:
-				v = NewStringValue(t, iter.StringValue())
+				// Sketches are written to sketch fields as strings holding
+				// their text encoding.
+				if e.isSketchField(p.Name(), iter.FieldKey()) {
+					sk, err := sketch.Parse(iter.StringValue())
+					if err != nil {
+						return fmt.Errorf("invalid sketch for %s: %w", string(iter.FieldKey()), err)
+					}
+					v = NewSketchValue(t, sk)
+				} else {
+					v = NewStringValue(t, iter.StringValue())
+				}
 			case models.Boolean:
 				bv, err := iter.BooleanValue()
 				if err != nil {
@@ -1356,6 +1507,17 @@ func (e *Engine) WritePoints(ctx context.Context, points []models.Point) error {
 	return seriesErr
 }
 
+// isSketchField returns true if the field of measurement name with the given
+// key is a sketch field.
+func (e *Engine) isSketchField(name, key []byte) bool {
+	mf := e.fieldset.Fields(name)
+	if mf == nil {
+		return false
+	}
+	f := mf.FieldBytes(key)
+	return f != nil && f.Type == cursors.SketchDataType
+}
+
 // DeleteSeriesRange removes the values between min and max (inclusive) from all series
 func (e *Engine) DeleteSeriesRange(ctx context.Context, itr tsdb.SeriesIterator, min, max int64) error {
 	return e.DeleteSeriesRangeWithPredicate(ctx, itr, func(name []byte, tags models.Tags) (int64, int64, bool) {
@@ -1370,6 +1532,10 @@ func (e *Engine) DeleteSeriesRangeWithPredicate(
 	itr tsdb.SeriesIterator,
 	predicate func(name []byte, tags models.Tags) (int64, int64, bool),
 ) error {
+	if err := e.checkWritable("delete series"); err != nil {
+		return err
+	}
+
 	var disableOnce bool
 
 	// Ensure that the index does not compact away the measurement or series we're
@@ -1486,6 +1652,10 @@ func (e *Engine) deleteSeriesRange(ctx context.Context, seriesKeys [][]byte, min
 		return nil
 	}
 
+	// Files must not be replaced by copies while tombstones are written to them.
+	e.rewriteMu.RLock()
+	defer e.rewriteMu.RUnlock()
+
 	// Min and max time in the engine are slightly different from the query language values.
 	if min == influxql.MinTime {
 		min = math.MinInt64
@@ -1518,6 +1688,14 @@ func (e *Engine) deleteSeriesRange(ctx context.Context, seriesKeys [][]byte, min
 		bytesutil.Sort(seriesKeys)
 	}
 
+	// Keys deleted from TSM files are only needed in the WAL by subscribers,
+	// since replaying the WAL only applies deletes to the cache.
+	var (
+		streamKeys   [][]byte
+		streamKeysMu sync.Mutex
+		stream       = e.WALEnabled && e.WAL.hasSubscribers()
+	)
+
 	// Run the delete on each TSM file in parallel
 	if err := e.FileStore.Apply(ctx, func(r TSMFile) error {
 		// See if this TSM file contains the keys and time range
@@ -1552,6 +1730,11 @@ func (e *Engine) deleteSeriesRange(ctx context.Context, seriesKeys [][]byte, min
 					batch.Rollback()
 					return err
 				}
+				if stream {
+					streamKeysMu.Lock()
+					streamKeys = append(streamKeys, bytes.Clone(indexKey))
+					streamKeysMu.Unlock()
+				}
 			}
 		}
 
@@ -1584,11 +1767,28 @@ func (e *Engine) deleteSeriesRange(ctx context.Context, seriesKeys [][]byte, min
 
 	// delete from the WAL
 	if e.WALEnabled {
-		if _, err := e.WAL.DeleteRange(ctx, deleteKeys, min, max); err != nil {
+		walKeys := deleteKeys
+		if len(streamKeys) > 0 {
+			walKeys = append(append(make([][]byte, 0, len(deleteKeys)+len(streamKeys)), deleteKeys...), streamKeys...)
+			walKeys = bytesutil.SortDedup(walKeys)
+		}
+		if _, err := e.WAL.DeleteRange(ctx, walKeys, min, max); err != nil {
 			return err
 		}
 	}
 
+	return e.dropSeriesWithoutValues(ctx, seriesKeys, deleteKeys)
+}
+
+// dropSeriesWithoutValues removes the series in seriesKeys that have no values
+// left in the cache or TSM files from the index, and from the series file if no
+// other shard has them.  seriesKeys must be sorted and are modified.  deleteKeys
+// are the sorted cache keys whose values were deleted.
+func (e *Engine) dropSeriesWithoutValues(ctx context.Context, seriesKeys, deleteKeys [][]byte) error {
+	if len(seriesKeys) == 0 {
+		return nil
+	}
+
 	// The series are deleted on disk, but the index may still say they exist.
 	// Depending on the min,max time passed in, the series may or not actually
 	// exists now.  To reconcile the index, we walk the series keys that still exists
@@ -1788,6 +1988,10 @@ func (e *Engine) cleanupMeasurement(name []byte) (deleted bool, err error) {
 
 // DeleteMeasurement deletes a measurement and all related series.
 func (e *Engine) DeleteMeasurement(ctx context.Context, name []byte) error {
+	if err := e.checkWritable("delete measurement"); err != nil {
+		return err
+	}
+
 	// Attempt to find the series keys.
 	indexSet := tsdb.IndexSet{Indexes: []tsdb.Index{e.index}, SeriesFile: e.sfile}
 	itr, err := indexSet.MeasurementSeriesByExprIterator(name, nil)
@@ -1806,10 +2010,18 @@ func (e *Engine) ForEachMeasurementName(fn func(name []byte) error) error {
 }
 
 func (e *Engine) CreateSeriesListIfNotExists(keys, names [][]byte, tagsSlice []models.Tags) error {
+	if err := e.checkWritable("create series"); err != nil {
+		return err
+	}
+
 	return e.index.CreateSeriesListIfNotExists(keys, names, tagsSlice)
 }
 
 func (e *Engine) CreateSeriesIfNotExists(key, name []byte, tags models.Tags) error {
+	if err := e.checkWritable("create series"); err != nil {
+		return err
+	}
+
 	return e.index.CreateSeriesIfNotExists(key, name, tags)
 }
 
@@ -1818,6 +2030,10 @@ func (e *Engine) WriteTo(w io.Writer) (n int64, err error) { panic("not implemen
 
 // WriteSnapshot will snapshot the cache and write a new TSM file with its contents, releasing the snapshot when done.
 func (e *Engine) WriteSnapshot() (err error) {
+	if err := e.checkWritable("write snapshot"); err != nil {
+		return err
+	}
+
 	// Lock and grab the cache snapshot along with all the closed WAL
 	// filenames associated with the snapshot
 
@@ -1884,6 +2100,10 @@ func (e *Engine) WriteSnapshot() (err error) {
 // skipCacheOk controls whether it is permissible to fail writing out
 // in-memory cache data when a previous snapshot is in progress.
 func (e *Engine) CreateSnapshot(skipCacheOk bool) (string, error) {
+	if err := e.checkWritable("create snapshot"); err != nil {
+		return "", err
+	}
+
 	err := e.WriteSnapshot()
 	for i := 0; i < 3 && err == ErrSnapshotInProgress; i += 1 {
 		backoff := time.Duration(math.Pow(32, float64(i))) * time.Millisecond
@@ -1954,6 +2174,10 @@ func (e *Engine) writeSnapshotAndCommit(log *zap.Logger, closedFiles []string, s
 func (e *Engine) compactCache() {
 	t := time.NewTicker(time.Second)
 	defer t.Stop()
+	expire := time.NewTicker(seriesRetentionCheckInterval)
+	defer expire.Stop()
+	expireFiles := time.NewTicker(seriesRetentionFileCheckInterval)
+	defer expireFiles.Stop()
 	for {
 		e.mu.RLock()
 		quit := e.snapDone
@@ -1971,12 +2195,172 @@ func (e *Engine) compactCache() {
 					e.logger.Info("Error writing snapshot", zap.Error(err))
 				}
 			}
+
+		case <-expire.C:
+			if err := e.expireCache(context.Background(), time.Now()); err != nil {
+				e.logger.Info("Error expiring cache values", zap.Error(err))
+			}
+
+		case <-expireFiles.C:
+			if err := e.expireFiles(context.Background(), time.Now()); err != nil {
+				e.logger.Info("Error expiring TSM file values", zap.Error(err))
+			}
 		}
 	}
 }
 
-// ShouldCompactCache returns true if the Cache is over its flush threshold
-// or if the passed in lastWriteTime is older than the write cold threshold.
+// expireCache deletes the values in the cache expired at now by the series
+// retention rules, and records the deletes in the WAL.  Series left without
+// values are removed from the index.
+func (e *Engine) expireCache(ctx context.Context, now time.Time) error {
+	if e.retention.Len() == 0 {
+		return nil
+	}
+
+	// Group the keys with expired values by cutoff so each group is deleted at once.
+	ts := now.UnixNano()
+	expired := make(map[int64][][]byte)
+	_ = e.Cache.ApplyEntryFn(func(k []byte, entry *entry) error {
+		series, _ := SeriesAndFieldFromCompositeKey(k)
+		cutoff := e.retention.Cutoff(series, ts)
+		if cutoff != math.MinInt64 && entry.minTime() < cutoff {
+			expired[cutoff] = append(expired[cutoff], k)
+		}
+		return nil
+	})
+
+	var deleteKeys, seriesKeys [][]byte
+	for cutoff, keys := range expired {
+		bytesutil.Sort(keys)
+		e.Cache.DeleteRange(keys, math.MinInt64, cutoff-1)
+
+		if e.WALEnabled {
+			if _, err := e.WAL.DeleteRange(ctx, keys, math.MinInt64, cutoff-1); err != nil {
+				return err
+			}
+		}
+
+		for _, k := range keys {
+			if e.Cache.Values(k).Len() == 0 {
+				series, _ := SeriesAndFieldFromCompositeKey(k)
+				seriesKeys = append(seriesKeys, series)
+			}
+		}
+		deleteKeys = append(deleteKeys, keys...)
+	}
+	return e.dropExpiredSeries(ctx, seriesKeys, deleteKeys)
+}
+
+// expireFiles tombstones the values in TSM files expired at now by the series
+// retention rules.  Compactions drop expired values, but a fully compacted file
+// is not compacted again unless it has tombstones, so the tombstones ensure the
+// values are dropped.  Files that already have tombstones are skipped, since
+// they are planned for compaction.  Series left without values are removed from
+// the index.
+func (e *Engine) expireFiles(ctx context.Context, now time.Time) error {
+	ts := now.UnixNano()
+	maxCutoff := e.retention.MaxCutoff(ts)
+	if maxCutoff == math.MinInt64 {
+		return nil
+	}
+
+	var (
+		seriesKeys   [][]byte
+		seriesKeysMu sync.Mutex
+	)
+	if err := func() error {
+		e.rewriteMu.RLock()
+		defer e.rewriteMu.RUnlock()
+		return e.FileStore.Apply(ctx, func(r TSMFile) error {
+			if minTime, _ := r.TimeRange(); minTime >= maxCutoff || r.HasTombstones() {
+				return nil
+			}
+
+			var (
+				series  []byte
+				cutoff  int64
+				entries []IndexEntry
+				deleted bool
+				dropped [][]byte
+			)
+			batch := r.BatchDelete()
+			for i, n := 0, r.KeyCount(); i < n; i++ {
+				key, _ := r.KeyAt(i)
+				seriesKey, _ := SeriesAndFieldFromCompositeKey(key)
+				if series == nil || !bytes.Equal(seriesKey, series) {
+					series = append(series[:0], seriesKey...)
+					cutoff = e.retention.Cutoff(series, ts)
+				}
+				if cutoff == math.MinInt64 {
+					continue
+				}
+
+				entries = r.ReadEntries(key, &entries)
+				if len(entries) == 0 || entries[0].MinTime >= cutoff {
+					continue
+				}
+				if err := batch.DeleteRange([][]byte{key}, math.MinInt64, cutoff-1); err != nil {
+					batch.Rollback()
+					return err
+				}
+				deleted = true
+
+				// The key is removed from the file if all its values expired.
+				if entries[len(entries)-1].MaxTime < cutoff {
+					dropped = append(dropped, bytes.Clone(seriesKey))
+				}
+			}
+
+			if !deleted {
+				return batch.Rollback()
+			} else if err := batch.Commit(); err != nil {
+				return err
+			}
+
+			seriesKeysMu.Lock()
+			seriesKeys = append(seriesKeys, dropped...)
+			seriesKeysMu.Unlock()
+			return nil
+		})
+	}(); err != nil {
+		return err
+	}
+	return e.dropExpiredSeries(ctx, seriesKeys, nil)
+}
+
+// dropExpiredSeries removes the series in seriesKeys whose values all expired
+// from the index.  deleteKeys are the sorted cache keys whose values expired.
+func (e *Engine) dropExpiredSeries(ctx context.Context, seriesKeys, deleteKeys [][]byte) error {
+	if len(seriesKeys) == 0 {
+		return nil
+	}
+	seriesKeys = bytesutil.SortDedup(seriesKeys)
+	bytesutil.Sort(deleteKeys)
+
+	// Keep the indexes from compacting away the series while they are dropped.
+	if tsiIndex, ok := e.index.(*tsi1.Index); ok {
+		tsiIndex.DisableCompactions()
+		defer tsiIndex.EnableCompactions()
+		tsiIndex.Wait()
+
+		fs, err := tsiIndex.RetainFileSet()
+		if err != nil {
+			return err
+		}
+		defer fs.Release()
+	}
+	e.sfile.DisableCompactions()
+	defer e.sfile.EnableCompactions()
+	e.sfile.Wait()
+
+	e.rewriteMu.RLock()
+	defer e.rewriteMu.RUnlock()
+	return e.dropSeriesWithoutValues(ctx, seriesKeys, deleteKeys)
+}
+
+// ShouldCompactCache returns true if the Cache is over its flush threshold,
+// if a snapshot was requested to free memory for other caches or if the passed
+// in lastWriteTime is older than the write cold threshold.
 func (e *Engine) ShouldCompactCache(t time.Time) bool {
 	sz := e.Cache.Size()
 
@@ -1984,7 +2368,7 @@ func (e *Engine) ShouldCompactCache(t time.Time) bool {
 		return false
 	}
 
-	if sz > e.CacheFlushMemorySizeThreshold {
+	if sz > e.CacheFlushMemorySizeThreshold || e.Cache.SnapshotRequested() {
 		return true
 	}
 
@@ -1994,6 +2378,7 @@ func (e *Engine) ShouldCompactCache(t time.Time) bool {
 func (e *Engine) compact(wg *sync.WaitGroup) {
 	t := time.NewTicker(time.Second)
 	defer t.Stop()
+	defer e.setQueuedCompactions(nil)
 
 	for {
 		e.mu.RLock()
@@ -2020,6 +2405,21 @@ func (e *Engine) compact(wg *sync.WaitGroup) {
 				e.stats.Queued.With(prometheus.Labels{levelKey: levelOpt}).Set(float64(len4))
 			}
 
+			// If no optimize is needed either, see if the shard can be downsampled
+			var downsample bool
+			if planner, ok := e.CompactionPlan.(DownsampleCompactionPlanner); ok && len(level4Groups) == 0 {
+				level4Groups, len4 = planner.PlanDownsample()
+				downsample = len(level4Groups) > 0
+				e.stats.Queued.With(prometheus.Labels{levelKey: levelDownsample}).Set(float64(len4))
+			}
+
+			// Skip the groups of recently canceled compactions
+			now := time.Now()
+			level1Groups = e.skipCanceledCompactions(level1Groups, now)
+			level2Groups = e.skipCanceledCompactions(level2Groups, now)
+			level3Groups = e.skipCanceledCompactions(level3Groups, now)
+			level4Groups = e.skipCanceledCompactions(level4Groups, now)
+
 			// Update the level plan queue stats
 			// For stats, use the length needed, even if the lock was
 			// not acquired
@@ -2034,6 +2434,7 @@ func (e *Engine) compact(wg *sync.WaitGroup) {
 			e.scheduler.setDepth(2, len(level2Groups))
 			e.scheduler.setDepth(3, len(level3Groups))
 			e.scheduler.setDepth(4, len(level4Groups))
+			atomic.StoreInt64(&e.level1Queued, int64(len1))
 
 			// Find the next compaction that can run and try to kick it off
 			if level, runnable := e.scheduler.next(); runnable {
@@ -2051,12 +2452,30 @@ func (e *Engine) compact(wg *sync.WaitGroup) {
 						level3Groups = level3Groups[1:]
 					}
 				case 4:
-					if e.compactFull(level4Groups[0], wg) {
+					if downsample {
+						if e.compactDownsample(level4Groups[0], wg) {
+							level4Groups = level4Groups[1:]
+						}
+					} else if e.compactFull(level4Groups[0], wg) {
 						level4Groups = level4Groups[1:]
 					}
 				}
 			}
 
+			// Move old, fully compacted generations to the cold tier.
+			e.moveToColdTier(wg)
+
+			level4 := levelFull
+			if downsample {
+				level4 = levelDownsample
+			}
+			e.setQueuedCompactions(map[string][]CompactionGroup{
+				level1: level1Groups,
+				level2: level2Groups,
+				level3: level3Groups,
+				level4: level4Groups,
+			})
+
 			// Release all the plans we didn't start.
 			e.CompactionPlan.Release(level1Groups)
 			e.CompactionPlan.Release(level2Groups)
@@ -2066,6 +2485,107 @@ func (e *Engine) compact(wg *sync.WaitGroup) {
 	}
 }
 
+// setQueuedCompactions records the compaction groups planned by level but not started.
+func (e *Engine) setQueuedCompactions(groups map[string][]CompactionGroup) {
+	var queued []tsdb.CompactionStatus
+	for _, level := range []string{level1, level2, level3, levelFull, levelDownsample} {
+		for _, group := range groups[level] {
+			queued = append(queued, tsdb.CompactionStatus{
+				Level: level,
+				Files: append([]string(nil), group...),
+			})
+		}
+	}
+
+	e.queuedMu.Lock()
+	e.queuedCompactions = queued
+	e.queuedMu.Unlock()
+}
+
+// Compactions returns the status of the running compactions of the engine,
+// followed by the compactions planned but not started when compactions were
+// last scheduled.
+func (e *Engine) Compactions() []tsdb.CompactionStatus {
+	statuses := e.Compactor.Compactions()
+
+	e.queuedMu.RLock()
+	statuses = append(statuses, e.queuedCompactions...)
+	e.queuedMu.RUnlock()
+	return statuses
+}
+
+// CancelCompaction aborts the running compaction with the given ID.  The input
+// files of the compaction are left unchanged, and are not compacted again for
+// compactionCancelBackoff.  It returns false if there is no such compaction.
+func (e *Engine) CancelCompaction(id uint64) bool {
+	return e.Compactor.CancelCompaction(id)
+}
+
+// backOffCompaction keeps the files of a canceled compaction group out of the
+// compaction plans for compactionCancelBackoff.
+func (e *Engine) backOffCompaction(group CompactionGroup) {
+	until := time.Now().Add(compactionCancelBackoff)
+
+	e.canceledMu.Lock()
+	defer e.canceledMu.Unlock()
+	if e.canceledFiles == nil {
+		e.canceledFiles = make(map[string]time.Time)
+	}
+	for _, f := range group {
+		e.canceledFiles[f] = until
+	}
+}
+
+// skipCanceledCompactions removes the groups with files of compactions canceled
+// within compactionCancelBackoff of now from groups, and releases them.
+func (e *Engine) skipCanceledCompactions(groups []CompactionGroup, now time.Time) []CompactionGroup {
+	e.canceledMu.Lock()
+	defer e.canceledMu.Unlock()
+
+	for f, until := range e.canceledFiles {
+		if !now.Before(until) {
+			delete(e.canceledFiles, f)
+		}
+	}
+	if len(e.canceledFiles) == 0 {
+		return groups
+	}
+
+	var skipped []CompactionGroup
+	kept := groups[:0]
+	for _, group := range groups {
+		if e.hasCanceledFile(group) {
+			skipped = append(skipped, group)
+		} else {
+			kept = append(kept, group)
+		}
+	}
+	e.CompactionPlan.Release(skipped)
+	return kept
+}
+
+// hasCanceledFile returns true if any file of group was in a canceled
+// compaction.  e.canceledMu must be held.
+func (e *Engine) hasCanceledFile(group CompactionGroup) bool {
+	for _, f := range group {
+		if _, ok := e.canceledFiles[f]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compactionRequest returns the request to start a compaction of the given level.
+func (e *Engine) compactionRequest(level int) tsdb.CompactionRequest {
+	return tsdb.CompactionRequest{
+		ShardID:      e.id,
+		Level:        level,
+		LastWrite:    e.Cache.LastWriteTime(),
+		Level1Queued: int(atomic.LoadInt64(&e.level1Queued)),
+		Size:         e.FileStore.DiskSizeBytes(),
+	}
+}
+
 // compactLevel kicks off compactions using the level strategy. It returns
 // true if the compaction was started
 func (e *Engine) compactLevel(grp CompactionGroup, level int, fast bool, wg *sync.WaitGroup) bool {
@@ -2074,7 +2594,8 @@ func (e *Engine) compactLevel(grp CompactionGroup, level int, fast bool, wg *syn
 		return false
 	}
 
-	if e.compactionLimiter.TryTake() {
+	req := e.compactionRequest(level)
+	if e.compactionScheduler.TryAcquire(req) {
 		{
 			val := atomic.AddInt64(e.activeCompactions.countForLevel(level), 1)
 			e.stats.Active.With(labelForLevel(level)).Set(float64(val))
@@ -2088,7 +2609,7 @@ func (e *Engine) compactLevel(grp CompactionGroup, level int, fast bool, wg *syn
 				e.stats.Active.With(labelForLevel(level)).Set(float64(val))
 			}()
 
-			defer e.compactionLimiter.Release()
+			defer e.compactionScheduler.Release(req)
 			s.Apply()
 			// Release the files in the compaction plan
 			e.CompactionPlan.Release([]CompactionGroup{s.group})
@@ -2109,7 +2630,8 @@ func (e *Engine) compactFull(grp CompactionGroup, wg *sync.WaitGroup) bool {
 	}
 
 	// Try the lo priority limiter, otherwise steal a little from the high priority if we can.
-	if e.compactionLimiter.TryTake() {
+	req := e.compactionRequest(4)
+	if e.compactionScheduler.TryAcquire(req) {
 		{
 			val := atomic.AddInt64(&e.activeCompactions.full, 1)
 			e.stats.Active.With(prometheus.Labels{levelKey: levelFull}).Set(float64(val))
@@ -2121,7 +2643,38 @@ func (e *Engine) compactFull(grp CompactionGroup, wg *sync.WaitGroup) bool {
 				val := atomic.AddInt64(&e.activeCompactions.full, -1)
 				e.stats.Active.With(prometheus.Labels{levelKey: levelFull}).Set(float64(val))
 			}()
-			defer e.compactionLimiter.Release()
+			defer e.compactionScheduler.Release(req)
+			s.Apply()
+			// Release the files in the compaction plan
+			e.CompactionPlan.Release([]CompactionGroup{s.group})
+		}()
+		return true
+	}
+	return false
+}
+
+// compactDownsample kicks off a downsampling compaction using the lo priority
+// policy. It returns true if the compaction was started.
+func (e *Engine) compactDownsample(grp CompactionGroup, wg *sync.WaitGroup) bool {
+	s := e.downsampleCompactionStrategy(grp)
+	if s == nil {
+		return false
+	}
+
+	req := e.compactionRequest(4)
+	if e.compactionScheduler.TryAcquire(req) {
+		{
+			val := atomic.AddInt64(&e.activeCompactions.downsample, 1)
+			e.stats.Active.With(prometheus.Labels{levelKey: levelDownsample}).Set(float64(val))
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				val := atomic.AddInt64(&e.activeCompactions.downsample, -1)
+				e.stats.Active.With(prometheus.Labels{levelKey: levelDownsample}).Set(float64(val))
+			}()
+			defer e.compactionScheduler.Release(req)
 			s.Apply()
 			// Release the files in the compaction plan
 			e.CompactionPlan.Release([]CompactionGroup{s.group})
@@ -2138,6 +2691,12 @@ type compactionStrategy struct {
 	fast  bool
 	level int
 
+	// name is the level label of the compaction.
+	name string
+
+	// downsample is set if the group should be downsampled by planner.
+	downsample DownsampleCompactionPlanner
+
 	durationSecondsStat prometheus.Observer
 	errorStat           prometheus.Counter
 
@@ -2167,20 +2726,29 @@ func (s *compactionStrategy) compactGroup() {
 	}
 
 	var (
-		err   error
-		files []string
+		err    error
+		files  []string
+		fields tsdb.FieldChanges
 	)
-	if s.fast {
-		files, err = s.compactor.CompactFast(group, log)
+	p := newCompactionProgress(s.name, group)
+	if s.downsample != nil {
+		var aggregates DownsampleFields
+		if aggregates, err = s.downsample.AggregateFields(); err == nil {
+			files, fields, err = s.compactor.compactDownsample(group, s.downsample.DownsampleConfig(), aggregates, p, log)
+		}
 	} else {
-		files, err = s.compactor.CompactFull(group, log)
+		files, err = s.compactor.compactFiles(s.fast, group, p, log)
 	}
 
 	if err != nil {
 		_, inProgress := err.(errCompactionInProgress)
-		if err == errCompactionsDisabled || inProgress {
+		if err == errCompactionsDisabled || inProgress || p.Canceled() {
 			log.Info("Aborted compaction", zap.Error(err))
 
+			if p.Canceled() {
+				s.engine.backOffCompaction(group)
+			}
+
 			if _, ok := err.(errCompactionInProgress); ok {
 				time.Sleep(time.Second)
 			}
@@ -2205,6 +2773,25 @@ func (s *compactionStrategy) compactGroup() {
 		return
 	}
 
+	// Fields created by downsampling must exist, and be recorded as
+	// aggregates, before their data is visible.
+	if len(fields) > 0 {
+		err := s.engine.createFields(fields)
+		if err == nil {
+			err = s.downsample.AddAggregateFields(fields)
+		}
+		if err != nil {
+			log.Info("Error creating downsampled fields", zap.Error(err))
+			s.errorStat.Inc()
+			time.Sleep(time.Second)
+
+			if err := s.compactor.removeTmpFiles(files); err != nil {
+				log.Error("Unable to remove files", zap.Error(err))
+			}
+			return
+		}
+	}
+
 	if err := s.fileStore.ReplaceWithCallback(group, files, nil); err != nil {
 		log.Info("Error replacing new TSM files", zap.Error(err))
 		s.errorStat.Inc()
@@ -2219,6 +2806,12 @@ func (s *compactionStrategy) compactGroup() {
 		return
 	}
 
+	if s.downsample != nil {
+		if err := s.downsample.Downsampled(group); err != nil {
+			log.Warn("Error recording downsampled files", zap.Error(err))
+		}
+	}
+
 	for i, f := range files {
 		log.Info("Compacted file", zap.Int("tsm1_index", i), zap.String("tsm1_file", f))
 	}
@@ -2238,6 +2831,7 @@ func (e *Engine) levelCompactionStrategy(group CompactionGroup, fast bool, level
 		fast:      fast,
 		engine:    e,
 		level:     level,
+		name:      label[levelKey],
 
 		errorStat:           e.stats.Failed.With(label),
 		durationSecondsStat: e.stats.Duration.With(label),
@@ -2255,17 +2849,55 @@ func (e *Engine) fullCompactionStrategy(group CompactionGroup, optimize bool) *c
 		fast:      optimize,
 		engine:    e,
 		level:     4,
+		name:      levelFull,
 	}
 
 	plabel := prometheus.Labels{levelKey: levelFull}
 	if optimize {
 		plabel = prometheus.Labels{levelKey: levelOpt}
+		s.name = levelOpt
 	}
 	s.errorStat = e.stats.Failed.With(plabel)
 	s.durationSecondsStat = e.stats.Duration.With(plabel)
 	return s
 }
 
+// downsampleCompactionStrategy returns a compactionStrategy that downsamples
+// group.  It returns nil if the planner does not support downsampling.
+func (e *Engine) downsampleCompactionStrategy(group CompactionGroup) *compactionStrategy {
+	planner, ok := e.CompactionPlan.(DownsampleCompactionPlanner)
+	if !ok {
+		return nil
+	}
+
+	plabel := prometheus.Labels{levelKey: levelDownsample}
+	return &compactionStrategy{
+		group:      group,
+		logger:     e.logger.With(zap.String("tsm1_strategy", "downsample")),
+		fileStore:  e.FileStore,
+		compactor:  e.Compactor,
+		downsample: planner,
+		engine:     e,
+		level:      4,
+		name:       levelDownsample,
+
+		errorStat:           e.stats.Failed.With(plabel),
+		durationSecondsStat: e.stats.Duration.With(plabel),
+	}
+}
+
+// createFields adds the fields in changes to the measurement field set and
+// persists them.
+func (e *Engine) createFields(changes tsdb.FieldChanges) error {
+	for _, fc := range changes {
+		mf := e.fieldset.CreateFieldsIfNotExists(fc.Measurement)
+		if err := mf.CreateFieldIfNotExists([]byte(fc.Field.Name), fc.Field.Type); err != nil {
+			return err
+		}
+	}
+	return e.fieldset.Save(changes)
+}
+
 // reloadCache reads the WAL segment files and loads them into the cache.
 func (e *Engine) reloadCache() error {
 	now := time.Now()
@@ -2284,15 +2916,39 @@ func (e *Engine) reloadCache() error {
 
 	loader := NewCacheLoader(files)
 	loader.WithLogger(e.logger)
-	if err := loader.Load(e.Cache); err != nil {
+	loader.ReadOnly = e.readOnly
+	report, err := loader.Load(e.Cache)
+	if err != nil {
 		return err
 	}
+	e.walLoadReport = report
+
+	msg := "Truncated damaged WAL segment"
+	if e.readOnly {
+		msg = "Skipped damaged end of WAL segment"
+	}
+	for _, d := range report.Segments {
+		e.logger.Warn(msg,
+			zap.String("path", d.Path),
+			zap.Int("entries_loaded", d.Entries),
+			zap.Int64("offset", d.Offset),
+			zap.Int64("lost_bytes", d.LostBytes),
+			zap.Bool("torn_write", d.Torn),
+			zap.Error(d.Err))
+	}
 
 	e.traceLogger.Info("Reloaded WAL cache",
 		zap.String("path", e.WAL.Path()), zap.Duration("duration", time.Since(now)))
 	return nil
 }
 
+// WALLoadReport returns the report of the WAL entries that could not be loaded
+// into the cache when the engine was opened.  It is nil if the WAL is disabled
+// or the engine has not been opened.
+func (e *Engine) WALLoadReport() *WALLoadReport {
+	return e.walLoadReport
+}
+
 // cleanup removes all temp files and dirs that exist on disk.  This is should only be run at startup to avoid
 // removing tmp files that are still in use.
 func (e *Engine) cleanup() error {
@@ -2322,6 +2978,16 @@ func (e *Engine) cleanupTempTSMFiles() error {
 		return fmt.Errorf("error getting compaction temp files: %s", err.Error())
 	}
 
+	// Moves to the cold tier and compactions of cold files leave temp files
+	// in the cold directory.
+	if dir := e.FileStore.ColdDir(); dir != "" {
+		cold, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf("*.%s", CompactionTempExtension)))
+		if err != nil {
+			return fmt.Errorf("error getting cold tier temp files: %s", err.Error())
+		}
+		files = append(files, cold...)
+	}
+
 	for _, f := range files {
 		if err := os.Remove(f); err != nil {
 			return fmt.Errorf("error removing temp compaction files: %v", err)
@@ -2494,11 +3160,17 @@ func (e *Engine) createCallIterator(ctx context.Context, measurement string, cal
 
 			// Wrap each series in a call iterator.
 			for i, input := range inputs {
+				callOpt := opt
+				if _, ok := input.(blockCountIterator); ok {
+					// Counts partially answered from block statistics are added up.
+					callOpt.Expr = &influxql.Call{Name: "sum", Args: call.Args}
+				}
+
 				if opt.InterruptCh != nil {
 					input = query.NewInterruptIterator(input, opt.InterruptCh)
 				}
 
-				itr, err := query.NewCallIterator(input, opt)
+				itr, err := query.NewCallIterator(input, callOpt)
 				if err != nil {
 					query.Iterators(inputs).Close()
 					return err
@@ -2718,6 +3390,16 @@ func (e *Engine) createVarRefSeriesIterator(ctx context.Context, ref *influxql.V
 		condCounter = col.GetCounter(numberOfCondCursorsCounter)
 	}
 
+	// Answer simple aggregates over whole blocks from their statistics.
+	if ref != nil && filter == nil {
+		if itr := e.createBlockStatsIterator(ctx, ref, name, seriesKey, tfs, opt); itr != nil {
+			if curCounter != nil {
+				curCounter.Add(1)
+			}
+			return itr, nil
+		}
+	}
+
 	// Build main cursor.
 	var cur cursor
 	if ref != nil {
@@ -2879,6 +3561,43 @@ func (e *Engine) createVarRefSeriesIterator(ctx context.Context, ref *influxql.V
 	}
 }
 
+// createBlockStatsIterator creates an iterator for a series that answers a count,
+// sum, min or max call over a numeric field from the statistics of the blocks
+// fully covered by the call.  It returns nil if the call cannot use statistics.
+func (e *Engine) createBlockStatsIterator(ctx context.Context, ref *influxql.VarRef, name, seriesKey string, tfs models.Tags, opt query.IteratorOptions) query.Iterator {
+	call, ok := blockStatsCall(opt)
+	if !ok {
+		return nil
+	}
+
+	mf := e.fieldset.FieldsByString(name)
+	if mf == nil {
+		return nil
+	}
+	f := mf.Field(ref.Val)
+	if f == nil {
+		return nil
+	} else if ref.Type != influxql.Unknown && ref.Type != influxql.AnyField && ref.Type != f.Type {
+		return nil
+	}
+
+	tags := query.NewTags(tfs.Map()).Subset(opt.GetDimensions())
+	if opt.StripName {
+		name = ""
+	}
+	opt.Condition = nil
+
+	switch f.Type {
+	case influxql.Float:
+		return e.buildFloatBlockStatsIterator(ctx, name, tags, seriesKey, ref.Val, call, opt)
+	case influxql.Integer:
+		return e.buildIntegerBlockStatsIterator(ctx, name, tags, seriesKey, ref.Val, call, opt)
+	case influxql.Unsigned:
+		return e.buildUnsignedBlockStatsIterator(ctx, name, tags, seriesKey, ref.Val, call, opt)
+	}
+	return nil
+}
+
 // buildCursor creates an untyped cursor for a field.
 func (e *Engine) buildCursor(ctx context.Context, measurement, seriesKey string, tags models.Tags, ref *influxql.VarRef, opt query.IteratorOptions) cursor {
 	// Check if this is a system field cursor.
@@ -2957,6 +3676,9 @@ func (e *Engine) buildCursor(ctx context.Context, measurement, seriesKey string,
 		return e.buildStringCursor(ctx, measurement, seriesKey, ref.Val, opt)
 	case influxql.Boolean:
 		return e.buildBooleanCursor(ctx, measurement, seriesKey, ref.Val, opt)
+	case cursors.SketchDataType:
+		// Sketches cannot be read by InfluxQL, only through the array cursors.
+		return nil
 	default:
 		panic("unreachable")
 	}
@@ -3104,7 +3826,7 @@ var (
 		BlockBoolean:  influxql.Boolean,
 		BlockString:   influxql.String,
 		BlockUnsigned: influxql.Unsigned,
-		5:             influxql.Unknown,
+		BlockSketch:   cursors.SketchDataType,
 		6:             influxql.Unknown,
 		7:             influxql.Unknown,
 	}
@@ -3144,6 +3866,10 @@ func varRefSliceRemove(a []influxql.VarRef, v string) []influxql.VarRef {
 const reindexBatchSize = 10000
 
 func (e *Engine) Reindex() error {
+	if err := e.checkWritable("reindex"); err != nil {
+		return err
+	}
+
 	keys := make([][]byte, reindexBatchSize)
 	seriesKeys := make([][]byte, reindexBatchSize)
 	names := make([][]byte, reindexBatchSize)
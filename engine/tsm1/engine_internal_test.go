@@ -2,6 +2,7 @@ package tsm1
 
 import (
 	"context"
+	"math"
 	"os"
 	"path/filepath"
 	"testing"
@@ -76,6 +77,160 @@ func TestEngine_ConcurrentShardSnapshots(t *testing.T) {
 	realEngineStruct.Cache.snapshotting = false
 }
 
+func TestEngine_ExpireCache(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sfile := NewSeriesFile(t, tmpDir)
+	defer sfile.Close()
+
+	opts := tsdb.NewEngineOptions()
+	opts.Config.WALDir = filepath.Join(tmpDir, "wal")
+	opts.SeriesIDSets = seriesIDSets([]*tsdb.SeriesIDSet{})
+
+	retention, err := tsdb.NewSeriesRetention(filepath.Join(tmpDir, tsdb.SeriesRetentionFileName))
+	require.NoError(t, err)
+	opts.SeriesRetention = retention
+
+	sh := tsdb.NewShard(1, filepath.Join(tmpDir, "shard"), opts.Config.WALDir, sfile, opts)
+	require.NoError(t, sh.Open(context.Background()), "error opening shard")
+	defer sh.Close()
+
+	now := time.Now()
+	old, recent := now.Add(-96*time.Hour), now.Add(-time.Hour)
+	var points []models.Point
+	for _, name := range []string{"cpu", "debug"} {
+		for i, ts := range []time.Time{old, recent} {
+			points = append(points, models.MustNewPoint(
+				name,
+				models.NewTags(map[string]string{"host": "server"}),
+				map[string]interface{}{"value": float64(i)},
+				ts,
+			))
+		}
+	}
+	// trace only has values that expire.
+	points = append(points, models.MustNewPoint(
+		"trace",
+		models.NewTags(map[string]string{"host": "server"}),
+		map[string]interface{}{"value": 0.0},
+		old,
+	))
+	require.NoError(t, sh.WritePoints(context.Background(), points))
+
+	engineInterface, err := sh.Engine()
+	require.NoError(t, err, "error retrieving shard engine")
+	e, ok := engineInterface.(*Engine)
+	if !ok {
+		t.Skip("Engine type does not permit expiring the cache")
+	}
+
+	require.NoError(t, retention.SetRules([]tsdb.SeriesRetentionRule{
+		{Measurement: "debug", Duration: 72 * time.Hour},
+		{Measurement: "trace", Duration: 72 * time.Hour},
+	}))
+	require.NoError(t, e.expireCache(context.Background(), now))
+
+	require.Len(t, e.Cache.Values([]byte("cpu,host=server#!~#value")), 2)
+	values := e.Cache.Values([]byte("debug,host=server#!~#value"))
+	require.Len(t, values, 1)
+	require.Equal(t, recent.UnixNano(), values[0].UnixNano())
+
+	// Series without values left are removed from the index.
+	require.Empty(t, e.Cache.Values([]byte("trace,host=server#!~#value")))
+	exists, err := e.index.MeasurementExists([]byte("trace"))
+	require.NoError(t, err)
+	require.False(t, exists)
+	exists, err = e.index.MeasurementExists([]byte("debug"))
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
+func TestEngine_ExpireFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sfile := NewSeriesFile(t, tmpDir)
+	defer sfile.Close()
+
+	opts := tsdb.NewEngineOptions()
+	opts.Config.WALDir = filepath.Join(tmpDir, "wal")
+	opts.SeriesIDSets = seriesIDSets([]*tsdb.SeriesIDSet{})
+
+	retention, err := tsdb.NewSeriesRetention(filepath.Join(tmpDir, tsdb.SeriesRetentionFileName))
+	require.NoError(t, err)
+	opts.SeriesRetention = retention
+
+	sh := tsdb.NewShard(1, filepath.Join(tmpDir, "shard"), opts.Config.WALDir, sfile, opts)
+	require.NoError(t, sh.Open(context.Background()), "error opening shard")
+	defer sh.Close()
+
+	engineInterface, err := sh.Engine()
+	require.NoError(t, err, "error retrieving shard engine")
+	e, ok := engineInterface.(*Engine)
+	if !ok {
+		t.Skip("Engine type does not permit expiring TSM files")
+	}
+
+	now := time.Now()
+	old, recent := now.Add(-96*time.Hour), now.Add(-time.Hour)
+	var points []models.Point
+	for _, name := range []string{"cpu", "debug"} {
+		for i, ts := range []time.Time{old, recent} {
+			points = append(points, models.MustNewPoint(
+				name,
+				models.NewTags(map[string]string{"host": "server"}),
+				map[string]interface{}{"value": float64(i)},
+				ts,
+			))
+		}
+	}
+	// trace only has values that expire.
+	points = append(points, models.MustNewPoint(
+		"trace",
+		models.NewTags(map[string]string{"host": "server"}),
+		map[string]interface{}{"value": 0.0},
+		old,
+	))
+	require.NoError(t, sh.WritePoints(context.Background(), points))
+	require.NoError(t, e.WriteSnapshot())
+	e.SetCompactionsEnabled(false)
+
+	require.NoError(t, retention.SetRules([]tsdb.SeriesRetentionRule{
+		{Measurement: "debug", Duration: 72 * time.Hour},
+		{Measurement: "trace", Duration: 72 * time.Hour},
+	}))
+	require.NoError(t, e.expireFiles(context.Background(), now))
+
+	files := e.FileStore.Files()
+	require.Len(t, files, 1)
+	require.True(t, files[0].HasTombstones())
+	require.Empty(t, files[0].TombstoneRange([]byte("cpu,host=server#!~#value")))
+	require.Equal(t,
+		[]TimeRange{{Min: math.MinInt64, Max: now.Add(-72*time.Hour).UnixNano() - 1}},
+		files[0].TombstoneRange([]byte("debug,host=server#!~#value")))
+
+	// Series without values left are removed from the index.
+	exists, err := e.index.MeasurementExists([]byte("trace"))
+	require.NoError(t, err)
+	require.False(t, exists)
+	exists, err = e.index.MeasurementExists([]byte("debug"))
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
+func TestEngine_SkipCanceledCompactions(t *testing.T) {
+	e := &Engine{CompactionPlan: NewDefaultPlanner(nil, 0)}
+	e.backOffCompaction(CompactionGroup{"01-01.tsm", "02-01.tsm"})
+
+	groups := []CompactionGroup{{"01-01.tsm", "03-01.tsm"}, {"04-01.tsm", "05-01.tsm"}}
+	now := time.Now()
+	require.Equal(t, []CompactionGroup{{"04-01.tsm", "05-01.tsm"}}, e.skipCanceledCompactions(groups, now))
+
+	// The files are planned again once the backoff has passed.
+	groups = []CompactionGroup{{"01-01.tsm", "03-01.tsm"}}
+	require.Equal(t, groups, e.skipCanceledCompactions(groups, now.Add(compactionCancelBackoff)))
+	require.Empty(t, e.canceledFiles)
+}
+
 // NewSeriesFile returns a new instance of SeriesFile with a temporary file path.
 func NewSeriesFile(tb testing.TB, tmpDir string) *tsdb.SeriesFile {
 	tb.Helper()
@@ -23,6 +23,7 @@ import (
 	"github.com/influxdata/influxdb/v2/models"
 	"github.com/influxdata/influxdb/v2/pkg/deep"
 	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxdb/v2/tsdb/cursors"
 	"github.com/influxdata/influxdb/v2/tsdb/engine/tsm1"
 	"github.com/influxdata/influxdb/v2/tsdb/index/tsi1"
 	"github.com/influxdata/influxql"
@@ -982,6 +983,86 @@ func TestEngine_CreateIterator_TSM_Descending(t *testing.T) {
 	}
 }
 
+// Ensure engine answers aggregates over snapshotted blocks and cached values.
+func TestEngine_CreateIterator_BlockStats(t *testing.T) {
+	t.Parallel()
+
+	for _, index := range tsdb.RegisteredIndexes() {
+		t.Run(index, func(t *testing.T) {
+			e := MustOpenEngine(t, index)
+
+			e.MeasurementFields([]byte("cpu")).CreateFieldIfNotExists([]byte("value"), influxql.Float)
+			e.CreateSeriesIfNotExists([]byte("cpu,host=A"), []byte("cpu"), models.NewTags(map[string]string{"host": "A"}))
+
+			if err := e.WritePointsString(
+				`cpu,host=A value=1.1 1000000000`,
+				`cpu,host=A value=4.2 2000000000`,
+				`cpu,host=A value=1.3 3000000000`,
+			); err != nil {
+				t.Fatalf("failed to write points: %s", err.Error())
+			}
+			e.MustWriteSnapshot()
+
+			if err := e.WritePointsString(`cpu,host=A value=2.5 15000000000`); err != nil {
+				t.Fatalf("failed to write points: %s", err.Error())
+			}
+
+			for _, tt := range []struct {
+				expr  string
+				times []int64
+				exp   []float64
+			}{
+				{expr: `count(value)`, times: []int64{0, 10000000000}, exp: []float64{3, 1}},
+				{expr: `sum(value)`, times: []int64{0, 10000000000}, exp: []float64{6.6, 2.5}},
+				{expr: `max(value)`, times: []int64{2000000000, 15000000000}, exp: []float64{4.2, 2.5}},
+			} {
+				itrs, err := e.CreateIterator(context.Background(), "cpu", query.IteratorOptions{
+					Expr:       influxql.MustParseExpr(tt.expr),
+					Dimensions: []string{"host"},
+					Interval:   query.Interval{Duration: 10 * time.Second},
+					StartTime:  0,
+					EndTime:    20000000000 - 1,
+					Ascending:  true,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var times []int64
+				var values []float64
+				for {
+					var p *query.FloatPoint
+					switch itr := itrs.(type) {
+					case query.FloatIterator:
+						p, err = itr.Next()
+					case query.IntegerIterator:
+						var ip *query.IntegerPoint
+						if ip, err = itr.Next(); ip != nil {
+							p = &query.FloatPoint{Time: ip.Time, Value: float64(ip.Value)}
+						}
+					default:
+						t.Fatalf("%s: unexpected iterator type: %T", tt.expr, itr)
+					}
+					if err != nil {
+						t.Fatalf("%s: unexpected error: %v", tt.expr, err)
+					} else if p == nil {
+						break
+					}
+					times = append(times, p.Time)
+					values = append(values, math.Round(p.Value*10)/10)
+				}
+				itrs.Close()
+
+				if !reflect.DeepEqual(times, tt.times) {
+					t.Fatalf("%s: unexpected times: got %v, exp %v", tt.expr, times, tt.times)
+				} else if !reflect.DeepEqual(values, tt.exp) {
+					t.Fatalf("%s: unexpected values: got %v, exp %v", tt.expr, values, tt.exp)
+				}
+			}
+		})
+	}
+}
+
 // Ensure engine can create an iterator with auxiliary fields.
 func TestEngine_CreateIterator_Aux(t *testing.T) {
 	t.Parallel()
@@ -2004,6 +2085,155 @@ func TestEngine_CreateCursor_Descending(t *testing.T) {
 	}
 }
 
+// Ensure engine can create window aggregate cursors for cache and tsm values.
+func TestEngine_CreateCursor_WindowAggregate(t *testing.T) {
+	t.Parallel()
+
+	e := MustOpenEngine(t, tsi1.IndexName)
+
+	e.MeasurementFields([]byte("cpu")).CreateFieldIfNotExists([]byte("value"), influxql.Float)
+	e.CreateSeriesIfNotExists([]byte("cpu,host=A"), []byte("cpu"), models.NewTags(map[string]string{"host": "A"}))
+
+	if err := e.WritePointsString(
+		`cpu,host=A value=1 1`,
+		`cpu,host=A value=3 2`,
+		`cpu,host=A value=2 3`,
+	); err != nil {
+		t.Fatalf("failed to write points: %s", err.Error())
+	}
+	e.MustWriteSnapshot()
+
+	if err := e.WritePointsString(
+		`cpu,host=A value=10 10`,
+		`cpu,host=A value=12 11`,
+		`cpu,host=A value=11 12`,
+		`cpu,host=A value=20 25`,
+	); err != nil {
+		t.Fatalf("failed to write points: %s", err.Error())
+	}
+
+	for _, tt := range []struct {
+		agg           cursors.Aggregate
+		every         int64
+		expTimestamps []int64
+		expValues     interface{}
+	}{
+		{agg: cursors.AggregateSum, every: 10, expTimestamps: []int64{0, 10, 20}, expValues: []float64{6, 33, 20}},
+		{agg: cursors.AggregateSum, expTimestamps: []int64{0}, expValues: []float64{59}},
+		{agg: cursors.AggregateCount, every: 10, expTimestamps: []int64{0, 10, 20}, expValues: []int64{3, 3, 1}},
+		{agg: cursors.AggregateMin, every: 10, expTimestamps: []int64{1, 10, 25}, expValues: []float64{1, 10, 20}},
+		{agg: cursors.AggregateMax, every: 10, expTimestamps: []int64{2, 11, 25}, expValues: []float64{3, 12, 20}},
+		{agg: cursors.AggregateMean, every: 10, expTimestamps: []int64{0, 10, 20}, expValues: []float64{2, 11, 20}},
+		{agg: cursors.AggregateFirst, every: 10, expTimestamps: []int64{1, 10, 25}, expValues: []float64{1, 10, 20}},
+		{agg: cursors.AggregateLast, every: 20, expTimestamps: []int64{12, 25}, expValues: []float64{11, 20}},
+	} {
+		q, err := e.CreateCursorIterator(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cur, err := q.Next(context.Background(), &tsdb.CursorRequest{
+			Name:      []byte("cpu"),
+			Tags:      models.ParseTags([]byte("cpu,host=A")),
+			Field:     "value",
+			StartTime: 0,
+			EndTime:   29,
+			Aggregate: tt.agg,
+			Every:     tt.every,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var timestamps []int64
+		var values interface{}
+		switch cur := cur.(type) {
+		case tsdb.FloatArrayCursor:
+			a := cur.Next()
+			timestamps, values = a.Timestamps, a.Values
+		case tsdb.IntegerArrayCursor:
+			a := cur.Next()
+			timestamps, values = a.Timestamps, a.Values
+		default:
+			t.Fatalf("%s: unexpected cursor type %T", tt.agg, cur)
+		}
+		if !cmp.Equal(tt.expTimestamps, timestamps) {
+			t.Errorf("%s every %d: unexpected timestamps: %v", tt.agg, tt.every, timestamps)
+		}
+		if !cmp.Equal(tt.expValues, values) {
+			t.Errorf("%s every %d: unexpected values: %v", tt.agg, tt.every, values)
+		}
+		cur.Close()
+	}
+}
+
+// Ensure a read-only engine loads its data without changing any file, and
+// rejects changes.
+func TestEngine_ReadOnly(t *testing.T) {
+	t.Parallel()
+
+	e := MustOpenEngine(t, tsi1.IndexName)
+	require.NoError(t, e.WritePointsString(
+		`cpu,host=A value=1 1`,
+		`cpu,host=A value=2 2`,
+	))
+	e.MustWriteSnapshot()
+	require.NoError(t, e.WritePointsString(`cpu,host=A value=3 3`))
+
+	require.NoError(t, e.close(false))
+	before := fileInfos(t, e.root)
+	require.NoError(t, e.open(true))
+
+	// Values are read from both the TSM files and the WAL.
+	q, err := e.CreateCursorIterator(context.Background())
+	require.NoError(t, err)
+	cur, err := q.Next(context.Background(), &tsdb.CursorRequest{
+		Name:      []byte("cpu"),
+		Tags:      models.ParseTags([]byte("cpu,host=A")),
+		Field:     "value",
+		Ascending: true,
+		StartTime: 0,
+		EndTime:   10,
+	})
+	require.NoError(t, err)
+	a := cur.(tsdb.FloatArrayCursor).Next()
+	require.Equal(t, []int64{1, 2, 3}, a.Timestamps)
+	cur.Close()
+
+	err = e.WritePointsString(`cpu,host=A value=4 4`)
+	require.ErrorIs(t, err, tsdb.ErrReadOnly)
+	var roErr tsdb.ReadOnlyError
+	require.ErrorAs(t, err, &roErr)
+	require.Equal(t, e.Path(), roErr.Path)
+
+	require.ErrorIs(t, e.WritePoints(context.Background(), MustParsePointsString(`cpu,host=A value=4 4`)), tsdb.ErrReadOnly)
+	require.ErrorIs(t, e.DeleteMeasurement(context.Background(), []byte("cpu")), tsdb.ErrReadOnly)
+	require.ErrorIs(t, e.WriteSnapshot(), tsdb.ErrReadOnly)
+	require.ErrorIs(t, e.ScheduleFullCompaction(), tsdb.ErrReadOnly)
+
+	require.NoError(t, e.close(false))
+	require.Equal(t, before, fileInfos(t, e.root))
+
+	// The engine can still be opened for writes.
+	require.NoError(t, e.open(false))
+}
+
+// fileInfos returns the size and modification time of the files and directories
+// under root.
+func fileInfos(tb testing.TB, root string) map[string]string {
+	tb.Helper()
+
+	infos := make(map[string]string)
+	require.NoError(tb, filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		infos[path] = fmt.Sprintf("%d %s", fi.Size(), fi.ModTime())
+		return nil
+	}))
+	return infos
+}
+
 // Ensure engine can create an descending iterator for cached values.
 func TestEngine_CreateIterator_SeriesKey(t *testing.T) {
 	t.Parallel()
@@ -2653,15 +2883,21 @@ func (e *Engine) Reopen() error {
 	if err := e.close(false); err != nil {
 		return err
 	}
+	return e.open(false)
+}
 
+// open opens the series file, index and engine of a closed engine.
+func (e *Engine) open(readOnly bool) error {
 	// Re-open series file. Must create a new series file using the same data.
 	e.sfile = tsdb.NewSeriesFile(e.sfile.Path())
+	e.sfile.WithReadOnly(readOnly)
 	if err := e.sfile.Open(); err != nil {
 		return err
 	}
 
 	db := path.Base(e.root)
 	opt := tsdb.NewEngineOptions()
+	opt.ReadOnly = readOnly
 
 	// Re-initialise the series id set
 	seriesIDSet := tsdb.NewSeriesIDSet()
@@ -931,3 +931,189 @@ func excludeTombstonesBooleanValues(t []TimeRange, values BooleanValues) Boolean
 	}
 	return values
 }
+
+// ReadSketchBlock reads the next block as a set of sketch values.
+func (c *KeyCursor) ReadSketchBlock(buf *[]SketchValue) ([]SketchValue, error) {
+LOOP:
+	// No matching blocks to decode
+	if len(c.current) == 0 {
+		return nil, nil
+	}
+
+	// First block is the oldest block containing the points we're searching for.
+	first := c.current[0]
+	*buf = (*buf)[:0]
+	var values SketchValues
+	values, err := first.r.ReadSketchBlockAt(&first.entry, buf)
+	if err != nil {
+		return nil, err
+	}
+	if c.col != nil {
+		c.col.GetCounter(sketchBlocksDecodedCounter).Add(1)
+		c.col.GetCounter(sketchBlocksSizeCounter).Add(int64(first.entry.Size))
+	}
+
+	// Remove values we already read
+	values = values.Exclude(first.readMin, first.readMax)
+
+	// Remove any tombstones
+	tombstones := first.r.TombstoneRange(c.key)
+	values = excludeTombstonesSketchValues(tombstones, values)
+	// If there are no values in this first block (all tombstoned or previously read) and
+	// we have more potential blocks too search.  Try again.
+	if values.Len() == 0 && len(c.current) > 0 {
+		c.current = c.current[1:]
+		goto LOOP
+	}
+
+	// Only one block with this key and time range so return it
+	if len(c.current) == 1 {
+		if values.Len() > 0 {
+			first.markRead(values.MinTime(), values.MaxTime())
+		}
+		return values, nil
+	}
+
+	// Use the current block time range as our overlapping window
+	minT, maxT := first.readMin, first.readMax
+	if values.Len() > 0 {
+		minT, maxT = values.MinTime(), values.MaxTime()
+	}
+	if c.ascending {
+		// Blocks are ordered by generation, we may have values in the past in later blocks, if so,
+		// expand the window to include the min time range to ensure values are returned in ascending
+		// order
+		for i := 1; i < len(c.current); i++ {
+			cur := c.current[i]
+			if cur.entry.MinTime < minT && !cur.read() {
+				minT = cur.entry.MinTime
+			}
+		}
+
+		// Find first block that overlaps our window
+		for i := 1; i < len(c.current); i++ {
+			cur := c.current[i]
+			if cur.entry.OverlapsTimeRange(minT, maxT) && !cur.read() {
+				// Shrink our window so it's the intersection of the first overlapping block and the
+				// first block.  We do this to minimize the region that overlaps and needs to
+				// be merged.
+				if cur.entry.MaxTime > maxT {
+					maxT = cur.entry.MaxTime
+				}
+				values = values.Include(minT, maxT)
+				break
+			}
+		}
+
+		// Search the remaining blocks that overlap our window and append their values so we can
+		// merge them.
+		for i := 1; i < len(c.current); i++ {
+			cur := c.current[i]
+			// Skip this block if it doesn't contain points we looking for or they have already been read
+			if !cur.entry.OverlapsTimeRange(minT, maxT) || cur.read() {
+				cur.markRead(minT, maxT)
+				continue
+			}
+
+			var a []SketchValue
+			var v SketchValues
+			v, err := cur.r.ReadSketchBlockAt(&cur.entry, &a)
+			if err != nil {
+				return nil, err
+			}
+			if c.col != nil {
+				c.col.GetCounter(sketchBlocksDecodedCounter).Add(1)
+				c.col.GetCounter(sketchBlocksSizeCounter).Add(int64(cur.entry.Size))
+			}
+
+			tombstones := cur.r.TombstoneRange(c.key)
+			// Remove any tombstoned values
+			v = excludeTombstonesSketchValues(tombstones, v)
+
+			// Remove values we already read
+			v = v.Exclude(cur.readMin, cur.readMax)
+
+			if v.Len() > 0 {
+				// Only use values in the overlapping window
+				v = v.Include(minT, maxT)
+				// Merge the remaining values with the existing
+				values = values.Merge(v)
+			}
+			cur.markRead(minT, maxT)
+		}
+
+	} else {
+		// Blocks are ordered by generation, we may have values in the past in later blocks, if so,
+		// expand the window to include the max time range to ensure values are returned in descending
+		// order
+		for i := 1; i < len(c.current); i++ {
+			cur := c.current[i]
+			if cur.entry.MaxTime > maxT && !cur.read() {
+				maxT = cur.entry.MaxTime
+			}
+		}
+
+		// Find first block that overlaps our window
+		for i := 1; i < len(c.current); i++ {
+			cur := c.current[i]
+			if cur.entry.OverlapsTimeRange(minT, maxT) && !cur.read() {
+				// Shrink our window so it's the intersection of the first overlapping block and the
+				// first block.  We do this to minimize the region that overlaps and needs to
+				// be merged.
+				if cur.entry.MinTime < minT {
+					minT = cur.entry.MinTime
+				}
+				values = values.Include(minT, maxT)
+				break
+			}
+		}
+
+		// Search the remaining blocks that overlap our window and append their values so we can
+		// merge them.
+		for i := 1; i < len(c.current); i++ {
+			cur := c.current[i]
+			// Skip this block if it doesn't contain points we looking for or they have already been read
+			if !cur.entry.OverlapsTimeRange(minT, maxT) || cur.read() {
+				cur.markRead(minT, maxT)
+				continue
+			}
+
+			var a []SketchValue
+			var v SketchValues
+			v, err := cur.r.ReadSketchBlockAt(&cur.entry, &a)
+			if err != nil {
+				return nil, err
+			}
+			if c.col != nil {
+				c.col.GetCounter(sketchBlocksDecodedCounter).Add(1)
+				c.col.GetCounter(sketchBlocksSizeCounter).Add(int64(cur.entry.Size))
+			}
+			tombstones := cur.r.TombstoneRange(c.key)
+			// Remove any tombstoned values
+			v = excludeTombstonesSketchValues(tombstones, v)
+
+			// Remove values we already read
+			v = v.Exclude(cur.readMin, cur.readMax)
+
+			// If the block we decoded should have all of it's values included, mark it as read so we
+			// don't use it again.
+			if v.Len() > 0 {
+				v = v.Include(minT, maxT)
+				// Merge the remaining values with the existing
+				values = v.Merge(values)
+			}
+			cur.markRead(minT, maxT)
+		}
+	}
+
+	first.markRead(minT, maxT)
+
+	return values, err
+}
+
+func excludeTombstonesSketchValues(t []TimeRange, values SketchValues) SketchValues {
+	for i := range t {
+		values = values.Exclude(t[i].Min, t[i].Max)
+	}
+	return values
+}
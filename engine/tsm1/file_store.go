@@ -56,11 +56,17 @@ type TSMFile interface {
 	ReadStringArrayBlockAt(entry *IndexEntry, values *tsdb.StringArray) error
 	ReadBooleanBlockAt(entry *IndexEntry, values *[]BooleanValue) ([]BooleanValue, error)
 	ReadBooleanArrayBlockAt(entry *IndexEntry, values *tsdb.BooleanArray) error
+	ReadSketchBlockAt(entry *IndexEntry, values *[]SketchValue) ([]SketchValue, error)
+	ReadSketchArrayBlockAt(entry *IndexEntry, values *tsdb.SketchArray) error
 
 	// Entries returns the index entries for all blocks for the given key.
 	Entries(key []byte) []IndexEntry
 	ReadEntries(key []byte, entries *[]IndexEntry) []IndexEntry
 
+	// BlockStats returns the statistics of the block identified by entry, if
+	// the file has them.
+	BlockStats(entry *IndexEntry) (BlockStats, bool)
+
 	// Returns true if the TSMFile may contain a value with the specified
 	// key and time.
 	ContainsValue(key []byte, t int64) bool
@@ -159,6 +165,8 @@ var (
 	stringBlocksSizeCounter      = metrics.MustRegisterCounter("string_blocks_size_bytes", metrics.WithGroup(tsmGroup))
 	booleanBlocksDecodedCounter  = metrics.MustRegisterCounter("boolean_blocks_decoded", metrics.WithGroup(tsmGroup))
 	booleanBlocksSizeCounter     = metrics.MustRegisterCounter("boolean_blocks_size_bytes", metrics.WithGroup(tsmGroup))
+	sketchBlocksDecodedCounter   = metrics.MustRegisterCounter("sketch_blocks_decoded", metrics.WithGroup(tsmGroup))
+	sketchBlocksSizeCounter      = metrics.MustRegisterCounter("sketch_blocks_size_bytes", metrics.WithGroup(tsmGroup))
 )
 
 // FileStore is an abstraction around multiple TSM files.
@@ -171,6 +179,7 @@ type FileStore struct {
 
 	currentGeneration int
 	dir               string
+	coldDir           string // If set, fully compacted files older than the cold age are moved here.
 
 	files           []TSMFile
 	tsmMMAPWillNeed bool          // If true then the kernel will be advised MMAP_WILLNEED for TSM files.
@@ -190,6 +199,10 @@ type FileStore struct {
 	obs tsdb.FileStoreObserver
 
 	copyFiles bool
+
+	blockCache *tsdb.BlockCache // If set, decoded blocks are cached here.
+
+	readOnly bool // If set, no file is created, renamed or removed.
 }
 
 // FileStat holds information about a TSM file on disk.
@@ -252,6 +265,31 @@ func (f *FileStore) WithObserver(obs tsdb.FileStoreObserver) {
 	f.obs = obs
 }
 
+// WithBlockCache sets the cache of the blocks decoded from TSM files.  It must
+// be called before the FileStore is opened.
+func (f *FileStore) WithBlockCache(c *tsdb.BlockCache) {
+	f.blockCache = c
+}
+
+// WithReadOnly sets whether the FileStore may change its files.  If readOnly is
+// true, corrupt files are not renamed when opening, and deletes, replacements,
+// snapshots and moves to the cold tier return a tsdb.ReadOnlyError.  It must be
+// called before the FileStore is opened.
+func (f *FileStore) WithReadOnly(readOnly bool) {
+	f.readOnly = readOnly
+}
+
+// WithColdDir sets the directory TSM files are moved to by MoveToCold.  It must
+// be called before the FileStore is opened.
+func (f *FileStore) WithColdDir(dir string) {
+	f.coldDir = dir
+}
+
+// ColdDir returns the directory of the cold storage tier, if any.
+func (f *FileStore) ColdDir() string {
+	return f.coldDir
+}
+
 func (f *FileStore) WithParseFileNameFunc(parseFileNameFunc ParseFileNameFunc) {
 	f.parseFileName = parseFileNameFunc
 }
@@ -484,6 +522,10 @@ func (f *FileStore) Apply(ctx context.Context, fn func(r TSMFile) error) error {
 // DeleteRange removes the values for keys between timestamps min and max.  This should only
 // be used with smaller batches of series keys.
 func (f *FileStore) DeleteRange(keys [][]byte, min, max int64) error {
+	if f.readOnly {
+		return tsdb.ReadOnlyError{Op: "delete", Path: f.dir}
+	}
+
 	var batches BatchDeleters
 	f.mu.RLock()
 	for _, f := range f.files {
@@ -559,7 +601,7 @@ func (f *FileStore) Open(ctx context.Context) error {
 		f.currentTempDirID = i
 	}
 
-	files, err := filepath.Glob(filepath.Join(f.dir, "*."+TSMFileExtension))
+	files, err := f.tieredFiles()
 	if err != nil {
 		return err
 	}
@@ -599,7 +641,7 @@ func (f *FileStore) Open(ctx context.Context) error {
 			defer f.openLimiter.Release()
 
 			start := time.Now()
-			df, err := NewTSMReader(file, WithMadviseWillNeed(f.tsmMMAPWillNeed))
+			df, err := NewTSMReader(file, WithMadviseWillNeed(f.tsmMMAPWillNeed), WithBlockCache(f.blockCache))
 			f.logger.Info("Opened file",
 				zap.String("path", file.Name()),
 				zap.Int("id", idx),
@@ -607,7 +649,12 @@ func (f *FileStore) Open(ctx context.Context) error {
 
 			// If we are unable to read a TSM file then log the error, rename
 			// the file, and continue loading the shard without it.
-			if err != nil {
+			if err != nil && f.readOnly {
+				f.logger.Error("Cannot read corrupt tsm file", zap.String("path", file.Name()), zap.Int("id", idx), zap.Error(err))
+				file.Close()
+				readerC <- &res{r: df, err: fmt.Errorf("cannot read corrupt file %s: %v", file.Name(), err)}
+				return
+			} else if err != nil {
 				f.logger.Error("Cannot read corrupt tsm file, renaming", zap.String("path", file.Name()), zap.Int("id", idx), zap.Error(err))
 				file.Close()
 				if e := os.Rename(file.Name(), file.Name()+"."+BadTSMFileExtension); e != nil {
@@ -664,6 +711,50 @@ func (f *FileStore) Open(ctx context.Context) error {
 	return nil
 }
 
+// tieredFiles returns the TSM files in the file store directory and in the cold
+// directory.  A file is found in both directories if a move to the cold tier
+// was interrupted after the moved file was renamed into place.  The cold copy
+// is complete in that case, so the original file and its tombstone are removed,
+// or only ignored if the file store is read-only.
+func (f *FileStore) tieredFiles() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(f.dir, "*."+TSMFileExtension))
+	if err != nil || f.coldDir == "" {
+		return files, err
+	}
+
+	cold, err := filepath.Glob(filepath.Join(f.coldDir, "*."+TSMFileExtension))
+	if err != nil {
+		return nil, err
+	} else if len(cold) == 0 {
+		return files, nil
+	}
+
+	moved := make(map[string]struct{}, len(cold))
+	for _, fn := range cold {
+		moved[filepath.Base(fn)] = struct{}{}
+	}
+
+	hot := files[:0]
+	for _, fn := range files {
+		if _, ok := moved[filepath.Base(fn)]; !ok {
+			hot = append(hot, fn)
+			continue
+		} else if f.readOnly {
+			continue
+		}
+
+		f.logger.Info("Removing TSM file already moved to cold tier", zap.String("path", fn))
+		ts := strings.TrimSuffix(fn, "."+TSMFileExtension) + "." + TombstoneFileExtension
+		if err := os.Remove(ts); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err := os.Remove(fn); err != nil {
+			return nil, err
+		}
+	}
+	return append(hot, cold...), nil
+}
+
 // Close closes the file store.
 func (f *FileStore) Close() error {
 	// Make the object appear closed to other method calls.
@@ -787,6 +878,8 @@ func (f *FileStore) Replace(oldFiles, newFiles []string) error {
 func (f *FileStore) replace(oldFiles, newFiles []string, updatedFn func(r []TSMFile)) error {
 	if len(oldFiles) == 0 && len(newFiles) == 0 {
 		return nil
+	} else if f.readOnly {
+		return tsdb.ReadOnlyError{Op: "replace files", Path: f.dir}
 	}
 
 	f.mu.RLock()
@@ -837,7 +930,7 @@ func (f *FileStore) replace(oldFiles, newFiles []string, updatedFn func(r []TSMF
 			}
 		}
 
-		tsm, err := NewTSMReader(fd, WithMadviseWillNeed(f.tsmMMAPWillNeed))
+		tsm, err := NewTSMReader(fd, WithMadviseWillNeed(f.tsmMMAPWillNeed), WithBlockCache(f.blockCache))
 		if err != nil {
 			if newName != oldName {
 				if err1 := os.Rename(newName, oldName); err1 != nil {
@@ -873,6 +966,11 @@ func (f *FileStore) replace(oldFiles, newFiles []string, updatedFn func(r []TSMF
 			if remove == file.Path() {
 				keep = false
 
+				// Drop the cached blocks of the file, even if queries still use it.
+				if r, ok := file.(*TSMReader); ok {
+					r.invalidateBlockCache()
+				}
+
 				// give the observer a chance to process the file first.
 				if err := f.obs.FileUnlinking(file.Path()); err != nil {
 					return err
@@ -938,6 +1036,11 @@ func (f *FileStore) replace(oldFiles, newFiles []string, updatedFn func(r []TSMF
 	if err := file.SyncDir(f.dir); err != nil {
 		return err
 	}
+	if f.coldDir != "" {
+		if err := file.SyncDir(f.coldDir); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 
 	// Tell the purger about our in-use files we need to remove
 	f.purger.add(inuse)
@@ -1190,7 +1293,11 @@ func (f *FileStore) copyNotLink(oldPath, newPath string) (returnErr error) {
 // linkNotCopy - use hard links for backup snapshots
 func (f *FileStore) linkNotCopy(oldPath, newPath string) error {
 	if err := os.Link(oldPath, newPath); err != nil {
-		if errors.Is(err, syscall.ENOTSUP) {
+		if errors.Is(err, syscall.EXDEV) {
+			// Files in the cold tier may be on another device than the
+			// snapshot directory.  Copy them without giving up on links.
+			return f.copyNotLink(oldPath, newPath)
+		} else if errors.Is(err, syscall.ENOTSUP) {
 			if fi, e := os.Stat(oldPath); e == nil && !fi.IsDir() {
 				f.logger.Info("file system does not support hard links, switching to copies for backup", zap.String("OldPath", oldPath), zap.String("NewPath", newPath))
 				// Force future snapshots to copy
@@ -1213,6 +1320,10 @@ func (f *FileStore) linkNotCopy(oldPath, newPath string) error {
 // CreateSnapshot creates hardlinks for all tsm and tombstone files
 // in the path provided.
 func (f *FileStore) CreateSnapshot() (string, error) {
+	if f.readOnly {
+		return "", tsdb.ReadOnlyError{Op: "create snapshot", Path: f.dir}
+	}
+
 	f.traceLogger.Info("Creating snapshot", zap.String("dir", f.dir))
 
 	f.mu.Lock()
@@ -1338,7 +1449,7 @@ func (a descLocations) Len() int      { return len(a) }
 func (a descLocations) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 func (a descLocations) Less(i, j int) bool {
 	if a[i].entry.OverlapsTimeRange(a[j].entry.MinTime, a[j].entry.MaxTime) {
-		return a[i].r.Path() < a[j].r.Path()
+		return filepath.Base(a[i].r.Path()) < filepath.Base(a[j].r.Path())
 	}
 	return a[i].entry.MaxTime < a[j].entry.MaxTime
 }
@@ -1350,7 +1461,7 @@ func (a ascLocations) Len() int      { return len(a) }
 func (a ascLocations) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 func (a ascLocations) Less(i, j int) bool {
 	if a[i].entry.OverlapsTimeRange(a[j].entry.MinTime, a[j].entry.MaxTime) {
-		return a[i].r.Path() < a[j].r.Path()
+		return filepath.Base(a[i].r.Path()) < filepath.Base(a[j].r.Path())
 	}
 	return a[i].entry.MinTime < a[j].entry.MinTime
 }
@@ -1433,6 +1544,72 @@ func (c *KeyCursor) seekDescending(t int64) {
 	}
 }
 
+// TakeBlockStats removes the blocks accepted by use from the cursor and returns
+// their statistics, in the order the cursor would have read the blocks.  Only
+// blocks that have statistics, have no tombstones and do not overlap any other
+// block are considered, so their values are exactly what the statistics describe.
+// It must be called before any block is read, with the time the cursor was
+// created with.
+func (c *KeyCursor) TakeBlockStats(t int64, use func(entry *IndexEntry) bool) []BlockStats {
+	if len(c.seeks) == 0 {
+		return nil
+	}
+
+	// Find the blocks that do not overlap any other block.
+	sorted := make([]*location, len(c.seeks))
+	copy(sorted, c.seeks)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].entry.MinTime < sorted[j].entry.MinTime })
+	isolated := make(map[*location]struct{}, len(sorted))
+	for i, l := range sorted {
+		if i > 0 && sorted[i-1].entry.MaxTime >= l.entry.MinTime {
+			continue
+		} else if i+1 < len(sorted) && sorted[i+1].entry.MinTime <= l.entry.MaxTime {
+			continue
+		}
+		isolated[l] = struct{}{}
+	}
+
+	var stats []BlockStats
+	seeks := c.seeks[:0]
+LOOP:
+	for _, l := range c.seeks {
+		// Skip blocks overlapping others, partially read or not wanted.
+		if _, ok := isolated[l]; !ok || !(l.readMax < l.entry.MinTime || l.readMin > l.entry.MaxTime) || !use(&l.entry) {
+			seeks = append(seeks, l)
+			continue
+		}
+
+		for _, tr := range l.r.TombstoneRange(c.key) {
+			if tr.Min <= l.entry.MaxTime && tr.Max >= l.entry.MinTime {
+				seeks = append(seeks, l)
+				continue LOOP
+			}
+		}
+
+		s, ok := l.r.BlockStats(&l.entry)
+		if !ok {
+			seeks = append(seeks, l)
+			continue
+		}
+		stats = append(stats, s)
+		l.r.Unref()
+	}
+	for i := len(seeks); i < len(c.seeks); i++ {
+		c.seeks[i] = nil
+	}
+	c.seeks = seeks
+
+	// Descending cursors read the seeks from the end.
+	if !c.ascending {
+		for i, j := 0, len(stats)-1; i < j; i, j = i+1, j-1 {
+			stats[i], stats[j] = stats[j], stats[i]
+		}
+	}
+
+	c.seek(t)
+	return stats
+}
+
 // Next moves the cursor to the next position.
 // Data should be read by the ReadBlock functions.
 func (c *KeyCursor) Next() {
@@ -1570,6 +1747,10 @@ func (p *purger) purge() {
 
 type tsmReaders []TSMFile
 
-func (a tsmReaders) Len() int           { return len(a) }
-func (a tsmReaders) Less(i, j int) bool { return a[i].Path() < a[j].Path() }
-func (a tsmReaders) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a tsmReaders) Len() int      { return len(a) }
+func (a tsmReaders) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+// Less orders files by name rather than path since files may be in either tier.
+func (a tsmReaders) Less(i, j int) bool {
+	return filepath.Base(a[i].Path()) < filepath.Base(a[j].Path())
+}
@@ -200,6 +200,10 @@ func (*mockTSMFile) ReadBooleanBlockAt(*IndexEntry, *[]BooleanValue) ([]BooleanV
 	panic("implement me")
 }
 
+func (*mockTSMFile) ReadSketchBlockAt(*IndexEntry, *[]SketchValue) ([]SketchValue, error) {
+	panic("implement me")
+}
+
 func (*mockTSMFile) ReadFloatArrayBlockAt(*IndexEntry, *tsdb.FloatArray) error {
 	panic("implement me")
 }
@@ -219,3 +223,11 @@ func (*mockTSMFile) ReadStringArrayBlockAt(*IndexEntry, *tsdb.StringArray) error
 func (*mockTSMFile) ReadBooleanArrayBlockAt(*IndexEntry, *tsdb.BooleanArray) error {
 	panic("implement me")
 }
+
+func (*mockTSMFile) ReadSketchArrayBlockAt(*IndexEntry, *tsdb.SketchArray) error {
+	panic("implement me")
+}
+
+func (*mockTSMFile) BlockStats(*IndexEntry) (BlockStats, bool) {
+	panic("implement me")
+}
@@ -3,9 +3,11 @@ package tsm1_test
 import (
 	"context"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -166,6 +168,70 @@ func TestFileStore_SeekToAsc_Duplicate(t *testing.T) {
 	}
 }
 
+// Tests that only blocks not overlapping other blocks are answered from their
+// statistics and are no longer read by the cursor.
+func TestFileStore_TakeBlockStats(t *testing.T) {
+	for _, ascending := range []bool{true, false} {
+		t.Run(fmt.Sprintf("ascending=%v", ascending), func(t *testing.T) {
+			dir := t.TempDir()
+			fs := newTestFileStore(t, dir)
+
+			data := []keyValues{
+				keyValues{"cpu", []tsm1.Value{tsm1.NewValue(0, 1.0), tsm1.NewValue(1, 2.0)}},
+				keyValues{"cpu", []tsm1.Value{tsm1.NewValue(1, 5.0)}},
+				keyValues{"cpu", []tsm1.Value{tsm1.NewValue(10, 3.0), tsm1.NewValue(11, -4.0), tsm1.NewValue(12, 3.0)}},
+				keyValues{"cpu", []tsm1.Value{tsm1.NewValue(20, 7.0)}},
+			}
+
+			files, err := newFiles(t, dir, data...)
+			if err != nil {
+				t.Fatalf("unexpected error creating files: %v", err)
+			}
+
+			fs.Replace(nil, files)
+
+			seek := int64(0)
+			if !ascending {
+				seek = 100
+			}
+			c := fs.KeyCursor(context.Background(), []byte("cpu"), seek, ascending)
+			t.Cleanup(c.Close)
+
+			stats := c.TakeBlockStats(seek, func(entry *tsm1.IndexEntry) bool {
+				return entry.MaxTime < 20
+			})
+			exp := []tsm1.BlockStats{{
+				MinTime: 10, MaxTime: 12, Count: 3,
+				Sum: math.Float64bits(2), Min: math.Float64bits(-4), Max: math.Float64bits(3),
+				MinAt: 11, MaxAt: 10,
+			}}
+			if !reflect.DeepEqual(stats, exp) {
+				t.Fatalf("unexpected stats: got %v, exp %v", stats, exp)
+			}
+
+			var got []int64
+			buf := make([]tsm1.FloatValue, 1000)
+			for {
+				values, err := c.ReadFloatBlock(&buf)
+				if err != nil {
+					t.Fatalf("unexpected error reading values: %v", err)
+				} else if len(values) == 0 {
+					break
+				}
+				for _, v := range values {
+					got = append(got, v.UnixNano())
+				}
+				c.Next()
+			}
+
+			sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			if expTimes := []int64{0, 1, 20}; !reflect.DeepEqual(got, expTimes) {
+				t.Fatalf("unexpected times read: got %v, exp %v", got, expTimes)
+			}
+		})
+	}
+}
+
 func TestFileStore_SeekToAsc_BeforeStart(t *testing.T) {
 	dir := t.TempDir()
 	fs := newTestFileStore(t, dir)
@@ -2772,6 +2838,131 @@ func TestFileStore_CreateSnapshot(t *testing.T) {
 	}
 }
 
+// Ensures files moved to the cold tier remain readable, are found again when
+// the file store is reopened and are included in snapshots.
+func TestFileStore_MoveToCold(t *testing.T) {
+	dir, cold := t.TempDir(), filepath.Join(t.TempDir(), "cold")
+	fs := newTestFileStore(t, dir)
+	fs.WithColdDir(cold)
+
+	data := []keyValues{
+		keyValues{"cpu", []tsm1.Value{tsm1.NewValue(0, 1.0)}},
+		keyValues{"cpu", []tsm1.Value{tsm1.NewValue(1, 2.0)}},
+	}
+
+	files, err := newFiles(t, dir, data...)
+	if err != nil {
+		t.Fatalf("unexpected error creating files: %v", err)
+	}
+	fs.Replace(nil, files)
+
+	if err := fs.DeleteRange([][]byte{[]byte("cpu")}, 0, 0); err != nil {
+		t.Fatalf("unexpected error delete range: %v", err)
+	}
+
+	old := fs.Files()[0].Path()
+	if err := fs.MoveToCold([]string{old}); err != nil {
+		t.Fatalf("unexpected error moving to cold tier: %v", err)
+	}
+
+	moved := filepath.Join(cold, filepath.Base(old))
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatalf("expected original file to be removed: %v", err)
+	} else if _, err := os.Stat(moved); err != nil {
+		t.Fatalf("expected file in cold tier: %v", err)
+	}
+
+	paths := []string{moved, files[1]}
+	if got, exp := len(fs.Files()), len(paths); got != exp {
+		t.Fatalf("files length mismatch: got %v, exp %v", got, exp)
+	}
+	for i, f := range fs.Files() {
+		if got, exp := f.Path(), paths[i]; got != exp {
+			t.Fatalf("file path mismatch: got %v, exp %v", got, exp)
+		}
+	}
+
+	// The tombstone moved with the file.
+	values, err := fs.Read([]byte("cpu"), 0)
+	if err != nil {
+		t.Fatalf("unexpected error reading values: %v", err)
+	} else if got, exp := len(values), 0; got != exp {
+		t.Fatalf("values length mismatch: got %v, exp %v", got, exp)
+	}
+
+	s, err := fs.CreateSnapshot()
+	if err != nil {
+		t.Fatalf("unexpected error creating snapshot: %v", err)
+	}
+	for _, p := range paths {
+		if _, err := os.Stat(filepath.Join(s, filepath.Base(p))); err != nil {
+			t.Fatalf("unable to find file %q in snapshot: %v", p, err)
+		}
+	}
+
+	fs.Close()
+	fs = newTestFileStore(t, dir)
+	fs.WithColdDir(cold)
+	if err := fs.Open(context.Background()); err != nil {
+		t.Fatalf("unexpected error opening file store: %v", err)
+	}
+
+	if got, exp := len(fs.Files()), len(paths); got != exp {
+		t.Fatalf("files length mismatch: got %v, exp %v", got, exp)
+	} else if got, exp := fs.Files()[0].Path(), moved; got != exp {
+		t.Fatalf("file path mismatch: got %v, exp %v", got, exp)
+	}
+
+	values, err = fs.Read([]byte("cpu"), 1)
+	if err != nil {
+		t.Fatalf("unexpected error reading values: %v", err)
+	}
+	exp := data[1]
+	if got, exp := len(values), len(exp.values); got != exp {
+		t.Fatalf("value length mismatch: got %v, exp %v", got, exp)
+	}
+	for i, v := range exp.values {
+		if got, exp := values[i].Value(), v.Value(); got != exp {
+			t.Fatalf("read value mismatch(%d): got %v, exp %v", i, got, exp)
+		}
+	}
+}
+
+// Ensures a file found in both tiers after an interrupted move is only loaded
+// from the cold tier.
+func TestFileStore_Open_ColdDuplicate(t *testing.T) {
+	dir, cold := t.TempDir(), t.TempDir()
+
+	data := []keyValues{
+		keyValues{"cpu", []tsm1.Value{tsm1.NewValue(0, 1.0)}},
+	}
+	if _, err := newFiles(t, dir, data...); err != nil {
+		t.Fatalf("unexpected error creating files: %v", err)
+	}
+	files, err := newFiles(t, cold, data...)
+	if err != nil {
+		t.Fatalf("unexpected error creating files: %v", err)
+	}
+
+	fs := newTestFileStore(t, dir)
+	fs.WithColdDir(cold)
+	if err := fs.Open(context.Background()); err != nil {
+		t.Fatalf("unexpected error opening file store: %v", err)
+	}
+
+	if got, exp := len(fs.Files()), 1; got != exp {
+		t.Fatalf("files length mismatch: got %v, exp %v", got, exp)
+	} else if got, exp := fs.Files()[0].Path(), files[0]; got != exp {
+		t.Fatalf("file path mismatch: got %v, exp %v", got, exp)
+	}
+
+	if tsm, err := filepath.Glob(filepath.Join(dir, "*.tsm")); err != nil {
+		t.Fatalf("unexpected error listing files: %v", err)
+	} else if len(tsm) != 0 {
+		t.Fatalf("expected duplicate file to be removed, got %v", tsm)
+	}
+}
+
 // newTestFileStore returns a FileStore for testing. The FileStore is closed by
 // tb.Cleanup when the test and all its subtests complete.
 func newTestFileStore(tb testing.TB, dir string) *tsm1.FileStore {
@@ -12,81 +12,297 @@ import (
 
 // ReadFloatBlockAt returns the float values corresponding to the given index entry.
 func (t *TSMReader) ReadFloatBlockAt(entry *IndexEntry, vals *[]FloatValue) ([]FloatValue, error) {
+	key := t.blockCacheKey(entry)
+	if b, ok := t.blockCache.Get(key); ok {
+		if a, ok := b.(*tsdb.FloatArray); ok {
+			if cap(*vals) < a.Len() {
+				*vals = make([]FloatValue, a.Len())
+			} else {
+				*vals = (*vals)[:a.Len()]
+			}
+			for i := range a.Timestamps {
+				(*vals)[i] = FloatValue{unixnano: a.Timestamps[i], value: a.Values[i]}
+			}
+			return *vals, nil
+		}
+	}
+
 	t.mu.RLock()
 	v, err := t.accessor.readFloatBlock(entry, vals)
 	t.mu.RUnlock()
+
+	if err == nil && t.blockCache != nil {
+		a := tsdb.NewFloatArrayLen(len(v))
+		for i := range v {
+			a.Timestamps[i], a.Values[i] = v[i].unixnano, v[i].value
+		}
+		t.blockCache.Add(key, a, blockCacheSize(a))
+	}
 	return v, err
 }
 
 // ReadFloatArrayBlockAt fills vals with the float values corresponding to the given index entry.
 func (t *TSMReader) ReadFloatArrayBlockAt(entry *IndexEntry, vals *tsdb.FloatArray) error {
+	key := t.blockCacheKey(entry)
+	if b, ok := t.blockCache.Get(key); ok {
+		if a, ok := b.(*tsdb.FloatArray); ok {
+			vals.Timestamps = append(vals.Timestamps[:0], a.Timestamps...)
+			vals.Values = append(vals.Values[:0], a.Values...)
+			return nil
+		}
+	}
+
 	t.mu.RLock()
 	err := t.accessor.readFloatArrayBlock(entry, vals)
 	t.mu.RUnlock()
+
+	if err == nil && t.blockCache != nil {
+		a := &tsdb.FloatArray{
+			Timestamps: append(vals.Timestamps[:0:0], vals.Timestamps...),
+			Values:     append(vals.Values[:0:0], vals.Values...),
+		}
+		t.blockCache.Add(key, a, blockCacheSize(a))
+	}
 	return err
 }
 
 // ReadIntegerBlockAt returns the integer values corresponding to the given index entry.
 func (t *TSMReader) ReadIntegerBlockAt(entry *IndexEntry, vals *[]IntegerValue) ([]IntegerValue, error) {
+	key := t.blockCacheKey(entry)
+	if b, ok := t.blockCache.Get(key); ok {
+		if a, ok := b.(*tsdb.IntegerArray); ok {
+			if cap(*vals) < a.Len() {
+				*vals = make([]IntegerValue, a.Len())
+			} else {
+				*vals = (*vals)[:a.Len()]
+			}
+			for i := range a.Timestamps {
+				(*vals)[i] = IntegerValue{unixnano: a.Timestamps[i], value: a.Values[i]}
+			}
+			return *vals, nil
+		}
+	}
+
 	t.mu.RLock()
 	v, err := t.accessor.readIntegerBlock(entry, vals)
 	t.mu.RUnlock()
+
+	if err == nil && t.blockCache != nil {
+		a := tsdb.NewIntegerArrayLen(len(v))
+		for i := range v {
+			a.Timestamps[i], a.Values[i] = v[i].unixnano, v[i].value
+		}
+		t.blockCache.Add(key, a, blockCacheSize(a))
+	}
 	return v, err
 }
 
 // ReadIntegerArrayBlockAt fills vals with the integer values corresponding to the given index entry.
 func (t *TSMReader) ReadIntegerArrayBlockAt(entry *IndexEntry, vals *tsdb.IntegerArray) error {
+	key := t.blockCacheKey(entry)
+	if b, ok := t.blockCache.Get(key); ok {
+		if a, ok := b.(*tsdb.IntegerArray); ok {
+			vals.Timestamps = append(vals.Timestamps[:0], a.Timestamps...)
+			vals.Values = append(vals.Values[:0], a.Values...)
+			return nil
+		}
+	}
+
 	t.mu.RLock()
 	err := t.accessor.readIntegerArrayBlock(entry, vals)
 	t.mu.RUnlock()
+
+	if err == nil && t.blockCache != nil {
+		a := &tsdb.IntegerArray{
+			Timestamps: append(vals.Timestamps[:0:0], vals.Timestamps...),
+			Values:     append(vals.Values[:0:0], vals.Values...),
+		}
+		t.blockCache.Add(key, a, blockCacheSize(a))
+	}
 	return err
 }
 
 // ReadUnsignedBlockAt returns the unsigned values corresponding to the given index entry.
 func (t *TSMReader) ReadUnsignedBlockAt(entry *IndexEntry, vals *[]UnsignedValue) ([]UnsignedValue, error) {
+	key := t.blockCacheKey(entry)
+	if b, ok := t.blockCache.Get(key); ok {
+		if a, ok := b.(*tsdb.UnsignedArray); ok {
+			if cap(*vals) < a.Len() {
+				*vals = make([]UnsignedValue, a.Len())
+			} else {
+				*vals = (*vals)[:a.Len()]
+			}
+			for i := range a.Timestamps {
+				(*vals)[i] = UnsignedValue{unixnano: a.Timestamps[i], value: a.Values[i]}
+			}
+			return *vals, nil
+		}
+	}
+
 	t.mu.RLock()
 	v, err := t.accessor.readUnsignedBlock(entry, vals)
 	t.mu.RUnlock()
+
+	if err == nil && t.blockCache != nil {
+		a := tsdb.NewUnsignedArrayLen(len(v))
+		for i := range v {
+			a.Timestamps[i], a.Values[i] = v[i].unixnano, v[i].value
+		}
+		t.blockCache.Add(key, a, blockCacheSize(a))
+	}
 	return v, err
 }
 
 // ReadUnsignedArrayBlockAt fills vals with the unsigned values corresponding to the given index entry.
 func (t *TSMReader) ReadUnsignedArrayBlockAt(entry *IndexEntry, vals *tsdb.UnsignedArray) error {
+	key := t.blockCacheKey(entry)
+	if b, ok := t.blockCache.Get(key); ok {
+		if a, ok := b.(*tsdb.UnsignedArray); ok {
+			vals.Timestamps = append(vals.Timestamps[:0], a.Timestamps...)
+			vals.Values = append(vals.Values[:0], a.Values...)
+			return nil
+		}
+	}
+
 	t.mu.RLock()
 	err := t.accessor.readUnsignedArrayBlock(entry, vals)
 	t.mu.RUnlock()
+
+	if err == nil && t.blockCache != nil {
+		a := &tsdb.UnsignedArray{
+			Timestamps: append(vals.Timestamps[:0:0], vals.Timestamps...),
+			Values:     append(vals.Values[:0:0], vals.Values...),
+		}
+		t.blockCache.Add(key, a, blockCacheSize(a))
+	}
 	return err
 }
 
 // ReadStringBlockAt returns the string values corresponding to the given index entry.
 func (t *TSMReader) ReadStringBlockAt(entry *IndexEntry, vals *[]StringValue) ([]StringValue, error) {
+	key := t.blockCacheKey(entry)
+	if b, ok := t.blockCache.Get(key); ok {
+		if a, ok := b.(*tsdb.StringArray); ok {
+			if cap(*vals) < a.Len() {
+				*vals = make([]StringValue, a.Len())
+			} else {
+				*vals = (*vals)[:a.Len()]
+			}
+			for i := range a.Timestamps {
+				(*vals)[i] = StringValue{unixnano: a.Timestamps[i], value: a.Values[i]}
+			}
+			return *vals, nil
+		}
+	}
+
 	t.mu.RLock()
 	v, err := t.accessor.readStringBlock(entry, vals)
 	t.mu.RUnlock()
+
+	if err == nil && t.blockCache != nil {
+		a := tsdb.NewStringArrayLen(len(v))
+		for i := range v {
+			a.Timestamps[i], a.Values[i] = v[i].unixnano, v[i].value
+		}
+		t.blockCache.Add(key, a, blockCacheSize(a))
+	}
 	return v, err
 }
 
 // ReadStringArrayBlockAt fills vals with the string values corresponding to the given index entry.
 func (t *TSMReader) ReadStringArrayBlockAt(entry *IndexEntry, vals *tsdb.StringArray) error {
+	key := t.blockCacheKey(entry)
+	if b, ok := t.blockCache.Get(key); ok {
+		if a, ok := b.(*tsdb.StringArray); ok {
+			vals.Timestamps = append(vals.Timestamps[:0], a.Timestamps...)
+			vals.Values = append(vals.Values[:0], a.Values...)
+			return nil
+		}
+	}
+
 	t.mu.RLock()
 	err := t.accessor.readStringArrayBlock(entry, vals)
 	t.mu.RUnlock()
+
+	if err == nil && t.blockCache != nil {
+		a := &tsdb.StringArray{
+			Timestamps: append(vals.Timestamps[:0:0], vals.Timestamps...),
+			Values:     append(vals.Values[:0:0], vals.Values...),
+		}
+		t.blockCache.Add(key, a, blockCacheSize(a))
+	}
 	return err
 }
 
 // ReadBooleanBlockAt returns the boolean values corresponding to the given index entry.
 func (t *TSMReader) ReadBooleanBlockAt(entry *IndexEntry, vals *[]BooleanValue) ([]BooleanValue, error) {
+	key := t.blockCacheKey(entry)
+	if b, ok := t.blockCache.Get(key); ok {
+		if a, ok := b.(*tsdb.BooleanArray); ok {
+			if cap(*vals) < a.Len() {
+				*vals = make([]BooleanValue, a.Len())
+			} else {
+				*vals = (*vals)[:a.Len()]
+			}
+			for i := range a.Timestamps {
+				(*vals)[i] = BooleanValue{unixnano: a.Timestamps[i], value: a.Values[i]}
+			}
+			return *vals, nil
+		}
+	}
+
 	t.mu.RLock()
 	v, err := t.accessor.readBooleanBlock(entry, vals)
 	t.mu.RUnlock()
+
+	if err == nil && t.blockCache != nil {
+		a := tsdb.NewBooleanArrayLen(len(v))
+		for i := range v {
+			a.Timestamps[i], a.Values[i] = v[i].unixnano, v[i].value
+		}
+		t.blockCache.Add(key, a, blockCacheSize(a))
+	}
 	return v, err
 }
 
 // ReadBooleanArrayBlockAt fills vals with the boolean values corresponding to the given index entry.
 func (t *TSMReader) ReadBooleanArrayBlockAt(entry *IndexEntry, vals *tsdb.BooleanArray) error {
+	key := t.blockCacheKey(entry)
+	if b, ok := t.blockCache.Get(key); ok {
+		if a, ok := b.(*tsdb.BooleanArray); ok {
+			vals.Timestamps = append(vals.Timestamps[:0], a.Timestamps...)
+			vals.Values = append(vals.Values[:0], a.Values...)
+			return nil
+		}
+	}
+
 	t.mu.RLock()
 	err := t.accessor.readBooleanArrayBlock(entry, vals)
 	t.mu.RUnlock()
+
+	if err == nil && t.blockCache != nil {
+		a := &tsdb.BooleanArray{
+			Timestamps: append(vals.Timestamps[:0:0], vals.Timestamps...),
+			Values:     append(vals.Values[:0:0], vals.Values...),
+		}
+		t.blockCache.Add(key, a, blockCacheSize(a))
+	}
+	return err
+}
+
+// ReadSketchBlockAt returns the sketch values corresponding to the given index entry.
+func (t *TSMReader) ReadSketchBlockAt(entry *IndexEntry, vals *[]SketchValue) ([]SketchValue, error) {
+	t.mu.RLock()
+	v, err := t.accessor.readSketchBlock(entry, vals)
+	t.mu.RUnlock()
+	return v, err
+}
+
+// ReadSketchArrayBlockAt fills vals with the sketch values corresponding to the given index entry.
+func (t *TSMReader) ReadSketchArrayBlockAt(entry *IndexEntry, vals *tsdb.SketchArray) error {
+	t.mu.RLock()
+	err := t.accessor.readSketchArrayBlock(entry, vals)
+	t.mu.RUnlock()
 	return err
 }
 
@@ -107,7 +323,10 @@ type blockAccessor interface {
 	readStringArrayBlock(entry *IndexEntry, values *tsdb.StringArray) error
 	readBooleanBlock(entry *IndexEntry, values *[]BooleanValue) ([]BooleanValue, error)
 	readBooleanArrayBlock(entry *IndexEntry, values *tsdb.BooleanArray) error
+	readSketchBlock(entry *IndexEntry, values *[]SketchValue) ([]SketchValue, error)
+	readSketchArrayBlock(entry *IndexEntry, values *tsdb.SketchArray) error
 	readBytes(entry *IndexEntry, buf []byte) (uint32, []byte, error)
+	blockStats(entry *IndexEntry) (BlockStats, bool)
 	rename(path string) error
 	path() string
 	close() error
@@ -283,3 +502,37 @@ func (m *mmapAccessor) readBooleanArrayBlock(entry *IndexEntry, values *tsdb.Boo
 
 	return err
 }
+
+func (m *mmapAccessor) readSketchBlock(entry *IndexEntry, values *[]SketchValue) ([]SketchValue, error) {
+	m.incAccess()
+
+	m.mu.RLock()
+	if int64(len(m.b)) < entry.Offset+int64(entry.Size) {
+		m.mu.RUnlock()
+		return nil, ErrTSMClosed
+	}
+
+	a, err := DecodeSketchBlock(m.b[entry.Offset+4:entry.Offset+int64(entry.Size)], values)
+	m.mu.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (m *mmapAccessor) readSketchArrayBlock(entry *IndexEntry, values *tsdb.SketchArray) error {
+	m.incAccess()
+
+	m.mu.RLock()
+	if int64(len(m.b)) < entry.Offset+int64(entry.Size) {
+		m.mu.RUnlock()
+		return ErrTSMClosed
+	}
+
+	err := DecodeSketchArrayBlock(m.b[entry.Offset+4:entry.Offset+int64(entry.Size)], values)
+	m.mu.RUnlock()
+
+	return err
+}
@@ -50,8 +50,18 @@ type TSMReader struct {
 
 	// deleteMu limits concurrent deletes
 	deleteMu sync.Mutex
+
+	// blockCache caches the decoded blocks of the file under its id and the
+	// current blockCacheGeneration, which is incremented when tombstones are
+	// applied.
+	blockCache           *tsdb.BlockCache
+	id                   uint64
+	blockCacheGeneration uint64 // accessed atomically
 }
 
+// lastTSMReaderID is the last ID assigned to a TSMReader.
+var lastTSMReaderID uint64
+
 // TSMIndex represent the index section of a TSM file.  The index records all
 // blocks, their locations, sizes, min and max times.
 type TSMIndex interface {
@@ -204,6 +214,15 @@ func (b *BlockIterator) Read() (key []byte, minTime int64, maxTime int64, typ by
 	return b.key, b.entries[0].MinTime, b.entries[0].MaxTime, b.typ, checksum, buf, err
 }
 
+// blockStats returns the statistics of the block returned by the last call to
+// Read, if the file has them.
+func (b *BlockIterator) blockStats() (BlockStats, bool) {
+	if len(b.entries) == 0 {
+		return BlockStats{}, false
+	}
+	return b.r.BlockStats(&b.entries[0])
+}
+
 // Err returns any errors encounter during iteration.
 func (b *BlockIterator) Err() error {
 	return b.err
@@ -218,9 +237,16 @@ var WithMadviseWillNeed = func(willNeed bool) tsmReaderOption {
 	}
 }
 
+// WithBlockCache is an option for specifying the cache of decoded blocks.
+var WithBlockCache = func(c *tsdb.BlockCache) tsmReaderOption {
+	return func(r *TSMReader) {
+		r.blockCache = c
+	}
+}
+
 // NewTSMReader returns a new TSMReader from the given file.
 func NewTSMReader(f *os.File, options ...tsmReaderOption) (*TSMReader, error) {
-	t := &TSMReader{}
+	t := &TSMReader{id: atomic.AddUint64(&lastTSMReaderID, 1)}
 	for _, option := range options {
 		option(t)
 	}
@@ -356,14 +382,62 @@ func (t *TSMReader) ReadBytes(e *IndexEntry, b []byte) (uint32, []byte, error) {
 	return n, v, err
 }
 
+// BlockStats returns the statistics of the block at entry.  It returns false if
+// the file has no statistics for the block.
+func (t *TSMReader) BlockStats(entry *IndexEntry) (BlockStats, bool) {
+	t.mu.RLock()
+	s, ok := t.accessor.blockStats(entry)
+	t.mu.RUnlock()
+	return s, ok
+}
+
 // Type returns the type of values stored at the given key.
 func (t *TSMReader) Type(key []byte) (byte, error) {
 	return t.index.Type(key)
 }
 
+// blockCacheKey returns the key of the block at entry in the block cache.
+func (t *TSMReader) blockCacheKey(entry *IndexEntry) tsdb.BlockCacheKey {
+	return tsdb.BlockCacheKey{
+		File:       t.id,
+		Generation: atomic.LoadUint64(&t.blockCacheGeneration),
+		Offset:     entry.Offset,
+	}
+}
+
+// invalidateBlockCache removes the blocks of the file from the block cache.
+// Blocks being decoded are cached under the previous generation and are not
+// returned anymore.
+func (t *TSMReader) invalidateBlockCache() {
+	atomic.AddUint64(&t.blockCacheGeneration, 1)
+	t.blockCache.RemoveFile(t.id)
+}
+
+// blockCacheSize returns the number of bytes used by a decoded block.
+func blockCacheSize(block interface{}) uint64 {
+	switch a := block.(type) {
+	case *tsdb.FloatArray:
+		return uint64(a.Len()) * 16
+	case *tsdb.IntegerArray:
+		return uint64(a.Len()) * 16
+	case *tsdb.UnsignedArray:
+		return uint64(a.Len()) * 16
+	case *tsdb.BooleanArray:
+		return uint64(a.Len()) * 9
+	case *tsdb.StringArray:
+		n := uint64(a.Len()) * 24
+		for _, v := range a.Values {
+			n += uint64(len(v))
+		}
+		return n
+	}
+	return 0
+}
+
 // Close closes the TSMReader.
 func (t *TSMReader) Close() error {
 	t.refsWG.Wait()
+	t.invalidateBlockCache()
 
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -470,6 +544,7 @@ func (t *TSMReader) Delete(keys [][]byte) error {
 	}
 
 	t.index.Delete(keys)
+	t.invalidateBlockCache()
 	return nil
 }
 
@@ -621,6 +696,7 @@ func (b *batchDelete) Commit() error {
 		return err
 	}
 
+	defer b.r.invalidateBlockCache()
 	return b.r.applyTombstones()
 }
 
@@ -1311,6 +1387,9 @@ type mmapAccessor struct {
 	b  []byte
 	f  *os.File
 
+	// stats is the block statistics section of b, if the file has one.
+	stats []byte
+
 	index *indirectIndex
 }
 
@@ -1360,6 +1439,7 @@ func (m *mmapAccessor) init() (*indirectIndex, error) {
 	if err := m.index.UnmarshalBinary(m.b[indexStart:indexOfsPos]); err != nil {
 		return nil, err
 	}
+	m.stats = blockStatsSection(m.b, indexStart)
 
 	// Allow resources to be freed immediately if requested
 	m.incAccess()
@@ -1437,6 +1517,12 @@ func (m *mmapAccessor) rename(path string) error {
 		return err
 	}
 
+	// The statistics section refers to the previous mapping.
+	m.stats = nil
+	if len(m.b) >= 8 {
+		m.stats = blockStatsSection(m.b, binary.BigEndian.Uint64(m.b[len(m.b)-8:]))
+	}
+
 	if m.mmapWillNeed {
 		return madviseWillNeed(m.b)
 	}
@@ -1487,6 +1573,19 @@ func (m *mmapAccessor) readBytes(entry *IndexEntry, b []byte) (uint32, []byte, e
 	return crc, block, nil
 }
 
+// blockStats returns the statistics of the block at entry, if the file has them.
+func (m *mmapAccessor) blockStats(entry *IndexEntry) (BlockStats, bool) {
+	m.incAccess()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.stats == nil {
+		return BlockStats{}, false
+	}
+	return findBlockStats(m.stats, entry)
+}
+
 // readAll returns all values for a key in all blocks.
 func (m *mmapAccessor) readAll(key []byte) ([]Value, error) {
 	m.incAccess()
@@ -1557,6 +1656,7 @@ func (m *mmapAccessor) close() error {
 	}
 
 	m.b = nil
+	m.stats = nil
 	return m.f.Close()
 }
 
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/influxdata/influxdb/v2/tsdb"
 	"github.com/stretchr/testify/require"
 )
 
@@ -58,6 +59,81 @@ func TestTSMReader_Type(t *testing.T) {
 	}
 }
 
+func TestTSMReader_BlockStats(t *testing.T) {
+	dir := t.TempDir()
+	f := mustTempFile(dir)
+
+	w, err := NewTSMWriter(f)
+	if err != nil {
+		t.Fatalf("unexpected error creating writer: %v", err)
+	}
+
+	// Keys must be written in sorted order.
+	var data = []struct {
+		key    string
+		values []Value
+	}{
+		{"float", []Value{NewValue(1, 2.5), NewValue(2, -1.0), NewValue(3, 4.0), NewValue(4, -1.0)}},
+		{"int", []Value{NewValue(1, int64(-3)), NewValue(2, int64(7)), NewValue(3, int64(7))}},
+		{"string", []Value{NewValue(1, "foo")}},
+		{"uint", []Value{NewValue(5, uint64(10)), NewValue(6, ^uint64(0))}},
+	}
+	for _, d := range data {
+		if err := w.Write([]byte(d.key), d.values); err != nil {
+			t.Fatalf("unexpected error writing: %v", err)
+		}
+	}
+
+	if err := w.WriteIndex(); err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+
+	f, err = os.Open(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error opening: %v", err)
+	}
+	r, err := NewTSMReader(f)
+	if err != nil {
+		t.Fatalf("unexpected error created reader: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	stats := func(key string) (BlockStats, bool) {
+		entries := r.Entries([]byte(key))
+		require.Len(t, entries, 1)
+		return r.BlockStats(&entries[0])
+	}
+
+	s, ok := stats("float")
+	require.True(t, ok)
+	require.Equal(t, BlockStats{
+		MinTime: 1, MaxTime: 4, Count: 4,
+		Sum: math.Float64bits(4.5), Min: math.Float64bits(-1), Max: math.Float64bits(4),
+		MinAt: 2, MaxAt: 3,
+	}, s)
+
+	s, ok = stats("int")
+	require.True(t, ok)
+	require.Equal(t, BlockStats{
+		MinTime: 1, MaxTime: 3, Count: 3,
+		Sum: 11, Min: ^uint64(2), Max: 7,
+		MinAt: 1, MaxAt: 2,
+	}, s)
+
+	s, ok = stats("uint")
+	require.True(t, ok)
+	require.Equal(t, uint64(9), s.Sum)
+	require.Equal(t, ^uint64(0), s.Max)
+	require.Equal(t, int64(6), s.MaxAt)
+
+	_, ok = stats("string")
+	require.False(t, ok)
+}
+
 func TestTSMReader_MMAP_ReadAll(t *testing.T) {
 	dir := t.TempDir()
 	f := mustTempFile(dir)
@@ -287,6 +363,65 @@ func TestTSMReader_MMAP_Keys(t *testing.T) {
 	}
 }
 
+func TestTSMReader_BlockCache(t *testing.T) {
+	dir := t.TempDir()
+	f := mustTempFile(dir)
+
+	w, err := NewTSMWriter(f)
+	if err != nil {
+		t.Fatalf("unexpected error creating writer: %v", err)
+	}
+	for _, key := range []string{"cpu", "mem"} {
+		if err := w.Write([]byte(key), []Value{NewValue(1, 1.5), NewValue(2, 2.5)}); err != nil {
+			t.Fatalf("unexpected error writing: %v", err)
+		}
+	}
+	if err := w.WriteIndex(); err != nil {
+		t.Fatalf("unexpected error writing index: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+
+	f, err = os.Open(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error opening: %v", err)
+	}
+	cache := tsdb.NewBlockCache(1 << 20)
+	r, err := NewTSMReader(f, WithBlockCache(cache))
+	if err != nil {
+		t.Fatalf("unexpected error created reader: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	entries := r.Entries([]byte("cpu"))
+	require.Len(t, entries, 1)
+
+	// The first read decodes the block, the others are served by the cache.
+	for i := 0; i < 2; i++ {
+		var a tsdb.FloatArray
+		require.NoError(t, r.ReadFloatArrayBlockAt(&entries[0], &a))
+		require.Equal(t, []int64{1, 2}, a.Timestamps)
+		require.Equal(t, []float64{1.5, 2.5}, a.Values)
+
+		// Modifying the values read does not modify the cached block.
+		a.Values[0] = -1
+	}
+	var buf []FloatValue
+	values, err := r.ReadFloatBlockAt(&entries[0], &buf)
+	require.NoError(t, err)
+	require.Equal(t, []FloatValue{{unixnano: 1, value: 1.5}, {unixnano: 2, value: 2.5}}, values)
+
+	stats := cache.Statistics()
+	require.Equal(t, uint64(2), stats.Hits)
+	require.Equal(t, uint64(1), stats.Misses)
+	require.Equal(t, 1, stats.Blocks)
+
+	// Tombstones invalidate the cached blocks of the file.
+	require.NoError(t, r.Delete([][]byte{[]byte("mem")}))
+	require.Equal(t, 0, cache.Statistics().Blocks)
+}
+
 func TestTSMReader_MMAP_Tombstone(t *testing.T) {
 	dir := t.TempDir()
 	f := mustTempFile(dir)
@@ -0,0 +1,130 @@
+package tsm1
+
+import (
+	"bytes"
+	"math"
+
+	"github.com/influxdata/influxdb/v2/tsdb"
+)
+
+// retentionKeyIterator drops the values of a KeyIterator that are expired by the
+// series retention rules of a shard.
+type retentionKeyIterator struct {
+	iter        KeyIterator
+	retention   *tsdb.SeriesRetention
+	now         int64
+	stringCodec tsdb.BlockCodec
+
+	// series and cutoff cache the cutoff of the current series.
+	series []byte
+	cutoff int64
+
+	key              []byte
+	minTime, maxTime int64
+	block            []byte
+	values           []Value
+
+	err error
+}
+
+func newRetentionKeyIterator(iter KeyIterator, retention *tsdb.SeriesRetention, now int64, stringCodec tsdb.BlockCodec) *retentionKeyIterator {
+	return &retentionKeyIterator{
+		iter:        iter,
+		retention:   retention,
+		now:         now,
+		stringCodec: stringCodec,
+	}
+}
+
+// Next returns true if there are any values remaining in the iterator.
+func (k *retentionKeyIterator) Next() bool {
+	for k.err == nil && k.iter.Next() {
+		key, minTime, maxTime, block, err := k.iter.Read()
+		if err != nil {
+			k.err = err
+			return false
+		}
+
+		series, _ := SeriesAndFieldFromCompositeKey(key)
+		if k.series == nil || !bytes.Equal(series, k.series) {
+			k.series = append(k.series[:0], series...)
+			k.cutoff = k.retention.Cutoff(series, k.now)
+		}
+
+		k.key, k.minTime, k.maxTime, k.block = key, minTime, maxTime, block
+		if minTime >= k.cutoff {
+			return true
+		} else if maxTime < k.cutoff {
+			continue
+		}
+
+		// The block is partially expired and must be re-encoded.
+		if k.expire() {
+			return true
+		}
+	}
+	return false
+}
+
+// expire removes the expired values of the current block, returning false if
+// there are none left.
+func (k *retentionKeyIterator) expire() bool {
+	values, err := DecodeBlock(k.block, k.values[:0])
+	if err != nil {
+		k.err = err
+		return false
+	}
+	k.values = values
+
+	values = Values(values).Exclude(math.MinInt64, k.cutoff-1)
+	if len(values) == 0 {
+		return false
+	}
+
+	var b []byte
+	if _, ok := values[0].(StringValue); ok {
+		a := tsdb.NewStringArrayLen(len(values))
+		for i, v := range values {
+			a.Timestamps[i], a.Values[i] = v.UnixNano(), v.(StringValue).value
+		}
+		b, err = EncodeStringArrayBlockUsing(a, nil, k.stringCodec)
+	} else {
+		b, err = Values(values).Encode(nil)
+	}
+	if err != nil {
+		k.err = err
+		return false
+	}
+
+	k.minTime, k.maxTime, k.block = values[0].UnixNano(), values[len(values)-1].UnixNano(), b
+	return true
+}
+
+// Read returns the key, time range, and raw data for the next block,
+// or any error that occurred.
+func (k *retentionKeyIterator) Read() ([]byte, int64, int64, []byte, error) {
+	if k.err != nil {
+		return nil, 0, 0, nil, k.err
+	}
+	return k.key, k.minTime, k.maxTime, k.block, nil
+}
+
+// Close closes the iterator.
+func (k *retentionKeyIterator) Close() error {
+	k.values = nil
+	return k.iter.Close()
+}
+
+// Err returns any errors encountered during iteration.
+func (k *retentionKeyIterator) Err() error {
+	if k.err != nil {
+		return k.err
+	}
+	return k.iter.Err()
+}
+
+// EstimatedIndexSize returns the estimated size of the index of the
+// underlying iterator.
+func (k *retentionKeyIterator) EstimatedIndexSize() int {
+	return k.iter.EstimatedIndexSize()
+}
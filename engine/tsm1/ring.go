@@ -89,7 +89,7 @@ func (r *ring) entry(key []byte) *entry {
 // write writes values to the entry in the ring's partition associated with key.
 // If no entry exists for the key then one will be created.
 // write is safe for use by multiple goroutines.
-func (r *ring) write(key []byte, values Values) (bool, error) {
+func (r *ring) write(key []byte, values Values) (bool, int, error) {
 	return r.getPartition(key).write(key, values)
 }
 
@@ -216,15 +216,16 @@ func (p *partition) entry(key []byte) *entry {
 }
 
 // write writes the values to the entry in the partition, creating the entry
-// if it does not exist.
+// if it does not exist.  It returns the number of values written out of order.
 // write is safe for use by multiple goroutines.
-func (p *partition) write(key []byte, values Values) (bool, error) {
+func (p *partition) write(key []byte, values Values) (bool, int, error) {
 	p.mu.RLock()
 	e := p.store[string(key)]
 	p.mu.RUnlock()
 	if e != nil {
 		// Hot path.
-		return false, e.add(values)
+		n, err := e.add(values)
+		return false, n, err
 	}
 
 	p.mu.Lock()
@@ -232,17 +233,18 @@ func (p *partition) write(key []byte, values Values) (bool, error) {
 
 	// Check again.
 	if e = p.store[string(key)]; e != nil {
-		return false, e.add(values)
+		n, err := e.add(values)
+		return false, n, err
 	}
 
 	// Create a new entry using a preallocated size if we have a hint available.
-	e, err := newEntryValues(values)
+	e, n, err := newEntryValues(values)
 	if err != nil {
-		return false, err
+		return false, 0, err
 	}
 
 	p.store[string(key)] = e
-	return true, nil
+	return true, n, nil
 }
 
 // remove deletes the entry associated with the provided key.
@@ -0,0 +1,93 @@
+package tsm1
+
+// Sketch encoding stores each quantile sketch in its binary encoding the same way
+// strings are stored: each encoded sketch is appended to a byte slice prefixed with
+// a variable byte length, and the bytes are compressed using snappy with a 1 byte
+// header indicating the type of encoding.
+//
+// Sketches are written through line protocol as string field values holding
+// their text encoding, see sketch.Sketch.MarshalText, to the fields configured
+// with the sketch-fields option.  Like other values, a sketch written to a
+// series at the same timestamp as an existing one replaces it, so replaying the
+// WAL is idempotent.  Sketches are merged when they are read, by the sum window
+// aggregate.
+
+import (
+	"fmt"
+
+	"github.com/influxdata/influxdb/v2/tsdb/sketch"
+)
+
+// SketchEncoder encodes multiple sketches into a byte slice.
+type SketchEncoder struct {
+	enc StringEncoder
+	buf []byte
+}
+
+// NewSketchEncoder returns a new SketchEncoder with an initial buffer ready to hold sz bytes.
+func NewSketchEncoder(sz int) SketchEncoder {
+	return SketchEncoder{enc: NewStringEncoder(sz)}
+}
+
+// Flush is no-op
+func (e *SketchEncoder) Flush() {}
+
+// Reset sets the encoder back to its initial state.
+func (e *SketchEncoder) Reset() {
+	e.enc.Reset()
+}
+
+// Write encodes s to the underlying buffer.
+func (e *SketchEncoder) Write(s *sketch.Sketch) {
+	e.buf = s.AppendBinary(e.buf[:0])
+	e.enc.writeBytes(e.buf)
+}
+
+// Bytes returns a copy of the underlying buffer.
+func (e *SketchEncoder) Bytes() ([]byte, error) {
+	return e.enc.Bytes()
+}
+
+// SketchDecoder decodes a byte slice into sketches.
+type SketchDecoder struct {
+	dec StringDecoder
+	err error
+}
+
+// SetBytes initializes the decoder with bytes to read from.
+// This must be called before calling any other method.
+func (e *SketchDecoder) SetBytes(b []byte) error {
+	e.err = nil
+	if err := e.dec.SetBytes(b); err != nil {
+		return fmt.Errorf("failed to decode sketch block: %v", err)
+	}
+	return nil
+}
+
+// Next returns true if there are any values remaining to be decoded.
+func (e *SketchDecoder) Next() bool {
+	return e.err == nil && e.dec.Next()
+}
+
+// Read returns the next value from the decoder.
+func (e *SketchDecoder) Read() *sketch.Sketch {
+	b := e.dec.readBytes()
+	if err := e.dec.Error(); err != nil {
+		return nil
+	}
+
+	s, err := sketch.Unmarshal(b)
+	if err != nil {
+		e.err = fmt.Errorf("SketchDecoder: %v", err)
+		return nil
+	}
+	return s
+}
+
+// Error returns the last error encountered by the decoder.
+func (e *SketchDecoder) Error() error {
+	if e.err != nil {
+		return e.err
+	}
+	return e.dec.Error()
+}
@@ -0,0 +1,85 @@
+package tsm1
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/influxdata/influxdb/v2/tsdb/sketch"
+)
+
+func newTestSketch(values ...float64) *sketch.Sketch {
+	s := sketch.NewDefault()
+	for _, v := range values {
+		s.Add(v)
+	}
+	return s
+}
+
+func Test_SketchEncoder_Single(t *testing.T) {
+	enc := NewSketchEncoder(1024)
+	v1 := newTestSketch(1, 2, 3, 250)
+	enc.Write(v1)
+	b, err := enc.Bytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var dec SketchDecoder
+	if err := dec.SetBytes(b); err != nil {
+		t.Fatalf("unexpected error creating sketch decoder: %v", err)
+	}
+	if !dec.Next() {
+		t.Fatalf("unexpected next value: got false, exp true")
+	}
+
+	if got := dec.Read(); !reflect.DeepEqual(got, v1) {
+		t.Fatalf("unexpected value: got %v, exp %v", got, v1)
+	}
+	if dec.Next() {
+		t.Fatalf("unexpected next value: got true, exp false")
+	}
+	if err := dec.Error(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func Test_SketchDecoder_Corrupt(t *testing.T) {
+	enc := NewStringEncoder(1024)
+	enc.Write("not a sketch")
+	b, err := enc.Bytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var dec SketchDecoder
+	if err := dec.SetBytes(b); err != nil {
+		t.Fatalf("unexpected error creating sketch decoder: %v", err)
+	}
+	for dec.Next() {
+		dec.Read()
+	}
+	if dec.Error() == nil {
+		t.Fatalf("expected error decoding corrupt sketch")
+	}
+}
+
+func TestSketchArrayEncodeAll_Multi(t *testing.T) {
+	src := []*sketch.Sketch{
+		newTestSketch(1),
+		newTestSketch(),
+		newTestSketch(-10, 0, 10, 1e6),
+	}
+
+	b, err := SketchArrayEncodeAll(src, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := SketchArrayDecodeAll(b, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, src) {
+		t.Fatalf("unexpected values:\n\tgot: %v\n\texp: %v", got, src)
+	}
+}
@@ -3,13 +3,15 @@ package tsm1
 // String encoding uses snappy compression to compress each string.  Each string is
 // appended to byte slice prefixed with a variable byte length followed by the string
 // bytes.  The bytes are compressed using snappy compressor and a 1 byte header is used
-// to indicate the type of encoding.
+// to indicate the type of encoding.  Other registered block codecs may be used in place
+// of snappy, in which case the upper 4 bits of the header hold the ID of the codec.
 
 import (
 	"encoding/binary"
 	"fmt"
 
 	"github.com/golang/snappy"
+	"github.com/influxdata/influxdb/v2/tsdb"
 )
 
 // Note: an uncompressed format is not yet implemented.
@@ -21,6 +23,9 @@ const stringCompressedSnappy = 1
 type StringEncoder struct {
 	// The encoded bytes
 	bytes []byte
+
+	// The codec used to compress the encoded bytes. Snappy is used if nil.
+	codec tsdb.BlockCodec
 }
 
 // NewStringEncoder returns a new StringEncoder with an initial buffer ready to hold sz bytes.
@@ -38,6 +43,12 @@ func (e *StringEncoder) Reset() {
 	e.bytes = e.bytes[:0]
 }
 
+// SetCodec sets the codec used to compress the encoded bytes.
+// A nil codec uses snappy.
+func (e *StringEncoder) SetCodec(codec tsdb.BlockCodec) {
+	e.codec = codec
+}
+
 // Write encodes s to the underlying buffer.
 func (e *StringEncoder) Write(s string) {
 	b := make([]byte, 10)
@@ -49,12 +60,28 @@ func (e *StringEncoder) Write(s string) {
 	e.bytes = append(e.bytes, s...)
 }
 
+// writeBytes encodes b to the underlying buffer like a string.
+func (e *StringEncoder) writeBytes(b []byte) {
+	var buf [binary.MaxVarintLen64]byte
+	i := binary.PutUvarint(buf[:], uint64(len(b)))
+	e.bytes = append(e.bytes, buf[:i]...)
+	e.bytes = append(e.bytes, b...)
+}
+
 // Bytes returns a copy of the underlying buffer.
 func (e *StringEncoder) Bytes() ([]byte, error) {
 	// Compress the currently appended bytes using snappy and prefix with
 	// a 1 byte header for future extension
-	data := snappy.Encode(nil, e.bytes)
-	return append([]byte{stringCompressedSnappy << 4}, data...), nil
+	if e.codec == nil || e.codec.ID() == stringCompressedSnappy {
+		data := snappy.Encode(nil, e.bytes)
+		return append([]byte{stringCompressedSnappy << 4}, data...), nil
+	}
+
+	data, err := e.codec.Encode(nil, e.bytes)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{e.codec.ID() << 4}, data...), nil
 }
 
 // StringDecoder decodes a byte slice into strings.
@@ -68,12 +95,11 @@ type StringDecoder struct {
 // SetBytes initializes the decoder with bytes to read from.
 // This must be called before calling any other method.
 func (e *StringDecoder) SetBytes(b []byte) error {
-	// First byte stores the encoding type, only have snappy format
-	// currently so ignore for now.
+	// First byte stores the encoding type.
 	var data []byte
 	if len(b) > 0 {
 		var err error
-		data, err = snappy.Decode(nil, b[1:])
+		data, err = decodeStringBlockBytes(b)
 		if err != nil {
 			return fmt.Errorf("failed to decode string block: %v", err.Error())
 		}
@@ -99,11 +125,17 @@ func (e *StringDecoder) Next() bool {
 
 // Read returns the next value from the decoder.
 func (e *StringDecoder) Read() string {
+	return string(e.readBytes())
+}
+
+// readBytes returns the bytes of the next value from the decoder.  The bytes
+// are only valid until the next call to SetBytes.
+func (e *StringDecoder) readBytes() []byte {
 	// Read the length of the string
 	length, n := binary.Uvarint(e.b[e.i:])
 	if n <= 0 {
 		e.err = fmt.Errorf("StringDecoder: invalid encoded string length")
-		return ""
+		return nil
 	}
 
 	// The length of this string plus the length of the variable byte encoded length
@@ -113,17 +145,32 @@ func (e *StringDecoder) Read() string {
 	upper := lower + int(length)
 	if upper < lower {
 		e.err = fmt.Errorf("StringDecoder: length overflow")
-		return ""
+		return nil
 	}
 	if upper > len(e.b) {
 		e.err = fmt.Errorf("StringDecoder: not enough data to represent encoded string")
-		return ""
+		return nil
 	}
 
-	return string(e.b[lower:upper])
+	return e.b[lower:upper]
 }
 
 // Error returns the last error encountered by the decoder.
 func (e *StringDecoder) Error() error {
 	return e.err
 }
+
+// decodeStringBlockBytes decompresses the encoded strings in b using the codec
+// recorded in its 1 byte header. The returned slice never shares memory with b.
+func decodeStringBlockBytes(b []byte) ([]byte, error) {
+	id := b[0] >> 4
+	if id == stringCompressedSnappy {
+		return snappy.Decode(nil, b[1:])
+	}
+
+	codec, err := blockCodec(id)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decode(nil, b[1:])
+}
@@ -8,8 +8,60 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/influxdata/influxdb/v2/internal/testutil"
+	"github.com/influxdata/influxdb/v2/tsdb"
 )
 
+func Test_StringEncoder_Codecs(t *testing.T) {
+	for _, name := range tsdb.RegisteredBlockCodecs() {
+		t.Run(name, func(t *testing.T) {
+			codec := tsdb.BlockCodecByName(name)
+
+			enc := NewStringEncoder(1024)
+			enc.SetCodec(codec)
+
+			values := make([]string, 100)
+			for i := range values {
+				values[i] = fmt.Sprintf("log line %d: request completed", i)
+				enc.Write(values[i])
+			}
+
+			b, err := enc.Bytes()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got, exp := b[0]>>4, codec.ID(); got != exp {
+				t.Fatalf("unexpected encoding: got %v, exp %v", got, exp)
+			}
+
+			var dec StringDecoder
+			if err := dec.SetBytes(b); err != nil {
+				t.Fatalf("unexpected error creating string decoder: %v", err)
+			}
+
+			for i, v := range values {
+				if !dec.Next() {
+					t.Fatalf("unexpected next value: got false, exp true")
+				}
+				if got := dec.Read(); v != got {
+					t.Fatalf("unexpected value at pos %d: got %v, exp %v", i, got, v)
+				}
+			}
+
+			if dec.Next() {
+				t.Fatalf("unexpected next value: got true, exp false")
+			}
+		})
+	}
+}
+
+func Test_StringDecoder_UnknownCodec(t *testing.T) {
+	var dec StringDecoder
+	if err := dec.SetBytes([]byte{0xf0, 0x00}); err == nil {
+		t.Fatal("expected error decoding block with unknown codec")
+	}
+}
+
 func Test_StringEncoder_NoValues(t *testing.T) {
 	enc := NewStringEncoder(1024)
 	b, err := enc.Bytes()
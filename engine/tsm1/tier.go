@@ -0,0 +1,206 @@
+package tsm1
+
+// Tiered storage moves fully compacted generations of TSM files holding only
+// old data from the shard directory to a shard directory under the cold tier.
+// The FileStore resolves files in either directory, so reads, compactions and
+// snapshots do not depend on where a file is stored.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/v2/logger"
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"go.uber.org/zap"
+)
+
+// coldTierCheckInterval is how often the engine looks for files to move to the
+// cold tier.
+const coldTierCheckInterval = time.Minute
+
+// coldShardPath returns the directory of the shard at path in the cold tier at
+// coldDir.  Shards are laid out as db/rp/id in both tiers.
+func coldShardPath(coldDir, path string) string {
+	rp := filepath.Dir(path)
+	return filepath.Join(coldDir, filepath.Base(filepath.Dir(rp)), filepath.Base(rp), filepath.Base(path))
+}
+
+// MoveToCold moves the TSM files at paths and their tombstones to the cold
+// directory.  Each file is copied to the cold directory before it replaces the
+// original, so the files remain readable while they are moved and a crash
+// leaves either the original file or a complete copy.  The caller must ensure
+// the files are not compacted or deleted from while they are moved.
+func (f *FileStore) MoveToCold(paths []string) error {
+	if f.coldDir == "" {
+		return errors.New("file store has no cold directory")
+	} else if len(paths) == 0 {
+		return nil
+	} else if f.readOnly {
+		return tsdb.ReadOnlyError{Op: "move to cold tier", Path: f.dir}
+	}
+
+	if err := os.MkdirAll(f.coldDir, 0777); err != nil {
+		return err
+	}
+
+	var copied, newFiles []string
+	removeCopies := func() {
+		for _, fn := range copied {
+			_ = os.Remove(fn)
+		}
+		for _, fn := range newFiles {
+			_ = os.Remove(strings.TrimSuffix(fn, "."+TmpTSMFileExtension))
+		}
+	}
+
+	for _, path := range paths {
+		if filepath.Dir(path) == filepath.Clean(f.coldDir) {
+			removeCopies()
+			return fmt.Errorf("file already in cold tier: %s", path)
+		}
+
+		// The tombstone must be in place before the copy is opened so that
+		// deletes are still applied to it.
+		ts := strings.TrimSuffix(path, "."+TSMFileExtension) + "." + TombstoneFileExtension
+		if _, err := os.Stat(ts); err == nil {
+			dst := filepath.Join(f.coldDir, filepath.Base(ts))
+			copied = append(copied, dst)
+			if err := f.copyToCold(ts, dst); err != nil {
+				removeCopies()
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			removeCopies()
+			return err
+		}
+
+		dst := filepath.Join(f.coldDir, filepath.Base(path)+"."+TmpTSMFileExtension)
+		copied = append(copied, dst)
+		newFiles = append(newFiles, dst)
+		if err := f.copyToCold(path, dst); err != nil {
+			removeCopies()
+			return err
+		}
+	}
+
+	if err := f.replace(paths, newFiles, nil); err != nil {
+		// The copies are only removed while every original is still in place.
+		for _, path := range paths {
+			if _, statErr := os.Stat(path); statErr != nil {
+				return err
+			}
+		}
+		removeCopies()
+		return err
+	}
+	return nil
+}
+
+// copyToCold copies the file at src to dst and flushes it to disk.
+func (f *FileStore) copyToCold(src, dst string) error {
+	// A previous move may have left a partial copy behind.
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := f.copyNotLink(src, dst); err != nil {
+		return err
+	}
+
+	fd, err := os.OpenFile(dst, os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+// planColdTier returns the files in the shard directory if the shard is fully
+// compacted and all its data is older than the cold tier age.  Only fully
+// compacted files are moved so that the planner does not compact them again.
+func (e *Engine) planColdTier(now time.Time) []string {
+	if full, _ := e.CompactionPlan.FullyCompacted(); !full {
+		return nil
+	}
+
+	coldDir := filepath.Clean(e.FileStore.ColdDir())
+	cutoff := now.Add(-e.TSMColdAge).UnixNano()
+
+	var paths []string
+	for _, f := range e.FileStore.Stats() {
+		if f.MaxTime >= cutoff {
+			return nil
+		} else if filepath.Dir(f.Path) != coldDir {
+			paths = append(paths, f.Path)
+		}
+	}
+	return paths
+}
+
+// moveToColdTier kicks off a move of a generation of files to the cold tier if
+// one is due.  It returns true if the move was started.
+func (e *Engine) moveToColdTier(wg *sync.WaitGroup) bool {
+	if e.FileStore.ColdDir() == "" || e.TSMColdAge <= 0 {
+		return false
+	}
+
+	now := time.Now()
+	if now.Sub(e.lastColdCheck) < coldTierCheckInterval {
+		return false
+	}
+	e.lastColdCheck = now
+
+	paths := e.planColdTier(now)
+	if len(paths) == 0 {
+		return false
+	}
+
+	// Prevent compactions from using the files while they are moved.
+	if !e.Compactor.add(paths) {
+		return false
+	}
+
+	req := e.compactionRequest(4)
+	if !e.compactionScheduler.TryAcquire(req) {
+		e.Compactor.remove(paths)
+		return false
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer e.compactionScheduler.Release(req)
+		defer e.Compactor.remove(paths)
+
+		log, logEnd := logger.NewOperation(context.TODO(), e.logger, "TSM cold tier move", "tsm1_cold_tier")
+		defer logEnd()
+
+		// Deletes are blocked so that their tombstones are not lost.
+		e.rewriteMu.Lock()
+		defer e.rewriteMu.Unlock()
+
+		start := time.Now()
+		if err := e.FileStore.MoveToCold(paths); err != nil {
+			log.Warn("Error moving TSM files to cold tier", zap.Error(err))
+			return
+		}
+
+		for i, f := range paths {
+			log.Info("Moved TSM file to cold tier",
+				zap.Int("tsm1_index", i),
+				zap.String("tsm1_file", f))
+		}
+		log.Info("Finished moving TSM files to cold tier",
+			zap.String("tsm1_cold_dir", e.FileStore.ColdDir()),
+			zap.Duration("duration", time.Since(start)))
+	}()
+	return true
+}
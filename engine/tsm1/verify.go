@@ -0,0 +1,508 @@
+package tsm1
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrBlockChecksum is returned when the checksum of a block does not match
+	// its contents.
+	ErrBlockChecksum = errors.New("block checksum mismatch")
+
+	// ErrBlockIndexMismatch is returned when a block does not match its index entry.
+	ErrBlockIndexMismatch = errors.New("block does not match index entry")
+
+	// ErrBlockOverlap is reported when a block overlaps the previous block for
+	// the same key.  Overlapping blocks are readable and are not repaired.
+	ErrBlockOverlap = errors.New("block overlaps previous block")
+)
+
+// BlockCorruption describes a block that failed verification.
+type BlockCorruption struct {
+	Key              []byte
+	MinTime, MaxTime int64
+	Err              error
+}
+
+// TSMFileReport is the result of verifying a single TSM file.
+type TSMFileReport struct {
+	// Path is the path of the verified file.
+	Path string
+
+	// BlocksN is the number of blocks verified.
+	BlocksN int
+
+	// Corrupt are the blocks that failed verification.
+	Corrupt []BlockCorruption
+
+	// Overlaps are the blocks that overlap the previous block for the same
+	// key.  They are readable and are not counted as corrupt.
+	Overlaps []BlockCorruption
+
+	// Err is set if the file could not be verified completely.
+	Err error
+
+	// RepairedPath is the path of the file written by Engine.Repair to replace
+	// this file, if any.  It is empty if every block was dropped.
+	RepairedPath string
+
+	// Repaired is true if Engine.Repair replaced this file.
+	Repaired bool
+}
+
+// VerifyReport is the result of verifying the TSM files in a FileStore.
+type VerifyReport struct {
+	Files []TSMFileReport
+}
+
+// Corrupt returns true if any file failed verification.
+func (r *VerifyReport) Corrupt() bool {
+	for i := range r.Files {
+		if len(r.Files[i].Corrupt) > 0 || r.Files[i].Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks every block of every TSM file in the store against its checksum
+// and index entry, and checks that the blocks for each key do not overlap.  Files
+// remain available to queries and compactions while they are verified.
+func (f *FileStore) Verify(ctx context.Context) (*VerifyReport, error) {
+	f.mu.RLock()
+	files := make([]TSMFile, len(f.files))
+	copy(files, f.files)
+	// Ensure files are not unmapped while we're iterating over them.
+	for _, r := range files {
+		r.Ref()
+	}
+	f.mu.RUnlock()
+
+	defer func() {
+		for _, r := range files {
+			r.Unref()
+		}
+	}()
+
+	report := &VerifyReport{Files: make([]TSMFileReport, 0, len(files))}
+	for _, r := range files {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		tr, ok := r.(*TSMReader)
+		if !ok {
+			report.Files = append(report.Files, TSMFileReport{Path: r.Path(), Err: fmt.Errorf("unable to verify %T", r)})
+			continue
+		}
+		report.Files = append(report.Files, VerifyTSM(ctx, tr))
+	}
+	return report, ctx.Err()
+}
+
+// VerifyTSM checks every block in r against its checksum and index entry, and
+// checks that the blocks for each key do not overlap.
+func VerifyTSM(ctx context.Context, r *TSMReader) TSMFileReport {
+	report := TSMFileReport{Path: r.Path()}
+
+	var entries []IndexEntry
+	for i, n := 0, r.KeyCount(); i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			report.Err = err
+			return report
+		}
+
+		key, typ, blocks := r.Key(i, &entries)
+		for j := range blocks {
+			report.BlocksN++
+
+			if err := verifyBlock(r, typ, &blocks[j]); err != nil {
+				report.Corrupt = append(report.Corrupt, BlockCorruption{
+					Key:     bytes.Clone(key),
+					MinTime: blocks[j].MinTime,
+					MaxTime: blocks[j].MaxTime,
+					Err:     err,
+				})
+			} else if j > 0 && blocks[j].MinTime <= blocks[j-1].MaxTime {
+				report.Overlaps = append(report.Overlaps, BlockCorruption{
+					Key:     bytes.Clone(key),
+					MinTime: blocks[j].MinTime,
+					MaxTime: blocks[j].MaxTime,
+					Err:     ErrBlockOverlap,
+				})
+			}
+		}
+	}
+	return report
+}
+
+// verifyBlock checks the block at entry against its checksum and the entry.
+func verifyBlock(r *TSMReader, typ byte, entry *IndexEntry) error {
+	if entry.MinTime > entry.MaxTime {
+		return fmt.Errorf("%w: min time %d after max time %d", ErrBlockIndexMismatch, entry.MinTime, entry.MaxTime)
+	}
+
+	checksum, buf, err := r.ReadBytes(entry, nil)
+	if err != nil {
+		return err
+	} else if crc32.ChecksumIEEE(buf) != checksum {
+		return ErrBlockChecksum
+	}
+
+	if blockType, err := BlockType(buf); err != nil {
+		return err
+	} else if blockType != typ {
+		return fmt.Errorf("%w: block type %d, index type %d", ErrBlockIndexMismatch, blockType, typ)
+	}
+
+	var minTime, maxTime int64
+	switch typ {
+	case BlockFloat64:
+		var a tsdb.FloatArray
+		err = DecodeFloatArrayBlock(buf, &a)
+		minTime, maxTime = a.MinTime(), a.MaxTime()
+	case BlockInteger:
+		var a tsdb.IntegerArray
+		err = DecodeIntegerArrayBlock(buf, &a)
+		minTime, maxTime = a.MinTime(), a.MaxTime()
+	case BlockUnsigned:
+		var a tsdb.UnsignedArray
+		err = DecodeUnsignedArrayBlock(buf, &a)
+		minTime, maxTime = a.MinTime(), a.MaxTime()
+	case BlockString:
+		var a tsdb.StringArray
+		err = DecodeStringArrayBlock(buf, &a)
+		minTime, maxTime = a.MinTime(), a.MaxTime()
+	case BlockBoolean:
+		var a tsdb.BooleanArray
+		err = DecodeBooleanArrayBlock(buf, &a)
+		minTime, maxTime = a.MinTime(), a.MaxTime()
+	case BlockSketch:
+		var a tsdb.SketchArray
+		err = DecodeSketchArrayBlock(buf, &a)
+		minTime, maxTime = a.MinTime(), a.MaxTime()
+	}
+	if err != nil {
+		return err
+	}
+
+	if minTime < entry.MinTime || maxTime > entry.MaxTime {
+		return fmt.Errorf("%w: block time range [%d, %d] outside index time range [%d, %d]",
+			ErrBlockIndexMismatch, minTime, maxTime, entry.MinTime, entry.MaxTime)
+	}
+	return nil
+}
+
+// Repair verifies the TSM files in the engine and rewrites each file holding
+// corrupt blocks without them.  The original file is kept alongside the new
+// file with a .bad extension and its tombstones are applied to the new file.
+// The points of each dropped block are deleted from older files, so that
+// values the block overwrote do not become visible again.  Points are only
+// known if the timestamps of the block can still be decoded.  Overlapping
+// blocks are reported but kept.  Files being compacted are skipped and
+// reported with an error.  Deletes wait while a file is repaired.
+func (e *Engine) Repair(ctx context.Context) (*VerifyReport, error) {
+	if err := e.checkWritable("repair"); err != nil {
+		return nil, err
+	}
+
+	report, err := e.FileStore.Verify(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	for i := range report.Files {
+		fr := &report.Files[i]
+		if fr.Err != nil || len(fr.Corrupt) == 0 {
+			continue
+		}
+
+		if err := e.repairFile(ctx, fr); err != nil {
+			if _, ok := err.(errCompactionInProgress); ok {
+				fr.Err = err
+				continue
+			}
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// repairFile replaces the file in fr with a copy holding only its valid blocks.
+func (e *Engine) repairFile(ctx context.Context, fr *TSMFileReport) error {
+	log := e.logger.With(zap.String("tsm1_file", fr.Path))
+
+	// Tombstones written to the file after they are copied would be lost.
+	e.rewriteMu.Lock()
+	defer e.rewriteMu.Unlock()
+
+	// Prevent compactions from using the file while it is being replaced.
+	if !e.Compactor.add([]string{fr.Path}) {
+		return errCompactionInProgress{}
+	}
+	defer e.Compactor.remove([]string{fr.Path})
+
+	r := e.FileStore.TSMReader(fr.Path)
+	if r == nil {
+		// The file was compacted away after it was verified.
+		return nil
+	}
+	defer r.Unref()
+
+	gen, seq, err := e.FileStore.ParseFileName(fr.Path)
+	if err != nil {
+		return err
+	}
+
+	// The repaired file keeps the generation of the original file so that it
+	// keeps its precedence over older files.
+	for _, f := range e.FileStore.Files() {
+		if g, s, err := e.FileStore.ParseFileName(f.Path()); err == nil && g == gen && s > seq {
+			seq = s
+		}
+	}
+	path := filepath.Join(e.path, e.Compactor.formatFileName(gen, seq+1)+"."+TSMFileExtension+"."+TmpTSMFileExtension)
+	repairedPath := strings.TrimSuffix(path, "."+TmpTSMFileExtension)
+
+	tombstones, dropped, unknown, err := writeRepairedTSM(ctx, r, path)
+	if err == ErrNoValues {
+		path, repairedPath = "", ""
+	} else if err != nil {
+		return err
+	}
+	if unknown > 0 {
+		log.Warn("Unable to decode the timestamps of dropped blocks, values they overwrote may become visible",
+			zap.Int("tsm1_blocks_n", unknown))
+	}
+
+	// The repaired file, and the older files, must not return deleted or
+	// overwritten values once the repaired file is visible.
+	removeNew := func() {
+		if path != "" {
+			_ = os.Remove(path)
+			_ = os.Remove(tombstonePath(repairedPath))
+		}
+	}
+	if path != "" {
+		if err := writeTombstones(repairedPath, tombstones); err != nil {
+			removeNew()
+			return err
+		}
+	}
+	if err := e.deleteDroppedPoints(gen, dropped); err != nil {
+		removeNew()
+		return err
+	}
+
+	bad := fr.Path + "." + BadTSMFileExtension
+	if err := os.Link(fr.Path, bad); err != nil {
+		removeNew()
+		return err
+	}
+
+	var newFiles []string
+	if path != "" {
+		newFiles = append(newFiles, path)
+	}
+	if err := e.FileStore.ReplaceWithCallback([]string{fr.Path}, newFiles, nil); err != nil {
+		removeNew()
+		_ = os.Remove(bad)
+		return err
+	}
+	fr.RepairedPath = repairedPath
+	fr.Repaired = true
+
+	log.Info("Repaired TSM file",
+		zap.Int("tsm1_blocks_dropped", len(fr.Corrupt)),
+		zap.String("tsm1_repaired_file", fr.RepairedPath),
+		zap.String("tsm1_quarantined_file", bad))
+	return nil
+}
+
+// repairTombstone is a range of time deleted for a key.
+type repairTombstone struct {
+	key []byte
+	min int64
+	max int64
+}
+
+// writeRepairedTSM writes the blocks in r that pass verification to a new TSM
+// file at path.  It returns the tombstones of r that must be applied to the
+// new file, and the points of the dropped blocks, one tombstone per point, for
+// the blocks whose timestamps can be decoded.  unknown is the number of
+// dropped blocks whose timestamps cannot be decoded.
+func writeRepairedTSM(ctx context.Context, r *TSMReader, path string) (tombstones, dropped []repairTombstone, unknown int, err error) {
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	w, err := NewTSMWriter(fd)
+	if err != nil {
+		fd.Close()
+		_ = os.Remove(path)
+		return nil, nil, 0, err
+	}
+
+	err = func() error {
+		var entries []IndexEntry
+		for i, n := 0, r.KeyCount(); i < n; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			key, typ, blocks := r.Key(i, &entries)
+			var written bool
+			for j := range blocks {
+				if verifyBlock(r, typ, &blocks[j]) != nil {
+					if ts, ok := blockTimestamps(r, &blocks[j]); !ok {
+						unknown++
+					} else {
+						for _, t := range ts {
+							dropped = append(dropped, repairTombstone{key: bytes.Clone(key), min: t, max: t})
+						}
+					}
+					continue
+				}
+
+				_, buf, err := r.ReadBytes(&blocks[j], nil)
+				if err != nil {
+					return err
+				}
+				var stats *BlockStats
+				if s, ok := r.BlockStats(&blocks[j]); ok {
+					stats = &s
+				}
+				if err := writeBlock(w, key, blocks[j].MinTime, blocks[j].MaxTime, buf, stats); err != nil {
+					return err
+				}
+				written = true
+			}
+
+			if written {
+				for _, ts := range r.TombstoneRange(key) {
+					tombstones = append(tombstones, repairTombstone{key: bytes.Clone(key), min: ts.Min, max: ts.Max})
+				}
+			}
+		}
+		return w.WriteIndex()
+	}()
+
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// The points of the dropped blocks are still returned if no block
+		// is left to write.
+		_ = os.Remove(path)
+	}
+	return tombstones, dropped, unknown, err
+}
+
+// blockTimestamps returns the timestamps of the corrupt block at entry.  It
+// returns false if they cannot be decoded or fall outside the time range of
+// the entry, as they are then not known.
+func blockTimestamps(r *TSMReader, entry *IndexEntry) ([]int64, bool) {
+	_, buf, err := r.ReadBytes(entry, nil)
+	if err != nil || len(buf) == 0 {
+		return nil, false
+	}
+
+	tb, _, err := unpackBlock(buf[1:])
+	if err != nil {
+		return nil, false
+	}
+	ts, err := TimeArrayDecodeAll(tb, nil)
+	if err != nil || len(ts) == 0 {
+		return nil, false
+	}
+	for _, t := range ts {
+		if t < entry.MinTime || t > entry.MaxTime {
+			return nil, false
+		}
+	}
+	return ts, true
+}
+
+// deleteDroppedPoints deletes the points of the dropped blocks from the files
+// older than generation gen.
+func (e *Engine) deleteDroppedPoints(gen int, dropped []repairTombstone) error {
+	if len(dropped) == 0 {
+		return nil
+	}
+
+	for _, f := range e.FileStore.Files() {
+		if g, _, err := e.FileStore.ParseFileName(f.Path()); err != nil {
+			return err
+		} else if g >= gen {
+			continue
+		}
+
+		r := e.FileStore.TSMReader(f.Path())
+		if r == nil {
+			// The file was compacted away.
+			continue
+		}
+
+		var tombstones []repairTombstone
+		for _, ts := range dropped {
+			if r.Contains(ts.key) {
+				tombstones = append(tombstones, ts)
+			}
+		}
+		err := deleteTombstones(r, tombstones)
+		r.Unref()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTombstones writes the ranges in tombstones to the tombstone file of the
+// TSM file at path, before the file is opened.
+func writeTombstones(path string, tombstones []repairTombstone) error {
+	if len(tombstones) == 0 {
+		return nil
+	}
+
+	t := NewTombstoner(path, nil)
+	for _, ts := range tombstones {
+		if err := t.AddRange([][]byte{ts.key}, ts.min, ts.max); err != nil {
+			_ = t.Rollback()
+			return err
+		}
+	}
+	return t.Flush()
+}
+
+// tombstonePath returns the path of the tombstone file of the TSM file at path.
+func tombstonePath(path string) string {
+	return strings.TrimSuffix(path, "."+TSMFileExtension) + "." + TombstoneFileExtension
+}
+
+// deleteTombstones deletes the ranges in tombstones from r.
+func deleteTombstones(r *TSMReader, tombstones []repairTombstone) error {
+	if len(tombstones) == 0 {
+		return nil
+	}
+
+	d := r.BatchDelete()
+	for _, ts := range tombstones {
+		if err := d.DeleteRange([][]byte{ts.key}, ts.min, ts.max); err != nil {
+			_ = d.Rollback()
+			return err
+		}
+	}
+	return d.Commit()
+}
@@ -0,0 +1,203 @@
+package tsm1
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/influxdata/influxdb/v2/logger"
+	"github.com/influxdata/influxdb/v2/models"
+	"github.com/influxdata/influxdb/v2/pkg/bytesutil"
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"go.uber.org/zap"
+)
+
+// VerifyIndex cross-checks the series in the index against the series keys in
+// the TSM files and the cache, and against the series file.  The shard remains
+// available while it is verified, and series written while it is verified are
+// not reported.
+func (e *Engine) VerifyIndex(ctx context.Context) (*tsdb.IndexVerifyReport, error) {
+	indexed := e.index.SeriesIDSet()
+	report := &tsdb.IndexVerifyReport{SeriesN: int(indexed.Cardinality())}
+
+	// Collect the series with data, reporting the ones which are not indexed.
+	data := tsdb.NewSeriesIDSet()
+	var notIndexed []tsdb.SeriesInconsistency
+	if err := e.walkSeriesKeys(func(key []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		name, tags := models.ParseKeyBytes(key)
+		id := e.sfile.SeriesID(name, tags, nil)
+		if id == 0 {
+			report.Missing = append(report.Missing, tsdb.SeriesInconsistency{Key: bytes.Clone(key), Err: tsdb.ErrSeriesNotInSeriesFile})
+			return nil
+		}
+
+		data.Add(id)
+		if !indexed.Contains(id) {
+			notIndexed = append(notIndexed, tsdb.SeriesInconsistency{ID: id, Key: bytes.Clone(key), Err: tsdb.ErrSeriesNotIndexed})
+		}
+		return nil
+	}); err != nil {
+		return report, err
+	}
+
+	// Series created after the index was read are not missing.
+	if len(notIndexed) > 0 {
+		indexed := e.index.SeriesIDSet()
+		for _, s := range notIndexed {
+			if !indexed.Contains(s.ID) {
+				report.Missing = append(report.Missing, s)
+			}
+		}
+	}
+
+	var noData []tsdb.SeriesInconsistency
+	indexed.ForEach(func(id uint64) {
+		name, tags := e.sfile.Series(id)
+		if name == nil || e.sfile.IsDeleted(id) {
+			report.Orphaned = append(report.Orphaned, tsdb.SeriesInconsistency{ID: id, Err: tsdb.ErrSeriesNotInSeriesFile})
+		} else if !data.Contains(id) {
+			noData = append(noData, tsdb.SeriesInconsistency{ID: id, Key: models.MakeKey(name, tags), Err: tsdb.ErrSeriesHasNoData})
+		}
+	})
+
+	// Series written after the keys were read are not orphaned.
+	if len(noData) > 0 {
+		seriesKeys := make([][]byte, len(noData))
+		for i, s := range noData {
+			seriesKeys[i] = s.Key
+		}
+
+		hasData, err := e.seriesHaveData(ctx, seriesKeys)
+		if err != nil {
+			return report, err
+		}
+		for i, s := range noData {
+			if !hasData[i] {
+				report.Orphaned = append(report.Orphaned, s)
+			}
+		}
+	}
+	return report, ctx.Err()
+}
+
+// RebuildIndex rebuilds the index from the series keys in the TSM files and the
+// cache.  The new index is written next to the live one and swapped in, so the
+// shard remains available while the index is rebuilt.  The index is swapped in
+// one partition at a time, so if the rebuild fails some partitions may already
+// use the rebuilt index.  Both indexes hold every series with data, and the
+// rebuild can be run again.
+func (e *Engine) RebuildIndex(ctx context.Context) error {
+	if err := e.checkWritable("rebuild index"); err != nil {
+		return err
+	}
+
+	idx, ok := e.index.(tsdb.RebuildableIndex)
+	if !ok {
+		return fmt.Errorf("cannot rebuild %s index", e.index.Type())
+	}
+
+	log, logEnd := logger.NewOperation(ctx, e.logger, "Rebuild index", "tsm1_rebuild_index")
+	defer logEnd()
+
+	if err := idx.Rebuild(ctx, e.walkSeriesKeys); err != nil {
+		log.Error("Cannot rebuild index", zap.Error(err))
+		return err
+	}
+
+	// Series written while the index was rebuilt may only have been indexed in
+	// the replaced files, so the series in the cache are indexed again.
+	keys := e.Cache.keysWithSnapshot()
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > reindexBatchSize {
+			n = reindexBatchSize
+		}
+
+		seriesKeys := make([][]byte, n)
+		names := make([][]byte, n)
+		tags := make([]models.Tags, n)
+		for i, key := range keys[:n] {
+			seriesKeys[i], _ = SeriesAndFieldFromCompositeKey(key)
+			names[i], tags[i] = models.ParseKeyBytes(seriesKeys[i])
+		}
+		if err := e.index.CreateSeriesListIfNotExists(seriesKeys, names, tags); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// seriesHaveData returns whether each of seriesKeys has data in the cache or
+// the TSM files.  The cache is read first, so that series whose data is
+// snapshotted in between are found in the files.
+func (e *Engine) seriesHaveData(ctx context.Context, seriesKeys [][]byte) ([]bool, error) {
+	// The keys of a series start with its series key and the field separator.
+	prefixes := make([][]byte, len(seriesKeys))
+	for i, seriesKey := range seriesKeys {
+		prefixes[i] = append(bytes.Clone(seriesKey), keyFieldSeparatorBytes...)
+	}
+
+	hasData := make([]bool, len(seriesKeys))
+	cacheKeys := e.Cache.keysWithSnapshot()
+	for i, prefix := range prefixes {
+		if j := bytesutil.SearchBytes(cacheKeys, prefix); j < len(cacheKeys) {
+			hasData[i] = bytes.HasPrefix(cacheKeys[j], prefix)
+		}
+	}
+
+	var mu sync.Mutex
+	if err := e.FileStore.Apply(ctx, func(r TSMFile) error {
+		n := r.KeyCount()
+		for i, prefix := range prefixes {
+			if j := r.Seek(prefix); j < n {
+				if key, _ := r.KeyAt(j); bytes.HasPrefix(key, prefix) {
+					mu.Lock()
+					hasData[i] = true
+					mu.Unlock()
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return hasData, nil
+}
+
+// walkSeriesKeys calls fn with the series key of every key in the TSM files and
+// the cache.  Consecutive keys of the same series are only passed once.  The
+// cache keys are read before the files are walked, so that keys snapshotted
+// to a new file in between are found in either.
+func (e *Engine) walkSeriesKeys(fn func(key []byte) error) error {
+	cacheKeys := e.Cache.keysWithSnapshot()
+
+	var prev []byte
+	walk := func(key []byte) error {
+		seriesKey, _ := SeriesAndFieldFromCompositeKey(key)
+		if prev != nil && bytes.Equal(seriesKey, prev) {
+			return nil
+		}
+		prev = seriesKey
+		return fn(seriesKey)
+	}
+
+	if err := e.FileStore.WalkKeys(nil, func(key []byte, _ byte) error {
+		return walk(key)
+	}); err != nil {
+		return err
+	}
+
+	prev = nil
+	for _, key := range cacheKeys {
+		if err := walk(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
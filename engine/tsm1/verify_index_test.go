@@ -0,0 +1,101 @@
+package tsm1_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/influxdata/influxdb/v2/models"
+	"github.com/influxdata/influxdb/v2/tsdb"
+)
+
+func TestEngine_VerifyIndex(t *testing.T) {
+	for _, index := range tsdb.RegisteredIndexes() {
+		t.Run(index, func(t *testing.T) {
+			e := MustOpenEngine(t, index)
+
+			if err := e.WritePointsString(
+				`cpu,host=A value=1.1 1000000000`,
+				`cpu,host=B value=1.2 1000000000`,
+			); err != nil {
+				t.Fatalf("failed to write points: %s", err.Error())
+			}
+			e.MustWriteSnapshot()
+			if err := e.WritePointsString(`cpu,host=C value=1.3 1000000000`); err != nil {
+				t.Fatalf("failed to write points: %s", err.Error())
+			}
+
+			report, err := e.VerifyIndex(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error verifying: %v", err)
+			} else if !report.Consistent() {
+				t.Fatalf("unexpected inconsistency: %+v", report)
+			} else if got, exp := report.SeriesN, 3; got != exp {
+				t.Fatalf("series count mismatch: got %v, exp %v", got, exp)
+			}
+
+			// Series in a snapshot which is not written yet have data.
+			if _, err := e.Cache.Snapshot(); err != nil {
+				t.Fatalf("unexpected error taking snapshot: %v", err)
+			}
+			report, err = e.VerifyIndex(context.Background())
+			e.Cache.ClearSnapshot(false)
+			if err != nil {
+				t.Fatalf("unexpected error verifying: %v", err)
+			} else if !report.Consistent() {
+				t.Fatalf("unexpected inconsistency with snapshot: %+v", report)
+			}
+
+			// Index a series without data, and drop a series with data from the index.
+			orphan := models.NewTags(map[string]string{"host": "D"})
+			if err := e.index.CreateSeriesListIfNotExists([][]byte{models.MakeKey([]byte("cpu"), orphan)}, [][]byte{[]byte("cpu")}, []models.Tags{orphan}); err != nil {
+				t.Fatalf("unexpected error creating series: %v", err)
+			}
+			missing := models.NewTags(map[string]string{"host": "B"})
+			id := e.sfile.SeriesID([]byte("cpu"), missing, nil)
+			if err := e.index.DropSeries(id, models.MakeKey([]byte("cpu"), missing), false); err != nil {
+				t.Fatalf("unexpected error dropping series: %v", err)
+			}
+
+			report, err = e.VerifyIndex(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error verifying: %v", err)
+			} else if got, exp := len(report.Missing), 1; got != exp {
+				t.Fatalf("missing series mismatch: got %v, exp %v", got, exp)
+			} else if got, exp := string(report.Missing[0].Key), "cpu,host=B"; got != exp {
+				t.Fatalf("missing key mismatch: got %v, exp %v", got, exp)
+			} else if !errors.Is(report.Missing[0].Err, tsdb.ErrSeriesNotIndexed) {
+				t.Fatalf("unexpected missing error: %v", report.Missing[0].Err)
+			} else if got, exp := len(report.Orphaned), 1; got != exp {
+				t.Fatalf("orphaned series mismatch: got %v, exp %v", got, exp)
+			} else if got, exp := string(report.Orphaned[0].Key), "cpu,host=D"; got != exp {
+				t.Fatalf("orphaned key mismatch: got %v, exp %v", got, exp)
+			} else if !errors.Is(report.Orphaned[0].Err, tsdb.ErrSeriesHasNoData) {
+				t.Fatalf("unexpected orphaned error: %v", report.Orphaned[0].Err)
+			}
+
+			if err := e.RebuildIndex(context.Background()); err != nil {
+				t.Fatalf("unexpected error rebuilding index: %v", err)
+			}
+
+			// The rebuilt index is kept when the engine is reopened.
+			for _, reopen := range []bool{false, true} {
+				if reopen {
+					e.MustWriteSnapshot()
+					if err := e.Reopen(); err != nil {
+						t.Fatalf("unexpected error reopening: %v", err)
+					}
+				}
+
+				report, err = e.VerifyIndex(context.Background())
+				if err != nil {
+					t.Fatalf("unexpected error verifying: %v", err)
+				} else if !report.Consistent() {
+					t.Fatalf("unexpected inconsistency after rebuild: %+v", report)
+				} else if got, exp := report.SeriesN, 3; got != exp {
+					t.Fatalf("series count mismatch: got %v, exp %v", got, exp)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package tsm1_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxdb/v2/tsdb/engine/tsm1"
+)
+
+// corruptFirstBlock flips a byte in the data of the first block in the TSM file
+// at path.
+func corruptFirstBlock(tb testing.TB, path string) {
+	tb.Helper()
+
+	// Skip the 5 byte header and 4 byte block checksum.
+	corruptByte(tb, path, 10)
+}
+
+// corruptByte flips the byte at offset in the file at path.
+func corruptByte(tb testing.TB, path string, offset int64) {
+	tb.Helper()
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		tb.Fatalf("unexpected error opening file: %v", err)
+	}
+	defer f.Close()
+
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, offset); err != nil {
+		tb.Fatalf("unexpected error reading file: %v", err)
+	}
+	b[0] ^= 0xff
+	if _, err := f.WriteAt(b, offset); err != nil {
+		tb.Fatalf("unexpected error writing file: %v", err)
+	}
+}
+
+func TestFileStore_Verify(t *testing.T) {
+	dir := t.TempDir()
+
+	f1 := MustWriteTSM(t, dir, 1, map[string][]tsm1.Value{
+		"cpu#!~#value": {tsm1.NewValue(1, 1.0), tsm1.NewValue(2, 2.0)},
+		"mem#!~#value": {tsm1.NewValue(1, 3.0)},
+	})
+	f2 := MustWriteTSM(t, dir, 2, map[string][]tsm1.Value{
+		"cpu#!~#value": {tsm1.NewValue(3, 4.0)},
+	})
+	corruptFirstBlock(t, f1)
+
+	fs := tsm1.NewFileStore(dir, tsdb.EngineTags{})
+	if err := fs.Open(context.Background()); err != nil {
+		t.Fatalf("unexpected error opening file store: %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+
+	report, err := fs.Verify(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error verifying: %v", err)
+	} else if !report.Corrupt() {
+		t.Fatal("expected corruption to be reported")
+	} else if got, exp := len(report.Files), 2; got != exp {
+		t.Fatalf("files length mismatch: got %v, exp %v", got, exp)
+	}
+
+	for _, fr := range report.Files {
+		if fr.Err != nil {
+			t.Fatalf("unexpected error verifying %s: %v", fr.Path, fr.Err)
+		}
+
+		switch fr.Path {
+		case f1:
+			if got, exp := fr.BlocksN, 2; got != exp {
+				t.Fatalf("blocks mismatch: got %v, exp %v", got, exp)
+			} else if got, exp := len(fr.Corrupt), 1; got != exp {
+				t.Fatalf("corrupt blocks mismatch: got %v, exp %v", got, exp)
+			}
+
+			c := fr.Corrupt[0]
+			if string(c.Key) != "cpu#!~#value" || c.MinTime != 1 || c.MaxTime != 2 {
+				t.Fatalf("unexpected corrupt block: %s [%d, %d]", c.Key, c.MinTime, c.MaxTime)
+			} else if c.Err == nil {
+				t.Fatal("expected corrupt block error")
+			}
+		case f2:
+			if got, exp := len(fr.Corrupt), 0; got != exp {
+				t.Fatalf("corrupt blocks mismatch: got %v, exp %v", got, exp)
+			}
+		default:
+			t.Fatalf("unexpected file: %s", fr.Path)
+		}
+	}
+}
+
+func TestFileStore_Verify_Canceled(t *testing.T) {
+	dir := t.TempDir()
+	MustWriteTSM(t, dir, 1, map[string][]tsm1.Value{"cpu#!~#value": {tsm1.NewValue(1, 1.0)}})
+
+	fs := tsm1.NewFileStore(dir, tsdb.EngineTags{})
+	if err := fs.Open(context.Background()); err != nil {
+		t.Fatalf("unexpected error opening file store: %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := fs.Verify(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error: got %v, exp %v", err, context.Canceled)
+	}
+}
+
+func TestFileStore_Verify_Overlap(t *testing.T) {
+	dir := t.TempDir()
+
+	w, _ := MustTSMWriter(t, dir, 1)
+	if err := w.Write([]byte("cpu#!~#value"), []tsm1.Value{tsm1.NewValue(1, 1.0), tsm1.NewValue(3, 3.0)}); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if err := w.Write([]byte("cpu#!~#value"), []tsm1.Value{tsm1.NewValue(2, 2.0)}); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if err := w.WriteIndex(); err != nil {
+		t.Fatalf("unexpected error writing index: %v", err)
+	} else if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error closing: %v", err)
+	}
+
+	fs := tsm1.NewFileStore(dir, tsdb.EngineTags{})
+	if err := fs.Open(context.Background()); err != nil {
+		t.Fatalf("unexpected error opening file store: %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+
+	report, err := fs.Verify(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error verifying: %v", err)
+	} else if report.Corrupt() {
+		t.Fatalf("unexpected corruption: %+v", report.Files)
+	}
+
+	fr := report.Files[0]
+	if got, exp := len(fr.Overlaps), 1; got != exp {
+		t.Fatalf("overlapping blocks mismatch: got %v, exp %v", got, exp)
+	} else if !errors.Is(fr.Overlaps[0].Err, tsm1.ErrBlockOverlap) {
+		t.Fatalf("unexpected error: got %v, exp %v", fr.Overlaps[0].Err, tsm1.ErrBlockOverlap)
+	}
+}
+
+func TestEngine_Repair(t *testing.T) {
+	for _, index := range tsdb.RegisteredIndexes() {
+		t.Run(index, func(t *testing.T) {
+			e := MustOpenEngine(t, index)
+
+			if err := e.WritePointsString(
+				`cpu,host=A value=1.1 1000000000`,
+				`cpu,host=B value=1.2 1000000000`,
+			); err != nil {
+				t.Fatalf("failed to write points: %s", err.Error())
+			}
+			if err := e.WriteSnapshot(); err != nil {
+				t.Fatalf("failed to snapshot: %s", err.Error())
+			}
+
+			files := e.FileStore.Files()
+			if got, exp := len(files), 1; got != exp {
+				t.Fatalf("files length mismatch: got %v, exp %v", got, exp)
+			}
+			path := files[0].Path()
+			corruptFirstBlock(t, path)
+
+			report, err := e.Repair(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error repairing: %v", err)
+			} else if got, exp := len(report.Files), 1; got != exp {
+				t.Fatalf("files length mismatch: got %v, exp %v", got, exp)
+			}
+
+			fr := report.Files[0]
+			if !fr.Repaired {
+				t.Fatalf("expected file to be repaired: %v", fr.Err)
+			} else if got, exp := len(fr.Corrupt), 1; got != exp {
+				t.Fatalf("corrupt blocks mismatch: got %v, exp %v", got, exp)
+			} else if got, exp := string(fr.Corrupt[0].Key), "cpu,host=A#!~#value"; got != exp {
+				t.Fatalf("corrupt key mismatch: got %v, exp %v", got, exp)
+			}
+
+			if _, err := os.Stat(path + "." + tsm1.BadTSMFileExtension); err != nil {
+				t.Fatalf("expected quarantined file: %v", err)
+			}
+
+			files = e.FileStore.Files()
+			if got, exp := len(files), 1; got != exp {
+				t.Fatalf("files length mismatch: got %v, exp %v", got, exp)
+			} else if got, exp := files[0].Path(), fr.RepairedPath; got != exp {
+				t.Fatalf("repaired path mismatch: got %v, exp %v", got, exp)
+			}
+
+			if got, exp := files[0].KeyCount(), 1; got != exp {
+				t.Fatalf("keys length mismatch: got %v, exp %v", got, exp)
+			}
+			values, err := e.FileStore.Read([]byte("cpu,host=B#!~#value"), 1000000000)
+			if err != nil {
+				t.Fatalf("unexpected error reading: %v", err)
+			} else if got, exp := len(values), 1; got != exp {
+				t.Fatalf("values length mismatch: got %v, exp %v", got, exp)
+			}
+
+			report, err = e.FileStore.Verify(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error verifying: %v", err)
+			} else if report.Corrupt() {
+				t.Fatalf("unexpected corruption after repair: %+v", report.Files)
+			}
+		})
+	}
+}
+
+func TestEngine_Repair_DeletesDroppedPoints(t *testing.T) {
+	for _, index := range tsdb.RegisteredIndexes() {
+		t.Run(index, func(t *testing.T) {
+			e := MustOpenEngine(t, index)
+			e.SetCompactionsEnabled(false)
+
+			// The values in the second file overwrite two of the values in the first.
+			for _, points := range [][]string{
+				{`cpu,host=A value=1.1 1000000000`, `cpu,host=A value=1.2 2000000000`, `cpu,host=A value=1.3 3000000000`},
+				{`cpu,host=A value=2.1 1000000000`, `cpu,host=A value=2.3 3000000000`},
+			} {
+				if err := e.WritePointsString(points...); err != nil {
+					t.Fatalf("failed to write points: %s", err.Error())
+				}
+				if err := e.WriteSnapshot(); err != nil {
+					t.Fatalf("failed to snapshot: %s", err.Error())
+				}
+			}
+
+			files := e.FileStore.Files()
+			if got, exp := len(files), 2; got != exp {
+				t.Fatalf("files length mismatch: got %v, exp %v", got, exp)
+			}
+
+			// Corrupt the values of the block, so that its timestamps are still known.
+			entries := files[1].Entries([]byte("cpu,host=A#!~#value"))
+			if got, exp := len(entries), 1; got != exp {
+				t.Fatalf("entries length mismatch: got %v, exp %v", got, exp)
+			}
+			corruptByte(t, files[1].Path(), entries[0].Offset+int64(entries[0].Size)-1)
+
+			report, err := e.Repair(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error repairing: %v", err)
+			} else if !report.Files[1].Repaired {
+				t.Fatalf("expected file to be repaired: %v", report.Files[1].Err)
+			}
+
+			// The overwritten values must not become visible again, but the
+			// value between them must be kept.
+			values, err := e.FileStore.Read([]byte("cpu,host=A#!~#value"), 2000000000)
+			if err != nil {
+				t.Fatalf("unexpected error reading: %v", err)
+			} else if got, exp := len(values), 1; got != exp {
+				t.Fatalf("values length mismatch: got %v, exp %v", got, exp)
+			} else if got, exp := values[0].UnixNano(), int64(2000000000); got != exp {
+				t.Fatalf("timestamp mismatch: got %v, exp %v", got, exp)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"math"
 	"os"
@@ -22,6 +23,7 @@ import (
 	"github.com/influxdata/influxdb/v2/pkg/limiter"
 	"github.com/influxdata/influxdb/v2/pkg/pool"
 	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxdb/v2/tsdb/sketch"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
@@ -44,11 +46,36 @@ const (
 	booleanEntryType  = 3
 	stringEntryType   = 4
 	unsignedEntryType = 5
+	sketchEntryType   = 6
+)
+
+const (
+	// WALSegmentMagicNumber is written at the start of WAL segments that have a
+	// version header.  Its first byte is not a valid entry type, which tells these
+	// segments apart from segments written before the header was added.
+	WALSegmentMagicNumber uint32 = 0x0057414C
+
+	// WALSegmentVersion is the version of the WAL segments written.  Each entry
+	// of a version 2 segment is followed by the CRC32 of its type, length and data.
+	WALSegmentVersion byte = 2
+
+	// WALSegmentHeaderSize is the size in bytes of the header of a WAL segment.
+	WALSegmentHeaderSize = 5
+
+	// walSegmentVersionUnchecked is the version of segments without a header,
+	// whose entries have no checksum.
+	walSegmentVersionUnchecked byte = 1
 )
 
 // WalEntryType is a byte written to a wal segment file that indicates what the following compressed block contains.
 type WalEntryType byte
 
+// walEntryTypeMask masks the entry type from the type byte of a WAL entry. The
+// upper 4 bits hold the ID of the codec used to compress the entry. A codec ID of
+// 0 indicates snappy, which keeps segments written with the default codec
+// readable by versions that predate configurable codecs.
+const walEntryTypeMask = 0x0f
+
 const (
 	// WriteWALEntryType indicates a write entry.
 	WriteWALEntryType WalEntryType = 0x01
@@ -67,8 +94,20 @@ var (
 	// ErrWALCorrupt is returned when reading a corrupt WAL entry.
 	ErrWALCorrupt = fmt.Errorf("corrupted WAL entry")
 
+	// ErrWALChecksum is returned when the checksum of a WAL entry does not match its data.
+	ErrWALChecksum = fmt.Errorf("WAL entry checksum mismatch")
+
+	// ErrWALSegmentVersion is returned when reading a WAL segment of a newer version.
+	ErrWALSegmentVersion = fmt.Errorf("unsupported WAL segment version")
+
+	// errWALSegmentTorn is returned when a WAL segment is too short to hold its
+	// header, as left by a crash while the header was written.
+	errWALSegmentTorn = fmt.Errorf("WAL segment too short for header")
+
 	defaultWaitingWALWrites = runtime.GOMAXPROCS(0) * 2
+)
 
+var (
 	// bytePool is a shared bytes pool buffer re-cycle []byte slices to reduce allocations.
 	bytesPool = pool.NewLimitedBytes(256, walEncodeBufSize*2)
 )
@@ -79,6 +118,10 @@ type WAL struct {
 	syncCount   uint64
 	syncWaiters chan chan error
 
+	// writesInFlight is the number of writes encoding an entry that have not
+	// been added to the current segment yet.
+	writesInFlight int64
+
 	mu            sync.RWMutex
 	lastWriteTime time.Time
 
@@ -97,6 +140,27 @@ type WAL struct {
 	// is opened if a non-default value is required.
 	syncDelay time.Duration
 
+	// codec is the codec used to compress new entries.  Snappy is used if nil.
+	// This must be set before the WAL is opened if a non-default value is required.
+	codec tsdb.BlockCodec
+
+	// groupCommitBytes enables group commit if greater than 0: writes are fsynced
+	// together once groupCommitBytes are pending, once the oldest of them has waited
+	// groupCommitLatency or a write's deadline is near, or once no other write is
+	// in flight.  syncDelay is ignored.  These must be set before the WAL is opened.
+	groupCommitBytes   int
+	groupCommitLatency time.Duration
+
+	// commitBytes is the number of bytes written since the last fsync, and
+	// commitDeadline is when the group commit of these writes is due.  syncLatency
+	// is the duration of the last fsync.  They are protected by mu.
+	commitBytes    int
+	commitDeadline time.Time
+	syncLatency    time.Duration
+
+	// commitCh notifies group commit of new writes.
+	commitCh chan struct{}
+
 	// WALOutput is the writer used by the logger.
 	logger       *zap.Logger // Logger to be used for important messages
 	traceLogger  *zap.Logger // Logger to be used when trace-logging is on.
@@ -114,6 +178,11 @@ type WAL struct {
 	// maxWriteWait sets the max duration the WAL will wait when limiter has no available
 	// values to take.
 	maxWriteWait time.Duration
+
+	// subscribers receive entries once they are synced to disk.  pending holds
+	// the entries written since the last sync.  Both are protected by mu.
+	subscribers []*WALSubscription
+	pending     []WALStreamEntry
 }
 
 // NewWAL initializes a new WAL at the given directory.
@@ -130,6 +199,7 @@ func NewWAL(path string, maxConcurrentWrites int, maxWriteDelay time.Duration, t
 		SegmentSize:  DefaultSegmentSize,
 		closing:      make(chan struct{}),
 		syncWaiters:  make(chan chan error, 1024),
+		commitCh:     make(chan struct{}, 1),
 		stats:        newWALMetrics(tags),
 		limiter:      limiter.NewFixed(maxConcurrentWrites),
 		maxWriteWait: maxWriteDelay,
@@ -155,23 +225,43 @@ func (l *WAL) WithLogger(log *zap.Logger) {
 	}
 }
 
+// SetCodec sets the codec used to compress new entries. A nil codec uses snappy.
+// It must be called before the WAL is opened.
+func (l *WAL) SetCodec(codec tsdb.BlockCodec) {
+	l.codec = codec
+}
+
+// SetGroupCommit enables group commit of writes once n bytes are pending or the
+// oldest pending write has waited latency.  A value of 0 for n disables group
+// commit.  It must be called before the WAL is opened.
+func (l *WAL) SetGroupCommit(n int, latency time.Duration) {
+	l.groupCommitBytes = n
+	l.groupCommitLatency = latency
+}
+
 var globalWALMetrics = newAllWALMetrics()
 
 const walSubsystem = "wal"
 
 type allWALMetrics struct {
-	size      *prometheus.GaugeVec
-	writes    *prometheus.CounterVec
-	writesErr *prometheus.CounterVec
+	size           *prometheus.GaugeVec
+	writes         *prometheus.CounterVec
+	writesErr      *prometheus.CounterVec
+	writesUnsynced *prometheus.CounterVec
+	syncBatchSize  *prometheus.HistogramVec
+	syncDuration   *prometheus.HistogramVec
 }
 
 type walMetrics struct {
 	// size should never be updated directly, only through SetSize/AddSize
 	size prometheus.Gauge
 	// sizeAtomic should never be updated directly, only through SetSize/AddSize
-	sizeAtomic int64
-	writes     prometheus.Counter
-	writesErr  prometheus.Counter
+	sizeAtomic     int64
+	writes         prometheus.Counter
+	writesErr      prometheus.Counter
+	writesUnsynced prometheus.Counter
+	syncBatchSize  prometheus.Observer
+	syncDuration   prometheus.Observer
 }
 
 func (f *walMetrics) AddSize(n int64) {
@@ -205,6 +295,26 @@ func newAllWALMetrics() *allWALMetrics {
 			Name:      "writes_err",
 			Help:      "Number of failed write attempts to the WAL",
 		}, labels),
+		writesUnsynced: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: storageNamespace,
+			Subsystem: walSubsystem,
+			Name:      "writes_unsynced",
+			Help:      "Number of writes to the WAL that returned before their entry was synced",
+		}, labels),
+		syncBatchSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: storageNamespace,
+			Subsystem: walSubsystem,
+			Name:      "fsync_batch_size",
+			Help:      "Histogram of the number of writes committed by each fsync of the WAL",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 11),
+		}, labels),
+		syncDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: storageNamespace,
+			Subsystem: walSubsystem,
+			Name:      "fsync_duration_seconds",
+			Help:      "Histogram of the duration of fsyncs of the WAL",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 15),
+		}, labels),
 	}
 }
 
@@ -213,15 +323,21 @@ func WALCollectors() []prometheus.Collector {
 		globalWALMetrics.size,
 		globalWALMetrics.writes,
 		globalWALMetrics.writesErr,
+		globalWALMetrics.writesUnsynced,
+		globalWALMetrics.syncBatchSize,
+		globalWALMetrics.syncDuration,
 	}
 }
 
 func newWALMetrics(tags tsdb.EngineTags) *walMetrics {
 	labels := tags.GetLabels()
 	return &walMetrics{
-		size:      globalWALMetrics.size.With(labels),
-		writes:    globalWALMetrics.writes.With(labels),
-		writesErr: globalWALMetrics.writesErr.With(labels),
+		size:           globalWALMetrics.size.With(labels),
+		writes:         globalWALMetrics.writes.With(labels),
+		writesErr:      globalWALMetrics.writesErr.With(labels),
+		writesUnsynced: globalWALMetrics.writesUnsynced.With(labels),
+		syncBatchSize:  globalWALMetrics.syncBatchSize.With(labels),
+		syncDuration:   globalWALMetrics.syncDuration.With(labels),
 	}
 }
 
@@ -270,14 +386,31 @@ func (l *WAL) Open() error {
 			if err != nil {
 				return err
 			}
-			if _, err := fd.Seek(0, io.SeekEnd); err != nil {
+			version, err := readWALSegmentVersion(fd)
+			if err == errWALSegmentTorn {
+				// The segment holds no entries.  It is left for the cache loader
+				// to report and truncate, and writes go to a new segment.
+				_ = fd.Close()
+				l.logger.Warn("Torn WAL segment header", zap.String("path", lastSegment), zap.Int64("size", stat.Size()))
+			} else if err != nil {
 				_ = fd.Close()
 				return err
-			}
-			l.currentSegmentWriter = NewWALSegmentWriter(fd)
+			} else if version > WALSegmentVersion {
+				_ = fd.Close()
+				return fmt.Errorf("%w %d: %s", ErrWALSegmentVersion, version, lastSegment)
+			} else {
+				if _, err := fd.Seek(0, io.SeekEnd); err != nil {
+					_ = fd.Close()
+					return err
+				}
+
+				// Keep appending entries in the format of the segment.
+				l.currentSegmentWriter = NewWALSegmentWriter(fd)
+				l.currentSegmentWriter.version = version
 
-			// Set the correct size on the segment writer
-			l.currentSegmentWriter.size = int(stat.Size())
+				// Set the correct size on the segment writer
+				l.currentSegmentWriter.size = int(stat.Size())
+			}
 		}
 	}
 
@@ -312,6 +445,11 @@ func (l *WAL) scheduleSync() {
 		return
 	}
 
+	if l.groupCommitBytes > 0 {
+		go l.groupCommit()
+		return
+	}
+
 	// Fsync the wal and notify all pending waiters
 	go func() {
 		var timerCh <-chan time.Time
@@ -349,10 +487,76 @@ func (l *WAL) scheduleSync() {
 	}()
 }
 
+// groupCommit fsyncs the writes waiting for the current wal segment to be synced
+// once they are due, and returns once no writes are waiting.  Writes are due once
+// enough bytes are pending, once their commit deadline is reached, or as soon as
+// no other write is about to be added to them.
+func (l *WAL) groupCommit() {
+	for {
+		l.mu.Lock()
+		if len(l.syncWaiters) == 0 {
+			atomic.StoreUint64(&l.syncCount, 0)
+			l.mu.Unlock()
+			return
+		}
+
+		wait := time.Until(l.commitDeadline)
+		if l.commitBytes >= l.groupCommitBytes || wait <= 0 || atomic.LoadInt64(&l.writesInFlight) == 0 {
+			l.sync()
+			l.mu.Unlock()
+			continue
+		}
+		l.mu.Unlock()
+
+		t := time.NewTimer(wait)
+		select {
+		case <-l.commitCh:
+		case <-t.C:
+		case <-l.closing:
+			t.Stop()
+			atomic.StoreUint64(&l.syncCount, 0)
+			return
+		}
+		t.Stop()
+	}
+}
+
+// addCommit accounts for a write of n bytes waiting for group commit, whose
+// context has the given deadline, if any.  Callers must ensure a write lock on
+// the WAL is obtained before calling addCommit.
+func (l *WAL) addCommit(n int, deadline time.Time, hasDeadline bool) {
+	if len(l.syncWaiters) == 0 {
+		l.commitDeadline = time.Now().Add(l.groupCommitLatency)
+	}
+	l.commitBytes += n
+
+	// Leave time for the fsync to complete before the deadline of the write.
+	if hasDeadline {
+		if due := deadline.Add(-l.syncLatency); due.Before(l.commitDeadline) {
+			l.commitDeadline = due
+		}
+	}
+}
+
+// notifyCommit wakes up group commit to check if pending writes are due.
+func (l *WAL) notifyCommit() {
+	select {
+	case l.commitCh <- struct{}{}:
+	default:
+	}
+}
+
 // sync fsyncs the current wal segments and notifies any waiters.  Callers must ensure
 // a write lock on the WAL is obtained before calling sync.
 func (l *WAL) sync() {
+	start := time.Now()
 	err := l.currentSegmentWriter.sync()
+	l.syncLatency = time.Since(start)
+	l.stats.syncDuration.Observe(l.syncLatency.Seconds())
+	l.stats.syncBatchSize.Observe(float64(len(l.syncWaiters)))
+	l.commitBytes = 0
+
+	l.publish(err)
 	for len(l.syncWaiters) > 0 {
 		errC := <-l.syncWaiters
 		errC <- err
@@ -361,7 +565,9 @@ func (l *WAL) sync() {
 
 // WriteMulti writes the given values to the WAL. It returns the WAL segment ID to
 // which the points were written. If an error is returned the segment ID should
-// be ignored.
+// be ignored.  If ctx is done before the values are written, its error is
+// returned.  If it is done once they are written, while they wait to be synced,
+// the write succeeds: the values are synced with later writes.
 func (l *WAL) WriteMulti(ctx context.Context, values map[string][]Value) (int, error) {
 	entry := &WriteWALEntry{
 		Values: values,
@@ -454,17 +660,26 @@ func (l *WAL) writeToLog(ctx context.Context, entry WALEntry) (int, error) {
 	// limit how many concurrent encodings can be in flight.  Since we can only
 	// write one at a time to disk, a slow disk can cause the allocations below
 	// to increase quickly.  If we're backed up, wait until others have completed.
-	cancel := func() {}
+	takeCtx, cancel := ctx, func() {}
 	if l.maxWriteWait > 0 {
-		ctx, cancel = context.WithTimeout(ctx, l.maxWriteWait)
+		takeCtx, cancel = context.WithTimeout(ctx, l.maxWriteWait)
 	}
-	if err := l.limiter.Take(ctx); err != nil {
+	if err := l.limiter.Take(takeCtx); err != nil {
 		cancel()
 		return 0, err
 	}
 	defer l.limiter.Release()
 	cancel()
 
+	// Group commit waits for writes in flight to join the next fsync.
+	atomic.AddInt64(&l.writesInFlight, 1)
+	inFlight := true
+	defer func() {
+		if inFlight && atomic.AddInt64(&l.writesInFlight, -1) == 0 {
+			l.notifyCommit()
+		}
+	}()
+
 	bytes := bytesPool.Get(entry.MarshalSize())
 
 	b, err := entry.Encode(bytes)
@@ -475,10 +690,23 @@ func (l *WAL) writeToLog(ctx context.Context, entry WALEntry) (int, error) {
 
 	encBuf := bytesPool.Get(snappy.MaxEncodedLen(len(b)))
 
-	compressed := snappy.Encode(encBuf, b)
-	bytesPool.Put(bytes)
+	entryType := entry.Type()
+	var compressed []byte
+	if l.codec == nil || l.codec.ID() == codecSnappy {
+		compressed = snappy.Encode(encBuf, b)
+	} else {
+		compressed, err = l.codec.Encode(encBuf, b)
+		if err != nil {
+			bytesPool.Put(bytes)
+			bytesPool.Put(encBuf)
+			return -1, err
+		}
+		entryType |= WalEntryType(l.codec.ID() << 4)
+	}
 
-	syncErr := make(chan error)
+	// The error channel is buffered as the write stops waiting for the fsync once
+	// its context is done.
+	syncErr := make(chan error, 1)
 
 	segID, err := func() (int, error) {
 		l.mu.Lock()
@@ -491,6 +719,12 @@ func (l *WAL) writeToLog(ctx context.Context, entry WALEntry) (int, error) {
 		default:
 		}
 
+		// Entries can't be rolled back once written, so fail writes whose
+		// context is done before writing them.
+		if err := ctx.Err(); err != nil {
+			return -1, err
+		}
+
 		// roll the segment file if needed
 		if err := l.rollSegment(); err != nil {
 			return -1, fmt.Errorf("error rolling WAL segment: %v", err)
@@ -498,11 +732,25 @@ func (l *WAL) writeToLog(ctx context.Context, entry WALEntry) (int, error) {
 
 		// write and sync
 		oldSize := l.currentSegmentWriter.size
-		if err := l.currentSegmentWriter.Write(entry.Type(), compressed); err != nil {
+		if err := l.currentSegmentWriter.Write(entryType, compressed); err != nil {
 			return -1, fmt.Errorf("error writing WAL entry: %v", err)
 		}
 		sizeDelta := l.currentSegmentWriter.size - oldSize
 
+		if len(l.subscribers) > 0 {
+			l.pending = append(l.pending, WALStreamEntry{
+				SegmentID: l.currentSegmentID,
+				Offset:    int64(l.currentSegmentWriter.offset),
+				Type:      entry.Type(),
+				Data:      append([]byte(nil), b...),
+			})
+		}
+
+		if l.groupCommitBytes > 0 {
+			deadline, ok := ctx.Deadline()
+			l.addCommit(sizeDelta, deadline, ok)
+		}
+
 		select {
 		case l.syncWaiters <- syncErr:
 		default:
@@ -519,14 +767,28 @@ func (l *WAL) writeToLog(ctx context.Context, entry WALEntry) (int, error) {
 
 	}()
 
+	bytesPool.Put(bytes)
 	bytesPool.Put(encBuf)
 
+	// The write has been added to the segment, or failed.
+	inFlight = false
+	if atomic.AddInt64(&l.writesInFlight, -1) == 0 || err == nil {
+		l.notifyCommit()
+	}
+
 	if err != nil {
 		return segID, err
 	}
 
-	// schedule an fsync and wait for it to complete
-	return segID, <-syncErr
+	// wait for the scheduled fsync to complete
+	select {
+	case err := <-syncErr:
+		return segID, err
+	case <-ctx.Done():
+		l.stats.writesUnsynced.Inc()
+		l.logger.Warn("WAL write returned before its entry was synced", zap.Int("segment_id", segID), zap.Error(ctx.Err()))
+		return segID, nil
+	}
 }
 
 // rollSegment checks if the current segment is due to roll over to a new segment;
@@ -614,6 +876,7 @@ func (l *WAL) Close() error {
 		_ = l.currentSegmentWriter.close()
 		l.currentSegmentWriter = nil
 	}
+	l.closeSubscriptions(ErrWALClosed)
 
 	l.once.Do(func() {
 		// Close, but don't set to nil so future goroutines can still be signaled
@@ -699,6 +962,14 @@ func (w *WriteWALEntry) MarshalSize() int {
 				}
 				encLen += 4 + len(str.value)
 			}
+		case SketchValue:
+			for _, vv := range v {
+				sk, ok := vv.(SketchValue)
+				if !ok {
+					return 0
+				}
+				encLen += 4 + sk.value.BinarySize()
+			}
 		default:
 			return 0
 		}
@@ -721,6 +992,7 @@ func (w *WriteWALEntry) Encode(dst []byte) ([]byte, error) {
 	// and N byte value.  The value is dependent on the type being encoded.  float64,
 	// int64, use 8 bytes, boolean uses 1 byte, and string is similar to the key encoding,
 	// except that string values have a 4-byte length, and keys only use 2 bytes.
+	// Sketch values are written like strings, using their binary encoding.
 	//
 	// This structure is then repeated for each key an value slices.
 	//
@@ -756,6 +1028,8 @@ func (w *WriteWALEntry) Encode(dst []byte) ([]byte, error) {
 			curType = booleanEntryType
 		case StringValue:
 			curType = stringEntryType
+		case SketchValue:
+			curType = sketchEntryType
 		default:
 			return nil, fmt.Errorf("unsupported value type: %T", v[0])
 		}
@@ -809,6 +1083,15 @@ func (w *WriteWALEntry) Encode(dst []byte) ([]byte, error) {
 				binary.BigEndian.PutUint32(dst[n:n+4], uint32(len(vv.value)))
 				n += 4
 				n += copy(dst[n:], vv.value)
+			case SketchValue:
+				if curType != sketchEntryType {
+					return nil, fmt.Errorf("incorrect value found in %T slice: %T", v[0].Value(), vv)
+				}
+				sz := vv.value.BinarySize()
+				binary.BigEndian.PutUint32(dst[n:n+4], uint32(sz))
+				n += 4
+				vv.value.AppendBinary(dst[n:n])
+				n += sz
 			default:
 				return nil, fmt.Errorf("unsupported value found in %T slice: %T", v[0].Value(), vv)
 			}
@@ -949,6 +1232,32 @@ func (w *WriteWALEntry) UnmarshalBinary(b []byte) error {
 			}
 			w.Values[k] = values
 
+		case sketchEntryType:
+			values := make([]Value, 0, nvals)
+			for j := 0; j < nvals; j++ {
+				if i+12 > len(b) {
+					return ErrWALCorrupt
+				}
+
+				un := int64(binary.BigEndian.Uint64(b[i : i+8]))
+				i += 8
+
+				length := int(binary.BigEndian.Uint32(b[i : i+4]))
+				i += 4
+
+				if i+length > len(b) {
+					return ErrWALCorrupt
+				}
+
+				v, err := sketch.Unmarshal(b[i : i+length])
+				if err != nil {
+					return ErrWALCorrupt
+				}
+				i += length
+				values = append(values, NewSketchValue(un, v))
+			}
+			w.Values[k] = values
+
 		default:
 			return fmt.Errorf("unsupported value type: %#v", typ)
 		}
@@ -1111,16 +1420,20 @@ func (w *DeleteRangeWALEntry) Type() WalEntryType {
 
 // WALSegmentWriter writes WAL segments.
 type WALSegmentWriter struct {
-	bw   *bufio.Writer
-	w    io.WriteCloser
-	size int
+	bw      *bufio.Writer
+	w       io.WriteCloser
+	size    int
+	offset  int // offset of the last entry written
+	version byte
 }
 
-// NewWALSegmentWriter returns a new WALSegmentWriter writing to w.
+// NewWALSegmentWriter returns a new WALSegmentWriter writing a segment of the
+// current version to w.
 func NewWALSegmentWriter(w io.WriteCloser) *WALSegmentWriter {
 	return &WALSegmentWriter{
-		bw: bufio.NewWriterSize(w, 16*1024),
-		w:  w,
+		bw:      bufio.NewWriterSize(w, 16*1024),
+		w:       w,
+		version: WALSegmentVersion,
 	}
 }
 
@@ -1131,13 +1444,32 @@ func (w *WALSegmentWriter) path() string {
 	return ""
 }
 
-// Write writes entryType and the buffer containing compressed entry data.
+// Write writes entryType and the buffer containing compressed entry data.  The
+// header of the segment is written before its first entry.
 func (w *WALSegmentWriter) Write(entryType WalEntryType, compressed []byte) error {
-	var buf [5]byte
+	if w.size == 0 && w.version != walSegmentVersionUnchecked {
+		var hdr [WALSegmentHeaderSize]byte
+		binary.BigEndian.PutUint32(hdr[0:4], WALSegmentMagicNumber)
+		hdr[4] = w.version
+
+		if _, err := w.bw.Write(hdr[:]); err != nil {
+			return err
+		}
+		w.size += len(hdr)
+	}
+
+	var buf [9]byte
 	buf[0] = byte(entryType)
 	binary.BigEndian.PutUint32(buf[1:5], uint32(len(compressed)))
 
-	if _, err := w.bw.Write(buf[:]); err != nil {
+	n := 5
+	if w.version != walSegmentVersionUnchecked {
+		crc := crc32.Update(crc32.ChecksumIEEE(buf[:5]), crc32.IEEETable, compressed)
+		binary.BigEndian.PutUint32(buf[5:9], crc)
+		n = 9
+	}
+
+	if _, err := w.bw.Write(buf[:n]); err != nil {
 		return err
 	}
 
@@ -1145,7 +1477,8 @@ func (w *WALSegmentWriter) Write(entryType WalEntryType, compressed []byte) erro
 		return err
 	}
 
-	w.size += len(buf) + len(compressed)
+	w.offset = w.size
+	w.size += n + len(compressed)
 
 	return nil
 }
@@ -1176,11 +1509,12 @@ func (w *WALSegmentWriter) close() error {
 
 // WALSegmentReader reads WAL segments.
 type WALSegmentReader struct {
-	rc    io.ReadCloser
-	r     *bufio.Reader
-	entry WALEntry
-	n     int64
-	err   error
+	rc      io.ReadCloser
+	r       *bufio.Reader
+	entry   WALEntry
+	n       int64
+	err     error
+	version byte
 }
 
 // NewWALSegmentReader returns a new WALSegmentReader reading from r.
@@ -1197,15 +1531,55 @@ func (r *WALSegmentReader) Reset(rc io.ReadCloser) {
 	r.entry = nil
 	r.n = 0
 	r.err = nil
+	r.version = 0
+}
+
+// readHeader reads the header of the segment, if it has one.
+func (r *WALSegmentReader) readHeader() error {
+	r.version = walSegmentVersionUnchecked
+
+	// A segment too short for a header is read as entries, which fail to read
+	// if the segment is not empty.
+	hdr, err := r.r.Peek(WALSegmentHeaderSize)
+	if err != nil || binary.BigEndian.Uint32(hdr[0:4]) != WALSegmentMagicNumber {
+		return nil
+	}
+
+	version := hdr[4]
+	if version > WALSegmentVersion {
+		return fmt.Errorf("%w %d", ErrWALSegmentVersion, version)
+	}
+	if _, err := r.r.Discard(WALSegmentHeaderSize); err != nil {
+		return err
+	}
+	r.version = version
+	r.n += WALSegmentHeaderSize
+	return nil
+}
+
+// Version returns the version of the segment, once Next has been called.
+func (r *WALSegmentReader) Version() byte {
+	return r.version
 }
 
 // Next indicates if there is a value to read.
 func (r *WALSegmentReader) Next() bool {
 	var nReadOK int
 
-	// read the type and the length of the entry
-	var lv [5]byte
-	n, err := io.ReadFull(r.r, lv[:])
+	if r.version == 0 {
+		if err := r.readHeader(); err != nil {
+			r.err = err
+			return true
+		}
+	}
+
+	// read the type and the length of the entry, followed by its checksum
+	var lv [9]byte
+	hdrLen := 5
+	if r.version != walSegmentVersionUnchecked {
+		hdrLen = 9
+	}
+	n, err := io.ReadFull(r.r, lv[:hdrLen])
 	if err == io.EOF {
 		return false
 	}
@@ -1226,28 +1600,55 @@ func (r *WALSegmentReader) Next() bool {
 
 	// read the compressed block and decompress it
 	n, err = io.ReadFull(r.r, b[:length])
+	if err == io.EOF {
+		// The segment ends after the header of the entry.
+		err = io.ErrUnexpectedEOF
+	}
 	if err != nil {
 		r.err = err
 		return true
 	}
 	nReadOK += n
 
-	decLen, err := snappy.DecodedLen(b[:length])
-	if err != nil {
-		r.err = err
-		return true
+	if hdrLen == 9 {
+		crc := crc32.Update(crc32.ChecksumIEEE(lv[:5]), crc32.IEEETable, b[:length])
+		if crc != binary.BigEndian.Uint32(lv[5:9]) {
+			r.err = ErrWALChecksum
+			return true
+		}
 	}
-	decBuf := *(getBuf(decLen))
-	defer putBuf(&decBuf)
 
-	data, err := snappy.Decode(decBuf, b[:length])
-	if err != nil {
-		r.err = err
-		return true
+	var data []byte
+	if id := entryType >> 4; id == 0 {
+		decLen, err := snappy.DecodedLen(b[:length])
+		if err != nil {
+			r.err = err
+			return true
+		}
+		decBuf := *(getBuf(decLen))
+		defer putBuf(&decBuf)
+
+		data, err = snappy.Decode(decBuf, b[:length])
+		if err != nil {
+			r.err = err
+			return true
+		}
+	} else {
+		codec, err := blockCodec(id)
+		if err != nil {
+			r.err = err
+			return true
+		}
+
+		data, err = codec.Decode(nil, b[:length])
+		if err != nil {
+			r.err = err
+			return true
+		}
 	}
 
 	// and marshal it and send it to the cache
-	switch WalEntryType(entryType) {
+	switch WalEntryType(entryType & walEntryTypeMask) {
 	case WriteWALEntryType:
 		r.entry = &WriteWALEntry{
 			Values: make(map[string][]Value),
@@ -1299,6 +1700,25 @@ func (r *WALSegmentReader) Close() error {
 	return err
 }
 
+// readWALSegmentVersion returns the version of the WAL segment in f.  It
+// returns errWALSegmentTorn if f is not empty but shorter than a header, as
+// neither a header nor an entry of a segment without one fits.
+func readWALSegmentVersion(f *os.File) (byte, error) {
+	var hdr [WALSegmentHeaderSize]byte
+	if n, err := f.ReadAt(hdr[:], 0); err == io.EOF && n == 0 {
+		return walSegmentVersionUnchecked, nil
+	} else if err == io.EOF {
+		return 0, errWALSegmentTorn
+	} else if err != nil {
+		return 0, err
+	}
+
+	if binary.BigEndian.Uint32(hdr[0:4]) != WALSegmentMagicNumber {
+		return walSegmentVersionUnchecked, nil
+	}
+	return hdr[4], nil
+}
+
 // idFromFileName parses the segment file ID from its name.
 func idFromFileName(name string) (int, error) {
 	parts := strings.Split(filepath.Base(name), ".")
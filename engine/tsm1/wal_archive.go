@@ -0,0 +1,267 @@
+package tsm1
+
+// A WAL archive records the entries of a WAL stream with the time they were
+// committed.  Segments are removed from the WAL once the cache is written to a
+// TSM file, so the archive keeps the entries written after a backup around.
+// Restoring the backup and replaying the archive up to a point in time restores
+// the shard as it was at that time.
+//
+// An archive covers a single WAL subscription.  When the subscription ends, an
+// end marker recording the time it ended is appended, so that a replay past
+// that time fails rather than silently leaving out the entries that were not
+// archived.  A new archive, and a new backup taken after it was started, is
+// needed whenever archiving is restarted.
+//
+// Each archived entry is stored as:
+//
+//	┌─────────────────────────────────────────────────────────────────────┐
+//	│                            Archived Entry                           │
+//	├─────────┬───────────┬──────────┬──────┬────────┬────────────────────┤
+//	│  Time   │ SegmentID │  Offset  │ Type │ Length │   Compressed Data  │
+//	│ 8 bytes │  8 bytes  │ 8 bytes  │1 byte│4 bytes │       N bytes      │
+//	└─────────┴───────────┴──────────┴──────┴────────┴────────────────────┘
+//
+// Time is in nanoseconds since the epoch and the data is compressed with snappy.
+// The end marker has type walArchiveEndType and holds the error the
+// subscription ended with, if any.
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/influxdata/influxdb/v2/tsdb"
+)
+
+const (
+	// walArchiveHeaderSize is the size of the header of an archived entry.
+	walArchiveHeaderSize = 8 + 8 + 8 + 1 + 4
+
+	// walArchiveEndType is the type of the marker written when the
+	// subscription of an archive ends.
+	walArchiveEndType WalEntryType = 0xFF
+)
+
+// ErrWALArchiveIncomplete is returned when replaying a WAL archive that does
+// not cover the requested time.
+var ErrWALArchiveIncomplete = errors.New("WAL archive incomplete")
+
+// WALArchiveWriter writes WAL stream entries to an archive.
+type WALArchiveWriter struct {
+	bw  *bufio.Writer
+	buf []byte
+}
+
+// NewWALArchiveWriter returns a new WALArchiveWriter writing to w.
+func NewWALArchiveWriter(w io.Writer) *WALArchiveWriter {
+	return &WALArchiveWriter{bw: bufio.NewWriterSize(w, 16*1024)}
+}
+
+// Write appends entry to the archive.
+func (w *WALArchiveWriter) Write(entry *WALStreamEntry) error {
+	w.buf = snappy.Encode(w.buf[:cap(w.buf)], entry.Data)
+
+	var hdr [walArchiveHeaderSize]byte
+	binary.BigEndian.PutUint64(hdr[0:8], uint64(entry.Time.UnixNano()))
+	binary.BigEndian.PutUint64(hdr[8:16], uint64(entry.SegmentID))
+	binary.BigEndian.PutUint64(hdr[16:24], uint64(entry.Offset))
+	hdr[24] = byte(entry.Type)
+	binary.BigEndian.PutUint32(hdr[25:29], uint32(len(w.buf)))
+
+	if _, err := w.bw.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.bw.Write(w.buf)
+	return err
+}
+
+// Flush writes any buffered entries to the underlying writer.
+func (w *WALArchiveWriter) Flush() error {
+	return w.bw.Flush()
+}
+
+// writeEnd appends the end marker of a subscription that ended at end with
+// err.
+func (w *WALArchiveWriter) writeEnd(end time.Time, err error) error {
+	var msg []byte
+	if err != nil {
+		msg = []byte(err.Error())
+	}
+	return w.Write(&WALStreamEntry{Time: end, Type: walArchiveEndType, Data: msg})
+}
+
+// ArchiveWAL writes the entries of s to w until the subscription ends, followed
+// by its end marker.  Entries are flushed whenever no more entries are waiting.
+// It returns the error the subscription ended with, which is nil if it was
+// closed by WALSubscription.Close.
+func ArchiveWAL(s *WALSubscription, w *WALArchiveWriter) error {
+	for {
+		entry, ok := <-s.Entries()
+		if !ok {
+			if err := w.writeEnd(s.End(), s.Err()); err != nil {
+				return err
+			} else if err := w.Flush(); err != nil {
+				return err
+			}
+			return s.Err()
+		}
+
+		if err := w.Write(&entry); err != nil {
+			return err
+		}
+		if len(s.c) == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// WALArchiveReader reads the entries of a WAL archive.
+type WALArchiveReader struct {
+	r     *bufio.Reader
+	entry WALStreamEntry
+	buf   []byte
+	err   error
+}
+
+// NewWALArchiveReader returns a new WALArchiveReader reading from r.
+func NewWALArchiveReader(r io.Reader) *WALArchiveReader {
+	return &WALArchiveReader{r: bufio.NewReader(r)}
+}
+
+// Next reads the next entry.  It returns false at the end of the archive or if
+// an error occurred.  The end marker of the archive is read as an entry of an
+// unknown type.
+func (r *WALArchiveReader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+
+	var hdr [walArchiveHeaderSize]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err == io.EOF {
+		return false
+	} else if err != nil {
+		r.err = fmt.Errorf("%w: %v", ErrWALCorrupt, err)
+		return false
+	}
+
+	length := binary.BigEndian.Uint32(hdr[25:29])
+	if cap(r.buf) < int(length) {
+		r.buf = make([]byte, length)
+	}
+	r.buf = r.buf[:length]
+	if _, err := io.ReadFull(r.r, r.buf); err != nil {
+		r.err = fmt.Errorf("%w: %v", ErrWALCorrupt, err)
+		return false
+	}
+
+	data, err := snappy.Decode(nil, r.buf)
+	if err != nil {
+		r.err = fmt.Errorf("%w: %v", ErrWALCorrupt, err)
+		return false
+	}
+
+	r.entry = WALStreamEntry{
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(hdr[0:8]))).UTC(),
+		SegmentID: int(binary.BigEndian.Uint64(hdr[8:16])),
+		Offset:    int64(binary.BigEndian.Uint64(hdr[16:24])),
+		Type:      WalEntryType(hdr[24]),
+		Data:      data,
+	}
+	return true
+}
+
+// Entry returns the entry read by the last call to Next.
+func (r *WALArchiveReader) Entry() WALStreamEntry {
+	return r.entry
+}
+
+// Err returns the error that stopped Next, if any.
+func (r *WALArchiveReader) Err() error {
+	return r.err
+}
+
+// ReplayWALArchive replays the entries of the archive in r committed at or
+// before asOf against shard, and returns the number of entries replayed.
+// Combined with restoring a backup of the shard taken after the archive was
+// started, it restores the shard as it was at asOf.
+//
+// Entries already included in the backup are replayed again.  Writes overwrite
+// the values they wrote, sketches included, and deletes remove them again, so
+// this does not change the result.
+//
+// ErrWALArchiveIncomplete is returned if the archive ends, or its subscription
+// ended, at or before asOf; the entries replayed up to then are kept.
+func ReplayWALArchive(ctx context.Context, shard tsdb.WALReplayer, r io.Reader, asOf time.Time) (int, error) {
+	ar := NewWALArchiveReader(r)
+
+	var n int
+	for ar.Next() {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		se := ar.Entry()
+		if se.Time.After(asOf) {
+			// Entries are archived in the order they were committed.
+			return n, nil
+		} else if se.Type == walArchiveEndType {
+			if len(se.Data) > 0 {
+				return n, fmt.Errorf("%w: archiving ended at %s: %s", ErrWALArchiveIncomplete, se.Time.Format(time.RFC3339Nano), se.Data)
+			}
+			return n, fmt.Errorf("%w: archiving ended at %s", ErrWALArchiveIncomplete, se.Time.Format(time.RFC3339Nano))
+		}
+
+		entry, err := se.Entry()
+		if err != nil {
+			return n, err
+		}
+		if err := ReplayWALEntry(ctx, shard, entry); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := ar.Err(); err != nil {
+		return n, err
+	}
+	return n, fmt.Errorf("%w: archive ends before %s", ErrWALArchiveIncomplete, asOf.Format(time.RFC3339Nano))
+}
+
+// ArchiveWAL subscribes to the WAL of the engine and writes its entries to an
+// archive in w in the background.  Entries committed after ArchiveWAL returns
+// are archived until the returned closer is closed.  Closing it returns the
+// error that ended archiving early, such as ErrWALSubscriberLagging.
+func (e *Engine) ArchiveWAL(w io.Writer) (io.Closer, error) {
+	s, err := e.SubscribeWAL(0)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &walArchiver{s: s, done: make(chan error, 1)}
+	go func() { a.done <- ArchiveWAL(s, NewWALArchiveWriter(w)) }()
+	return a, nil
+}
+
+// walArchiver archives a WAL subscription in the background.
+type walArchiver struct {
+	s    *WALSubscription
+	done chan error
+}
+
+// Close ends the subscription and waits for its entries to be archived.
+func (a *walArchiver) Close() error {
+	a.s.Close()
+	return <-a.done
+}
+
+// ReplayWALArchive replays the archive in r up to asOf against shard.  See the
+// ReplayWALArchive function.
+func (e *Engine) ReplayWALArchive(ctx context.Context, shard tsdb.WALReplayer, r io.Reader, asOf time.Time) (int, error) {
+	return ReplayWALArchive(ctx, shard, r, asOf)
+}
@@ -0,0 +1,140 @@
+package tsm1_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/v2/models"
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxdb/v2/tsdb/engine/tsm1"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestWALArchive_ReadWrite(t *testing.T) {
+	entries := []tsm1.WALStreamEntry{
+		{SegmentID: 1, Offset: 0, Time: time.Unix(0, 10).UTC(), Type: tsm1.WriteWALEntryType, Data: []byte("foo")},
+		{SegmentID: 2, Offset: 9, Time: time.Unix(0, 20).UTC(), Type: tsm1.DeleteRangeWALEntryType, Data: []byte("bar")},
+	}
+
+	var buf bytes.Buffer
+	w := tsm1.NewWALArchiveWriter(&buf)
+	for i := range entries {
+		require.NoError(t, w.Write(&entries[i]))
+	}
+	require.NoError(t, w.Flush())
+
+	r := tsm1.NewWALArchiveReader(bytes.NewReader(buf.Bytes()))
+	for _, exp := range entries {
+		require.True(t, r.Next())
+		require.Equal(t, exp, r.Entry())
+	}
+	require.False(t, r.Next())
+	require.NoError(t, r.Err())
+
+	// A truncated archive is reported as corrupt.
+	r = tsm1.NewWALArchiveReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	require.True(t, r.Next())
+	require.False(t, r.Next())
+	require.True(t, errors.Is(r.Err(), tsm1.ErrWALCorrupt))
+}
+
+// newArchiveTestStore returns an open store with shard 1 in db0/rp0.
+func newArchiveTestStore(tb testing.TB, index string) *tsdb.Store {
+	tb.Helper()
+
+	path := tb.TempDir()
+	s := tsdb.NewStore(path)
+	s.EngineOptions.IndexVersion = index
+	s.EngineOptions.Config.WALDir = filepath.Join(path, "wal")
+	s.WithLogger(zaptest.NewLogger(tb))
+	require.NoError(tb, s.Open(context.Background()))
+	tb.Cleanup(func() { s.Close() })
+
+	require.NoError(tb, s.CreateShard(context.Background(), "db0", "rp0", 1, true))
+	return s
+}
+
+// Ensures a shard restored from a backup and a WAL archive replayed up to a
+// point in time includes the writes made after the backup but not a later
+// accidental delete.
+func TestReplayWALArchive_PointInTime(t *testing.T) {
+	for _, index := range tsdb.RegisteredIndexes() {
+		t.Run(index, func(t *testing.T) {
+			ctx := context.Background()
+			s := newArchiveTestStore(t, index)
+
+			var archive bytes.Buffer
+			archiver, err := s.ArchiveShardWAL(1, &archive)
+			require.NoError(t, err)
+
+			require.NoError(t, s.WriteToShard(ctx, 1, models.MustParsePointsString(`cpu,host=A value=1 1000000000`)))
+
+			var backup bytes.Buffer
+			require.NoError(t, s.BackupShard(1, time.Time{}, &backup))
+
+			require.NoError(t, s.WriteToShard(ctx, 1, models.MustParsePointsString(`cpu,host=A value=2 2000000000`)))
+			asOf := time.Now()
+			time.Sleep(time.Millisecond)
+
+			require.NoError(t, s.DeleteMeasurement(ctx, "db0", "cpu"))
+
+			require.NoError(t, archiver.Close())
+
+			restored := newArchiveTestStore(t, index)
+			n, err := restored.RestoreShardAsOf(ctx, 1, &backup, &archive, asOf)
+			require.NoError(t, err)
+			require.Equal(t, 2, n)
+
+			engine, err := restored.Shard(1).Engine()
+			require.NoError(t, err)
+			e := engine.(*tsm1.Engine)
+
+			key := []byte("cpu,host=A#!~#value")
+			values, err := e.FileStore.Read(key, 1000000000)
+			require.NoError(t, err)
+			require.Equal(t, 1, len(values))
+			require.Equal(t, 1.0, values[0].Value())
+
+			// Both writes are replayed into the cache, including the one that
+			// is already part of the backup.
+			values = e.Cache.Values(key)
+			require.Equal(t, 2, len(values))
+			require.Equal(t, int64(2000000000), values[1].UnixNano())
+			require.Equal(t, 2.0, values[1].Value())
+		})
+	}
+}
+
+// Ensures replaying an archive whose subscription fell behind fails past the
+// entries that were not archived.
+func TestReplayWALArchive_Lagging(t *testing.T) {
+	ctx := context.Background()
+	s := newArchiveTestStore(t, tsdb.DefaultIndex)
+
+	engine, err := s.Shard(1).Engine()
+	require.NoError(t, err)
+	sub, err := engine.(*tsm1.Engine).SubscribeWAL(1)
+	require.NoError(t, err)
+
+	require.NoError(t, s.WriteToShard(ctx, 1, models.MustParsePointsString(`cpu,host=A value=1 1000000000`)))
+	before := time.Now()
+	time.Sleep(time.Millisecond)
+	require.NoError(t, s.WriteToShard(ctx, 1, models.MustParsePointsString(`cpu,host=A value=2 2000000000`)))
+
+	var archive bytes.Buffer
+	require.Equal(t, tsm1.ErrWALSubscriberLagging, tsm1.ArchiveWAL(sub, tsm1.NewWALArchiveWriter(&archive)))
+
+	restored := newArchiveTestStore(t, tsdb.DefaultIndex)
+	n, err := tsm1.ReplayWALArchive(ctx, restored.Shard(1), bytes.NewReader(archive.Bytes()), before)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	n, err = tsm1.ReplayWALArchive(ctx, restored.Shard(1), bytes.NewReader(archive.Bytes()), time.Now())
+	require.True(t, errors.Is(err, tsm1.ErrWALArchiveIncomplete))
+	require.Equal(t, 1, n)
+}
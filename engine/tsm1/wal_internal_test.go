@@ -0,0 +1,58 @@
+package tsm1
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure a write whose context expires while it waits to be synced succeeds,
+// and that the entry is kept in the WAL.
+func TestWAL_WriteMulti_ContextDoneBeforeSync(t *testing.T) {
+	w := NewWAL(t.TempDir(), 0, 0, tsdb.EngineTags{})
+	w.syncDelay = time.Hour
+	require.NoError(t, w.Open())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	values := map[string][]Value{"cpu,host=A#!~#value": {NewValue(1, 1.0)}}
+	_, err := w.WriteMulti(ctx, values)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	files, err := segmentFileNames(w.Path())
+	require.NoError(t, err)
+
+	cache := NewCache(1024, tsdb.EngineTags{})
+	_, err = NewCacheLoader(files).Load(cache)
+	require.NoError(t, err)
+	require.Equal(t, Values{NewValue(1, 1.0)}, cache.Values([]byte("cpu,host=A#!~#value")))
+}
+
+// Ensure a write whose context is done before its entry is written fails
+// without writing it.
+func TestWAL_WriteMulti_ContextDoneBeforeWrite(t *testing.T) {
+	w := NewWAL(t.TempDir(), 0, 0, tsdb.EngineTags{})
+	require.NoError(t, w.Open())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	values := map[string][]Value{"cpu,host=A#!~#value": {NewValue(1, 1.0)}}
+	_, err := w.WriteMulti(ctx, values)
+	require.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+	require.NoError(t, w.Close())
+
+	files, err := segmentFileNames(w.Path())
+	require.NoError(t, err)
+
+	cache := NewCache(1024, tsdb.EngineTags{})
+	_, err = NewCacheLoader(files).Load(cache)
+	require.NoError(t, err)
+	require.Empty(t, cache.Values([]byte("cpu,host=A#!~#value")))
+}
@@ -0,0 +1,323 @@
+package tsm1
+
+// The WAL stream lets a follower observe the entries committed to the WAL of a
+// shard.  Entries are delivered in the order they were written, once they have
+// been synced to disk, and can be replayed against another shard to keep a warm
+// standby copy of it.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/influxdata/influxdb/v2/models"
+	"github.com/influxdata/influxdb/v2/pkg/bytesutil"
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxql"
+)
+
+// DefaultWALSubscriptionBufferSize is the number of entries buffered for a
+// subscriber when no size is given.
+const DefaultWALSubscriptionBufferSize = 1024
+
+var (
+	// ErrWALSubscriberLagging is returned by WALSubscription.Err when the
+	// subscription was closed because its buffer filled up.
+	ErrWALSubscriberLagging = errors.New("WAL subscriber lagging")
+
+	// ErrWALDisabled is returned when subscribing to an engine without a WAL.
+	ErrWALDisabled = errors.New("WAL disabled")
+)
+
+// WALStreamEntry is an entry committed to the WAL.
+type WALStreamEntry struct {
+	// SegmentID is the ID of the segment the entry was written to.
+	SegmentID int
+
+	// Offset is the offset of the entry in the segment.
+	Offset int64
+
+	// Time is the wall-clock time the entry was committed.
+	Time time.Time
+
+	// Type is the type of the entry.
+	Type WalEntryType
+
+	// Data is the uncompressed, encoded entry.
+	Data []byte
+}
+
+// Entry decodes the entry.
+func (e *WALStreamEntry) Entry() (WALEntry, error) {
+	var entry WALEntry
+	switch e.Type {
+	case WriteWALEntryType:
+		entry = &WriteWALEntry{Values: make(map[string][]Value)}
+	case DeleteWALEntryType:
+		entry = &DeleteWALEntry{}
+	case DeleteRangeWALEntryType:
+		entry = &DeleteRangeWALEntry{}
+	default:
+		return nil, fmt.Errorf("unknown wal entry type: %v", e.Type)
+	}
+
+	if err := entry.UnmarshalBinary(e.Data); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// WALSubscription receives the entries committed to a WAL.  The subscription is
+// closed if the subscriber falls behind by more than its buffer size, since the
+// WAL never blocks writes on a subscriber.
+type WALSubscription struct {
+	wal *WAL
+	c   chan WALStreamEntry
+	err error     // set before c is closed
+	end time.Time // set before c is closed
+
+	closed bool // protected by wal.mu
+}
+
+// Entries returns the channel the entries are delivered on.  It is closed when
+// the subscription ends.
+func (s *WALSubscription) Entries() <-chan WALStreamEntry {
+	return s.c
+}
+
+// Err returns the reason the subscription ended.  It must only be called once
+// the channel returned by Entries is closed, and returns nil if the
+// subscription was closed by Close.
+func (s *WALSubscription) Err() error {
+	return s.err
+}
+
+// End returns the time the subscription ended.  Every entry committed before
+// then was delivered, and none committed at or after it.  Like Err, it must
+// only be called once the channel returned by Entries is closed.
+func (s *WALSubscription) End() time.Time {
+	return s.end
+}
+
+// Close ends the subscription.
+func (s *WALSubscription) Close() {
+	s.wal.mu.Lock()
+	defer s.wal.mu.Unlock()
+	s.wal.unsubscribe(s, nil, time.Now().UTC())
+}
+
+// Subscribe returns a subscription to the entries committed to the WAL from now
+// on.  Up to bufferSize entries are buffered for the subscriber.
+func (l *WAL) Subscribe(bufferSize int) *WALSubscription {
+	if bufferSize <= 0 {
+		bufferSize = DefaultWALSubscriptionBufferSize
+	}
+
+	s := &WALSubscription{
+		wal: l,
+		c:   make(chan WALStreamEntry, bufferSize),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-l.closing:
+		s.err, s.end, s.closed = ErrWALClosed, time.Now().UTC(), true
+		close(s.c)
+	default:
+		l.subscribers = append(l.subscribers, s)
+	}
+	return s
+}
+
+// hasSubscribers returns true if the WAL has subscribers.
+func (l *WAL) hasSubscribers() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.subscribers) > 0
+}
+
+// publish sends the pending entries to the subscribers if they were synced
+// successfully.  Callers must hold a write lock on the WAL.
+func (l *WAL) publish(err error) {
+	pending := l.pending
+	l.pending = nil
+	if err != nil || len(pending) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	for i := range pending {
+		pending[i].Time = now
+	}
+
+	for _, s := range append([]*WALSubscription(nil), l.subscribers...) {
+		for _, entry := range pending {
+			select {
+			case s.c <- entry:
+				continue
+			default:
+				l.unsubscribe(s, ErrWALSubscriberLagging, now)
+			}
+			break
+		}
+	}
+}
+
+// unsubscribe removes s from the subscribers and closes it with err.  end is
+// the commit time of the first entry not delivered to s.  Callers must hold a
+// write lock on the WAL.
+func (l *WAL) unsubscribe(s *WALSubscription, err error, end time.Time) {
+	if s.closed {
+		return
+	}
+
+	for i := range l.subscribers {
+		if l.subscribers[i] == s {
+			l.subscribers = append(l.subscribers[:i], l.subscribers[i+1:]...)
+			break
+		}
+	}
+	s.err, s.end, s.closed = err, end, true
+	close(s.c)
+}
+
+// closeSubscriptions closes all subscriptions with err.  Callers must hold a
+// write lock on the WAL.
+func (l *WAL) closeSubscriptions(err error) {
+	now := time.Now().UTC()
+	for len(l.subscribers) > 0 {
+		l.unsubscribe(l.subscribers[0], err, now)
+	}
+	l.pending = nil
+}
+
+// SubscribeWAL returns a subscription to the entries committed to the WAL of
+// the engine.  Deletes of data already in TSM files are only logged while the
+// WAL has subscribers, so a follower must subscribe before it copies the shard.
+func (e *Engine) SubscribeWAL(bufferSize int) (*WALSubscription, error) {
+	if !e.WALEnabled {
+		return nil, ErrWALDisabled
+	}
+	return e.WAL.Subscribe(bufferSize), nil
+}
+
+// ReplayWALEntry applies entry to shard.
+func ReplayWALEntry(ctx context.Context, shard tsdb.WALReplayer, entry WALEntry) error {
+	switch en := entry.(type) {
+	case *WriteWALEntry:
+		points, err := walEntryPoints(en.Values)
+		if err != nil {
+			return err
+		}
+		return shard.WritePoints(ctx, points)
+	case *DeleteWALEntry:
+		return shard.DeleteSeriesRange(ctx, newWALSeriesIterator(en.Keys), math.MinInt64, math.MaxInt64)
+	case *DeleteRangeWALEntry:
+		return shard.DeleteSeriesRange(ctx, newWALSeriesIterator(en.Keys), en.Min, en.Max)
+	default:
+		return fmt.Errorf("unknown wal entry type: %T", entry)
+	}
+}
+
+// walEntryPoints converts the values of a WriteWALEntry back into points.
+func walEntryPoints(values map[string][]Value) ([]models.Point, error) {
+	type pointKey struct {
+		series string
+		time   int64
+	}
+
+	fields := make(map[pointKey]models.Fields)
+	for key, vals := range values {
+		seriesKey, field := SeriesAndFieldFromCompositeKey([]byte(key))
+		for _, v := range vals {
+			k := pointKey{series: string(seriesKey), time: v.UnixNano()}
+			if fields[k] == nil {
+				fields[k] = make(models.Fields)
+			}
+			if sv, ok := v.(SketchValue); ok {
+				// Sketches are written as their text encoding.
+				text, err := sv.RawValue().MarshalText()
+				if err != nil {
+					return nil, err
+				}
+				fields[k][string(field)] = string(text)
+				continue
+			}
+			fields[k][string(field)] = v.Value()
+		}
+	}
+
+	keys := make([]pointKey, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].series != keys[j].series {
+			return keys[i].series < keys[j].series
+		}
+		return keys[i].time < keys[j].time
+	})
+
+	points := make([]models.Point, 0, len(keys))
+	for _, k := range keys {
+		name, tags := models.ParseKeyBytes([]byte(k.series))
+		pt, err := models.NewPoint(string(name), tags, fields[k], time.Unix(0, k.time))
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, pt)
+	}
+	return points, nil
+}
+
+// walSeriesIterator iterates over the series of the composite keys of a WAL
+// delete entry.
+type walSeriesIterator struct {
+	keys [][]byte
+}
+
+// newWALSeriesIterator returns an iterator over the distinct series of keys.
+func newWALSeriesIterator(keys [][]byte) *walSeriesIterator {
+	seriesKeys := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		seriesKey, _ := SeriesAndFieldFromCompositeKey(key)
+		seriesKeys = append(seriesKeys, seriesKey)
+	}
+
+	bytesutil.Sort(seriesKeys)
+	distinct := seriesKeys[:0]
+	for _, key := range seriesKeys {
+		if len(distinct) == 0 || !bytes.Equal(key, distinct[len(distinct)-1]) {
+			distinct = append(distinct, key)
+		}
+	}
+	return &walSeriesIterator{keys: distinct}
+}
+
+func (itr *walSeriesIterator) Close() error { return nil }
+
+func (itr *walSeriesIterator) Next() (tsdb.SeriesElem, error) {
+	if len(itr.keys) == 0 {
+		return nil, nil
+	}
+	name, tags := models.ParseKeyBytes(itr.keys[0])
+	itr.keys = itr.keys[1:]
+	return walSeries{name: name, tags: tags}, nil
+}
+
+// walSeries is a series deleted by a WAL entry.
+type walSeries struct {
+	name []byte
+	tags models.Tags
+}
+
+func (s walSeries) Name() []byte        { return s.name }
+func (s walSeries) Tags() models.Tags   { return s.tags }
+func (s walSeries) Deleted() bool       { return false }
+func (s walSeries) Expr() influxql.Expr { return nil }
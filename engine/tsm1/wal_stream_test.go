@@ -0,0 +1,168 @@
+package tsm1_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxdb/v2/tsdb/engine/tsm1"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// mustReceiveWALEntry returns the next entry of s.
+func mustReceiveWALEntry(tb testing.TB, s *tsm1.WALSubscription) tsm1.WALStreamEntry {
+	tb.Helper()
+
+	select {
+	case entry, ok := <-s.Entries():
+		require.True(tb, ok, "subscription closed: %v", s.Err())
+		return entry
+	case <-time.After(10 * time.Second):
+		tb.Fatal("timed out waiting for WAL entry")
+	}
+	return tsm1.WALStreamEntry{}
+}
+
+func TestWAL_Subscribe(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWAL(dir, 0, 0)
+	require.NoError(t, w.Open())
+
+	s := w.Subscribe(0)
+
+	values := map[string][]tsm1.Value{
+		"cpu,host=A#!~#value": {tsm1.NewValue(1, 1.1)},
+	}
+	id, err := w.WriteMulti(context.Background(), values)
+	require.NoError(t, err)
+	_, err = w.DeleteRange(context.Background(), [][]byte{[]byte("cpu,host=A#!~#value")}, 1, 2)
+	require.NoError(t, err)
+
+	entry := mustReceiveWALEntry(t, s)
+	require.Equal(t, id, entry.SegmentID)
+	require.Equal(t, int64(tsm1.WALSegmentHeaderSize), entry.Offset)
+	require.Equal(t, tsm1.WriteWALEntryType, entry.Type)
+
+	we, err := entry.Entry()
+	require.NoError(t, err)
+	e, ok := we.(*tsm1.WriteWALEntry)
+	require.True(t, ok)
+	for k, v := range e.Values {
+		for i, vv := range v {
+			require.Equal(t, values[k][i].String(), vv.String())
+		}
+	}
+
+	entry = mustReceiveWALEntry(t, s)
+	require.Equal(t, id, entry.SegmentID)
+	require.Greater(t, entry.Offset, int64(0))
+
+	we, err = entry.Entry()
+	require.NoError(t, err)
+	de, ok := we.(*tsm1.DeleteRangeWALEntry)
+	require.True(t, ok)
+	require.Equal(t, "cpu,host=A#!~#value", string(de.Keys[0]))
+	require.Equal(t, int64(1), de.Min)
+	require.Equal(t, int64(2), de.Max)
+
+	require.NoError(t, w.Close())
+	_, ok = <-s.Entries()
+	require.False(t, ok)
+	require.Equal(t, tsm1.ErrWALClosed, s.Err())
+}
+
+func TestWAL_Subscribe_Lagging(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWAL(dir, 0, 0)
+	defer w.Close()
+	require.NoError(t, w.Open())
+
+	s := w.Subscribe(1)
+	for i := 0; i < 2; i++ {
+		_, err := w.WriteMulti(context.Background(), map[string][]tsm1.Value{
+			"cpu,host=A#!~#value": {tsm1.NewValue(int64(i), 1.1)},
+		})
+		require.NoError(t, err)
+	}
+
+	mustReceiveWALEntry(t, s)
+	_, ok := <-s.Entries()
+	require.False(t, ok)
+	require.Equal(t, tsm1.ErrWALSubscriberLagging, s.Err())
+
+	// Closed subscriptions no longer receive entries.
+	s = w.Subscribe(1)
+	s.Close()
+	_, err := w.WriteMulti(context.Background(), map[string][]tsm1.Value{
+		"cpu,host=A#!~#value": {tsm1.NewValue(3, 1.1)},
+	})
+	require.NoError(t, err)
+	_, ok = <-s.Entries()
+	require.False(t, ok)
+	require.NoError(t, s.Err())
+}
+
+// Ensures a follower store replaying the WAL stream of an engine ends up with
+// the same data, including deletes of data already written to TSM files.
+func TestEngine_SubscribeWAL_Follower(t *testing.T) {
+	for _, index := range tsdb.RegisteredIndexes() {
+		t.Run(index, func(t *testing.T) {
+			e := MustOpenEngine(t, index)
+
+			path := t.TempDir()
+			follower := tsdb.NewStore(path)
+			follower.EngineOptions.IndexVersion = index
+			follower.EngineOptions.Config.WALDir = filepath.Join(path, "wal")
+			follower.WithLogger(zaptest.NewLogger(t))
+			require.NoError(t, follower.Open(context.Background()))
+			t.Cleanup(func() { follower.Close() })
+			require.NoError(t, follower.CreateShard(context.Background(), "db0", "rp0", 1, true))
+			sh := follower.Shard(1)
+
+			s, err := e.SubscribeWAL(0)
+			require.NoError(t, err)
+			defer s.Close()
+
+			require.NoError(t, e.WritePointsString(
+				`cpu,host=A value=1.1 1000000000`,
+				`cpu,host=B value=1.2 1000000000`,
+			))
+			require.NoError(t, e.WritePointsString(
+				`cpu,host=A value=2.1,n=2i 2000000000`,
+			))
+			require.NoError(t, e.WriteSnapshot())
+
+			itr := &seriesIterator{keys: [][]byte{[]byte("cpu,host=A")}}
+			require.NoError(t, e.DeleteSeriesRange(context.Background(), itr, 0, 1500000000))
+
+			for i := 0; i < 3; i++ {
+				entry := mustReceiveWALEntry(t, s)
+				we, err := entry.Entry()
+				require.NoError(t, err)
+				require.NoError(t, tsm1.ReplayWALEntry(context.Background(), sh, we))
+			}
+
+			engine, err := sh.Engine()
+			require.NoError(t, err)
+			fe := engine.(*tsm1.Engine)
+
+			for _, exp := range []struct {
+				key    string
+				values []tsm1.Value
+			}{
+				{"cpu,host=A#!~#n", []tsm1.Value{tsm1.NewValue(2000000000, int64(2))}},
+				{"cpu,host=A#!~#value", []tsm1.Value{tsm1.NewValue(2000000000, 2.1)}},
+				{"cpu,host=B#!~#value", []tsm1.Value{tsm1.NewValue(1000000000, 1.2)}},
+			} {
+				values := fe.Cache.Values([]byte(exp.key))
+				require.Equal(t, len(exp.values), len(values), exp.key)
+				for i := range exp.values {
+					require.Equal(t, exp.values[i].String(), values[i].String())
+				}
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -16,6 +17,7 @@ import (
 	"github.com/influxdata/influxdb/v2/pkg/slices"
 	"github.com/influxdata/influxdb/v2/tsdb"
 	"github.com/influxdata/influxdb/v2/tsdb/engine/tsm1"
+	"github.com/influxdata/influxdb/v2/tsdb/sketch"
 	"github.com/stretchr/testify/require"
 )
 
@@ -67,6 +69,90 @@ func TestWALWriter_WriteMulti_Single(t *testing.T) {
 	require.Equal(t, r.Count(), mustReadFileSize(f))
 }
 
+func TestWALWriter_WriteMulti_Codecs(t *testing.T) {
+	for _, name := range tsdb.RegisteredBlockCodecs() {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			w := NewWAL(dir, 0, 0)
+			defer w.Close()
+			w.SetCodec(tsdb.BlockCodecByName(name))
+			require.NoError(t, w.Open())
+
+			values := map[string][]tsm1.Value{
+				"cpu,host=A#!~#float":  {tsm1.NewValue(1, 1.1), tsm1.NewValue(2, 2.2)},
+				"cpu,host=A#!~#string": {tsm1.NewValue(1, strings.Repeat("string", 100))},
+			}
+
+			_, err := w.WriteMulti(context.Background(), values)
+			require.NoError(t, err)
+			_, err = w.DeleteRange(context.Background(), [][]byte{[]byte("cpu,host=A#!~#float")}, 2, 3)
+			require.NoError(t, err)
+
+			f, r := mustSegmentReader(t, w)
+			defer r.Close()
+
+			require.True(t, r.Next())
+			we, err := r.Read()
+			require.NoError(t, err)
+
+			e, ok := we.(*tsm1.WriteWALEntry)
+			require.True(t, ok)
+			require.Equal(t, len(values), len(e.Values))
+			for k, v := range e.Values {
+				for i, vv := range v {
+					require.Equal(t, values[k][i].String(), vv.String())
+				}
+			}
+
+			require.True(t, r.Next())
+			we, err = r.Read()
+			require.NoError(t, err)
+
+			de, ok := we.(*tsm1.DeleteRangeWALEntry)
+			require.True(t, ok)
+			require.Equal(t, int64(2), de.Min)
+			require.Equal(t, int64(3), de.Max)
+
+			require.False(t, r.Next())
+			require.Equal(t, r.Count(), mustReadFileSize(f))
+		})
+	}
+}
+
+func TestWALWriter_WriteMulti_Sketch(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWAL(dir, 0, 0)
+	defer w.Close()
+	require.NoError(t, w.Open())
+
+	s1, s2 := sketch.NewDefault(), sketch.NewDefault()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, s1.Add(float64(i)))
+		require.NoError(t, s2.Add(float64(-i)/3))
+	}
+
+	values := map[string][]tsm1.Value{
+		"cpu,host=A#!~#latency": {tsm1.NewValue(1, s1), tsm1.NewValue(2, s2)},
+	}
+
+	_, err := w.WriteMulti(context.Background(), values)
+	require.NoError(t, err)
+
+	f, r := mustSegmentReader(t, w)
+	defer r.Close()
+
+	require.True(t, r.Next())
+	we, err := r.Read()
+	require.NoError(t, err)
+
+	e, ok := we.(*tsm1.WriteWALEntry)
+	require.True(t, ok)
+	require.Equal(t, values, e.Values)
+
+	require.False(t, r.Next())
+	require.Equal(t, r.Count(), mustReadFileSize(f))
+}
+
 func TestWALWriter_WriteMulti_LargeBatch(t *testing.T) {
 	dir := t.TempDir()
 	w := NewWAL(dir, 0, 0)
@@ -318,6 +404,40 @@ func TestWAL_ClosedSegments(t *testing.T) {
 	require.Equal(t, len(files), 0)
 }
 
+// Ensures a last segment too short for its header is not appended to, and its
+// torn header is reported when the cache is loaded.
+func TestWAL_Open_TornHeader(t *testing.T) {
+	dir := t.TempDir()
+	segment := func(id int) string {
+		return filepath.Join(dir, fmt.Sprintf("%s%05d.%s", tsm1.WALFilePrefix, id, tsm1.WALFileExtension))
+	}
+
+	torn := segment(1)
+	require.NoError(t, os.WriteFile(torn, []byte{0x00, 0x57, 0x41}, 0666))
+
+	w := NewWAL(dir, 0, 0)
+	defer w.Close()
+	require.NoError(t, w.Open())
+
+	_, err := w.WriteMulti(context.Background(), map[string][]tsm1.Value{
+		"cpu,host=A#!~#value": {tsm1.NewValue(1, 1.1)},
+	})
+	require.NoError(t, err)
+
+	files, err := w.ClosedSegments()
+	require.NoError(t, err)
+	require.Equal(t, []string{torn}, files)
+	require.NoError(t, w.Close())
+
+	cache := tsm1.NewCache(1024, tsdb.EngineTags{})
+	report, err := tsm1.NewCacheLoader([]string{torn, segment(2)}).Load(cache)
+	require.NoError(t, err)
+	require.Len(t, report.Segments, 1)
+	require.Equal(t, torn, report.Segments[0].Path)
+	require.True(t, report.Segments[0].Torn)
+	require.Len(t, cache.Values([]byte("cpu,host=A#!~#value")), 1)
+}
+
 func TestWAL_Delete(t *testing.T) {
 	dir := t.TempDir()
 
@@ -466,6 +586,56 @@ func TestWALRollSegment(t *testing.T) {
 	require.NoError(t, w.Close())
 }
 
+func TestWAL_GroupCommit(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWAL(dir, 0, 0)
+	defer w.Close()
+
+	// Writes must not wait for the latency deadline once no other write is in flight.
+	w.SetGroupCommit(1<<20, time.Hour)
+	require.NoError(t, w.Open())
+
+	const n = 20
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values := map[string][]tsm1.Value{
+				fmt.Sprintf("cpu,host=%d#!~#value", i): {tsm1.NewValue(int64(i), float64(i))},
+			}
+			_, err := w.WriteMulti(context.Background(), values)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.Less(t, time.Since(start), time.Minute)
+
+	// Verify the segment contains all committed writes.
+	files, err := os.ReadDir(w.Path())
+	require.NoError(t, err)
+	require.Equal(t, 1, len(files))
+
+	f, err := os.Open(filepath.Join(w.Path(), files[0].Name()))
+	require.NoError(t, err)
+	r := tsm1.NewWALSegmentReader(f)
+	defer r.Close()
+
+	var entries int
+	for r.Next() {
+		_, err := r.Read()
+		require.NoError(t, err)
+		entries++
+	}
+	require.Equal(t, n, entries)
+}
+
 func TestWAL_DiskSize(t *testing.T) {
 	test := func(w *tsm1.WAL, oldZero, curZero bool) {
 		// get disk size by reading file
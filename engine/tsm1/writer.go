@@ -2,6 +2,7 @@ package tsm1
 
 /*
 A TSM file is composed for four sections: header, blocks, index and the footer.
+Files of version 2 also have a statistics section between the blocks and the index.
 
 ┌────────┬────────────────────────────────────┬─────────────┬──────────────┐
 │ Header │               Blocks               │    Index    │    Footer    │
@@ -31,9 +32,22 @@ is stored in the index.
 │ 4 bytes │ N bytes │ 4 bytes │ N bytes │ 4 bytes │ N bytes │
 └─────────┴─────────┴─────────┴─────────┴─────────┴─────────┘
 
-Following the blocks is the index for the blocks in the file.  The index is
-composed of a sequence of index entries ordered lexicographically by key and
-then by time.  Each index entry starts with a key length and key followed by a
+Files of version 2 have a statistics section following the blocks, holding the
+count, sum, min and max of float, integer and unsigned blocks, ordered by block
+offset.  Each entry is 56 bytes and checksummed.  The section ends with the
+number of entries.  Blocks copied between files without being decoded have no
+entry.  Files of version 1 end their blocks directly before the index.
+
+┌──────────────────────────────────────────┐
+│                Statistics                │
+├──────────────┬──────────────┬───┬────────┤
+│   Block 1    │   Block 2    │...│ Count  │
+│   56 bytes   │   56 bytes   │   │4 bytes │
+└──────────────┴──────────────┴───┴────────┘
+
+Following the blocks and statistics is the index for the blocks in the file.
+The index is composed of a sequence of index entries ordered lexicographically
+by key and then by time.  Each index entry starts with a key length and key followed by a
 count of the number of blocks in the file.  Each block entry is composed of
 the min and max time for the block, the offset into the file where the block
 is located and the size of the block.
@@ -83,6 +97,10 @@ const (
 	// Version indicates the version of the TSM file format.
 	Version byte = 1
 
+	// VersionBlockStats is the version of the TSM file format with a block
+	// statistics section.  New files are written with this version.
+	VersionBlockStats byte = 2
+
 	// Size in bytes of an index entry
 	indexEntrySize = 28
 
@@ -548,6 +566,18 @@ type tsmWriter struct {
 
 	// The bytes written count of when we last fsync'd
 	lastSync int64
+
+	// stats holds the encoded statistics of the blocks written so far.
+	stats     []byte
+	statsBufs blockStatsBuffers
+}
+
+// blockStatsWriter is implemented by writers that can record the statistics
+// of a block computed by the caller.
+type blockStatsWriter interface {
+	// writeBlockWithStats writes block as WriteBlock does and records s as its
+	// statistics, unless s is nil.
+	writeBlockWithStats(key []byte, minTime, maxTime int64, block []byte, s *BlockStats) error
 }
 
 // NewTSMWriter returns a new TSMWriter writing to w.
@@ -578,7 +608,7 @@ func NewTSMWriterWithDiskBuffer(w io.Writer) (TSMWriter, error) {
 func (t *tsmWriter) writeHeader() error {
 	var buf [5]byte
 	binary.BigEndian.PutUint32(buf[0:4], MagicNumber)
-	buf[4] = Version
+	buf[4] = VersionBlockStats
 
 	n, err := t.w.Write(buf[:])
 	if err != nil {
@@ -632,6 +662,9 @@ func (t *tsmWriter) Write(key []byte, values Values) error {
 
 	// Record this block in index
 	t.index.Add(key, blockType, values[0].UnixNano(), values[len(values)-1].UnixNano(), t.n, uint32(n))
+	if s, ok := newValuesBlockStats(values, &t.statsBufs); ok {
+		t.stats = s.appendTo(t.stats, t.n)
+	}
 
 	// Increment file position pointer
 	t.n += int64(n)
@@ -647,6 +680,10 @@ func (t *tsmWriter) Write(key []byte, values Values) error {
 // exceeds max entries for a given key, ErrMaxBlocksExceeded is returned.  This indicates
 // that the index is now full for this key and no future writes to this key will succeed.
 func (t *tsmWriter) WriteBlock(key []byte, minTime, maxTime int64, block []byte) error {
+	return t.writeBlockWithStats(key, minTime, maxTime, block, nil)
+}
+
+func (t *tsmWriter) writeBlockWithStats(key []byte, minTime, maxTime int64, block []byte, s *BlockStats) error {
 	if len(key) > maxKeyLength {
 		return ErrMaxKeyLengthExceeded
 	}
@@ -684,6 +721,9 @@ func (t *tsmWriter) WriteBlock(key []byte, minTime, maxTime int64, block []byte)
 
 	// Record this block in index
 	t.index.Add(key, blockType, minTime, maxTime, t.n, uint32(n))
+	if s != nil {
+		t.stats = s.appendTo(t.stats, t.n)
+	}
 
 	// Increment file position pointer (checksum + block len)
 	t.n += int64(n)
@@ -706,12 +746,15 @@ func (t *tsmWriter) WriteBlock(key []byte, minTime, maxTime int64, block []byte)
 // WriteIndex writes the index section of the file.  If there are no index entries to write,
 // this returns ErrNoValues.
 func (t *tsmWriter) WriteIndex() error {
-	indexPos := t.n
-
 	if t.index.KeyCount() == 0 {
 		return ErrNoValues
 	}
 
+	if err := t.writeBlockStats(); err != nil {
+		return err
+	}
+	indexPos := t.n
+
 	// Set the destination file on the index so we can periodically
 	// fsync while writing the index.
 	if f, ok := t.wrapped.(syncer); ok {
@@ -731,6 +774,23 @@ func (t *tsmWriter) WriteIndex() error {
 	return err
 }
 
+// writeBlockStats writes the statistics section of the file.
+func (t *tsmWriter) writeBlockStats() error {
+	var trailer [blockStatsTrailerSize]byte
+	binary.BigEndian.PutUint32(trailer[:], uint32(len(t.stats)/blockStatsSize))
+
+	if _, err := t.w.Write(t.stats); err != nil {
+		return err
+	}
+	if _, err := t.w.Write(trailer[:]); err != nil {
+		return err
+	}
+
+	t.n += int64(len(t.stats) + len(trailer))
+	t.stats = nil
+	return nil
+}
+
 func (t *tsmWriter) Flush() error {
 	if err := t.w.Flush(); err != nil {
 		return err
@@ -794,11 +854,11 @@ func (t *tsmWriter) Remove() error {
 }
 
 func (t *tsmWriter) Size() uint32 {
-	return uint32(t.n) + t.index.Size()
+	return uint32(t.n) + uint32(len(t.stats)) + blockStatsTrailerSize + t.index.Size()
 }
 
 // verifyVersion verifies that the reader's bytes are a TSM byte
-// stream of the correct version (1)
+// stream of a supported version (1 or 2)
 func verifyVersion(r io.ReadSeeker) error {
 	_, err := r.Seek(0, 0)
 	if err != nil {
@@ -816,8 +876,8 @@ func verifyVersion(r io.ReadSeeker) error {
 	if err != nil {
 		return fmt.Errorf("init: error reading version: %v", err)
 	}
-	if b[0] != Version {
-		return fmt.Errorf("init: file is version %b. expected %b", b[0], Version)
+	if b[0] != Version && b[0] != VersionBlockStats {
+		return fmt.Errorf("init: file is version %b. expected %b or %b", b[0], Version, VersionBlockStats)
 	}
 
 	return nil
@@ -5,6 +5,8 @@ import (
 	"fmt"
 
 	"github.com/influxdata/influxdb/v2/models"
+	"github.com/influxdata/influxdb/v2/tsdb/cursors"
+	"github.com/influxdata/influxdb/v2/tsdb/sketch"
 	"github.com/influxdata/influxql"
 )
 
@@ -14,6 +16,13 @@ const MaxFieldValueLength = 1048576
 //   - the point has inconsistent fields, or
 //   - the point has fields that are too long
 func ValidateFields(mf *MeasurementFields, point models.Point, skipSizeValidation bool) error {
+	return validateFields(mf, point, skipSizeValidation, nil)
+}
+
+// validateFields is ValidateFields for a shard whose new fields with a key in
+// sketchFields are sketch fields.  It also returns a PartialWriteError if a
+// value written to a sketch field is not the text encoding of a sketch.
+func validateFields(mf *MeasurementFields, point models.Point, skipSizeValidation bool, sketchFields []string) error {
 	pointSize := point.StringSize()
 	iter := point.FieldIterator()
 	for iter.Next() {
@@ -38,13 +47,24 @@ func ValidateFields(mf *MeasurementFields, point models.Point, skipSizeValidatio
 			continue
 		}
 
-		// If the fields is not present, there cannot be a conflict.
 		f := mf.FieldBytes(iter.FieldKey())
+		dataType := fieldDataType(iter, f, sketchFields)
+		if dataType == cursors.SketchDataType {
+			if _, err := sketch.Parse(iter.StringValue()); err != nil {
+				return PartialWriteError{
+					Reason: fmt.Sprintf(
+						"input field \"%s\" on measurement \"%s\" is not a valid sketch: %s",
+						iter.FieldKey(), point.Name(), err),
+					Dropped: 1,
+				}
+			}
+		}
+
+		// If the fields is not present, there cannot be a conflict.
 		if f == nil {
 			continue
 		}
 
-		dataType := dataTypeFromModelsFieldType(iter.Type())
 		if dataType == influxql.Unknown {
 			continue
 		}
@@ -63,6 +83,30 @@ func ValidateFields(mf *MeasurementFields, point models.Point, skipSizeValidatio
 	return nil
 }
 
+// fieldDataType returns the influxql.DataType of the field value at iter, given
+// the existing field f, or nil if the field does not exist yet.  Line protocol
+// has no sketch type, so string values are sketches if they are written to a
+// sketch field, or to a new field whose key is in sketchFields.
+func fieldDataType(iter models.FieldIterator, f *Field, sketchFields []string) influxql.DataType {
+	dataType := dataTypeFromModelsFieldType(iter.Type())
+	if dataType != influxql.String {
+		return dataType
+	}
+
+	if f != nil {
+		if f.Type == cursors.SketchDataType {
+			return cursors.SketchDataType
+		}
+		return dataType
+	}
+	for _, key := range sketchFields {
+		if key == string(iter.FieldKey()) {
+			return cursors.SketchDataType
+		}
+	}
+	return dataType
+}
+
 // dataTypeFromModelsFieldType returns the influxql.DataType that corresponds to the
 // passed in field type. If there is no good match, it returns Unknown.
 func dataTypeFromModelsFieldType(fieldType models.FieldType) influxql.DataType {
@@ -2,6 +2,7 @@ package tsdb
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -63,6 +64,10 @@ type Index interface {
 	TagKeySeriesIDIterator(name, key []byte) (SeriesIDIterator, error)
 	TagValueSeriesIDIterator(name, key, value []byte) (SeriesIDIterator, error)
 
+	// Prefix search iterators, returning at most limit elements if limit > 0.
+	MeasurementPrefixIterator(prefix []byte, limit int) (MeasurementIterator, error)
+	TagValuePrefixIterator(name, key, prefix []byte, limit int) (TagValueIterator, error)
+
 	// Sets a shared fieldset from the engine.
 	FieldSet() *MeasurementFieldSet
 	SetFieldSet(fs *MeasurementFieldSet)
@@ -79,6 +84,34 @@ type Index interface {
 	UniqueReferenceID() uintptr
 }
 
+// NumericTagValueIndex is implemented by indexes which can compare the values
+// of some tag keys as numbers.
+type NumericTagValueIndex interface {
+	// IsNumericTagKey returns true if the values of key are compared as numbers.
+	IsNumericTagKey(key []byte) bool
+
+	// NumericTagValueIterator returns an iterator over the values of a numeric
+	// tag key which compare to v with op.
+	NumericTagValueIterator(name, key []byte, op influxql.Token, v float64) (TagValueIterator, error)
+}
+
+// RebuildableIndex is implemented by indexes which can be rebuilt while they
+// are in use.
+type RebuildableIndex interface {
+	// Rebuild replaces the series of the index with the series keys passed to
+	// add by fn. Series created or deleted while the index is rebuilt are kept.
+	// The rebuild is not atomic: if it fails, parts of the index may already
+	// have been replaced.
+	Rebuild(ctx context.Context, fn func(add func(key []byte) error) error) error
+}
+
+// TagValueMatcher is implemented by indexes that can narrow down the values of
+// a tag key to the ones which may match a regular expression. The returned
+// values are candidates only, so callers must still match each of them.
+type TagValueMatcher interface {
+	MatchTagValueIterator(name, key []byte, value *regexp.Regexp) (TagValueIterator, error)
+}
+
 // SeriesElem represents a generic series element.
 type SeriesElem interface {
 	Name() []byte
@@ -1102,6 +1135,31 @@ func (itr *measurementMergeIterator) Next() (_ []byte, err error) {
 	return name, nil
 }
 
+// LimitMeasurementIterator returns an iterator over the first n measurements of
+// itr. Returns itr if n is not positive.
+func LimitMeasurementIterator(itr MeasurementIterator, n int) MeasurementIterator {
+	if itr == nil || n <= 0 {
+		return itr
+	}
+	return &measurementLimitIterator{itr: itr, n: n}
+}
+
+type measurementLimitIterator struct {
+	itr MeasurementIterator
+	n   int
+}
+
+func (itr *measurementLimitIterator) Close() error { return itr.itr.Close() }
+
+// Next returns the next name until n names have been returned.
+func (itr *measurementLimitIterator) Next() ([]byte, error) {
+	if itr.n == 0 {
+		return nil, nil
+	}
+	itr.n--
+	return itr.itr.Next()
+}
+
 // TagKeyIterator represents a iterator over a list of tag keys.
 type TagKeyIterator interface {
 	Close() error
@@ -1312,6 +1370,31 @@ func (itr *tagValueMergeIterator) Next() (_ []byte, err error) {
 	return value, nil
 }
 
+// LimitTagValueIterator returns an iterator over the first n values of itr.
+// Returns itr if n is not positive.
+func LimitTagValueIterator(itr TagValueIterator, n int) TagValueIterator {
+	if itr == nil || n <= 0 {
+		return itr
+	}
+	return &tagValueLimitIterator{itr: itr, n: n}
+}
+
+type tagValueLimitIterator struct {
+	itr TagValueIterator
+	n   int
+}
+
+func (itr *tagValueLimitIterator) Close() error { return itr.itr.Close() }
+
+// Next returns the next value until n values have been returned.
+func (itr *tagValueLimitIterator) Next() ([]byte, error) {
+	if itr.n == 0 {
+		return nil, nil
+	}
+	itr.n--
+	return itr.itr.Next()
+}
+
 // IndexSet represents a list of indexes, all belonging to one database.
 type IndexSet struct {
 	Indexes    []Index                // The set of indexes comprising this IndexSet.
@@ -1675,7 +1758,12 @@ func (is IndexSet) measurementNamesByTagFilter(auth query.Authorizer, op influxq
 		// Authorization must be explicitly granted when an authorizer is present.
 		authorized = query.AuthorizerIsOpen(auth)
 
-		vitr, err := is.tagValueIterator(me, []byte(key))
+		var vitr TagValueIterator
+		if op == influxql.EQREGEX || op == influxql.NEQREGEX {
+			vitr, err = is.matchTagValueIterator(me, []byte(key), regex)
+		} else {
+			vitr, err = is.tagValueIterator(me, []byte(key))
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -1956,8 +2044,8 @@ func (is IndexSet) measurementHasTagValueRegex(auth query.Authorizer, me, key []
 		}
 	}
 
-	// Iterate over the tag values and find one that matches the value.
-	vitr, err := is.tagValueIterator(me, key)
+	// Iterate over the candidate tag values and find one that matches the value.
+	vitr, err := is.matchTagValueIterator(me, key, value)
 	if err != nil || vitr == nil {
 		return false, err
 	}
@@ -2104,6 +2192,153 @@ func (is IndexSet) tagValueIterator(name, key []byte) (TagValueIterator, error)
 	return MergeTagValueIterators(a...), nil
 }
 
+// MeasurementNamesByPrefix returns the sorted names of the measurements which
+// start with prefix and have at least one series authorized by auth. At most
+// limit names are returned if limit > 0.
+func (is IndexSet) MeasurementNamesByPrefix(auth query.Authorizer, prefix []byte, limit int) ([][]byte, error) {
+	release := is.SeriesFile.Retain()
+	defer release()
+
+	itr, err := is.measurementPrefixIterator(prefix, prefixIndexLimit(auth, limit))
+	if err != nil {
+		return nil, err
+	} else if itr == nil {
+		return nil, nil
+	}
+	defer itr.Close()
+
+	var names [][]byte
+	for limit <= 0 || len(names) < limit {
+		e, err := itr.Next()
+		if err != nil {
+			return nil, err
+		} else if e == nil {
+			break
+		}
+
+		if is.measurementAuthorizedSeries(auth, e, nil) {
+			names = append(names, e)
+		}
+	}
+	return slices.CopyChunkedByteSlices(names, 1000), nil
+}
+
+// MeasurementPrefixIterator returns an iterator over the measurements which
+// start with prefix. At most limit measurements are returned if limit > 0.
+func (is IndexSet) MeasurementPrefixIterator(prefix []byte, limit int) (MeasurementIterator, error) {
+	return is.measurementPrefixIterator(prefix, limit)
+}
+
+// measurementPrefixIterator returns an iterator over the measurements which
+// start with prefix. It guarantees to never take any locks on the underlying
+// series file.
+func (is IndexSet) measurementPrefixIterator(prefix []byte, limit int) (MeasurementIterator, error) {
+	a := make([]MeasurementIterator, 0, len(is.Indexes))
+	for _, idx := range is.Indexes {
+		itr, err := idx.MeasurementPrefixIterator(prefix, limit)
+		if err != nil {
+			MeasurementIterators(a).Close()
+			return nil, err
+		} else if itr != nil {
+			a = append(a, itr)
+		}
+	}
+	return LimitMeasurementIterator(MergeMeasurementIterators(a...), limit), nil
+}
+
+// TagValuesByPrefix returns the sorted values of a tag key which start with
+// prefix and belong to at least one series authorized by auth. At most limit
+// values are returned if limit > 0.
+func (is IndexSet) TagValuesByPrefix(auth query.Authorizer, name, key, prefix []byte, limit int) ([][]byte, error) {
+	release := is.SeriesFile.Retain()
+	defer release()
+
+	itr, err := is.tagValuePrefixIterator(name, key, prefix, prefixIndexLimit(auth, limit))
+	if err != nil {
+		return nil, err
+	} else if itr == nil {
+		return nil, nil
+	}
+	defer itr.Close()
+
+	var values [][]byte
+	for limit <= 0 || len(values) < limit {
+		e, err := itr.Next()
+		if err != nil {
+			return nil, err
+		} else if e == nil {
+			break
+		}
+
+		if !query.AuthorizerIsOpen(auth) {
+			if ok, err := is.measurementHasTagValue(auth, name, key, e); err != nil {
+				return nil, err
+			} else if !ok {
+				continue
+			}
+		}
+		values = append(values, e)
+	}
+	return slices.CopyChunkedByteSlices(values, 1000), nil
+}
+
+// TagValuePrefixIterator returns a value iterator over the values of a tag key
+// which start with prefix. At most limit values are returned if limit > 0.
+func (is IndexSet) TagValuePrefixIterator(name, key, prefix []byte, limit int) (TagValueIterator, error) {
+	return is.tagValuePrefixIterator(name, key, prefix, limit)
+}
+
+// tagValuePrefixIterator returns a value iterator over the values of a tag key
+// which start with prefix. It guarantees to never take any locks on the
+// underlying series file.
+func (is IndexSet) tagValuePrefixIterator(name, key, prefix []byte, limit int) (TagValueIterator, error) {
+	a := make([]TagValueIterator, 0, len(is.Indexes))
+	for _, idx := range is.Indexes {
+		itr, err := idx.TagValuePrefixIterator(name, key, prefix, limit)
+		if err != nil {
+			TagValueIterators(a).Close()
+			return nil, err
+		} else if itr != nil {
+			a = append(a, itr)
+		}
+	}
+	return LimitTagValueIterator(MergeTagValueIterators(a...), limit), nil
+}
+
+// prefixIndexLimit returns the limit of a prefix search to pass to the indexes.
+// Elements which are not authorized are skipped, so the indexes are only
+// limited when auth authorizes everything.
+func prefixIndexLimit(auth query.Authorizer, limit int) int {
+	if !query.AuthorizerIsOpen(auth) {
+		return 0
+	}
+	return limit
+}
+
+// matchTagValueIterator returns a value iterator for a tag key, which only
+// returns the candidate values which may match value if the indexes support it.
+// Callers must still match each returned value. It guarantees to never take
+// any locks on the underlying series file.
+func (is IndexSet) matchTagValueIterator(name, key []byte, value *regexp.Regexp) (TagValueIterator, error) {
+	a := make([]TagValueIterator, 0, len(is.Indexes))
+	for _, idx := range is.Indexes {
+		var itr TagValueIterator
+		var err error
+		if m, ok := idx.(TagValueMatcher); ok {
+			itr, err = m.MatchTagValueIterator(name, key, value)
+		} else {
+			itr, err = idx.TagValueIterator(name, key)
+		}
+		if err != nil {
+			TagValueIterators(a).Close()
+			return nil, err
+		} else if itr != nil {
+			a = append(a, itr)
+		}
+	}
+	return MergeTagValueIterators(a...), nil
+}
+
 // TagKeyHasAuthorizedSeries determines if there exists an authorized series for
 // the provided measurement name and tag key.
 func (is IndexSet) TagKeyHasAuthorizedSeries(auth query.Authorizer, name, tagKey []byte) (bool, error) {
@@ -2519,7 +2754,7 @@ func (is IndexSet) seriesByBinaryExprIterator(name []byte, n *influxql.BinaryExp
 
 	// Retrieve the variable reference from the correct side of the expression.
 	key, ok := n.LHS.(*influxql.VarRef)
-	value := n.RHS
+	value, op := n.RHS, n.Op
 	if !ok {
 		key, ok = n.RHS.(*influxql.VarRef)
 		if !ok {
@@ -2531,7 +2766,7 @@ func (is IndexSet) seriesByBinaryExprIterator(name []byte, n *influxql.BinaryExp
 			}
 			return newSeriesIDExprIterator(itr, n), nil
 		}
-		value = n.LHS
+		value, op = n.LHS, reverseComparisonOp(n.Op)
 	}
 
 	// For fields, return all series from this measurement.
@@ -2561,6 +2796,11 @@ func (is IndexSet) seriesByBinaryExprIterator(name []byte, n *influxql.BinaryExp
 	case *influxql.VarRef:
 		return is.seriesByBinaryExprVarRefIterator(name, []byte(key.Val), value, n.Op)
 	default:
+		// Compare numeric tag keys to numbers in the index.
+		if v, ok := numericLiteral(value); ok && isComparisonOp(op) && is.isNumericTagKey([]byte(key.Val)) {
+			return is.seriesByBinaryExprNumericIterator(name, []byte(key.Val), v, op)
+		}
+
 		// We do not know how to evaluate this expression so pass it
 		// on to the query engine.
 		itr, err := is.measurementSeriesIDIterator(name)
@@ -2641,6 +2881,122 @@ func (is IndexSet) seriesByBinaryExprRegexIterator(name, key []byte, value *rege
 	return is.matchTagValueSeriesIDIterator(name, key, value, op == influxql.EQREGEX)
 }
 
+// seriesByBinaryExprNumericIterator returns the series of the values of a
+// numeric tag key which compare to v with op. Values are compared by number,
+// so EQ matches every value parsing to v, such as "12", "12.0" and "012". As
+// for strings, series without a value equal to v are returned for NEQ.
+func (is IndexSet) seriesByBinaryExprNumericIterator(name, key []byte, v float64, op influxql.Token) (SeriesIDIterator, error) {
+	if op != influxql.NEQ {
+		return is.numericTagValueSeriesIDIterator(name, key, v, op)
+	}
+
+	mitr, err := is.measurementSeriesIDIterator(name)
+	if err != nil {
+		return nil, err
+	}
+
+	vitr, err := is.numericTagValueSeriesIDIterator(name, key, v, influxql.EQ)
+	if err != nil {
+		if mitr != nil {
+			mitr.Close()
+		}
+		return nil, err
+	}
+	return DifferenceSeriesIDIterators(mitr, vitr), nil
+}
+
+// numericTagValueSeriesIDIterator returns the union of the series of the values
+// of a numeric tag key which compare to v with op.
+func (is IndexSet) numericTagValueSeriesIDIterator(name, key []byte, v float64, op influxql.Token) (SeriesIDIterator, error) {
+	a := make([]TagValueIterator, 0, len(is.Indexes))
+	for _, idx := range is.Indexes {
+		itr, err := idx.(NumericTagValueIndex).NumericTagValueIterator(name, key, op, v)
+		if err != nil {
+			TagValueIterators(a).Close()
+			return nil, err
+		} else if itr != nil {
+			a = append(a, itr)
+		}
+	}
+
+	vitr := MergeTagValueIterators(a...)
+	if vitr == nil {
+		return nil, nil
+	}
+	defer vitr.Close()
+
+	var itrs []SeriesIDIterator
+	for {
+		e, err := vitr.Next()
+		if err != nil {
+			SeriesIDIterators(itrs).Close()
+			return nil, err
+		} else if e == nil {
+			break
+		}
+
+		itr, err := is.tagValueSeriesIDIterator(name, key, e)
+		if err != nil {
+			SeriesIDIterators(itrs).Close()
+			return nil, err
+		} else if itr != nil {
+			itrs = append(itrs, itr)
+		}
+	}
+	return MergeSeriesIDIterators(itrs...), nil
+}
+
+// isNumericTagKey returns true if all indexes compare the values of key as numbers.
+func (is IndexSet) isNumericTagKey(key []byte) bool {
+	for _, idx := range is.Indexes {
+		if nidx, ok := idx.(NumericTagValueIndex); !ok || !nidx.IsNumericTagKey(key) {
+			return false
+		}
+	}
+	return len(is.Indexes) > 0
+}
+
+// numericLiteral returns the value of a number literal.
+func numericLiteral(expr influxql.Expr) (float64, bool) {
+	switch expr := expr.(type) {
+	case *influxql.NumberLiteral:
+		return expr.Val, true
+	case *influxql.IntegerLiteral:
+		return float64(expr.Val), true
+	case *influxql.UnsignedLiteral:
+		return float64(expr.Val), true
+	default:
+		return 0, false
+	}
+}
+
+// isComparisonOp returns true if op compares two values.
+func isComparisonOp(op influxql.Token) bool {
+	switch op {
+	case influxql.EQ, influxql.NEQ, influxql.LT, influxql.LTE, influxql.GT, influxql.GTE:
+		return true
+	default:
+		return false
+	}
+}
+
+// reverseComparisonOp returns the operator comparing the operands of op in the
+// reverse order, so that `12 < version` is the same as `version > 12`.
+func reverseComparisonOp(op influxql.Token) influxql.Token {
+	switch op {
+	case influxql.LT:
+		return influxql.GT
+	case influxql.LTE:
+		return influxql.GTE
+	case influxql.GT:
+		return influxql.LT
+	case influxql.GTE:
+		return influxql.LTE
+	default:
+		return op
+	}
+}
+
 func (is IndexSet) seriesByBinaryExprVarRefIterator(name, key []byte, value *influxql.VarRef, op influxql.Token) (SeriesIDIterator, error) {
 	itr0, err := is.tagKeySeriesIDIterator(name, key)
 	if err != nil {
@@ -2736,7 +3092,7 @@ func (is IndexSet) matchTagValueEqualEmptySeriesIDIterator(name, key []byte, val
 }
 
 func (is IndexSet) matchTagValueEqualNotEmptySeriesIDIterator(name, key []byte, value *regexp.Regexp) (SeriesIDIterator, error) {
-	vitr, err := is.tagValueIterator(name, key)
+	vitr, err := is.matchTagValueIterator(name, key, value)
 	if err != nil {
 		return nil, err
 	} else if vitr == nil {
@@ -2800,7 +3156,7 @@ func (is IndexSet) matchTagValueNotEqualEmptySeriesIDIterator(name, key []byte,
 }
 
 func (is IndexSet) matchTagValueNotEqualNotEmptySeriesIDIterator(name, key []byte, value *regexp.Regexp) (SeriesIDIterator, error) {
-	vitr, err := is.tagValueIterator(name, key)
+	vitr, err := is.matchTagValueIterator(name, key, value)
 	if err != nil {
 		return nil, err
 	} else if vitr == nil {
@@ -2,8 +2,12 @@ package tsi1
 
 import (
 	"container/list"
+	"math/bits"
 	"sync"
+	"sync/atomic"
+	"unsafe"
 
+	"github.com/cespare/xxhash"
 	"github.com/influxdata/influxdb/v2/tsdb"
 )
 
@@ -16,6 +20,12 @@ import (
 // more than c items are added to the cache, the least recently used item is
 // evicted from the cache.
 //
+// The cache may also share a memory budget with the caches of other indexes.
+// Items are then evicted from any of these caches when the budget is full, and
+// new items are only admitted if they are accessed more often than the items
+// they would evict. The size of an item is measured when it is added, and
+// again when series are added to its set.
+//
 // A TagValueSeriesIDCache comprises a linked list implementation to track the
 // order by which items should be evicted from the cache, and a hashmap implementation
 // to provide constant time retrievals of items from the cache.
@@ -23,16 +33,30 @@ type TagValueSeriesIDCache struct {
 	sync.RWMutex
 	cache   map[string]map[string]map[string]*list.Element
 	evictor *list.List
+	size    uint64 // Accessed atomically.
 
 	capacity int
+	budget   *tsdb.SeriesIDSetCacheBudget
+	metrics  *tsdb.SeriesIDSetCacheMetrics
+
+	// Accessed atomically.
+	hits, misses, evictions uint64
 }
 
 // NewTagValueSeriesIDCache returns a TagValueSeriesIDCache with capacity c.
 func NewTagValueSeriesIDCache(c int) *TagValueSeriesIDCache {
+	return newTagValueSeriesIDCache(c, nil, nil)
+}
+
+// newTagValueSeriesIDCache returns a TagValueSeriesIDCache with capacity c,
+// sharing budget with other caches. budget and metrics may be nil.
+func newTagValueSeriesIDCache(c int, budget *tsdb.SeriesIDSetCacheBudget, metrics *tsdb.SeriesIDSetCacheMetrics) *TagValueSeriesIDCache {
 	return &TagValueSeriesIDCache{
 		cache:    map[string]map[string]map[string]*list.Element{},
 		evictor:  list.New(),
 		capacity: c,
+		budget:   budget,
+		metrics:  metrics,
 	}
 }
 
@@ -49,10 +73,17 @@ func (c *TagValueSeriesIDCache) get(name, key, value []byte) *tsdb.SeriesIDSet {
 		if tkmap, ok := mmap[string(key)]; ok {
 			if ele, ok := tkmap[string(value)]; ok {
 				c.evictor.MoveToFront(ele) // This now becomes most recently used.
-				return ele.Value.(*seriesIDCacheElement).SeriesIDSet
+				elem := ele.Value.(*seriesIDCacheElement)
+				c.budget.Touch(elem.hash, elem.entry)
+				c.hit()
+				return elem.SeriesIDSet
 			}
 		}
 	}
+	if c.budget != nil {
+		c.budget.Touch(seriesIDCacheHash(name, key, value), nil)
+	}
+	c.miss()
 	return nil
 }
 
@@ -70,21 +101,27 @@ func (c *TagValueSeriesIDCache) exists(name, key, value []byte) bool {
 // addToSet adds x to the SeriesIDSet associated with the tuple {name, key, value}
 // if it exists. This method takes a lock on the underlying SeriesIDSet.
 //
+// The item is accounted for again in the memory budget, and the items evicted
+// from the budget are returned. They must be evicted with evictEntries once
+// the lock of the cache is released.
+//
 // NB this does not count as an access on the set—therefore the set is not promoted
 // within the LRU cache.
-func (c *TagValueSeriesIDCache) addToSet(name, key, value []byte, x uint64) {
+func (c *TagValueSeriesIDCache) addToSet(name, key, value []byte, x uint64) []*tsdb.SeriesIDSetCacheEntry {
 	if mmap, ok := c.cache[string(name)]; ok {
 		if tkmap, ok := mmap[string(key)]; ok {
 			if ele, ok := tkmap[string(value)]; ok {
-				ss := ele.Value.(*seriesIDCacheElement).SeriesIDSet
-				if ss == nil {
-					ele.Value.(*seriesIDCacheElement).SeriesIDSet = tsdb.NewSeriesIDSet(x)
-					return
+				elem := ele.Value.(*seriesIDCacheElement)
+				if elem.SeriesIDSet == nil {
+					elem.SeriesIDSet = tsdb.NewSeriesIDSet(x)
+				} else {
+					elem.SeriesIDSet.Add(x)
 				}
-				ele.Value.(*seriesIDCacheElement).SeriesIDSet.Add(x)
+				return elem.resize()
 			}
 		}
 	}
+	return nil
 }
 
 // measurementContainsSets returns true if there are sets cached for the provided measurement.
@@ -94,7 +131,10 @@ func (c *TagValueSeriesIDCache) measurementContainsSets(name []byte) bool {
 }
 
 // Put adds the SeriesIDSet to the cache under the tuple {name, key, value}. If
-// the cache is at its limit, then the least recently used item is evicted.
+// the cache is at its limit, then the least recently used item is evicted. If
+// the memory budget of the cache is full, then the least recently used items of
+// all caches sharing it are evicted, unless they are used more often than the
+// new item, in which case it is not added.
 func (c *TagValueSeriesIDCache) Put(name, key, value []byte, ss *tsdb.SeriesIDSet) {
 	c.Lock()
 	// Check under the write lock if the relevant item is now in the cache.
@@ -102,20 +142,43 @@ func (c *TagValueSeriesIDCache) Put(name, key, value []byte, ss *tsdb.SeriesIDSe
 		c.Unlock()
 		return
 	}
-	defer c.Unlock()
 
 	// Ensure our SeriesIDSet is go heap backed.
 	if ss != nil {
 		ss = ss.Clone()
 	}
 
-	// Create list item, and add to the front of the eviction list.
-	listElement := c.evictor.PushFront(&seriesIDCacheElement{
+	elem := &seriesIDCacheElement{
 		name:        string(name),
 		key:         string(key),
 		value:       string(value),
 		SeriesIDSet: ss,
-	})
+		cache:       c,
+	}
+	elem.size = elem.bytes()
+
+	// Account for the item in the shared budget, which may evict items of any
+	// cache. They are removed once the lock is released, as the lock of their
+	// cache must be taken.
+	var victims []*tsdb.SeriesIDSetCacheEntry
+	if c.budget != nil {
+		elem.hash = seriesIDCacheHash(name, key, value)
+		elem.entry = tsdb.NewSeriesIDSetCacheEntry(elem.hash, elem.size, elem)
+
+		var ok bool
+		if victims, ok = c.budget.Admit(elem.entry); !ok {
+			c.Unlock()
+			return
+		}
+	}
+	defer func() {
+		c.Unlock()
+		evictEntries(victims)
+	}()
+
+	// Create list item, and add to the front of the eviction list.
+	listElement := c.evictor.PushFront(elem)
+	atomic.AddUint64(&c.size, elem.size)
 
 	// Add the listElement to the set of items.
 	if mmap, ok := c.cache[string(name)]; ok {
@@ -147,21 +210,87 @@ EVICT:
 // This method takes a lock on the underlying SeriesIDSet.
 func (c *TagValueSeriesIDCache) Delete(name, key, value []byte, x uint64) {
 	c.Lock()
-	c.delete(name, key, value, x)
+	victims := c.delete(name, key, value, x)
 	c.Unlock()
+	evictEntries(victims)
 }
 
 // delete removes x from the tuple {name, key, value} if it exists.
-func (c *TagValueSeriesIDCache) delete(name, key, value []byte, x uint64) {
+//
+// As for addToSet, the item is accounted for again in the memory budget, and
+// the items evicted from the budget are returned.
+func (c *TagValueSeriesIDCache) delete(name, key, value []byte, x uint64) []*tsdb.SeriesIDSetCacheEntry {
 	if mmap, ok := c.cache[string(name)]; ok {
 		if tkmap, ok := mmap[string(key)]; ok {
 			if ele, ok := tkmap[string(value)]; ok {
-				if ss := ele.Value.(*seriesIDCacheElement).SeriesIDSet; ss != nil {
-					ele.Value.(*seriesIDCacheElement).SeriesIDSet.Remove(x)
+				elem := ele.Value.(*seriesIDCacheElement)
+				if elem.SeriesIDSet != nil {
+					elem.SeriesIDSet.Remove(x)
+					return elem.resize()
 				}
 			}
 		}
 	}
+	return nil
+}
+
+// Clear removes all items from the cache.
+func (c *TagValueSeriesIDCache) Clear() {
+	c.Lock()
+	for e := c.evictor.Front(); e != nil; e = e.Next() {
+		c.budget.Release(e.Value.(*seriesIDCacheElement).entry)
+	}
+	c.cache = map[string]map[string]map[string]*list.Element{}
+	c.evictor.Init()
+	atomic.StoreUint64(&c.size, 0)
+	c.Unlock()
+}
+
+// setMetrics sets the metrics updated by the cache. The previous metrics, if
+// any, are no longer exported. m may be nil.
+func (c *TagValueSeriesIDCache) setMetrics(m *tsdb.SeriesIDSetCacheMetrics) {
+	c.Lock()
+	defer c.Unlock()
+	if c.metrics != nil {
+		c.metrics.Delete()
+	}
+	c.metrics = m
+}
+
+// Statistics returns the statistics of the cache.
+func (c *TagValueSeriesIDCache) Statistics() tsdb.SeriesIDSetCacheStatistics {
+	c.RLock()
+	sets, size := c.evictor.Len(), atomic.LoadUint64(&c.size)
+	c.RUnlock()
+
+	return tsdb.SeriesIDSetCacheStatistics{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+		Sets:      sets,
+		Size:      size,
+	}
+}
+
+func (c *TagValueSeriesIDCache) hit() {
+	atomic.AddUint64(&c.hits, 1)
+	if c.metrics != nil {
+		c.metrics.Hits.Inc()
+	}
+}
+
+func (c *TagValueSeriesIDCache) miss() {
+	atomic.AddUint64(&c.misses, 1)
+	if c.metrics != nil {
+		c.metrics.Misses.Inc()
+	}
+}
+
+func (c *TagValueSeriesIDCache) evicted() {
+	atomic.AddUint64(&c.evictions, 1)
+	if c.metrics != nil {
+		c.metrics.Evictions.Inc()
+	}
 }
 
 // checkEviction checks if the cache is too big, and evicts the least recently used
@@ -172,6 +301,13 @@ func (c *TagValueSeriesIDCache) checkEviction() {
 	}
 
 	e := c.evictor.Back() // Least recently used item.
+	c.budget.Release(e.Value.(*seriesIDCacheElement).entry)
+	c.remove(e)
+	c.evicted()
+}
+
+// remove removes the item of e from the cache.
+func (c *TagValueSeriesIDCache) remove(e *list.Element) {
 	listElement := e.Value.(*seriesIDCacheElement)
 	name := listElement.name
 	key := listElement.key
@@ -179,6 +315,7 @@ func (c *TagValueSeriesIDCache) checkEviction() {
 
 	c.evictor.Remove(e)                                       // Remove from evictor
 	delete(c.cache[string(name)][string(key)], string(value)) // Remove from hashmap of items.
+	atomic.AddUint64(&c.size, -atomic.LoadUint64(&listElement.size))
 
 	// Check if there are no more tag values for the tag key.
 	if len(c.cache[string(name)][string(key)]) == 0 {
@@ -197,4 +334,58 @@ type seriesIDCacheElement struct {
 	key         string
 	value       string
 	SeriesIDSet *tsdb.SeriesIDSet
+
+	cache *TagValueSeriesIDCache
+	size  uint64 // Accessed atomically once the item is cached.
+	hash  uint64
+	entry *tsdb.SeriesIDSetCacheEntry // nil if the cache has no budget.
+}
+
+// bytes estimates the memory footprint of the item, in bytes.
+func (e *seriesIDCacheElement) bytes() uint64 {
+	b := int(unsafe.Sizeof(*e)) + len(e.name) + len(e.key) + len(e.value)
+	if e.SeriesIDSet != nil {
+		b += e.SeriesIDSet.Bytes()
+	}
+	return uint64(b)
+}
+
+// resize measures the item again after its set has changed, and accounts
+// for its new size. It returns the entries evicted from the memory budget.
+func (e *seriesIDCacheElement) resize() []*tsdb.SeriesIDSetCacheEntry {
+	size := e.bytes()
+	old := atomic.SwapUint64(&e.size, size)
+	if size == old {
+		return nil
+	}
+	atomic.AddUint64(&e.cache.size, size-old)
+	return e.cache.budget.Resize(e.entry, size)
+}
+
+// evictEntries removes the items of entries evicted from the memory budget
+// from their caches. The locks of the caches must not be held.
+func evictEntries(entries []*tsdb.SeriesIDSetCacheEntry) {
+	for _, e := range entries {
+		e.Value.(*seriesIDCacheElement).evict()
+	}
+}
+
+// evict removes the item from its cache after it has been evicted from the
+// budget. It is a no-op if the item has already been removed.
+func (e *seriesIDCacheElement) evict() {
+	c := e.cache
+	c.Lock()
+	defer c.Unlock()
+
+	if ele, ok := c.cache[e.name][e.key][e.value]; ok && ele.Value.(*seriesIDCacheElement) == e {
+		c.remove(ele)
+		c.evicted()
+	}
+}
+
+// seriesIDCacheHash returns the hash of the tuple {name, key, value}, used to
+// estimate how often its set is accessed. The hash does not depend on the
+// index, as queries usually look up the same tuples in all shards.
+func seriesIDCacheHash(name, key, value []byte) uint64 {
+	return xxhash.Sum64(name) ^ bits.RotateLeft64(xxhash.Sum64(key), 21) ^ bits.RotateLeft64(xxhash.Sum64(value), 42)
 }
@@ -169,6 +169,92 @@ func TestTagValueSeriesIDCache_addToSet(t *testing.T) {
 
 }
 
+func TestTagValueSeriesIDCache_budget(t *testing.T) {
+	ss := tsdb.NewSeriesIDSet(1, 2, 3)
+	size := (&seriesIDCacheElement{name: "m0", key: "k0", value: "v0", SeriesIDSet: ss}).bytes()
+
+	// Both caches share room for two sets.
+	budget := tsdb.NewSeriesIDSetCacheBudget(2 * size)
+	c0 := TestCache{newTagValueSeriesIDCache(10, budget, nil)}
+	c1 := TestCache{newTagValueSeriesIDCache(10, budget, nil)}
+
+	c0.HasNot(t, "m0", "k0", "v0")
+	c0.PutByString("m0", "k0", "v0", ss)
+	c1.HasNot(t, "m0", "k0", "v1")
+	c1.PutByString("m0", "k0", "v1", ss)
+
+	// A set is not cached in place of a set used as often.
+	c1.HasNot(t, "m0", "k0", "v2")
+	c1.PutByString("m0", "k0", "v2", ss)
+	c1.HasNot(t, "m0", "k0", "v2")
+
+	// Once used more often, it evicts the least recently used set of the other cache.
+	c1.PutByString("m0", "k0", "v2", ss)
+	c0.HasNot(t, "m0", "k0", "v0")
+	c1.Has(t, "m0", "k0", "v1", ss)
+	c1.Has(t, "m0", "k0", "v2", ss)
+
+	if got, exp := c0.Statistics(), (tsdb.SeriesIDSetCacheStatistics{Misses: 2, Evictions: 1}); got != exp {
+		t.Fatalf("got statistics %+v, expected %+v", got, exp)
+	}
+	if got, exp := c1.Statistics(), (tsdb.SeriesIDSetCacheStatistics{Hits: 2, Misses: 3, Sets: 2, Size: 2 * size}); got != exp {
+		t.Fatalf("got statistics %+v, expected %+v", got, exp)
+	}
+	if got, exp := budget.Size(), 2*size; got != exp {
+		t.Fatalf("budget size was %d, expected %d", got, exp)
+	}
+
+	// Clearing a cache releases its sets from the budget.
+	c1.Clear()
+	if got, exp := budget.Size(), uint64(0); got != exp {
+		t.Fatalf("budget size was %d, expected %d", got, exp)
+	}
+}
+
+func TestTagValueSeriesIDCache_budgetAddToSet(t *testing.T) {
+	ids := make([]uint64, 1000)
+	for i := range ids {
+		ids[i] = uint64(i + 1)
+	}
+	size := (&seriesIDCacheElement{name: "m0", key: "k0", value: "v0", SeriesIDSet: tsdb.NewSeriesIDSet(1)}).bytes()
+	grown := (&seriesIDCacheElement{name: "m0", key: "k0", value: "v1", SeriesIDSet: tsdb.NewSeriesIDSet(ids...)}).bytes()
+
+	// There is room for both sets until one of them grows.
+	budget := tsdb.NewSeriesIDSetCacheBudget(size + grown - 1)
+	c0 := TestCache{newTagValueSeriesIDCache(10, budget, nil)}
+	c1 := TestCache{newTagValueSeriesIDCache(10, budget, nil)}
+	c0.PutByString("m0", "k0", "v0", tsdb.NewSeriesIDSet(1))
+	c1.PutByString("m0", "k0", "v1", tsdb.NewSeriesIDSet(1))
+
+	// Growing a set evicts the least recently used set of the other cache.
+	var victims []*tsdb.SeriesIDSetCacheEntry
+	for _, id := range ids[1:] {
+		victims = append(victims, c1.addToSet([]byte("m0"), []byte("k0"), []byte("v1"), id)...)
+	}
+	evictEntries(victims)
+
+	c0.HasNot(t, "m0", "k0", "v0")
+	c1.Has(t, "m0", "k0", "v1", tsdb.NewSeriesIDSet(ids...))
+	if got, exp := c1.Statistics().Size, grown; got != exp {
+		t.Fatalf("cache size was %d, expected %d", got, exp)
+	}
+	if got, exp := budget.Size(), grown; got != exp {
+		t.Fatalf("budget size was %d, expected %d", got, exp)
+	}
+
+	// Removing series from a set accounts for its smaller size.
+	for _, id := range ids[1:] {
+		c1.Delete([]byte("m0"), []byte("k0"), []byte("v1"), id)
+	}
+	c1.Has(t, "m0", "k0", "v1", tsdb.NewSeriesIDSet(1))
+	if got := c1.Statistics().Size; got >= grown {
+		t.Fatalf("cache size was %d, expected less than %d", got, grown)
+	}
+	if got, exp := budget.Size(), c1.Statistics().Size; got != exp {
+		t.Fatalf("budget size was %d, expected %d", got, exp)
+	}
+}
+
 func TestTagValueSeriesIDCache_ConcurrentGetPut(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping long test")
@@ -181,6 +181,38 @@ measurements.
 	┃ └──────────────────────┘ ┃
 	┗━━━━━━━━━━━━━━━━━━━━━━━━━━┛
 
+# N-gram block
+
+Index files may also contain an n-gram block, which is written when the index
+is configured to do so. It indexes every 3-byte sequence occurring in the values
+of each tag key so that regular expressions do not need to be run against all
+the values of a key.
+
+After each tag block, a section is written for every key of the measurement.
+A section holds a sorted list of n-grams, each pointing to a posting list of the
+offsets of the values containing it within the tag block. The n-gram block
+itself is a directory of the offsets of these sections and its position is
+recorded in the trailer. Files without the block use the original trailer
+format.
+
+	┏━━━━━━━NGram Section━━━━━━┓
+	┃ ┌──────────────────────┐ ┃
+	┃ │     N-gram Count     │ ┃
+	┃ ├──────────────────────┤ ┃
+	┃ │ N-gram/Posting Offset│ ┃
+	┃ ├──────────────────────┤ ┃
+	┃ │ N-gram/Posting Offset│ ┃
+	┃ ├──────────────────────┤ ┃
+	┃ │       Postings       │ ┃
+	┃ ├──────────────────────┤ ┃
+	┃ │       Postings       │ ┃
+	┃ └──────────────────────┘ ┃
+	┗━━━━━━━━━━━━━━━━━━━━━━━━━━┛
+
+When a regular expression is matched against the values of a key, the literal
+strings it requires are turned into a query over n-grams. Only the values whose
+posting lists satisfy the query are matched against the expression.
+
 # Manifest file
 
 The index is simply an ordered set of log and index files. These files can be
@@ -171,6 +171,19 @@ func (fs *FileSet) MeasurementIterator() MeasurementIterator {
 	return MergeMeasurementIterators(a...)
 }
 
+// MeasurementPrefixIterator returns an iterator over the measurements which
+// start with prefix, in sorted order.
+func (fs *FileSet) MeasurementPrefixIterator(prefix []byte) MeasurementIterator {
+	a := make([]MeasurementIterator, 0, len(fs.files))
+	for _, f := range fs.files {
+		itr := f.measurementPrefixIterator(prefix)
+		if itr != nil {
+			a = append(a, itr)
+		}
+	}
+	return MergeMeasurementIterators(a...)
+}
+
 // TagKeyIterator returns an iterator over all tag keys for a measurement.
 func (fs *FileSet) TagKeyIterator(name []byte) TagKeyIterator {
 	a := make([]TagKeyIterator, 0, len(fs.files))
@@ -339,6 +352,48 @@ func (fs *FileSet) TagValueIterator(name, key []byte) TagValueIterator {
 	return MergeTagValueIterators(a...)
 }
 
+// TagValuePrefixIterator returns a value iterator over the values of a tag
+// key which start with prefix, in sorted order.
+func (fs *FileSet) TagValuePrefixIterator(name, key, prefix []byte) TagValueIterator {
+	a := make([]TagValueIterator, 0, len(fs.files))
+	for _, f := range fs.files {
+		itr := f.tagValuePrefixIterator(name, key, prefix)
+		if itr != nil {
+			a = append(a, itr)
+		}
+	}
+	return MergeTagValueIterators(a...)
+}
+
+// MatchTagValueIterator returns a value iterator over the values of a tag key
+// which may match value. Index files with an n-gram block only return the
+// candidate values, so callers must still match each returned value.
+func (fs *FileSet) MatchTagValueIterator(name, key []byte, value *regexp.Regexp) TagValueIterator {
+	q := compileNGramQuery(value)
+
+	a := make([]TagValueIterator, 0, len(fs.files))
+	for _, f := range fs.files {
+		itr := f.tagValueIteratorByNGrams(name, key, q)
+		if itr != nil {
+			a = append(a, itr)
+		}
+	}
+	return MergeTagValueIterators(a...)
+}
+
+// NumericTagValueIterator returns an iterator over the values of a numeric tag
+// key which compare to v with op. Values which are not numbers never compare.
+func (fs *FileSet) NumericTagValueIterator(name, key []byte, op influxql.Token, v float64) TagValueIterator {
+	a := make([]TagValueIterator, 0, len(fs.files))
+	for _, f := range fs.files {
+		itr := f.numericTagValueIterator(name, key, op, v)
+		if itr != nil {
+			a = append(a, itr)
+		}
+	}
+	return MergeTagValueIterators(a...)
+}
+
 // TagValueSeriesIDIterator returns a series iterator for a single tag value.
 func (fs *FileSet) TagValueSeriesIDIterator(name, key, value []byte) (tsdb.SeriesIDIterator, error) {
 	ss := tsdb.NewSeriesIDSet()
@@ -408,6 +463,7 @@ type File interface {
 
 	Measurement(name []byte) MeasurementElem
 	MeasurementIterator() MeasurementIterator
+	measurementPrefixIterator(prefix []byte) MeasurementIterator
 	MeasurementHasSeries(ss *tsdb.SeriesIDSet, name []byte) bool
 
 	TagKey(name, key []byte) TagKeyElem
@@ -415,6 +471,9 @@ type File interface {
 
 	TagValue(name, key, value []byte) TagValueElem
 	TagValueIterator(name, key []byte) TagValueIterator
+	tagValueIteratorByNGrams(name, key []byte, q *ngramQuery) TagValueIterator
+	numericTagValueIterator(name, key []byte, op influxql.Token, v float64) TagValueIterator
+	tagValuePrefixIterator(name, key, prefix []byte) TagValueIterator
 
 	// Series iteration.
 	MeasurementSeriesIDIterator(name []byte) tsdb.SeriesIDIterator
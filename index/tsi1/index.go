@@ -1,6 +1,7 @@
 package tsi1
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -40,13 +41,21 @@ func init() {
 		DefaultPartitionN = uint64(i)
 	}
 
-	tsdb.RegisterIndex(IndexName, func(_ uint64, db, path string, _ *tsdb.SeriesIDSet, sfile *tsdb.SeriesFile, opt tsdb.EngineOptions) tsdb.Index {
-		idx := NewIndex(sfile, db,
+	tsdb.RegisterIndex(IndexName, func(id uint64, db, path string, _ *tsdb.SeriesIDSet, sfile *tsdb.SeriesFile, opt tsdb.EngineOptions) tsdb.Index {
+		options := []IndexOption{
 			WithPath(path),
+			WithShardID(id),
 			WithMaximumLogFileSize(int64(opt.Config.MaxIndexLogFileSize)),
 			WithMaximumLogFileAge(time.Duration(opt.Config.CompactFullWriteColdDuration)),
 			WithSeriesIDCacheSize(opt.Config.SeriesIDSetCacheSize),
-		)
+			WithSeriesIDCacheBudget(opt.SeriesIDSetCacheBudget),
+			WithNGramIndex(opt.Config.TagValueNGramIndex),
+			WithNumericTagKeys(opt.Config.NumericTagKeys),
+		}
+		if opt.ReadOnly {
+			options = append(options, ReadOnly())
+		}
+		idx := NewIndex(sfile, db, options...)
 		return idx
 	})
 }
@@ -75,6 +84,33 @@ var DisableCompactions = func() IndexOption {
 	}
 }
 
+// ReadOnly opens the Index without creating, writing or removing any file.
+// Compactions are disabled, and creating or dropping series and measurements
+// returns a tsdb.ReadOnlyError.
+var ReadOnly = func() IndexOption {
+	return func(i *Index) {
+		i.readOnly = true
+	}
+}
+
+// WithNGramIndex sets whether compactions write an n-gram block indexing tag
+// values to index files. The block narrows down the values matched against
+// regular expressions. Index files without the block are read as before.
+var WithNGramIndex = func(enabled bool) IndexOption {
+	return func(i *Index) {
+		i.ngramIndex = enabled
+	}
+}
+
+// WithNumericTagKeys declares tag keys whose values are compared as numbers, so
+// that comparison predicates on them can use the index. Declared keys are
+// recorded in the index and remain numeric once they are.
+var WithNumericTagKeys = func(keys []string) IndexOption {
+	return func(i *Index) {
+		i.numericTagKeys = keys
+	}
+}
+
 // WithLogger sets the logger for the Index.
 var WithLogger = func(l zap.Logger) IndexOption {
 	return func(i *Index) {
@@ -128,14 +164,31 @@ var WithSeriesIDCacheSize = func(sz int) IndexOption {
 	}
 }
 
+// WithShardID sets the ID of the shard of the index, which labels the metrics
+// of the index.
+var WithShardID = func(id uint64) IndexOption {
+	return func(i *Index) {
+		i.shardID = id
+	}
+}
+
+// WithSeriesIDCacheBudget sets the memory budget the series id set cache shares
+// with the caches of other indexes. If nil, the cache is only limited by its size.
+var WithSeriesIDCacheBudget = func(b *tsdb.SeriesIDSetCacheBudget) IndexOption {
+	return func(i *Index) {
+		i.tagValueCacheBudget = b
+	}
+}
+
 // Index represents a collection of layered index files and WAL.
 type Index struct {
 	mu         sync.RWMutex
 	partitions []*Partition
 	opened     bool
 
-	tagValueCache     *TagValueSeriesIDCache
-	tagValueCacheSize int
+	tagValueCache       *TagValueSeriesIDCache
+	tagValueCacheSize   int
+	tagValueCacheBudget *tsdb.SeriesIDSetCacheBudget
 
 	// The following may be set when initializing an Index.
 	path               string        // Root directory of the index partitions.
@@ -144,11 +197,15 @@ type Index struct {
 	maxLogFileAge      time.Duration // Maximum age of a LogFile before it's compacted.
 	logfileBufferSize  int           // The size of the buffer used by the LogFile.
 	disableFsync       bool          // Disables flushing buffers and fsyning files. Used when working with indexes offline.
+	readOnly           bool          // Never creates, writes or removes files. Used when working with indexes offline.
+	ngramIndex         bool          // Writes an n-gram block of tag values to compacted index files.
+	numericTagKeys     []string      // Tag keys whose values are compared as numbers.
 	logger             *zap.Logger   // Index's logger.
 
 	// The following must be set when initializing an Index.
 	sfile    *tsdb.SeriesFile // series lookup file
 	database string           // Name of database.
+	shardID  uint64           // ID of the shard of the index.
 
 	// Cached sketches.
 	mSketch, mTSketch estimator.Sketch // Measurement sketches
@@ -186,7 +243,7 @@ func NewIndex(sfile *tsdb.SeriesFile, database string, options ...IndexOption) *
 		option(idx)
 	}
 
-	idx.tagValueCache = NewTagValueSeriesIDCache(idx.tagValueCacheSize)
+	idx.tagValueCache = newTagValueSeriesIDCache(idx.tagValueCacheSize, idx.tagValueCacheBudget, nil)
 	return idx
 }
 
@@ -260,8 +317,15 @@ func (i *Index) Open() (rErr error) {
 		return errors.New("index already open")
 	}
 
+	// The metrics of the series id set cache are exported while the index is open.
+	i.tagValueCache.setMetrics(tsdb.NewSeriesIDSetCacheMetrics(i.database, i.shardID))
+
 	// Ensure root exists.
-	if err := os.MkdirAll(i.path, 0777); err != nil {
+	if i.readOnly {
+		if _, err := os.Stat(i.path); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(i.path, 0777); err != nil {
 		return err
 	}
 
@@ -272,6 +336,11 @@ func (i *Index) Open() (rErr error) {
 		p.MaxLogFileSize = i.maxLogFileSize
 		p.MaxLogFileAge = i.maxLogFileAge
 		p.nosync = i.disableFsync
+		p.readOnly = i.readOnly
+		p.ngramIndex = i.ngramIndex
+		for _, key := range i.numericTagKeys {
+			p.numericTagKeys[key] = struct{}{}
+		}
 		p.logbufferSize = i.logfileBufferSize
 		p.logger = i.logger.With(zap.String("tsi1_partition", fmt.Sprint(j+1)))
 		i.partitions[j] = p
@@ -340,6 +409,86 @@ func (i *Index) Wait() {
 	}
 }
 
+// rebuildBatchSize is the number of series added at once to a rebuilt partition.
+const rebuildBatchSize = 10000
+
+// Rebuild replaces the series of the index with the series keys passed to add
+// by fn. Each partition is rebuilt next to its live files and swapped in
+// through its manifest, so the index remains available. Series created or
+// deleted while the index is rebuilt are kept. Partitions are swapped in one
+// at a time, so if an error is returned after the first one is swapped in,
+// the index is left with some partitions rebuilt and the others unchanged.
+func (i *Index) Rebuild(ctx context.Context, fn func(add func(key []byte) error) error) (rErr error) {
+	// Cached sets may hold series which are no longer indexed.
+	defer i.tagValueCache.Clear()
+
+	rebuilds := make([]*partitionRebuild, 0, len(i.partitions))
+	defer func() {
+		if rErr != nil {
+			for _, r := range rebuilds {
+				r.abort()
+			}
+		}
+	}()
+	for _, p := range i.partitions {
+		r, err := p.beginRebuild()
+		if err != nil {
+			return err
+		}
+		rebuilds = append(rebuilds, r)
+	}
+
+	// Series are added to each partition in batches.
+	names := make([][][]byte, len(i.partitions))
+	tags := make([][]models.Tags, len(i.partitions))
+	flush := func(j int) error {
+		if err := rebuilds[j].addSeries(names[j], tags[j]); err != nil {
+			return err
+		}
+		names[j], tags[j] = names[j][:0], tags[j][:0]
+		return nil
+	}
+
+	if err := fn(func(key []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		j := i.partitionIdx(key)
+		name, t := models.ParseKeyBytes(key)
+		names[j], tags[j] = append(names[j], name), append(tags[j], t)
+		if len(names[j]) < rebuildBatchSize {
+			return nil
+		}
+		return flush(j)
+	}); err != nil {
+		return err
+	}
+	for j := range rebuilds {
+		if err := flush(j); err != nil {
+			return err
+		}
+	}
+
+	for len(rebuilds) > 0 {
+		r := rebuilds[0]
+		rebuilds = rebuilds[1:]
+		if err := r.commit(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Sketches cannot drop series, so they are rebuilt from the new files.
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.mSketch, i.mTSketch = hll.NewDefaultPlus(), hll.NewDefaultPlus()
+	i.sSketch, i.sTSketch = hll.NewDefaultPlus(), hll.NewDefaultPlus()
+	if err := i.updateSeriesSketches(); err != nil {
+		return err
+	}
+	return i.updateMeasurementSketches()
+}
+
 // Close closes the index.
 func (i *Index) Close() error {
 	// Lock index and close partitions.
@@ -360,11 +509,20 @@ func (i *Index) close() (rErr error) {
 			}
 		}
 	}
+	// Release the memory budget shared with other indexes.
+	i.tagValueCache.Clear()
+	i.tagValueCache.setMetrics(nil)
+
 	// Mark index as closed.
 	i.opened = false
 	return rErr
 }
 
+// SeriesIDSetCacheStatistics returns the statistics of the series id set cache.
+func (i *Index) SeriesIDSetCacheStatistics() tsdb.SeriesIDSetCacheStatistics {
+	return i.tagValueCache.Statistics()
+}
+
 // Path returns the path the index was opened with.
 func (i *Index) Path() string { return i.path }
 
@@ -580,6 +738,22 @@ func (i *Index) MeasurementIterator() (tsdb.MeasurementIterator, error) {
 	return tsdb.MergeMeasurementIterators(itrs...), nil
 }
 
+// MeasurementPrefixIterator returns an iterator over the measurements which
+// start with prefix. At most limit measurements are returned if limit > 0.
+func (i *Index) MeasurementPrefixIterator(prefix []byte, limit int) (tsdb.MeasurementIterator, error) {
+	itrs := make([]tsdb.MeasurementIterator, 0, len(i.partitions))
+	for _, p := range i.partitions {
+		itr, err := p.MeasurementPrefixIterator(prefix)
+		if err != nil {
+			tsdb.MeasurementIterators(itrs).Close()
+			return nil, err
+		} else if itr != nil {
+			itrs = append(itrs, itr)
+		}
+	}
+	return tsdb.LimitMeasurementIterator(tsdb.MergeMeasurementIterators(itrs...), limit), nil
+}
+
 // MeasurementSeriesIDIterator returns an iterator over all series in a measurement.
 func (i *Index) MeasurementSeriesIDIterator(name []byte) (tsdb.SeriesIDIterator, error) {
 	itrs := make([]tsdb.SeriesIDIterator, 0, len(i.partitions))
@@ -692,6 +866,7 @@ func (i *Index) CreateSeriesListIfNotExists(keys [][]byte, names [][]byte, tagsS
 				}
 
 				// Some cached bitset results may need to be updated.
+				var victims []*tsdb.SeriesIDSetCacheEntry
 				i.tagValueCache.RLock()
 				for j, id := range ids {
 					if id == 0 {
@@ -716,11 +891,12 @@ func (i *Index) CreateSeriesListIfNotExists(keys [][]byte, names [][]byte, tagsS
 							// and then keep it locked until we're done with all the ids.
 							//
 							// Note: this will only add `id` to the set if it exists.
-							i.tagValueCache.addToSet(name, pair.Key, pair.Value, id) // Takes a lock on the series id set
+							victims = append(victims, i.tagValueCache.addToSet(name, pair.Key, pair.Value, id)...) // Takes a lock on the series id set
 						}
 					}
 				}
 				i.tagValueCache.RUnlock()
+				evictEntries(victims)
 
 				errC <- err
 			}
@@ -766,6 +942,7 @@ func (i *Index) CreateSeriesIfNotExists(key, name []byte, tags models.Tags) erro
 
 	// If there are cached sets for any of the tag pairs, they will need to be
 	// updated with the series id.
+	var victims []*tsdb.SeriesIDSetCacheEntry
 	i.tagValueCache.RLock()
 	if i.tagValueCache.measurementContainsSets(name) {
 		for _, pair := range tags {
@@ -780,10 +957,11 @@ func (i *Index) CreateSeriesIfNotExists(key, name []byte, tags models.Tags) erro
 			// Need to think on it, but I think taking a lock on each series id set is the way to go.
 			//
 			// Note this will only add `id` to the set if it exists.
-			i.tagValueCache.addToSet(name, pair.Key, pair.Value, ids[0]) // Takes a lock on the series id set
+			victims = append(victims, i.tagValueCache.addToSet(name, pair.Key, pair.Value, ids[0])...) // Takes a lock on the series id set
 		}
 	}
 	i.tagValueCache.RUnlock()
+	evictEntries(victims)
 	return nil
 }
 
@@ -809,13 +987,15 @@ func (i *Index) DropSeries(seriesID uint64, key []byte, cascade bool) error {
 
 	// If there are cached sets for any of the tag pairs, they will need to be
 	// updated with the series id.
+	var victims []*tsdb.SeriesIDSetCacheEntry
 	i.tagValueCache.RLock()
 	if i.tagValueCache.measurementContainsSets(name) {
 		for _, pair := range tags {
-			i.tagValueCache.delete(name, pair.Key, pair.Value, seriesID) // Takes a lock on the series id set
+			victims = append(victims, i.tagValueCache.delete(name, pair.Key, pair.Value, seriesID)...) // Takes a lock on the series id set
 		}
 	}
 	i.tagValueCache.RUnlock()
+	evictEntries(victims)
 
 	// Check if that was the last series for the measurement in the entire index.
 	if ok, err := i.MeasurementHasSeries(name); err != nil {
@@ -982,6 +1162,65 @@ func (i *Index) TagValueIterator(name, key []byte) (tsdb.TagValueIterator, error
 	return tsdb.MergeTagValueIterators(a...), nil
 }
 
+// TagValuePrefixIterator returns an iterator over the values of a single key
+// which start with prefix. At most limit values are returned if limit > 0.
+func (i *Index) TagValuePrefixIterator(name, key, prefix []byte, limit int) (tsdb.TagValueIterator, error) {
+	a := make([]tsdb.TagValueIterator, 0, len(i.partitions))
+	for _, p := range i.partitions {
+		itr, err := p.TagValuePrefixIterator(name, key, prefix)
+		if err != nil {
+			tsdb.TagValueIterators(a).Close()
+			return nil, err
+		} else if itr != nil {
+			a = append(a, itr)
+		}
+	}
+	return tsdb.LimitTagValueIterator(tsdb.MergeTagValueIterators(a...), limit), nil
+}
+
+// IsNumericTagKey returns true if the values of key are compared as numbers.
+func (i *Index) IsNumericTagKey(key []byte) bool {
+	for _, p := range i.partitions {
+		if !p.IsNumericTagKey(key) {
+			return false
+		}
+	}
+	return len(i.partitions) > 0
+}
+
+// NumericTagValueIterator returns an iterator over the values of a numeric tag
+// key which compare to v with op. Values which are not numbers never compare.
+func (i *Index) NumericTagValueIterator(name, key []byte, op influxql.Token, v float64) (tsdb.TagValueIterator, error) {
+	a := make([]tsdb.TagValueIterator, 0, len(i.partitions))
+	for _, p := range i.partitions {
+		itr, err := p.NumericTagValueIterator(name, key, op, v)
+		if err != nil {
+			tsdb.TagValueIterators(a).Close()
+			return nil, err
+		} else if itr != nil {
+			a = append(a, itr)
+		}
+	}
+	return tsdb.MergeTagValueIterators(a...), nil
+}
+
+// MatchTagValueIterator returns an iterator over the values of a single key
+// which may match value. Values are narrowed using the n-gram blocks of the
+// index files, so callers must still match each returned value.
+func (i *Index) MatchTagValueIterator(name, key []byte, value *regexp.Regexp) (tsdb.TagValueIterator, error) {
+	a := make([]tsdb.TagValueIterator, 0, len(i.partitions))
+	for _, p := range i.partitions {
+		itr, err := p.MatchTagValueIterator(name, key, value)
+		if err != nil {
+			tsdb.TagValueIterators(a).Close()
+			return nil, err
+		} else if itr != nil {
+			a = append(a, itr)
+		}
+	}
+	return tsdb.MergeTagValueIterators(a...), nil
+}
+
 // TagKeySeriesIDIterator returns a series iterator for all values across a single key.
 func (i *Index) TagKeySeriesIDIterator(name, key []byte) (tsdb.SeriesIDIterator, error) {
 	a := make([]tsdb.SeriesIDIterator, 0, len(i.partitions))
@@ -14,11 +14,16 @@ import (
 	"github.com/influxdata/influxdb/v2/pkg/estimator/hll"
 	"github.com/influxdata/influxdb/v2/pkg/mmap"
 	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxql"
 )
 
 // IndexFileVersion is the current TSI1 index file version.
 const IndexFileVersion = 1
 
+// IndexFileNGramVersion is the version of TSI1 index files that include an
+// n-gram block. Files without the block keep the IndexFileVersion format.
+const IndexFileNGramVersion = 2
+
 // FileSignature represents a magic number at the header of the index file.
 const FileSignature = "TSI1"
 
@@ -35,6 +40,12 @@ const (
 		8 + 8 + // series sketch offset + size
 		8 + 8 + // tombstone series sketch offset + size
 		0
+
+	// IndexFileNGramTrailerSize is the size of the trailer of files with an
+	// n-gram block. Currently 98 bytes.
+	IndexFileNGramTrailerSize = IndexFileTrailerSize +
+		8 + 8 + // n-gram block offset + size
+		0
 )
 
 // IndexFile errors.
@@ -52,6 +63,7 @@ type IndexFile struct {
 	sfile *tsdb.SeriesFile
 	tblks map[string]*TagBlock // tag blocks by measurement name
 	mblk  MeasurementBlock
+	nblk  NGramBlock // optional n-gram index of tag values
 
 	// Raw series set data.
 	seriesIDSetData          []byte
@@ -68,6 +80,15 @@ type IndexFile struct {
 	// Compaction tracking.
 	compacting bool
 
+	// Values of numeric tag keys sorted by number, built on first use.
+	numeric numericTagValuesCache
+
+	// Offsets of the measurements and of the values of tag keys in sorted
+	// order, built on the first prefix search.
+	offsetsMu          sync.Mutex
+	measurementOffsets []int
+	tagValueOffsets    map[string]map[string][]int
+
 	// Path to data file.
 	path string
 }
@@ -75,7 +96,8 @@ type IndexFile struct {
 // NewIndexFile returns a new instance of IndexFile.
 func NewIndexFile(sfile *tsdb.SeriesFile) *IndexFile {
 	return &IndexFile{
-		sfile: sfile,
+		sfile:   sfile,
+		numeric: numericTagValuesCache{maxSize: maxNumericTagValuesCacheSize},
 	}
 }
 
@@ -95,11 +117,22 @@ func (f *IndexFile) bytes() int {
 		b += int(unsafe.Sizeof(*v))
 	}
 	b += int(unsafe.Sizeof(f.mblk)) + f.mblk.bytes()
+	b += f.nblk.bytes()
 	b += int(unsafe.Sizeof(f.seriesIDSetData) + unsafe.Sizeof(f.tombstoneSeriesIDSetData))
 	// Do not count contents of seriesIDSetData or tombstoneSeriesIDSetData: references f.data
 	b += int(unsafe.Sizeof(f.level) + unsafe.Sizeof(f.id))
 	b += 24 // mu RWMutex is 24 bytes
 	b += int(unsafe.Sizeof(f.compacting))
+	b += f.numeric.bytes()
+	f.offsetsMu.Lock()
+	b += cap(f.measurementOffsets) * int(unsafe.Sizeof(int(0)))
+	for name, keys := range f.tagValueOffsets {
+		b += int(unsafe.Sizeof(name)) + len(name)
+		for key, a := range keys {
+			b += int(unsafe.Sizeof(key)) + len(key) + cap(a)*int(unsafe.Sizeof(int(0)))
+		}
+	}
+	f.offsetsMu.Unlock()
 	b += int(unsafe.Sizeof(f.path)) + len(f.path)
 	f.wg.Done()
 	return b
@@ -133,6 +166,10 @@ func (f *IndexFile) Close() error {
 	f.sfile = nil
 	f.tblks = nil
 	f.mblk = MeasurementBlock{}
+	f.nblk = NGramBlock{}
+	f.numeric.reset()
+	f.measurementOffsets = nil
+	f.tagValueOffsets = nil
 	return mmap.Unmap(f.data)
 }
 
@@ -213,6 +250,13 @@ func (f *IndexFile) UnmarshalBinary(data []byte) error {
 		f.tblks[string(e.name)] = &tblk
 	}
 
+	// Unmarshal n-gram block, if the file was written with one.
+	if t.NGramBlock.Size > 0 {
+		if err := f.nblk.UnmarshalBinary(data[t.NGramBlock.Offset:][:t.NGramBlock.Size]); err != nil {
+			return fmt.Errorf("%q: %w", f.path, err)
+		}
+	}
+
 	// Save reference to entire data block.
 	f.data = data
 
@@ -289,6 +333,83 @@ func (f *IndexFile) TagValueIterator(name, key []byte) TagValueIterator {
 	return ke.TagValueIterator()
 }
 
+// tagValueIteratorByNGrams returns an iterator over the values of a tag key
+// which may satisfy q. Values are narrowed using the n-gram block if the file
+// has one, otherwise all values of the key are returned.
+func (f *IndexFile) tagValueIteratorByNGrams(name, key []byte, q *ngramQuery) TagValueIterator {
+	tblk := f.tblks[string(name)]
+	if tblk == nil {
+		return nil
+	}
+
+	// Find key element.
+	ke := tblk.TagKeyElem(key)
+	if ke == nil {
+		return nil
+	}
+
+	sec := f.nblk.section(f.data, name, key)
+	if sec == nil {
+		return ke.TagValueIterator()
+	}
+
+	offsets, all := sec.eval(q)
+	if all {
+		return ke.TagValueIterator()
+	} else if len(offsets) == 0 {
+		return nil
+	}
+	return &ngramTagValueIterator{data: tblk.data, offsets: offsets}
+}
+
+// numericTagValueIterator returns an iterator over the numeric values of a tag
+// key which compare to v with op. The values of the key are sorted by number
+// on first use and cached, within a memory limit, until the file is closed.
+func (f *IndexFile) numericTagValueIterator(name, key []byte, op influxql.Token, v float64) TagValueIterator {
+	a := f.numeric.get(name, key, func() numericTagValues {
+		return newNumericTagValues(f.TagValueIterator(name, key))
+	})
+	return a.iterator(op, v)
+}
+
+// tagValuePrefixIterator returns an iterator over the values of a tag key
+// which start with prefix. Iteration starts at the first value not before
+// prefix, found using the sorted offsets of the values of the key.
+func (f *IndexFile) tagValuePrefixIterator(name, key, prefix []byte) TagValueIterator {
+	if len(prefix) == 0 {
+		return f.TagValueIterator(name, key)
+	}
+
+	tblk := f.tblks[string(name)]
+	if tblk == nil {
+		return nil
+	}
+
+	var ke TagBlockKeyElem
+	if !tblk.DecodeTagKeyElem(key, &ke) {
+		return nil
+	}
+
+	f.offsetsMu.Lock()
+	offsets, ok := f.tagValueOffsets[string(name)][string(key)]
+	if !ok {
+		offsets = ke.tagValueOffsets()
+
+		if f.tagValueOffsets == nil {
+			f.tagValueOffsets = make(map[string]map[string][]int)
+		}
+		keys := f.tagValueOffsets[string(name)]
+		if keys == nil {
+			keys = make(map[string][]int)
+			f.tagValueOffsets[string(name)] = keys
+		}
+		keys[string(key)] = offsets
+	}
+	f.offsetsMu.Unlock()
+
+	return newPrefixTagValueIterator(ke.seekTagValueIterator(offsets, prefix), prefix)
+}
+
 // TagKeySeriesIDIterator returns a series iterator for a tag key and a flag
 // indicating if a tombstone exists on the measurement or key.
 func (f *IndexFile) TagKeySeriesIDIterator(name, key []byte) (tsdb.SeriesIDIterator, error) {
@@ -377,6 +498,24 @@ func (f *IndexFile) MeasurementIterator() MeasurementIterator {
 	return f.mblk.Iterator()
 }
 
+// measurementPrefixIterator returns an iterator over the measurements which
+// start with prefix. Iteration starts at the first measurement not before
+// prefix, found using the sorted offsets of the measurements.
+func (f *IndexFile) measurementPrefixIterator(prefix []byte) MeasurementIterator {
+	if len(prefix) == 0 {
+		return f.MeasurementIterator()
+	}
+
+	f.offsetsMu.Lock()
+	if f.measurementOffsets == nil {
+		f.measurementOffsets = f.mblk.offsets()
+	}
+	offsets := f.measurementOffsets
+	f.offsetsMu.Unlock()
+
+	return newPrefixMeasurementIterator(f.mblk.seekIterator(offsets, prefix), prefix)
+}
+
 // TagKeyIterator returns an iterator over all tag keys for a measurement.
 func (f *IndexFile) TagKeyIterator(name []byte) TagKeyIterator {
 	blk := f.tblks[string(name)]
@@ -416,12 +555,17 @@ func ReadIndexFileTrailer(data []byte) (IndexFileTrailer, error) {
 
 	// Read version.
 	t.Version = int(binary.BigEndian.Uint16(data[len(data)-IndexFileVersionSize:]))
-	if t.Version != IndexFileVersion {
-		return t, ErrUnsupportedIndexFileVersion
-	}
 
 	// Slice trailer data.
-	buf := data[len(data)-IndexFileTrailerSize:]
+	var buf []byte
+	switch t.Version {
+	case IndexFileVersion:
+		buf = data[len(data)-IndexFileTrailerSize:]
+	case IndexFileNGramVersion:
+		buf = data[len(data)-IndexFileNGramTrailerSize:]
+	default:
+		return t, ErrUnsupportedIndexFileVersion
+	}
 
 	// Read measurement block info.
 	t.MeasurementBlock.Offset, buf = int64(binary.BigEndian.Uint64(buf[0:8])), buf[8:]
@@ -443,6 +587,12 @@ func ReadIndexFileTrailer(data []byte) (IndexFileTrailer, error) {
 	t.TombstoneSeriesSketch.Offset, buf = int64(binary.BigEndian.Uint64(buf[0:8])), buf[8:]
 	t.TombstoneSeriesSketch.Size, buf = int64(binary.BigEndian.Uint64(buf[0:8])), buf[8:]
 
+	// Read n-gram block info.
+	if t.Version == IndexFileNGramVersion {
+		t.NGramBlock.Offset, buf = int64(binary.BigEndian.Uint64(buf[0:8])), buf[8:]
+		t.NGramBlock.Size, buf = int64(binary.BigEndian.Uint64(buf[0:8])), buf[8:]
+	}
+
 	if len(buf) != 2 { // Version field still in buffer.
 		return t, fmt.Errorf("unread %d bytes left unread in trailer", len(buf)-2)
 	}
@@ -477,6 +627,12 @@ type IndexFileTrailer struct {
 		Offset int64
 		Size   int64
 	}
+
+	// Only written by IndexFileNGramVersion files.
+	NGramBlock struct {
+		Offset int64
+		Size   int64
+	}
 }
 
 // WriteTo writes the trailer to w.
@@ -516,8 +672,19 @@ func (t *IndexFileTrailer) WriteTo(w io.Writer) (n int64, err error) {
 		return n, err
 	}
 
-	// Write index file encoding version.
-	if err := writeUint16To(w, IndexFileVersion, &n); err != nil {
+	// Write n-gram block info, if any, and index file encoding version.
+	if t.NGramBlock.Size == 0 {
+		if err := writeUint16To(w, IndexFileVersion, &n); err != nil {
+			return n, err
+		}
+		return n, nil
+	}
+
+	if err := writeUint64To(w, uint64(t.NGramBlock.Offset), &n); err != nil {
+		return n, err
+	} else if err := writeUint64To(w, uint64(t.NGramBlock.Size), &n); err != nil {
+		return n, err
+	} else if err := writeUint16To(w, IndexFileNGramVersion, &n); err != nil {
 		return n, err
 	}
 
@@ -184,7 +184,7 @@ func CreateIndexFile(sfile *tsdb.SeriesFile, series []Series) (*tsi1.IndexFile,
 
 	// Write index file to buffer.
 	var buf bytes.Buffer
-	if _, err := lf.CompactTo(&buf, M, K, nil); err != nil {
+	if _, err := lf.CompactTo(&buf, M, K, false, nil); err != nil {
 		return nil, err
 	}
 
@@ -207,7 +207,7 @@ func GenerateIndexFile(sfile *tsdb.SeriesFile, measurementN, tagN, valueN int) (
 
 	// Compact log file to buffer.
 	var buf bytes.Buffer
-	if _, err := lf.CompactTo(&buf, M, K, nil); err != nil {
+	if _, err := lf.CompactTo(&buf, M, K, false, nil); err != nil {
 		return nil, err
 	}
 
@@ -151,8 +151,9 @@ func (p IndexFiles) TagValueSeriesIDSet(name, key, value []byte) (*tsdb.SeriesID
 	return ss, nil
 }
 
-// CompactTo merges all index files and writes them to w.
-func (p IndexFiles) CompactTo(w io.Writer, sfile *tsdb.SeriesFile, m, k uint64, cancel <-chan struct{}) (n int64, err error) {
+// CompactTo merges all index files and writes them to w. If ngrams is true, an
+// n-gram block indexing the tag values is also written.
+func (p IndexFiles) CompactTo(w io.Writer, sfile *tsdb.SeriesFile, m, k uint64, ngrams bool, cancel <-chan struct{}) (n int64, err error) {
 	var t IndexFileTrailer
 
 	// Check for cancellation.
@@ -169,6 +170,9 @@ func (p IndexFiles) CompactTo(w io.Writer, sfile *tsdb.SeriesFile, m, k uint64,
 	var info indexCompactInfo
 	info.cancel = cancel
 	info.tagSets = make(map[string]indexTagSetPos)
+	if ngrams {
+		info.ngrams = NewNGramBlockWriter()
+	}
 
 	// Write magic number.
 	if err := writeTo(bw, []byte(FileSignature), &n); err != nil {
@@ -256,6 +260,16 @@ func (p IndexFiles) CompactTo(w io.Writer, sfile *tsdb.SeriesFile, m, k uint64,
 	t.TombstoneSeriesSketch.Size = int64(len(data))
 	n += t.TombstoneSeriesSketch.Size
 
+	// Write n-gram block.
+	if info.ngrams != nil {
+		t.NGramBlock.Offset = n
+		nn, err = info.ngrams.WriteTo(bw)
+		if n += nn; err != nil {
+			return n, err
+		}
+		t.NGramBlock.Size = n - t.NGramBlock.Offset
+	}
+
 	// Write trailer.
 	nn, err = t.WriteTo(bw)
 	n += nn
@@ -326,6 +340,12 @@ func (p IndexFiles) writeTagsetTo(w io.Writer, name []byte, info *indexCompactIn
 				if err != nil {
 					return err
 				}
+
+				// Index n-grams of tombstoned values too, so they still
+				// shadow the value in older files.
+				if info.ngrams != nil {
+					info.ngrams.Add(ke.Key(), ve.Value(), enc.N())
+				}
 				return enc.EncodeValue(ve.Value(), ve.Deleted(), ss)
 			}(); err != nil {
 				return nil
@@ -349,6 +369,13 @@ func (p IndexFiles) writeTagsetTo(w io.Writer, name []byte, info *indexCompactIn
 
 	info.tagSets[string(name)] = pos
 
+	// Write n-gram sections of the tagset.
+	if info.ngrams != nil {
+		if err := info.ngrams.WriteTagSetTo(w, name, n); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -448,6 +475,9 @@ type indexCompactInfo struct {
 
 	// Tracks offset/size for each measurement's tagset.
 	tagSets map[string]indexTagSetPos
+
+	// Builds the n-gram block. Nil if the block is not written.
+	ngrams *NGramBlockWriter
 }
 
 // indexTagSetPos stores the offset/size of tagsets.
@@ -2,6 +2,7 @@ package tsi1_test
 
 import (
 	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -18,6 +19,7 @@ import (
 	"github.com/influxdata/influxdb/v2/models"
 	"github.com/influxdata/influxdb/v2/tsdb"
 	"github.com/influxdata/influxdb/v2/tsdb/index/tsi1"
+	"github.com/influxdata/influxql"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -523,6 +525,194 @@ func TestIndex_TagValueSeriesIDIterator(t *testing.T) {
 	})
 }
 
+// Ensure comparison predicates on numeric tag keys return the matching values.
+func TestIndex_NumericTagValueIterator(t *testing.T) {
+	idx := &Index{SeriesFile: NewSeriesFile(t)}
+	idx.Index = tsi1.NewIndex(idx.SeriesFile.SeriesFile, "db0", tsi1.WithPath(t.TempDir()), tsi1.WithNumericTagKeys([]string{"version"}))
+	idx.Index.PartitionN = 2
+	require.NoError(t, idx.Open())
+	defer idx.Close()
+
+	var series []Series
+	for _, v := range []string{"-1.5", "012", "100", "12", "12.0", "13", "2", "abc"} {
+		series = append(series, Series{Name: []byte("cpu"), Tags: models.NewTags(map[string]string{"region": v, "version": v})})
+	}
+	require.NoError(t, idx.CreateSeriesSliceIfNotExists(series))
+
+	// Values added later are sorted among the existing ones.
+	require.NoError(t, idx.CreateSeriesSliceIfNotExists([]Series{
+		{Name: []byte("cpu"), Tags: models.NewTags(map[string]string{"region": "7", "version": "7"})},
+	}))
+
+	idx.Run(t, func(t *testing.T) {
+		// Keys stay numeric once recorded, even if no longer declared.
+		require.True(t, idx.IsNumericTagKey([]byte("version")))
+		require.False(t, idx.IsNumericTagKey([]byte("region")))
+
+		for _, tt := range []struct {
+			op       influxql.Token
+			v        float64
+			expected []string
+		}{
+			{op: influxql.GT, v: 12, expected: []string{"100", "13"}},
+			{op: influxql.GTE, v: 12, expected: []string{"012", "100", "12", "12.0", "13"}},
+			{op: influxql.LT, v: 12, expected: []string{"-1.5", "2", "7"}},
+			{op: influxql.LTE, v: 2, expected: []string{"-1.5", "2"}},
+			{op: influxql.EQ, v: 12, expected: []string{"012", "12", "12.0"}},
+			{op: influxql.NEQ, v: 12, expected: []string{"-1.5", "100", "13", "2", "7"}},
+			{op: influxql.GT, v: 2, expected: []string{"012", "100", "12", "12.0", "13", "7"}},
+			{op: influxql.GT, v: 1000, expected: nil},
+		} {
+			itr, err := idx.NumericTagValueIterator([]byte("cpu"), []byte("version"), tt.op, tt.v)
+			require.NoError(t, err)
+
+			var values []string
+			if itr != nil {
+				for {
+					v, err := itr.Next()
+					require.NoError(t, err)
+					if v == nil {
+						break
+					}
+					values = append(values, string(v))
+				}
+				require.NoError(t, itr.Close())
+			}
+			require.Equal(t, tt.expected, values, "%s %v", tt.op, tt.v)
+		}
+	})
+}
+
+// Ensure measurements and tag values can be searched by prefix.
+func TestIndex_PrefixIterators(t *testing.T) {
+	idx := MustOpenIndex(t, 2)
+	defer idx.Close()
+
+	var series []Series
+	for _, name := range []string{"cpu", "cpu2", "cpu_load", "disk", "mem"} {
+		for _, host := range []string{"a", "server01", "server02", "server10", "serverless", "z"} {
+			series = append(series, Series{Name: []byte(name), Tags: models.NewTags(map[string]string{"host": host})})
+		}
+	}
+	require.NoError(t, idx.CreateSeriesSliceIfNotExists(series))
+	require.NoError(t, idx.DropMeasurement([]byte("cpu2")))
+
+	readAll := func(t *testing.T, next func() ([]byte, error)) []string {
+		var a []string
+		for {
+			v, err := next()
+			require.NoError(t, err)
+			if v == nil {
+				return a
+			}
+			a = append(a, string(v))
+		}
+	}
+
+	idx.Run(t, func(t *testing.T) {
+		for _, tt := range []struct {
+			prefix   string
+			limit    int
+			expected []string
+		}{
+			{prefix: "", expected: []string{"cpu", "cpu_load", "disk", "mem"}},
+			{prefix: "cpu", expected: []string{"cpu", "cpu_load"}},
+			{prefix: "cpu", limit: 1, expected: []string{"cpu"}},
+			{prefix: "d", expected: []string{"disk"}},
+			{prefix: "cpu2", expected: nil},
+			{prefix: "net", expected: nil},
+			{prefix: "a", expected: nil},
+			{prefix: "zz", expected: nil},
+		} {
+			itr, err := idx.MeasurementPrefixIterator([]byte(tt.prefix), tt.limit)
+			require.NoError(t, err)
+
+			var names []string
+			if itr != nil {
+				names = readAll(t, itr.Next)
+				require.NoError(t, itr.Close())
+			}
+			require.Equal(t, tt.expected, names, "prefix=%q limit=%d", tt.prefix, tt.limit)
+		}
+
+		for _, tt := range []struct {
+			prefix   string
+			limit    int
+			expected []string
+		}{
+			{prefix: "server", expected: []string{"server01", "server02", "server10", "serverless"}},
+			{prefix: "server0", expected: []string{"server01", "server02"}},
+			{prefix: "server", limit: 3, expected: []string{"server01", "server02", "server10"}},
+			{prefix: "z", expected: []string{"z"}},
+			{prefix: "b", expected: nil},
+			{prefix: "a", expected: []string{"a"}},
+			{prefix: "zz", expected: nil},
+		} {
+			itr, err := idx.TagValuePrefixIterator([]byte("cpu"), []byte("host"), []byte(tt.prefix), tt.limit)
+			require.NoError(t, err)
+
+			var values []string
+			if itr != nil {
+				values = readAll(t, itr.Next)
+				require.NoError(t, itr.Close())
+			}
+			require.Equal(t, tt.expected, values, "prefix=%q limit=%d", tt.prefix, tt.limit)
+		}
+	})
+}
+
+// Ensure the index can be rebuilt while series are created.
+func TestIndex_Rebuild(t *testing.T) {
+	idx := MustOpenIndex(t, 2)
+	defer idx.Close()
+
+	require.NoError(t, idx.CreateSeriesSliceIfNotExists([]Series{
+		{Name: []byte("cpu"), Tags: models.NewTags(map[string]string{"host": "A"})},
+		{Name: []byte("cpu"), Tags: models.NewTags(map[string]string{"host": "B"})},
+	}))
+
+	require.NoError(t, idx.Rebuild(context.Background(), func(add func(key []byte) error) error {
+		// Series created while rebuilding are kept.
+		if err := idx.CreateSeriesSliceIfNotExists([]Series{
+			{Name: []byte("cpu"), Tags: models.NewTags(map[string]string{"host": "D"})},
+		}); err != nil {
+			return err
+		}
+
+		for _, key := range []string{"cpu,host=A", "cpu,host=C"} {
+			if err := add([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	for _, reopen := range []bool{false, true} {
+		if reopen {
+			require.NoError(t, idx.Reopen(tsdb.DefaultMaxIndexLogFileSize))
+		}
+
+		itr, err := idx.TagValueIterator([]byte("cpu"), []byte("host"))
+		require.NoError(t, err)
+		var values []string
+		for {
+			v, err := itr.Next()
+			require.NoError(t, err)
+			if v == nil {
+				break
+			}
+			values = append(values, string(v))
+		}
+		require.NoError(t, itr.Close())
+		require.Equal(t, []string{"A", "C", "D"}, values)
+		require.Equal(t, uint64(3), idx.SeriesIDSet().Cardinality())
+
+		s, ts, err := idx.SeriesSketches()
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), s.Count()-ts.Count())
+	}
+}
+
 // Index is a test wrapper for tsi1.Index.
 type Index struct {
 	*tsi1.Index
@@ -21,6 +21,7 @@ import (
 	"github.com/influxdata/influxdb/v2/pkg/estimator/hll"
 	"github.com/influxdata/influxdb/v2/pkg/mmap"
 	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxql"
 )
 
 // Log errors.
@@ -56,6 +57,7 @@ type LogFile struct {
 	w          *bufio.Writer  // buffered writer
 	bufferSize int            // The size of the buffer used by the buffered writer
 	nosync     bool           // Disables buffer flushing and file syncing. Useful for offline tooling.
+	readOnly   bool           // Only memory maps the file, without opening it for writes.
 	buf        []byte         // marshaling buffer
 	keyBuf     []byte
 
@@ -123,22 +125,31 @@ func (f *LogFile) open() error {
 	f.id, _ = ParseFilename(f.path)
 
 	// Open file for appending.
-	file, err := os.OpenFile(f.Path(), os.O_WRONLY|os.O_CREATE, 0666)
-	if err != nil {
-		return err
-	}
-	f.file = file
+	var fi os.FileInfo
+	if f.readOnly {
+		var err error
+		if fi, err = os.Stat(f.Path()); err != nil {
+			return err
+		}
+	} else {
+		file, err := os.OpenFile(f.Path(), os.O_WRONLY|os.O_CREATE, 0666)
+		if err != nil {
+			return err
+		}
+		f.file = file
+
+		if f.bufferSize == 0 {
+			f.bufferSize = defaultLogFileBufferSize
+		}
+		f.w = bufio.NewWriterSize(f.file, f.bufferSize)
 
-	if f.bufferSize == 0 {
-		f.bufferSize = defaultLogFileBufferSize
+		if fi, err = file.Stat(); err != nil {
+			return err
+		}
 	}
-	f.w = bufio.NewWriterSize(f.file, f.bufferSize)
 
 	// Finish opening if file is empty.
-	fi, err := file.Stat()
-	if err != nil {
-		return err
-	} else if fi.Size() == 0 {
+	if fi.Size() == 0 {
 		return nil
 	}
 	f.size = fi.Size()
@@ -172,7 +183,10 @@ func (f *LogFile) open() error {
 
 	// Move to the end of the file.
 	f.size = n
-	_, err = file.Seek(n, io.SeekStart)
+	if f.file == nil {
+		return nil
+	}
+	_, err = f.file.Seek(n, io.SeekStart)
 	return err
 }
 
@@ -432,6 +446,56 @@ func (f *LogFile) TagValueIterator(name, key []byte) TagValueIterator {
 	return tk.TagValueIterator()
 }
 
+// tagValueIteratorByNGrams returns a value iterator for a tag key. Log files
+// are not n-gram indexed so all values of the key are returned.
+func (f *LogFile) tagValueIteratorByNGrams(name, key []byte, q *ngramQuery) TagValueIterator {
+	return f.TagValueIterator(name, key)
+}
+
+// numericTagValueIterator returns an iterator over the numeric values of a tag
+// key which compare to v with op. The numeric values of each tag key are kept
+// sorted by number as they are added.
+func (f *LogFile) numericTagValueIterator(name, key []byte, op influxql.Token, v float64) TagValueIterator {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	mm, ok := f.mms[string(name)]
+	if !ok {
+		return nil
+	}
+
+	tk, ok := mm.tagSet[string(key)]
+	if !ok {
+		return nil
+	}
+	return tk.numeric.iterator(op, v)
+}
+
+// tagValuePrefixIterator returns a value iterator for a tag key, over the
+// values which start with prefix.
+func (f *LogFile) tagValuePrefixIterator(name, key, prefix []byte) TagValueIterator {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	mm, ok := f.mms[string(name)]
+	if !ok {
+		return nil
+	}
+
+	tk, ok := mm.tagSet[string(key)]
+	if !ok {
+		return nil
+	}
+
+	var a []logTagValue
+	for _, v := range tk.tagValues {
+		if bytes.HasPrefix(v.name, prefix) {
+			a = append(a, v)
+		}
+	}
+	return newLogTagValueIterator(a)
+}
+
 // DeleteTagKey adds a tombstone for a tag key to the log file.
 func (f *LogFile) DeleteTagKey(name, key []byte) error {
 	f.mu.Lock()
@@ -662,6 +726,7 @@ func (f *LogFile) execDeleteTagValueEntry(e *LogEntry) {
 	tv := ts.createTagValueIfNotExists(e.Value)
 
 	tv.deleted = true
+	ts.numeric.setDeleted(tv.name)
 
 	ts.tagValues[string(e.Value)] = tv
 	mm.tagSet[string(e.Key)] = ts
@@ -795,6 +860,22 @@ func (f *LogFile) MeasurementIterator() MeasurementIterator {
 	return &itr
 }
 
+// measurementPrefixIterator returns an iterator over the measurements in the
+// file which start with prefix.
+func (f *LogFile) measurementPrefixIterator(prefix []byte) MeasurementIterator {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var itr logMeasurementIterator
+	for _, mm := range f.mms {
+		if bytes.HasPrefix(mm.name, prefix) {
+			itr.mms = append(itr.mms, *mm)
+		}
+	}
+	sort.Sort(logMeasurementSlice(itr.mms))
+	return &itr
+}
+
 // MeasurementSeriesIDIterator returns an iterator over all series for a measurement.
 func (f *LogFile) MeasurementSeriesIDIterator(name []byte) tsdb.SeriesIDIterator {
 	f.mu.RLock()
@@ -807,8 +888,9 @@ func (f *LogFile) MeasurementSeriesIDIterator(name []byte) tsdb.SeriesIDIterator
 	return tsdb.NewSeriesIDSetIterator(mm.seriesIDSet())
 }
 
-// CompactTo compacts the log file and writes it to w.
-func (f *LogFile) CompactTo(w io.Writer, m, k uint64, cancel <-chan struct{}) (n int64, err error) {
+// CompactTo compacts the log file and writes it to w. If ngrams is true, an
+// n-gram block indexing the tag values is also written.
+func (f *LogFile) CompactTo(w io.Writer, m, k uint64, ngrams bool, cancel <-chan struct{}) (n int64, err error) {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 
@@ -826,6 +908,9 @@ func (f *LogFile) CompactTo(w io.Writer, m, k uint64, cancel <-chan struct{}) (n
 	var t IndexFileTrailer
 	info := newLogFileCompactInfo()
 	info.cancel = cancel
+	if ngrams {
+		info.ngrams = NewNGramBlockWriter()
+	}
 
 	// Write magic number.
 	if err := writeTo(bw, []byte(FileSignature), &n); err != nil {
@@ -894,6 +979,16 @@ func (f *LogFile) CompactTo(w io.Writer, m, k uint64, cancel <-chan struct{}) (n
 	t.TombstoneSeriesSketch.Size = int64(len(data))
 	n += t.TombstoneSeriesSketch.Size
 
+	// Write n-gram block.
+	if info.ngrams != nil {
+		t.NGramBlock.Offset = n
+		nn, err = info.ngrams.WriteTo(bw)
+		if n += nn; err != nil {
+			return n, err
+		}
+		t.NGramBlock.Size = n - t.NGramBlock.Offset
+	}
+
 	// Write trailer.
 	nn, err = t.WriteTo(bw)
 	n += nn
@@ -951,6 +1046,9 @@ func (f *LogFile) writeTagsetTo(w io.Writer, name string, info *logFileCompactIn
 		// Add each value.
 		for _, v := range values {
 			value := tag.tagValues[v]
+			if info.ngrams != nil {
+				info.ngrams.Add(tag.name, value.name, enc.N())
+			}
 			if err := enc.EncodeValue(value.name, value.deleted, value.seriesIDSet()); err != nil {
 				return err
 			}
@@ -981,6 +1079,13 @@ func (f *LogFile) writeTagsetTo(w io.Writer, name string, info *logFileCompactIn
 
 	info.mms[name] = &logFileMeasurementCompactInfo{offset: offset, size: size}
 
+	// Write n-gram sections of the tagset.
+	if info.ngrams != nil {
+		if err := info.ngrams.WriteTagSetTo(w, mm.name, n); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -1012,6 +1117,7 @@ func (f *LogFile) writeMeasurementBlockTo(w io.Writer, names []string, info *log
 type logFileCompactInfo struct {
 	cancel <-chan struct{}
 	mms    map[string]*logFileMeasurementCompactInfo
+	ngrams *NGramBlockWriter // nil if the n-gram block is not written
 }
 
 // newLogFileCompactInfo returns a new instance of logFileCompactInfo.
@@ -1367,6 +1473,9 @@ type logTagKey struct {
 	name      []byte
 	deleted   bool
 	tagValues map[string]logTagValue
+
+	// numeric holds the values which parse as numbers, sorted by number.
+	numeric numericTagValues
 }
 
 // bytes estimates the memory footprint of this logTagKey, in bytes.
@@ -1377,6 +1486,7 @@ func (tk *logTagKey) bytes() int {
 		b += len(k)
 		b += v.bytes()
 	}
+	b += cap(tk.numeric) * int(unsafe.Sizeof(numericTagValue{}))
 	b += int(unsafe.Sizeof(*tk))
 	return b
 }
@@ -1399,6 +1509,7 @@ func (tk *logTagKey) createTagValueIfNotExists(value []byte) logTagValue {
 	tv, ok := tk.tagValues[string(value)]
 	if !ok {
 		tv = logTagValue{name: value, series: make(map[uint64]struct{})}
+		tk.numeric = tk.numeric.insert(tv.name)
 	}
 	return tv
 }
@@ -143,6 +143,37 @@ func (blk *MeasurementBlock) Iterator() MeasurementIterator {
 	return &blockMeasurementIterator{data: blk.data[MeasurementFillSize:]}
 }
 
+// offsets returns the offsets of the measurements in the block data, in the
+// order of their names.  Measurements are written in sorted order, so the
+// offsets of the hash index are sorted.
+func (blk *MeasurementBlock) offsets() []int {
+	n := int(binary.BigEndian.Uint64(blk.hashData[:MeasurementNSize]))
+
+	offsets := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if offset := binary.BigEndian.Uint64(blk.hashData[MeasurementNSize+(i*MeasurementOffsetSize):]); offset != 0 {
+			offsets = append(offsets, int(offset))
+		}
+	}
+	sort.Ints(offsets)
+	return offsets
+}
+
+// seekIterator returns an iterator over the measurements from the first one
+// not before name.  offsets are the offsets returned by offsets.  Returns nil
+// if all measurements are before name.
+func (blk *MeasurementBlock) seekIterator(offsets []int, name []byte) MeasurementIterator {
+	var e MeasurementBlockElem
+	i := sort.Search(len(offsets), func(i int) bool {
+		e.UnmarshalBinary(blk.data[offsets[i]:])
+		return bytes.Compare(e.name, name) >= 0
+	})
+	if i == len(offsets) {
+		return nil
+	}
+	return &blockMeasurementIterator{data: blk.data[offsets[i]:]}
+}
+
 // SeriesIDIterator returns an iterator for all series ids in a measurement.
 func (blk *MeasurementBlock) SeriesIDIterator(name []byte) tsdb.SeriesIDIterator {
 	// Find measurement element.
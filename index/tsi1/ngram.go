@@ -0,0 +1,560 @@
+package tsi1
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"unsafe"
+)
+
+// NGramSize is the length, in bytes, of the n-grams indexed for tag values.
+const NGramSize = 3
+
+// NGram block field size constants.
+const (
+	// NGram block fields.
+	NGramBlockNSize = 8
+
+	// NGram section fields.
+	NGramNSize           = 8
+	NGramOffsetSize      = 8
+	NGramSectionElemSize = NGramSize + NGramOffsetSize
+)
+
+// NGram block errors.
+var (
+	ErrNGramBlockSizeMismatch = errors.New("ngram block size mismatch")
+)
+
+// maxNGramExactN is the maximum number of exact strings tracked for a regular
+// expression node before it is reduced to an n-gram query.
+const maxNGramExactN = 16
+
+// NGramBlock represents the directory of the optional n-gram posting index of
+// an index file. It holds the position of the n-gram section of every tag key.
+type NGramBlock struct {
+	sections map[string]map[string]ngramSectionPos // section positions by name & key
+}
+
+// ngramSectionPos stores the offset/size of an n-gram section in the index file.
+type ngramSectionPos struct {
+	offset int64
+	size   int64
+}
+
+// bytes estimates the memory footprint of this NGramBlock, in bytes.
+func (blk *NGramBlock) bytes() int {
+	b := int(unsafe.Sizeof(*blk))
+	for name, keys := range blk.sections {
+		b += int(unsafe.Sizeof(name)) + len(name)
+		for key, pos := range keys {
+			b += int(unsafe.Sizeof(key)) + len(key) + int(unsafe.Sizeof(pos))
+		}
+	}
+	return b
+}
+
+// UnmarshalBinary unpacks data into the block.
+func (blk *NGramBlock) UnmarshalBinary(data []byte) error {
+	if len(data) < NGramBlockNSize {
+		return ErrNGramBlockSizeMismatch
+	}
+	n, buf := binary.BigEndian.Uint64(data[:NGramBlockNSize]), data[NGramBlockNSize:]
+
+	blk.sections = make(map[string]map[string]ngramSectionPos)
+	for i := uint64(0); i < n; i++ {
+		var name, key []byte
+		var ok bool
+		if name, buf, ok = readNGramBytes(buf); !ok {
+			return ErrNGramBlockSizeMismatch
+		} else if key, buf, ok = readNGramBytes(buf); !ok {
+			return ErrNGramBlockSizeMismatch
+		} else if len(buf) < 16 {
+			return ErrNGramBlockSizeMismatch
+		}
+
+		var pos ngramSectionPos
+		pos.offset, buf = int64(binary.BigEndian.Uint64(buf[0:8])), buf[8:]
+		pos.size, buf = int64(binary.BigEndian.Uint64(buf[0:8])), buf[8:]
+
+		keys := blk.sections[string(name)]
+		if keys == nil {
+			keys = make(map[string]ngramSectionPos)
+			blk.sections[string(name)] = keys
+		}
+		keys[string(key)] = pos
+	}
+
+	if len(buf) != 0 {
+		return ErrNGramBlockSizeMismatch
+	}
+	return nil
+}
+
+// readNGramBytes reads a uvarint length-prefixed byte slice from buf.
+func readNGramBytes(buf []byte) (v, other []byte, ok bool) {
+	sz, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < sz {
+		return nil, buf, false
+	}
+	return buf[n : n+int(sz)], buf[n+int(sz):], true
+}
+
+// section returns the n-gram section of a tag key from the index file data.
+// Returns nil if the block has no valid section for the key.
+func (blk *NGramBlock) section(data, name, key []byte) ngramSection {
+	pos, ok := blk.sections[string(name)][string(key)]
+	if !ok || pos.size < NGramNSize || pos.offset+pos.size > int64(len(data)) {
+		return nil
+	}
+	return ngramSection(data[pos.offset:][:pos.size])
+}
+
+// ngramSection is the encoded n-gram index of a single tag key.
+type ngramSection []byte
+
+// postings returns the decoded posting list for gram.
+func (s ngramSection) postings(gram string) []uint64 {
+	n := int(binary.BigEndian.Uint64(s[:NGramNSize]))
+	elems := s[NGramNSize:]
+
+	i := sort.Search(n, func(i int) bool {
+		return string(elems[i*NGramSectionElemSize:][:NGramSize]) >= gram
+	})
+	if i >= n {
+		return nil
+	}
+	elem := elems[i*NGramSectionElemSize:][:NGramSectionElemSize]
+	if string(elem[:NGramSize]) != gram {
+		return nil
+	}
+
+	buf := s[binary.BigEndian.Uint64(elem[NGramSize:]):]
+	cnt, sz := binary.Uvarint(buf)
+	buf = buf[sz:]
+
+	a := make([]uint64, 0, cnt)
+	var prev uint64
+	for i := uint64(0); i < cnt; i++ {
+		delta, sz := binary.Uvarint(buf)
+		buf = buf[sz:]
+		prev += delta
+		a = append(a, prev)
+	}
+	return a
+}
+
+// eval returns the sorted offsets of the values matching q.
+func (s ngramSection) eval(q *ngramQuery) (offsets []uint64, all bool) {
+	if q == nil {
+		return nil, true
+	}
+
+	if q.or {
+		for _, sub := range q.subs {
+			a, all := s.eval(sub)
+			if all {
+				return nil, true
+			}
+			offsets = unionUint64s(offsets, a)
+		}
+		return offsets, false
+	}
+
+	all = true
+	for _, gram := range q.grams {
+		a := s.postings(gram)
+		if all {
+			offsets, all = a, false
+		} else {
+			offsets = intersectUint64s(offsets, a)
+		}
+		if len(offsets) == 0 {
+			return nil, false
+		}
+	}
+	for _, sub := range q.subs {
+		a, subAll := s.eval(sub)
+		if subAll {
+			continue
+		} else if all {
+			offsets, all = a, false
+		} else {
+			offsets = intersectUint64s(offsets, a)
+		}
+		if len(offsets) == 0 {
+			return nil, false
+		}
+	}
+	return offsets, all
+}
+
+// intersectUint64s returns the values in both sorted slices a and b.
+func intersectUint64s(a, b []uint64) []uint64 {
+	other := make([]uint64, 0, len(a))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if a[i] < b[j] {
+			i++
+		} else if a[i] > b[j] {
+			j++
+		} else {
+			other = append(other, a[i])
+			i, j = i+1, j+1
+		}
+	}
+	return other
+}
+
+// unionUint64s returns the values in either of the sorted slices a and b.
+func unionUint64s(a, b []uint64) []uint64 {
+	other := make([]uint64, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] < b[j] {
+			other, i = append(other, a[i]), i+1
+		} else if a[i] > b[j] {
+			other, j = append(other, b[j]), j+1
+		} else {
+			other, i, j = append(other, a[i]), i+1, j+1
+		}
+	}
+	other = append(other, a[i:]...)
+	return append(other, b[j:]...)
+}
+
+// ngramTagValueIterator iterates over the values of a tag block at a list of
+// value element offsets.
+type ngramTagValueIterator struct {
+	data    []byte
+	offsets []uint64
+	e       TagBlockValueElem
+}
+
+// Next returns the next element in the iterator.
+func (itr *ngramTagValueIterator) Next() TagValueElem {
+	if len(itr.offsets) == 0 {
+		return nil
+	}
+	itr.e.unmarshal(itr.data[itr.offsets[0]:])
+	itr.offsets = itr.offsets[1:]
+	return &itr.e
+}
+
+// NGramBlockWriter writes the n-gram sections and the n-gram block of an
+// index file. Values are added while their tag block is encoded, and the
+// sections are flushed right after each tag block with WriteTagSetTo.
+type NGramBlockWriter struct {
+	keys    map[string]map[string][]uint64 // postings by key & n-gram of the current tag block
+	entries []ngramBlockEntry              // sections written so far
+}
+
+// ngramBlockEntry is the directory entry of a written section.
+type ngramBlockEntry struct {
+	name, key []byte
+	pos       ngramSectionPos
+}
+
+// NewNGramBlockWriter returns a new NGramBlockWriter.
+func NewNGramBlockWriter() *NGramBlockWriter {
+	return &NGramBlockWriter{
+		keys: make(map[string]map[string][]uint64),
+	}
+}
+
+// Add indexes the n-grams of a tag value encoded at offset in the current tag block.
+func (bw *NGramBlockWriter) Add(key, value []byte, offset int64) {
+	if len(value) < NGramSize {
+		return
+	}
+
+	grams := bw.keys[string(key)]
+	if grams == nil {
+		grams = make(map[string][]uint64)
+		bw.keys[string(key)] = grams
+	}
+
+	for i := 0; i+NGramSize <= len(value); i++ {
+		gram := string(value[i : i+NGramSize])
+		a := grams[gram]
+
+		// Values repeating an n-gram are only posted once.
+		if len(a) > 0 && a[len(a)-1] == uint64(offset) {
+			continue
+		}
+		grams[gram] = append(a, uint64(offset))
+	}
+}
+
+// WriteTagSetTo writes a section for each key of the values added since the
+// last call to w, and saves their offsets for the block.
+func (bw *NGramBlockWriter) WriteTagSetTo(w io.Writer, name []byte, n *int64) error {
+	keys := make([]string, 0, len(bw.keys))
+	for k := range bw.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		entry := ngramBlockEntry{name: append([]byte(nil), name...), key: []byte(k)}
+		entry.pos.offset = *n
+		if err := writeNGramSectionTo(w, bw.keys[k], n); err != nil {
+			return err
+		}
+		entry.pos.size = *n - entry.pos.offset
+
+		bw.entries = append(bw.entries, entry)
+	}
+
+	bw.keys = make(map[string]map[string][]uint64)
+	return nil
+}
+
+// WriteTo writes the block to w.
+func (bw *NGramBlockWriter) WriteTo(w io.Writer) (n int64, err error) {
+	if err := writeUint64To(w, uint64(len(bw.entries)), &n); err != nil {
+		return n, err
+	}
+
+	for _, e := range bw.entries {
+		if err := writeUvarintTo(w, uint64(len(e.name)), &n); err != nil {
+			return n, err
+		} else if err := writeTo(w, e.name, &n); err != nil {
+			return n, err
+		} else if err := writeUvarintTo(w, uint64(len(e.key)), &n); err != nil {
+			return n, err
+		} else if err := writeTo(w, e.key, &n); err != nil {
+			return n, err
+		} else if err := writeUint64To(w, uint64(e.pos.offset), &n); err != nil {
+			return n, err
+		} else if err := writeUint64To(w, uint64(e.pos.size), &n); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// writeNGramSectionTo encodes the posting lists of a single tag key to w.
+func writeNGramSectionTo(w io.Writer, postings map[string][]uint64, n *int64) error {
+	grams := make([]string, 0, len(postings))
+	for gram := range postings {
+		grams = append(grams, gram)
+	}
+	sort.Strings(grams)
+
+	// Encode posting lists first so the elements can point at them.
+	var buf bytes.Buffer
+	var pn int64
+	offsets := make([]int64, len(grams))
+	for i, gram := range grams {
+		offsets[i] = pn
+
+		a := postings[gram]
+		if err := writeUvarintTo(&buf, uint64(len(a)), &pn); err != nil {
+			return err
+		}
+		var prev uint64
+		for _, v := range a {
+			if err := writeUvarintTo(&buf, v-prev, &pn); err != nil {
+				return err
+			}
+			prev = v
+		}
+	}
+
+	// Write elements, pointing past the element list.
+	base := int64(NGramNSize + len(grams)*NGramSectionElemSize)
+	if err := writeUint64To(w, uint64(len(grams)), n); err != nil {
+		return err
+	}
+	for i, gram := range grams {
+		if err := writeTo(w, []byte(gram), n); err != nil {
+			return err
+		} else if err := writeUint64To(w, uint64(base+offsets[i]), n); err != nil {
+			return err
+		}
+	}
+
+	nn, err := buf.WriteTo(w)
+	*n += nn
+	return err
+}
+
+// ngramQuery is a boolean query over the n-grams of a tag value. Every value
+// matched by the regular expression the query was built from satisfies the
+// query, but the opposite is not true. A nil query is satisfied by every value.
+type ngramQuery struct {
+	or    bool          // when true, any sub query must match; otherwise all grams & subs must.
+	grams []string      // required n-grams, unused by or queries
+	subs  []*ngramQuery // sub queries
+}
+
+// compileNGramQuery returns the n-gram query narrowing the candidate values of re.
+// Returns nil if re cannot be narrowed.
+func compileNGramQuery(re *regexp.Regexp) *ngramQuery {
+	sre, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	return analyzeNGrams(sre.Simplify()).query()
+}
+
+// ngramInfo is the result of the n-gram analysis of a regular expression node.
+type ngramInfo struct {
+	exact []string    // exact strings matched by the node, nil if unknown
+	match *ngramQuery // query satisfied by the node's matches if exact is nil
+}
+
+// query returns the n-gram query satisfied by all the matches of the node.
+func (info ngramInfo) query() *ngramQuery {
+	if info.exact == nil {
+		return info.match
+	}
+
+	var subs []*ngramQuery
+	for _, s := range info.exact {
+		if len(s) < NGramSize {
+			return nil
+		}
+		subs = append(subs, &ngramQuery{grams: ngramsOf(s)})
+	}
+	if len(subs) == 1 {
+		return subs[0]
+	}
+	return &ngramQuery{or: true, subs: subs}
+}
+
+// ngramsOf returns the distinct n-grams of s, in sorted order.
+func ngramsOf(s string) []string {
+	m := make(map[string]struct{})
+	for i := 0; i+NGramSize <= len(s); i++ {
+		m[s[i:i+NGramSize]] = struct{}{}
+	}
+
+	a := make([]string, 0, len(m))
+	for gram := range m {
+		a = append(a, gram)
+	}
+	sort.Strings(a)
+	return a
+}
+
+// analyzeNGrams returns the n-gram info for a simplified regular expression.
+func analyzeNGrams(re *syntax.Regexp) ngramInfo {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine,
+		syntax.OpBeginText, syntax.OpEndText,
+		syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return ngramInfo{exact: []string{""}}
+
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return ngramInfo{}
+		}
+		return ngramInfo{exact: []string{string(re.Rune)}}
+
+	case syntax.OpCharClass:
+		var exact []string
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				if exact = append(exact, string(r)); len(exact) > maxNGramExactN {
+					return ngramInfo{}
+				}
+			}
+		}
+		return ngramInfo{exact: exact}
+
+	case syntax.OpCapture:
+		return analyzeNGrams(re.Sub[0])
+
+	case syntax.OpQuest:
+		info := analyzeNGrams(re.Sub[0])
+		if info.exact == nil || len(info.exact) >= maxNGramExactN {
+			return ngramInfo{}
+		}
+		return ngramInfo{exact: append(info.exact, "")}
+
+	case syntax.OpPlus:
+		return ngramInfo{match: analyzeNGrams(re.Sub[0]).query()}
+
+	case syntax.OpRepeat:
+		if re.Min == 0 {
+			return ngramInfo{}
+		}
+		return ngramInfo{match: analyzeNGrams(re.Sub[0]).query()}
+
+	case syntax.OpConcat:
+		info := ngramInfo{exact: []string{""}}
+		for _, sub := range re.Sub {
+			info = concatNGramInfo(info, analyzeNGrams(sub))
+		}
+		return info
+
+	case syntax.OpAlternate:
+		infos := make([]ngramInfo, len(re.Sub))
+		exact := []string{}
+		for i, sub := range re.Sub {
+			infos[i] = analyzeNGrams(sub)
+			if exact == nil {
+				continue
+			} else if infos[i].exact == nil || len(exact)+len(infos[i].exact) > maxNGramExactN {
+				exact = nil
+				continue
+			}
+			exact = append(exact, infos[i].exact...)
+		}
+		if exact != nil {
+			return ngramInfo{exact: exact}
+		}
+
+		q := &ngramQuery{or: true}
+		for _, info := range infos {
+			sub := info.query()
+			if sub == nil {
+				return ngramInfo{}
+			}
+			q.subs = append(q.subs, sub)
+		}
+		return ngramInfo{match: q}
+
+	default:
+		return ngramInfo{}
+	}
+}
+
+// concatNGramInfo returns the n-gram info of x followed by y.
+func concatNGramInfo(x, y ngramInfo) ngramInfo {
+	if x.exact != nil && y.exact != nil && len(x.exact)*len(y.exact) <= maxNGramExactN {
+		exact := make([]string, 0, len(x.exact)*len(y.exact))
+		for _, a := range x.exact {
+			for _, b := range y.exact {
+				exact = append(exact, a+b)
+			}
+		}
+		return ngramInfo{exact: exact}
+	}
+	return ngramInfo{match: andNGramQueries(x.query(), y.query())}
+}
+
+// andNGramQueries returns a query satisfied by values satisfying both a and b.
+func andNGramQueries(a, b *ngramQuery) *ngramQuery {
+	if a == nil {
+		return b
+	} else if b == nil {
+		return a
+	}
+
+	q := &ngramQuery{}
+	for _, sub := range []*ngramQuery{a, b} {
+		if sub.or {
+			q.subs = append(q.subs, sub)
+			continue
+		}
+		q.grams = append(q.grams, sub.grams...)
+		q.subs = append(q.subs, sub.subs...)
+	}
+	return q
+}
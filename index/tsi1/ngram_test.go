@@ -0,0 +1,131 @@
+package tsi1_test
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/influxdata/influxdb/v2/models"
+	"github.com/influxdata/influxdb/v2/tsdb/index/tsi1"
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure regex tag value lookups are narrowed by the n-gram block of compacted files.
+func TestIndexFile_MatchTagValueIterator(t *testing.T) {
+	sfile := MustOpenSeriesFile(t)
+	defer sfile.Close()
+
+	hosts := []string{"ab", "api-prod-web", "db-01-prod", "web-01-prod", "web-02-prod", "web-03-dev"}
+	series := make([]Series, 0, len(hosts))
+	for _, host := range hosts {
+		series = append(series, Series{Name: []byte("cpu"), Tags: models.NewTags(map[string]string{"host": host})})
+	}
+	lf, err := CreateLogFile(sfile.SeriesFile, series)
+	require.NoError(t, err)
+	defer lf.Close()
+
+	open := func(t *testing.T, data []byte) *tsi1.IndexFile {
+		f := tsi1.NewIndexFile(sfile.SeriesFile)
+		require.NoError(t, f.UnmarshalBinary(data))
+		return f
+	}
+
+	var plain, ngrams bytes.Buffer
+	_, err = lf.CompactTo(&plain, M, K, false, nil)
+	require.NoError(t, err)
+	_, err = lf.CompactTo(&ngrams, M, K, true, nil)
+	require.NoError(t, err)
+
+	// Files are only written with the n-gram format when it is enabled.
+	tr, err := tsi1.ReadIndexFileTrailer(plain.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, tsi1.IndexFileVersion, tr.Version)
+	tr, err = tsi1.ReadIndexFileTrailer(ngrams.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, tsi1.IndexFileNGramVersion, tr.Version)
+	require.NotZero(t, tr.NGramBlock.Size)
+
+	// Compacting index files keeps the n-gram block.
+	var compacted bytes.Buffer
+	_, err = tsi1.IndexFiles{open(t, plain.Bytes()), open(t, ngrams.Bytes())}.CompactTo(&compacted, sfile.SeriesFile, M, K, true, nil)
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		expr       string
+		candidates []string // values returned by n-gram indexed files
+	}{
+		{expr: `web-.*-prod`, candidates: []string{"web-01-prod", "web-02-prod"}},
+		{expr: `^web-0[12]`, candidates: []string{"web-01-prod", "web-02-prod"}},
+		{expr: `prod`, candidates: []string{"api-prod-web", "db-01-prod", "web-01-prod", "web-02-prod"}},
+		{expr: `dev|db-`, candidates: []string{"db-01-prod", "web-03-dev"}},
+		{expr: `(web)+-03`, candidates: []string{"web-03-dev"}},
+		{expr: `xyz`, candidates: nil},
+
+		// Expressions which cannot be narrowed return all values.
+		{expr: `ab|web`, candidates: hosts},
+		{expr: `(?i)WEB`, candidates: hosts},
+		{expr: `.*`, candidates: hosts},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			re := regexp.MustCompile(tt.expr)
+			for _, f := range []struct {
+				data       []byte
+				candidates []string
+			}{
+				{data: plain.Bytes(), candidates: hosts},
+				{data: ngrams.Bytes(), candidates: tt.candidates},
+				{data: compacted.Bytes(), candidates: tt.candidates},
+			} {
+				fs := tsi1.NewFileSet([]tsi1.File{open(t, f.data)})
+				itr := fs.MatchTagValueIterator([]byte("cpu"), []byte("host"), re)
+
+				var candidates []string
+				if itr != nil {
+					for e := itr.Next(); e != nil; e = itr.Next() {
+						candidates = append(candidates, string(e.Value()))
+					}
+				}
+				require.Equal(t, f.candidates, candidates)
+
+				// Every matching value must be a candidate.
+				for _, host := range hosts {
+					if re.MatchString(host) {
+						require.Contains(t, candidates, host)
+					}
+				}
+			}
+		})
+	}
+}
+
+// Ensure tombstoned values are returned so they shadow values of older files.
+func TestIndexFile_MatchTagValueIterator_Tombstone(t *testing.T) {
+	sfile := MustOpenSeriesFile(t)
+	defer sfile.Close()
+
+	lf, err := CreateLogFile(sfile.SeriesFile, []Series{
+		{Name: []byte("cpu"), Tags: models.NewTags(map[string]string{"host": "web-01-prod"})},
+		{Name: []byte("cpu"), Tags: models.NewTags(map[string]string{"host": "web-02-prod"})},
+	})
+	require.NoError(t, err)
+	defer lf.Close()
+	require.NoError(t, lf.DeleteTagValue([]byte("cpu"), []byte("host"), []byte("web-02-prod")))
+
+	var buf bytes.Buffer
+	_, err = lf.CompactTo(&buf, M, K, true, nil)
+	require.NoError(t, err)
+
+	f := tsi1.NewIndexFile(sfile.SeriesFile)
+	require.NoError(t, f.UnmarshalBinary(buf.Bytes()))
+
+	itr := tsi1.NewFileSet([]tsi1.File{f}).MatchTagValueIterator([]byte("cpu"), []byte("host"), regexp.MustCompile(`web-.*-prod`))
+	require.NotNil(t, itr)
+
+	var deleted []string
+	for e := itr.Next(); e != nil; e = itr.Next() {
+		if e.Deleted() {
+			deleted = append(deleted, string(e.Value()))
+		}
+	}
+	require.Equal(t, []string{"web-02-prod"}, deleted)
+}
@@ -0,0 +1,211 @@
+package tsi1
+
+import (
+	"bytes"
+	"container/list"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"unsafe"
+
+	"github.com/influxdata/influxql"
+)
+
+// numericTagValue is a tag value of a numeric tag key and the number it parses to.
+type numericTagValue struct {
+	n       float64
+	value   []byte
+	deleted bool
+}
+
+// Value returns the tag value.
+func (e *numericTagValue) Value() []byte { return e.value }
+
+// Deleted returns true if the tag value has been tombstoned.
+func (e *numericTagValue) Deleted() bool { return e.deleted }
+
+// numericTagValues is a list of the values of a tag key sorted by number.
+type numericTagValues []numericTagValue
+
+// newNumericTagValues returns the values of itr which parse as numbers, sorted by number.
+// Other values are ignored, as they never compare to a number.
+func newNumericTagValues(itr TagValueIterator) numericTagValues {
+	var a numericTagValues
+	if itr == nil {
+		return a
+	}
+
+	for e := itr.Next(); e != nil; e = itr.Next() {
+		n, ok := parseNumericTagValue(e.Value())
+		if !ok {
+			continue
+		}
+		a = append(a, numericTagValue{n: n, value: e.Value(), deleted: e.Deleted()})
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].n < a[j].n })
+	return a
+}
+
+// parseNumericTagValue returns the number value parses to, or false if it is
+// not a number.
+func parseNumericTagValue(value []byte) (float64, bool) {
+	n, err := strconv.ParseFloat(string(value), 64)
+	return n, err == nil && !math.IsNaN(n)
+}
+
+// insert adds value to the list, keeping it sorted by number.  The list is
+// returned unchanged if value is not a number.
+func (a numericTagValues) insert(value []byte) numericTagValues {
+	n, ok := parseNumericTagValue(value)
+	if !ok {
+		return a
+	}
+
+	i := sort.Search(len(a), func(i int) bool { return a[i].n > n })
+	a = append(a, numericTagValue{})
+	copy(a[i+1:], a[i:])
+	a[i] = numericTagValue{n: n, value: value}
+	return a
+}
+
+// setDeleted marks value as tombstoned in the list.
+func (a numericTagValues) setDeleted(value []byte) {
+	n, ok := parseNumericTagValue(value)
+	if !ok {
+		return
+	}
+
+	for i := sort.Search(len(a), func(i int) bool { return a[i].n >= n }); i < len(a) && a[i].n == n; i++ {
+		if bytes.Equal(a[i].value, value) {
+			a[i].deleted = true
+			return
+		}
+	}
+}
+
+// bytes estimates the memory footprint of the list, in bytes.
+func (a numericTagValues) bytes() int {
+	// Do not count the values, they reference the file data.
+	return int(unsafe.Sizeof(a)) + cap(a)*int(unsafe.Sizeof(numericTagValue{}))
+}
+
+// iterator returns an iterator over the values comparing to v with op, in the
+// lexicographic order of the values so it can be merged with other iterators.
+// Returns nil if no value compares or op is not a comparison operator.
+func (a numericTagValues) iterator(op influxql.Token, v float64) TagValueIterator {
+	lower := sort.Search(len(a), func(i int) bool { return a[i].n >= v })
+	upper := sort.Search(len(a), func(i int) bool { return a[i].n > v })
+
+	var other numericTagValues
+	switch op {
+	case influxql.EQ:
+		other = append(other, a[lower:upper]...)
+	case influxql.NEQ:
+		other = append(append(other, a[:lower]...), a[upper:]...)
+	case influxql.LT:
+		other = append(other, a[:lower]...)
+	case influxql.LTE:
+		other = append(other, a[:upper]...)
+	case influxql.GT:
+		other = append(other, a[upper:]...)
+	case influxql.GTE:
+		other = append(other, a[lower:]...)
+	}
+	if len(other) == 0 {
+		return nil
+	}
+
+	sort.Slice(other, func(i, j int) bool { return bytes.Compare(other[i].value, other[j].value) == -1 })
+	return &numericTagValueIterator{a: other}
+}
+
+// numericTagValueIterator represents an iterator over a list of numeric tag values.
+type numericTagValueIterator struct {
+	a numericTagValues
+}
+
+// Next returns the next element in the iterator.
+func (itr *numericTagValueIterator) Next() TagValueElem {
+	if len(itr.a) == 0 {
+		return nil
+	}
+	e := &itr.a[0]
+	itr.a = itr.a[1:]
+	return e
+}
+
+// maxNumericTagValuesCacheSize is the maximum number of bytes used by the
+// numeric tag value lists cached by an index file.
+const maxNumericTagValuesCacheSize = 4 << 20
+
+// numericTagValuesCache holds the numeric tag value lists of the keys of an
+// index file. The least recently used lists are evicted once the lists use
+// more than maxSize bytes, and a list larger than maxSize is never cached.
+type numericTagValuesCache struct {
+	mu      sync.Mutex
+	maxSize int
+	size    int
+	lru     *list.List // of *numericTagValuesCacheEntry, most recently used first
+	entries map[numericTagValuesCacheKey]*list.Element
+}
+
+type numericTagValuesCacheKey struct {
+	name, key string
+}
+
+type numericTagValuesCacheEntry struct {
+	k numericTagValuesCacheKey
+	a numericTagValues
+}
+
+// get returns the list of the values of key in measurement name, building it
+// with fn if it is not cached.
+func (c *numericTagValuesCache) get(name, key []byte, fn func() numericTagValues) numericTagValues {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := numericTagValuesCacheKey{name: string(name), key: string(key)}
+	if elem, ok := c.entries[k]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*numericTagValuesCacheEntry).a
+	}
+
+	a := fn()
+	size := a.bytes()
+	if size > c.maxSize {
+		return a
+	}
+
+	if c.entries == nil {
+		c.entries = make(map[numericTagValuesCacheKey]*list.Element)
+		c.lru = list.New()
+	}
+	for c.size+size > c.maxSize {
+		e := c.lru.Remove(c.lru.Back()).(*numericTagValuesCacheEntry)
+		delete(c.entries, e.k)
+		c.size -= e.a.bytes()
+	}
+	c.entries[k] = c.lru.PushFront(&numericTagValuesCacheEntry{k: k, a: a})
+	c.size += size
+	return a
+}
+
+// bytes estimates the memory footprint of the cached lists, in bytes.
+func (c *numericTagValuesCache) bytes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b := c.size
+	for k := range c.entries {
+		b += int(unsafe.Sizeof(k)) + len(k.name) + len(k.key)
+	}
+	return b
+}
+
+// reset removes all lists from the cache.
+func (c *numericTagValuesCache) reset() {
+	c.mu.Lock()
+	c.entries, c.lru, c.size = nil, nil, 0
+	c.mu.Unlock()
+}
@@ -0,0 +1,59 @@
+package tsi1
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure the numeric tag value cache evicts the least recently used lists
+// and does not cache lists larger than its limit.
+func TestNumericTagValuesCache(t *testing.T) {
+	values := func(n int) numericTagValues {
+		a := make(numericTagValues, n)
+		for i := range a {
+			a[i] = numericTagValue{n: float64(i), value: []byte(strconv.Itoa(i))}
+		}
+		return a
+	}
+
+	var builds int
+	get := func(c *numericTagValuesCache, key string, n int) numericTagValues {
+		return c.get([]byte("cpu"), []byte(key), func() numericTagValues {
+			builds++
+			return values(n)
+		})
+	}
+
+	c := &numericTagValuesCache{maxSize: 2*values(10).bytes() + values(10).bytes()/2}
+
+	require.Len(t, get(c, "a", 10), 10)
+	require.Len(t, get(c, "b", 10), 10)
+	require.Equal(t, 2, builds)
+
+	// Cached lists are not rebuilt, and a is now the most recently used.
+	get(c, "a", 10)
+	require.Equal(t, 2, builds)
+
+	// Caching c evicts b.
+	get(c, "c", 10)
+	require.Equal(t, 3, builds)
+	get(c, "a", 10)
+	get(c, "c", 10)
+	require.Equal(t, 3, builds)
+	get(c, "b", 10)
+	require.Equal(t, 4, builds)
+	require.LessOrEqual(t, c.size, c.maxSize)
+
+	// Lists larger than the limit are returned but never cached.
+	require.Len(t, get(c, "large", 100), 100)
+	require.Len(t, get(c, "large", 100), 100)
+	require.Equal(t, 6, builds)
+	require.LessOrEqual(t, c.size, c.maxSize)
+
+	c.reset()
+	require.Zero(t, c.bytes())
+	get(c, "a", 10)
+	require.Equal(t, 7, builds)
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -74,8 +75,13 @@ type Partition struct {
 	MaxLogFileSize int64
 	MaxLogFileAge  time.Duration
 	nosync         bool // when true, flushing and syncing of LogFile will be disabled.
+	readOnly       bool // when true, no file is created, written or removed.
+	ngramIndex     bool // when true, compactions write an n-gram block to index files.
 	logbufferSize  int  // the LogFile's buffer is set to this value.
 
+	// Tag keys whose values are compared as numbers.
+	numericTagKeys map[string]struct{}
+
 	// Frequency of compaction checks.
 	compactionInterrupt chan struct{}
 	compactionsDisabled int
@@ -100,6 +106,7 @@ func NewPartition(sfile *tsdb.SeriesFile, path string) *Partition {
 		seriesIDSet:    tsdb.NewSeriesIDSet(),
 		fileSet:        &FileSet{},
 		MaxLogFileSize: tsdb.DefaultMaxIndexLogFileSize,
+		numericTagKeys: make(map[string]struct{}),
 
 		// compactionEnabled: true,
 		compactionInterrupt: make(chan struct{}),
@@ -117,7 +124,10 @@ func (p *Partition) bytes() int {
 	b += 24 // mu RWMutex is 24 bytes
 	b += int(unsafe.Sizeof(p.opened))
 	// Do not count SeriesFile because it belongs to the code that constructed this Partition.
-	b += int(unsafe.Sizeof(p.activeLogFile)) + p.activeLogFile.bytes()
+	b += int(unsafe.Sizeof(p.activeLogFile))
+	if p.activeLogFile != nil {
+		b += p.activeLogFile.bytes()
+	}
 	b += int(unsafe.Sizeof(p.fileSet)) + p.fileSet.bytes()
 	b += int(unsafe.Sizeof(p.seq))
 	b += int(unsafe.Sizeof(p.seriesIDSet)) + p.seriesIDSet.Bytes()
@@ -168,7 +178,11 @@ func (p *Partition) Open() (rErr error) {
 	}
 
 	// Create directory if it doesn't exist.
-	if err := os.MkdirAll(p.path, 0777); err != nil {
+	if p.readOnly {
+		if _, err := os.Stat(p.path); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(p.path, 0777); err != nil {
 		return err
 	}
 
@@ -192,6 +206,11 @@ func (p *Partition) Open() (rErr error) {
 	p.levels = make([]CompactionLevel, len(m.Levels))
 	copy(p.levels, m.Levels)
 
+	// Numeric tag keys recorded in the manifest remain numeric.
+	for _, key := range m.NumericTagKeys {
+		p.numericTagKeys[key] = struct{}{}
+	}
+
 	// Set up flags to track whether a level is compacting.
 	p.levelCompacting = make([]bool, len(p.levels))
 
@@ -231,16 +250,27 @@ func (p *Partition) Open() (rErr error) {
 	// Set initial sequence number.
 	p.seq = p.fileSet.MaxID()
 
-	// Delete any files not in the manifest.
-	if err := p.deleteNonManifestFiles(m); err != nil {
-		return err
-	}
-
-	// Ensure a log file exists.
-	if p.activeLogFile == nil {
-		if err := p.prependActiveLogFile(); err != nil {
+	// Files are left as they are in read-only mode, and no log file is
+	// created for writes.
+	if !p.readOnly {
+		// Delete any files not in the manifest.
+		if err := p.deleteNonManifestFiles(m); err != nil {
 			return err
 		}
+
+		// Ensure a log file exists.
+		if p.activeLogFile == nil {
+			if err := p.prependActiveLogFile(); err != nil {
+				return err
+			}
+		}
+
+		// Record newly declared numeric tag keys.
+		if len(p.numericTagKeys) != len(m.NumericTagKeys) {
+			if p.manifestSize, err = p.Manifest().Write(); err != nil {
+				return err
+			}
+		}
 	}
 
 	// Build series existence set.
@@ -252,7 +282,9 @@ func (p *Partition) Open() (rErr error) {
 	p.opened = true
 
 	// Send a compaction request on start up.
-	go p.runPeriodicCompaction()
+	if !p.readOnly {
+		go p.runPeriodicCompaction()
+	}
 
 	return nil
 }
@@ -266,6 +298,7 @@ func (p *Partition) openLogFile(path string) (*LogFile, error) {
 	f := NewLogFile(p.sfile, path)
 	f.nosync = p.nosync
 	f.bufferSize = p.logbufferSize
+	f.readOnly = p.readOnly
 
 	if err := f.Open(); err != nil {
 		return nil, err
@@ -444,6 +477,11 @@ func (p *Partition) manifest(newFileSet *FileSet) *Manifest {
 		m.Files[j] = filepath.Base(f.Path())
 	}
 
+	for key := range p.numericTagKeys {
+		m.NumericTagKeys = append(m.NumericTagKeys, key)
+	}
+	sort.Strings(m.NumericTagKeys)
+
 	return m
 }
 
@@ -585,6 +623,21 @@ func (p *Partition) MeasurementIterator() (tsdb.MeasurementIterator, error) {
 	return newFileSetMeasurementIterator(fs, NewTSDBMeasurementIteratorAdapter(itr)), nil
 }
 
+// MeasurementPrefixIterator returns an iterator over the measurements which
+// start with prefix.
+func (p *Partition) MeasurementPrefixIterator(prefix []byte) (tsdb.MeasurementIterator, error) {
+	fs, err := p.RetainFileSet()
+	if err != nil {
+		return nil, err
+	}
+	itr := fs.MeasurementPrefixIterator(prefix)
+	if itr == nil {
+		fs.Release()
+		return nil, nil
+	}
+	return newFileSetMeasurementIterator(fs, NewTSDBMeasurementIteratorAdapter(itr)), nil
+}
+
 // MeasurementExists returns true if a measurement exists.
 func (p *Partition) MeasurementExists(name []byte) (bool, error) {
 	fs, err := p.RetainFileSet()
@@ -629,6 +682,10 @@ func (p *Partition) MeasurementSeriesIDIterator(name []byte) (tsdb.SeriesIDItera
 // DropMeasurement deletes a measurement from the index. DropMeasurement does
 // not remove any series from the index directly.
 func (p *Partition) DropMeasurement(name []byte) error {
+	if p.readOnly {
+		return tsdb.ReadOnlyError{Op: "drop measurement", Path: p.path}
+	}
+
 	fs, err := p.RetainFileSet()
 	if err != nil {
 		return err
@@ -714,6 +771,8 @@ func (p *Partition) createSeriesListIfNotExists(names [][]byte, tagsSlice []mode
 		return nil, nil
 	} else if len(names) != len(tagsSlice) {
 		return nil, fmt.Errorf("uneven batch, partition %s sent %d names and %d tags", p.id, len(names), len(tagsSlice))
+	} else if p.readOnly {
+		return nil, tsdb.ReadOnlyError{Op: "create series", Path: p.path}
 	}
 
 	// Maintain reference count on files in file set.
@@ -740,6 +799,10 @@ func (p *Partition) createSeriesListIfNotExists(names [][]byte, tagsSlice []mode
 }
 
 func (p *Partition) DropSeries(seriesID uint64) error {
+	if p.readOnly {
+		return tsdb.ReadOnlyError{Op: "drop series", Path: p.path}
+	}
+
 	// Delete series from index.
 	if err := func() error {
 		p.Mu.RLock()
@@ -827,6 +890,62 @@ func (p *Partition) TagValueIterator(name, key []byte) tsdb.TagValueIterator {
 	return newFileSetTagValueIterator(fs, NewTSDBTagValueIteratorAdapter(itr))
 }
 
+// TagValuePrefixIterator returns an iterator over the values of a single key
+// which start with prefix.
+func (p *Partition) TagValuePrefixIterator(name, key, prefix []byte) (tsdb.TagValueIterator, error) {
+	fs, err := p.RetainFileSet()
+	if err != nil {
+		return nil, err
+	}
+
+	itr := fs.TagValuePrefixIterator(name, key, prefix)
+	if itr == nil {
+		fs.Release()
+		return nil, nil
+	}
+	return newFileSetTagValueIterator(fs, NewTSDBTagValueIteratorAdapter(itr)), nil
+}
+
+// MatchTagValueIterator returns an iterator over the values of a single key
+// which may match value. Callers must still match each returned value.
+func (p *Partition) MatchTagValueIterator(name, key []byte, value *regexp.Regexp) (tsdb.TagValueIterator, error) {
+	fs, err := p.RetainFileSet()
+	if err != nil {
+		return nil, err
+	}
+
+	itr := fs.MatchTagValueIterator(name, key, value)
+	if itr == nil {
+		fs.Release()
+		return nil, nil
+	}
+	return newFileSetTagValueIterator(fs, NewTSDBTagValueIteratorAdapter(itr)), nil
+}
+
+// IsNumericTagKey returns true if the values of key are compared as numbers.
+func (p *Partition) IsNumericTagKey(key []byte) bool {
+	p.Mu.RLock()
+	defer p.Mu.RUnlock()
+	_, ok := p.numericTagKeys[string(key)]
+	return ok
+}
+
+// NumericTagValueIterator returns an iterator over the values of a numeric tag
+// key which compare to v with op.
+func (p *Partition) NumericTagValueIterator(name, key []byte, op influxql.Token, v float64) (tsdb.TagValueIterator, error) {
+	fs, err := p.RetainFileSet()
+	if err != nil {
+		return nil, err
+	}
+
+	itr := fs.NumericTagValueIterator(name, key, op, v)
+	if itr == nil {
+		fs.Release()
+		return nil, nil
+	}
+	return newFileSetTagValueIterator(fs, NewTSDBTagValueIteratorAdapter(itr)), nil
+}
+
 // TagKeySeriesIDIterator returns a series iterator for all values across a single key.
 func (p *Partition) TagKeySeriesIDIterator(name, key []byte) (tsdb.SeriesIDIterator, error) {
 	fs, err := p.RetainFileSet()
@@ -935,14 +1054,14 @@ func (p *Partition) EnableCompactions() {
 	defer p.Mu.Unlock()
 
 	// Already enabled?
-	if p.compactionsEnabled() {
+	if p.compactionsDisabled == 0 {
 		return
 	}
 	p.compactionsDisabled--
 }
 
 func (p *Partition) compactionsEnabled() bool {
-	return p.compactionsDisabled == 0
+	return p.compactionsDisabled == 0 && !p.readOnly
 }
 
 func (p *Partition) runPeriodicCompaction() {
@@ -1126,7 +1245,7 @@ func (p *Partition) compactToLevel(files []*IndexFile, level int, interrupt <-ch
 
 	// Compact all index files to new index file.
 	lvl := p.levels[level]
-	n, err := IndexFiles(files).CompactTo(f, p.sfile, lvl.M, lvl.K, interrupt)
+	n, err := IndexFiles(files).CompactTo(f, p.sfile, lvl.M, lvl.K, p.ngramIndex, interrupt)
 	if err != nil {
 		log.Error("Cannot compact index files", zap.Error(err))
 		return
@@ -1205,11 +1324,175 @@ func (p *Partition) compactToLevel(files []*IndexFile, level int, interrupt <-ch
 	}
 }
 
-func (p *Partition) Rebuild() {}
+// partitionRebuild writes a fresh index for a partition next to its live files.
+type partitionRebuild struct {
+	p *Partition
+
+	// Series of the rebuilt index. The log file is not in the manifest so it
+	// is removed when the partition is opened if the rebuild does not complete.
+	logFile     *LogFile
+	seriesIDSet *tsdb.SeriesIDSet
+
+	// Files replaced by the rebuilt index.
+	files []File
+}
+
+// beginRebuild starts a rebuild of the partition. Writes are kept in a new
+// active log file, which is not replaced, and compactions are disabled until
+// the rebuild is committed or aborted.
+func (p *Partition) beginRebuild() (_ *partitionRebuild, rErr error) {
+	if p.readOnly {
+		return nil, tsdb.ReadOnlyError{Op: "rebuild", Path: p.path}
+	}
+
+	// Running compactions would replace the files being rebuilt.
+	p.DisableCompactions()
+	p.Wait()
+	defer func() {
+		if rErr != nil {
+			p.EnableCompactions()
+		}
+	}()
+
+	p.Mu.Lock()
+	defer p.Mu.Unlock()
+
+	if p.isClosing() {
+		return nil, tsdb.ErrIndexClosing
+	}
+
+	logFile, err := p.openLogFile(filepath.Join(p.path, FormatLogFileName(p.nextSequence())))
+	if err != nil {
+		return nil, err
+	}
+	logFile.nosync = true
+
+	if err := p.prependActiveLogFile(); err != nil {
+		removeRebuildLogFile(logFile)
+		return nil, err
+	}
+
+	files := make([]File, len(p.fileSet.files)-1)
+	copy(files, p.fileSet.files[1:])
+	return &partitionRebuild{
+		p:           p,
+		logFile:     logFile,
+		seriesIDSet: tsdb.NewSeriesIDSet(),
+		files:       files,
+	}, nil
+}
+
+// addSeries adds series to the rebuilt index, creating them in the series file
+// if they do not exist.
+func (r *partitionRebuild) addSeries(names [][]byte, tagsSlice []models.Tags) error {
+	if len(names) == 0 {
+		return nil
+	}
+	_, err := r.logFile.AddSeriesList(r.seriesIDSet, names, tagsSlice)
+	return err
+}
+
+// abort discards the rebuilt index and enables compactions.
+func (r *partitionRebuild) abort() {
+	removeRebuildLogFile(r.logFile)
+	r.p.EnableCompactions()
+}
+
+// commit writes the rebuilt index file, replaces the files of the partition
+// with it and removes them.
+func (r *partitionRebuild) commit(ctx context.Context) error {
+	p := r.p
+	defer func() {
+		p.EnableCompactions()
+		p.Compact()
+	}()
+	defer removeRebuildLogFile(r.logFile)
+
+	// The rebuilt file holds every series, so it is written to the last level.
+	level := len(p.levels) - 1
+	path := filepath.Join(p.path, FormatIndexFileName(r.logFile.ID(), level))
+	if err := func() (rErr error) {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer errors2.Capture(&rErr, f.Close)()
+
+		lvl := p.levels[level]
+		if _, err := r.logFile.CompactTo(f, lvl.M, lvl.K, p.ngramIndex, ctx.Done()); err != nil {
+			return err
+		}
+		return f.Sync()
+	}(); err != nil {
+		_ = os.Remove(path)
+		return fmt.Errorf("cannot write rebuilt index file %q: %w", path, err)
+	}
+
+	file := NewIndexFile(p.sfile)
+	file.SetPath(path)
+	if err := file.Open(); err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+
+	// Obtain lock to swap in index file and write manifest.
+	var swapped bool
+	if err := func() error {
+		p.Mu.Lock()
+		defer p.Mu.Unlock()
+
+		if p.isClosing() {
+			return tsdb.ErrIndexClosing
+		}
+
+		// Replace previous files with the rebuilt index file.
+		newFileSet := p.fileSet.MustReplace(r.files, file)
+
+		// Write new manifest.
+		manifestSize, err := p.manifest(newFileSet).Write()
+		if err != nil {
+			return fmt.Errorf("manifest file write failed rebuilding index %q: %w", p.ManifestPath(), err)
+		}
+		p.manifestSize = manifestSize
+		p.fileSet = newFileSet
+		swapped = true
+		return p.buildSeriesSet()
+	}(); err != nil {
+		if !swapped {
+			file.Close()
+			_ = os.Remove(path)
+		}
+		return err
+	}
+
+	p.logger.Info("Rebuilt index partition",
+		zap.String("path", path),
+		zap.Uint64("series", r.seriesIDSet.Cardinality()),
+		zap.Int("replaced_files", len(r.files)))
+
+	// Close and delete the replaced files.
+	for _, f := range r.files {
+		if err := f.Close(); err != nil {
+			return err
+		} else if err := os.Remove(f.Path()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeRebuildLogFile closes and removes the log file of a rebuild.
+func removeRebuildLogFile(f *LogFile) {
+	_ = f.Close()
+	_ = os.Remove(f.Path())
+}
 
 // needsLogCompaction returns true if the log file is too big or too old
 // The caller must have at least a read lock on the partition
 func (p *Partition) needsLogCompaction() bool {
+	if p.activeLogFile == nil {
+		return false // read-only
+	}
 	size := p.activeLogFile.Size()
 	modTime := p.activeLogFile.ModTime()
 	return size >= p.MaxLogFileSize || (size > 0 && modTime.Before(time.Now().Add(-p.MaxLogFileAge)))
@@ -1283,7 +1566,7 @@ func (p *Partition) compactLogFile(logFile *LogFile) {
 
 	// Compact log file to new index file.
 	lvl := p.levels[1]
-	n, err := logFile.CompactTo(f, lvl.M, lvl.K, interrupt)
+	n, err := logFile.CompactTo(f, lvl.M, lvl.K, p.ngramIndex, interrupt)
 	if err != nil {
 		log.Error("Cannot compact log file", zap.Error(err), zap.String("path", logFile.Path()))
 		return
@@ -1404,6 +1687,9 @@ type Manifest struct {
 	Levels []CompactionLevel `json:"levels,omitempty"`
 	Files  []string          `json:"files,omitempty"`
 
+	// NumericTagKeys lists the tag keys whose values are compared as numbers.
+	NumericTagKeys []string `json:"numericTagKeys,omitempty"`
+
 	// Version should be updated whenever the TSI format has changed.
 	Version int `json:"version,omitempty"`
 
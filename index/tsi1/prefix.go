@@ -0,0 +1,73 @@
+package tsi1
+
+import (
+	"bytes"
+)
+
+// prefixMeasurementIterator returns the measurements of a sorted iterator which
+// start with a prefix. Measurements before the prefix are skipped and iteration
+// stops at the first measurement past it.
+type prefixMeasurementIterator struct {
+	itr    MeasurementIterator
+	prefix []byte
+}
+
+// newPrefixMeasurementIterator returns an iterator over the measurements of itr
+// which start with prefix.
+func newPrefixMeasurementIterator(itr MeasurementIterator, prefix []byte) MeasurementIterator {
+	if itr == nil || len(prefix) == 0 {
+		return itr
+	}
+	return &prefixMeasurementIterator{itr: itr, prefix: prefix}
+}
+
+// Next returns the next element in the iterator.
+func (itr *prefixMeasurementIterator) Next() MeasurementElem {
+	if itr.itr == nil {
+		return nil
+	}
+
+	for e := itr.itr.Next(); e != nil; e = itr.itr.Next() {
+		if bytes.HasPrefix(e.Name(), itr.prefix) {
+			return e
+		} else if bytes.Compare(e.Name(), itr.prefix) == 1 {
+			break
+		}
+	}
+	itr.itr = nil
+	return nil
+}
+
+// prefixTagValueIterator returns the values of a sorted iterator which start
+// with a prefix. Values before the prefix are skipped and iteration stops at
+// the first value past it.
+type prefixTagValueIterator struct {
+	itr    TagValueIterator
+	prefix []byte
+}
+
+// newPrefixTagValueIterator returns an iterator over the values of itr which
+// start with prefix.
+func newPrefixTagValueIterator(itr TagValueIterator, prefix []byte) TagValueIterator {
+	if itr == nil || len(prefix) == 0 {
+		return itr
+	}
+	return &prefixTagValueIterator{itr: itr, prefix: prefix}
+}
+
+// Next returns the next element in the iterator.
+func (itr *prefixTagValueIterator) Next() TagValueElem {
+	if itr.itr == nil {
+		return nil
+	}
+
+	for e := itr.itr.Next(); e != nil; e = itr.itr.Next() {
+		if bytes.HasPrefix(e.Value(), itr.prefix) {
+			return e
+		} else if bytes.Compare(e.Value(), itr.prefix) == 1 {
+			break
+		}
+	}
+	itr.itr = nil
+	return nil
+}
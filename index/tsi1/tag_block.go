@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 
 	"github.com/influxdata/influxdb/v2/pkg/rhh"
 	"github.com/influxdata/influxdb/v2/tsdb"
@@ -277,6 +278,38 @@ func (e *TagBlockKeyElem) TagValueIterator() TagValueIterator {
 	return &tagBlockValueIterator{data: e.data.buf}
 }
 
+// tagValueOffsets returns the offsets of the values of the key in its value
+// data, in the order of the values.  Values are written in sorted order, so the
+// offsets of the hash index are sorted.
+func (e *TagBlockKeyElem) tagValueOffsets() []int {
+	hashData := e.hashIndex.buf
+	n := int(binary.BigEndian.Uint64(hashData[:TagValueNSize]))
+
+	offsets := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if offset := binary.BigEndian.Uint64(hashData[TagValueNSize+(i*TagValueOffsetSize):]); offset != 0 {
+			offsets = append(offsets, int(offset-e.data.offset))
+		}
+	}
+	sort.Ints(offsets)
+	return offsets
+}
+
+// seekTagValueIterator returns an iterator over the values of the key from the
+// first value not before value.  offsets are the offsets returned by
+// tagValueOffsets.  Returns nil if all values are before value.
+func (e *TagBlockKeyElem) seekTagValueIterator(offsets []int, value []byte) TagValueIterator {
+	var elem TagBlockValueElem
+	i := sort.Search(len(offsets), func(i int) bool {
+		elem.unmarshal(e.data.buf[offsets[i]:])
+		return bytes.Compare(elem.value, value) >= 0
+	})
+	if i == len(offsets) {
+		return nil
+	}
+	return &tagBlockValueIterator{data: e.data.buf[offsets[i]:]}
+}
+
 // unmarshal unmarshals buf into e.
 // The data argument represents the entire block data.
 func (e *TagBlockKeyElem) unmarshal(buf, data []byte) {
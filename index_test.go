@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"sync"
 	"testing"
 
@@ -226,6 +227,51 @@ func TestIndexSet_MeasurementNamesByPredicate(t *testing.T) {
 	}
 }
 
+func TestIndexSet_MeasurementSeriesKeysByExpr_NumericTagKeys(t *testing.T) {
+	idx := MustOpenNewIndex(t, tsi1.IndexName, func(opts *tsdb.EngineOptions) {
+		opts.Config.NumericTagKeys = []string{"version"}
+	})
+	defer idx.Close()
+
+	for _, version := range []string{"2", "12", "12.0", "13", "100", "abc"} {
+		if err := idx.AddSeries("cpu", map[string]string{"version": version}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idx.AddSeries("cpu", map[string]string{"region": "east"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, example := range []struct {
+		expr     string
+		expected []string
+	}{
+		{expr: `version > 12`, expected: []string{"cpu,version=100", "cpu,version=13"}},
+		{expr: `12 < version`, expected: []string{"cpu,version=100", "cpu,version=13"}},
+		{expr: `version >= 12 AND version <= 13`, expected: []string{"cpu,version=12", "cpu,version=12.0", "cpu,version=13"}},
+		{expr: `version < 12.5`, expected: []string{"cpu,version=12", "cpu,version=12.0", "cpu,version=2"}},
+		{expr: `version = 12`, expected: []string{"cpu,version=12", "cpu,version=12.0"}},
+		{expr: `version != 12`, expected: []string{"cpu,region=east", "cpu,version=100", "cpu,version=13", "cpu,version=2", "cpu,version=abc"}},
+		{expr: `version > 1000`, expected: nil},
+	} {
+		t.Run(example.expr, func(t *testing.T) {
+			keys, err := idx.IndexSet().MeasurementSeriesKeysByExpr([]byte("cpu"), influxql.MustParseExpr(example.expr))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, key := range keys {
+				got = append(got, string(key))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, example.expected) {
+				t.Fatalf("got keys: %v, expected %v", got, example.expected)
+			}
+		})
+	}
+}
+
 func TestIndex_Sketches(t *testing.T) {
 	checkCardinalities := func(t *testing.T, index *Index, state string, series, tseries, measurements, tmeasurements int) {
 		t.Helper()
@@ -0,0 +1,45 @@
+package tsdb
+
+import "errors"
+
+var (
+	// ErrSeriesNotIndexed is returned for a series with data which is not in the index.
+	ErrSeriesNotIndexed = errors.New("series is not in the index")
+
+	// ErrSeriesNotInSeriesFile is returned for a series which is not in the series file.
+	ErrSeriesNotInSeriesFile = errors.New("series is not in the series file")
+
+	// ErrSeriesHasNoData is returned for a series in the index without data.
+	ErrSeriesHasNoData = errors.New("series has no data")
+)
+
+// SeriesInconsistency describes a series whose index entry does not match the
+// data of the shard.
+type SeriesInconsistency struct {
+	// ID is the series ID, or zero if the series is not in the series file.
+	ID uint64
+
+	// Key is the series key, or nil if the series is not in the series file.
+	Key []byte
+
+	Err error
+}
+
+// IndexVerifyReport is the result of verifying the index of a shard against
+// its TSM files, its cache and the series file.
+type IndexVerifyReport struct {
+	// SeriesN is the number of series in the index.
+	SeriesN int
+
+	// Missing are the series with data which are not in the index.
+	Missing []SeriesInconsistency
+
+	// Orphaned are the series in the index which have no data or are not in
+	// the series file.
+	Orphaned []SeriesInconsistency
+}
+
+// Consistent returns true if the index matches the data of the shard.
+func (r *IndexVerifyReport) Consistent() bool {
+	return len(r.Missing) == 0 && len(r.Orphaned) == 0
+}
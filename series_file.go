@@ -38,6 +38,7 @@ type SeriesFile struct {
 	partitions []*SeriesPartition
 
 	maxSnapshotConcurrency int
+	readOnly               bool
 
 	refs sync.RWMutex // RWMutex to track references to the SeriesFile that are in use.
 
@@ -69,6 +70,12 @@ func (f *SeriesFile) WithMaxCompactionConcurrency(maxCompactionConcurrency int)
 	f.maxSnapshotConcurrency = maxCompactionConcurrency
 }
 
+// WithReadOnly sets whether the series file is opened without creating or
+// writing any file.  It must be called before Open.
+func (f *SeriesFile) WithReadOnly(readOnly bool) {
+	f.readOnly = readOnly
+}
+
 // Open memory maps the data file at the file's path.
 func (f *SeriesFile) Open() error {
 	// Wait for all references to be released and prevent new ones from being acquired.
@@ -76,7 +83,11 @@ func (f *SeriesFile) Open() error {
 	defer f.refs.Unlock()
 
 	// Create path if it doesn't exist.
-	if err := os.MkdirAll(filepath.Join(f.path), 0777); err != nil {
+	if f.readOnly {
+		if _, err := os.Stat(f.path); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(filepath.Join(f.path), 0777); err != nil {
 		return err
 	}
 
@@ -88,6 +99,7 @@ func (f *SeriesFile) Open() error {
 	for i := 0; i < SeriesFilePartitionN; i++ {
 		p := NewSeriesPartition(i, f.SeriesPartitionPath(i), compactionLimiter)
 		p.Logger = f.Logger.With(zap.Int("partition", p.ID()))
+		p.ReadOnly = f.readOnly
 		if err := p.Open(); err != nil {
 			f.Logger.Error("Unable to open series file",
 				zap.String("path", f.path),
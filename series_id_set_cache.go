@@ -0,0 +1,325 @@
+package tsdb
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SeriesIDSetCacheBudget bounds the memory used by the series ID set caches of
+// all indexes in a store.  Sets are evicted least recently used first across
+// all caches.  Once the budget is full, a set is only admitted if it is
+// estimated to be accessed more often than every set it would evict, so that
+// a burst of one-off lookups does not flush frequently used sets.  Access
+// frequencies are estimated with a TinyLFU sketch.
+//
+// The methods of a nil SeriesIDSetCacheBudget are no-ops.
+type SeriesIDSetCacheBudget struct {
+	mu      sync.Mutex
+	maxSize uint64
+	size    uint64
+	lru     *list.List // of *SeriesIDSetCacheEntry, most recently used first
+	sketch  *frequencySketch
+}
+
+// SeriesIDSetCacheEntry is a cached series ID set accounted for by a
+// SeriesIDSetCacheBudget.
+type SeriesIDSetCacheEntry struct {
+	// Value is set by the cache holding the set, to remove it when the entry
+	// is evicted.
+	Value interface{}
+
+	hash uint64
+	size uint64
+	elem *list.Element // nil if the entry is not accounted for.
+}
+
+// NewSeriesIDSetCacheEntry returns an entry of size bytes for the set whose
+// key hashes to hash.
+func NewSeriesIDSetCacheEntry(hash, size uint64, value interface{}) *SeriesIDSetCacheEntry {
+	return &SeriesIDSetCacheEntry{Value: value, hash: hash, size: size}
+}
+
+// NewSeriesIDSetCacheBudget returns a budget of maxSize bytes.
+func NewSeriesIDSetCacheBudget(maxSize uint64) *SeriesIDSetCacheBudget {
+	return &SeriesIDSetCacheBudget{
+		maxSize: maxSize,
+		lru:     list.New(),
+		sketch:  newFrequencySketch(seriesIDSetCacheSketchWidth),
+	}
+}
+
+// Touch records an access to the set whose key hashes to hash.  e is the
+// cached entry of the set, or nil if the set is not cached.
+func (b *SeriesIDSetCacheBudget) Touch(hash uint64, e *SeriesIDSetCacheEntry) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.sketch.increment(hash)
+	if e != nil && e.elem != nil {
+		b.lru.MoveToFront(e.elem)
+	}
+	b.mu.Unlock()
+}
+
+// Admit accounts for e, evicting the least recently used entries to make room
+// for it.  It returns false, and evicts nothing, if e is larger than the budget
+// or is not accessed more often than the entries it would evict.  The caches
+// holding the evicted entries must remove them.
+func (b *SeriesIDSetCacheBudget) Admit(e *SeriesIDSetCacheEntry) ([]*SeriesIDSetCacheEntry, bool) {
+	if b == nil {
+		return nil, true
+	} else if e.size > b.maxSize {
+		return nil, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Find the entries to evict, and check they are all less frequently used.
+	var victims []*SeriesIDSetCacheEntry
+	if b.size+e.size > b.maxSize {
+		freq := b.sketch.estimate(e.hash)
+		var freed uint64
+		for elem := b.lru.Back(); b.size+e.size-freed > b.maxSize; elem = elem.Prev() {
+			victim := elem.Value.(*SeriesIDSetCacheEntry)
+			if b.sketch.estimate(victim.hash) >= freq {
+				return nil, false
+			}
+			victims = append(victims, victim)
+			freed += victim.size
+		}
+	}
+
+	for _, victim := range victims {
+		b.remove(victim)
+	}
+	e.elem = b.lru.PushFront(e)
+	b.size += e.size
+	globalSeriesIDSetCacheMetrics.size.Add(float64(e.size))
+	return victims, true
+}
+
+// Resize changes the accounted size of e to size bytes, after its set has
+// grown or shrunk.  If the budget is then full, the least recently used
+// entries are evicted regardless of how often they are used, and possibly e
+// itself.  The caches holding the evicted entries must remove them.
+func (b *SeriesIDSetCacheBudget) Resize(e *SeriesIDSetCacheEntry, size uint64) []*SeriesIDSetCacheEntry {
+	if b == nil || e == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if e.elem == nil {
+		e.size = size
+		return nil
+	}
+	b.size += size - e.size
+	globalSeriesIDSetCacheMetrics.size.Add(float64(size) - float64(e.size))
+	e.size = size
+
+	var victims []*SeriesIDSetCacheEntry
+	for b.size > b.maxSize {
+		victim := b.lru.Back().Value.(*SeriesIDSetCacheEntry)
+		b.remove(victim)
+		victims = append(victims, victim)
+	}
+	return victims
+}
+
+// Release stops accounting for e.  It is a no-op if e has been evicted.
+func (b *SeriesIDSetCacheBudget) Release(e *SeriesIDSetCacheEntry) {
+	if b == nil || e == nil {
+		return
+	}
+
+	b.mu.Lock()
+	if e.elem != nil {
+		b.remove(e)
+	}
+	b.mu.Unlock()
+}
+
+// remove stops accounting for e.  b.mu must be held.
+func (b *SeriesIDSetCacheBudget) remove(e *SeriesIDSetCacheEntry) {
+	b.lru.Remove(e.elem)
+	e.elem = nil
+	b.size -= e.size
+	globalSeriesIDSetCacheMetrics.size.Sub(float64(e.size))
+}
+
+// Size returns the number of bytes used by the cached sets.
+func (b *SeriesIDSetCacheBudget) Size() uint64 {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.size
+}
+
+const (
+	// seriesIDSetCacheSketchWidth is the number of counters in each row of the
+	// frequency sketch.  It must be a power of two.
+	seriesIDSetCacheSketchWidth = 1 << 14
+
+	// frequencySketchDepth is the number of rows of a frequency sketch.
+	frequencySketchDepth = 4
+
+	// frequencySketchMaxCount is the count at which counters saturate.
+	frequencySketchMaxCount = 15
+)
+
+// frequencySketch is a count-min sketch estimating how often keys are
+// accessed.  All counters are halved after a number of accesses proportional
+// to the width of the sketch, so that the estimates favour recent accesses.
+type frequencySketch struct {
+	rows      [frequencySketchDepth][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+func newFrequencySketch(width int) *frequencySketch {
+	s := &frequencySketch{mask: uint64(width - 1), resetAt: 10 * width}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// index returns the counter of hash in row i.
+func (s *frequencySketch) index(hash uint64, i int) uint64 {
+	h1, h2 := hash&0xFFFFFFFF, hash>>32|1
+	return (h1 + uint64(i)*h2) & s.mask
+}
+
+// increment records an access to hash.
+func (s *frequencySketch) increment(hash uint64) {
+	for i := range s.rows {
+		if j := s.index(hash, i); s.rows[i][j] < frequencySketchMaxCount {
+			s.rows[i][j]++
+		}
+	}
+
+	if s.additions++; s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+// estimate returns the estimated number of accesses to hash.
+func (s *frequencySketch) estimate(hash uint64) uint8 {
+	est := uint8(frequencySketchMaxCount)
+	for i := range s.rows {
+		if n := s.rows[i][s.index(hash, i)]; n < est {
+			est = n
+		}
+	}
+	return est
+}
+
+// reset halves all counters.
+func (s *frequencySketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+// SeriesIDSetCacheStatistics are the statistics of the series ID set cache of
+// an index.
+type SeriesIDSetCacheStatistics struct {
+	Hits, Misses, Evictions uint64
+	Sets                    int
+	Size                    uint64
+}
+
+var globalSeriesIDSetCacheMetrics = newSeriesIDSetCacheMetrics()
+
+const seriesIDSetCacheSubsystem = "series_id_set_cache"
+
+// SeriesIDSetCacheMetrics are the prometheus metrics of the series ID set
+// cache of the index of a shard.
+type SeriesIDSetCacheMetrics struct {
+	Hits      prometheus.Counter
+	Misses    prometheus.Counter
+	Evictions prometheus.Counter
+
+	labels prometheus.Labels
+}
+
+type seriesIDSetCacheMetrics struct {
+	hits      *prometheus.CounterVec
+	misses    *prometheus.CounterVec
+	evictions *prometheus.CounterVec
+	size      prometheus.Gauge
+}
+
+func newSeriesIDSetCacheMetrics() *seriesIDSetCacheMetrics {
+	labelNames := []string{"database", "id"}
+	return &seriesIDSetCacheMetrics{
+		hits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: storageNamespace,
+			Subsystem: seriesIDSetCacheSubsystem,
+			Name:      "hits_total",
+			Help:      "Counter of series ID sets found in the series ID set cache of a shard index",
+		}, labelNames),
+		misses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: storageNamespace,
+			Subsystem: seriesIDSetCacheSubsystem,
+			Name:      "misses_total",
+			Help:      "Counter of series ID sets not found in the series ID set cache of a shard index",
+		}, labelNames),
+		evictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: storageNamespace,
+			Subsystem: seriesIDSetCacheSubsystem,
+			Name:      "evictions_total",
+			Help:      "Counter of series ID sets evicted from the series ID set cache of a shard index",
+		}, labelNames),
+		size: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: storageNamespace,
+			Subsystem: seriesIDSetCacheSubsystem,
+			Name:      "inuse_bytes",
+			Help:      "Gauge of the memory used by the series ID sets cached within the memory budget",
+		}),
+	}
+}
+
+// NewSeriesIDSetCacheMetrics returns the metrics of the series ID set cache of
+// the index of shard shardID of database.
+func NewSeriesIDSetCacheMetrics(database string, shardID uint64) *SeriesIDSetCacheMetrics {
+	labels := prometheus.Labels{"database": database, "id": strconv.FormatUint(shardID, 10)}
+	return &SeriesIDSetCacheMetrics{
+		Hits:      globalSeriesIDSetCacheMetrics.hits.With(labels),
+		Misses:    globalSeriesIDSetCacheMetrics.misses.With(labels),
+		Evictions: globalSeriesIDSetCacheMetrics.evictions.With(labels),
+		labels:    labels,
+	}
+}
+
+// Delete stops exporting the metrics, once the index of the shard is closed.
+func (m *SeriesIDSetCacheMetrics) Delete() {
+	globalSeriesIDSetCacheMetrics.hits.Delete(m.labels)
+	globalSeriesIDSetCacheMetrics.misses.Delete(m.labels)
+	globalSeriesIDSetCacheMetrics.evictions.Delete(m.labels)
+}
+
+// SeriesIDSetCacheCollectors returns the prometheus metrics of the series ID
+// set caches.
+func SeriesIDSetCacheCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		globalSeriesIDSetCacheMetrics.hits,
+		globalSeriesIDSetCacheMetrics.misses,
+		globalSeriesIDSetCacheMetrics.evictions,
+		globalSeriesIDSetCacheMetrics.size,
+	}
+}
@@ -0,0 +1,49 @@
+package tsdb_test
+
+import (
+	"testing"
+
+	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesIDSetCacheBudget_Admit(t *testing.T) {
+	b := tsdb.NewSeriesIDSetCacheBudget(100)
+	e0 := tsdb.NewSeriesIDSetCacheEntry(1, 40, "e0")
+	e1 := tsdb.NewSeriesIDSetCacheEntry(2, 40, "e1")
+	e2 := tsdb.NewSeriesIDSetCacheEntry(3, 40, "e2")
+
+	for _, e := range []*tsdb.SeriesIDSetCacheEntry{e0, e1} {
+		victims, ok := b.Admit(e)
+		require.True(t, ok)
+		require.Empty(t, victims)
+	}
+	require.Equal(t, uint64(80), b.Size())
+
+	// Reading e0 makes e1 the least recently used entry. e2 is not admitted
+	// as it has not been accessed more often than e1.
+	b.Touch(1, e0)
+	victims, ok := b.Admit(e2)
+	require.False(t, ok)
+	require.Empty(t, victims)
+	require.Equal(t, uint64(80), b.Size())
+
+	// Once accessed more often, e2 evicts e1.
+	b.Touch(3, nil)
+	victims, ok = b.Admit(e2)
+	require.True(t, ok)
+	require.Equal(t, []*tsdb.SeriesIDSetCacheEntry{e1}, victims)
+	require.Equal(t, uint64(80), b.Size())
+
+	// Releasing an evicted entry is a no-op.
+	b.Release(e1)
+	require.Equal(t, uint64(80), b.Size())
+	b.Release(e0)
+	require.Equal(t, uint64(40), b.Size())
+
+	// Entries larger than the budget are never admitted.
+	b.Touch(4, nil)
+	_, ok = b.Admit(tsdb.NewSeriesIDSetCacheEntry(4, 101, "e3"))
+	require.False(t, ok)
+	require.Equal(t, uint64(40), b.Size())
+}
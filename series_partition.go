@@ -46,6 +46,10 @@ type SeriesPartition struct {
 
 	CompactThreshold int
 
+	// ReadOnly opens the partition without creating or writing any file.
+	// Creating or deleting series returns a ReadOnlyError.
+	ReadOnly bool
+
 	Logger *zap.Logger
 }
 
@@ -69,7 +73,11 @@ func (p *SeriesPartition) Open() error {
 	}
 
 	// Create path if it doesn't exist.
-	if err := os.MkdirAll(filepath.Join(p.path), 0777); err != nil {
+	if p.ReadOnly {
+		if _, err := os.Stat(p.path); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(filepath.Join(p.path), 0777); err != nil {
 		return err
 	}
 
@@ -80,8 +88,10 @@ func (p *SeriesPartition) Open() error {
 		}
 
 		// Init last segment for writes.
-		if err := p.activeSegment().InitForWrite(); err != nil {
-			return err
+		if !p.ReadOnly {
+			if err := p.activeSegment().InitForWrite(); err != nil {
+				return err
+			}
 		}
 
 		p.index = NewSeriesIndex(p.IndexPath())
@@ -129,7 +139,7 @@ func (p *SeriesPartition) openSegments() error {
 	}
 
 	// Create initial segment if none exist.
-	if len(p.segments) == 0 {
+	if len(p.segments) == 0 && !p.ReadOnly {
 		segment, err := CreateSeriesSegment(0, filepath.Join(p.path, "0000"))
 		if err != nil {
 			return err
@@ -219,6 +229,8 @@ func (p *SeriesPartition) CreateSeriesListIfNotExists(keys [][]byte, keyPartitio
 	// Exit if all series for this partition already exist.
 	if !writeRequired {
 		return nil
+	} else if p.ReadOnly {
+		return ReadOnlyError{Op: "create series", Path: p.path}
 	}
 
 	type keyRange struct {
@@ -325,6 +337,8 @@ func (p *SeriesPartition) DeleteSeriesID(id uint64) error {
 	// Already tombstoned, ignore.
 	if p.index.IsDeleted(id) {
 		return nil
+	} else if p.ReadOnly {
+		return ReadOnlyError{Op: "delete series", Path: p.path}
 	}
 
 	// Write tombstone entry.
@@ -0,0 +1,247 @@
+package tsdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb/v2/models"
+	"github.com/influxdata/influxdb/v2/pkg/file"
+	"github.com/influxdata/influxql"
+)
+
+// SeriesRetentionFileName is the name of the file the series retention rules
+// of a retention policy are persisted to, next to its shard directories.
+const SeriesRetentionFileName = "retention.json"
+
+// SeriesRetentionRule expires the values of the series it matches once they are
+// older than Duration.  A rule with an empty Measurement matches every measurement,
+// and a rule with an empty Predicate matches every series of its measurements.
+type SeriesRetentionRule struct {
+	// Measurement is the name of the measurement the rule applies to.
+	Measurement string `json:"measurement,omitempty"`
+
+	// Predicate is an InfluxQL expression on the tags of a series, such as
+	// "level = 'debug'".
+	Predicate string `json:"predicate,omitempty"`
+
+	// Duration is how long the values of the matching series are kept.
+	Duration time.Duration `json:"duration"`
+}
+
+// Validate returns an error if the rule is invalid.
+func (r SeriesRetentionRule) Validate() error {
+	_, err := r.compile()
+	return err
+}
+
+// compile parses the predicate of the rule.
+func (r SeriesRetentionRule) compile() (seriesRetentionRule, error) {
+	if r.Duration <= 0 {
+		return seriesRetentionRule{}, errors.New("series retention: duration must be positive")
+	}
+
+	rule := seriesRetentionRule{measurement: r.Measurement, duration: int64(r.Duration)}
+	if r.Predicate != "" {
+		expr, err := influxql.ParseExpr(r.Predicate)
+		if err != nil {
+			return seriesRetentionRule{}, fmt.Errorf("series retention: invalid predicate %q: %w", r.Predicate, err)
+		}
+		rule.predicate = expr
+	}
+	return rule, nil
+}
+
+// seriesRetentionRule is a SeriesRetentionRule with its predicate parsed.
+type seriesRetentionRule struct {
+	measurement string
+	predicate   influxql.Expr
+	duration    int64
+}
+
+// matches returns true if the rule applies to the series with the given name and tags.
+func (r *seriesRetentionRule) matches(name []byte, tags map[string]interface{}) bool {
+	if r.measurement != "" && r.measurement != string(name) {
+		return false
+	}
+	return r.predicate == nil || influxql.EvalBool(r.predicate, tags)
+}
+
+// SeriesRetention is the set of series retention rules of a retention policy.
+// The rules apply to every shard of the retention policy, including shards
+// created after the rules are set.  It is safe for concurrent use.
+type SeriesRetention struct {
+	mu    sync.RWMutex
+	rules []SeriesRetentionRule
+	exprs []seriesRetentionRule
+
+	// path is the location the rules are persisted to.
+	path string
+
+	// readOnly is set if the rules belong to a read-only store.
+	readOnly bool
+}
+
+// NewSeriesRetention returns the series retention rules persisted at path.  There
+// are no rules if the file does not exist.
+func NewSeriesRetention(path string) (*SeriesRetention, error) {
+	r := &SeriesRetention{path: path}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	} else if err != nil {
+		return r, err
+	}
+
+	var rules []SeriesRetentionRule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return r, fmt.Errorf("series retention: cannot parse %s: %w", path, err)
+	}
+
+	exprs, err := compileSeriesRetentionRules(rules)
+	if err != nil {
+		return r, err
+	}
+	r.rules, r.exprs = rules, exprs
+	return r, nil
+}
+
+// compileSeriesRetentionRules parses the predicates of rules.
+func compileSeriesRetentionRules(rules []SeriesRetentionRule) ([]seriesRetentionRule, error) {
+	exprs := make([]seriesRetentionRule, 0, len(rules))
+	for _, rule := range rules {
+		expr, err := rule.compile()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	return exprs, nil
+}
+
+// Rules returns a copy of the rules.
+func (r *SeriesRetention) Rules() []SeriesRetentionRule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]SeriesRetentionRule(nil), r.rules...)
+}
+
+// Len returns the number of rules.
+func (r *SeriesRetention) Len() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.rules)
+}
+
+// SetRules replaces the rules and persists them.  No rules are changed if any
+// of them is invalid, if the rules belong to a read-only store, or if their
+// retention policy was deleted.
+func (r *SeriesRetention) SetRules(rules []SeriesRetentionRule) error {
+	if r.readOnly {
+		return ReadOnlyError{Op: "set series retention rules", Path: r.path}
+	}
+
+	exprs, err := compileSeriesRetentionRules(rules)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.writeFileNoLock(rules); err != nil {
+		return err
+	}
+	r.rules = append([]SeriesRetentionRule(nil), rules...)
+	r.exprs = exprs
+	return nil
+}
+
+// writeFileNoLock atomically writes rules to the file of the set, removing it
+// if there are none.  The directory of the file is not created, so that rules
+// are not persisted for a retention policy that was deleted.
+func (r *SeriesRetention) writeFileNoLock(rules []SeriesRetentionRule) error {
+	if len(rules) == 0 {
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	b, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(r.path)
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0666); err != nil {
+		return fmt.Errorf("failed writing %s: %w", tmp, err)
+	}
+	if err := file.RenameFile(tmp, r.path); err != nil {
+		return fmt.Errorf("cannot rename %s to %s: %w", tmp, r.path, err)
+	}
+	if err := file.SyncDir(dir); err != nil {
+		return fmt.Errorf("cannot sync directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// MaxCutoff returns the latest time before which the values of any series are
+// expired at now, or math.MinInt64 if there are no rules.
+func (r *SeriesRetention) MaxCutoff(now int64) int64 {
+	cutoff := int64(math.MinInt64)
+	if r == nil {
+		return cutoff
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := range r.exprs {
+		if now-r.exprs[i].duration > cutoff {
+			cutoff = now - r.exprs[i].duration
+		}
+	}
+	return cutoff
+}
+
+// Cutoff returns the time before which the values of the series with the given
+// key are expired at now, or math.MinInt64 if no rule matches the series.  If
+// several rules match, the one keeping values for the shortest time applies.
+func (r *SeriesRetention) Cutoff(seriesKey []byte, now int64) int64 {
+	cutoff := int64(math.MinInt64)
+	if r == nil {
+		return cutoff
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.exprs) == 0 {
+		return cutoff
+	}
+
+	name, tags := models.ParseKeyBytes(seriesKey)
+	values := make(map[string]interface{}, len(tags))
+	for _, t := range tags {
+		values[string(t.Key)] = string(t.Value)
+	}
+
+	for i := range r.exprs {
+		rule := &r.exprs[i]
+		if rule.matches(name, values) && now-rule.duration > cutoff {
+			cutoff = now - rule.duration
+		}
+	}
+	return cutoff
+}
@@ -0,0 +1,72 @@
+package tsdb_test
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/influxdata/influxdb/v2/tsdb"
+)
+
+func TestSeriesRetention_Cutoff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), tsdb.SeriesRetentionFileName)
+	r, err := tsdb.NewSeriesRetention(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := []tsdb.SeriesRetentionRule{
+		{Measurement: "debug", Duration: 72 * time.Hour},
+		{Predicate: "level = 'trace'", Duration: time.Hour},
+		{Measurement: "cpu", Predicate: "host = 'a' OR host = 'b'", Duration: 24 * time.Hour},
+	}
+	if err := r.SetRules(rules); err != nil {
+		t.Fatal(err)
+	}
+
+	now := int64(1000 * time.Hour)
+	for _, tt := range []struct {
+		key string
+		exp int64
+	}{
+		{key: "debug,host=a", exp: now - int64(72*time.Hour)},
+		{key: "debug,level=trace", exp: now - int64(time.Hour)},
+		{key: "cpu,host=b", exp: now - int64(24*time.Hour)},
+		{key: "cpu,host=c", exp: math.MinInt64},
+		{key: "mem,level=info", exp: math.MinInt64},
+	} {
+		if got := r.Cutoff([]byte(tt.key), now); got != tt.exp {
+			t.Errorf("%s: unexpected cutoff: got %d, exp %d", tt.key, got, tt.exp)
+		}
+	}
+
+	// The rules are reloaded from disk.
+	r, err = tsdb.NewSeriesRetention(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Rules(); !reflect.DeepEqual(got, rules) {
+		t.Fatalf("unexpected rules:\n\tgot: %v\n\texp: %v", got, rules)
+	}
+}
+
+func TestSeriesRetention_SetRules_Invalid(t *testing.T) {
+	r, err := tsdb.NewSeriesRetention(filepath.Join(t.TempDir(), tsdb.SeriesRetentionFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rule := range []tsdb.SeriesRetentionRule{
+		{Measurement: "cpu"},
+		{Measurement: "cpu", Predicate: "host = ", Duration: time.Hour},
+	} {
+		if err := r.SetRules([]tsdb.SeriesRetentionRule{rule}); err == nil {
+			t.Errorf("expected error setting rule %+v", rule)
+		}
+	}
+	if n := r.Len(); n != 0 {
+		t.Fatalf("unexpected number of rules: got %d, exp 0", n)
+	}
+}
@@ -65,6 +65,14 @@ var (
 	// attempted on a hot shard.
 	ErrShardNotIdle = errors.New("shard not idle")
 
+	// ErrCompactionNotFound is returned when canceling a compaction that is
+	// not running.
+	ErrCompactionNotFound = errors.New("compaction not found")
+
+	// ErrReadOnly is returned when a change is attempted on a store, shard or
+	// engine opened in read-only mode.
+	ErrReadOnly = errors.New("read-only")
+
 	// fieldsIndexMagicNumber is the file magic number for the fields index file.
 	fieldsIndexMagicNumber = []byte{0, 6, 1, 3}
 )
@@ -108,6 +116,25 @@ func (e PartialWriteError) Error() string {
 	return fmt.Sprintf("partial write: %s dropped=%d", e.Reason, e.Dropped)
 }
 
+// ReadOnlyError is returned when an operation would change the files of a store,
+// shard or engine opened in read-only mode.  It wraps ErrReadOnly.
+type ReadOnlyError struct {
+	// Op is the rejected operation.
+	Op string
+
+	// Path is the path of the store, shard or file the operation would change.
+	Path string
+}
+
+func (e ReadOnlyError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Op, e.Path, ErrReadOnly)
+}
+
+// Unwrap returns ErrReadOnly.
+func (e ReadOnlyError) Unwrap() error {
+	return ErrReadOnly
+}
+
 // Shard represents a self-contained time series database. An inverted index of
 // the measurement and tag data is kept along with the raw time series data.
 // Data can be split across many shards. The query engine in TSDB is responsible
@@ -135,6 +162,9 @@ type Shard struct {
 
 	metricUpdater *ticker
 
+	// tagValueNs counts the values of tag keys to enforce max-values-per-tag.
+	tagValueNs tagValueCounts
+
 	EnableOnOpen bool
 
 	// CompactionDisabled specifies the shard should not schedule compactions.
@@ -521,6 +551,7 @@ func (s *Shard) closeNoLock() error {
 	if e := s.index.Close(); e == nil {
 		s.index = nil
 	}
+	s.tagValueNs.reset()
 	return err
 }
 
@@ -613,6 +644,49 @@ func (s *Shard) Free() error {
 	return engine.Free()
 }
 
+// Compactions returns the status of the running compactions of the shard,
+// followed by the compactions planned but not started.
+func (s *Shard) Compactions() ([]CompactionStatus, error) {
+	engine, err := s.Engine()
+	if err != nil {
+		return nil, err
+	}
+	return engine.Compactions(), nil
+}
+
+// CancelCompaction aborts the running compaction of the shard with the given
+// ID.  It returns ErrCompactionNotFound if there is no such compaction.
+func (s *Shard) CancelCompaction(id uint64) error {
+	engine, err := s.Engine()
+	if err != nil {
+		return err
+	}
+	if !engine.CancelCompaction(id) {
+		return ErrCompactionNotFound
+	}
+	return nil
+}
+
+// VerifyIndex cross-checks the series in the index of the shard against the
+// series with data in the shard and the series file.
+func (s *Shard) VerifyIndex(ctx context.Context) (*IndexVerifyReport, error) {
+	engine, err := s.Engine()
+	if err != nil {
+		return nil, err
+	}
+	return engine.VerifyIndex(ctx)
+}
+
+// RebuildIndex rebuilds the index of the shard from the series with data in
+// the shard, while the shard remains available.
+func (s *Shard) RebuildIndex(ctx context.Context) error {
+	engine, err := s.Engine()
+	if err != nil {
+		return err
+	}
+	return engine.RebuildIndex(ctx)
+}
+
 // SetCompactionsEnabled enables or disable shard background compactions.
 func (s *Shard) SetCompactionsEnabled(enabled bool) {
 	engine, err := s.Engine()
@@ -649,6 +723,8 @@ func (s *Shard) WritePoints(ctx context.Context, points []models.Point) (rErr er
 	engine, err := s.engineNoLock()
 	if err != nil {
 		return err
+	} else if s.options.ReadOnly {
+		return ReadOnlyError{Op: "write points", Path: s.path}
 	}
 
 	var writeError error
@@ -737,9 +813,17 @@ func (s *Shard) validateSeriesAndFields(points []models.Point) ([]models.Point,
 		return nil, nil, err
 	}
 
+	// Drop any new series that would exceed the configured cardinality limits.
+	newKeys, newNames, newTagsSlice, limitErr := s.limitSeries(keys, names, tagsSlice)
+	if limitErr != nil {
+		if _, ok := limitErr.(PartialWriteError); !ok {
+			return nil, nil, limitErr
+		}
+	}
+
 	// Add new series. Check for partial writes.
 	var droppedKeys [][]byte
-	if err := engine.CreateSeriesListIfNotExists(keys, names, tagsSlice); err != nil {
+	if err := engine.CreateSeriesListIfNotExists(newKeys, newNames, newTagsSlice); err != nil {
 		switch err := err.(type) {
 		// (DSB) This was previously *PartialWriteError. Now catch pointer and value types.
 		case *PartialWriteError:
@@ -757,6 +841,18 @@ func (s *Shard) validateSeriesAndFields(points []models.Point) ([]models.Point,
 		}
 	}
 
+	if err, ok := limitErr.(PartialWriteError); ok {
+		if reason == "" {
+			reason = err.Reason
+		}
+		dropped += err.Dropped
+		s.stats.writesDropped.Add(float64(err.Dropped))
+
+		// Both sets of dropped keys must be sorted for the lookup below.
+		droppedKeys = append(droppedKeys, err.DroppedKeys...)
+		bytesutil.Sort(droppedKeys)
+	}
+
 	j = 0
 	for i, p := range points {
 		// Skip any points with only invalid fields.
@@ -788,7 +884,7 @@ func (s *Shard) validateSeriesAndFields(points []models.Point) ([]models.Point,
 		mf := engine.MeasurementFields(name)
 
 		// Check with the field validator.
-		if err := ValidateFields(mf, p, s.options.Config.SkipFieldSizeValidation); err != nil {
+		if err := validateFields(mf, p, s.options.Config.SkipFieldSizeValidation, s.options.Config.SketchFields); err != nil {
 			switch err := err.(type) {
 			case PartialWriteError:
 				if reason == "" {
@@ -819,7 +915,7 @@ func (s *Shard) validateSeriesAndFields(points []models.Point) ([]models.Point,
 				continue
 			}
 
-			dataType := dataTypeFromModelsFieldType(iter.Type())
+			dataType := fieldDataType(iter, nil, s.options.Config.SketchFields)
 			if dataType == influxql.Unknown {
 				continue
 			}
@@ -841,6 +937,188 @@ func (s *Shard) validateSeriesAndFields(points []models.Point) ([]models.Point,
 	return points[:j], fieldsToCreate, err
 }
 
+// limitSeries filters out any series that do not yet exist in the database and
+// would cause the max-series-per-database or max-values-per-tag limits to be
+// exceeded. Series which already exist are never dropped. The returned slices
+// do not share backing arrays with the arguments, so the caller's slices stay
+// aligned with their points. If any series are dropped a PartialWriteError is
+// returned holding the sorted keys of the dropped series.
+func (s *Shard) limitSeries(keys, names [][]byte, tagsSlice []models.Tags) ([][]byte, [][]byte, []models.Tags, error) {
+	maxSeriesN := s.options.Config.MaxSeriesPerDatabase
+	maxValuesPerTag := s.options.Config.MaxValuesPerTag
+	if maxSeriesN <= 0 && maxValuesPerTag <= 0 {
+		return keys, names, tagsSlice, nil
+	}
+
+	var (
+		newKeys      = make([][]byte, 0, len(keys))
+		newNames     = make([][]byte, 0, len(names))
+		newTagsSlice = make([]models.Tags, 0, len(tagsSlice))
+
+		reason      string
+		dropped     int
+		droppedKeys [][]byte
+
+		seriesN   = int(s.sfile.SeriesCount())
+		created   = make(map[string]bool) // new series keys seen in this batch, true if accepted
+		tagValues = make(map[string]struct{})
+		buf       []byte
+	)
+
+	var tagValueNs map[string]int
+	if maxValuesPerTag > 0 {
+		s.tagValueNs.mu.Lock()
+		defer s.tagValueNs.mu.Unlock()
+		if s.tagValueNs.n == nil {
+			s.tagValueNs.n = make(map[string]int)
+		}
+		tagValueNs = s.tagValueNs.n
+	}
+
+outer:
+	for i, key := range keys {
+		name, tags := names[i], tagsSlice[i]
+
+		// Existing series are never subject to limits.
+		accepted, seen := created[string(key)]
+		if !seen {
+			if id := s.sfile.SeriesID(name, tags, buf); id != 0 && !s.sfile.IsDeleted(id) {
+				accepted, seen = true, true
+			}
+		}
+		if seen {
+			if !accepted {
+				dropped++
+				continue
+			}
+			newKeys, newNames, newTagsSlice = append(newKeys, key), append(newNames, name), append(newTagsSlice, tags)
+			continue
+		}
+
+		if maxSeriesN > 0 && seriesN >= maxSeriesN {
+			if reason == "" {
+				reason = fmt.Sprintf("max-series-per-database limit exceeded: (%d/%d)", seriesN, maxSeriesN)
+			}
+			created[string(key)] = false
+			dropped++
+			droppedKeys = append(droppedKeys, key)
+			continue
+		}
+
+		// Ensure that no tag goes over the maximum cardinality.
+		if maxValuesPerTag > 0 {
+			for _, tag := range tags {
+				if _, ok := tagValues[tagValueLimitKey(name, tag.Key, tag.Value)]; ok {
+					continue
+				}
+
+				// Skip if the tag value already exists.
+				if ok, err := s.index.HasTagValue(name, tag.Key, tag.Value); err != nil {
+					return nil, nil, nil, err
+				} else if ok {
+					tagValues[tagValueLimitKey(name, tag.Key, tag.Value)] = struct{}{}
+					continue
+				}
+
+				// Read cardinality. Skip if we're below the threshold.
+				n, err := s.tagValueN(tagValueNs, name, tag.Key)
+				if err != nil {
+					return nil, nil, nil, err
+				} else if n < maxValuesPerTag {
+					continue
+				}
+
+				if reason == "" {
+					reason = fmt.Sprintf("max-values-per-tag limit exceeded (%d/%d): measurement=%q tag=%q value=%q",
+						n, maxValuesPerTag, name, string(tag.Key), string(tag.Value))
+				}
+				created[string(key)] = false
+				dropped++
+				droppedKeys = append(droppedKeys, key)
+				continue outer
+			}
+
+			// The series is accepted, so account for any new tag values it adds.
+			for _, tag := range tags {
+				k := tagValueLimitKey(name, tag.Key, tag.Value)
+				if _, ok := tagValues[k]; ok {
+					continue
+				}
+				tagValues[k] = struct{}{}
+				tagValueNs[tagValueLimitKey(name, tag.Key, nil)]++
+			}
+		}
+
+		created[string(key)] = true
+		seriesN++
+		newKeys, newNames, newTagsSlice = append(newKeys, key), append(newNames, name), append(newTagsSlice, tags)
+	}
+
+	if dropped == 0 {
+		return newKeys, newNames, newTagsSlice, nil
+	}
+
+	bytesutil.Sort(droppedKeys)
+	return newKeys, newNames, newTagsSlice, PartialWriteError{
+		Reason:      reason,
+		Dropped:     dropped,
+		DroppedKeys: droppedKeys,
+	}
+}
+
+// tagValueN returns the number of values for a tag key within a measurement.
+// The values of a key are only read from the index the first time the key is
+// seen, the count is then memoized in cache and maintained by limitSeries as
+// new values are accepted.
+func (s *Shard) tagValueN(cache map[string]int, name, key []byte) (int, error) {
+	k := tagValueLimitKey(name, key, nil)
+	if n, ok := cache[k]; ok {
+		return n, nil
+	}
+
+	itr, err := s.index.TagValueIterator(name, key)
+	if err != nil {
+		return 0, err
+	} else if itr == nil {
+		cache[k] = 0
+		return 0, nil
+	}
+	defer itr.Close()
+
+	var n int
+	for {
+		if v, err := itr.Next(); err != nil {
+			return 0, err
+		} else if v == nil {
+			break
+		}
+		n++
+	}
+	cache[k] = n
+	return n, nil
+}
+
+// tagValueCounts holds the number of values of tag keys within measurements.
+// Counts may overestimate the number of values, when series are dropped after
+// they have been accepted, but they never underestimate it.
+type tagValueCounts struct {
+	mu sync.Mutex
+	n  map[string]int // keyed by tagValueLimitKey(name, key, nil)
+}
+
+// reset drops all counts, so they are read from the index again. It must be
+// called when tag values may have been removed.
+func (c *tagValueCounts) reset() {
+	c.mu.Lock()
+	c.n = nil
+	c.mu.Unlock()
+}
+
+// tagValueLimitKey returns a map key for a measurement, tag key and tag value.
+func tagValueLimitKey(name, key, value []byte) string {
+	return string(name) + "\x00" + string(key) + "\x00" + string(value)
+}
+
 const unPrintReplRune = '?'
 const unPrintMaxReplRune = 3
 
@@ -895,6 +1173,7 @@ func (s *Shard) DeleteSeriesRange(ctx context.Context, itr SeriesIterator, min,
 	if err != nil {
 		return err
 	}
+	defer s.tagValueNs.reset()
 	return engine.DeleteSeriesRange(ctx, itr, min, max)
 }
 
@@ -909,6 +1188,7 @@ func (s *Shard) DeleteSeriesRangeWithPredicate(
 	if err != nil {
 		return err
 	}
+	defer s.tagValueNs.reset()
 	return engine.DeleteSeriesRangeWithPredicate(ctx, itr, predicate)
 }
 
@@ -918,6 +1198,7 @@ func (s *Shard) DeleteMeasurement(ctx context.Context, name []byte) error {
 	if err != nil {
 		return err
 	}
+	defer s.tagValueNs.reset()
 	return engine.DeleteMeasurement(ctx, name)
 }
 
@@ -1228,6 +1509,10 @@ func (s *Shard) Export(w io.Writer, basePath string, start time.Time, end time.T
 // Restore restores data to the underlying engine for the shard.
 // The shard is reopened after restore.
 func (s *Shard) Restore(ctx context.Context, r io.Reader, basePath string) error {
+	if s.options.ReadOnly {
+		return ReadOnlyError{Op: "restore", Path: s.path}
+	}
+
 	closeWaitNeeded, err := func() (bool, error) {
 		s.mu.Lock()
 		defer s.mu.Unlock()
@@ -1286,6 +1571,32 @@ func (s *Shard) Import(r io.Reader, basePath string) error {
 	return s._engine.Import(r, basePath)
 }
 
+// ArchiveWAL writes the entries committed to the WAL of the shard to an
+// archive in w until the returned closer is closed.  A backup taken after
+// ArchiveWAL returns can then be restored to any point in time covered by the
+// archive with RestoreAsOf.
+func (s *Shard) ArchiveWAL(w io.Writer) (io.Closer, error) {
+	engine, err := s.Engine()
+	if err != nil {
+		return nil, err
+	}
+	return engine.ArchiveWAL(w)
+}
+
+// RestoreAsOf restores the shard from the backup in r and replays the WAL
+// archive in archive up to asOf.  It returns the number of entries replayed.
+func (s *Shard) RestoreAsOf(ctx context.Context, r, archive io.Reader, basePath string, asOf time.Time) (int, error) {
+	if err := s.Restore(ctx, r, basePath); err != nil {
+		return 0, err
+	}
+
+	engine, err := s.Engine()
+	if err != nil {
+		return 0, err
+	}
+	return engine.ReplayWALArchive(ctx, s, archive, asOf)
+}
+
 // CreateSnapshot will return a path to a temp directory
 // containing hard links to the underlying shard files.
 func (s *Shard) CreateSnapshot(skipCacheOk bool) (string, error) {
@@ -1761,6 +2072,17 @@ func (a Shards) CreateSeriesCursor(ctx context.Context, req SeriesCursorRequest,
 	return newSeriesCursor(req, IndexSet{Indexes: idxs, SeriesFile: sfile}, cond)
 }
 
+// CreateMergeCursor returns a cursor reading the series field key of r from all
+// shards in parallel and merging their values into a single stream ordered by
+// time.  Each shard reads at most readAhead arrays ahead of the merge.
+func (a Shards) CreateMergeCursor(ctx context.Context, r *CursorRequest, readAhead int) (Cursor, error) {
+	itrs, err := CreateCursorIterators(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	return itrs.NewMergeCursor(ctx, r, readAhead)
+}
+
 func (a Shards) ExpandSources(sources influxql.Sources) (influxql.Sources, error) {
 	// Use a map as a set to prevent duplicates.
 	set := map[string]influxql.Source{}
@@ -1950,14 +2272,28 @@ type MeasurementFieldSet struct {
 	// path is the location to persist field sets
 	path      string
 	changeMgr *measurementFieldSetChangeMgr
+	// readOnly is set if the field set may not be persisted.
+	readOnly bool
 }
 
 // NewMeasurementFieldSet returns a new instance of MeasurementFieldSet.
 func NewMeasurementFieldSet(path string, logger *zap.Logger) (*MeasurementFieldSet, error) {
+	return newMeasurementFieldSet(path, logger, false)
+}
+
+// NewReadOnlyMeasurementFieldSet returns a MeasurementFieldSet loaded from path
+// and its change log that never writes or removes them.  Saving it returns a
+// ReadOnlyError.
+func NewReadOnlyMeasurementFieldSet(path string, logger *zap.Logger) (*MeasurementFieldSet, error) {
+	return newMeasurementFieldSet(path, logger, true)
+}
+
+func newMeasurementFieldSet(path string, logger *zap.Logger, readOnly bool) (*MeasurementFieldSet, error) {
 	const MaxCombinedWrites = 100
 	fs := &MeasurementFieldSet{
-		fields: make(map[string]*MeasurementFields),
-		path:   path,
+		fields:   make(map[string]*MeasurementFields),
+		path:     path,
+		readOnly: readOnly,
 	}
 	if nil == logger {
 		logger = zap.NewNop()
@@ -1971,6 +2307,9 @@ func NewMeasurementFieldSet(path string, logger *zap.Logger) (*MeasurementFieldS
 func (fs *MeasurementFieldSet) Close() error {
 	if fs != nil && fs.changeMgr != nil {
 		fs.changeMgr.Close()
+		if fs.readOnly {
+			return nil
+		}
 		// If there is a change log file, save the in-memory version
 		if _, err := os.Stat(fs.changeMgr.changeFilePath); err == nil {
 			return fs.WriteToFile()
@@ -2122,6 +2461,9 @@ func (fscm *measurementFieldSetChangeMgr) Close() {
 }
 
 func (fs *MeasurementFieldSet) Save(changes FieldChanges) error {
+	if fs.readOnly {
+		return ReadOnlyError{Op: "save fields", Path: fs.path}
+	}
 	return fs.changeMgr.RequestSave(changes)
 }
 
@@ -2143,6 +2485,9 @@ func (fscm *measurementFieldSetChangeMgr) SaveWriter() {
 // WriteToFile: Write the new index to a temp file and rename when it's sync'd
 // This locks the MeasurementFieldSet during the marshaling, the write, and the rename.
 func (fs *MeasurementFieldSet) WriteToFile() error {
+	if fs.readOnly {
+		return ReadOnlyError{Op: "save fields", Path: fs.path}
+	}
 	path := fs.path + ".tmp"
 
 	// Open the temp file
@@ -2530,6 +2875,9 @@ func (fs *MeasurementFieldSet) ApplyChanges() error {
 		return err
 	}
 	if len(changes) <= 0 {
+		if fs.readOnly {
+			return nil
+		}
 		return os.RemoveAll(fs.changeMgr.changeFilePath)
 	}
 
@@ -2547,6 +2895,9 @@ func (fs *MeasurementFieldSet) ApplyChanges() error {
 			}
 		}
 	}
+	if fs.readOnly {
+		return nil
+	}
 	return fs.WriteToFile()
 }
 
@@ -26,8 +26,10 @@ import (
 	"github.com/influxdata/influxdb/v2/pkg/deep"
 	"github.com/influxdata/influxdb/v2/pkg/testing/assert"
 	"github.com/influxdata/influxdb/v2/tsdb"
+	"github.com/influxdata/influxdb/v2/tsdb/cursors"
 	_ "github.com/influxdata/influxdb/v2/tsdb/engine"
 	_ "github.com/influxdata/influxdb/v2/tsdb/index"
+	"github.com/influxdata/influxdb/v2/tsdb/sketch"
 	"github.com/influxdata/influxql"
 	assert2 "github.com/stretchr/testify/assert"
 )
@@ -314,6 +316,110 @@ func TestWriteTimeField(t *testing.T) {
 	}
 }
 
+func TestShard_WritePoints_MaxValuesPerTag(t *testing.T) {
+	tmpDir := t.TempDir()
+	tmpShard := filepath.Join(tmpDir, "shard")
+	tmpWal := filepath.Join(tmpDir, "wal")
+
+	sfile := MustOpenSeriesFile(t)
+	defer sfile.Close()
+
+	opts := tsdb.NewEngineOptions()
+	opts.Config.WALDir = filepath.Join(tmpDir, "wal")
+	opts.Config.MaxValuesPerTag = 2
+
+	sh := tsdb.NewShard(1, tmpShard, tmpWal, sfile.SeriesFile, opts)
+	if err := sh.Open(context.Background()); err != nil {
+		t.Fatalf("error opening shard: %s", err.Error())
+	}
+	defer sh.Close()
+
+	points := []models.Point{
+		models.MustNewPoint("cpu", models.NewTags(map[string]string{"host": "a"}), map[string]interface{}{"value": 1.0}, time.Unix(1, 0)),
+		models.MustNewPoint("cpu", models.NewTags(map[string]string{"host": "b"}), map[string]interface{}{"value": 1.0}, time.Unix(1, 0)),
+		models.MustNewPoint("cpu", models.NewTags(map[string]string{"host": "c"}), map[string]interface{}{"value": 1.0}, time.Unix(1, 0)),
+		models.MustNewPoint("mem", models.NewTags(map[string]string{"host": "c"}), map[string]interface{}{"value": 1.0}, time.Unix(1, 0)),
+	}
+
+	err := sh.WritePoints(context.Background(), points)
+	if perr, ok := err.(tsdb.PartialWriteError); !ok {
+		t.Fatalf("expected partial write error, got %v", err)
+	} else if got, exp := perr.Dropped, 1; got != exp {
+		t.Fatalf("unexpected dropped count: got=%d exp=%d", got, exp)
+	} else if !strings.Contains(perr.Reason, "max-values-per-tag limit exceeded (2/2)") {
+		t.Fatalf("unexpected reason: %s", perr.Reason)
+	}
+
+	// Writes to existing tag values must still succeed.
+	if err := sh.WritePoints(context.Background(), []models.Point{
+		models.MustNewPoint("cpu", models.NewTags(map[string]string{"host": "a"}), map[string]interface{}{"value": 2.0}, time.Unix(2, 0)),
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// New tag values are still limited in later batches.
+	err = sh.WritePoints(context.Background(), []models.Point{
+		models.MustNewPoint("cpu", models.NewTags(map[string]string{"host": "d"}), map[string]interface{}{"value": 1.0}, time.Unix(3, 0)),
+	})
+	if perr, ok := err.(tsdb.PartialWriteError); !ok {
+		t.Fatalf("expected partial write error, got %v", err)
+	} else if got, exp := perr.Dropped, 1; got != exp {
+		t.Fatalf("unexpected dropped count: got=%d exp=%d", got, exp)
+	}
+
+	if ok, err := sh.MeasurementExists([]byte("mem")); err != nil || !ok {
+		t.Fatalf("expected mem measurement: ok=%v err=%v", ok, err)
+	}
+	if got, exp := sh.SeriesN(), int64(3); got != exp {
+		t.Fatalf("unexpected series count: got=%d exp=%d", got, exp)
+	}
+}
+
+func TestShard_WritePoints_MaxSeriesPerDatabase(t *testing.T) {
+	tmpDir := t.TempDir()
+	tmpShard := filepath.Join(tmpDir, "shard")
+	tmpWal := filepath.Join(tmpDir, "wal")
+
+	sfile := MustOpenSeriesFile(t)
+	defer sfile.Close()
+
+	opts := tsdb.NewEngineOptions()
+	opts.Config.WALDir = filepath.Join(tmpDir, "wal")
+	opts.Config.MaxSeriesPerDatabase = 2
+
+	sh := tsdb.NewShard(1, tmpShard, tmpWal, sfile.SeriesFile, opts)
+	if err := sh.Open(context.Background()); err != nil {
+		t.Fatalf("error opening shard: %s", err.Error())
+	}
+	defer sh.Close()
+
+	points := []models.Point{
+		models.MustNewPoint("cpu", models.NewTags(map[string]string{"host": "a"}), map[string]interface{}{"value": 1.0}, time.Unix(1, 0)),
+		models.MustNewPoint("cpu", models.NewTags(map[string]string{"host": "a"}), map[string]interface{}{"value": 2.0}, time.Unix(2, 0)),
+		models.MustNewPoint("cpu", models.NewTags(map[string]string{"host": "b"}), map[string]interface{}{"value": 1.0}, time.Unix(1, 0)),
+		models.MustNewPoint("cpu", models.NewTags(map[string]string{"host": "c"}), map[string]interface{}{"value": 1.0}, time.Unix(1, 0)),
+		models.MustNewPoint("cpu", models.NewTags(map[string]string{"host": "c"}), map[string]interface{}{"value": 2.0}, time.Unix(2, 0)),
+	}
+
+	err := sh.WritePoints(context.Background(), points)
+	if perr, ok := err.(tsdb.PartialWriteError); !ok {
+		t.Fatalf("expected partial write error, got %v", err)
+	} else if got, exp := perr.Dropped, 2; got != exp {
+		t.Fatalf("unexpected dropped count: got=%d exp=%d", got, exp)
+	} else if got, exp := perr.Reason, "max-series-per-database limit exceeded: (2/2)"; got != exp {
+		t.Fatalf("unexpected reason: got=%q exp=%q", got, exp)
+	}
+
+	if got, exp := sh.SeriesN(), int64(2); got != exp {
+		t.Fatalf("unexpected series count: got=%d exp=%d", got, exp)
+	}
+
+	// Existing series can still be written to once the limit is reached.
+	if err := sh.WritePoints(context.Background(), points[:3]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 func TestShardWriteAddNewField(t *testing.T) {
 	tmpDir := t.TempDir()
 	tmpShard := filepath.Join(tmpDir, "shard")
@@ -360,6 +466,127 @@ func TestShardWriteAddNewField(t *testing.T) {
 	}
 }
 
+// Ensures sketches written as their text encoding to a configured sketch field
+// are stored in a sketch field, that a sketch written at the same timestamp
+// replaces the existing one, and that sketches are merged by the sum aggregate.
+func TestShard_WritePoints_Sketch(t *testing.T) {
+	tmpDir := t.TempDir()
+	tmpShard := filepath.Join(tmpDir, "shard")
+	tmpWal := filepath.Join(tmpDir, "wal")
+
+	sfile := MustOpenSeriesFile(t)
+	defer sfile.Close()
+
+	opts := tsdb.NewEngineOptions()
+	opts.Config.WALDir = filepath.Join(tmpDir, "wal")
+	opts.Config.SketchFields = []string{"latency"}
+
+	sh := tsdb.NewShard(1, tmpShard, tmpWal, sfile.SeriesFile, opts)
+	if err := sh.Open(context.Background()); err != nil {
+		t.Fatalf("error opening shard: %s", err.Error())
+	}
+	defer sh.Close()
+
+	newPoint := func(field string, value interface{}, ts int64) models.Point {
+		if s, ok := value.(*sketch.Sketch); ok {
+			b, err := s.MarshalText()
+			if err != nil {
+				t.Fatal(err)
+			}
+			value = string(b)
+		}
+		return models.MustNewPoint(
+			"http",
+			models.NewTags(map[string]string{"host": "server"}),
+			map[string]interface{}{field: value},
+			time.Unix(0, ts),
+		)
+	}
+	newSketch := func(values ...float64) *sketch.Sketch {
+		s := sketch.NewDefault()
+		for _, v := range values {
+			s.Add(v)
+		}
+		return s
+	}
+
+	if err := sh.WritePoints(context.Background(), []models.Point{
+		newPoint("latency", newSketch(1, 2), 10),
+		newPoint("latency", newSketch(3), 20),
+	}); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// A sketch written again at the same timestamp replaces the existing one.
+	if err := sh.WritePoints(context.Background(), []models.Point{newPoint("latency", newSketch(4), 10)}); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// Other strings are not sketches.
+	err := sh.WritePoints(context.Background(), []models.Point{newPoint("latency", "fast", 30)})
+	if pwe, ok := err.(tsdb.PartialWriteError); !ok || !strings.Contains(pwe.Reason, "not a valid sketch") {
+		t.Fatalf("expected invalid sketch error, got %v", err)
+	}
+
+	// Sketches written to other fields are strings.
+	if err := sh.WritePoints(context.Background(), []models.Point{newPoint("note", newSketch(5), 10)}); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	mf := sh.MeasurementFields([]byte("http"))
+	if f := mf.Field("latency"); f == nil {
+		t.Fatal("expected latency field")
+	} else if got, exp := f.Type, cursors.SketchDataType; got != exp {
+		t.Fatalf("field type mismatch: got %v, exp %v", got, exp)
+	}
+	if f := mf.Field("note"); f == nil {
+		t.Fatal("expected note field")
+	} else if got, exp := f.Type, influxql.String; got != exp {
+		t.Fatalf("field type mismatch: got %v, exp %v", got, exp)
+	}
+
+	itr, err := sh.CreateCursorIterator(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &tsdb.CursorRequest{
+		Name:      []byte("http"),
+		Tags:      models.NewTags(map[string]string{"host": "server"}),
+		Field:     "latency",
+		Ascending: true,
+		StartTime: models.MinNanoTime,
+		EndTime:   models.MaxNanoTime,
+	}
+	cur, err := itr.Next(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+
+	a := cur.(tsdb.SketchArrayCursor).Next()
+	if got, exp := a.Timestamps, []int64{10, 20}; !cmp.Equal(got, exp) {
+		t.Fatalf("unexpected timestamps -got/+exp\n%s", cmp.Diff(got, exp))
+	} else if got, exp := a.Values[0].Count(), uint64(1); got != exp {
+		t.Fatalf("count mismatch: got %d, exp %d", got, exp)
+	} else if got, exp := a.Values[0].Min(), 4.0; got != exp {
+		t.Fatalf("min mismatch: got %v, exp %v", got, exp)
+	}
+
+	req.StartTime, req.EndTime, req.Aggregate = 0, 100, cursors.AggregateSum
+	sum, err := itr.Next(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sum.Close()
+
+	a = sum.(tsdb.SketchArrayCursor).Next()
+	if got, exp := a.Timestamps, []int64{0}; !cmp.Equal(got, exp) {
+		t.Fatalf("unexpected timestamps -got/+exp\n%s", cmp.Diff(got, exp))
+	} else if got, exp := a.Values[0].Count(), uint64(2); got != exp {
+		t.Fatalf("count mismatch: got %d, exp %d", got, exp)
+	}
+}
+
 // Tests concurrently writing to the same shard with different field types which
 // can trigger a panic when the shard is snapshotted to TSM files.
 func TestShard_WritePoints_FieldConflictConcurrent(t *testing.T) {
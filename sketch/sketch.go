@@ -0,0 +1,480 @@
+// Package sketch implements a mergeable quantile sketch.
+//
+// The sketch is a DDSketch: every value is counted in a bucket whose bounds grow
+// geometrically, so any quantile can be estimated with a relative error of at most
+// the accuracy the sketch was created with.  Sketches with the same accuracy can be
+// merged without losing precision, which lets quantiles be computed across series
+// and time ranges from the sketches stored for each point.
+package sketch
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// DefaultRelativeAccuracy is the relative accuracy of sketches created by NewDefault.
+	DefaultRelativeAccuracy = 0.01
+
+	// maxBuckets is the maximum number of buckets kept for positive or negative
+	// values.  Once exceeded, the buckets of the values closest to zero are
+	// collapsed, so only the accuracy of the lowest quantiles is lost.
+	maxBuckets = 2048
+
+	// encodingVersion is the version of the binary encoding of a sketch.
+	encodingVersion = 1
+
+	// TextPrefix prefixes the text encoding of a sketch.
+	TextPrefix = "sketch:"
+)
+
+var (
+	// ErrIncompatible is returned when merging sketches with different accuracies.
+	ErrIncompatible = errors.New("sketch: incompatible relative accuracy")
+
+	// ErrInvalidValue is returned when adding NaN or an infinite value.
+	ErrInvalidValue = errors.New("sketch: invalid value")
+
+	// ErrCorrupt is returned when decoding an invalid sketch.
+	ErrCorrupt = errors.New("sketch: corrupt encoding")
+)
+
+// Sketch estimates the quantiles of the values added to it.  A Sketch is not safe
+// for concurrent use and must not be modified once it has been written to storage.
+type Sketch struct {
+	alpha    float64
+	gamma    float64
+	logGamma float64
+
+	positive buckets
+	negative buckets
+	zero     uint64
+
+	min, max, sum float64
+}
+
+// New returns an empty sketch estimating quantiles with a relative error of at
+// most alpha, which must be between 0 and 1.
+func New(alpha float64) (*Sketch, error) {
+	if !(alpha > 0 && alpha < 1) {
+		return nil, fmt.Errorf("sketch: relative accuracy must be between 0 and 1, got %v", alpha)
+	}
+	return newSketch(alpha), nil
+}
+
+// NewDefault returns an empty sketch with DefaultRelativeAccuracy.
+func NewDefault() *Sketch {
+	return newSketch(DefaultRelativeAccuracy)
+}
+
+func newSketch(alpha float64) *Sketch {
+	gamma := (1 + alpha) / (1 - alpha)
+	return &Sketch{
+		alpha:    alpha,
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		min:      math.Inf(1),
+		max:      math.Inf(-1),
+	}
+}
+
+// RelativeAccuracy returns the relative accuracy of the sketch.
+func (s *Sketch) RelativeAccuracy() float64 { return s.alpha }
+
+// Add adds v to the sketch.
+func (s *Sketch) Add(v float64) error {
+	return s.AddN(v, 1)
+}
+
+// AddN adds n occurrences of v to the sketch.
+func (s *Sketch) AddN(v float64, n uint64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return ErrInvalidValue
+	} else if n == 0 {
+		return nil
+	}
+
+	switch {
+	case v > 0:
+		s.positive.add(s.index(v), n)
+	case v < 0:
+		s.negative.add(s.index(-v), n)
+	default:
+		s.zero += n
+	}
+
+	if v < s.min {
+		s.min = v
+	}
+	if v > s.max {
+		s.max = v
+	}
+	s.sum += v * float64(n)
+	return nil
+}
+
+// Merge adds the values of other to the sketch.
+func (s *Sketch) Merge(other *Sketch) error {
+	if other.alpha != s.alpha {
+		return ErrIncompatible
+	}
+	if other.Count() == 0 {
+		return nil
+	}
+
+	s.positive.merge(&other.positive)
+	s.negative.merge(&other.negative)
+	s.zero += other.zero
+	if other.min < s.min {
+		s.min = other.min
+	}
+	if other.max > s.max {
+		s.max = other.max
+	}
+	s.sum += other.sum
+	return nil
+}
+
+// Merge returns a new sketch holding the values of a and b.  If they cannot be
+// merged because their accuracies differ, b is returned.
+func Merge(a, b *Sketch) *Sketch {
+	s := a.Clone()
+	if err := s.Merge(b); err != nil {
+		return b
+	}
+	return s
+}
+
+// Count returns the number of values added to the sketch.
+func (s *Sketch) Count() uint64 {
+	return s.positive.count + s.negative.count + s.zero
+}
+
+// Sum returns the sum of the values added to the sketch.
+func (s *Sketch) Sum() float64 { return s.sum }
+
+// Min returns the smallest value added to the sketch, or NaN if it is empty.
+func (s *Sketch) Min() float64 {
+	if s.Count() == 0 {
+		return math.NaN()
+	}
+	return s.min
+}
+
+// Max returns the largest value added to the sketch, or NaN if it is empty.
+func (s *Sketch) Max() float64 {
+	if s.Count() == 0 {
+		return math.NaN()
+	}
+	return s.max
+}
+
+// Quantile returns an estimate of the q-quantile of the values added to the
+// sketch.  It returns NaN if the sketch is empty or q is not between 0 and 1.
+func (s *Sketch) Quantile(q float64) float64 {
+	count := s.Count()
+	if count == 0 || !(q >= 0 && q <= 1) {
+		return math.NaN()
+	}
+
+	// The rank of the value, counting from zero.
+	rank := uint64(q * float64(count-1))
+
+	var v float64
+	switch {
+	case rank < s.negative.count:
+		// Negative values are stored by magnitude, so the smallest value is
+		// found in the highest bucket.
+		v = -s.value(s.negative.indexOf(s.negative.count - 1 - rank))
+	case rank < s.negative.count+s.zero:
+		v = 0
+	default:
+		v = s.value(s.positive.indexOf(rank - s.negative.count - s.zero))
+	}
+
+	// The estimate of a bucket can be outside the range of the values added.
+	return math.Max(s.min, math.Min(s.max, v))
+}
+
+// Clone returns a copy of the sketch.
+func (s *Sketch) Clone() *Sketch {
+	other := *s
+	other.positive.counts = append([]uint64(nil), s.positive.counts...)
+	other.negative.counts = append([]uint64(nil), s.negative.counts...)
+	return &other
+}
+
+// Size returns the approximate number of bytes used by the sketch.
+func (s *Sketch) Size() int {
+	return 96 + 8*(len(s.positive.counts)+len(s.negative.counts))
+}
+
+// String returns a summary of the sketch.
+func (s *Sketch) String() string {
+	return fmt.Sprintf("sketch(count=%d, p50=%v, p99=%v)", s.Count(), s.Quantile(0.5), s.Quantile(0.99))
+}
+
+// index returns the index of the bucket of the positive value v.
+func (s *Sketch) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// value returns the estimate of the values counted in the bucket at index i,
+// which is within the relative accuracy of all values in the bucket.
+func (s *Sketch) value(i int) float64 {
+	return 2 * math.Pow(s.gamma, float64(i)) / (1 + s.gamma)
+}
+
+// MarshalBinary encodes the sketch.
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	return s.AppendBinary(nil), nil
+}
+
+// BinarySize returns the number of bytes of the encoding of the sketch.
+func (s *Sketch) BinarySize() int {
+	return 1 + 8 + uvarintSize(s.zero) + 3*8 + s.positive.binarySize() + s.negative.binarySize()
+}
+
+// AppendBinary appends the encoding of the sketch to b.
+func (s *Sketch) AppendBinary(b []byte) []byte {
+	b = append(b, encodingVersion)
+	b = appendFloat(b, s.alpha)
+	b = appendUvarint(b, s.zero)
+	b = appendFloat(b, s.min)
+	b = appendFloat(b, s.max)
+	b = appendFloat(b, s.sum)
+	b = s.positive.appendBinary(b)
+	return s.negative.appendBinary(b)
+}
+
+// UnmarshalBinary decodes a sketch encoded by MarshalBinary.
+func (s *Sketch) UnmarshalBinary(b []byte) error {
+	if len(b) < 1 || b[0] != encodingVersion {
+		return ErrCorrupt
+	}
+	b = b[1:]
+
+	alpha, b, err := readFloat(b)
+	if err != nil {
+		return err
+	} else if !(alpha > 0 && alpha < 1) {
+		return ErrCorrupt
+	}
+	*s = *newSketch(alpha)
+
+	zero, n := binary.Uvarint(b)
+	if n <= 0 {
+		return ErrCorrupt
+	}
+	s.zero, b = zero, b[n:]
+
+	if s.min, b, err = readFloat(b); err != nil {
+		return err
+	} else if s.max, b, err = readFloat(b); err != nil {
+		return err
+	} else if s.sum, b, err = readFloat(b); err != nil {
+		return err
+	} else if b, err = s.positive.readBinary(b); err != nil {
+		return err
+	} else if b, err = s.negative.readBinary(b); err != nil {
+		return err
+	} else if len(b) != 0 {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// MarshalText encodes the sketch as TextPrefix followed by the base64 encoding
+// of its binary encoding.  Line protocol has no sketch type, so sketches are
+// written as string field values holding their text encoding.
+func (s *Sketch) MarshalText() ([]byte, error) {
+	bin := s.AppendBinary(nil)
+	b := make([]byte, len(TextPrefix)+base64.StdEncoding.EncodedLen(len(bin)))
+	base64.StdEncoding.Encode(b[copy(b, TextPrefix):], bin)
+	return b, nil
+}
+
+// UnmarshalText decodes a sketch encoded by MarshalText.
+func (s *Sketch) UnmarshalText(b []byte) error {
+	if len(b) < len(TextPrefix) || string(b[:len(TextPrefix)]) != TextPrefix {
+		return ErrCorrupt
+	}
+	bin := make([]byte, base64.StdEncoding.DecodedLen(len(b)-len(TextPrefix)))
+	n, err := base64.StdEncoding.Decode(bin, b[len(TextPrefix):])
+	if err != nil {
+		return ErrCorrupt
+	}
+	return s.UnmarshalBinary(bin[:n])
+}
+
+// Parse returns the sketch encoded in text by MarshalText.
+func Parse(text string) (*Sketch, error) {
+	if !strings.HasPrefix(text, TextPrefix) {
+		return nil, ErrCorrupt
+	}
+
+	var s Sketch
+	if err := s.UnmarshalText([]byte(text)); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Unmarshal returns the sketch encoded in b.
+func Unmarshal(b []byte) (*Sketch, error) {
+	var s Sketch
+	if err := s.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func appendFloat(b []byte, v float64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], math.Float64bits(v))
+	return append(b, buf[:]...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func uvarintSize(v uint64) int {
+	n := 1
+	for ; v >= 0x80; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+func readFloat(b []byte) (float64, []byte, error) {
+	if len(b) < 8 {
+		return 0, nil, ErrCorrupt
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(b)), b[8:], nil
+}
+
+// buckets counts values by bucket index.  counts[i] is the count of the bucket
+// at index offset+i.
+type buckets struct {
+	offset int
+	counts []uint64
+	count  uint64
+}
+
+// add adds n to the count of the bucket at index i.  If the buckets would span
+// more than maxBuckets indexes, the lowest buckets are collapsed into one.
+func (b *buckets) add(i int, n uint64) {
+	b.count += n
+	if len(b.counts) == 0 {
+		b.offset, b.counts = i, append(b.counts, n)
+		return
+	}
+
+	lo, hi := b.offset, b.offset+len(b.counts)-1
+	if i < lo {
+		lo = i
+	} else if i > hi {
+		hi = i
+	}
+	if hi-lo+1 > maxBuckets {
+		lo = hi - maxBuckets + 1
+		if i < lo {
+			i = lo
+		}
+	}
+
+	if lo != b.offset || hi != b.offset+len(b.counts)-1 {
+		counts := make([]uint64, hi-lo+1)
+		for j, c := range b.counts {
+			if k := b.offset + j - lo; k > 0 {
+				counts[k] += c
+			} else {
+				counts[0] += c
+			}
+		}
+		b.offset, b.counts = lo, counts
+	}
+	b.counts[i-lo] += n
+}
+
+// merge adds the counts of other.
+func (b *buckets) merge(other *buckets) {
+	for j, n := range other.counts {
+		if n > 0 {
+			b.add(other.offset+j, n)
+		}
+	}
+}
+
+// indexOf returns the index of the bucket containing the value of the given
+// rank, counting from the lowest bucket.
+func (b *buckets) indexOf(rank uint64) int {
+	var n uint64
+	for j, c := range b.counts {
+		n += c
+		if n > rank {
+			return b.offset + j
+		}
+	}
+	return b.offset + len(b.counts) - 1
+}
+
+func (b *buckets) binarySize() int {
+	// Offsets are zig-zag encoded by binary.PutVarint.
+	offset := uint64(b.offset) << 1
+	if b.offset < 0 {
+		offset = ^offset
+	}
+	n := uvarintSize(offset) + uvarintSize(uint64(len(b.counts)))
+	for _, c := range b.counts {
+		n += uvarintSize(c)
+	}
+	return n
+}
+
+func (b *buckets) appendBinary(dst []byte) []byte {
+	dst = appendVarint(dst, int64(b.offset))
+	dst = appendUvarint(dst, uint64(len(b.counts)))
+	for _, c := range b.counts {
+		dst = appendUvarint(dst, c)
+	}
+	return dst
+}
+
+func (b *buckets) readBinary(src []byte) ([]byte, error) {
+	offset, n := binary.Varint(src)
+	if n <= 0 {
+		return nil, ErrCorrupt
+	}
+	src = src[n:]
+
+	sz, n := binary.Uvarint(src)
+	if n <= 0 || sz > maxBuckets || sz > uint64(len(src)) {
+		return nil, ErrCorrupt
+	}
+	src = src[n:]
+
+	*b = buckets{offset: int(offset)}
+	if sz > 0 {
+		b.counts = make([]uint64, sz)
+	}
+	for i := range b.counts {
+		c, n := binary.Uvarint(src)
+		if n <= 0 {
+			return nil, ErrCorrupt
+		}
+		b.counts[i], b.count, src = c, b.count+c, src[n:]
+	}
+	return src, nil
+}
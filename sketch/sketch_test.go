@@ -0,0 +1,197 @@
+package sketch_test
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/influxdata/influxdb/v2/tsdb/sketch"
+)
+
+// exactQuantile returns the q-quantile of the sorted values, using the same rank
+// as Sketch.Quantile.
+func exactQuantile(sorted []float64, q float64) float64 {
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+func TestSketch_Quantile(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	s := sketch.NewDefault()
+
+	values := make([]float64, 10000)
+	for i := range values {
+		values[i] = rnd.ExpFloat64()*100 - 20
+		if i%50 == 0 {
+			values[i] = 0
+		}
+		if err := s.Add(values[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Float64s(values)
+
+	if got, exp := s.Count(), uint64(len(values)); got != exp {
+		t.Fatalf("unexpected count: got %d, exp %d", got, exp)
+	}
+	if got, exp := s.Min(), values[0]; got != exp {
+		t.Fatalf("unexpected min: got %v, exp %v", got, exp)
+	}
+	if got, exp := s.Max(), values[len(values)-1]; got != exp {
+		t.Fatalf("unexpected max: got %v, exp %v", got, exp)
+	}
+
+	for _, q := range []float64{0, 0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 0.999, 1} {
+		got, exp := s.Quantile(q), exactQuantile(values, q)
+		if math.Abs(got-exp) > sketch.DefaultRelativeAccuracy*math.Abs(exp)+1e-9 {
+			t.Errorf("unexpected quantile %v: got %v, exp %v", q, got, exp)
+		}
+	}
+
+	if !math.IsNaN(s.Quantile(1.5)) {
+		t.Fatal("expected NaN for invalid quantile")
+	}
+	if !math.IsNaN(sketch.NewDefault().Quantile(0.5)) {
+		t.Fatal("expected NaN for empty sketch")
+	}
+	if err := s.Add(math.NaN()); err != sketch.ErrInvalidValue {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSketch_Merge(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	a, b, all := sketch.NewDefault(), sketch.NewDefault(), sketch.NewDefault()
+	for i := 0; i < 5000; i++ {
+		v := rnd.NormFloat64()*10 + 50
+		if i%2 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+		all.Add(v)
+	}
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if got, exp := a.Count(), all.Count(); got != exp {
+		t.Fatalf("unexpected count: got %d, exp %d", got, exp)
+	}
+	for _, q := range []float64{0, 0.5, 0.9, 0.99, 1} {
+		if got, exp := a.Quantile(q), all.Quantile(q); got != exp {
+			t.Errorf("unexpected quantile %v: got %v, exp %v", q, got, exp)
+		}
+	}
+
+	other, err := sketch.New(0.05)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Add(1)
+	if err := a.Merge(other); err != sketch.ErrIncompatible {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSketch_MarshalBinary(t *testing.T) {
+	s := sketch.NewDefault()
+	for _, v := range []float64{-3, -0.5, 0, 0, 1e-6, 2, 2, 1e6} {
+		s.Add(v)
+	}
+
+	b, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, exp := s.BinarySize(), len(b); got != exp {
+		t.Fatalf("unexpected binary size: got %d, exp %d", got, exp)
+	}
+	other, err := sketch.Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, exp := other.Count(), s.Count(); got != exp {
+		t.Fatalf("unexpected count: got %d, exp %d", got, exp)
+	}
+	if got, exp := other.Sum(), s.Sum(); got != exp {
+		t.Fatalf("unexpected sum: got %v, exp %v", got, exp)
+	}
+	for _, q := range []float64{0, 0.25, 0.5, 0.75, 1} {
+		if got, exp := other.Quantile(q), s.Quantile(q); got != exp {
+			t.Errorf("unexpected quantile %v: got %v, exp %v", q, got, exp)
+		}
+	}
+
+	if _, err := sketch.Unmarshal(b[:len(b)-1]); err != sketch.ErrCorrupt {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSketch_MarshalText(t *testing.T) {
+	s := sketch.NewDefault()
+	for _, v := range []float64{-3, 0, 2, 2, 1e6} {
+		s.Add(v)
+	}
+
+	b, err := s.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := sketch.Parse(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, exp := other.Count(), s.Count(); got != exp {
+		t.Fatalf("unexpected count: got %d, exp %d", got, exp)
+	}
+	if got, exp := other.Quantile(0.5), s.Quantile(0.5); got != exp {
+		t.Fatalf("unexpected median: got %v, exp %v", got, exp)
+	}
+
+	for _, text := range []string{"", "sketch", string(b[len(sketch.TextPrefix):]), sketch.TextPrefix + "!"} {
+		if _, err := sketch.Parse(text); err != sketch.ErrCorrupt {
+			t.Fatalf("unexpected error parsing %q: %v", text, err)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b := sketch.NewDefault(), sketch.NewDefault()
+	a.Add(1)
+	b.Add(2)
+	b.Add(3)
+
+	if got, exp := sketch.Merge(a, b).Count(), uint64(3); got != exp {
+		t.Fatalf("unexpected count: got %d, exp %d", got, exp)
+	} else if got, exp := a.Count(), uint64(1); got != exp {
+		t.Fatalf("merged sketch modified: got %d, exp %d", got, exp)
+	}
+
+	other, err := sketch.New(0.05)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sketch.Merge(a, other); got != other {
+		t.Fatalf("unexpected sketch: got %v, exp %v", got, other)
+	}
+}
+
+// Ensures values spanning more buckets than are kept only lose accuracy for the
+// values closest to zero.
+func TestSketch_Collapse(t *testing.T) {
+	s := sketch.NewDefault()
+	for _, v := range []float64{1e-300, 1e-200, 1, 2, 1e10} {
+		s.Add(v)
+	}
+
+	if got, exp := s.Count(), uint64(5); got != exp {
+		t.Fatalf("unexpected count: got %d, exp %d", got, exp)
+	}
+	if got, exp := s.Quantile(1), 1e10; got != exp {
+		t.Fatalf("unexpected max: got %v, exp %v", got, exp)
+	}
+	if got := s.Quantile(0.75); math.Abs(got-2) > 0.02 {
+		t.Fatalf("unexpected quantile: got %v", got)
+	}
+}
@@ -36,6 +36,9 @@ var (
 	ErrShardNotFound = fmt.Errorf("shard not found")
 	// ErrStoreClosed is returned when trying to use a closed Store.
 	ErrStoreClosed = fmt.Errorf("store is closed")
+	// ErrRetentionPolicyNotFound is returned when trying to use a retention
+	// policy without a directory in the store.
+	ErrRetentionPolicyNotFound = errors.New("retention policy not found")
 	// ErrShardDeletion is returned when trying to create a shard that is being deleted
 	ErrShardDeletion = errors.New("shard is being deleted")
 	// ErrMultipleIndexTypes is returned when trying to do deletes on a database with
@@ -109,6 +112,9 @@ type Store struct {
 	// Maintains a set of shards that failed to open
 	badShards shardErrorMap
 
+	// Series retention rules by database and retention policy.
+	retentions map[string]*SeriesRetention
+
 	// Epoch tracker helps serialize writes and deletes that may conflict. It
 	// is stored by shard.
 	epochs map[uint64]*epochTracker
@@ -130,6 +136,7 @@ func NewStore(path string) *Store {
 		databases:           make(map[string]*databaseState),
 		path:                path,
 		sfiles:              make(map[string]*SeriesFile),
+		retentions:          make(map[string]*SeriesRetention),
 		pendingShardDeletes: make(map[uint64]struct{}),
 		badShards:           shardErrorMap{shardErrors: make(map[uint64]error)},
 		epochs:              make(map[uint64]*epochTracker),
@@ -239,6 +246,14 @@ func (s *Store) IndexBytes() int {
 // Path returns the store's root path.
 func (s *Store) Path() string { return s.path }
 
+// checkWritable returns a ReadOnlyError for op if the store is read-only.
+func (s *Store) checkWritable(op string) error {
+	if s.EngineOptions.ReadOnly {
+		return ReadOnlyError{Op: op, Path: s.path}
+	}
+	return nil
+}
+
 // Open initializes the store, creating all necessary directories, loading all
 // shards as well as initializing periodic maintenance of them.
 func (s *Store) Open(ctx context.Context) error {
@@ -256,7 +271,11 @@ func (s *Store) Open(ctx context.Context) error {
 	s.Logger.Info("Using data dir", zap.String("path", s.Path()))
 
 	// Create directory.
-	if err := os.MkdirAll(s.path, 0777); err != nil {
+	if s.EngineOptions.ReadOnly {
+		if _, err := os.Stat(s.path); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(s.path, 0777); err != nil {
 		return err
 	}
 
@@ -312,7 +331,39 @@ func (s *Store) loadShards(ctx context.Context) error {
 
 	s.EngineOptions.CompactionLimiter = limiter.NewFixed(lim)
 
+	// Setup the scheduler deciding which shard's compactions run first.
+	policy, reserved := s.EngineOptions.Config.CompactSchedulerPolicy, s.EngineOptions.Config.CompactFullReserved
+	scheduler, err := NewCompactionScheduler(s.EngineOptions.CompactionLimiter, policy, reserved)
+	if err != nil {
+		return err
+	}
+	s.EngineOptions.CompactionScheduler = scheduler
+
+	// Setup a shared budget for the memory used by all caches.
+	if total := uint64(s.EngineOptions.Config.CacheMaxMemorySizeTotal); total > 0 {
+		s.EngineOptions.CacheMemoryGovernor = NewCacheMemoryGovernor(total)
+		s.Logger.Info("Cache memory limited", zap.Uint64("cache_max_memory_size_total", total))
+	}
+
+	// Setup the cache of decoded blocks shared by all shards.
+	if size := uint64(s.EngineOptions.Config.BlockCacheMaxMemorySize); size > 0 {
+		s.EngineOptions.BlockCache = NewBlockCache(size)
+		s.Logger.Info("Block cache enabled", zap.Uint64("block_cache_max_memory_size", size))
+	}
+
+	// Setup a shared budget for the memory used by the series ID set caches of all indexes.
+	if size := uint64(s.EngineOptions.Config.SeriesIDSetCacheMaxMemorySize); size > 0 {
+		s.EngineOptions.SeriesIDSetCacheBudget = NewSeriesIDSetCacheBudget(size)
+		s.Logger.Info("Series ID set cache memory limited", zap.Uint64("series_id_set_cache_max_memory_size", size))
+	}
+
 	compactionSettings := []zapcore.Field{zap.Int("max_concurrent_compactions", lim)}
+	if policy != "" {
+		compactionSettings = append(compactionSettings, zap.String("scheduler_policy", policy))
+	}
+	if reserved > 0 {
+		compactionSettings = append(compactionSettings, zap.Int("full_compactions_reserved", reserved))
+	}
 	throughput := int(s.EngineOptions.Config.CompactThroughput)
 	throughputBurst := int(s.EngineOptions.Config.CompactThroughputBurst)
 	if throughput > 0 {
@@ -390,12 +441,23 @@ func (s *Store) loadShards(ctx context.Context) error {
 				continue
 			}
 
+			// Load the series retention rules shared by the shards.
+			retention, err := s.openSeriesRetention(db.Name(), rp.Name())
+			if err != nil {
+				return err
+			}
+
 			shardDirs, err := os.ReadDir(rpPath)
 			if err != nil {
 				return err
 			}
 
 			for _, sh := range shardDirs {
+				// The series retention rules are not a shard.
+				if sh.Name() == SeriesRetentionFileName {
+					continue
+				}
+
 				// Series file should not be in a retention policy but skip just in case.
 				if sh.Name() == SeriesFileDirectory {
 					log.Warn("Skipping series file in retention policy dir", zap.String("path", filepath.Join(s.path, db.Name(), rp.Name())))
@@ -435,6 +497,7 @@ func (s *Store) loadShards(ctx context.Context) error {
 
 					// Provide an implementation of the ShardIDSets
 					opt.SeriesIDSets = shardSet{store: s, db: db}
+					opt.SeriesRetention = retention
 
 					// Open engine.
 					shard := NewShard(shardID, path, walPath, sfile, opt)
@@ -528,6 +591,7 @@ func (s *Store) Close() error {
 
 	s.databases = make(map[string]*databaseState)
 	s.sfiles = map[string]*SeriesFile{}
+	s.retentions = make(map[string]*SeriesRetention)
 	s.pendingShardDeletes = make(map[uint64]struct{})
 	s.shards = nil
 	s.opened = false // Store may now be opened again.
@@ -554,6 +618,7 @@ func (s *Store) openSeriesFile(database string) (*SeriesFile, error) {
 
 	sfile := NewSeriesFile(filepath.Join(s.path, database, SeriesFileDirectory))
 	sfile.WithMaxCompactionConcurrency(s.EngineOptions.Config.SeriesFileMaxConcurrentSnapshotCompactions)
+	sfile.WithReadOnly(s.EngineOptions.ReadOnly)
 	sfile.Logger = s.baseLogger
 	if err := sfile.Open(); err != nil {
 		return nil, err
@@ -562,6 +627,45 @@ func (s *Store) openSeriesFile(database string) (*SeriesFile, error) {
 	return sfile, nil
 }
 
+// openSeriesRetention either returns or loads the series retention rules of the
+// provided retention policy. It must be called under a full lock.
+func (s *Store) openSeriesRetention(database, rp string) (*SeriesRetention, error) {
+	key := filepath.Join(database, rp)
+	if retention := s.retentions[key]; retention != nil {
+		return retention, nil
+	}
+
+	retention, err := NewSeriesRetention(filepath.Join(s.path, database, rp, SeriesRetentionFileName))
+	if err != nil {
+		return nil, err
+	}
+	retention.readOnly = s.EngineOptions.ReadOnly
+	s.retentions[key] = retention
+	return retention, nil
+}
+
+// SeriesRetention returns the series retention rules of the provided retention
+// policy.  The rules apply to all of its shards, including shards created later.
+// ErrRetentionPolicyNotFound is returned if no shard of the retention policy was
+// created in the store.
+func (s *Store) SeriesRetention(database, rp string) (*SeriesRetention, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Reject names that don't resolve to a retention policy directory.
+	rpPath := filepath.Clean(filepath.Join(s.path, database, rp))
+	if filepath.Clean(s.path) != filepath.Dir(filepath.Dir(rpPath)) || rp == SeriesFileDirectory {
+		return nil, ErrRetentionPolicyNotFound
+	}
+
+	if fi, err := os.Stat(rpPath); os.IsNotExist(err) || (err == nil && !fi.IsDir()) {
+		return nil, ErrRetentionPolicyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return s.openSeriesRetention(database, rp)
+}
+
 func (s *Store) SeriesFile(database string) *SeriesFile {
 	return s.seriesFile(database)
 }
@@ -639,6 +743,14 @@ func (s *Store) ShardGroup(ids []uint64) ShardGroup {
 	return Shards(s.Shards(ids))
 }
 
+// CreateMergeCursor returns a cursor reading the series field key of r from the
+// shards with the given IDs in parallel, and merging their values into a single
+// stream ordered by time.  Each shard reads at most readAhead arrays ahead of the
+// merge.  It returns nil if none of the shards has the series field key.
+func (s *Store) CreateMergeCursor(ctx context.Context, ids []uint64, r *CursorRequest, readAhead int) (Cursor, error) {
+	return Shards(s.Shards(ids)).CreateMergeCursor(ctx, r, readAhead)
+}
+
 // ShardN returns the number of shards in the store.
 func (s *Store) ShardN() int {
 	s.mu.RLock()
@@ -659,6 +771,10 @@ func (s *Store) ShardDigest(id uint64) (io.ReadCloser, int64, error) {
 
 // CreateShard creates a shard with the given id and retention policy on a database.
 func (s *Store) CreateShard(ctx context.Context, database, retentionPolicy string, shardID uint64, enabled bool) error {
+	if err := s.checkWritable("create shard"); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -696,9 +812,16 @@ func (s *Store) CreateShard(ctx context.Context, database, retentionPolicy strin
 		return err
 	}
 
+	// Retrieve the series retention rules of the retention policy.
+	retention, err := s.openSeriesRetention(database, retentionPolicy)
+	if err != nil {
+		return err
+	}
+
 	// Copy index options and pass in shared index.
 	opt := s.EngineOptions
 	opt.SeriesIDSets = shardSet{store: s, db: database}
+	opt.SeriesRetention = retention
 
 	path := filepath.Join(s.path, database, retentionPolicy, strconv.FormatUint(shardID, 10))
 	shard := NewShard(shardID, path, walPath, sfile, opt)
@@ -737,6 +860,46 @@ func (s *Store) CreateShardSnapshot(id uint64, skipCacheOk bool) (string, error)
 	return sh.CreateSnapshot(skipCacheOk)
 }
 
+// Compactions returns the status of the running and queued compactions of the
+// shard with the given ID.
+func (s *Store) Compactions(shardID uint64) ([]CompactionStatus, error) {
+	sh := s.Shard(shardID)
+	if sh == nil {
+		return nil, ErrShardNotFound
+	}
+	return sh.Compactions()
+}
+
+// CancelCompaction aborts the running compaction with the given ID of the shard
+// with the given ID.
+func (s *Store) CancelCompaction(shardID, id uint64) error {
+	sh := s.Shard(shardID)
+	if sh == nil {
+		return ErrShardNotFound
+	}
+	return sh.CancelCompaction(id)
+}
+
+// VerifyIndex cross-checks the index of the shard with the given ID against
+// the data of the shard and the series file.
+func (s *Store) VerifyIndex(ctx context.Context, shardID uint64) (*IndexVerifyReport, error) {
+	sh := s.Shard(shardID)
+	if sh == nil {
+		return nil, ErrShardNotFound
+	}
+	return sh.VerifyIndex(ctx)
+}
+
+// RebuildIndex rebuilds the index of the shard with the given ID from the data
+// of the shard.
+func (s *Store) RebuildIndex(ctx context.Context, shardID uint64) error {
+	sh := s.Shard(shardID)
+	if sh == nil {
+		return ErrShardNotFound
+	}
+	return sh.RebuildIndex(ctx)
+}
+
 // SetShardEnabled enables or disables a shard for read and writes.
 func (s *Store) SetShardEnabled(shardID uint64, enabled bool) error {
 	sh := s.Shard(shardID)
@@ -759,6 +922,10 @@ func (s *Store) DeleteShards() error {
 
 // DeleteShard removes a shard from disk.
 func (s *Store) DeleteShard(shardID uint64) error {
+	if err := s.checkWritable("delete shard"); err != nil {
+		return err
+	}
+
 	sh := s.Shard(shardID)
 	if sh == nil {
 		return nil
@@ -846,6 +1013,8 @@ func (s *Store) DeleteShard(shardID uint64) error {
 		return err
 	} else if err = os.RemoveAll(sh.walPath); err != nil {
 		return err
+	} else if err = s.removeColdPath(sh.database, sh.retentionPolicy, strconv.FormatUint(sh.id, 10)); err != nil {
+		return err
 	} else {
 		// Remove index type from the database on success
 		s.databases[db].removeIndexType(sh.IndexType())
@@ -853,10 +1022,23 @@ func (s *Store) DeleteShard(shardID uint64) error {
 	}
 }
 
+// removeColdPath removes the directory at elem under the cold tier, if any.
+func (s *Store) removeColdPath(elem ...string) error {
+	dir := s.EngineOptions.Config.TSMColdDir
+	if dir == "" {
+		return nil
+	}
+	return os.RemoveAll(filepath.Join(append([]string{dir}, elem...)...))
+}
+
 // DeleteDatabase will close all shards associated with a database and remove the directory and files from disk.
 //
 // Returns nil if no database exists
 func (s *Store) DeleteDatabase(name string) error {
+	if err := s.checkWritable("delete database"); err != nil {
+		return err
+	}
+
 	s.mu.RLock()
 	if _, ok := s.databases[name]; !ok {
 		s.mu.RUnlock()
@@ -905,11 +1087,19 @@ func (s *Store) DeleteDatabase(name string) error {
 	if err := os.RemoveAll(filepath.Join(s.EngineOptions.Config.WALDir, name)); err != nil {
 		return err
 	}
+	if err := s.removeColdPath(name); err != nil {
+		return err
+	}
 
 	for _, sh := range shards {
 		delete(s.shards, sh.id)
 		delete(s.epochs, sh.id)
 	}
+	for key := range s.retentions {
+		if filepath.Dir(key) == name {
+			delete(s.retentions, key)
+		}
+	}
 
 	// Remove database from store list of databases
 	delete(s.databases, name)
@@ -921,6 +1111,10 @@ func (s *Store) DeleteDatabase(name string) error {
 // provided retention policy, remove the retention policy directories on
 // both the DB and WAL, and remove all shard files from disk.
 func (s *Store) DeleteRetentionPolicy(database, name string) error {
+	if err := s.checkWritable("delete retention policy"); err != nil {
+		return err
+	}
+
 	s.mu.RLock()
 	if _, ok := s.databases[database]; !ok {
 		s.mu.RUnlock()
@@ -962,18 +1156,28 @@ func (s *Store) DeleteRetentionPolicy(database, name string) error {
 		return err
 	}
 
+	// Remove the retention policy folder from the cold tier.
+	if err := s.removeColdPath(database, name); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	state := s.databases[database]
 	for _, sh := range shards {
 		delete(s.shards, sh.id)
 		state.removeIndexType(sh.IndexType())
 	}
+	delete(s.retentions, filepath.Join(database, name))
 	s.mu.Unlock()
 	return nil
 }
 
 // DeleteMeasurement removes a measurement and all associated series from a database.
 func (s *Store) DeleteMeasurement(ctx context.Context, database, name string) error {
+	if err := s.checkWritable("delete measurement"); err != nil {
+		return err
+	}
+
 	s.mu.RLock()
 	if s.databases[database].hasMultipleIndexTypes() {
 		s.mu.RUnlock()
@@ -1322,6 +1526,34 @@ func (s *Store) RestoreShard(ctx context.Context, id uint64, r io.Reader) error
 	return shard.Restore(ctx, r, path)
 }
 
+// ArchiveShardWAL writes the entries committed to the WAL of the given shard to
+// an archive in w until the returned closer is closed.  The backup the archive
+// is later replayed on top of must be taken after ArchiveShardWAL returns.
+func (s *Store) ArchiveShardWAL(id uint64, w io.Writer) (io.Closer, error) {
+	shard := s.Shard(id)
+	if shard == nil {
+		return nil, fmt.Errorf("shard %d doesn't exist on this server", id)
+	}
+	return shard.ArchiveWAL(w)
+}
+
+// RestoreShardAsOf restores the given shard from the backup in r, then replays
+// the WAL archive in archive up to asOf to restore the shard as it was at that
+// time.  It returns the number of archived entries replayed.
+func (s *Store) RestoreShardAsOf(ctx context.Context, id uint64, r, archive io.Reader, asOf time.Time) (int, error) {
+	shard := s.Shard(id)
+	if shard == nil {
+		return 0, fmt.Errorf("shard %d doesn't exist on this server", id)
+	}
+
+	path, err := relativePath(s.path, shard.path)
+	if err != nil {
+		return 0, err
+	}
+
+	return shard.RestoreAsOf(ctx, r, archive, path, asOf)
+}
+
 // ImportShard imports the contents of r to a given shard.
 // All files in the backup are added as new files which may
 // cause duplicated data to occur requiring more expensive
@@ -1353,6 +1585,10 @@ func (s *Store) ShardRelativePath(id uint64) (string, error) {
 // DeleteSeries loops through the local shards and deletes the series data for
 // the passed in series keys.
 func (s *Store) DeleteSeriesWithPredicate(ctx context.Context, database string, min, max int64, pred influxdb.Predicate, measurement influxql.Expr) error {
+	if err := s.checkWritable("delete series"); err != nil {
+		return err
+	}
+
 	s.mu.RLock()
 	if s.databases[database].hasMultipleIndexTypes() {
 		s.mu.RUnlock()
@@ -1453,6 +1689,10 @@ func (s *Store) DeleteSeriesWithPredicate(ctx context.Context, database string,
 // DeleteSeries loops through the local shards and deletes the series data for
 // the passed in series keys.
 func (s *Store) DeleteSeries(ctx context.Context, database string, sources []influxql.Source, condition influxql.Expr) error {
+	if err := s.checkWritable("delete series"); err != nil {
+		return err
+	}
+
 	// Expand regex expressions in the FROM clause.
 	a, err := s.ExpandSources(sources)
 	if err != nil {
@@ -1565,6 +1805,10 @@ func (s *Store) ExpandSources(sources influxql.Sources) (influxql.Sources, error
 
 // WriteToShard writes a list of points to a shard identified by its ID.
 func (s *Store) WriteToShard(ctx context.Context, shardID uint64, points []models.Point) error {
+	if err := s.checkWritable("write points"); err != nil {
+		return err
+	}
+
 	s.mu.RLock()
 
 	select {
@@ -1634,6 +1878,36 @@ func (s *Store) MeasurementNames(ctx context.Context, auth query.Authorizer, dat
 	return is.MeasurementNamesByExpr(auth, cond)
 }
 
+// MeasurementNamesByPrefix returns the sorted names of the measurements in the
+// database which start with prefix and have at least one series authorized by
+// auth. At most limit names are returned if limit > 0.
+func (s *Store) MeasurementNamesByPrefix(ctx context.Context, auth query.Authorizer, database string, prefix []byte, limit int) ([][]byte, error) {
+	s.mu.RLock()
+	shards := s.filterShards(byDatabase(database))
+	s.mu.RUnlock()
+
+	sfile := s.seriesFile(database)
+	if sfile == nil {
+		return nil, nil
+	}
+
+	// Build indexset.
+	is := IndexSet{Indexes: make([]Index, 0, len(shards)), SeriesFile: sfile}
+	for _, sh := range shards {
+		index, err := sh.Index()
+		if err != nil {
+			return nil, err
+		}
+		is.Indexes = append(is.Indexes, index)
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	return is.MeasurementNamesByPrefix(auth, prefix, limit)
+}
+
 type TagKeys struct {
 	Measurement string
 	Keys        []string
@@ -2033,6 +2307,53 @@ func (s *Store) TagValues(ctx context.Context, auth query.Authorizer, shardIDs [
 	return result, nil
 }
 
+// TagValuesByPrefix returns the sorted values of a tag key of a measurement in
+// the provided shards which start with prefix and belong to at least one series
+// authorized by auth. At most limit values are returned if limit > 0.
+func (s *Store) TagValuesByPrefix(ctx context.Context, auth query.Authorizer, shardIDs []uint64, name, key, prefix []byte, limit int) ([][]byte, error) {
+	if len(shardIDs) == 0 {
+		return nil, nil
+	}
+
+	// Build index set to work on.
+	is := IndexSet{Indexes: make([]Index, 0, len(shardIDs))}
+	s.mu.RLock()
+	for _, sid := range shardIDs {
+		shard, ok := s.shards[sid]
+		if !ok {
+			continue
+		}
+
+		if is.SeriesFile == nil {
+			sfile, err := shard.SeriesFile()
+			if err != nil {
+				s.mu.RUnlock()
+				return nil, err
+			}
+			is.SeriesFile = sfile
+		}
+
+		index, err := shard.Index()
+		if err != nil {
+			s.mu.RUnlock()
+			return nil, err
+		}
+
+		is.Indexes = append(is.Indexes, index)
+	}
+	s.mu.RUnlock()
+
+	if len(is.Indexes) == 0 {
+		return nil, nil
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	return is.TagValuesByPrefix(auth, name, key, prefix, limit)
+}
+
 func makeTagValues(tv tagValues) TagValues {
 	var result TagValues
 	result.Measurement = string(tv.name)
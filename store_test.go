@@ -143,6 +143,155 @@ func TestStore_CreateShard(t *testing.T) {
 	}
 }
 
+// Ensure the series retention rules of a retention policy are persisted, and
+// are not mistaken for a shard when the store is reopened.
+func TestStore_SeriesRetention(t *testing.T) {
+	test := func(t *testing.T, index string) {
+		s := MustOpenStore(t, index)
+		defer s.Close()
+
+		// Rules are not created for retention policies without shards.
+		_, err := s.SeriesRetention("db0", "rp0")
+		require.ErrorIs(t, err, tsdb.ErrRetentionPolicyNotFound)
+		_, err = s.SeriesRetention("db0", "")
+		require.ErrorIs(t, err, tsdb.ErrRetentionPolicyNotFound)
+		_, err = os.Stat(filepath.Join(s.Path(), "db0", "rp0"))
+		require.True(t, os.IsNotExist(err), err)
+
+		require.NoError(t, s.CreateShard(context.Background(), "db0", "rp0", 1, true))
+		rules := []tsdb.SeriesRetentionRule{{Measurement: "debug", Duration: 72 * time.Hour}}
+		retention, err := s.SeriesRetention("db0", "rp0")
+		require.NoError(t, err)
+		require.NoError(t, retention.SetRules(rules))
+
+		// The rules apply to shards created later.
+		require.NoError(t, s.CreateShard(context.Background(), "db0", "rp0", 2, true))
+		other, err := s.SeriesRetention("db0", "rp0")
+		require.NoError(t, err)
+		require.Same(t, retention, other)
+
+		require.NoError(t, s.Reopen(t))
+		require.NotNil(t, s.Shard(1))
+		require.Len(t, s.ShardIDs(), 2)
+
+		retention, err = s.SeriesRetention("db0", "rp0")
+		require.NoError(t, err)
+		require.Equal(t, rules, retention.Rules())
+
+		// Rules are removed with their retention policy, and are not persisted
+		// for it once it is deleted.
+		require.NoError(t, s.DeleteRetentionPolicy("db0", "rp0"))
+		_, err = s.SeriesRetention("db0", "rp0")
+		require.ErrorIs(t, err, tsdb.ErrRetentionPolicyNotFound)
+		require.Error(t, retention.SetRules(rules))
+		_, err = os.Stat(filepath.Join(s.Path(), "db0", "rp0"))
+		require.True(t, os.IsNotExist(err), err)
+	}
+
+	for _, index := range tsdb.RegisteredIndexes() {
+		t.Run(index, func(t *testing.T) { test(t, index) })
+	}
+}
+
+func TestStore_Compactions(t *testing.T) {
+	test := func(t *testing.T, index string) {
+		s := MustOpenStore(t, index)
+		defer s.Close()
+
+		require.NoError(t, s.CreateShard(context.Background(), "db0", "rp0", 1, true))
+
+		statuses, err := s.Compactions(1)
+		require.NoError(t, err)
+		require.Empty(t, statuses)
+		require.ErrorIs(t, s.CancelCompaction(1, 42), tsdb.ErrCompactionNotFound)
+
+		_, err = s.Compactions(2)
+		require.ErrorIs(t, err, tsdb.ErrShardNotFound)
+		require.ErrorIs(t, s.CancelCompaction(2, 42), tsdb.ErrShardNotFound)
+	}
+
+	for _, index := range tsdb.RegisteredIndexes() {
+		t.Run(index, func(t *testing.T) { test(t, index) })
+	}
+}
+
+// Ensure the index of a shard can be verified and rebuilt through the store.
+func TestStore_VerifyIndex(t *testing.T) {
+	test := func(t *testing.T, index string) {
+		s := MustOpenStore(t, index)
+		defer s.Close()
+
+		s.MustCreateShardWithData("db0", "rp0", 1, "cpu,host=a v=1 10", "cpu,host=b v=2 20")
+
+		report, err := s.VerifyIndex(context.Background(), 1)
+		require.NoError(t, err)
+		require.True(t, report.Consistent(), "%+v", report)
+		require.Equal(t, 2, report.SeriesN)
+
+		require.NoError(t, s.RebuildIndex(context.Background(), 1))
+		report, err = s.VerifyIndex(context.Background(), 1)
+		require.NoError(t, err)
+		require.True(t, report.Consistent(), "%+v", report)
+		require.Equal(t, 2, report.SeriesN)
+
+		_, err = s.VerifyIndex(context.Background(), 2)
+		require.ErrorIs(t, err, tsdb.ErrShardNotFound)
+		require.ErrorIs(t, s.RebuildIndex(context.Background(), 2), tsdb.ErrShardNotFound)
+	}
+
+	for _, index := range tsdb.RegisteredIndexes() {
+		t.Run(index, func(t *testing.T) { test(t, index) })
+	}
+}
+
+// Ensure a merge cursor reads a series field key from several shards in time order.
+func TestStore_CreateMergeCursor(t *testing.T) {
+	test := func(t *testing.T, index string) {
+		s := MustOpenStore(t, index)
+		defer s.Close()
+
+		s.MustCreateShardWithData("db0", "rp0", 1, "cpu,host=a v=1 10", "cpu,host=a v=3 30")
+		s.MustCreateShardWithData("db0", "rp0", 2, "cpu,host=a v=2 20", "cpu,host=a v=4 40")
+
+		req := &tsdb.CursorRequest{
+			Name:      []byte("cpu"),
+			Tags:      models.NewTags(map[string]string{"host": "a"}),
+			Field:     "v",
+			Ascending: true,
+			StartTime: models.MinNanoTime,
+			EndTime:   models.MaxNanoTime,
+		}
+		cur, err := s.CreateMergeCursor(context.Background(), []uint64{1, 2}, req, 1)
+		require.NoError(t, err)
+		require.NotNil(t, cur)
+		defer cur.Close()
+
+		var timestamps []int64
+		var values []float64
+		for {
+			a := cur.(tsdb.FloatArrayCursor).Next()
+			if a.Len() == 0 {
+				break
+			}
+			timestamps = append(timestamps, a.Timestamps...)
+			values = append(values, a.Values...)
+		}
+		require.NoError(t, cur.Err())
+		require.Equal(t, []int64{10e9, 20e9, 30e9, 40e9}, timestamps)
+		require.Equal(t, []float64{1, 2, 3, 4}, values)
+
+		// No cursor is returned for a field no shard has.
+		req.Field = "missing"
+		cur, err = s.CreateMergeCursor(context.Background(), []uint64{1, 2}, req, 1)
+		require.NoError(t, err)
+		require.Nil(t, cur)
+	}
+
+	for _, index := range tsdb.RegisteredIndexes() {
+		t.Run(index, func(t *testing.T) { test(t, index) })
+	}
+}
+
 func TestStore_BadShard(t *testing.T) {
 	const errStr = "a shard open error"
 	indexes := tsdb.RegisteredIndexes()
@@ -477,6 +626,54 @@ func TestStore_Open(t *testing.T) {
 	}
 }
 
+// Ensure a read-only store opens its shards and rejects changes.
+func TestStore_ReadOnly(t *testing.T) {
+
+	test := func(t *testing.T, index string) {
+		s := MustOpenStore(t, index)
+		defer s.Close()
+
+		s.MustCreateShardWithData("db0", "rp0", 1,
+			`cpu,host=serverA value=1 0`,
+			`cpu,host=serverB value=2 10`,
+		)
+
+		// Reopen the store read-only.
+		require.NoError(t, s.Store.Close())
+		s.Store = tsdb.NewStore(s.Path())
+		s.EngineOptions.IndexVersion = s.index
+		s.EngineOptions.Config.WALDir = filepath.Join(s.Path(), "wal")
+		s.EngineOptions.ReadOnly = true
+		s.WithLogger(zaptest.NewLogger(t))
+		require.NoError(t, s.Open(context.Background()))
+
+		require.Equal(t, 1, s.ShardN())
+		n, err := s.SeriesCardinality(context.Background(), "db0")
+		require.NoError(t, err)
+		require.Equal(t, int64(2), n)
+
+		points := []models.Point{models.MustNewPoint("cpu", models.NewTags(map[string]string{"host": "serverC"}), map[string]interface{}{"value": 3.0}, time.Unix(20, 0))}
+		err = s.WriteToShard(context.Background(), 1, points)
+		require.True(t, errors.Is(err, tsdb.ErrReadOnly), err)
+		require.True(t, errors.Is(s.Shard(1).WritePoints(context.Background(), points), tsdb.ErrReadOnly))
+		require.True(t, errors.Is(s.CreateShard(context.Background(), "db0", "rp0", 2, true), tsdb.ErrReadOnly))
+		require.True(t, errors.Is(s.DeleteShard(1), tsdb.ErrReadOnly))
+		require.True(t, errors.Is(s.DeleteMeasurement(context.Background(), "db0", "cpu"), tsdb.ErrReadOnly))
+		require.Equal(t, 1, s.ShardN())
+
+		retention, err := s.SeriesRetention("db0", "rp0")
+		require.NoError(t, err)
+		err = retention.SetRules([]tsdb.SeriesRetentionRule{{Measurement: "cpu", Duration: time.Hour}})
+		require.True(t, errors.Is(err, tsdb.ErrReadOnly), err)
+		_, err = os.Stat(filepath.Join(s.Path(), "db0", "rp0", tsdb.SeriesRetentionFileName))
+		require.True(t, os.IsNotExist(err), err)
+	}
+
+	for _, index := range tsdb.RegisteredIndexes() {
+		t.Run(index, func(t *testing.T) { test(t, index) })
+	}
+}
+
 // Ensure the store reports an error when it can't open a database directory.
 func TestStore_Open_InvalidDatabaseFile(t *testing.T) {
 
@@ -1831,6 +2028,94 @@ func TestStore_TagValues_Auth(t *testing.T) {
 	}
 }
 
+func TestStore_PrefixSearch(t *testing.T) {
+	for _, index := range tsdb.RegisteredIndexes() {
+		t.Run(index, func(t *testing.T) {
+			s := MustOpenStore(t, index)
+			defer s.Close()
+
+			s.MustCreateShardWithData("db0", "rp0", 0,
+				`cpu,host=serverA value=1 0`,
+				`cpu,host=serverB value=1 10`,
+				`cpu,host=serverC,secret=foo value=1 20`,
+				`cpu,host=other value=1 30`,
+				`cpu_load,host=serverA value=1 40`,
+				`cpu_secret,secret=foo value=1 50`,
+				`mem,host=serverA value=1 60`,
+			)
+			s.MustCreateShardWithData("db0", "rp0", 1,
+				`cpu,host=serverD value=1 70`,
+				`cpu2,host=serverA value=1 80`,
+			)
+
+			authorizer := &internal.AuthorizerMock{
+				AuthorizeSeriesReadFn: func(database string, measurement []byte, tags models.Tags) bool {
+					return tags.GetString("secret") == ""
+				},
+			}
+
+			for _, tt := range []struct {
+				name     string
+				auth     query.Authorizer
+				prefix   string
+				limit    int
+				expected [][]byte
+			}{
+				{name: "all", prefix: "", expected: slices.StringsToBytes("cpu", "cpu2", "cpu_load", "cpu_secret", "mem")},
+				{name: "prefix", prefix: "cpu", expected: slices.StringsToBytes("cpu", "cpu2", "cpu_load", "cpu_secret")},
+				{name: "limit", prefix: "cpu", limit: 2, expected: slices.StringsToBytes("cpu", "cpu2")},
+				{name: "no match", prefix: "disk", expected: nil},
+				{name: "auth", auth: authorizer, prefix: "cpu_", expected: slices.StringsToBytes("cpu_load")},
+				{name: "auth limit", auth: authorizer, prefix: "cpu", limit: 3, expected: slices.StringsToBytes("cpu", "cpu2", "cpu_load")},
+			} {
+				t.Run("measurements/"+tt.name, func(t *testing.T) {
+					names, err := s.MeasurementNamesByPrefix(context.Background(), tt.auth, "db0", []byte(tt.prefix), tt.limit)
+					require.NoError(t, err)
+					if len(tt.expected) == 0 {
+						require.Empty(t, names)
+					} else {
+						require.Equal(t, tt.expected, names)
+					}
+				})
+			}
+
+			for _, tt := range []struct {
+				name     string
+				auth     query.Authorizer
+				prefix   string
+				limit    int
+				expected [][]byte
+			}{
+				{name: "all", prefix: "", expected: slices.StringsToBytes("other", "serverA", "serverB", "serverC", "serverD")},
+				{name: "prefix", prefix: "server", expected: slices.StringsToBytes("serverA", "serverB", "serverC", "serverD")},
+				{name: "limit", prefix: "server", limit: 3, expected: slices.StringsToBytes("serverA", "serverB", "serverC")},
+				{name: "no match", prefix: "x", expected: nil},
+				{name: "auth", auth: authorizer, prefix: "server", expected: slices.StringsToBytes("serverA", "serverB", "serverD")},
+				{name: "auth limit", auth: authorizer, prefix: "server", limit: 3, expected: slices.StringsToBytes("serverA", "serverB", "serverD")},
+			} {
+				t.Run("tag values/"+tt.name, func(t *testing.T) {
+					values, err := s.TagValuesByPrefix(context.Background(), tt.auth, []uint64{0, 1}, []byte("cpu"), []byte("host"), []byte(tt.prefix), tt.limit)
+					require.NoError(t, err)
+					if len(tt.expected) == 0 {
+						require.Empty(t, values)
+					} else {
+						require.Equal(t, tt.expected, values)
+					}
+				})
+			}
+
+			// Values of deleted series are not authorized.
+			cond, err := influxql.ParseExpr("host = 'serverB'")
+			require.NoError(t, err)
+			require.NoError(t, s.DeleteSeries(context.Background(), "db0", nil, cond))
+
+			values, err := s.TagValuesByPrefix(context.Background(), authorizer, []uint64{0, 1}, []byte("cpu"), []byte("host"), []byte("server"), 2)
+			require.NoError(t, err)
+			require.Equal(t, slices.StringsToBytes("serverA", "serverD"), values)
+		})
+	}
+}
+
 // Helper to create some tag values
 func createTagValues(mname string, kvs map[string][]string) tsdb.TagValues {
 	var sz int